@@ -0,0 +1,420 @@
+package bboltkv
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// RawValue is returned by GetAny for values it cannot decode without
+// knowing their Go type.
+type RawValue struct {
+	// TypeName is the name of the stored type as recorded in the gob
+	// stream, such as "Person" or "[]Person". It is empty if the value was
+	// not stored by gob.
+	TypeName string
+
+	// Bytes holds the value as stored.
+	Bytes []byte
+
+	// Err tells why the value could not be decoded.
+	Err error
+}
+
+func (r RawValue) String() string {
+	if r.TypeName == "" {
+		return fmt.Sprintf("<%d bytes>", len(r.Bytes))
+	}
+	return fmt.Sprintf("<%s, %d bytes>", r.TypeName, len(r.Bytes))
+}
+
+// GetAny decodes the entry with the given key without knowing its Go type,
+// for tools that only need to look at the data. Gob encodes the layout of
+// every type it stores, so GetAny rebuilds the value from that: structs
+// come back as map[string]interface{} keyed by field name, slices and
+// arrays as []interface{}, maps as map[string]interface{} if their keys are
+// strings and map[interface{}]interface{} otherwise, and numbers as int64,
+// uint64, float64 or complex128. Interface-typed fields hold their
+// concrete value, which must have been registered with gob.Register, as it
+// would have to be for Get.
+//
+// Values that cannot be rebuilt this way, such as types with their own
+// GobEncode, MarshalBinary or MarshalText methods, recursive types, and
+// interface fields of unregistered types, come back as a RawValue holding
+// the stored bytes and, where known, the name of their type. If the key is
+// not present in the store, GetAny returns ErrNotFound.
+//
+//	v, err := store.GetAny("user:42")
+//	fmt.Printf("%v\n", v) // map[Age:42 Name:Ann]
+func (s *Store) GetAny(key string) (interface{}, error) {
+	raw, err := s.load(s.sealKey(key))
+	if err != nil {
+		return nil, err
+	}
+	return s.decodeUntyped(raw)
+}
+
+// decodeUntyped decodes a value as stored for GetAny.
+func (s *Store) decodeUntyped(raw []byte) (interface{}, error) {
+	raw, err := s.pipeline.untransform(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > 0 && isTag(raw[0]) {
+		if raw[0] == tagList {
+			return nil, errList
+		}
+		return RawValue{Bytes: raw, Err: errors.New("bboltkv: value was not stored by gob")}, nil
+	}
+	v, name, err := decodeAny(raw)
+	if err != nil {
+		return RawValue{TypeName: name, Bytes: raw, Err: err}, nil
+	}
+	return v, nil
+}
+
+// decodeAny decodes a gob stream into a value built from the type
+// definitions it contains. It also returns the name of the stream's type,
+// as far as it could be determined.
+func decodeAny(raw []byte) (interface{}, string, error) {
+	types, id, _, err := readGobTypes(raw)
+	if err != nil {
+		return nil, "", err
+	}
+	name := types.name(id, nil)
+	t, err := types.build(id, nil)
+	if err != nil {
+		return nil, name, err
+	}
+	v := reflect.New(t)
+	if err := gob.NewDecoder(bytes.NewReader(raw)).DecodeValue(v); err != nil {
+		return nil, name, err
+	}
+	return plain(v.Elem()), name, nil
+}
+
+// plain converts a value of a type made by gobTypes.build into maps,
+// slices and basic values.
+func plain(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Struct:
+		m := make(map[string]interface{}, v.NumField())
+		for i := 0; i < v.NumField(); i++ {
+			m[v.Type().Field(i).Name] = plain(v.Field(i))
+		}
+		return m
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Interface()
+		}
+		fallthrough
+	case reflect.Array:
+		l := make([]interface{}, v.Len())
+		for i := range l {
+			l[i] = plain(v.Index(i))
+		}
+		return l
+	case reflect.Map:
+		if v.Type().Key().Kind() == reflect.String {
+			m := make(map[string]interface{}, v.Len())
+			for _, k := range v.MapKeys() {
+				m[k.String()] = plain(v.MapIndex(k))
+			}
+			return m
+		}
+		m := make(map[interface{}]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			m[k.Interface()] = plain(v.MapIndex(k))
+		}
+		return m
+	case reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return v.Elem().Interface()
+	}
+	return v.Interface()
+}
+
+// gob's predefined type ids.
+const (
+	gobBool      = 1
+	gobInt       = 2
+	gobUint      = 3
+	gobFloat     = 4
+	gobBytes     = 5
+	gobString    = 6
+	gobComplex   = 7
+	gobInterface = 8
+)
+
+var gobBasic = map[int64]reflect.Type{
+	gobBool:      reflect.TypeOf(false),
+	gobInt:       reflect.TypeOf(int64(0)),
+	gobUint:      reflect.TypeOf(uint64(0)),
+	gobFloat:     reflect.TypeOf(float64(0)),
+	gobBytes:     reflect.TypeOf([]byte(nil)),
+	gobString:    reflect.TypeOf(""),
+	gobComplex:   reflect.TypeOf(complex128(0)),
+	gobInterface: reflect.TypeOf((*interface{})(nil)).Elem(),
+}
+
+// gobType is a type definition read from a gob stream, following gob's
+// wireType. kind is the index of the wireType field that was set.
+type gobType struct {
+	kind   int
+	name   string
+	elem   int64 // arrays, slices and maps
+	key    int64 // maps
+	length int64 // arrays
+	fields []gobField
+}
+
+type gobField struct {
+	name string
+	id   int64
+}
+
+const (
+	gobArrayT = iota
+	gobSliceT
+	gobStructT
+	gobMapT
+	gobGobEncoderT
+	gobBinaryMarshalerT
+	gobTextMarshalerT
+)
+
+type gobTypes map[int64]*gobType
+
+func (types gobTypes) name(id int64, seen map[int64]bool) string {
+	if t, ok := gobBasic[id]; ok {
+		return t.String()
+	}
+	def := types[id]
+	if def == nil || seen[id] {
+		return ""
+	} else if def.name != "" {
+		return def.name
+	}
+	if seen == nil {
+		seen = make(map[int64]bool)
+	}
+	seen[id] = true
+	defer delete(seen, id)
+	switch def.kind {
+	case gobArrayT:
+		return "[" + strconv.FormatInt(def.length, 10) + "]" + types.name(def.elem, seen)
+	case gobSliceT:
+		return "[]" + types.name(def.elem, seen)
+	case gobMapT:
+		return "map[" + types.name(def.key, seen) + "]" + types.name(def.elem, seen)
+	}
+	return ""
+}
+
+// build returns a Go type that gob can decode values of the given type id
+// into.
+func (types gobTypes) build(id int64, seen map[int64]bool) (reflect.Type, error) {
+	if t, ok := gobBasic[id]; ok {
+		return t, nil
+	}
+	def := types[id]
+	if def == nil {
+		return nil, fmt.Errorf("bboltkv: gob type %d is not defined", id)
+	} else if seen[id] {
+		return nil, fmt.Errorf("bboltkv: gob type %s is recursive", types.name(id, nil))
+	}
+	if seen == nil {
+		seen = make(map[int64]bool)
+	}
+	seen[id] = true
+	defer delete(seen, id)
+	switch def.kind {
+	case gobArrayT, gobSliceT:
+		elem, err := types.build(def.elem, seen)
+		if err != nil {
+			return nil, err
+		} else if def.kind == gobSliceT {
+			return reflect.SliceOf(elem), nil
+		}
+		return reflect.ArrayOf(int(def.length), elem), nil
+	case gobMapT:
+		key, err := types.build(def.key, seen)
+		if err != nil {
+			return nil, err
+		}
+		elem, err := types.build(def.elem, seen)
+		if err != nil {
+			return nil, err
+		}
+		if !key.Comparable() {
+			return nil, fmt.Errorf("bboltkv: gob type %s has keys that cannot be compared", types.name(id, nil))
+		}
+		return reflect.MapOf(key, elem), nil
+	case gobStructT:
+		fields := make([]reflect.StructField, len(def.fields))
+		for i, f := range def.fields {
+			t, err := types.build(f.id, seen)
+			if err != nil {
+				return nil, err
+			}
+			fields[i] = reflect.StructField{Name: f.name, Type: t}
+		}
+		return reflect.StructOf(fields), nil
+	}
+	return nil, fmt.Errorf("bboltkv: gob type %s has its own encoding", types.name(id, nil))
+}
+
+// readGobTypes reads the type definitions at the start of a gob stream
+// holding a single value, and returns them with the id of the value's type
+// and the encoded value.
+func readGobTypes(raw []byte) (gobTypes, int64, []byte, error) {
+	types := make(gobTypes)
+	r := &gobReader{buf: raw}
+	for r.err == nil {
+		n := int(r.uint())
+		if r.err == nil && n > len(r.buf) {
+			r.err = errors.New("bboltkv: truncated gob stream")
+		}
+		if r.err != nil {
+			break
+		}
+		msg := &gobReader{buf: r.buf[:n]}
+		r.buf = r.buf[n:]
+		id := msg.int()
+		if id >= 0 {
+			return types, id, msg.buf, msg.err
+		}
+		def := msg.wireType()
+		if msg.err != nil {
+			return nil, 0, nil, msg.err
+		}
+		types[-id] = def
+	}
+	return nil, 0, nil, r.err
+}
+
+// gobReader reads the parts of gob's encoding needed for type
+// definitions. After an error, all reads return zero values.
+type gobReader struct {
+	buf []byte
+	err error
+}
+
+var errGobFormat = errors.New("bboltkv: malformed gob stream")
+
+func (r *gobReader) uint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.buf) == 0 {
+		r.err = errGobFormat
+		return 0
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	if b < 0x80 {
+		return uint64(b)
+	}
+	n := int(-int8(b))
+	if n > 8 || n > len(r.buf) {
+		r.err = errGobFormat
+		return 0
+	}
+	var x uint64
+	for _, c := range r.buf[:n] {
+		x = x<<8 | uint64(c)
+	}
+	r.buf = r.buf[n:]
+	return x
+}
+
+func (r *gobReader) int() int64 {
+	u := r.uint()
+	if u&1 != 0 {
+		return ^int64(u >> 1)
+	}
+	return int64(u >> 1)
+}
+
+func (r *gobReader) string() string {
+	n := r.uint()
+	if r.err == nil && n > uint64(len(r.buf)) {
+		r.err = errGobFormat
+	}
+	if r.err != nil {
+		return ""
+	}
+	s := string(r.buf[:n])
+	r.buf = r.buf[n:]
+	return s
+}
+
+// fields reads a struct, calling field with the number of each field
+// present, which must read the field's value.
+func (r *gobReader) fields(field func(n int)) {
+	n := -1
+	for r.err == nil {
+		delta := r.uint()
+		if delta == 0 {
+			return
+		}
+		n += int(delta)
+		field(n)
+	}
+}
+
+func (r *gobReader) wireType() *gobType {
+	def := &gobType{kind: -1}
+	r.fields(func(kind int) {
+		if kind > gobTextMarshalerT {
+			r.err = errGobFormat
+			return
+		}
+		def.kind = kind
+		// All wireType fields point to structs starting with CommonType.
+		r.fields(func(n int) {
+			switch {
+			case n == 0:
+				r.fields(func(n int) {
+					if n == 0 {
+						def.name = r.string()
+					} else {
+						r.int()
+					}
+				})
+			case kind == gobStructT && n == 1:
+				for i := r.uint(); i > 0 && r.err == nil; i-- {
+					var f gobField
+					r.fields(func(n int) {
+						if n == 0 {
+							f.name = r.string()
+						} else {
+							f.id = r.int()
+						}
+					})
+					def.fields = append(def.fields, f)
+				}
+			case kind == gobMapT && n == 1:
+				def.key = r.int()
+			case n == 1:
+				def.elem = r.int()
+			default:
+				// array length, or map element
+				if kind == gobMapT {
+					def.elem = r.int()
+				} else {
+					def.length = r.int()
+				}
+			}
+		})
+	})
+	if r.err == nil && def.kind < 0 {
+		r.err = errGobFormat
+	}
+	return def
+}
@@ -0,0 +1,129 @@
+package bboltkv
+
+import (
+	"encoding/gob"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type anyAddress struct {
+	City string
+}
+
+type anyPerson struct {
+	Name    string
+	Age     int
+	Tags    []string
+	Address *anyAddress
+	Scores  map[string]float64
+	Extra   interface{}
+}
+
+type anyRegistered struct {
+	ID int
+}
+
+type anyNode struct {
+	Value int
+	Next  *anyNode
+}
+
+func init() {
+	gob.Register(anyRegistered{})
+}
+
+func TestGetAny(t *testing.T) {
+	db := openTestStore(t)
+	for _, c := range []struct {
+		value interface{}
+		want  interface{}
+	}{
+		{42, int64(42)},
+		{uint8(7), uint64(7)},
+		{"text", "text"},
+		{true, true},
+		{2.5, 2.5},
+		{[]byte("raw"), []byte("raw")},
+		{[]int{1, 2}, []interface{}{int64(1), int64(2)}},
+		{[2]string{"a", "b"}, []interface{}{"a", "b"}},
+		{map[int]bool{1: true}, map[interface{}]interface{}{int64(1): true}},
+		{
+			anyPerson{
+				Name:    "Ann",
+				Age:     42,
+				Tags:    []string{"admin"},
+				Address: &anyAddress{City: "Oslo"},
+				Scores:  map[string]float64{"go": 1},
+				Extra:   anyRegistered{ID: 7},
+			},
+			map[string]interface{}{
+				"Name":    "Ann",
+				"Age":     int64(42),
+				"Tags":    []interface{}{"admin"},
+				"Address": map[string]interface{}{"City": "Oslo"},
+				"Scores":  map[string]interface{}{"go": 1.0},
+				"Extra":   anyRegistered{ID: 7},
+			},
+		},
+		{
+			[]anyAddress{{City: "Oslo"}},
+			[]interface{}{map[string]interface{}{"City": "Oslo"}},
+		},
+	} {
+		if err := db.Put("key", c.value); err != nil {
+			t.Fatal(err)
+		}
+		got, err := db.GetAny("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("%T: got %#v, expected %#v", c.value, got, c.want)
+		}
+	}
+	if _, err := db.GetAny("missing"); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+}
+
+func TestGetAnyRaw(t *testing.T) {
+	db := openTestStore(t)
+	for _, c := range []struct {
+		value    interface{}
+		typeName string
+	}{
+		{time.Now(), "Time"},
+		{anyNode{Value: 1, Next: &anyNode{Value: 2}}, "anyNode"},
+		{[]time.Time{time.Now()}, "[]Time"},
+		{map[string]anyNode{}, "map[string]anyNode"},
+	} {
+		if err := db.Put("key", c.value); err != nil {
+			t.Fatal(err)
+		}
+		got, err := db.GetAny("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, ok := got.(RawValue)
+		if !ok {
+			t.Fatalf("%T: got %#v, expected a RawValue", c.value, got)
+		}
+		if raw.TypeName != c.typeName || raw.Err == nil {
+			t.Fatalf("%T: got type %q, error %v; expected %q", c.value, raw.TypeName, raw.Err, c.typeName)
+		}
+		// the bytes are the stored value
+		if err := db.PutEncoded("copy", raw.Bytes); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db = openTestStore(t, WithMarshalerPreference())
+	if err := db.Put("key", testUUID{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.GetAny("key")
+	if raw, ok := got.(RawValue); err != nil || !ok || raw.TypeName != "" {
+		t.Fatalf("got %#v, %v, expected an untyped RawValue", got, err)
+	}
+}
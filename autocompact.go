@@ -0,0 +1,312 @@
+package bboltkv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Logger receives the messages of a store about its housekeeping, see
+// WithLogger. *log.Logger implements it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// WithLogger makes the store report its housekeeping to l, such as the
+// compactions of WithAutoCompact. Without a logger, nothing is reported.
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		o.logger = l
+	}
+}
+
+// logf reports a message to the logger of o, if it has one.
+func (o *options) logf(format string, v ...interface{}) {
+	if o.logger != nil {
+		o.logger.Printf(format, v...)
+	}
+}
+
+// WithAutoCompact makes Open compact the database file before it returns,
+// if its free pages make up more than threshold of it, between 0 and 1.
+// bbolt never shrinks a file, and reuses its freed pages only so well, so a
+// store that once held much more data than it does now keeps its size
+// otherwise. Compacting copies the data into a new file, which takes time
+// in proportion to the data; see WithAutoCompactBudget to bound it.
+//
+// The data is copied into a file next to the database file, with the
+// suffix ".compact", which is synced, checked to hold all of the data, and
+// then renamed into place, so a crash at any point leaves either the old
+// file or the new one. A compaction that fails leaves the old file in use,
+// and is reported to the logger of WithLogger, as are those that succeed;
+// Open itself goes on. The leftover file of a compaction cut short is
+// removed by the next Open with the option. Read-only stores are never
+// compacted. With OpenShared, the file is compacted when the first store
+// opens it. SelfStats reports when the store's file was compacted.
+//
+//	store, err := bboltkv.Open(path, "bucket", bboltkv.WithAutoCompact(0.5), bboltkv.WithLogger(log.Default()))
+func WithAutoCompact(threshold float64) Option {
+	return func(o *options) {
+		o.autoCompact = threshold
+	}
+}
+
+// WithAutoCompactBudget bounds the time the compaction of WithAutoCompact
+// takes at Open: once it has taken longer than d, it gives up, leaving the
+// old file in use, and reports so to the logger of WithLogger. Without it,
+// the compaction runs to completion.
+func WithAutoCompactBudget(d time.Duration) Option {
+	return func(o *options) {
+		o.autoCompactBudget = d
+	}
+}
+
+// errCompactBudget stops a compaction that took longer than its budget.
+var errCompactBudget = errors.New("bboltkv: compaction ran out of time")
+
+// compactTxSize is the amount of data copied in each transaction of a
+// compaction, so that large files do not need a single huge one.
+const compactTxSize = 16 << 20
+
+// autoCompact compacts the database file at path, if it is fragmented
+// enough, see WithAutoCompact, and returns when it did, or the zero time if
+// it did not. It only fails if the file cannot be opened; failed
+// compactions are logged.
+func autoCompact(path string, o options) (time.Time, error) {
+	tmp := path + ".compact"
+	// a compaction cut short leaves its file behind
+	os.Remove(tmp)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	src, err := openDB(path, o)
+	if err != nil {
+		return time.Time{}, err
+	}
+	frag, size, err := fragmentation(src)
+	if err != nil || frag <= o.autoCompact {
+		src.Close()
+		return time.Time{}, err
+	}
+	start := time.Now()
+	err = compactFile(src, tmp, path, o)
+	if cerr := src.Close(); err == nil && cerr != nil {
+		err = cerr
+	}
+	switch {
+	case err == errCompactBudget:
+		os.Remove(tmp)
+		o.logf("bboltkv: skipped compacting %s, %.0f%% free: took longer than %v", path, frag*100, o.autoCompactBudget)
+	case err != nil:
+		os.Remove(tmp)
+		o.logf("bboltkv: compacting %s failed: %v", path, err)
+	default:
+		var now int64
+		if fi, err := os.Stat(path); err == nil {
+			now = fi.Size()
+		}
+		o.logf("bboltkv: compacted %s, %.0f%% free, from %d to %d bytes in %v", path, frag*100, size, now, time.Since(start))
+		return o.now(), nil
+	}
+	return time.Time{}, nil
+}
+
+// fragmentation returns the share of the pages of db that are free, and
+// the size of its data, in bytes.
+func fragmentation(db *bbolt.DB) (float64, int64, error) {
+	var size int64
+	var pageSize int
+	if err := db.View(func(tx *bbolt.Tx) error {
+		size = tx.Size()
+		pageSize = db.Info().PageSize
+		return nil
+	}); err != nil {
+		return 0, 0, err
+	}
+	st := db.Stats()
+	free := int64(st.FreePageN+st.PendingPageN) * int64(pageSize)
+	return float64(free) / float64(size), size, nil
+}
+
+// compactFile copies the data of src into a new file at tmp, checks it, and
+// renames it to path, replacing the file of src, which the caller closes.
+func compactFile(src *bbolt.DB, tmp, path string, o options) error {
+	dst, err := bbolt.Open(tmp, 0640, rewriteOptions(src))
+	if err != nil {
+		return err
+	}
+	c := &compaction{dst: dst}
+	if o.autoCompactBudget > 0 {
+		c.deadline = time.Now().Add(o.autoCompactBudget)
+	}
+	err = compactStage(o, "compacting")
+	if err == nil {
+		err = c.copy(src)
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = compactStage(o, "compacted")
+	}
+	if err == nil {
+		err = verifyCompacted(tmp, c.entries)
+	}
+	if err == nil {
+		err = compactStage(o, "verified")
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	if err := compactStage(o, "renamed"); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// compactStage runs the test hook for the given stage of a compaction.
+func compactStage(o options, name string) error {
+	if o.compactHook != nil {
+		return o.compactHook(name)
+	}
+	return nil
+}
+
+// compaction copies the buckets of a database into dst, like bbolt.Compact,
+// committing every compactTxSize bytes, and giving up once past deadline.
+type compaction struct {
+	dst      *bbolt.DB
+	tx       *bbolt.Tx
+	size     int64
+	entries  int // keys and buckets copied
+	deadline time.Time
+}
+
+func (c *compaction) copy(src *bbolt.DB) error {
+	var err error
+	if c.tx, err = c.dst.Begin(true); err != nil {
+		return err
+	}
+	defer func() {
+		if c.tx != nil {
+			c.tx.Rollback()
+		}
+	}()
+	err = src.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			return c.copyBucket(b, nil, name)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return c.tx.Commit()
+}
+
+// copyBucket copies the bucket b, named name within the bucket at path.
+func (c *compaction) copyBucket(b *bbolt.Bucket, path [][]byte, name []byte) error {
+	if err := c.step(len(name)); err != nil {
+		return err
+	}
+	var into *bbolt.Bucket
+	var err error
+	if parent := c.bucket(path); parent == nil {
+		into, err = c.tx.CreateBucket(name)
+	} else {
+		into, err = parent.CreateBucket(name)
+	}
+	if err == nil {
+		err = into.SetSequence(b.Sequence())
+	}
+	if err != nil {
+		return err
+	}
+	path = append(path[:len(path):len(path)], name)
+	return b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return c.copyBucket(b.Bucket(k), path, k)
+		}
+		if err := c.step(len(k) + len(v)); err != nil {
+			return err
+		}
+		// step may have begun a new transaction
+		return c.bucket(path).Put(k, v)
+	})
+}
+
+// step accounts for n bytes about to be copied, committing the transaction
+// so far if it has grown large enough, and checks the deadline.
+func (c *compaction) step(n int) error {
+	if c.entries++; c.entries%1000 == 1 && !c.deadline.IsZero() && time.Now().After(c.deadline) {
+		return errCompactBudget
+	}
+	if c.size += int64(n); c.size < compactTxSize {
+		return nil
+	}
+	var err error
+	if err = c.tx.Commit(); err == nil {
+		c.tx, err = c.dst.Begin(true)
+	}
+	c.size = int64(n)
+	return err
+}
+
+// bucket returns the bucket at path in the transaction under way, or nil
+// for the root.
+func (c *compaction) bucket(path [][]byte) *bbolt.Bucket {
+	if len(path) == 0 {
+		return nil
+	}
+	b := c.tx.Bucket(path[0])
+	for _, name := range path[1:] {
+		b = b.Bucket(name)
+	}
+	return b
+}
+
+// verifyCompacted checks that the compacted file at path is consistent and
+// holds as many keys and buckets as were copied into it.
+func verifyCompacted(path string, entries int) error {
+	db, err := bbolt.Open(path, 0640, &bbolt.Options{Timeout: 50 * time.Millisecond, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(func(tx *bbolt.Tx) error {
+		var bad error
+		for err := range tx.Check() {
+			if bad == nil {
+				bad = fmt.Errorf("%w: compacted file: %v", ErrCorrupt, err)
+			}
+		}
+		if bad != nil {
+			return bad
+		}
+		n := 0
+		var count func(b *bbolt.Bucket) error
+		count = func(b *bbolt.Bucket) error {
+			n++
+			return b.ForEach(func(k, v []byte) error {
+				if v == nil {
+					return count(b.Bucket(k))
+				}
+				n++
+				return nil
+			})
+		}
+		if err := tx.ForEach(func(_ []byte, b *bbolt.Bucket) error { return count(b) }); err != nil {
+			return err
+		}
+		if n != entries {
+			return fmt.Errorf("%w: compacted file holds %d entries, %d were copied", ErrCorrupt, n, entries)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,221 @@
+package bboltkv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unknownnf/bboltkv/testutil"
+)
+
+// logLines is a Logger keeping the messages it is given.
+type logLines []string
+
+func (l *logLines) Printf(format string, v ...interface{}) {
+	*l = append(*l, fmt.Sprintf(format, v...))
+}
+
+func (l *logLines) has(s string) bool {
+	for _, line := range *l {
+		if strings.Contains(line, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// fragmentedFile writes a store at dir/test.db that has held a lot more data
+// than it does now, and returns its path and the values it holds.
+func fragmentedFile(t *testing.T, dir string) (string, map[string]int) {
+	t.Helper()
+	path := filepath.Join(dir, "test.db")
+	db, err := Open(path, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	pad := make([]byte, 1<<10)
+	batch := map[string]interface{}{}
+	for i := 0; i < 2000; i++ {
+		batch[fmt.Sprintf("pad:%d", i)] = pad
+	}
+	if err := db.PutAll(batch); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{}
+	for i := 0; i < 20; i++ {
+		if err := db.Put(keyN(i), i); err != nil {
+			t.Fatal(err)
+		}
+		want[keyN(i)] = i
+	}
+	if _, err := db.DeletePrefix("pad:"); err != nil {
+		t.Fatal(err)
+	}
+	return path, want
+}
+
+func fileOf(t *testing.T, path string) os.FileInfo {
+	t.Helper()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fi
+}
+
+func TestAutoCompact(t *testing.T) {
+	path, want := fragmentedFile(t, t.TempDir())
+	before := fileOf(t, path)
+	var log logLines
+	clock := testutil.NewFakeClock(time.Now())
+	db, err := Open(path, "test", WithAutoCompact(0.5), WithLogger(&log), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := fileOf(t, path)
+	if os.SameFile(before, after) || after.Size() >= before.Size() {
+		t.Fatalf("file went from %d to %d bytes", before.Size(), after.Size())
+	}
+	if !log.has("compacted") {
+		t.Fatalf("logged %q", log)
+	}
+	if stats, err := db.selfStats(); err != nil || !stats.LastCompaction.Equal(clock.Now()) {
+		t.Fatalf("got %+v, %v, expected the compaction", stats, err)
+	}
+	checkValues(t, db, want)
+	// the store's bookkeeping carries over
+	if n, err := db.PutSeq("seq", 1); err != nil || n != 1 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	db.Close()
+
+	db, err = Open(path, "test", WithAutoCompact(0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if !os.SameFile(after, fileOf(t, path)) {
+		t.Fatal("compacted file compacted again")
+	}
+	if stats, err := db.selfStats(); err != nil || !stats.LastCompaction.IsZero() {
+		t.Fatalf("got %+v, %v, expected no compaction", stats, err)
+	}
+	want["seq"] = 1
+	checkValues(t, db, want)
+}
+
+func TestAutoCompactBelowThreshold(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{}
+	for i := 0; i < 2000; i++ {
+		if err := db.Put(keyN(i), i); err != nil {
+			t.Fatal(err)
+		}
+		want[keyN(i)] = i
+	}
+	db.Close()
+	before := fileOf(t, path)
+	var log logLines
+	db, err = Open(path, "test", WithAutoCompact(0.5), WithLogger(&log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if !os.SameFile(before, fileOf(t, path)) || len(log) != 0 {
+		t.Fatalf("file compacted, logged %q", log)
+	}
+	checkValues(t, db, want)
+}
+
+func TestAutoCompactBudget(t *testing.T) {
+	path, want := fragmentedFile(t, t.TempDir())
+	before := fileOf(t, path)
+	var log logLines
+	db, err := Open(path, "test", WithAutoCompact(0.5), WithAutoCompactBudget(time.Nanosecond), WithLogger(&log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if !os.SameFile(before, fileOf(t, path)) || !log.has("skipped") {
+		t.Fatalf("logged %q", log)
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Fatal("compacted file left behind")
+	}
+	checkValues(t, db, want)
+}
+
+func TestAutoCompactShared(t *testing.T) {
+	path, want := fragmentedFile(t, t.TempDir())
+	before := fileOf(t, path)
+	var log logLines
+	a, err := OpenShared(path, "test", WithAutoCompact(0.5), WithLogger(&log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	after := fileOf(t, path)
+	if os.SameFile(before, after) || !log.has("compacted") {
+		t.Fatalf("file not compacted, logged %q", log)
+	}
+	checkValues(t, a, want)
+
+	// the file is already open for the next store
+	b, err := OpenShared(path, "other", WithAutoCompact(0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if !os.SameFile(after, fileOf(t, path)) || a.GetDb() != b.GetDb() {
+		t.Fatal("shared file compacted again")
+	}
+	if stats, err := b.selfStats(); err != nil || !stats.LastCompaction.IsZero() {
+		t.Fatalf("got %+v, %v, expected no compaction", stats, err)
+	}
+}
+
+func TestAutoCompactCrash(t *testing.T) {
+	dir := t.TempDir()
+	path, want := fragmentedFile(t, dir)
+	crashed := map[string]string{}
+	hook := func(o *options) {
+		o.compactHook = func(stage string) error {
+			crashed[stage] = copyFiles(t, dir)
+			return nil
+		}
+	}
+	db, err := Open(path, "test", WithAutoCompact(0.5), hook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	for _, stage := range []string{"compacting", "compacted", "verified", "renamed"} {
+		if crashed[stage] == "" {
+			t.Fatalf("no stage %s", stage)
+		}
+		path := filepath.Join(crashed[stage], "test.db")
+		var log logLines
+		db, err := Open(path, "test", WithAutoCompact(0.5), WithLogger(&log))
+		if err != nil {
+			t.Fatalf("%s: %v", stage, err)
+		}
+		checkValues(t, db, want)
+		db.Close()
+		// the copies made before the rename are compacted on the next try
+		if compacted := log.has("compacted"); compacted != (stage != "renamed") {
+			t.Errorf("%s: logged %q", stage, log)
+		}
+		if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+			t.Errorf("%s: compacted file left behind", stage)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package bboltkv
+
+import (
+	"context"
+	"runtime"
+	"sort"
+	"sync"
+
+	"go.etcd.io/bbolt"
+)
+
+// RawEntries maps keys to values previously returned by Encode. See
+// PutAllEncoded.
+type RawEntries map[string][]byte
+
+// PutAll stores all the given entries in a single transaction: either every
+// entry is written, or none is. Values are encoded and validated as with
+// Put, and none of them can be nil - if one is, PutAll returns ErrBadValue
+// without writing anything. Likewise, if a validator rejects any entry,
+// nothing is written.
+//
+//	err := store.PutAll(map[string]interface{}{
+//	    "harry": 1,
+//	    "emma":  "two",
+//	})
+func (s *Store) PutAll(entries map[string]interface{}) error {
+	return s.PutAllContext(context.Background(), entries)
+}
+
+// PutAllContext is PutAll, bounded by ctx like PutContext.
+func (s *Store) PutAllContext(ctx context.Context, entries map[string]interface{}) error {
+	raw := make(RawEntries, len(entries))
+	for k, v := range entries {
+		encoded, err := s.encodeForPut(k, v)
+		if err != nil {
+			return err
+		}
+		raw[k] = encoded
+	}
+	return s.putAllContext(ctx, raw)
+}
+
+// PutAllEncoded stores all the given pre-encoded entries in a single
+// transaction, like PutAll. None of the values can be empty - if one is,
+// PutAllEncoded returns ErrBadValue without writing anything.
+func (s *Store) PutAllEncoded(entries RawEntries) error {
+	for k, v := range entries {
+		if err := s.validateEncoded(k, v); err != nil {
+			return err
+		}
+	}
+	return s.putAll(entries)
+}
+
+func (s *Store) putAll(entries RawEntries) error {
+	return s.putAllContext(context.Background(), entries)
+}
+
+func (s *Store) putAllContext(ctx context.Context, entries RawEntries) error {
+	// bbolt is fastest when keys are inserted in order.
+	keys := make([]string, 0, len(entries))
+	stored := entries
+	if s.keys != nil {
+		stored = make(RawEntries, len(entries))
+		for k, raw := range entries {
+			stored[s.sealKey(k)] = raw
+		}
+	}
+	for k := range stored {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return s.updateContext(ctx, s.named("PutAll", "", s.withMeta(ctx, func(w *wtx) error {
+		for _, k := range keys {
+			if err := w.put(k, stored[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})))
+}
+
+// GetMulti reads the entries with the given keys in a single transaction.
+// For each key, it calls newValue for a pointer to decode the entry into,
+// and then fn with the key and the decoded value, or with the error of
+// decoding it; for keys not present in the store, that is ErrNotFound and
+// v is nil. fn is called once per key, in the order of keys. GetMulti
+// itself only fails if the store cannot be read.
+//
+//	err := store.GetMulti(ids, func() interface{} { return new(User) },
+//	    func(key string, v interface{}, err error) {
+//	        if err == nil {
+//	            users = append(users, v.(*User))
+//	        }
+//	    })
+func (s *Store) GetMulti(keys []string, newValue func() interface{}, fn func(key string, v interface{}, err error)) error {
+	raws, err := s.getRaws(keys)
+	if err != nil {
+		return err
+	}
+	for i, key := range keys {
+		v, err := s.decodeNew(raws[i], newValue)
+		fn(key, v, err)
+	}
+	return nil
+}
+
+// GetMultiParallel works like GetMulti, but decodes the values on the
+// given number of goroutines, or on as many as there are CPUs if workers is
+// not positive. This pays off for many keys, or values that are costly to
+// decode. The values are still read in a single transaction, before
+// decoding starts.
+//
+// fn is called once per key, in no particular order. Calls may come from
+// different goroutines, but never overlap.
+func (s *Store) GetMultiParallel(keys []string, newValue func() interface{}, workers int, fn func(key string, v interface{}, err error)) error {
+	raws, err := s.getRaws(keys)
+	if err != nil {
+		return err
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(keys) {
+		workers = len(keys)
+	}
+	next := make(chan int)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				v, err := s.decodeNew(raws[i], newValue)
+				mu.Lock()
+				fn(keys[i], v, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range keys {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return nil
+}
+
+// getRaws returns copies of the encoded values stored under keys, nil for
+// keys that are not present.
+func (s *Store) getRaws(keys []string) ([][]byte, error) {
+	raws := make([][]byte, len(keys))
+	err := s.view(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucketName)
+		for i, key := range keys {
+			key = s.sealKey(key)
+			if v := b.Get([]byte(key)); v != nil && !s.expired(tx, key) {
+				v, err := s.assemble(tx, []byte(key), v)
+				if err != nil {
+					return err
+				}
+				raws[i] = append([]byte(nil), v...)
+			}
+		}
+		return nil
+	})
+	return raws, err
+}
+
+// decodeNew decodes raw into a value from newValue.
+func (s *Store) decodeNew(raw []byte, newValue func() interface{}) (interface{}, error) {
+	if raw == nil {
+		return nil, ErrNotFound
+	}
+	v := newValue()
+	if err := s.decode(raw, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,167 @@
+package bboltkv
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPutAll(t *testing.T) {
+	db := openTestStore(t)
+	entries := make(map[string]interface{})
+	for i := 0; i < 100; i++ {
+		entries[fmt.Sprintf("key%d", i)] = i
+	}
+	if err := db.PutAll(entries); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		var val int
+		if err := db.Get(fmt.Sprintf("key%d", i), &val); err != nil {
+			t.Fatal(err)
+		} else if val != i {
+			t.Fatalf("got %d, expected %d", val, i)
+		}
+	}
+	// a nil value rejects the whole batch
+	if err := db.PutAll(map[string]interface{}{"a": 1, "b": nil}); err != ErrBadValue {
+		t.Fatalf("got %v, expected ErrBadValue", err)
+	}
+	if err := db.Get("a", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+}
+
+func TestPutAllEncoded(t *testing.T) {
+	db := openTestStore(t)
+	raw, err := db.Encode("value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutAll(map[string]interface{}{"plain": "value"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutAllEncoded(RawEntries{"a": raw, "b": raw}); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"plain", "a", "b"} {
+		var val string
+		if err := db.Get(k, &val); err != nil {
+			t.Fatal(err)
+		} else if val != "value" {
+			t.Fatalf("got \"%s\", expected \"value\"", val)
+		}
+	}
+	if err := db.PutAllEncoded(RawEntries{"c": raw, "d": nil}); err != ErrBadValue {
+		t.Fatalf("got %v, expected ErrBadValue", err)
+	}
+	if err := db.Get("c", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+}
+
+type multiValue struct {
+	N    int
+	Tags []string
+}
+
+func fillMulti(t testing.TB, db *Store, n int) []string {
+	t.Helper()
+	entries := make(map[string]interface{}, n)
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = keyN(i)
+		entries[keys[i]] = multiValue{N: i, Tags: []string{"a", "b", "c"}}
+	}
+	if err := db.PutAll(entries); err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestGetMulti(t *testing.T) {
+	db := openTestStore(t)
+	keys := fillMulti(t, db, 10)
+	if err := db.Put("string", "not a multiValue"); err != nil {
+		t.Fatal(err)
+	}
+	keys = append(keys, "missing", "string")
+	var got []string
+	err := db.GetMulti(keys, func() interface{} { return new(multiValue) }, func(key string, v interface{}, err error) {
+		if err != nil {
+			got = append(got, key+":"+fmt.Sprint(err == ErrNotFound))
+		} else {
+			got = append(got, fmt.Sprintf("%s:%d", key, v.(*multiValue).N))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 12 || got[3] != "k00003:3" || got[10] != "missing:true" || got[11] != "string:false" {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestGetMultiParallel(t *testing.T) {
+	db := openTestStore(t)
+	keys := fillMulti(t, db, 5000)
+	if err := db.Put("string", "not a multiValue"); err != nil {
+		t.Fatal(err)
+	}
+	keys = append(keys, "missing", "string")
+	for _, workers := range []int{0, -1, 1, 8, 10000} {
+		seen := make(map[string]int)
+		err := db.GetMultiParallel(keys, func() interface{} { return new(multiValue) }, workers,
+			func(key string, v interface{}, err error) {
+				seen[key]++
+				switch {
+				case key == "missing":
+					if err != ErrNotFound || v != nil {
+						t.Errorf("missing: got %v, %v", v, err)
+					}
+				case key == "string":
+					if err == nil || err == ErrNotFound {
+						t.Errorf("string: got %v, expected a decoding error", err)
+					}
+				case err != nil:
+					t.Errorf("%s: %v", key, err)
+				case keyN(v.(*multiValue).N) != key:
+					t.Errorf("%s: got value %d", key, v.(*multiValue).N)
+				}
+			})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(seen) != len(keys) {
+			t.Fatalf("workers %d: saw %d keys, expected %d", workers, len(seen), len(keys))
+		}
+		for key, n := range seen {
+			if n != 1 {
+				t.Fatalf("workers %d: %s reported %d times", workers, key, n)
+			}
+		}
+	}
+	if err := db.GetMultiParallel(nil, nil, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkGetMulti(b *testing.B) {
+	db := openTestStore(b)
+	keys := fillMulti(b, db, 10000)
+	newValue := func() interface{} { return new(multiValue) }
+	discard := func(string, interface{}, error) {}
+	b.Run("Serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := db.GetMulti(keys, newValue, discard); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := db.GetMultiParallel(keys, newValue, 0, discard); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package bboltkv
+
+import "time"
+
+// WithAdaptiveBatching makes the operations that write in batches, each in
+// a transaction of its own, size their batches so that each transaction
+// takes about target to commit: ImportJSON, ImportBolt, ImportGob and its
+// kin, Merge, ApplyIncremental, RenamePrefix, SweepExpired and
+// ReapScheduledDeletes. Each starts from the batch size it uses without the
+// option, bounded by min and max, and after each full batch it scales the
+// size of the next by how far the time the batch took, from the start of
+// its transaction to the end of its commit, was from target, by at most
+// half or double, so that consecutive batches differ by no more than a
+// factor of 2. Batches within 10% of target keep their size. With
+// WithBatchReport, an operation reports the size and time of each batch.
+//
+// Without the option, batches have a fixed size, which is too small to make
+// good use of a fast disk, or on a slow one too big to commit without
+// holding up other writers for long.
+//
+//	bboltkv.WithAdaptiveBatching(50*time.Millisecond, 100, 50000)
+func WithAdaptiveBatching(target time.Duration, min, max int) Option {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	return func(o *options) {
+		o.batchTarget = target
+		o.batchMin, o.batchMax = min, max
+	}
+}
+
+// WithBatchReport makes an operation that writes in batches, see
+// WithAdaptiveBatching, call fn once each batch has committed, with the
+// number of entries it held and the time its transaction took. fn must not
+// call back into the store, as for Progress.
+//
+//	n, err := store.ImportJSON(r, bboltkv.WithBatchReport(func(size int, took time.Duration) {
+//	    log.Printf("imported %d entries in %v", size, took)
+//	}))
+func WithBatchReport(fn func(size int, took time.Duration)) OpOption {
+	return func(o *opOptions) {
+		o.batchReport = fn
+	}
+}
+
+// batcher sizes the batches of one call of an operation that writes in
+// batches.
+type batcher struct {
+	s      *Store
+	size   int // of the next batch
+	report func(size int, took time.Duration)
+}
+
+// newBatcher returns a batcher starting from size, the operation's batch
+// size without WithAdaptiveBatching.
+func (s *Store) newBatcher(size int, report func(size int, took time.Duration)) *batcher {
+	if s.opts.batchTarget > 0 {
+		if size < s.opts.batchMin {
+			size = s.opts.batchMin
+		} else if size > s.opts.batchMax {
+			size = s.opts.batchMax
+		}
+	}
+	return &batcher{s: s, size: size, report: report}
+}
+
+// done sizes the next batch by took, the time the transaction of the batch
+// of n entries just committed took, and reports the batch.
+func (b *batcher) done(n int, took time.Duration) error {
+	if n >= b.size {
+		b.adapt(took)
+	}
+	if b.report == nil {
+		return nil
+	}
+	return b.s.callback(func() { b.report(n, took) })
+}
+
+// adapt scales the batch size toward the one that takes the target time.
+func (b *batcher) adapt(took time.Duration) {
+	o := &b.s.opts
+	if o.batchTarget <= 0 {
+		return
+	}
+	factor := 2.0
+	if took > 0 {
+		factor = float64(o.batchTarget) / float64(took)
+	}
+	switch {
+	case factor > 2:
+		factor = 2
+	case factor < 0.5:
+		factor = 0.5
+	case factor > 0.9 && factor < 1.1:
+		return
+	}
+	size := int(float64(b.size)*factor + 0.5)
+	if size < o.batchMin {
+		size = o.batchMin
+	} else if size > o.batchMax {
+		size = o.batchMax
+	}
+	b.size = size
+}
@@ -0,0 +1,148 @@
+package bboltkv
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/unknownnf/bboltkv/testutil"
+)
+
+// exportN returns the ExportJSON of a store holding keyN(0) to keyN(n-1).
+func exportN(t *testing.T, n int) []byte {
+	t.Helper()
+	src := openTestStore(t)
+	entries := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		entries[keyN(i)] = i
+	}
+	if err := src.PutAll(entries); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := src.ExportJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// importSizes imports data into db and returns the sizes of the batches.
+func importSizes(t *testing.T, db *Store, data []byte) []int {
+	t.Helper()
+	var sizes []int
+	_, err := db.ImportJSON(bytes.NewReader(data), WithBatchReport(func(size int, _ time.Duration) {
+		sizes = append(sizes, size)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sizes
+}
+
+// checkImported checks that db holds keyN(0) to keyN(n-1).
+func checkImported(t *testing.T, db *Store, n int) {
+	t.Helper()
+	want := make(map[string]int, n)
+	for i := 0; i < n; i++ {
+		want[keyN(i)] = i
+	}
+	checkValues(t, db, want)
+}
+
+// checkSteady checks that consecutive batch sizes differ by at most a
+// factor of 2.
+func checkSteady(t *testing.T, sizes []int) {
+	t.Helper()
+	for i := 1; i < len(sizes)-1; i++ {
+		a, b := sizes[i-1], sizes[i]
+		if a > 2*b || b > 2*a {
+			t.Fatalf("batch sizes jump from %d to %d in %v", a, b, sizes)
+		}
+	}
+}
+
+func TestAdaptiveBatchingSlow(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock), WithAdaptiveBatching(10*time.Millisecond, 50, 5000))
+	db.txHook = func() error {
+		clock.Advance(time.Second)
+		return nil
+	}
+	sizes := importSizes(t, db, exportN(t, 3000))
+	if want := []int{1000, 500, 250, 125, 63, 50}; fmt.Sprint(sizes[:len(want)]) != fmt.Sprint(want) {
+		t.Fatalf("got batch sizes %v, expected them to start %v", sizes, want)
+	}
+	// held at the minimum, but for the last batch, of the entries left
+	for _, n := range sizes[5 : len(sizes)-1] {
+		if n != 50 {
+			t.Fatalf("got batch sizes %v", sizes)
+		}
+	}
+	checkSteady(t, sizes)
+	db.txHook = nil
+	checkImported(t, db, 3000)
+}
+
+func TestAdaptiveBatchingFast(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock), WithAdaptiveBatching(time.Second, 10, 5000))
+	sizes := importSizes(t, db, exportN(t, 20000))
+	if want := []int{1000, 2000, 4000, 5000, 5000, 3000}; fmt.Sprint(sizes) != fmt.Sprint(want) {
+		t.Fatalf("got batch sizes %v, expected %v", sizes, want)
+	}
+	checkImported(t, db, 20000)
+}
+
+func TestAdaptiveBatchingConverges(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	// commits take 1ms per entry, so batches of 100 take the target time
+	db := openTestStore(t, WithClock(clock), WithAdaptiveBatching(100*time.Millisecond, 1, 10000),
+		WithPutHook(func(string, map[string]string, []byte) { clock.Advance(time.Millisecond) }))
+	sizes := importSizes(t, db, exportN(t, 5000))
+	checkSteady(t, sizes)
+	settled := sizes[len(sizes)/2 : len(sizes)-1]
+	for _, n := range settled {
+		if n < 90 || n > 110 {
+			t.Fatalf("batch sizes settle at %v", settled)
+		}
+	}
+	for i := 1; i < len(settled); i++ {
+		if settled[i] != settled[0] {
+			t.Fatalf("batch sizes keep changing: %v", settled)
+		}
+	}
+	checkImported(t, db, 5000)
+
+	// other batched operations
+	moved, err := db.RenamePrefix("k", "m", false)
+	if err != nil || moved != 5000 {
+		t.Fatalf("moved %d, %v", moved, err)
+	}
+	dst := openTestStore(t, WithClock(clock), WithAdaptiveBatching(time.Millisecond, 1, 10))
+	if n, err := dst.Merge(db); err != nil || n != 5000 {
+		t.Fatalf("merged %d, %v", n, err)
+	}
+	want := make(map[string]int, 5000)
+	for i := 0; i < 5000; i++ {
+		want["m"+keyN(i)[1:]] = i
+	}
+	checkValues(t, dst, want)
+}
+
+func TestBatchReportFixed(t *testing.T) {
+	db := openTestStore(t)
+	sizes := importSizes(t, db, exportN(t, 2500))
+	if want := []int{1000, 1000, 500}; fmt.Sprint(sizes) != fmt.Sprint(want) {
+		t.Fatalf("got batch sizes %v, expected %v", sizes, want)
+	}
+	checkImported(t, db, 2500)
+
+	// a report calling back into the store fails the operation
+	_, err := db.ImportJSON(bytes.NewReader(exportN(t, 10)), WithBatchReport(func(int, time.Duration) {
+		db.Count()
+	}))
+	if err != ErrReentrant {
+		t.Fatalf("got %v, expected ErrReentrant", err)
+	}
+}
@@ -2,17 +2,61 @@ package bboltkv
 
 import (
 	"bytes"
-	"encoding/gob"
+	"context"
 	"errors"
+	"fmt"
 	"go.etcd.io/bbolt"
+	"os"
+	"sync"
 	"time"
 )
 
 // Store represents the key value store. Use the Open() method to create
 // one, and Close() it when done.
 type Store struct {
-	db         *bbolt.DB
-	bucketName []byte
+	db            *bbolt.DB
+	bucketName    []byte
+	opts          options
+	readOnly      int32
+	cache         *readCache
+	decoded       *decodedCache // see WithDecodedCache
+	filter        *bloomFilter  // see WithNegativeLookupFilter
+	filterMu      sync.RWMutex
+	filterGrowing int32 // set while growFilter rebuilds the filter
+	flights       *flights
+	opStats       *opStats
+	txStats       *txStats
+	wbuf          *writeBuffer
+	fair          *writeQueue  // see WithFairWrites
+	limiter       *rateLimiter // see WithWriteRateLimit
+	pipeline      *pipeline
+	keys          *keyCipher // see WithEncryptedKeys
+	sweepSteps    int64      // expiry index entries visited by sweeps, for tests
+	outboxMu      sync.Mutex
+	release       func() error   // closes the database, or drops a shared reference
+	shared        *sharedDB      // see OpenShared
+	freezer       *freezer       // see Freeze
+	rot           *rotation      // see OpenRotating
+	pruner        *journalPruner // see WithJournalRetention
+	warnings      sync.Mutex     // held while warnings are delivered, see WithQuotaWarning
+	callbacks     callbacks
+	schemas       schemas
+	views         views
+	textIndexes   textIndexes
+	topics        topics
+	logWaits      topics       // signalled on changes to the change log, see WatchState
+	txHook        func() error // run before each write commits, for tests
+	state         *bucketState // shared with the stores on the same bucket, see OpenShared
+	seqs          commitSeqs
+	lastModTime   int64     // UnixNano of the last change stamped, see WithModTimes
+	compacted     time.Time // when WithAutoCompact compacted the file, see SelfStats
+
+	gate     gate
+	done     chan struct{} // closed when the store starts closing
+	bg       sync.WaitGroup
+	stopOnce sync.Once
+	closeMu  sync.Mutex
+	closed   bool
 }
 
 var (
@@ -23,37 +67,223 @@ var (
 	// ErrBadValue is returned when the value supplied to the Put method
 	// is nil.
 	ErrBadValue = errors.New("bboltkv: bad value")
+
+	// ErrReadOnly is returned by methods that modify the store when the
+	// store has been put into read-only mode.
+	ErrReadOnly = errors.New("bboltkv: store is read-only")
+
+	// ErrClosed is returned by all methods once the store has been closed,
+	// or is being closed.
+	ErrClosed = errors.New("bboltkv: store is closed")
+
+	// ErrNoDatabase is wrapped by the error returned by OpenExisting when
+	// the database file does not exist.
+	ErrNoDatabase = errors.New("bboltkv: database file does not exist")
+
+	// ErrNoBucket is wrapped by the error returned by OpenExisting when the
+	// database file has no bucket of the given name, and by the errors of
+	// the store's methods once its bucket has been removed from the file,
+	// see WithAutoRecreateBucket.
+	ErrNoBucket = errors.New("bboltkv: bucket does not exist")
 )
 
 // Open a key-value store. "path" is the full path to the database file, any
 // leading directories must have been created already. File is created with
-// mode 0640 if needed.
+// mode 0640 if needed. Optional behaviour can be configured by passing
+// Options.
 //
 // Because of bboltDB restrictions, only one process may open the file at a
 // time. Attempts to open the file from another process will fail with a
 // timeout error.
-func Open(path string, bucketName string) (*Store, error) {
-	opts := &bbolt.Options{
-		Timeout: 50 * time.Millisecond,
+func Open(path string, bucketName string, opts ...Option) (*Store, error) {
+	return open(path, bucketName, buildOptions(opts))
+}
+
+// OpenExisting opens a key-value store like Open, but never creates the
+// database file or the bucket. If the file does not exist, it returns an
+// error wrapping ErrNoDatabase, and if the bucket does not exist, one
+// wrapping ErrNoBucket. WithReadOnly implies the same behaviour.
+//
+//	store, err := bboltkv.OpenExisting("my.db", "bucket")
+//	if errors.Is(err, bboltkv.ErrNoBucket) {
+//	    log.Fatal("wrong bucket name?")
+//	}
+func OpenExisting(path string, bucketName string, opts ...Option) (*Store, error) {
+	o := buildOptions(opts)
+	o.mustExist = true
+	return open(path, bucketName, o)
+}
+
+func open(path string, bucketName string, o options) (*Store, error) {
+	var compacted time.Time
+	if o.autoCompact > 0 && !o.readOnly {
+		var err error
+		if compacted, err = autoCompact(path, o); err != nil {
+			return nil, err
+		}
 	}
-	if db, err := bbolt.Open(path, 0640, opts); err != nil {
+	db, err := openDB(path, o)
+	if err != nil {
 		return nil, err
-	} else {
-		err := db.Update(func(tx *bbolt.Tx) error {
-			_, err := tx.CreateBucketIfNotExists([]byte(bucketName))
+	}
+	s, err := newStore(db, bucketName, o, true)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	s.compacted = compacted
+	return s, nil
+}
+
+func buildOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// openDB opens the database file, failing with ErrNoDatabase rather than
+// creating it if the options say it must exist, or with ErrPageSize if it
+// does not have the page size they ask for.
+func openDB(path string, o options) (*bbolt.DB, error) {
+	if o.mustExist {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrNoDatabase, path)
+		}
+	}
+	bopts, err := o.boltOptions()
+	if err != nil {
+		return nil, err
+	}
+	db, err := bbolt.Open(path, 0640, bopts)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.checkPageSize(db, path); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// newStore sets up a store on an open database. The consistency check is
+// only run if check is set. On error, the database is left open.
+func newStore(db *bbolt.DB, bucketName string, o options, check bool) (*Store, error) {
+	s := &Store{
+		db:         db,
+		bucketName: []byte(bucketName),
+		opts:       o,
+		done:       make(chan struct{}),
+		release:    db.Close,
+		freezer:    o.freezer,
+		state:      o.state,
+	}
+	if s.freezer == nil {
+		s.freezer = newFreezer()
+	}
+	if s.state == nil {
+		s.state = &bucketState{}
+	}
+	if o.cacheSize > 0 {
+		s.cache = newReadCache(o.cacheSize)
+	}
+	if o.decodedSize > 0 {
+		s.decoded = newDecodedCache(o.decodedSize)
+	}
+	if o.singleflight {
+		s.flights = newFlights()
+	}
+	if o.opStatsPrefixLen > 0 {
+		s.opStats = newOpStats(o.opStatsPrefixLen)
+	}
+	if o.txStats {
+		s.txStats = &txStats{ops: make(map[string]*TxOpStats)}
+	}
+	if o.writeBuffer {
+		s.wbuf = newWriteBuffer(o.bufferEntries)
+	}
+	if o.fairWrites {
+		s.fair = newWriteQueue(o.fairDelay)
+	}
+	s.limiter = newRateLimiter(o, s.now())
+	var err error
+	s.pipeline, err = newPipeline(o)
+	if err == nil && o.encryptedKeys {
+		s.keys, err = newKeyCipher(o)
+	}
+	if err == nil && check {
+		err = s.checkOnOpen()
+	}
+	setup := func(tx *bbolt.Tx) error {
+		if o.mustExist {
+			if tx.Bucket([]byte(bucketName)) == nil {
+				return fmt.Errorf("%w: %s", ErrNoBucket, bucketName)
+			}
+		} else if _, err := tx.CreateBucketIfNotExists([]byte(bucketName)); err != nil {
 			return err
-		})
-		if err != nil {
-			return nil, err
-		} else {
-			return &Store{db: db, bucketName: []byte(bucketName)}, nil
 		}
+		s.loadProtection(tx)
+		s.loadTTLPolicies(tx)
+		s.loadImmutables(tx)
+		s.loadDicts(tx)
+		if err := s.loadQuota(tx); err != nil {
+			return err
+		}
+		if o.filterBitsPerKey > 0 {
+			s.buildFilter(tx)
+		}
+		var seq uint64
+		if b := s.aux(tx, commitsBucket); b != nil {
+			seq = b.Sequence()
+		}
+		s.seqs.init(seq)
+		if s.wbuf != nil {
+			s.wbuf.seq = seq
+		}
+		return nil
+	}
+	if err == nil && o.readOnly {
+		s.setReadOnly()
+		err = db.View(setup)
+	} else if err == nil {
+		err = db.Update(setup)
+	}
+	for _, prefix := range o.preload {
+		if err == nil {
+			_, err = s.Preload(prefix)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if check && o.checkMode == CheckFull && o.checkBackground {
+		s.goBackground(func(<-chan struct{}) { s.backgroundCheck() })
 	}
+	if o.readOnly {
+		// the remaining background work only writes
+		return s, nil
+	}
+	if s.wbuf != nil && o.bufferDelay > 0 {
+		s.goBackground(s.flushLoop)
+	}
+	if o.sweepInterval > 0 {
+		s.goBackground(s.sweepLoop)
+	}
+	if o.selfStatsInterval > 0 {
+		s.goBackground(s.selfStatsLoop)
+	}
+	if o.journalMaxBytes > 0 || o.journalMaxAge > 0 {
+		s.pruner = &journalPruner{due: make(chan struct{}, 1)}
+		s.goBackground(s.pruneLoop)
+	}
+	return s, nil
 }
 
 // Put an entry into the store. The passed value is gob-encoded and stored.
 // The key can be an empty string, but the value cannot be nil - if it is,
-// Put() returns ErrBadValue.
+// Put() returns ErrBadValue. Values gob cannot encode faithfully are
+// rejected with an error matching ErrUnencodable; see Validate.
 //
 //	err := store.Put("key", 1)
 //	err := store.Put("key", "string")
@@ -63,16 +293,34 @@ func Open(path string, bucketName string) (*Store, error) {
 //	}
 //	err := store.Put("key", m)
 func (s *Store) Put(key string, value interface{}) error {
-	if value == nil {
-		return ErrBadValue
+	return s.PutContext(context.Background(), key, value)
+}
+
+// PutContext is Put, giving up with ErrTimeout if the write cannot begin
+// before ctx's deadline, or with ctx's error if ctx is cancelled first. The
+// deadline replaces the store's WithOpTimeout.
+func (s *Store) PutContext(ctx context.Context, key string, value interface{}) (err error) {
+	if s.opts.tracer != nil {
+		defer s.startSpan(s.opts.tracer, "Put", key)(&err)
 	}
-	var buf bytes.Buffer
-	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+	raw, err := s.encodeForPut(key, value)
+	if err != nil {
 		return err
 	}
-	return s.db.Update(func(tx *bbolt.Tx) error {
-		return tx.Bucket(s.bucketName).Put([]byte(key), buf.Bytes())
-	})
+	if err := s.limitWrite(ctx, key); err != nil {
+		return err
+	}
+	plain := key
+	key = s.sealKey(key)
+	if s.wbuf != nil {
+		return s.putBuffered(key, raw)
+	}
+	if s.fair != nil {
+		return s.queueWrite(ctx, &queuedWrite{key: key, raw: raw, meta: OpMeta(ctx)})
+	}
+	return s.updateContext(ctx, s.named("Put", plain, s.withMeta(ctx, func(w *wtx) error {
+		return w.put(key, raw)
+	})))
 }
 
 // Get an entry from the store. "value" must be a pointer-typed. If the key
@@ -93,44 +341,406 @@ func (s *Store) Put(key string, value interface{}) error {
 // The value passed to Get() can be nil, in which case any value read from
 // the store is silently discarded.
 //
-//  if err := store.Get("key", nil); err == nil {
-//      fmt.Println("entry is present")
-//  }
-func (s *Store) Get(key string, value interface{}) error {
-	return s.db.View(func(tx *bbolt.Tx) error {
+//	if err := store.Get("key", nil); err == nil {
+//	    fmt.Println("entry is present")
+//	}
+func (s *Store) Get(key string, value interface{}) (err error) {
+	if s.opts.tracer != nil {
+		defer s.startSpan(s.opts.tracer, "Get", key)(&err)
+	}
+	if s.opts.schemaGets && value != nil {
+		if err := s.checkSchema(key, value); err != nil {
+			return err
+		}
+	}
+	key = s.sealKey(key)
+	if s.cache != nil || s.flights != nil || s.wbuf != nil {
+		raw, err := s.load(key)
+		if err != nil || value == nil {
+			return err
+		}
+		return s.decode(raw, value)
+	}
+	if s.filterAbsent(key) {
+		return ErrNotFound
+	}
+	return s.view(func(tx *bbolt.Tx) error {
 		c := tx.Bucket(s.bucketName).Cursor()
-		if k, v := c.Seek([]byte(key)); k == nil || string(k) != key {
+		k, v := c.Seek([]byte(key))
+		if k == nil || string(k) != key || s.expired(tx, key) {
 			return ErrNotFound
-		} else if value == nil {
+		}
+		v, err := s.assemble(tx, k, v)
+		if err != nil {
+			return err
+		}
+		if s.opStats != nil {
+			s.opStats.read(key, len(v))
+		}
+		if value == nil {
 			return nil
-		} else {
-			d := gob.NewDecoder(bytes.NewReader(v))
-			return d.Decode(value)
 		}
+		return s.decode(v, value)
+	})
+}
+
+// load returns a copy of the encoded value stored under key, going through
+// the write buffer, the read cache and the singleflight layer when they are
+// enabled.
+func (s *Store) load(key string) ([]byte, error) {
+	raw, _, err := s.loadCacheable(key)
+	return raw, err
+}
+
+// loadCacheable is load, also reporting whether the value may be cached:
+// not if it was read from the write buffer, has a TTL, or was read by
+// another caller's read joined through the singleflight layer.
+func (s *Store) loadCacheable(key string) ([]byte, bool, error) {
+	if err := s.enter(); err != nil {
+		return nil, false, err
+	}
+	defer s.gate.exit()
+	if s.wbuf != nil {
+		if raw, ok := s.wbuf.get(key); ok {
+			if s.opStats != nil {
+				s.opStats.read(key, len(raw))
+			}
+			return raw, false, nil
+		}
+	}
+	if s.filterAbsent(key) {
+		return nil, false, ErrNotFound
+	}
+	if s.cache != nil {
+		if raw, ok := s.cache.get(key); ok {
+			if s.opStats != nil {
+				s.opStats.read(key, len(raw))
+			}
+			return raw, true, nil
+		}
+	}
+	cacheable := false
+	read := func() ([]byte, error) {
+		var epoch uint64
+		if s.cache != nil {
+			epoch = s.cache.begin()
+		}
+		var raw []byte
+		cacheable = true
+		err := s.db.View(func(tx *bbolt.Tx) error {
+			b := tx.Bucket(s.bucketName)
+			if b == nil {
+				return s.errNoBucket()
+			}
+			v := b.Get([]byte(key))
+			if v == nil {
+				return ErrNotFound
+			}
+			if _, ok := s.scheduledAt(tx, key); ok {
+				return ErrNotFound
+			}
+			if at, ok := s.expiresAt(tx, key); ok {
+				// the cache knows nothing of expiry
+				cacheable = false
+				if !s.now().Before(at) {
+					return ErrNotFound
+				}
+			}
+			v, err := s.assemble(tx, []byte(key), v)
+			if err != nil {
+				return err
+			}
+			raw = append([]byte(nil), v...)
+			return nil
+		})
+		if err == nil && s.cache != nil && cacheable {
+			s.cache.add(key, raw, epoch)
+		}
+		return raw, err
+	}
+	var raw []byte
+	var err error
+	if s.flights != nil {
+		raw, err = s.flights.reads.do(key, read)
+	} else {
+		raw, err = read()
+	}
+	if err == nil && s.opStats != nil {
+		s.opStats.read(key, len(raw))
+	}
+	return raw, cacheable, err
+}
+
+// GetOrPut gets the entry with the given key into value, like Get. If the
+// key is not present, it calls compute, stores the value it returns like
+// Put, and decodes that into value instead. If compute fails, its error is
+// returned and nothing is stored. "value" must be pointer-typed or nil.
+//
+// compute runs outside of any transaction, so concurrent callers may each
+// compute a value; only the first one to be stored is kept, and all callers
+// receive that one. With WithSingleflight, concurrent callers for the same
+// key share a single call to compute instead.
+//
+//	var page Page
+//	err := store.GetOrPut("page:"+url, &page, func() (interface{}, error) {
+//	    return fetch(url)
+//	})
+func (s *Store) GetOrPut(key string, value interface{}, compute func() (interface{}, error)) error {
+	getOrPut := func() ([]byte, error) {
+		return s.getOrPut(key, compute)
+	}
+	var raw []byte
+	var err error
+	if s.flights != nil {
+		raw, err = s.flights.computes.do(key, getOrPut)
+	} else {
+		raw, err = getOrPut()
+	}
+	if err != nil || value == nil {
+		return err
+	}
+	return s.decode(raw, value)
+}
+
+func (s *Store) getOrPut(key string, compute func() (interface{}, error)) ([]byte, error) {
+	stored := s.sealKey(key)
+	raw, err := s.load(stored)
+	if err != ErrNotFound {
+		return raw, err
+	}
+	v, err := compute()
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := s.encodeForPut(key, v)
+	if err != nil {
+		return nil, err
+	}
+	err = s.update(func(w *wtx) error {
+		if existing := w.get(stored); existing != nil {
+			raw = append([]byte(nil), existing...)
+			return nil
+		}
+		raw = encoded
+		return w.put(stored, encoded)
 	})
+	return raw, err
 }
 
 // Delete the entry with the given key. If no such key is present in the store,
-// it returns ErrNotFound.
+// it returns ErrNotFound, if the key is protected, see Protect, it returns
+// ErrProtected, and if it is immutable, see PutImmutable, ErrImmutable.
 //
 //	store.Delete("key")
 func (s *Store) Delete(key string) error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
-		c := tx.Bucket(s.bucketName).Cursor()
-		if k, _ := c.Seek([]byte(key)); k == nil || string(k) != key {
+	return s.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext is Delete, bounded by ctx like PutContext.
+func (s *Store) DeleteContext(ctx context.Context, key string) (err error) {
+	if s.opts.tracer != nil {
+		defer s.startSpan(s.opts.tracer, "Delete", key)(&err)
+	}
+	if err := s.limitWrite(ctx, key); err != nil {
+		return err
+	}
+	plain := key
+	key = s.sealKey(key)
+	if s.fair != nil {
+		return s.queueWrite(ctx, &queuedWrite{key: key, delete: true, meta: OpMeta(ctx)})
+	}
+	return s.updateContext(ctx, s.named("Delete", plain, s.withMeta(ctx, func(w *wtx) error {
+		return s.deleteOne(w, key)
+	})))
+}
+
+// deleteOne implements Delete in w.
+func (s *Store) deleteOne(w *wtx, key string) error {
+	if w.get(key) == nil {
+		return ErrNotFound
+	} else if s.protected(key) {
+		return ErrProtected
+	}
+	return w.delete(key)
+}
+
+// DeleteGet deletes the entry with the given key and decodes the value it
+// held into "value", all within one transaction. As with Get, "value" must be
+// pointer-typed or nil, in which case the old value is discarded. If no such
+// key is present in the store, it returns ErrNotFound. If the old value cannot
+// be decoded, the entry is left in place and the decoding error is returned.
+// Protected keys are refused like with Delete.
+//
+//	var old string
+//	if err := store.DeleteGet("key", &old); err == nil {
+//	    log.Printf("deleted key=%q value=%q", "key", old)
+//	}
+func (s *Store) DeleteGet(key string, value interface{}) error {
+	key = s.sealKey(key)
+	return s.update(func(w *wtx) error {
+		if v := w.get(key); v == nil {
 			return ErrNotFound
+		} else if s.protected(key) {
+			return ErrProtected
+		} else if value != nil {
+			if err := s.decode(v, value); err != nil {
+				return err
+			}
+		}
+		return w.delete(key)
+	})
+}
+
+// DeleteGetRaw deletes the entry with the given key and returns a copy of the
+// encoded bytes it held. If no such key is present in the store, it returns
+// ErrNotFound; protected keys are refused like with Delete.
+func (s *Store) DeleteGetRaw(key string) ([]byte, error) {
+	key = s.sealKey(key)
+	var raw []byte
+	err := s.update(func(w *wtx) error {
+		if v := w.get(key); v == nil {
+			return ErrNotFound
+		} else if s.protected(key) {
+			return ErrProtected
 		} else {
-			return c.Delete()
+			raw = append([]byte(nil), v...)
+			return w.delete(key)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// GetRaw returns a copy of the encoded bytes stored under the given key, as
+// written by Put or PutEncoded. If no such key is present in the store, it
+// returns ErrNotFound.
+func (s *Store) GetRaw(key string) ([]byte, error) {
+	raw, err := s.GetRawInto(key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// GetRawInto appends the encoded bytes stored under the given key to buf,
+// like append, and returns the extended slice. If buf has enough spare
+// capacity, no memory is allocated for the value, so callers on hot paths
+// can reuse buffers:
+//
+//	buf, err = store.GetRawInto(key, buf[:0])
+//
+// bbolt allocates a little memory for every read transaction, so only reads
+// served from the read cache, see WithReadCache, allocate nothing at all.
+//
+// If no such key is present in the store, GetRawInto returns buf unchanged
+// together with ErrNotFound, and it does the same on any other error.
+func (s *Store) GetRawInto(key string, buf []byte) ([]byte, error) {
+	key = s.sealKey(key)
+	if s.cache != nil || s.flights != nil || s.wbuf != nil {
+		raw, err := s.load(key)
+		if err != nil {
+			return buf, err
+		}
+		return append(buf, raw...), nil
+	}
+	out := buf
+	err := s.view(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(s.bucketName).Get([]byte(key))
+		if v == nil || s.expired(tx, key) {
+			return ErrNotFound
+		}
+		v, err := s.assemble(tx, []byte(key), v)
+		if err != nil {
+			return err
+		}
+		if s.opStats != nil {
+			s.opStats.read(key, len(v))
 		}
+		out = append(out, v...)
+		return nil
 	})
+	if err != nil {
+		return buf, err
+	}
+	return out, nil
 }
 
-// Close closes the key-value store file.
-func (s *Store) Close() error {
-	return s.db.Close()
+// GetSet stores newValue under the given key and decodes the value it
+// replaced into oldValue, all within one transaction. It reports whether the
+// key held a value before. As with Get, oldValue must be pointer-typed or
+// nil, in which case the old value is discarded; as with Put, newValue
+// cannot be nil. If the old value cannot be decoded, nothing is written.
+//
+//	var oldToken string
+//	if existed, err := store.GetSet("token", newToken, &oldToken); err == nil && existed {
+//	    log.Printf("rotated token %s", oldToken)
+//	}
+func (s *Store) GetSet(key string, newValue interface{}, oldValue interface{}) (existed bool, err error) {
+	raw, err := s.encodeForPut(key, newValue)
+	if err != nil {
+		return false, err
+	}
+	key = s.sealKey(key)
+	err = s.update(func(w *wtx) error {
+		if v := w.get(key); v != nil {
+			existed = true
+			if oldValue != nil {
+				if err := s.decode(v, oldValue); err != nil {
+					return err
+				}
+			}
+		}
+		return w.put(key, raw)
+	})
+	return existed, err
 }
 
-// GetDb Get the database object directly to work with it
+// CompareAndPut stores newValue under the given key, but only if the key
+// currently holds oldValue, all within one transaction. Values are compared
+// by their encoding, so for values holding maps, open the store with
+// WithCanonicalEncoding. It returns ErrConflict if the key holds a
+// different value, and ErrNotFound if no such key is present in the store.
+//
+//	if err := store.CompareAndPut("config", current, updated); err == bboltkv.ErrConflict {
+//	    // someone else changed the config in the meantime
+//	}
+func (s *Store) CompareAndPut(key string, oldValue, newValue interface{}) error {
+	oldValue, err := s.normalize(key, oldValue)
+	if err != nil {
+		return err
+	}
+	old, err := s.encodePlain(oldValue)
+	if err != nil {
+		return err
+	}
+	raw, err := s.encodeForPut(key, newValue)
+	if err != nil {
+		return err
+	}
+	key = s.sealKey(key)
+	return s.update(func(w *wtx) error {
+		v := w.get(key)
+		if v == nil {
+			return ErrNotFound
+		}
+		v, err := s.pipeline.untransform(v)
+		if err != nil {
+			return err
+		} else if !bytes.Equal(v, old) {
+			return ErrConflict
+		}
+		return w.put(key, raw)
+	})
+}
+
+// GetDb Get the database object directly to work with it. For a store
+// opened with OpenRotating, it changes with each rotation.
+//
+// Deprecated: Writing to the database behind the store's back easily breaks
+// what it keeps about its entries. Use RawView and RawUpdate, which hand
+// out the store's bucket within a transaction the store manages.
 func (s *Store) GetDb() *bbolt.DB {
 	return s.db
 }
@@ -2,15 +2,34 @@ package bboltkv
 
 import (
 	"bytes"
+	"encoding/gob"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"go.etcd.io/bbolt"
 )
 
+// openTestStore opens a store on a fresh file in a temporary directory. The
+// store is closed when the test ends; GetDb().Path() names the file.
+func openTestStore(t testing.TB, opts ...Option) *Store {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(name, "test", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
 func TestBasic(t *testing.T) {
 	name := "test.db"
 	os.RemoveAll(name)
@@ -188,16 +207,16 @@ func TestGoroutines(t *testing.T) {
 			switch rand.Intn(3) {
 			case 0:
 				if err := db.Put(testKey, testValue); err != nil {
-					t.Fatal(err)
+					t.Error(err)
 				}
 			case 1:
 				var val string
 				if err := db.Get(testKey, &val); err != nil && err != ErrNotFound {
-					t.Fatal(err)
+					t.Error(err)
 				}
 			case 2:
 				if err := db.Delete(testKey); err != nil && err != ErrNotFound {
-					t.Fatal(err)
+					t.Error(err)
 				}
 			}
 			wg.Done()
@@ -207,6 +226,212 @@ func TestGoroutines(t *testing.T) {
 	os.RemoveAll(name)
 }
 
+func TestDeleteGet(t *testing.T) {
+	name := "test.db"
+	os.RemoveAll(name)
+	db, err := Open(name, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(name)
+	defer db.Close()
+
+	inval := aStruct{Numbers: &[]int{1, 2, 3}}
+	if err := db.Put("key", inval); err != nil {
+		t.Fatal(err)
+	}
+	var outval aStruct
+	if err := db.DeleteGet("key", &outval); err != nil {
+		t.Fatal(err)
+	}
+	if len(*outval.Numbers) != 3 || (*outval.Numbers)[2] != 3 {
+		t.Fatalf("got %v, expected %v", *outval.Numbers, *inval.Numbers)
+	}
+	if err := db.Get("key", nil); err != ErrNotFound {
+		t.Fatalf("get after DeleteGet returned %v, expected ErrNotFound", err)
+	}
+	// discard the old value
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteGet("key", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get("key", nil); err != ErrNotFound {
+		t.Fatalf("get after DeleteGet returned %v, expected ErrNotFound", err)
+	}
+	// missing key
+	if err := db.DeleteGet("key", &outval); err != ErrNotFound {
+		t.Fatalf("DeleteGet returned %v, expected ErrNotFound", err)
+	}
+	// a value that cannot be decoded stays in place
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	var wrong int
+	if err := db.DeleteGet("key", &wrong); err == nil {
+		t.Fatal("DeleteGet decoded a string into an int")
+	}
+	if err := db.Get("key", nil); err != nil {
+		t.Fatalf("failed DeleteGet removed the entry: %v", err)
+	}
+}
+
+func TestDeleteGetRaw(t *testing.T) {
+	name := "test.db"
+	os.RemoveAll(name)
+	db, err := Open(name, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(name)
+	defer db.Close()
+
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := db.DeleteGetRaw("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var val string
+	if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&val); err != nil {
+		t.Fatal(err)
+	} else if val != "value" {
+		t.Fatalf("got \"%s\", expected \"value\"", val)
+	}
+	if _, err := db.DeleteGetRaw("key"); err != ErrNotFound {
+		t.Fatalf("DeleteGetRaw returned %v, expected ErrNotFound", err)
+	}
+}
+
+type pair struct {
+	A, B int
+}
+
+func TestDeleteGetConcurrentPut(t *testing.T) {
+	name := "test.db"
+	os.RemoveAll(name)
+	db, err := Open(name, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(name)
+	defer db.Close()
+
+	for i := 1; i <= 100; i++ {
+		if err := db.Put("key", pair{i, i}); err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		var old pair
+		var delErr, putErr error
+		wg.Add(2)
+		go func() {
+			delErr = db.DeleteGet("key", &old)
+			wg.Done()
+		}()
+		go func() {
+			putErr = db.Put("key", pair{-i, -i})
+			wg.Done()
+		}()
+		wg.Wait()
+		if delErr != nil || putErr != nil {
+			t.Fatal(delErr, putErr)
+		}
+		if old.A != old.B {
+			t.Fatalf("observed a mixed value %v", old)
+		}
+		var cur pair
+		err := db.Get("key", &cur)
+		switch {
+		case old.A == i:
+			// the delete won: the put must have landed afterwards
+			if err != nil || cur.A != -i {
+				t.Fatalf("iteration %d: deleted old value, then got %v, %v", i, cur, err)
+			}
+		case old.A == -i:
+			// the put won: the delete removed the new value
+			if err != ErrNotFound {
+				t.Fatalf("iteration %d: deleted new value, then got %v, %v", i, cur, err)
+			}
+		}
+		db.Delete("key")
+	}
+}
+
+func TestGetSet(t *testing.T) {
+	db := openTestStore(t)
+	var old string
+	if existed, err := db.GetSet("key", "value1", &old); err != nil || existed {
+		t.Fatalf("got %v, %v, expected a new key", existed, err)
+	}
+	if existed, err := db.GetSet("key", "value2", &old); err != nil || !existed {
+		t.Fatalf("got %v, %v, expected an existing key", existed, err)
+	} else if old != "value1" {
+		t.Fatalf("got \"%s\", expected \"value1\"", old)
+	}
+	// discard the old value
+	if existed, err := db.GetSet("key", "value3", nil); err != nil || !existed {
+		t.Fatalf("got %v, %v, expected an existing key", existed, err)
+	}
+	var val string
+	if err := db.Get("key", &val); err != nil {
+		t.Fatal(err)
+	} else if val != "value3" {
+		t.Fatalf("got \"%s\", expected \"value3\"", val)
+	}
+	if _, err := db.GetSet("key", nil, &old); err != ErrBadValue {
+		t.Fatalf("got %v, expected ErrBadValue", err)
+	}
+	// an old value that cannot be decoded leaves the entry alone
+	var wrong int
+	if _, err := db.GetSet("key", "value4", &wrong); err == nil {
+		t.Fatal("GetSet decoded a string into an int")
+	}
+	if err := db.Get("key", &val); err != nil {
+		t.Fatal(err)
+	} else if val != "value3" {
+		t.Fatalf("got \"%s\", expected \"value3\"", val)
+	}
+}
+
+func TestGetSetConcurrent(t *testing.T) {
+	db := openTestStore(t)
+	if err := db.Put("key", -1); err != nil {
+		t.Fatal(err)
+	}
+	const n = 100
+	olds := make([]int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := db.GetSet("key", i, &olds[i]); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	var last int
+	if err := db.Get("key", &last); err != nil {
+		t.Fatal(err)
+	}
+	// the swaps form a chain: every value but the last is replaced exactly
+	// once
+	seen := map[int]int{}
+	for _, old := range olds {
+		seen[old]++
+	}
+	seen[last]++
+	for v := -1; v < n; v++ {
+		if seen[v] != 1 {
+			t.Fatalf("value %d observed %d times", v, seen[v])
+		}
+	}
+}
+
 func BenchmarkPut(b *testing.B) {
 	name := "bench.db"
 	os.RemoveAll(name)
@@ -273,3 +498,197 @@ func BenchmarkPutDelete(b *testing.B) {
 	db.Close()
 	os.RemoveAll(name)
 }
+
+func TestGetRawInto(t *testing.T) {
+	db := openTestStore(t)
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	want, err := db.GetRaw("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nil buffer
+	got, err := db.GetRawInto("key", nil)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	// undersized buffer: grown, keeping what was in it
+	small := append(make([]byte, 0, 2), "ab"...)
+	got, err = db.GetRawInto("key", small)
+	if err != nil || !bytes.Equal(got, append([]byte("ab"), want...)) {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	// oversized buffer: used in place
+	large := make([]byte, 0, 1024)
+	got, err = db.GetRawInto("key", large)
+	if err != nil || !bytes.Equal(got, want) || &got[0] != &large[:1][0] || cap(got) != 1024 {
+		t.Fatalf("got %q, %v, cap %d", got, err, cap(got))
+	}
+	// missing key: buffer returned unchanged
+	got, err = db.GetRawInto("missing", small)
+	if err != ErrNotFound || len(got) != 2 || &got[0] != &small[0] {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if _, err := db.GetRaw("missing"); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+
+	// the read cache takes the same path
+	cached := openTestStore(t, WithReadCache(10))
+	if err := cached.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		got, err = cached.GetRawInto("key", large[:0])
+		if err != nil || !bytes.Equal(got, want) || &got[0] != &large[:1][0] {
+			t.Fatalf("got %q, %v", got, err)
+		}
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		got, _ = cached.GetRawInto("key", large[:0])
+	})
+	if allocs != 0 {
+		t.Fatalf("cache hits allocate %v times", allocs)
+	}
+}
+
+func BenchmarkGetRawInto(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		opts []Option
+	}{
+		{"Plain", nil},
+		{"ReadCache", []Option{WithReadCache(10)}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			db := openTestStore(b, bench.opts...)
+			if err := db.Put("key", strings.Repeat("v", 100)); err != nil {
+				b.Fatal(err)
+			}
+			buf := make([]byte, 0, 1024)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var err error
+				if buf, err = db.GetRawInto("key", buf[:0]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenExisting(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.db")
+	for _, opt := range []Option{nil, WithReadOnly()} {
+		open := func(bucket string) (*Store, error) {
+			if opt == nil {
+				return OpenExisting(name, bucket)
+			}
+			return Open(name, bucket, opt)
+		}
+		if _, err := open("test"); !errors.Is(err, ErrNoDatabase) {
+			t.Fatalf("got %v, expected ErrNoDatabase", err)
+		}
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Fatalf("file was created: %v", err)
+		}
+	}
+
+	db, err := Open(name, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	for _, opt := range []Option{nil, WithReadOnly()} {
+		open := func(bucket string, opts ...Option) (*Store, error) {
+			if opt == nil {
+				return OpenExisting(name, bucket, opts...)
+			}
+			return Open(name, bucket, append(opts, opt)...)
+		}
+		if _, err := open("tset"); !errors.Is(err, ErrNoBucket) {
+			t.Fatalf("got %v, expected ErrNoBucket", err)
+		}
+		// other options still apply
+		db, err := open("test", WithReadCache(10), WithWriteBuffer(10, time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var val string
+		if err := db.Get("key", &val); err != nil || val != "value" {
+			t.Fatalf("got %q, %v", val, err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the failed opens created nothing
+	db, err = Open(name, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = db.GetDb().View(func(tx *bbolt.Tx) error {
+		if tx.Bucket([]byte("tset")) != nil {
+			t.Error("bucket was created")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(name, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// several read-only stores can open the file at once
+	var readers []*Store
+	for i := 0; i < 2; i++ {
+		db, err := Open(name, "test", WithReadOnly(), WithExpirySweep(time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+		readers = append(readers, db)
+		if err := db.Put("key", "other"); err != ErrReadOnly {
+			t.Fatalf("got %v, expected ErrReadOnly", err)
+		}
+		if err := db.Delete("key"); err != ErrReadOnly {
+			t.Fatalf("got %v, expected ErrReadOnly", err)
+		}
+		var val string
+		if err := db.Get("key", &val); err != nil || val != "value" {
+			t.Fatalf("got %q, %v", val, err)
+		}
+	}
+	if _, err := Open(name, "test"); err == nil {
+		t.Fatal("opened for writing while open read-only")
+	}
+	for _, db := range readers {
+		db.Close()
+	}
+
+	// nor read-only while open for writing, by another handle on the file
+	db, err = Open(name, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := Open(name, "test", WithReadOnly()); err == nil {
+		t.Fatal("opened read-only while open for writing")
+	}
+}
@@ -0,0 +1,70 @@
+package bboltkv
+
+import (
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+// WithAutoRecreateBucket makes writes recreate the store's bucket if it has
+// been removed from the file behind the store's back, through GetDb or by
+// another program, instead of failing with an error wrapping ErrNoBucket.
+// The write creates the bucket, empty, in its own transaction, so that it
+// is recreated only if the write commits; the read caches are emptied, as
+// the entries they hold went with the bucket, and the usage WithQuota
+// counts starts afresh.
+//
+// Reads cannot create the bucket, and fail with an error wrapping
+// ErrNoBucket until a write has recreated it, with or without the option:
+// Get, GetAny and the other methods reading one entry return it rather than
+// ErrNotFound, and Keys, Count, ExportJSON and the other methods reading
+// many return it rather than nothing; only entries in the read cache of
+// WithReadCache, which does not see writes made through GetDb, are still
+// read until a write recreates the bucket. What the store keeps about the
+// entries elsewhere in the file, such as their TTLs and tags, is not
+// dropped along with the bucket; Fsck reports what is left behind, and
+// FsckRepair removes it.
+func WithAutoRecreateBucket() Option {
+	return func(o *options) {
+		o.autoRecreateBucket = true
+	}
+}
+
+// errNoBucket returns the error for finding the store's bucket missing.
+func (s *Store) errNoBucket() error {
+	return fmt.Errorf("%w: %q was removed from the file", ErrNoBucket, s.bucketName)
+}
+
+// writeBucket returns the store's bucket in the read-write transaction tx,
+// recreating it if it is missing and WithAutoRecreateBucket says so.
+func (s *Store) writeBucket(tx *bbolt.Tx) (*bbolt.Bucket, error) {
+	if b := tx.Bucket(s.bucketName); b != nil {
+		return b, nil
+	}
+	if !s.opts.autoRecreateBucket {
+		return nil, s.errNoBucket()
+	}
+	b, err := tx.CreateBucket(s.bucketName)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadQuota(tx); err != nil {
+		return nil, err
+	}
+	s.forgetAll(tx)
+	return b, nil
+}
+
+// forgetAll empties the read caches and makes reads in progress start afresh
+// once tx commits, after a change to entries that are not known.
+func (s *Store) forgetAll(tx *bbolt.Tx) {
+	if c := s.cache; c != nil {
+		tx.OnCommit(c.clear)
+	}
+	if c := s.decoded; c != nil {
+		tx.OnCommit(c.clear)
+	}
+	if f := s.flights; f != nil {
+		tx.OnCommit(f.reads.forgetAll)
+	}
+}
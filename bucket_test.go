@@ -0,0 +1,200 @@
+package bboltkv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// dropBucket removes the bucket of db from the file behind its back.
+func dropBucket(t *testing.T, db *Store) {
+	t.Helper()
+	err := db.GetDb().Update(func(tx *bbolt.Tx) error {
+		return tx.DeleteBucket(db.bucketName)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// bucketOps calls methods of db that need its bucket, by name.
+func bucketOps(db *Store) map[string]func() error {
+	var v int
+	ctx := context.Background()
+	return map[string]func() error{
+		"Put":           func() error { return db.Put("a", 1) },
+		"PutContext":    func() error { return db.PutContext(ctx, "a", 1) },
+		"PutEncoded":    func() error { return db.PutEncoded("a", []byte{tagTextMarshaler, '1'}) },
+		"PutAll":        func() error { return db.PutAll(map[string]interface{}{"a": 1}) },
+		"PutWithTTL":    func() error { return db.PutWithTTL("a", 1, time.Hour) },
+		"PutTagged":     func() error { return db.PutTagged("a", 1, "t") },
+		"Get":           func() error { return db.Get("a", &v) },
+		"GetRaw":        func() error { _, err := db.GetRaw("a"); return err },
+		"GetAny":        func() error { _, err := db.GetAny("a"); return err },
+		"GetShared":     func() error { _, err := db.GetShared("a"); return err },
+		"Has":           func() error { _, err := db.Has("a"); return err },
+		"Delete":        func() error { return db.Delete("a") },
+		"DeletePrefix":  func() error { _, err := db.DeletePrefix("a"); return err },
+		"CompareAndPut": func() error { return db.CompareAndPut("a", 1, 2) },
+		"Keys":          func() error { _, err := db.Keys(); return err },
+		"Count":         func() error { _, err := db.Count(); return err },
+		"ForEach": func() error {
+			return db.ForEach(func(string, func(interface{}) error) error { return nil })
+		},
+		"GetMulti": func() error {
+			return db.GetMulti([]string{"a"}, func() interface{} { return new(int) }, func(string, interface{}, error) {})
+		},
+		"Append":         func() error { _, err := db.Append("l", 1); return err },
+		"Expire":         func() error { return db.Expire("a", time.Hour) },
+		"TTL":            func() error { _, err := db.TTL("a"); return err },
+		"KeysByTag":      func() error { _, err := db.KeysByTag("t"); return err },
+		"ScheduleDelete": func() error { return db.ScheduleDelete("a", time.Hour) },
+		"AcquireLease":   func() error { _, err := db.AcquireLease("lease", "me", time.Hour); return err },
+		"RenamePrefix":   func() error { _, err := db.RenamePrefix("a", "b", false); return err },
+		"Truncate":       func() error { _, err := db.Truncate(); return err },
+		"ExportJSON":     func() error { return db.ExportJSON(&bytes.Buffer{}) },
+		"Ping":           func() error { return db.Ping(ctx) },
+		"RawView":        func() error { return db.RawView(func(*bbolt.Bucket) error { return nil }) },
+		"RawUpdate":      func() error { return db.RawUpdate(func(*bbolt.Bucket) error { return nil }) },
+		"Update": func() error {
+			return db.Update(func(tx *WriteTx) error { return tx.InBucket("test").Put("a", 1) })
+		},
+		"UpdateValidated": func() error {
+			return db.UpdateValidated(func(tx *WriteTx) error { return tx.InBucket("test").Get("a", &v) })
+		},
+	}
+}
+
+// callOp calls op, turning a panic into an error.
+func callOp(op func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return op()
+}
+
+func TestBucketRemoved(t *testing.T) {
+	for _, opts := range [][]Option{
+		nil,
+		{WithReadCache(10), WithDecodedCache(10), WithSingleflight()},
+		{WithFairWrites(0)},
+		{WithQuota(100, 0), WithChangeLog()},
+	} {
+		db := openTestStore(t, opts...)
+		if err := db.Put("a", 1); err != nil {
+			t.Fatal(err)
+		}
+		dropBucket(t, db)
+		for name, op := range bucketOps(db) {
+			if err := callOp(op); !errors.Is(err, ErrNoBucket) {
+				t.Errorf("%s with %d options: got %v, expected ErrNoBucket", name, len(opts), err)
+			}
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBucketRemovedBuffered(t *testing.T) {
+	db := openTestStore(t, WithWriteBuffer(10, 0), WithReadCache(10))
+	if err := db.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// entries read before stay cached, as for other writes through GetDb
+	if err := db.Get("a", nil); err != nil {
+		t.Fatal(err)
+	}
+	dropBucket(t, db)
+	if err := db.Get("a", nil); err != nil {
+		t.Fatalf("got %v, expected the cached entry", err)
+	}
+	if err := db.Get("b", nil); !errors.Is(err, ErrNoBucket) {
+		t.Fatalf("got %v, expected ErrNoBucket", err)
+	}
+	// writes are buffered, and fail once flushed
+	if err := db.Put("b", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get("b", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Flush(); !errors.Is(err, ErrNoBucket) {
+		t.Fatalf("got %v, expected ErrNoBucket", err)
+	}
+}
+
+func TestAutoRecreateBucket(t *testing.T) {
+	db := openTestStore(t, WithAutoRecreateBucket(), WithReadCache(10), WithQuota(3, 0))
+	if err := db.PutAll(map[string]interface{}{"a": 1, "b": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get("a", nil); err != nil {
+		t.Fatal(err)
+	}
+	dropBucket(t, db)
+
+	// reads fail until a write recreates the bucket
+	if err := db.Get("b", nil); !errors.Is(err, ErrNoBucket) {
+		t.Fatalf("got %v, expected ErrNoBucket", err)
+	}
+	if _, err := db.Count(); !errors.Is(err, ErrNoBucket) {
+		t.Fatalf("got %v, expected ErrNoBucket", err)
+	}
+	// a failed write does not recreate it
+	err := db.Update(func(tx *WriteTx) error {
+		if err := tx.InBucket("test").Put("a", 1); err != nil {
+			return err
+		}
+		return ErrBadValue
+	})
+	if err != ErrBadValue {
+		t.Fatalf("got %v, expected ErrBadValue", err)
+	}
+	if _, err := db.Count(); !errors.Is(err, ErrNoBucket) {
+		t.Fatalf("got %v, expected ErrNoBucket", err)
+	}
+
+	if err := db.Delete("a"); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound from the recreated bucket", err)
+	}
+	if err := db.Put("c", 0); err != nil {
+		t.Fatal(err)
+	}
+	// the cached entry and the quota usage went with the bucket
+	if err := db.Get("a", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if err := db.PutAll(map[string]interface{}{"c": 3, "d": 4}); err != nil {
+		t.Fatal(err)
+	}
+	checkValues(t, db, map[string]int{"c": 3, "d": 4})
+
+	// as for the other writes
+	reads := map[string]bool{
+		"Get": true, "GetRaw": true, "GetAny": true, "GetShared": true, "Has": true,
+		"Keys": true, "Count": true, "ForEach": true, "GetMulti": true, "TTL": true,
+		"KeysByTag": true, "ExportJSON": true, "Ping": true, "RawView": true,
+	}
+	for name, op := range bucketOps(db) {
+		if err := db.Put("x", 0); err != nil {
+			t.Fatal(err)
+		}
+		dropBucket(t, db)
+		err := callOp(op)
+		if err != nil && strings.HasPrefix(err.Error(), "panic") || !reads[name] && errors.Is(err, ErrNoBucket) {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
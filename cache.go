@@ -0,0 +1,229 @@
+package bboltkv
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"sync"
+
+	"go.etcd.io/bbolt"
+)
+
+// ErrNoCache is returned by methods that need the read cache when the store
+// was opened without WithReadCache.
+var ErrNoCache = errors.New("bboltkv: read cache not enabled")
+
+// WithReadCache keeps the encoded bytes of up to maxEntries entries in
+// memory, so that Get does not need a transaction for recently read keys.
+// Values still need to be decoded on every Get. Least recently used entries
+// are evicted first, and writes through the store invalidate the entries
+// they touch once they commit. Writes made directly through GetDb() are not
+// seen by the cache.
+func WithReadCache(maxEntries int) Option {
+	return func(o *options) {
+		o.cacheSize = maxEntries
+	}
+}
+
+// WithPreload makes Open call Preload for each of the given prefixes. Open
+// fails with ErrNoCache unless WithReadCache is also given.
+func WithPreload(prefixes ...string) Option {
+	return func(o *options) {
+		o.preload = append(o.preload, prefixes...)
+	}
+}
+
+// CacheStats describes the read cache, see Store.CacheStats.
+type CacheStats struct {
+	Entries int // entries currently cached
+	Hits    int // Gets answered from the cache
+	Misses  int // Gets that had to read the database
+}
+
+// CacheStats returns the current read cache statistics. All fields are zero
+// if the cache is not enabled.
+func (s *Store) CacheStats() CacheStats {
+	if s.cache == nil {
+		return CacheStats{}
+	}
+	return s.cache.stats()
+}
+
+// Preload reads every entry whose key starts with prefix into the read
+// cache, in a single transaction, and returns how many entries were loaded.
+// Entries are loaded in key order. If the prefix holds more entries than the
+// cache can, Preload stops once it has loaded that many, so the first
+// maxEntries keys under the prefix end up cached; entries cached earlier may
+// be evicted to make room. Entries written concurrently with Preload are not
+// loaded. Preload returns ErrNoCache if the cache is not enabled.
+func (s *Store) Preload(prefix string) (int, error) {
+	if s.cache == nil {
+		return 0, ErrNoCache
+	}
+	p, err := s.sealPrefix([]byte(prefix))
+	if err != nil {
+		return 0, err
+	}
+	var keys []string
+	var values [][]byte
+	epoch := s.cache.begin()
+	err = s.view(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(s.bucketName).Cursor()
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			if len(keys) == s.cache.max {
+				break
+			}
+			v, err := s.assemble(tx, k, v)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, string(k))
+			values = append(values, append([]byte(nil), v...))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for i, k := range keys {
+		if s.cache.add(k, values[i], epoch) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// readCache is an LRU cache of encoded values.
+//
+// A reader that misses the cache reads the database and then adds what it
+// read. To keep it from adding a value that a concurrent write has replaced
+// in the meantime, every committed write bumps the cache's epoch and leaves a
+// tombstone carrying that epoch for each key it touched. Readers note the
+// epoch before they start reading, and may only add a key if no tombstone
+// newer than that exists. Tombstones are themselves kept in a bounded LRU;
+// the newest epoch of any tombstone evicted becomes a floor below which
+// readers may not add anything.
+type readCache struct {
+	mu      sync.Mutex
+	max     int
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+	epoch   uint64
+	tombs   *list.List // of *cacheTomb, most recent first
+	tombIdx map[string]*list.Element
+	floor   uint64
+	hits    int
+	misses  int
+}
+
+type cacheEntry struct {
+	key string
+	raw []byte
+}
+
+type cacheTomb struct {
+	key   string
+	epoch uint64
+}
+
+func newReadCache(max int) *readCache {
+	return &readCache{
+		max:     max,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		tombs:   list.New(),
+		tombIdx: make(map[string]*list.Element),
+	}
+}
+
+func (c *readCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		c.hits++
+		return e.Value.(*cacheEntry).raw, true
+	}
+	c.misses++
+	return nil, false
+}
+
+// begin returns the epoch to pass to add for a read about to start.
+func (c *readCache) begin() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.epoch
+}
+
+// add caches the value read for key by a read that started at epoch, and
+// reports whether it did.
+func (c *readCache) add(key string, raw []byte, epoch uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if epoch < c.floor {
+		return false
+	}
+	if t, ok := c.tombIdx[key]; ok && t.Value.(*cacheTomb).epoch > epoch {
+		return false
+	}
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*cacheEntry).raw = raw
+		c.lru.MoveToFront(e)
+		return true
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, raw: raw})
+	for c.lru.Len() > c.max {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*cacheEntry).key)
+	}
+	return true
+}
+
+// invalidate drops the given keys after a write to them committed.
+func (c *readCache) invalidate(keys []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	for _, key := range keys {
+		if e, ok := c.entries[key]; ok {
+			c.lru.Remove(e)
+			delete(c.entries, key)
+		}
+		if t, ok := c.tombIdx[key]; ok {
+			t.Value.(*cacheTomb).epoch = c.epoch
+			c.tombs.MoveToFront(t)
+		} else {
+			c.tombIdx[key] = c.tombs.PushFront(&cacheTomb{key: key, epoch: c.epoch})
+		}
+	}
+	for c.tombs.Len() > c.max {
+		t := c.tombs.Back()
+		c.tombs.Remove(t)
+		tomb := t.Value.(*cacheTomb)
+		delete(c.tombIdx, tomb.key)
+		if tomb.epoch > c.floor {
+			c.floor = tomb.epoch
+		}
+	}
+}
+
+func (c *readCache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Entries: c.lru.Len(), Hits: c.hits, Misses: c.misses}
+}
+
+// clear drops all entries after a write to unknown keys committed. Reads
+// that started before may not add what they read.
+func (c *readCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.epoch++
+	c.floor = c.epoch
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.tombs.Init()
+	c.tombIdx = make(map[string]*list.Element)
+}
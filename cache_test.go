@@ -0,0 +1,201 @@
+package bboltkv
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestReadCache(t *testing.T) {
+	db := openTestStore(t, WithReadCache(10))
+	if err := db.Put("key", "value1"); err != nil {
+		t.Fatal(err)
+	}
+	var val string
+	for i := 0; i < 2; i++ {
+		if err := db.Get("key", &val); err != nil {
+			t.Fatal(err)
+		} else if val != "value1" {
+			t.Fatalf("got \"%s\", expected \"value1\"", val)
+		}
+	}
+	if st := db.CacheStats(); st.Hits != 1 || st.Misses != 1 || st.Entries != 1 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	// writes invalidate
+	if err := db.Put("key", "value2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get("key", &val); err != nil {
+		t.Fatal(err)
+	} else if val != "value2" {
+		t.Fatalf("got \"%s\", expected \"value2\"", val)
+	}
+	if _, err := db.DeleteGetRaw("key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get("key", &val); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	// eviction
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%02d", i)
+		if err := db.Put(key, i); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Get(key, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st := db.CacheStats(); st.Entries != 10 {
+		t.Fatalf("cache holds %d entries, expected 10", st.Entries)
+	}
+}
+
+func TestPreload(t *testing.T) {
+	db := openTestStore(t, WithReadCache(1000))
+	for i := 0; i < 100; i++ {
+		if err := db.Put(fmt.Sprintf("hot:%03d", i), i); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put(fmt.Sprintf("cold:%03d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := db.Preload("hot:"); err != nil {
+		t.Fatal(err)
+	} else if n != 100 {
+		t.Fatalf("preloaded %d entries, expected 100", n)
+	}
+	for i := 0; i < 100; i++ {
+		var val int
+		if err := db.Get(fmt.Sprintf("hot:%03d", i), &val); err != nil {
+			t.Fatal(err)
+		} else if val != i {
+			t.Fatalf("got %d, expected %d", val, i)
+		}
+	}
+	if st := db.CacheStats(); st.Hits != 100 || st.Misses != 0 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+	if err := db.Get("cold:000", nil); err != nil {
+		t.Fatal(err)
+	}
+	if st := db.CacheStats(); st.Misses != 1 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestPreloadOverflow(t *testing.T) {
+	db := openTestStore(t, WithReadCache(10))
+	for i := 0; i < 50; i++ {
+		if err := db.Put(fmt.Sprintf("key%02d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := db.Preload("key"); err != nil {
+		t.Fatal(err)
+	} else if n != 10 {
+		t.Fatalf("preloaded %d entries, expected 10", n)
+	}
+	// the first keys in order are the ones cached
+	for i := 0; i < 10; i++ {
+		if err := db.Get(fmt.Sprintf("key%02d", i), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st := db.CacheStats(); st.Hits != 10 || st.Misses != 0 || st.Entries != 10 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+}
+
+func TestPreloadNoCache(t *testing.T) {
+	db := openTestStore(t)
+	if n, err := db.Preload(""); err != ErrNoCache || n != 0 {
+		t.Fatalf("got %d, %v, expected 0, ErrNoCache", n, err)
+	}
+	name := db.GetDb().Path()
+	db.Close()
+	if _, err := Open(name, "test", WithPreload("")); err != ErrNoCache {
+		t.Fatalf("got %v, expected ErrNoCache", err)
+	}
+}
+
+func TestWithPreload(t *testing.T) {
+	db := openTestStore(t)
+	for i := 0; i < 10; i++ {
+		if err := db.Put(fmt.Sprintf("key%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	name := db.GetDb().Path()
+	db.Close()
+	db, err := Open(name, "test", WithReadCache(100), WithPreload("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if st := db.CacheStats(); st.Entries != 10 {
+		t.Fatalf("cache holds %d entries, expected 10", st.Entries)
+	}
+}
+
+func TestPreloadConcurrentWrites(t *testing.T) {
+	db := openTestStore(t, WithReadCache(1000))
+	const keys = 20
+	for i := 0; i < keys; i++ {
+		if err := db.Put(fmt.Sprintf("key%02d", i), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := db.Preload("key"); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			var val int
+			if err := db.Get("key00", &val); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for round := 1; round <= 50; round++ {
+		for i := 0; i < keys; i++ {
+			if err := db.Put(fmt.Sprintf("key%02d", i), round); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	close(stop)
+	wg.Wait()
+	// the cache must not hold anything older than the last write
+	for i := 0; i < keys; i++ {
+		var val int
+		if err := db.Get(fmt.Sprintf("key%02d", i), &val); err != nil {
+			t.Fatal(err)
+		} else if val != 50 {
+			t.Fatalf("key%02d: got stale value %d", i, val)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package bboltkv
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+)
+
+// ErrNotCanonical is returned when a value cannot be encoded canonically,
+// see WithCanonicalEncoding.
+var ErrNotCanonical = errors.New("bboltkv: value cannot be encoded canonically")
+
+// WithCanonicalEncoding makes Put, Encode and the other writing methods
+// encode equal values to equal bytes, so that comparisons of encoded
+// values, such as the one CompareAndPut makes, work for values holding
+// maps. Plain gob writes map entries in Go's random iteration order, and
+// numbers types by the order a process first encodes them in; the
+// canonical encoding sorts map entries by their encoded keys and numbers
+// types by their position within the value, so the result does not depend
+// on the process either.
+//
+// The result is still a gob stream, so values can be read by any store,
+// whether it was opened with this option or not. Values holding non-nil
+// interface values cannot be encoded canonically, as their encoding names
+// their dynamic type, and make the writing methods fail with
+// ErrNotCanonical. For values made up mostly of maps, encoding canonically
+// takes about three times as long as plain gob.
+func WithCanonicalEncoding() Option {
+	return func(o *options) {
+		o.canonical = true
+	}
+}
+
+// canonicalGob rewrites a gob stream holding a single value into its
+// canonical form.
+func canonicalGob(raw []byte) ([]byte, error) {
+	types, id, body, err := readGobTypes(raw)
+	if err != nil {
+		return nil, err
+	}
+	c := &canonicalizer{types: types, ids: make(map[int64]int64), r: &gobReader{buf: body}}
+	c.number(id)
+
+	var value gobWriter
+	value.int(c.id(id))
+	if def := types[id]; def == nil || def.kind != gobStructT {
+		// a single value, as gob sends it: field delta 0, then the value
+		if c.r.uint() != 0 {
+			return nil, errGobFormat
+		}
+		value.uint(0)
+	}
+	if err := c.value(id, &value); err != nil {
+		return nil, err
+	}
+
+	var out gobWriter
+	for _, old := range c.order {
+		var def gobWriter
+		def.int(-c.id(old))
+		c.wireType(old, &def)
+		out.message(def.buf)
+	}
+	out.message(value.buf)
+	return out.buf, nil
+}
+
+// canonicalizer renumbers the types of a gob stream and sorts its maps.
+type canonicalizer struct {
+	types gobTypes
+	ids   map[int64]int64 // old type id -> new type id
+	order []int64         // old type ids, in the order of their new ids
+	r     *gobReader
+}
+
+// gobFirstUserID is the first type id gob assigns to types it defines.
+const gobFirstUserID = 64
+
+// number assigns new type ids to id and the types it refers to, depth
+// first.
+func (c *canonicalizer) number(id int64) {
+	def := c.types[id]
+	if def == nil {
+		return
+	} else if _, ok := c.ids[id]; ok {
+		return
+	}
+	c.ids[id] = int64(gobFirstUserID + len(c.order))
+	c.order = append(c.order, id)
+	switch def.kind {
+	case gobArrayT, gobSliceT:
+		c.number(def.elem)
+	case gobMapT:
+		c.number(def.key)
+		c.number(def.elem)
+	case gobStructT:
+		for _, f := range def.fields {
+			c.number(f.id)
+		}
+	}
+}
+
+// id returns the new id of a type. Predefined types keep theirs.
+func (c *canonicalizer) id(old int64) int64 {
+	if id, ok := c.ids[old]; ok {
+		return id
+	}
+	return old
+}
+
+// wireType writes the definition of a type, using new type ids.
+func (c *canonicalizer) wireType(old int64, w *gobWriter) {
+	def := c.types[old]
+	w.uint(uint64(def.kind + 1))
+	// CommonType
+	w.uint(1)
+	if def.name != "" {
+		w.uint(1)
+		w.string(def.name)
+		w.uint(1)
+	} else {
+		w.uint(2)
+	}
+	w.int(c.id(old))
+	w.uint(0)
+	switch def.kind {
+	case gobArrayT:
+		w.uint(1)
+		w.int(c.id(def.elem))
+		if def.length != 0 {
+			w.uint(1)
+			w.int(def.length)
+		}
+	case gobSliceT:
+		w.uint(1)
+		w.int(c.id(def.elem))
+	case gobMapT:
+		w.uint(1)
+		w.int(c.id(def.key))
+		w.uint(1)
+		w.int(c.id(def.elem))
+	case gobStructT:
+		if len(def.fields) > 0 {
+			w.uint(1)
+			w.uint(uint64(len(def.fields)))
+			for _, f := range def.fields {
+				w.uint(1)
+				w.string(f.name)
+				w.uint(1)
+				w.int(c.id(f.id))
+				w.uint(0)
+			}
+		}
+	}
+	w.uint(0) // end of the kind's struct
+	w.uint(0) // end of wireType
+}
+
+// value copies a value of the given type from the reader to w.
+func (c *canonicalizer) value(id int64, w *gobWriter) error {
+	r := c.r
+	switch id {
+	case gobBool, gobInt, gobUint, gobFloat:
+		w.uint(r.uint())
+		return r.err
+	case gobComplex:
+		w.uint(r.uint())
+		w.uint(r.uint())
+		return r.err
+	case gobBytes, gobString:
+		w.string(r.string())
+		return r.err
+	case gobInterface:
+		if r.uint() != 0 {
+			return ErrNotCanonical
+		}
+		w.uint(0)
+		return r.err
+	}
+	def := c.types[id]
+	if def == nil {
+		return errGobFormat
+	}
+	switch def.kind {
+	case gobArrayT, gobSliceT:
+		n := r.uint()
+		w.uint(n)
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			if err := c.value(def.elem, w); err != nil {
+				return err
+			}
+		}
+	case gobMapT:
+		n := r.uint()
+		entries := make([]mapEntry, 0, n)
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			var k, v gobWriter
+			if err := c.value(def.key, &k); err != nil {
+				return err
+			}
+			if err := c.value(def.elem, &v); err != nil {
+				return err
+			}
+			entries = append(entries, mapEntry{k.buf, v.buf})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return bytes.Compare(entries[i].key, entries[j].key) < 0
+		})
+		w.uint(n)
+		for _, e := range entries {
+			w.buf = append(append(w.buf, e.key...), e.value...)
+		}
+	case gobStructT:
+		field := -1
+		for r.err == nil {
+			delta := r.uint()
+			w.uint(delta)
+			if delta == 0 {
+				break
+			}
+			field += int(delta)
+			if field >= len(def.fields) {
+				return errGobFormat
+			}
+			if err := c.value(def.fields[field].id, w); err != nil {
+				return err
+			}
+		}
+	default:
+		// types with their own encoding are sent as bytes
+		w.string(r.string())
+	}
+	return r.err
+}
+
+type mapEntry struct {
+	key, value []byte
+}
+
+// gobWriter writes the parts of gob's encoding read by gobReader.
+type gobWriter struct {
+	buf []byte
+}
+
+func (w *gobWriter) uint(x uint64) {
+	if x < 0x80 {
+		w.buf = append(w.buf, byte(x))
+		return
+	}
+	var b [8]byte
+	n := 8
+	for ; x > 0; x >>= 8 {
+		n--
+		b[n] = byte(x)
+	}
+	w.buf = append(append(w.buf, byte(-int8(8-n))), b[n:]...)
+}
+
+func (w *gobWriter) int(i int64) {
+	if i < 0 {
+		w.uint(uint64(^i)<<1 | 1)
+	} else {
+		w.uint(uint64(i) << 1)
+	}
+}
+
+func (w *gobWriter) string(s string) {
+	w.uint(uint64(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+// message writes msg preceded by its length.
+func (w *gobWriter) message(msg []byte) {
+	w.uint(uint64(len(msg)))
+	w.buf = append(w.buf, msg...)
+}
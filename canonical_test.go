@@ -0,0 +1,180 @@
+package bboltkv
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type canonicalDoc struct {
+	Title  string
+	Counts map[string]int
+	Nested map[string]map[int][]string
+	When   time.Time
+	Nil    interface{}
+	Next   *canonicalDoc
+}
+
+func makeCanonicalDoc() canonicalDoc {
+	doc := canonicalDoc{
+		Title:  "doc",
+		Counts: make(map[string]int),
+		Nested: make(map[string]map[int][]string),
+		When:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		Next:   &canonicalDoc{Title: "next", Counts: map[string]int{"a": 1, "b": 2}},
+	}
+	for i := 0; i < 50; i++ {
+		doc.Counts[fmt.Sprint("key", i)] = i
+		doc.Nested[fmt.Sprint("outer", i)] = map[int][]string{i: {"x"}, -i: {"y", "z"}}
+	}
+	return doc
+}
+
+func TestCanonicalEncoding(t *testing.T) {
+	db := openTestStore(t, WithCanonicalEncoding())
+	doc := makeCanonicalDoc()
+	first, err := db.Encode(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		raw, err := db.Encode(makeCanonicalDoc())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(raw, first) {
+			t.Fatal("equal values were encoded differently")
+		}
+	}
+	// the result is a plain gob stream
+	var out canonicalDoc
+	if err := (&Store{}).decode(first, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, doc) {
+		t.Fatalf("got %+v, expected %+v", out, doc)
+	}
+	for _, v := range []interface{}{42, "text", []int{3, 1}, map[int]bool{2: true, 1: false}} {
+		raw, err := db.Encode(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := reflect.New(reflect.TypeOf(v))
+		if err := db.decode(raw, out.Interface()); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(out.Elem().Interface(), v) {
+			t.Fatalf("got %v, expected %v", out.Elem(), v)
+		}
+	}
+}
+
+func TestCanonicalTypeIDs(t *testing.T) {
+	// Two types gob sees as the same, numbered at different times.
+	type T struct{ M map[string]int }
+	var first bytes.Buffer
+	if err := encodeGob(&first, T{M: map[string]int{"a": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	encodeLater := func() ([]byte, []byte) {
+		type T struct{ M map[string]int }
+		var buf bytes.Buffer
+		if err := encodeGob(&buf, T{M: map[string]int{"a": 1}}); err != nil {
+			t.Fatal(err)
+		}
+		canonical, err := canonicalGob(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes(), canonical
+	}
+	later, laterCanonical := encodeLater()
+	if bytes.Equal(first.Bytes(), later) {
+		t.Fatal("plain gob numbered the types the same; the test proves nothing")
+	}
+	firstCanonical, err := canonicalGob(first.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(firstCanonical, laterCanonical) {
+		t.Fatalf("got %x and %x, expected equal encodings", firstCanonical, laterCanonical)
+	}
+}
+
+func TestCanonicalInterface(t *testing.T) {
+	db := openTestStore(t, WithCanonicalEncoding())
+	if err := db.Put("key", map[string]interface{}{"a": 1}); err != ErrNotCanonical {
+		t.Fatalf("got %v, expected ErrNotCanonical", err)
+	}
+	if err := db.Put("key", map[string]interface{}{"a": nil}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompareAndPutCanonical(t *testing.T) {
+	conflicts := func(opts ...Option) int {
+		db := openTestStore(t, opts...)
+		n := 0
+		for i := 0; i < 20; i++ {
+			if err := db.Put("key", makeCanonicalDoc()); err != nil {
+				t.Fatal(err)
+			}
+			err := db.CompareAndPut("key", makeCanonicalDoc(), "new")
+			if err == ErrConflict {
+				n++
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return n
+	}
+	if n := conflicts(WithCanonicalEncoding()); n != 0 {
+		t.Fatalf("got %d conflicts with canonical encoding, expected none", n)
+	}
+	if n := conflicts(); n == 0 {
+		t.Fatal("plain gob never conflicted; the test proves nothing")
+	}
+
+	db := openTestStore(t, WithCanonicalEncoding())
+	if err := db.CompareAndPut("key", 1, 2); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if err := db.Put("key", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CompareAndPut("key", 3, 2); err != ErrConflict {
+		t.Fatalf("got %v, expected ErrConflict", err)
+	}
+	if err := db.CompareAndPut("key", 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	var val int
+	if err := db.Get("key", &val); err != nil || val != 2 {
+		t.Fatalf("got %d, %v, expected 2", val, err)
+	}
+}
+
+func benchmarkEncodeMap(b *testing.B, opts ...Option) {
+	db := openTestStore(b, opts...)
+	doc := makeCanonicalDoc()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := db.Encode(doc); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeMapGob(b *testing.B) {
+	benchmarkEncodeMap(b)
+}
+
+func BenchmarkEncodeMapCanonical(b *testing.B) {
+	benchmarkEncodeMap(b, WithCanonicalEncoding())
+}
+
+func encodeGob(buf *bytes.Buffer, v interface{}) error {
+	return gob.NewEncoder(buf).Encode(v)
+}
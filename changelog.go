@@ -0,0 +1,192 @@
+package bboltkv
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"go.etcd.io/bbolt"
+)
+
+const changeLogBucket = "changelog"
+
+// ErrLogTrimmed is returned by Changes when entries after the requested
+// sequence number have been removed by TrimChangeLog.
+var ErrLogTrimmed = errors.New("bboltkv: change log has been trimmed")
+
+// Change is an entry of the change log, see WithChangeLog.
+type Change struct {
+	Seq     uint64 // position in the log, starting at 1
+	Key     string
+	Value   []byte // the encoded value as stored, nil for deletions
+	Deleted bool
+	Meta    map[string]string // attached to the write, see WithChangeLogMeta
+}
+
+// WithChangeLog makes the store record every write and deletion of an
+// entry in a change log, in the same transaction, so that the changes can
+// be replayed elsewhere, see Changes, ServeReplication and WatchState.
+// Only keys and values are logged, and with WithChangeLogMeta, the
+// metadata of the writes: TTLs, tags and the contents of lists are not,
+// though deletions by expiry sweeps are. The log grows until trimmed with
+// TrimChangeLog, or pruned as WithJournalRetention says.
+func WithChangeLog() Option {
+	return func(o *options) {
+		o.changeLog = true
+	}
+}
+
+func changeKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
+
+// logChange appends a change to the change log. raw is nil for deletions.
+// Changes are logged as 'd' followed by the key, or 'p' followed by the
+// length of the key as a uvarint, the key and the value; with metadata, see
+// WithChangeLogMeta, as 'D' or 'P' with the metadata before the key.
+func (w *wtx) logChange(key string, raw []byte) error {
+	b, err := w.aux(changeLogBucket)
+	if err != nil {
+		return err
+	}
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	var v []byte
+	meta := w.s.opts.changeLogMeta && w.meta != nil
+	if raw == nil {
+		v = []byte{'d'}
+		if meta {
+			v = encodeMeta([]byte{'D'}, w.meta)
+		}
+		v = append(v, key...)
+	} else {
+		v = make([]byte, 1, 1+binary.MaxVarintLen64+len(key)+len(raw))
+		v[0] = 'p'
+		if meta {
+			v = encodeMeta(append(v[:0], 'P'), w.meta)
+		}
+		v = appendUvarint(v, uint64(len(key)))
+		v = append(append(v, key...), raw...)
+	}
+	if err := b.Put(changeKey(seq), v); err != nil {
+		return err
+	}
+	if !w.logged {
+		w.logged = true
+		w.tx.OnCommit(func() { w.s.logWaits.published(changeLogBucket) })
+	}
+	return w.journaled(changeLogBucket, seq)
+}
+
+func decodeChange(k, v []byte) (Change, error) {
+	c := Change{Seq: binary.BigEndian.Uint64(k)}
+	if len(v) == 0 {
+		return c, ErrCorrupt
+	}
+	kind := v[0]
+	v = v[1:]
+	if kind == 'D' || kind == 'P' {
+		var err error
+		if c.Meta, v, err = decodeMeta(v); err != nil {
+			return c, err
+		}
+		kind += 'a' - 'A'
+	}
+	if kind == 'd' {
+		c.Key, c.Deleted = string(v), true
+		return c, nil
+	}
+	if kind != 'p' {
+		return c, ErrCorrupt
+	}
+	n, l := binary.Uvarint(v)
+	if l <= 0 || uint64(len(v)-l) < n {
+		return c, ErrCorrupt
+	}
+	v = v[l:]
+	c.Key = string(v[:n])
+	c.Value = append([]byte(nil), v[n:]...)
+	return c, nil
+}
+
+// changeLogState returns the sequence number of the last change logged, and
+// the first one still in the log, which is last+1 if the log is empty.
+func (s *Store) changeLogState(tx *bbolt.Tx) (last, first uint64) {
+	b := s.aux(tx, changeLogBucket)
+	if b == nil {
+		return 0, 1
+	}
+	last = b.Sequence()
+	if k, _ := b.Cursor().First(); k != nil {
+		return last, binary.BigEndian.Uint64(k)
+	}
+	return last, last + 1
+}
+
+// Changes calls fn for every change logged after the given sequence number,
+// in order, reading them in a single transaction. Returning an error from
+// fn stops the iteration and returns that error. If changes after since
+// have been trimmed from the log, Changes returns ErrLogTrimmed without
+// calling fn; so it does if since lies ahead of the log.
+func (s *Store) Changes(since uint64, fn func(c Change) error) error {
+	return s.view(func(tx *bbolt.Tx) error {
+		return s.changes(tx, since, -1, func(c Change) error {
+			var err error
+			if c.Key, err = s.openKey([]byte(c.Key)); err != nil {
+				return err
+			}
+			return fn(c)
+		})
+	})
+}
+
+// changes implements Changes, handing at most limit changes to fn, or all
+// of them if limit is negative.
+func (s *Store) changes(tx *bbolt.Tx, since uint64, limit int, fn func(c Change) error) error {
+	last, first := s.changeLogState(tx)
+	if since+1 < first || since > last {
+		return ErrLogTrimmed
+	}
+	b := s.aux(tx, changeLogBucket)
+	if b == nil {
+		return nil
+	}
+	c := b.Cursor()
+	for k, v := c.Seek(changeKey(since + 1)); k != nil && limit != 0; k, v = c.Next() {
+		change, err := decodeChange(k, v)
+		if err != nil {
+			return err
+		}
+		if err := fn(change); err != nil {
+			return err
+		}
+		limit--
+	}
+	return nil
+}
+
+// TrimChangeLog removes the changes up to and including the given sequence
+// number from the change log, and returns how many it removed. Readers
+// that have not seen them yet get ErrLogTrimmed from Changes, and replicas
+// fall back to a full copy.
+func (s *Store) TrimChangeLog(upTo uint64) (int, error) {
+	n := 0
+	err := s.update(func(w *wtx) error {
+		b := s.aux(w.tx, changeLogBucket)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) <= upTo; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
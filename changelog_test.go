@@ -0,0 +1,81 @@
+package bboltkv
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestChangeLog(t *testing.T) {
+	db := openTestStore(t, WithChangeLog())
+	if err := db.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutAll(map[string]interface{}{"b": 2, "c": 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	collect := func(c Change) error {
+		if c.Deleted != (c.Value == nil) {
+			return fmt.Errorf("change %d: deleted %v with value %q", c.Seq, c.Deleted, c.Value)
+		}
+		got = append(got, fmt.Sprintf("%d %s %v", c.Seq, c.Key, c.Deleted))
+		return nil
+	}
+	if err := db.Changes(0, collect); err != nil {
+		t.Fatal(err)
+	}
+	want := "[1 a false 2 b false 3 c false 4 a true]"
+	if fmt.Sprint(got) != want {
+		t.Fatalf("got %v, expected %v", got, want)
+	}
+
+	got = nil
+	if err := db.Changes(2, collect); err != nil {
+		t.Fatal(err)
+	} else if fmt.Sprint(got) != "[3 c false 4 a true]" {
+		t.Fatalf("got %v", got)
+	}
+
+	if n, err := db.TrimChangeLog(2); err != nil || n != 2 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	if err := db.Changes(1, collect); err != ErrLogTrimmed {
+		t.Fatalf("got %v, expected ErrLogTrimmed", err)
+	}
+	if err := db.Changes(5, collect); err != ErrLogTrimmed {
+		t.Fatalf("ahead of the log: got %v, expected ErrLogTrimmed", err)
+	}
+	got = nil
+	if err := db.Changes(2, collect); err != nil || len(got) != 2 {
+		t.Fatalf("got %v, %v", got, err)
+	}
+
+	// fully trimmed, the log continues from where it was
+	if _, err := db.TrimChangeLog(10); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Changes(3, collect); err != ErrLogTrimmed {
+		t.Fatalf("got %v, expected ErrLogTrimmed", err)
+	}
+	if err := db.Put("d", 4); err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	if err := db.Changes(4, collect); err != nil || fmt.Sprint(got) != "[5 d false]" {
+		t.Fatalf("got %v, %v", got, err)
+	}
+}
+
+func TestChangeLogOff(t *testing.T) {
+	db := openTestStore(t)
+	if err := db.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	if err := db.Changes(0, func(Change) error { n++; return nil }); err != nil || n != 0 {
+		t.Fatalf("got %d changes, %v", n, err)
+	}
+}
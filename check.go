@@ -0,0 +1,119 @@
+package bboltkv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+// CheckMode selects how thoroughly the database file is verified at Open
+// time. See WithCheckOnOpen.
+type CheckMode int
+
+const (
+	// CheckOff performs no verification beyond what bbolt itself does.
+	CheckOff CheckMode = iota
+
+	// CheckFast verifies that both meta pages are present and that the
+	// freelist agrees with the pages marked free in the file. It reads every
+	// page header, but does not walk the b-trees.
+	CheckFast
+
+	// CheckFull runs bbolt's complete consistency check, walking every page
+	// reachable from every bucket. This can take minutes on large files; see
+	// WithBackgroundCheck.
+	CheckFull
+)
+
+// ErrCorrupt is wrapped by the error reported when a consistency check
+// finds problems in the database file.
+var ErrCorrupt = errors.New("bboltkv: database is corrupt")
+
+// checkOnOpen runs the configured consistency check, unless it is to run in
+// the background.
+func (s *Store) checkOnOpen() error {
+	switch {
+	case s.opts.checkMode == CheckFull && s.opts.checkBackground:
+		return nil
+	case s.opts.checkMode == CheckFull:
+		// Nobody else can write yet, so a read transaction is enough.
+		return s.db.View(checkFull)
+	case s.opts.checkMode == CheckFast:
+		return s.db.View(checkFast)
+	}
+	return nil
+}
+
+func (s *Store) backgroundCheck() {
+	// Once the store is in use, bbolt's check is only safe from within a
+	// writable transaction, unless nobody can write to the file.
+	check := s.db.Update
+	if s.db.IsReadOnly() {
+		check = s.db.View
+	} else {
+		s.freezer.hold(context.Background(), false)
+		defer s.freezer.release()
+	}
+	err := check(checkFull)
+	if err != nil && s.opts.checkReadOnly {
+		s.setReadOnly()
+	}
+	if s.opts.checkCallback != nil {
+		s.opts.checkCallback(err)
+	}
+}
+
+func checkFull(tx *bbolt.Tx) error {
+	var first error
+	n := 0
+	for err := range tx.Check() {
+		if first == nil {
+			first = err
+		}
+		n++
+	}
+	return corruptError(first, n)
+}
+
+func checkFast(tx *bbolt.Tx) error {
+	var first error
+	n := 0
+	report := func(err error) {
+		if first == nil {
+			first = err
+		}
+		n++
+	}
+	for id := 0; id < 2; id++ {
+		if p, err := tx.Page(id); err != nil {
+			return err
+		} else if p == nil || p.Type != "meta" {
+			report(fmt.Errorf("page %d: not a meta page", id))
+		}
+	}
+	pages := int(tx.Size()) / tx.DB().Info().PageSize
+	free := 0
+	for id := 2; id < pages; id++ {
+		if p, err := tx.Page(id); err != nil {
+			return err
+		} else if p != nil && p.Type == "free" {
+			free++
+		}
+	}
+	if stats := tx.DB().Stats(); stats.FreePageN+stats.PendingPageN != free {
+		report(fmt.Errorf("freelist holds %d pages, but %d pages are marked free",
+			stats.FreePageN+stats.PendingPageN, free))
+	}
+	return corruptError(first, n)
+}
+
+func corruptError(first error, n int) error {
+	if n == 0 {
+		return nil
+	} else if n == 1 {
+		return fmt.Errorf("%w: %v", ErrCorrupt, first)
+	}
+	return fmt.Errorf("%w: %v (and %d more problems)", ErrCorrupt, first, n-1)
+}
@@ -0,0 +1,153 @@
+package bboltkv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// makeCheckFixture creates a store holding enough data to need several leaf
+// pages, and closes it again.
+func makeCheckFixture(t *testing.T, name string) {
+	os.RemoveAll(name)
+	db, err := Open(name, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		if err := db.Put(fmt.Sprintf("key%03d", i), strings.Repeat("v", 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// corruptLeaf overwrites the page id in the header of one leaf page. Reads
+// still work, but bbolt's check notices the page is out of place.
+func corruptLeaf(t *testing.T, name string) {
+	db, err := bbolt.Open(name, 0640, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageSize := db.Info().PageSize
+	leaf := -1
+	err = db.View(func(tx *bbolt.Tx) error {
+		for id := 2; ; id++ {
+			p, err := tx.Page(id)
+			if err != nil || p == nil {
+				return err
+			} else if p.Type == "leaf" {
+				leaf = id
+			}
+		}
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	} else if leaf < 0 {
+		t.Fatal("no leaf page found")
+	}
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	garbage := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}
+	if _, err := f.WriteAt(garbage, int64(leaf*pageSize)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckOnOpenClean(t *testing.T) {
+	name := "test.db"
+	makeCheckFixture(t, name)
+	defer os.RemoveAll(name)
+	for _, mode := range []CheckMode{CheckOff, CheckFast, CheckFull} {
+		db, err := Open(name, name, WithCheckOnOpen(mode))
+		if err != nil {
+			t.Fatalf("mode %d: %v", mode, err)
+		}
+		var val string
+		if err := db.Get("key000", &val); err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+	}
+}
+
+func TestCheckOnOpenCorrupt(t *testing.T) {
+	name := "test.db"
+	makeCheckFixture(t, name)
+	defer os.RemoveAll(name)
+	corruptLeaf(t, name)
+
+	if _, err := Open(name, name, WithCheckOnOpen(CheckFull)); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("got %v, expected ErrCorrupt", err)
+	}
+	// without a check the damage goes unnoticed
+	db, err := Open(name, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+}
+
+func TestCheckOnOpenBackground(t *testing.T) {
+	name := "test.db"
+	makeCheckFixture(t, name)
+	defer os.RemoveAll(name)
+
+	// clean file
+	result := make(chan error, 1)
+	callback := func(err error) { result <- err }
+	db, err := Open(name, name, WithCheckOnOpen(CheckFull), WithBackgroundCheck(callback, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("callback did not fire")
+	}
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// corrupted file
+	corruptLeaf(t, name)
+	db, err = Open(name, name, WithCheckOnOpen(CheckFull), WithBackgroundCheck(callback, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrCorrupt) {
+			t.Fatalf("got %v, expected ErrCorrupt", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("callback did not fire")
+	}
+	if err := db.Put("key", "value"); err != ErrReadOnly {
+		t.Fatalf("put returned %v, expected ErrReadOnly", err)
+	}
+	if err := db.Delete("key"); err != ErrReadOnly {
+		t.Fatalf("delete returned %v, expected ErrReadOnly", err)
+	}
+	// reads keep working
+	var val string
+	if err := db.Get("key000", &val); err != nil {
+		t.Fatal(err)
+	}
+}
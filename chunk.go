@@ -0,0 +1,339 @@
+package bboltkv
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+const chunksBucket = "chunks"
+
+// defaultChunkSize is the chunk size used when WithChunkThreshold is given
+// without WithChunkSize.
+const defaultChunkSize = 64 << 10
+
+// WithChunkThreshold makes the store split encoded values larger than the
+// given number of bytes into chunks, see WithChunkSize, so that writing a
+// large value does not need a run of free pages as long as the value, and
+// reading one key does not drag large neighbours into memory. The entry
+// then only holds a small header; reads reassemble the value, so nothing
+// changes for callers. Values are not chunked by default.
+//
+// Changing the threshold or the chunk size only affects values written
+// afterwards. Rechunk and RechunkAll rewrite existing values to match.
+func WithChunkThreshold(bytes int) Option {
+	return func(o *options) {
+		o.chunkThreshold = bytes
+	}
+}
+
+// WithChunkSize sets the size of the chunks values are split into, see
+// WithChunkThreshold. The default is 64 KiB.
+func WithChunkSize(bytes int) Option {
+	return func(o *options) {
+		o.chunkSize = bytes
+	}
+}
+
+// chunkSize returns the size of new chunks.
+func (s *Store) chunkSize() int {
+	if s.opts.chunkSize > 0 {
+		return s.opts.chunkSize
+	}
+	return defaultChunkSize
+}
+
+// chunkCount returns the number of chunks a value of n bytes is written in,
+// 0 if it is stored whole.
+func (s *Store) chunkCount(n int) int {
+	if s.opts.chunkThreshold <= 0 || n <= s.opts.chunkThreshold {
+		return 0
+	}
+	size := s.chunkSize()
+	return (n + size - 1) / size
+}
+
+// chunkKey returns the key of chunk i of the value stored under key. The
+// index has a fixed width, so the key can always be split again.
+func chunkKey(key []byte, i int) []byte {
+	ck := make([]byte, len(key)+4)
+	copy(ck, key)
+	binary.BigEndian.PutUint32(ck[len(key):], uint32(i))
+	return ck
+}
+
+// chunkHeader parses the header a chunked value leaves in its entry: the
+// tag, then the total size and the number of chunks, as uvarints. ok is
+// false if v is not such a header.
+func chunkHeader(v []byte) (total int64, count int, ok bool) {
+	if len(v) == 0 || v[0] != tagChunked {
+		return 0, 0, false
+	}
+	t, n := binary.Uvarint(v[1:])
+	if n <= 0 {
+		return 0, 0, false
+	}
+	c, m := binary.Uvarint(v[1+n:])
+	if m <= 0 {
+		return 0, 0, false
+	}
+	return int64(t), int(c), true
+}
+
+// assemble returns the value stored in the entry v of key, reading its
+// chunks if it was chunked, or the deduplicated value it refers to. The
+// result is only valid for the lifetime of the transaction.
+func (s *Store) assemble(tx *bbolt.Tx, key, v []byte) ([]byte, error) {
+	total, count, ok := chunkHeader(v)
+	if !ok {
+		return s.resolveBlob(tx, key, v)
+	}
+	b := s.aux(tx, chunksBucket)
+	raw := make([]byte, 0, total)
+	for i := 0; i < count; i++ {
+		var chunk []byte
+		if b != nil {
+			chunk = b.Get(chunkKey(key, i))
+		}
+		if chunk == nil {
+			return nil, fmt.Errorf("%w: key %q: chunk %d of %d is missing", ErrCorrupt, key, i, count)
+		}
+		raw = append(raw, chunk...)
+	}
+	if int64(len(raw)) != total {
+		return nil, fmt.Errorf("%w: key %q: chunks hold %d bytes, expected %d", ErrCorrupt, key, len(raw), total)
+	}
+	return raw, nil
+}
+
+// store writes raw under key, deduplicated or split into chunks if it is
+// large enough. Any chunks or deduplicated value the key had must have been
+// dropped already.
+func (w *wtx) store(key string, raw []byte) error {
+	if w.s.opts.dedup && len(raw) > dedupThreshold {
+		return w.storeBlob(key, raw)
+	}
+	count := w.s.chunkCount(len(raw))
+	if count == 0 {
+		return w.b.Put([]byte(key), raw)
+	}
+	b, err := w.aux(chunksBucket)
+	if err != nil {
+		return err
+	}
+	size := w.s.chunkSize()
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(raw) {
+			end = len(raw)
+		}
+		if err := b.Put(chunkKey([]byte(key), i), raw[i*size:end]); err != nil {
+			return err
+		}
+	}
+	header := []byte{tagChunked}
+	header = appendUvarint(header, uint64(len(raw)))
+	header = appendUvarint(header, uint64(count))
+	return w.b.Put([]byte(key), header)
+}
+
+// dropChunks deletes the chunks of the value stored under key, if any.
+func (w *wtx) dropChunks(key string) error {
+	_, count, ok := chunkHeader(w.b.Get([]byte(key)))
+	if !ok {
+		return nil
+	}
+	b := w.s.aux(w.tx, chunksBucket)
+	if b == nil {
+		return nil
+	}
+	for i := 0; i < count; i++ {
+		if err := b.Delete(chunkKey([]byte(key), i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ChunkInfo reports how the value stored under key is kept: in how many
+// chunks, 0 if it is stored whole, and its encoded size. If no such key is
+// present in the store, it returns ErrNotFound.
+func (s *Store) ChunkInfo(key string) (chunks int, totalBytes int64, err error) {
+	key = s.sealKey(key)
+	err = s.view(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(s.bucketName).Get([]byte(key))
+		if v == nil || s.expired(tx, key) {
+			return ErrNotFound
+		}
+		if total, count, ok := chunkHeader(v); ok {
+			chunks, totalBytes = count, total
+		} else {
+			totalBytes = int64(len(v))
+		}
+		return nil
+	})
+	return chunks, totalBytes, err
+}
+
+// Rechunk rewrites the value stored under key to match the store's current
+// WithChunkThreshold and WithChunkSize, if it does not already. The value
+// itself, its expiry, tags and timestamp are left as they are. If no such
+// key is present in the store, it returns ErrNotFound.
+func (s *Store) Rechunk(key string) error {
+	key = s.sealKey(key)
+	return s.update(func(w *wtx) error {
+		if w.b.Get([]byte(key)) == nil {
+			return ErrNotFound
+		}
+		_, err := w.rechunk([]byte(key))
+		return err
+	})
+}
+
+// RechunkAll rewrites all values that do not match the store's current
+// WithChunkThreshold and WithChunkSize, like Rechunk, in a single
+// transaction, and returns how many it rewrote.
+func (s *Store) RechunkAll() (int, error) {
+	var keys [][]byte
+	err := s.view(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(s.bucketName).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if !s.chunkedRight(v) {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+		}
+		return nil
+	})
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+	n := 0
+	err = s.update(func(w *wtx) error {
+		n = 0
+		for _, k := range keys {
+			done, err := w.rechunk(k)
+			if err != nil {
+				return err
+			} else if done {
+				n++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// chunkedRight reports whether the entry v is chunked as a value of its
+// size would be written now.
+func (s *Store) chunkedRight(v []byte) bool {
+	if _, ok := blobRef(v); ok {
+		// deduplicated values are never chunked
+		return true
+	}
+	total, count, ok := chunkHeader(v)
+	if !ok {
+		return s.chunkCount(len(v)) == 0
+	}
+	return s.chunkCount(int(total)) == count
+}
+
+// rechunk rewrites the entry of key if it is not chunked right, and
+// reports whether it did.
+func (w *wtx) rechunk(key []byte) (bool, error) {
+	v := w.b.Get(key)
+	if v == nil || w.s.chunkedRight(v) {
+		return false, nil
+	}
+	raw, err := w.s.assemble(w.tx, key, v)
+	if err != nil {
+		return false, err
+	}
+	raw = append([]byte(nil), raw...)
+	if err := w.dropChunks(string(key)); err != nil {
+		return false, err
+	}
+	return true, w.store(string(key), raw)
+}
+
+// OrphanChunks looks for chunks that belong to no value, which a crash or a
+// bug may have left behind, and returns how many it found. If remove is
+// set, it also deletes them.
+func (s *Store) OrphanChunks(remove bool) (int, error) {
+	var orphans [][]byte
+	find := func(tx *bbolt.Tx) error {
+		b := s.aux(tx, chunksBucket)
+		if b == nil {
+			return nil
+		}
+		main := tx.Bucket(s.bucketName)
+		return b.ForEach(func(ck, _ []byte) error {
+			if len(ck) < 4 {
+				orphans = append(orphans, append([]byte(nil), ck...))
+				return nil
+			}
+			key, i := ck[:len(ck)-4], int(binary.BigEndian.Uint32(ck[len(ck)-4:]))
+			if _, count, ok := chunkHeader(main.Get(key)); !ok || i >= count {
+				orphans = append(orphans, append([]byte(nil), ck...))
+			}
+			return nil
+		})
+	}
+	if !remove {
+		err := s.view(find)
+		return len(orphans), err
+	}
+	err := s.update(func(w *wtx) error {
+		orphans = nil
+		if err := find(w.tx); err != nil {
+			return err
+		}
+		b := w.s.aux(w.tx, chunksBucket)
+		for _, ck := range orphans {
+			if err := b.Delete(ck); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(orphans), nil
+}
+
+// checkChunks is the Fsck check of the chunks bucket.
+func checkChunks(f *fsck) {
+	b := f.s.aux(f.tx, chunksBucket)
+	if b != nil {
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if len(k) < 4 {
+				f.report(FsckOrphanMetadata, chunksBucket, k, "malformed chunk key", deleteFix(chunksBucket, k))
+				continue
+			}
+			key, i := k[:len(k)-4], binary.BigEndian.Uint32(k[len(k)-4:])
+			if _, count, ok := chunkHeader(f.b.Get(key)); !ok {
+				f.report(FsckOrphanMetadata, chunksBucket, key, fmt.Sprintf("chunk %d of a key without a chunked value", i), deleteFix(chunksBucket, k))
+			} else if int(i) >= count {
+				f.report(FsckOrphanMetadata, chunksBucket, key, fmt.Sprintf("chunk %d of a value of %d chunks", i, count), deleteFix(chunksBucket, k))
+			}
+		}
+	}
+	c := f.b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		_, count, ok := chunkHeader(v)
+		if !ok {
+			continue
+		}
+		for i := 0; i < count; i++ {
+			if b == nil || b.Get(chunkKey(k, i)) == nil {
+				f.report(FsckMissingMetadata, chunksBucket, k, fmt.Sprintf("chunk %d of %d is missing", i, count), nil)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package bboltkv
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestChunkThreshold(t *testing.T) {
+	db := openTestStore(t, WithChunkThreshold(100), WithChunkSize(30))
+	for _, c := range []struct {
+		size   int
+		chunks int
+	}{{1, 0}, {99, 0}, {100, 0}, {101, 4}, {120, 4}, {121, 5}} {
+		raw := bytes.Repeat([]byte{'x'}, c.size)
+		if err := db.PutEncoded("key", raw); err != nil {
+			t.Fatal(err)
+		}
+		chunks, total, err := db.ChunkInfo("key")
+		if err != nil || chunks != c.chunks || total != int64(c.size) {
+			t.Fatalf("%d bytes: %d chunks, %d bytes, %v", c.size, chunks, total, err)
+		}
+		if got, err := db.GetRaw("key"); err != nil || !bytes.Equal(got, raw) {
+			t.Fatalf("%d bytes: got %d bytes, %v", c.size, len(got), err)
+		}
+	}
+	if _, _, err := db.ChunkInfo("other"); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+
+	// values read back through every path
+	value := strings.Repeat("large value ", 100)
+	if err := db.PutAll(map[string]interface{}{"a": value, "b": "small"}); err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	if err := db.Get("a", &got); err != nil || got != value {
+		t.Fatalf("got %d bytes, %v", len(got), err)
+	}
+	var old string
+	if _, err := db.GetSet("a", "replaced", &old); err != nil || old != value {
+		t.Fatalf("got %d bytes, %v", len(old), err)
+	}
+	if n, err := db.OrphanChunks(false); err != nil || n != 0 {
+		t.Fatalf("%d orphans, %v", n, err)
+	}
+}
+
+func TestRechunk(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(name, "test", WithChunkThreshold(100), WithChunkSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sums := map[string][32]byte{}
+	for i, size := range []int{50, 150, 1000} {
+		raw := bytes.Repeat([]byte{byte('a' + i)}, size)
+		key := keyN(i)
+		if err := db.PutEncoded(key, raw); err != nil {
+			t.Fatal(err)
+		}
+		sums[key] = sha256.Sum256(raw)
+	}
+	if err := db.Expire(keyN(2), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = Open(name, "test", WithChunkThreshold(500), WithChunkSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Rechunk("missing"); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if err := db.Rechunk(keyN(1)); err != nil {
+		t.Fatal(err)
+	}
+	if chunks, _, err := db.ChunkInfo(keyN(1)); err != nil || chunks != 0 {
+		t.Fatalf("got %d chunks, %v", chunks, err)
+	}
+	if n, err := db.RechunkAll(); err != nil || n != 1 {
+		t.Fatalf("rechunked %d, %v", n, err)
+	}
+	if chunks, _, err := db.ChunkInfo(keyN(2)); err != nil || chunks != 10 {
+		t.Fatalf("got %d chunks, %v", chunks, err)
+	}
+	if n, err := db.RechunkAll(); err != nil || n != 0 {
+		t.Fatalf("rechunked %d again, %v", n, err)
+	}
+	for key, sum := range sums {
+		if raw, err := db.GetRaw(key); err != nil || sha256.Sum256(raw) != sum {
+			t.Fatalf("%s: value changed, %v", key, err)
+		}
+	}
+	if ttl, err := db.TTL(keyN(2)); err != nil || ttl <= 0 {
+		t.Fatalf("expiry lost: %v, %v", ttl, err)
+	}
+	// the 100 old chunks of 10 bytes are gone
+	if n, err := db.OrphanChunks(false); err != nil || n != 0 {
+		t.Fatalf("%d orphans, %v", n, err)
+	}
+}
+
+func TestOrphanChunks(t *testing.T) {
+	db := openTestStore(t, WithChunkThreshold(10), WithChunkSize(10))
+	for _, key := range []string{"a", "b", "c"} {
+		if err := db.PutEncoded(key, bytes.Repeat([]byte{'x'}, 35)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// overwriting and deleting leave nothing behind
+	if err := db.PutEncoded("a", []byte("short")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.OrphanChunks(false); err != nil || n != 0 {
+		t.Fatalf("%d orphans, %v", n, err)
+	}
+
+	// simulate writes that stopped halfway: chunks of a value whose header
+	// was never written, a chunk past the end of a value, and a value whose
+	// header is gone
+	err := db.GetDb().Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(db.auxName(chunksBucket))
+		for _, ck := range [][]byte{chunkKey([]byte("new"), 0), chunkKey([]byte("new"), 1), chunkKey([]byte("c"), 4)} {
+			if err := b.Put(ck, []byte("chunk")); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket([]byte("test")).Delete([]byte("c"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.OrphanChunks(false); err != nil || n != 7 {
+		t.Fatalf("found %d orphans, %v", n, err)
+	}
+	if n, err := db.OrphanChunks(true); err != nil || n != 7 {
+		t.Fatalf("removed %d orphans, %v", n, err)
+	}
+	if n, err := db.OrphanChunks(false); err != nil || n != 0 {
+		t.Fatalf("%d orphans left, %v", n, err)
+	}
+}
+
+func TestChunkMissing(t *testing.T) {
+	db := openTestStore(t, WithChunkThreshold(10))
+	if err := db.Put("key", strings.Repeat("x", 100)); err != nil {
+		t.Fatal(err)
+	}
+	err := db.GetDb().Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(db.auxName(chunksBucket)).Delete(chunkKey([]byte("key"), 0))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var val string
+	if err := db.Get("key", &val); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("got %v, expected ErrCorrupt", err)
+	}
+	if _, err := db.GetRaw("key"); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("got %v, expected ErrCorrupt", err)
+	}
+	// the damaged value can still be replaced
+	if err := db.Put("key", "small"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get("key", &val); err != nil || val != "small" {
+		t.Fatalf("got %q, %v", val, err)
+	}
+}
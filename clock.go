@@ -0,0 +1,48 @@
+package bboltkv
+
+import "time"
+
+// Clock is the source of time for every feature of the store that records
+// or compares times. The default is the system clock; tests can substitute
+// their own with WithClock, such as testutil.FakeClock.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After waits for the duration to elapse and then sends the current time
+	// on the returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// WithClock makes the store take the time from the given clock instead of
+// the system clock.
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// now returns the current time according to the store's clock.
+func (s *Store) now() time.Time {
+	return s.clock().Now()
+}
+
+// now is Store.now, for before the store is opened.
+func (o *options) now() time.Time {
+	if o.clock == nil {
+		return time.Now()
+	}
+	return o.clock.Now()
+}
+
+func (s *Store) clock() Clock {
+	if s.opts.clock == nil {
+		return realClock{}
+	}
+	return s.opts.clock
+}
@@ -0,0 +1,22 @@
+package bboltkv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/unknownnf/bboltkv/testutil"
+)
+
+func TestWithClock(t *testing.T) {
+	db := openTestStore(t)
+	if d := time.Since(db.now()); d < 0 || d > time.Minute {
+		t.Fatalf("default clock is off by %v", d)
+	}
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := testutil.NewFakeClock(start)
+	db = openTestStore(t, WithClock(clock))
+	clock.Advance(time.Hour)
+	if !db.now().Equal(start.Add(time.Hour)) {
+		t.Fatalf("got %v, expected %v", db.now(), start.Add(time.Hour))
+	}
+}
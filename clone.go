@@ -0,0 +1,100 @@
+package bboltkv
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go.etcd.io/bbolt"
+)
+
+// Clone copies the store to a new database file at dstPath and opens it,
+// with the same bucket name and options as the store, so that the clone
+// encodes, transforms and validates values the same way. Only the
+// callbacks watching the store's writes are left out: WithPutHook,
+// WithDeleteHook, WithTracer and WithQuotaWarning, so that writes to the
+// clone do not pass for the store's. The copy is a
+// snapshot, as WriteTo writes it: the store's bucket and its internal
+// buckets, as they are in a single read transaction, so writes made to the
+// store meanwhile are either wholly in the clone or not at all, and are
+// not held up. Other stores sharing the file are not copied. The clone is
+// independent of the store from then on, so a risky change such as a bulk
+// migration can be tried on it first, and its outcome compared with the
+// store entry by entry.
+//
+// dstPath must not exist yet; Clone fails with an error wrapping
+// os.ErrExist if it does. A clone of a read-only store is writable. If
+// anything fails, no file is left behind.
+//
+//	clone, err := store.Clone("/tmp/dry-run.db")
+//	if err == nil {
+//	    defer clone.Close()
+//	    err = migrate(clone)
+//	}
+func (s *Store) Clone(dstPath string) (*Store, error) {
+	if _, err := os.Stat(dstPath); err == nil {
+		return nil, fmt.Errorf("bboltkv: cloning to %s: %w", dstPath, os.ErrExist)
+	}
+	// Info reads the mapping, which a commit may be replacing, so it is
+	// only safe within a transaction
+	var pageSize int
+	err := s.view(func(tx *bbolt.Tx) error {
+		pageSize = tx.DB().Info().PageSize
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	tmp := dstPath + ".tmp"
+	os.Remove(tmp)
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := s.WriteTo(pw)
+		pw.CloseWithError(err)
+	}()
+	err = loadHibernate(tmp, pr, pageSize)
+	// unblock the writer if loading stopped early
+	pr.CloseWithError(io.ErrClosedPipe)
+	if err == nil {
+		err = os.Rename(tmp, dstPath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	o := s.opts
+	o.readOnly, o.mustExist, o.freezer, o.state = false, false, nil, nil
+	// writes to the clone are not the store's, and must not reach its
+	// audit hooks, spans or warnings
+	o.putHook, o.deleteHook, o.tracer, o.quotaWarnings = nil, nil, nil, nil
+	c, err := open(dstPath, string(s.bucketName), o)
+	if err != nil {
+		os.Remove(dstPath)
+		return nil, err
+	}
+	return c, nil
+}
+
+// CloneTemp is Clone, writing the clone to a temporary directory that
+// closing it removes.
+func (s *Store) CloneTemp() (*Store, error) {
+	dir, err := os.MkdirTemp("", "bboltkv-clone-")
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.Clone(filepath.Join(dir, "clone.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	release := c.release
+	c.release = func() error {
+		err := release()
+		if rerr := os.RemoveAll(dir); err == nil {
+			err = rerr
+		}
+		return err
+	}
+	return c, nil
+}
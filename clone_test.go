@@ -0,0 +1,161 @@
+package bboltkv
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClone(t *testing.T) {
+	db := openTestStore(t, WithCompression())
+	for i := 0; i < 100; i++ {
+		if err := db.Put(keyN(i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.PutWithTTL("ttl", 1, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutTagged("tagged", 1, "red"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Append("list", "a"); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "clone.db")
+	clone, err := db.Clone(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clone.Close()
+	if !bytes.Equal(snapshotOf(t, clone), snapshotOf(t, db)) {
+		t.Fatal("clone differs from the store")
+	}
+	if keys, err := clone.KeysByTag("red"); err != nil || len(keys) != 1 {
+		t.Fatalf("got %v, %v", keys, err)
+	}
+	if ttl, err := clone.TTL("ttl"); err != nil || ttl <= 0 {
+		t.Fatalf("got %v, %v", ttl, err)
+	}
+	if !clone.opts.compression {
+		t.Fatal("clone opened without the store's options")
+	}
+
+	// writes to the clone stay there
+	if _, err := clone.DeletePrefix("k"); err != nil {
+		t.Fatal(err)
+	}
+	if err := clone.Put("new", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get("new", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	var v int
+	if err := db.Get(keyN(7), &v); err != nil || v != 7 {
+		t.Fatalf("got %d, %v", v, err)
+	}
+
+	if _, err := db.Clone(path); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("got %v, expected os.ErrExist", err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("left %s.tmp behind", path)
+	}
+}
+
+func TestCloneHooks(t *testing.T) {
+	var puts, deletes, warnings int
+	tracer := &recordingTracer{}
+	db := openTestStore(t, WithTracer(tracer),
+		WithPutHook(func(string, map[string]string, []byte) { puts++ }),
+		WithDeleteHook(func(string, map[string]string) { deletes++ }),
+		WithQuota(2, 0), WithQuotaWarning(0.5, func(QuotaStatus) { warnings++ }))
+	if err := db.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	clone, err := db.CloneTemp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clone.Close()
+	tracer.mu.Lock()
+	spans := len(tracer.spans)
+	tracer.mu.Unlock()
+	if err := clone.Put("b", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := clone.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	if puts != 1 || deletes != 0 || warnings != 1 || len(tracer.spans) != spans {
+		t.Fatalf("writes to the clone reached the store's callbacks: %d puts, %d deletes, %d warnings, %d spans",
+			puts, deletes, warnings, len(tracer.spans)-spans)
+	}
+	// the quota itself still applies
+	if err := clone.PutAll(map[string]interface{}{"c": 3, "d": 4}); err != ErrQuotaExceeded {
+		t.Fatalf("got %v, expected ErrQuotaExceeded", err)
+	}
+}
+
+func TestCloneTemp(t *testing.T) {
+	db := openTestStore(t)
+	if err := db.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	clone, err := db.CloneTemp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := clone.db.Path()
+	checkValues(t, clone, map[string]int{"a": 1})
+	if err := clone.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Dir(path)); !os.IsNotExist(err) {
+		t.Fatalf("got %v, expected the clone's directory removed", err)
+	}
+	checkValues(t, db, map[string]int{"a": 1})
+}
+
+func TestCloneUnderLoad(t *testing.T) {
+	db := openTestStore(t)
+	stop := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		// each write changes both keys, so a consistent copy has them equal
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				errs <- nil
+				return
+			default:
+			}
+			if err := db.PutAll(map[string]interface{}{"a": i, "b": i}); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		clone, err := db.CloneTemp()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var a, b int
+		errA, errB := clone.Get("a", &a), clone.Get("b", &b)
+		clone.Close()
+		if errA != errB || a != b {
+			t.Fatalf("cloned a=%d (%v) and b=%d (%v)", a, errA, b, errB)
+		}
+	}
+	close(stop)
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,196 @@
+package bboltkv
+
+import (
+	"context"
+	"sync"
+)
+
+// gate tracks the operations in flight on a store, so that closing can wait
+// for them while turning new ones away.
+type gate struct {
+	mu      sync.Mutex
+	n       int
+	total   uint64
+	closing bool
+	drained chan struct{}
+	paused  bool          // see pause
+	resumed chan struct{} // closed when the pause ends
+	idle    chan struct{} // closed once n drops to 0 while pause waits
+}
+
+// enter registers a new operation, once the gate is not paused. It returns
+// false once the gate is closing.
+func (g *gate) enter() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for g.paused && !g.closing {
+		resumed := g.resumed
+		g.mu.Unlock()
+		<-resumed
+		g.mu.Lock()
+	}
+	if g.closing {
+		return false
+	}
+	g.n++
+	g.total++
+	return true
+}
+
+// ops returns the number of operations that have entered so far.
+func (g *gate) ops() uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.total
+}
+
+func (g *gate) exit() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.n--
+	if g.n == 0 && g.closing {
+		close(g.drained)
+	}
+	if g.n == 0 && g.idle != nil {
+		close(g.idle)
+		g.idle = nil
+	}
+}
+
+// pause waits for a moment with no operation in flight, then holds off new
+// ones until resume, counting itself as the one in flight. New operations
+// keep entering while it waits, as they may be nested in operations already
+// in flight. It gives up, returning false, once done is closed or the gate
+// is closing.
+func (g *gate) pause(done <-chan struct{}) bool {
+	g.mu.Lock()
+	for g.n > 0 && !g.closing {
+		if g.idle == nil {
+			g.idle = make(chan struct{})
+		}
+		idle := g.idle
+		g.mu.Unlock()
+		select {
+		case <-idle:
+		case <-done:
+			return false
+		}
+		g.mu.Lock()
+	}
+	defer g.mu.Unlock()
+	if g.closing {
+		return false
+	}
+	g.n++
+	g.paused = true
+	g.resumed = make(chan struct{})
+	return true
+}
+
+// resume ends a pause.
+func (g *gate) resume() {
+	g.mu.Lock()
+	g.paused = false
+	close(g.resumed)
+	g.mu.Unlock()
+	g.exit()
+}
+
+// close stops new operations from entering and returns a channel that is
+// closed once the operations already in flight have finished.
+func (g *gate) close() <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.closing {
+		g.closing = true
+		g.drained = make(chan struct{})
+		if g.n == 0 {
+			close(g.drained)
+		}
+	}
+	return g.drained
+}
+
+// enter registers a store operation with the gate, failing with ErrClosed
+// once the store is closing, and with ErrReentrant when called from a
+// Progress callback.
+func (s *Store) enter() error {
+	if s.inCallback() {
+		return ErrReentrant
+	}
+	if !s.gate.enter() {
+		return ErrClosed
+	}
+	return nil
+}
+
+// goBackground runs fn in a goroutine that closing the store waits for. fn
+// should return soon after done is closed.
+func (s *Store) goBackground(fn func(done <-chan struct{})) {
+	s.bg.Add(1)
+	go func() {
+		defer s.bg.Done()
+		fn(s.done)
+	}()
+}
+
+// Close closes the key-value store file. It waits for operations in flight
+// on other goroutines to finish first; operations started after Close has
+// been called fail with ErrClosed, as does calling Close again. With
+// WithWriteBuffer, buffered writes are flushed before the file is closed.
+func (s *Store) Close() error {
+	return s.CloseGrace(context.Background())
+}
+
+// CloseGrace closes the store like Close, but gives up waiting for
+// operations in flight when ctx is done, returning ctx.Err(). The store then
+// stays closed to new operations, but the file is left open; call Close or
+// CloseGrace again to finish closing it.
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+//	defer cancel()
+//	if err := store.CloseGrace(ctx); err != nil {
+//	    log.Print("store still busy: ", err)
+//	}
+func (s *Store) CloseGrace(ctx context.Context) error {
+	return s.closeGrace(ctx, nil)
+}
+
+// closeGrace implements CloseGrace. If last is not nil, it is called once
+// all operations have finished and buffered writes have been flushed, just
+// before the file is closed.
+func (s *Store) closeGrace(ctx context.Context, last func() error) error {
+	s.closeMu.Lock()
+	defer s.closeMu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	drained := s.gate.close()
+	s.stopOnce.Do(func() { close(s.done) })
+	stopped := make(chan struct{})
+	go func() {
+		s.bg.Wait()
+		close(stopped)
+	}()
+	for _, ch := range []<-chan struct{}{drained, stopped} {
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	s.closed = true
+	var err error
+	if s.wbuf != nil {
+		if err = s.flushBuffer(); err == nil {
+			err = s.wbuf.takeErr()
+		}
+	}
+	if err == nil && last != nil {
+		err = last()
+	}
+	if cerr := s.release(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package bboltkv
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestClosed(t *testing.T) {
+	db := openTestStore(t)
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key", "value"); err != ErrClosed {
+		t.Fatalf("put returned %v, expected ErrClosed", err)
+	}
+	if err := db.Get("key", nil); err != ErrClosed {
+		t.Fatalf("get returned %v, expected ErrClosed", err)
+	}
+	if err := db.Delete("key"); err != ErrClosed {
+		t.Fatalf("delete returned %v, expected ErrClosed", err)
+	}
+	if err := db.Close(); err != ErrClosed {
+		t.Fatalf("close returned %v, expected ErrClosed", err)
+	}
+}
+
+func TestCloseGraceConcurrentWriters(t *testing.T) {
+	db := openTestStore(t)
+	name := db.GetDb().Path()
+
+	var mu sync.Mutex
+	var written []string
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				key := fmt.Sprintf("w%d-%d", w, i)
+				err := db.Put(key, i)
+				if err == ErrClosed {
+					return
+				} else if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				written = append(written, key)
+				mu.Unlock()
+			}
+		}(w)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := db.CloseGrace(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	db, err := Open(name, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if len(written) == 0 {
+		t.Fatal("no writes completed")
+	}
+	for _, key := range written {
+		if err := db.Get(key, nil); err != nil {
+			t.Fatalf("acknowledged write %s lost: %v", key, err)
+		}
+	}
+}
+
+func TestCloseGraceTimeout(t *testing.T) {
+	db := openTestStore(t)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go db.view(func(tx *bbolt.Tx) error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := db.CloseGrace(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, expected context.DeadlineExceeded", err)
+	}
+	// the store no longer accepts operations, but the file is still open
+	if err := db.Put("key", "value"); err != ErrClosed {
+		t.Fatalf("put returned %v, expected ErrClosed", err)
+	}
+	close(release)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloseStopsBackground(t *testing.T) {
+	db := openTestStore(t)
+	stopped := make(chan struct{})
+	db.goBackground(func(done <-chan struct{}) {
+		<-done
+		close(stopped)
+	})
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("Close returned before the background goroutine stopped")
+	}
+}
@@ -0,0 +1,141 @@
+package bboltkv
+
+import (
+	"bytes"
+	"io"
+
+	"go.etcd.io/bbolt"
+)
+
+// ConflictPolicy says what ImportInto does with entries whose keys are
+// already present in the store.
+type ConflictPolicy int
+
+const (
+	// ConflictOverwrite replaces the entries present with the imported
+	// ones.
+	ConflictOverwrite ConflictPolicy = iota
+
+	// ConflictSkip keeps the entries present, and skips the imported ones.
+	ConflictSkip
+
+	// ConflictFail fails the import with ErrConflict, importing nothing.
+	ConflictFail
+)
+
+// ExportPrefix writes the entries whose keys start with prefix to w, as a
+// collection that ImportInto can read, and returns how many it wrote. The
+// entries are read in a single transaction. As with ExportJSON, only keys
+// and values are exported, and keys left out of Keys are skipped.
+//
+// A collection uses the format of WriteTo: a single bucket, named after the
+// prefix, holding the entries with the prefix removed from their keys.
+func (s *Store) ExportPrefix(prefix string, w io.Writer) (int, error) {
+	if err := s.plainKeys(); err != nil {
+		return 0, err
+	}
+	n := 0
+	err := s.view(func(tx *bbolt.Tx) error {
+		sw := newSnapshotWriter(w)
+		sw.write([]byte{recBucket})
+		sw.bytes([]byte(prefix))
+		c := tx.Bucket(s.bucketName).Cursor()
+		p := []byte(prefix)
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p) && sw.err == nil; k, v = c.Next() {
+			if s.hidden(tx, k) {
+				continue
+			}
+			v, err := s.assemble(tx, k, v)
+			if err != nil {
+				return err
+			}
+			sw.write([]byte{recEntry})
+			sw.bytes(k[len(p):])
+			sw.bytes(v)
+			n++
+		}
+		sw.write([]byte{recEnd})
+		_, err := sw.finish()
+		return err
+	})
+	return n, err
+}
+
+// ImportInto reads a collection written by ExportPrefix from r, and puts
+// its entries into the store under prefix: the prefix the collection was
+// exported with is replaced with this one, so passing the same prefix
+// imports the entries under their original keys. Entries whose keys are
+// present already are handled according to policy. Values are validated
+// like those of PutEncoded.
+//
+// The import happens in a single transaction, which commits only once the
+// whole collection has been read and its checksum verified. So if r turns
+// out to be damaged, which fails with an error wrapping ErrBadSnapshot, or
+// anything else goes wrong, nothing is imported. ImportInto returns the
+// number of entries written.
+//
+//	n, err := store.ImportInto("archive:reports:", f, bboltkv.ConflictSkip)
+func (s *Store) ImportInto(prefix string, r io.Reader, policy ConflictPolicy) (int, error) {
+	if err := s.plainKeys(); err != nil {
+		return 0, err
+	}
+	n := 0
+	err := s.update(func(w *wtx) error {
+		l := &collectionLoader{s: s, w: w, prefix: prefix, policy: policy}
+		err := readSnapshot(r, l)
+		if err == nil && l.buckets != 1 {
+			err = ErrBadSnapshot
+		}
+		n = l.n
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// collectionLoader imports the entries of a collection.
+type collectionLoader struct {
+	s       *Store
+	w       *wtx
+	prefix  string
+	policy  ConflictPolicy
+	depth   int
+	buckets int
+	n       int
+}
+
+func (l *collectionLoader) bucket(name []byte) error {
+	if l.depth++; l.depth > 1 {
+		return ErrBadSnapshot
+	}
+	l.buckets++
+	return nil
+}
+
+func (l *collectionLoader) sequence(seq uint64) error {
+	return ErrBadSnapshot
+}
+
+func (l *collectionLoader) entry(key, value []byte) error {
+	k := l.prefix + string(key)
+	if err := l.s.validateEncoded(k, value); err != nil {
+		return err
+	}
+	if l.w.get(k) != nil {
+		switch l.policy {
+		case ConflictSkip:
+			return nil
+		case ConflictFail:
+			return ErrConflict
+		}
+	}
+	l.n++
+	return l.w.put(k, value)
+}
+
+func (l *collectionLoader) end() error {
+	l.depth--
+	return nil
+}
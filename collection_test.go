@@ -0,0 +1,113 @@
+package bboltkv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func exportReports(t *testing.T) []byte {
+	t.Helper()
+	src := openTestStore(t)
+	err := src.PutAll(map[string]interface{}{
+		"reports:2024": 1, "reports:2025": 2, "reportsx": 3, "users:1": 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if n, err := src.ExportPrefix("reports:", &buf); err != nil || n != 2 {
+		t.Fatalf("exported %d, %v", n, err)
+	}
+	return buf.Bytes()
+}
+
+func TestExportImportPrefix(t *testing.T) {
+	raw := exportReports(t)
+	dst := openTestStore(t)
+	if n, err := dst.ImportInto("reports:", bytes.NewReader(raw), ConflictOverwrite); err != nil || n != 2 {
+		t.Fatalf("imported %d, %v", n, err)
+	}
+	if keys, err := dst.Keys(); err != nil || fmt.Sprint(keys) != "[reports:2024 reports:2025]" {
+		t.Fatalf("got %v, %v", keys, err)
+	}
+	var v int
+	if err := dst.Get("reports:2025", &v); err != nil || v != 2 {
+		t.Fatalf("got %d, %v", v, err)
+	}
+
+	// re-rooted
+	if n, err := dst.ImportInto("archive/", bytes.NewReader(raw), ConflictOverwrite); err != nil || n != 2 {
+		t.Fatalf("imported %d, %v", n, err)
+	}
+	if err := dst.Get("archive/2024", &v); err != nil || v != 1 {
+		t.Fatalf("got %d, %v", v, err)
+	}
+}
+
+func TestImportIntoConflicts(t *testing.T) {
+	raw := exportReports(t)
+	for _, c := range []struct {
+		policy ConflictPolicy
+		n      int
+		err    error
+		want   int
+	}{
+		{ConflictOverwrite, 2, nil, 1},
+		{ConflictSkip, 1, nil, 9},
+		{ConflictFail, 0, ErrConflict, 9},
+	} {
+		dst := openTestStore(t)
+		if err := dst.Put("reports:2024", 9); err != nil {
+			t.Fatal(err)
+		}
+		n, err := dst.ImportInto("reports:", bytes.NewReader(raw), c.policy)
+		if err != c.err || n != c.n {
+			t.Fatalf("policy %d: got %d, %v", c.policy, n, err)
+		}
+		var v int
+		if err := dst.Get("reports:2024", &v); err != nil || v != c.want {
+			t.Fatalf("policy %d: got %d, %v", c.policy, v, err)
+		}
+		count, err := dst.Count()
+		if err != nil {
+			t.Fatal(err)
+		} else if want := map[bool]int{true: 1, false: 2}[c.err != nil]; count != want {
+			t.Fatalf("policy %d: %d keys, expected %d", c.policy, count, want)
+		}
+	}
+}
+
+func TestImportIntoCorrupt(t *testing.T) {
+	raw := exportReports(t)
+	dst := openTestStore(t)
+	for _, i := range []int{0, len(raw) / 2, len(raw) - 1} {
+		bad := append([]byte(nil), raw...)
+		bad[i] ^= 0x40
+		if _, err := dst.ImportInto("reports:", bytes.NewReader(bad), ConflictOverwrite); !errors.Is(err, ErrBadSnapshot) {
+			t.Fatalf("byte %d: got %v, expected ErrBadSnapshot", i, err)
+		}
+	}
+	if _, err := dst.ImportInto("reports:", bytes.NewReader(raw[:len(raw)-3]), ConflictOverwrite); !errors.Is(err, ErrBadSnapshot) {
+		t.Fatalf("truncated: got %v, expected ErrBadSnapshot", err)
+	}
+	// a full snapshot is not a collection
+	src := openTestStore(t)
+	if err := src.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.Append("list", 1); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := src.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dst.ImportInto("", &buf, ConflictOverwrite); !errors.Is(err, ErrBadSnapshot) {
+		t.Fatalf("snapshot: got %v, expected ErrBadSnapshot", err)
+	}
+	if count, err := dst.Count(); err != nil || count != 0 {
+		t.Fatalf("damaged imports wrote %d keys, %v", count, err)
+	}
+}
@@ -0,0 +1,340 @@
+package bboltkv
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.etcd.io/bbolt"
+)
+
+// CompatReport is the result of CheckCompatibility. Fields are named by the
+// names of the struct fields leading to them, joined with dots, so
+// "Address.City" is the City field of the Address field; the elements of
+// slices, arrays and maps are stepped through without naming them, so
+// "Items.Price" is the Price field of each of the Items.
+type CompatReport struct {
+	// Sampled is the number of values read.
+	Sampled int
+
+	// Dropped lists the fields of the stored values that the prototype has
+	// no field of the same name for, so that reading the values into it
+	// loses what they hold there, with how many of the values hold data in
+	// each. Fields that are zero in every value are not listed, as gob
+	// stores nothing for them, nor are those within a dropped field.
+	Dropped []CompatField
+
+	// AlwaysZero lists the fields of the prototype that none of the values
+	// read into it set, such as fields added or renamed since the values
+	// were written.
+	AlwaysZero []string
+
+	// Failures lists the values that could not be read into the prototype
+	// at all.
+	Failures []CompatFailure
+}
+
+// CompatField is a field of the stored values, see CompatReport.
+type CompatField struct {
+	Field  string
+	Values int
+}
+
+// CompatFailure is a value that could not be read into the prototype, see
+// CompatReport.
+type CompatFailure struct {
+	Key string
+	Err error
+}
+
+// Compatible reports whether every value was read into the prototype
+// without losing data.
+func (r CompatReport) Compatible() bool {
+	return len(r.Dropped) == 0 && len(r.Failures) == 0
+}
+
+// CheckCompatibility reads up to sample values of the keys starting with
+// prefix into a new value of the type of prototype, as Get would, and
+// reports what reading them loses, so that a change to a struct can be
+// checked against the values already stored before it is deployed. gob
+// matches struct fields by name and skips those of the stored values that
+// the type it decodes into lacks, so renaming a field loses its data
+// without an error: the report lists the old name as dropped and the new
+// one as always zero. Values whose stored fields have types the
+// prototype's cannot hold are listed as failures, with the error Get would
+// return.
+//
+// Values are sampled in the order of their keys; sample 0 or less reads
+// them all. Only gob-encoded values have fields: values stored with
+// MarshalBinary or MarshalText, see WithMarshalerPreference, are only
+// checked to be readable. The contents of interface values are not
+// examined. The values are read in a single transaction.
+//
+//	report, err := store.CheckCompatibility("user:", User{}, 1000)
+//	if err == nil && !report.Compatible() {
+//		log.Fatalf("dropped %v, failed %v", report.Dropped, report.Failures)
+//	}
+func (s *Store) CheckCompatibility(prefix string, prototype interface{}, sample int) (CompatReport, error) {
+	var report CompatReport
+	if prototype == nil {
+		return report, fmt.Errorf("%w: nil prototype", ErrBadValue)
+	}
+	t := baseType(reflect.TypeOf(prototype))
+	fields := make(map[string]bool)
+	protoFields(t, "", fields, make(map[reflect.Type]bool))
+	p, err := s.sealPrefix([]byte(prefix))
+	if err != nil {
+		return report, err
+	}
+	dropped := make(map[string]int)
+	set := make(map[string]bool)
+	err = s.view(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(s.bucketName).Cursor()
+		for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+			if sample > 0 && report.Sampled == sample {
+				break
+			}
+			if s.hidden(tx, k) {
+				continue
+			}
+			key, err := s.openKey(k)
+			if err != nil {
+				return err
+			}
+			raw, err := s.assemble(tx, k, v)
+			if err == nil {
+				err = s.decode(raw, reflect.New(t).Interface())
+			}
+			report.Sampled++
+			if err != nil {
+				report.Failures = append(report.Failures, CompatFailure{key, err})
+				continue
+			}
+			present, err := s.storedFields(raw)
+			if err != nil {
+				return fmt.Errorf("bboltkv: reading value of %q: %w", key, err)
+			}
+			for f := range present {
+				if hasField(t, f) {
+					set[f] = true
+				} else {
+					dropped[f]++
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return CompatReport{}, err
+	}
+	for f, n := range dropped {
+		if i := strings.LastIndexByte(f, '.'); i >= 0 && dropped[f[:i]] > 0 {
+			// the whole of the field holding it is dropped already
+			continue
+		}
+		report.Dropped = append(report.Dropped, CompatField{f, n})
+	}
+	sort.Slice(report.Dropped, func(i, j int) bool {
+		return report.Dropped[i].Field < report.Dropped[j].Field
+	})
+	for f := range fields {
+		if !set[f] {
+			report.AlwaysZero = append(report.AlwaysZero, f)
+		}
+	}
+	sort.Strings(report.AlwaysZero)
+	return report, nil
+}
+
+var (
+	gobDecoderType        = reflect.TypeOf((*gob.GobDecoder)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+	textUnmarshalerType   = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// protoFields adds the names of the fields of t, and of the structs within
+// it, to fields, as gob would decode them: exported fields other than
+// channels and functions, and not those within types with their own
+// encoding. seen holds the structs being added, so the fields of recursive
+// types are added once.
+func protoFields(t reflect.Type, path string, fields map[string]bool, seen map[reflect.Type]bool) {
+	t = baseType(t)
+	if ownEncoding(t) {
+		return
+	}
+	switch t.Kind() {
+	case reflect.Array, reflect.Slice, reflect.Map:
+		protoFields(t.Elem(), path, fields, seen)
+	case reflect.Struct:
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !decodedField(f) {
+				continue
+			}
+			name := fieldPath(path, f.Name)
+			fields[name] = true
+			protoFields(f.Type, name, fields, seen)
+		}
+	}
+}
+
+// hasField reports whether gob decodes the field at path into a value of
+// type t.
+func hasField(t reflect.Type, path string) bool {
+	for _, name := range strings.Split(path, ".") {
+		t = baseType(t)
+		for t.Kind() == reflect.Array || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+			t = baseType(t.Elem())
+		}
+		if t.Kind() != reflect.Struct || ownEncoding(t) {
+			return false
+		}
+		f, ok := t.FieldByName(name)
+		if !ok || len(f.Index) > 1 || !decodedField(f) {
+			return false
+		}
+		t = f.Type
+	}
+	return true
+}
+
+// ownEncoding reports whether gob decodes values of type t with a method
+// of theirs.
+func ownEncoding(t reflect.Type) bool {
+	pt := reflect.PtrTo(t)
+	return pt.Implements(gobDecoderType) || pt.Implements(binaryUnmarshalerType) || pt.Implements(textUnmarshalerType)
+}
+
+// decodedField reports whether gob decodes into the struct field f.
+func decodedField(f reflect.StructField) bool {
+	return f.PkgPath == "" && f.Type.Kind() != reflect.Chan && f.Type.Kind() != reflect.Func
+}
+
+// fieldPath returns the name of the field called name within the one at
+// path.
+func fieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// storedFields returns the names of the fields an encoded value holds data
+// in: those gob wrote, as it leaves out zero fields.
+func (s *Store) storedFields(raw []byte) (map[string]bool, error) {
+	raw, err := s.pipeline.untransform(raw)
+	if err != nil {
+		return nil, err
+	}
+	present := make(map[string]bool)
+	if len(raw) > 0 && isTag(raw[0]) {
+		return present, nil
+	}
+	types, id, body, err := readGobTypes(raw)
+	if err != nil {
+		return nil, err
+	}
+	f := &fieldFinder{types: types, r: &gobReader{buf: body}, present: present}
+	if def := types[id]; def == nil || def.kind != gobStructT {
+		// a single value: field delta 0, then the value
+		if f.r.uint() != 0 {
+			return nil, errGobFormat
+		}
+	}
+	if _, err := f.value(id, ""); err != nil {
+		return nil, err
+	}
+	return present, nil
+}
+
+// fieldFinder steps through a gob-encoded value, like canonicalizer, noting
+// the fields it holds.
+type fieldFinder struct {
+	types   gobTypes
+	r       *gobReader
+	present map[string]bool
+}
+
+// value reads a value of the given type within the field at path, and
+// reports whether it is other than zero. gob leaves out zero fields, but
+// not the zero elements of arrays, nor structs with no fields set.
+func (f *fieldFinder) value(id int64, path string) (bool, error) {
+	r := f.r
+	switch id {
+	case gobBool, gobInt, gobUint, gobFloat:
+		return r.uint() != 0, r.err
+	case gobComplex:
+		re, im := r.uint(), r.uint()
+		return re != 0 || im != 0, r.err
+	case gobBytes, gobString:
+		return r.string() != "", r.err
+	case gobInterface:
+		// the name of the dynamic type, its id and the value's bytes
+		if r.string() == "" {
+			return false, r.err
+		}
+		r.int()
+		r.string()
+		return true, r.err
+	}
+	def := f.types[id]
+	if def == nil {
+		return false, errGobFormat
+	}
+	nonzero := false
+	switch def.kind {
+	case gobArrayT, gobSliceT:
+		n := r.uint()
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			set, err := f.value(def.elem, path)
+			if err != nil {
+				return false, err
+			}
+			nonzero = nonzero || set || def.kind == gobSliceT
+		}
+	case gobMapT:
+		n := r.uint()
+		nonzero = n > 0
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			if _, err := f.value(def.key, path); err != nil {
+				return false, err
+			}
+			if _, err := f.value(def.elem, path); err != nil {
+				return false, err
+			}
+		}
+	case gobStructT:
+		field := -1
+		for r.err == nil {
+			delta := r.uint()
+			if delta == 0 {
+				break
+			}
+			field += int(delta)
+			if field >= len(def.fields) {
+				return false, errGobFormat
+			}
+			name := fieldPath(path, def.fields[field].name)
+			set, err := f.value(def.fields[field].id, name)
+			if err != nil {
+				return false, err
+			} else if set {
+				f.present[name] = true
+				nonzero = true
+			}
+		}
+	default:
+		// types with their own encoding are sent as bytes
+		nonzero = r.string() != ""
+	}
+	return nonzero, r.err
+}
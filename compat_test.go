@@ -0,0 +1,166 @@
+package bboltkv
+
+import (
+	"encoding/gob"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type compatAddress struct {
+	City, Zip string
+}
+
+type compatUserV1 struct {
+	Name    string
+	Email   string
+	Age     int
+	Address compatAddress
+	Tags    []string
+}
+
+// compatUserV2 renames Email, adds Phone, and drops Tags and Address.Zip.
+type compatUserV2 struct {
+	Name    string
+	Mail    string
+	Age     int
+	Phone   string
+	Address struct{ City string }
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	db := openTestStore(t)
+	for i := 0; i < 10; i++ {
+		u := compatUserV1{Name: fmt.Sprint("user", i), Email: "u@example.com", Age: i}
+		if i%2 == 0 {
+			u.Address = compatAddress{"Lisbon", "1000"}
+		}
+		if i < 3 {
+			u.Tags = []string{"new"}
+		}
+		if err := db.Put(fmt.Sprintf("user:%d", i), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Put("other", struct{ Unrelated int }{1}); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := db.CheckCompatibility("user:", compatUserV2{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := CompatReport{
+		Sampled:    10,
+		Dropped:    []CompatField{{"Address.Zip", 5}, {"Email", 10}, {"Tags", 3}},
+		AlwaysZero: []string{"Mail", "Phone"},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Fatalf("got %+v, expected %+v", report, expected)
+	} else if report.Compatible() {
+		t.Fatal("compatible")
+	}
+
+	// the type the values were written with loses nothing
+	report, err = db.CheckCompatibility("user:", &compatUserV1{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Compatible() || report.Sampled != 10 || len(report.AlwaysZero) != 0 {
+		t.Fatalf("got %+v", report)
+	}
+
+	// a field dropped as a whole is listed once
+	report, err = db.CheckCompatibility("user:", struct{ Name string }{}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = CompatReport{
+		Sampled: 4,
+		Dropped: []CompatField{{"Address", 2}, {"Age", 3}, {"Email", 4}, {"Tags", 3}},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Fatalf("got %+v, expected %+v", report, expected)
+	}
+
+	report, err = db.CheckCompatibility("none:", compatUserV2{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	} else if report.Sampled != 0 || len(report.AlwaysZero) != 6 {
+		t.Fatalf("got %+v", report)
+	}
+}
+
+func TestCheckCompatibilityFailures(t *testing.T) {
+	db := openTestStore(t, WithMarshalerPreference())
+	if err := db.Put("user:1", compatUserV1{Name: "ana", Age: 30}); err != nil {
+		t.Fatal(err)
+	}
+	// Age changed to a string
+	if err := db.Put("user:2", struct {
+		Name string
+		Age  string
+	}{"bo", "thirty"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("user:3", []int{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("user:4", jsonTicket{`{"Name":"cy"}`}); err != nil {
+		t.Fatal(err)
+	}
+	report, err := db.CheckCompatibility("user:", compatUserV1{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Sampled != 4 || len(report.Dropped) != 0 || report.Compatible() {
+		t.Fatalf("got %+v", report)
+	}
+	var failed []string
+	for _, f := range report.Failures {
+		if f.Err == nil {
+			t.Fatalf("%s failed without an error", f.Key)
+		}
+		failed = append(failed, f.Key)
+	}
+	if strings.Join(failed, ",") != "user:2,user:3,user:4" {
+		t.Fatalf("got failures %v", report.Failures)
+	}
+	if _, err := db.CheckCompatibility("user:", nil, 0); err == nil {
+		t.Fatal("checked against a nil prototype")
+	}
+}
+
+type compatEvent struct {
+	At      time.Time
+	Payload interface{}
+	Labels  map[string]compatAddress
+	Next    *compatEvent
+	Note    string
+}
+
+func TestCheckCompatibilityNested(t *testing.T) {
+	gob.Register(compatAddress{})
+	db := openTestStore(t)
+	in := compatEvent{
+		At:      time.Now(),
+		Payload: compatAddress{"Porto", "4000"},
+		Labels:  map[string]compatAddress{"home": {City: "Faro"}},
+		Next:    &compatEvent{Note: "second"},
+		Note:    "first",
+	}
+	if err := db.Put("event", in); err != nil {
+		t.Fatal(err)
+	}
+	report, err := db.CheckCompatibility("event", compatEvent{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the fields of a recursive type are matched however deep they are
+	expected := CompatReport{Sampled: 1, AlwaysZero: []string{"Labels.Zip"}}
+	if !reflect.DeepEqual(report, expected) {
+		t.Fatalf("got %+v, expected %+v", report, expected)
+	}
+}
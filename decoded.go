@@ -0,0 +1,230 @@
+package bboltkv
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+)
+
+// WithDecodedCache keeps up to maxEntries values decoded by GetShared in
+// memory, so that GetShared returns the value it decoded before for a key
+// until the key is written again, without reading or decoding anything.
+// Least recently used entries are evicted first. Unlike WithReadCache, which
+// saves the transaction but not the decoding, it only serves GetShared, and
+// values it returns are shared between its callers.
+//
+// Writes through the store invalidate the entries they touch once they
+// commit, and so does registering a schema; writes still in the write
+// buffer, see WithWriteBuffer, are decoded afresh by every GetShared, and
+// entries with a TTL are not cached. Writes made directly through GetDb(),
+// or by other stores sharing the file, are not seen by the cache, which is
+// why OpenShared refuses the option on a bucket another store also uses.
+func WithDecodedCache(maxEntries int) Option {
+	return func(o *options) {
+		o.decodedSize = maxEntries
+	}
+}
+
+// GetShared returns the value stored under key, decoded into a value of the
+// type registered for the key with RegisterSchema, or for keys matching no
+// registration as GetAny decodes them. It returns ErrNotFound if the key is
+// not present. With WithDecodedCache, GetShared returns the same value to
+// every caller until the key is written, decoding it only once.
+//
+// Unlike Get, which decodes into memory of the caller's, GetShared may hand
+// out the value to any number of callers at once, so it must be treated as
+// immutable: the slices, maps and pointers it holds are shared, and
+// modifying what they refer to corrupts the value every other caller sees,
+// without any write to the store. A caller that needs to change the value
+// must copy what it changes, or use Get.
+//
+//	store.RegisterSchema("product:", Product{})
+//	v, err := store.GetShared("product:42")
+//	if err != nil {
+//	    return err
+//	}
+//	p := v.(Product) // copies the struct, but not its Tags
+//	p.Name = strings.TrimSpace(p.Name)
+//	p.Tags = append([]string(nil), p.Tags...) // copy before modifying
+//	p.Tags[0] = "sale"
+func (s *Store) GetShared(key string) (v interface{}, err error) {
+	if s.opts.tracer != nil {
+		defer s.startSpan(s.opts.tracer, "GetShared", key)(&err)
+	}
+	sealed := s.sealKey(key)
+	c := s.decoded
+	if c == nil || s.wbuf != nil && s.buffered(sealed) {
+		raw, err := s.load(sealed)
+		if err != nil {
+			return nil, err
+		}
+		return s.decodeShared(key, raw)
+	}
+	if v, ok := c.get(sealed); ok {
+		return v, nil
+	}
+	// noted before the type is looked up, as registering a schema clears
+	// the cache
+	gen := c.begin(sealed)
+	raw, cacheable, err := s.loadCacheable(sealed)
+	if err != nil {
+		return nil, err
+	}
+	if v, err = s.decodeShared(key, raw); err != nil {
+		return nil, err
+	}
+	if cacheable {
+		c.add(sealed, v, gen)
+	}
+	return v, nil
+}
+
+// buffered reports whether the write buffer holds a write to key.
+func (s *Store) buffered(key string) bool {
+	_, ok := s.wbuf.get(key)
+	return ok
+}
+
+// decodeShared decodes raw, the value stored under key, for GetShared.
+func (s *Store) decodeShared(key string, raw []byte) (interface{}, error) {
+	_, t := s.schemaFor(key)
+	if t == nil {
+		return s.decodeUntyped(raw)
+	}
+	p := reflect.New(t)
+	if err := s.decode(raw, p.Interface()); err != nil {
+		return nil, err
+	}
+	return p.Elem().Interface(), nil
+}
+
+// decodedCache is an LRU cache of the values decoded by GetShared.
+//
+// Every key has a generation, which every committed write to it bumps to
+// the value of a counter shared by all keys, so that it only ever grows. A
+// reader that misses the cache notes the key's generation before it starts
+// reading, and may only add what it decoded if the generation is still the
+// same, so that it cannot add a value that a concurrent write has replaced.
+// Generations are kept for the max keys bumped most recently, in an LRU of
+// their own; a key without one has the floor, the newest generation among
+// those dropped, so that dropping the generation of a key written after a
+// reader noted it still changes it.
+type decodedCache struct {
+	mu      sync.Mutex
+	max     int
+	lru     *list.List // of *decodedEntry, most recently used first
+	entries map[string]*list.Element
+	counter uint64
+	gens    *list.List // of *keyGen, most recently bumped first
+	genIdx  map[string]*list.Element
+	floor   uint64
+}
+
+type decodedEntry struct {
+	key   string
+	value interface{}
+}
+
+type keyGen struct {
+	key string
+	gen uint64
+}
+
+func newDecodedCache(max int) *decodedCache {
+	return &decodedCache{
+		max:     max,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		gens:    list.New(),
+		genIdx:  make(map[string]*list.Element),
+	}
+}
+
+func (c *decodedCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*decodedEntry).value, true
+	}
+	return nil, false
+}
+
+// begin returns the generation of key to pass to add for a read of key
+// about to start.
+func (c *decodedCache) begin(key string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation(key)
+}
+
+func (c *decodedCache) generation(key string) uint64 {
+	if g, ok := c.genIdx[key]; ok {
+		return g.Value.(*keyGen).gen
+	}
+	return c.floor
+}
+
+// add caches the value decoded for key by a read that started at
+// generation gen, and reports whether it did.
+func (c *decodedCache) add(key string, value interface{}, gen uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation(key) != gen {
+		return false
+	}
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*decodedEntry).value = value
+		c.lru.MoveToFront(e)
+		return true
+	}
+	c.entries[key] = c.lru.PushFront(&decodedEntry{key: key, value: value})
+	for c.lru.Len() > c.max {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*decodedEntry).key)
+	}
+	return true
+}
+
+// invalidate bumps the generations of the given keys, and drops their
+// values, after a write to them committed.
+func (c *decodedCache) invalidate(keys []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counter++
+	for _, key := range keys {
+		if e, ok := c.entries[key]; ok {
+			c.lru.Remove(e)
+			delete(c.entries, key)
+		}
+		if g, ok := c.genIdx[key]; ok {
+			g.Value.(*keyGen).gen = c.counter
+			c.gens.MoveToFront(g)
+		} else {
+			c.genIdx[key] = c.gens.PushFront(&keyGen{key: key, gen: c.counter})
+		}
+	}
+	for c.gens.Len() > c.max {
+		g := c.gens.Back()
+		c.gens.Remove(g)
+		kg := g.Value.(*keyGen)
+		delete(c.genIdx, kg.key)
+		if kg.gen > c.floor {
+			c.floor = kg.gen
+		}
+	}
+}
+
+// clear drops all values after a write to unknown keys committed, or the
+// registered types changed, bumping the generations of all keys.
+func (c *decodedCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counter++
+	c.floor = c.counter
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.gens.Init()
+	c.genIdx = make(map[string]*list.Element)
+}
@@ -0,0 +1,286 @@
+package bboltkv
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/unknownnf/bboltkv/testutil"
+	"go.etcd.io/bbolt"
+)
+
+type sharedDoc struct {
+	Version int
+	Items   []string
+}
+
+// sameDoc reports whether a and b share their items, as cached values do.
+func sameDoc(a, b interface{}) bool {
+	x, y := a.(sharedDoc), b.(sharedDoc)
+	return len(x.Items) > 0 && len(y.Items) > 0 && &x.Items[0] == &y.Items[0]
+}
+
+func getShared(t *testing.T, db *Store, key string) interface{} {
+	t.Helper()
+	v, err := db.GetShared(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestGetShared(t *testing.T) {
+	db := openTestStore(t, WithDecodedCache(100))
+	db.RegisterSchema("doc:", sharedDoc{})
+	if err := db.Put("doc:1", sharedDoc{1, []string{"a", "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	v := getShared(t, db, "doc:1")
+	if d, ok := v.(sharedDoc); !ok || d.Version != 1 || len(d.Items) != 2 {
+		t.Fatalf("got %#v", v)
+	}
+	if w := getShared(t, db, "doc:1"); !sameDoc(v, w) {
+		t.Fatal("value decoded again")
+	}
+
+	// writes invalidate the value
+	if err := db.Put("doc:1", sharedDoc{2, []string{"c"}}); err != nil {
+		t.Fatal(err)
+	}
+	w := getShared(t, db, "doc:1")
+	if w.(sharedDoc).Version != 2 {
+		t.Fatalf("got %#v after a write", w)
+	}
+	err := db.Update(func(tx *WriteTx) error {
+		return tx.InBucket("test").Put("doc:1", sharedDoc{3, []string{"d"}})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := getShared(t, db, "doc:1"); v.(sharedDoc).Version != 3 {
+		t.Fatalf("got %#v after an update", v)
+	}
+	if err := db.Delete("doc:1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetShared("doc:1"); err != ErrNotFound {
+		t.Fatalf("got %v after a delete, expected ErrNotFound", err)
+	}
+
+	// keys without a schema decode as for GetAny
+	if err := db.Put("other", sharedDoc{4, nil}); err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := getShared(t, db, "other").(map[string]interface{}); !ok || m["Version"] != int64(4) {
+		t.Fatalf("got %#v", m)
+	}
+	// which registering a schema changes
+	db.RegisterSchema("other", sharedDoc{})
+	if v, ok := getShared(t, db, "other").(sharedDoc); !ok || v.Version != 4 {
+		t.Fatalf("got %#v after registering a schema", v)
+	}
+	if err := db.Put("other", "text"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetShared("other"); err == nil {
+		t.Fatal("decoded a string into the registered type")
+	}
+
+	// without the cache, every call decodes
+	db = openTestStore(t)
+	db.RegisterSchema("doc:", sharedDoc{})
+	if err := db.Put("doc:1", sharedDoc{1, []string{"a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if v, w := getShared(t, db, "doc:1"), getShared(t, db, "doc:1"); sameDoc(v, w) {
+		t.Fatal("value shared without the cache")
+	}
+}
+
+func TestGetSharedNotCached(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithDecodedCache(100), WithClock(clock), WithWriteBuffer(100, 0))
+	db.RegisterSchema("", sharedDoc{})
+
+	// buffered writes are read back
+	if err := db.Put("a", sharedDoc{1, []string{"a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if v, w := getShared(t, db, "a"), getShared(t, db, "a"); v.(sharedDoc).Version != 1 || sameDoc(v, w) {
+		t.Fatalf("got %#v, shared while buffered", v)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	v := getShared(t, db, "a")
+	if !sameDoc(v, getShared(t, db, "a")) {
+		t.Fatal("value not cached once flushed")
+	}
+	if err := db.Put("a", sharedDoc{2, []string{"b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if v := getShared(t, db, "a"); v.(sharedDoc).Version != 2 {
+		t.Fatalf("got %#v, expected the buffered write", v)
+	}
+
+	// entries with a TTL expire
+	if err := db.PutWithTTL("b", sharedDoc{1, []string{"a"}}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if v, w := getShared(t, db, "b"), getShared(t, db, "b"); sameDoc(v, w) {
+		t.Fatal("value with a TTL cached")
+	}
+	clock.Advance(time.Minute)
+	if _, err := db.GetShared("b"); err != ErrNotFound {
+		t.Fatalf("got %v after its TTL, expected ErrNotFound", err)
+	}
+
+	// RawUpdate empties the cache
+	v = getShared(t, db, "a")
+	err := db.RawUpdate(func(b *bbolt.Bucket) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sameDoc(v, getShared(t, db, "a")) {
+		t.Fatal("value kept through RawUpdate")
+	}
+}
+
+func TestDecodedCacheGenerations(t *testing.T) {
+	c := newDecodedCache(2)
+	// a write committing while a read is in progress
+	gen := c.begin("a")
+	c.invalidate([]string{"a"})
+	if c.add("a", 1, gen) {
+		t.Fatal("added a value replaced by a write")
+	}
+	if gen = c.begin("a"); !c.add("a", 2, gen) {
+		t.Fatal("value not added")
+	}
+	if v, ok := c.get("a"); !ok || v != 2 {
+		t.Fatalf("got %v, %v", v, ok)
+	}
+
+	// generations dropped to make room still keep reads out
+	gen = c.begin("b")
+	c.invalidate([]string{"b"})
+	c.invalidate([]string{"c", "d"})
+	if _, ok := c.genIdx["b"]; ok {
+		t.Fatal("generation of b kept")
+	}
+	if c.add("b", 1, gen) {
+		t.Fatal("added a value replaced by a write")
+	}
+	if !c.add("b", 1, c.begin("b")) {
+		t.Fatal("value not added")
+	}
+
+	// eviction
+	c.add("c", 1, c.begin("c"))
+	c.add("d", 1, c.begin("d"))
+	if n := c.lru.Len(); n != 2 {
+		t.Fatalf("holding %d values", n)
+	}
+	if _, ok := c.get("b"); ok {
+		t.Fatal("least recently used value kept")
+	}
+
+	gen = c.begin("e")
+	c.clear()
+	if c.add("e", 1, gen) || c.lru.Len() != 0 {
+		t.Fatal("value kept after clear")
+	}
+}
+
+func TestGetSharedConcurrent(t *testing.T) {
+	db := openTestStore(t, WithDecodedCache(10))
+	db.RegisterSchema("", sharedDoc{})
+	const versions = 200
+	if err := db.Put("k", sharedDoc{0, []string{"v0"}}); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	errs := make(chan error, 4)
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				v, err := db.GetShared("k")
+				if err != nil {
+					errs <- err
+					return
+				}
+				if d := v.(sharedDoc); d.Items[0] != fmt.Sprintf("v%d", d.Version) {
+					errs <- fmt.Errorf("read %#v", d)
+					return
+				}
+			}
+		}()
+	}
+	for i := 1; i <= versions; i++ {
+		if err := db.Put("k", sharedDoc{i, []string{fmt.Sprintf("v%d", i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if v := getShared(t, db, "k"); v.(sharedDoc).Version != versions {
+		t.Fatalf("got %#v after the last write", v)
+	}
+}
+
+// bigDoc stands for a value that takes long to decode.
+type bigDoc struct {
+	Title  string
+	Scores map[string]float64
+	Lines  []string
+}
+
+func benchmarkShared(b *testing.B, opts ...Option) *Store {
+	db := openTestStore(b, opts...)
+	db.RegisterSchema("", bigDoc{})
+	doc := bigDoc{Title: "big", Scores: make(map[string]float64)}
+	for i := 0; i < 1000; i++ {
+		doc.Scores[keyN(i)] = float64(i)
+		doc.Lines = append(doc.Lines, fmt.Sprintf("line %d of the document", i))
+	}
+	if err := db.Put("doc", doc); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	return db
+}
+
+func BenchmarkGetBig(b *testing.B) {
+	db := benchmarkShared(b, WithReadCache(10))
+	for i := 0; i < b.N; i++ {
+		var doc bigDoc
+		if err := db.Get("doc", &doc); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetSharedBig(b *testing.B) {
+	db := benchmarkShared(b, WithDecodedCache(10))
+	for i := 0; i < b.N; i++ {
+		if _, err := db.GetShared("doc"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package bboltkv
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+// blobsBucket holds deduplicated values under their SHA-256, and refsBucket
+// the number of entries referring to each, as 8 bytes big-endian.
+const (
+	blobsBucket = "blobs"
+	refsBucket  = "blob-refs"
+)
+
+// dedupThreshold is the size above which WithDeduplication stores values
+// by content.
+const dedupThreshold = 1024
+
+// WithDeduplication makes the store keep encoded values larger than 1 KiB
+// only once, however many keys they are stored under. Such a value is
+// stored under its SHA-256 in an internal bucket, and entries only refer
+// to it, so the file grows by a reference rather than a copy for each
+// further key. Reads resolve references, so nothing changes for callers.
+// A value is removed once no entry refers to it any more; see VerifyDedup
+// for checking the reference counts.
+//
+// Deduplicated values are never split into chunks, see WithChunkThreshold.
+// Values already stored are left as they are until they are written again.
+func WithDeduplication() Option {
+	return func(o *options) {
+		o.dedup = true
+	}
+}
+
+// blobRef parses a reference to a deduplicated value: the tag, then the
+// SHA-256 of the value. ok is false if v is not one.
+func blobRef(v []byte) (sum []byte, ok bool) {
+	if len(v) != 1+sha256.Size || v[0] != tagDedup {
+		return nil, false
+	}
+	return v[1:], true
+}
+
+// storeBlob writes raw under key as a reference to a deduplicated value.
+// Any reference the key held must have been dropped already.
+func (w *wtx) storeBlob(key string, raw []byte) error {
+	blobs, err := w.aux(blobsBucket)
+	if err != nil {
+		return err
+	}
+	refs, err := w.aux(refsBucket)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(raw)
+	if blobs.Get(sum[:]) == nil {
+		if err := blobs.Put(sum[:], raw); err != nil {
+			return err
+		}
+	}
+	if err := addRefs(refs, sum[:], 1); err != nil {
+		return err
+	}
+	return w.b.Put([]byte(key), append([]byte{tagDedup}, sum[:]...))
+}
+
+// dropBlob releases the deduplicated value the entry of key refers to, if
+// any, removing the value when this was its last reference.
+func (w *wtx) dropBlob(key string) error {
+	sum, ok := blobRef(w.b.Get([]byte(key)))
+	if !ok {
+		return nil
+	}
+	refs := w.s.aux(w.tx, refsBucket)
+	if refs == nil {
+		return nil
+	}
+	sum = append([]byte(nil), sum...)
+	if err := addRefs(refs, sum, -1); err != nil {
+		return err
+	}
+	if refs.Get(sum) == nil {
+		if blobs := w.s.aux(w.tx, blobsBucket); blobs != nil {
+			return blobs.Delete(sum)
+		}
+	}
+	return nil
+}
+
+// addRefs adds delta to the reference count of sum, deleting the count
+// when it drops to zero.
+func addRefs(refs *bbolt.Bucket, sum []byte, delta int64) error {
+	var n int64
+	if v := refs.Get(sum); len(v) == 8 {
+		n = int64(binary.BigEndian.Uint64(v))
+	}
+	return setRefs(refs, sum, n+delta)
+}
+
+func setRefs(refs *bbolt.Bucket, sum []byte, n int64) error {
+	if n <= 0 {
+		return refs.Delete(sum)
+	}
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(n))
+	return refs.Put(sum, v)
+}
+
+// resolveBlob returns the deduplicated value v refers to, or v itself if
+// it is not a reference.
+func (s *Store) resolveBlob(tx *bbolt.Tx, key, v []byte) ([]byte, error) {
+	sum, ok := blobRef(v)
+	if !ok {
+		return v, nil
+	}
+	var raw []byte
+	if blobs := s.aux(tx, blobsBucket); blobs != nil {
+		raw = blobs.Get(sum)
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("%w: key %q: deduplicated value %x is missing", ErrCorrupt, key, sum)
+	}
+	return raw, nil
+}
+
+// VerifyDedup recounts the references to deduplicated values, see
+// WithDeduplication, and repairs what it finds wrong: reference counts
+// that are off are corrected, and values nothing refers to are removed. It
+// returns the number of repairs made. References to values that are
+// missing cannot be repaired; if there are any, VerifyDedup still makes the
+// other repairs, and then returns an error wrapping ErrCorrupt.
+func (s *Store) VerifyDedup() (repaired int, err error) {
+	missing := 0
+	err = s.update(func(w *wtx) error {
+		repaired, missing = 0, 0
+		counts := make(map[string]int64)
+		c := w.b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if sum, ok := blobRef(v); ok {
+				counts[string(sum)]++
+			}
+		}
+		if len(counts) == 0 && s.aux(w.tx, blobsBucket) == nil {
+			return nil
+		}
+		blobs, err := w.aux(blobsBucket)
+		if err != nil {
+			return err
+		}
+		refs, err := w.aux(refsBucket)
+		if err != nil {
+			return err
+		}
+		// counts for values that have no references, or are gone
+		var stale [][]byte
+		refs.ForEach(func(sum, _ []byte) error {
+			if counts[string(sum)] == 0 || blobs.Get(sum) == nil {
+				stale = append(stale, append([]byte(nil), sum...))
+			}
+			return nil
+		})
+		var unused [][]byte
+		blobs.ForEach(func(sum, _ []byte) error {
+			if counts[string(sum)] == 0 {
+				unused = append(unused, append([]byte(nil), sum...))
+			}
+			return nil
+		})
+		for _, sum := range stale {
+			if err := refs.Delete(sum); err != nil {
+				return err
+			}
+			repaired++
+		}
+		for _, sum := range unused {
+			if err := blobs.Delete(sum); err != nil {
+				return err
+			}
+			repaired++
+		}
+		for sum, n := range counts {
+			if blobs.Get([]byte(sum)) == nil {
+				missing++
+				continue
+			}
+			var have int64
+			if v := refs.Get([]byte(sum)); len(v) == 8 {
+				have = int64(binary.BigEndian.Uint64(v))
+			}
+			if have != n {
+				if err := setRefs(refs, []byte(sum), n); err != nil {
+					return err
+				}
+				repaired++
+			}
+		}
+		return nil
+	})
+	if err == nil && missing > 0 {
+		err = fmt.Errorf("%w: %d deduplicated values are missing", ErrCorrupt, missing)
+	}
+	return repaired, err
+}
+
+// dedupUsage adds the space used and saved by deduplication to r.
+func (s *Store) dedupUsage(tx *bbolt.Tx, r *UsageReport) {
+	blobs, refs := s.aux(tx, blobsBucket), s.aux(tx, refsBucket)
+	if blobs == nil || refs == nil {
+		return
+	}
+	blobs.ForEach(func(sum, v []byte) error {
+		r.DedupValues++
+		r.DedupBytes += int64(len(v))
+		if n := refs.Get(sum); len(n) == 8 {
+			r.DedupSavedBytes += int64(len(v)) * (int64(binary.BigEndian.Uint64(n)) - 1)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,167 @@
+package bboltkv
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func blobRefs(t *testing.T, db *Store, raw []byte) int64 {
+	t.Helper()
+	sum := sha256.Sum256(raw)
+	var n int64 = -1
+	err := db.GetDb().View(func(tx *bbolt.Tx) error {
+		refs := db.aux(tx, refsBucket)
+		blobs := db.aux(tx, blobsBucket)
+		if refs == nil || blobs == nil {
+			return nil
+		}
+		if v := refs.Get(sum[:]); v != nil {
+			n = int64(binary.BigEndian.Uint64(v))
+		} else if blobs.Get(sum[:]) == nil {
+			n = 0
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestDedup(t *testing.T) {
+	db := openTestStore(t, WithDeduplication())
+	attachment := bytes.Repeat([]byte("attachment "), 1000)
+	other := bytes.Repeat([]byte("other "), 1000)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if err := db.Put(key, attachment); err != nil {
+			t.Fatal(err)
+		}
+	}
+	raw, err := db.Encode(attachment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := blobRefs(t, db, raw); n != 4 {
+		t.Fatalf("%d references, expected 4", n)
+	}
+	r, err := db.Usage()
+	if err != nil {
+		t.Fatal(err)
+	} else if r.DedupValues != 1 || r.DedupBytes != int64(len(raw)) || r.DedupSavedBytes != 3*int64(len(raw)) {
+		t.Fatalf("got %d values, %d bytes, %d saved", r.DedupValues, r.DedupBytes, r.DedupSavedBytes)
+	} else if r.LogicalBytes > int64(len(raw)) {
+		t.Fatalf("bucket holds %d bytes, expected references only", r.LogicalBytes)
+	}
+	var got []byte
+	if err := db.Get("c", &got); err != nil || !bytes.Equal(got, attachment) {
+		t.Fatalf("got %d bytes, %v", len(got), err)
+	}
+
+	// overwrites and deletes release references
+	if err := db.Put("a", other); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if n := blobRefs(t, db, raw); n != 2 {
+		t.Fatalf("%d references, expected 2", n)
+	}
+	if err := db.Put("c", "small"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.DeleteGetRaw("d"); err != nil {
+		t.Fatal(err)
+	}
+	if n := blobRefs(t, db, raw); n != 0 {
+		t.Fatalf("%d references left, expected the value to be gone", n)
+	}
+	if r, err := db.Usage(); err != nil || r.DedupValues != 1 || r.DedupSavedBytes != 0 {
+		t.Fatalf("got %d values, %d saved, %v", r.DedupValues, r.DedupSavedBytes, err)
+	}
+	if n, err := db.VerifyDedup(); err != nil || n != 0 {
+		t.Fatalf("%d repairs, %v", n, err)
+	}
+}
+
+func TestDedupSmallValues(t *testing.T) {
+	db := openTestStore(t, WithDeduplication())
+	for _, key := range []string{"a", "b"} {
+		if err := db.Put(key, "small"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plain := openTestStore(t)
+	if err := plain.Put("a", "small"); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := rawValue(t, db, "a"), rawValue(t, plain, "a"); !bytes.Equal(a, b) {
+		t.Fatalf("small value stored as %x, expected %x", a, b)
+	}
+	if r, err := db.Usage(); err != nil || r.DedupValues != 0 {
+		t.Fatalf("got %d deduplicated values, %v", r.DedupValues, err)
+	}
+}
+
+func TestVerifyDedup(t *testing.T) {
+	db := openTestStore(t, WithDeduplication())
+	value := bytes.Repeat([]byte{'x'}, 2000)
+	lost := bytes.Repeat([]byte{'y'}, 2000)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := db.PutEncoded(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.PutEncoded("d", lost); err != nil {
+		t.Fatal(err)
+	}
+	// break the counts: one off, one for a value nothing refers to, and an
+	// unreferenced value
+	err := db.GetDb().Update(func(tx *bbolt.Tx) error {
+		refs, blobs := db.aux(tx, refsBucket), db.aux(tx, blobsBucket)
+		sum := sha256.Sum256(value)
+		if err := setRefs(refs, sum[:], 7); err != nil {
+			return err
+		}
+		unused := sha256.Sum256([]byte("unused"))
+		if err := blobs.Put(unused[:], []byte("unused")); err != nil {
+			return err
+		}
+		return setRefs(refs, unused[:], 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.VerifyDedup(); err != nil || n != 3 {
+		t.Fatalf("%d repairs, %v", n, err)
+	}
+	if n := blobRefs(t, db, value); n != 3 {
+		t.Fatalf("%d references, expected 3", n)
+	}
+	if n, err := db.VerifyDedup(); err != nil || n != 0 {
+		t.Fatalf("%d repairs on the second run, %v", n, err)
+	}
+
+	// a value that is gone cannot be repaired
+	err = db.GetDb().Update(func(tx *bbolt.Tx) error {
+		sum := sha256.Sum256(lost)
+		return db.aux(tx, blobsBucket).Delete(sum[:])
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetRaw("d"); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("got %v, expected ErrCorrupt", err)
+	}
+	if n, err := db.VerifyDedup(); !errors.Is(err, ErrCorrupt) || n != 1 {
+		t.Fatalf("%d repairs, %v, expected ErrCorrupt", n, err)
+	}
+	if got, err := db.GetRaw("a"); err != nil || !bytes.Equal(got, value) {
+		t.Fatalf("got %d bytes, %v", len(got), err)
+	}
+}
@@ -0,0 +1,82 @@
+package bboltkv
+
+import "bytes"
+
+// DeletePrefix deletes all entries whose keys start with prefix, in a
+// single transaction, and returns how many it deleted. If one of them is
+// protected, see Protect, it fails with ErrProtected and deletes nothing.
+func (s *Store) DeletePrefix(prefix string) (int, error) {
+	return s.deleteMatching([]byte(prefix), nil)
+}
+
+// DeleteWhere calls fn for every entry in the store, in key order, with the
+// encoded value as stored, and deletes the entries for which it returns
+// true, all in a single transaction. It returns how many entries it
+// deleted. If one of them is protected, see Protect, it fails with
+// ErrProtected and deletes nothing. fn runs inside the transaction, so it
+// must not call methods of the store.
+func (s *Store) DeleteWhere(fn func(key string, raw []byte) bool) (int, error) {
+	return s.deleteMatching(nil, fn)
+}
+
+// Truncate deletes all entries of the store, in a single transaction,
+// except for protected keys, see Protect. It returns how many entries it
+// preserved because they were protected.
+func (s *Store) Truncate() (preserved int, err error) {
+	err = s.update(func(w *wtx) error {
+		var err error
+		_, preserved, err = s.deleteIn(w, nil, nil, true)
+		return err
+	})
+	return preserved, err
+}
+
+func (s *Store) deleteMatching(prefix []byte, fn func(key string, raw []byte) bool) (int, error) {
+	prefix, err := s.sealPrefix(prefix)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	err = s.update(func(w *wtx) error {
+		var err error
+		n, _, err = s.deleteIn(w, prefix, fn, false)
+		return err
+	})
+	return n, err
+}
+
+// deleteIn deletes the entries with the given prefix, or those fn selects,
+// and returns how many it deleted and how many protected ones it kept.
+// Unless skipProtected is set, protected entries fail the deletion.
+func (s *Store) deleteIn(w *wtx, prefix []byte, fn func(key string, raw []byte) bool, skipProtected bool) (deleted, preserved int, err error) {
+	var keys []string
+	c := w.b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if fn != nil {
+			if s.hidden(w.tx, k) {
+				continue
+			}
+			key, err := s.openKey(k)
+			if err != nil {
+				return 0, 0, err
+			}
+			if !fn(key, v) {
+				continue
+			}
+		}
+		if s.protected(string(k)) {
+			if !skipProtected {
+				return 0, 0, ErrProtected
+			}
+			preserved++
+			continue
+		}
+		keys = append(keys, string(k))
+	}
+	for _, k := range keys {
+		if err := w.delete(k); err != nil {
+			return 0, 0, err
+		}
+	}
+	return len(keys), preserved, nil
+}
@@ -0,0 +1,54 @@
+package bboltkv
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeletePrefix(t *testing.T) {
+	db := openTestStore(t)
+	if err := db.PutAll(map[string]interface{}{"a:1": 1, "a:2": 2, "b:1": 3}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.DeletePrefix("a:"); err != nil || n != 2 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	if keys, err := db.Keys(); err != nil || len(keys) != 1 || keys[0] != "b:1" {
+		t.Fatalf("got %v, %v", keys, err)
+	}
+	if n, err := db.DeletePrefix("x"); err != nil || n != 0 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+}
+
+func TestDeleteWhere(t *testing.T) {
+	db := openTestStore(t)
+	fillStore(t, db, 10)
+	n, err := db.DeleteWhere(func(key string, raw []byte) bool {
+		return strings.HasSuffix(key, "3") || strings.HasSuffix(key, "7")
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	if count, err := db.Count(); err != nil || count != 8 {
+		t.Fatalf("got %d, %v", count, err)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	db := openTestStore(t)
+	fillStore(t, db, 10)
+	if err := db.PutWithTTL("ttl", 1, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.Truncate(); err != nil || n != 0 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	if count, err := db.Count(); err != nil || count != 0 {
+		t.Fatalf("got %d, %v", count, err)
+	}
+	if n, err := db.CountExpiring(2 * time.Hour); err != nil || n != 0 {
+		t.Fatalf("TTL left behind: %d, %v", n, err)
+	}
+}
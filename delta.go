@@ -0,0 +1,116 @@
+package bboltkv
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// checkpointBucket holds the checkpoints of StatsCheckpoint: the time in
+// Unix nanoseconds, the number of keys, the logical bytes and the file size,
+// each as 8 bytes big-endian.
+const checkpointBucket = "stats-checkpoints"
+
+// ErrNoCheckpoint is returned by StatsSince for checkpoints that have not
+// been saved with StatsCheckpoint, or have been deleted.
+var ErrNoCheckpoint = errors.New("bboltkv: no such statistics checkpoint")
+
+// StatsDelta describes how the store changed since a checkpoint, see
+// StatsSince. Negative values mean the store shrank.
+type StatsDelta struct {
+	Since   time.Time     // when the checkpoint was saved
+	Elapsed time.Duration // time since then
+
+	Keys         int   // net change in the number of entries
+	LogicalBytes int64 // change in the length of all keys and values
+	FileSize     int64 // change in the size of the database file
+}
+
+// StatsCheckpoint saves the current number of entries, logical bytes and
+// file size, as reported by Usage, under the given name in the database
+// file, replacing any checkpoint of the same name. StatsSince then reports
+// the changes since.
+//
+//	err := store.StatsCheckpoint("weekly")
+//	// a week later
+//	d, err := store.StatsSince("weekly")
+//	log.Printf("%d keys, %d bytes in %v", d.Keys, d.LogicalBytes, d.Elapsed)
+func (s *Store) StatsCheckpoint(name string) error {
+	r, err := s.Usage()
+	if err != nil {
+		return err
+	}
+	v := make([]byte, 32)
+	binary.BigEndian.PutUint64(v, uint64(s.now().UnixNano()))
+	binary.BigEndian.PutUint64(v[8:], uint64(r.Keys))
+	binary.BigEndian.PutUint64(v[16:], uint64(r.LogicalBytes))
+	binary.BigEndian.PutUint64(v[24:], uint64(r.FileSize))
+	return s.update(func(w *wtx) error {
+		b, err := w.aux(checkpointBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(name), v)
+	})
+}
+
+// StatsSince reports how the store changed since the checkpoint saved with
+// StatsCheckpoint under the given name. If there is no such checkpoint, it
+// returns ErrNoCheckpoint.
+func (s *Store) StatsSince(name string) (StatsDelta, error) {
+	var v []byte
+	err := s.view(func(tx *bbolt.Tx) error {
+		if b := s.aux(tx, checkpointBucket); b != nil {
+			v = append([]byte(nil), b.Get([]byte(name))...)
+		}
+		if len(v) != 32 {
+			return ErrNoCheckpoint
+		}
+		return nil
+	})
+	if err != nil {
+		return StatsDelta{}, err
+	}
+	r, err := s.Usage()
+	if err != nil {
+		return StatsDelta{}, err
+	}
+	d := StatsDelta{
+		Since:        time.Unix(0, int64(binary.BigEndian.Uint64(v))),
+		Keys:         r.Keys - int(binary.BigEndian.Uint64(v[8:])),
+		LogicalBytes: r.LogicalBytes - int64(binary.BigEndian.Uint64(v[16:])),
+		FileSize:     r.FileSize - int64(binary.BigEndian.Uint64(v[24:])),
+	}
+	d.Elapsed = s.now().Sub(d.Since)
+	return d, nil
+}
+
+// StatsCheckpoints returns the names of all checkpoints saved with
+// StatsCheckpoint, in lexicographic order.
+func (s *Store) StatsCheckpoints() ([]string, error) {
+	var names []string
+	err := s.view(func(tx *bbolt.Tx) error {
+		if b := s.aux(tx, checkpointBucket); b != nil {
+			return b.ForEach(func(k, _ []byte) error {
+				names = append(names, string(k))
+				return nil
+			})
+		}
+		return nil
+	})
+	return names, err
+}
+
+// DeleteStatsCheckpoint deletes the checkpoint saved with StatsCheckpoint
+// under the given name. Deleting a checkpoint that does not exist is not an
+// error.
+func (s *Store) DeleteStatsCheckpoint(name string) error {
+	return s.update(func(w *wtx) error {
+		if b := s.aux(w.tx, checkpointBucket); b != nil {
+			return b.Delete([]byte(name))
+		}
+		return nil
+	})
+}
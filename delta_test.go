@@ -0,0 +1,77 @@
+package bboltkv
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unknownnf/bboltkv/testutil"
+)
+
+func TestStatsSince(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock))
+	fillStore(t, db, 10)
+	if _, err := db.StatsSince("start"); err != ErrNoCheckpoint {
+		t.Fatalf("got %v, expected ErrNoCheckpoint", err)
+	}
+	initial, err := db.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.StatsCheckpoint("start"); err != nil {
+		t.Fatal(err)
+	}
+	start := clock.Now()
+
+	clock.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		if err := db.Delete(keyN(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 500; i++ {
+		if err := db.Put(strings.Repeat("x", 10)+keyN(i), strings.Repeat("v", 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now, err := db.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := db.StatsSince("start")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Keys != 497 || !d.Since.Equal(start) || d.Elapsed != time.Hour {
+		t.Fatalf("got %+v", d)
+	}
+	if d.LogicalBytes != now.LogicalBytes-initial.LogicalBytes || d.LogicalBytes <= 497*100 || d.FileSize <= 0 {
+		t.Fatalf("got %d bytes and %d bytes of file", d.LogicalBytes, d.FileSize)
+	}
+
+	// a checkpoint taken now shows no change
+	if err := db.StatsCheckpoint("now"); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := db.StatsSince("now"); err != nil || d.Keys != 0 || d.LogicalBytes != 0 || d.FileSize != 0 || d.Elapsed != 0 {
+		t.Fatalf("got %+v, %v", d, err)
+	}
+
+	if names, err := db.StatsCheckpoints(); err != nil || !reflect.DeepEqual(names, []string{"now", "start"}) {
+		t.Fatalf("got %v, %v", names, err)
+	}
+	if err := db.DeleteStatsCheckpoint("start"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteStatsCheckpoint("missing"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.StatsSince("start"); err != ErrNoCheckpoint {
+		t.Fatalf("got %v, expected ErrNoCheckpoint", err)
+	}
+	if names, err := db.StatsCheckpoints(); err != nil || !reflect.DeepEqual(names, []string{"now"}) {
+		t.Fatalf("got %v, %v", names, err)
+	}
+}
@@ -0,0 +1,344 @@
+package bboltkv
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"go.etcd.io/bbolt"
+)
+
+const (
+	// dictBucket holds the compression dictionaries, keyed by generation.
+	dictBucket = "dicts"
+
+	// dictSize is the largest useful dictionary: DEFLATE only refers back
+	// 32 KiB.
+	dictSize = 32 << 10
+
+	// defaultDictSamples is the number of values TrainCompressionDict
+	// samples by default.
+	defaultDictSamples = 1000
+)
+
+// dictionaries holds the compression dictionaries of a store, all
+// generations of them, so that values compressed with older ones can still
+// be read.
+type dictionaries struct {
+	mu     sync.RWMutex
+	gens   map[uint64][]byte
+	latest uint64
+}
+
+func newDictionaries() *dictionaries {
+	return &dictionaries{gens: make(map[uint64][]byte)}
+}
+
+func (d *dictionaries) add(gen uint64, dict []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.gens[gen] = dict
+	if gen > d.latest {
+		d.latest = gen
+	}
+}
+
+// current returns the newest generation, or 0 if no dictionary has been
+// trained yet.
+func (d *dictionaries) current() uint64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.latest
+}
+
+func (d *dictionaries) get(gen uint64) []byte {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.gens[gen]
+}
+
+// dictCompression compresses with DEFLATE against the newest dictionary,
+// storing its generation in front of the compressed value.
+type dictCompression struct {
+	d *dictionaries
+}
+
+func (dictCompression) Tag() byte { return transformDictCompression }
+
+func (c dictCompression) Apply(data []byte) ([]byte, error) {
+	gen := c.d.current()
+	buf := bytes.NewBuffer(appendUvarint(nil, gen))
+	w, err := flate.NewWriterDict(buf, flate.DefaultCompression, c.d.get(gen))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	} else if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c dictCompression) Reverse(data []byte) ([]byte, error) {
+	gen, n := binary.Uvarint(data)
+	if n <= 0 {
+		return nil, fmt.Errorf("%w: truncated dictionary generation", ErrCorrupt)
+	}
+	dict := c.d.get(gen)
+	if dict == nil {
+		return nil, fmt.Errorf("%w: unknown compression dictionary %d", ErrCorrupt, gen)
+	}
+	r := flate.NewReaderDict(bytes.NewReader(data[n:]), dict)
+	defer r.Close()
+	out, err := ioutil.ReadAll(r)
+	if _, ok := err.(flate.CorruptInputError); ok || err == io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return out, err
+}
+
+// dictGeneration returns the generation of the dictionary the stored value
+// raw was compressed with, or 0 if it was not compressed with one.
+func (p *pipeline) dictGeneration(raw []byte) uint64 {
+	if len(raw) == 0 || raw[0] != tagEnvelope {
+		return 0
+	}
+	e, payload, err := DecodeEnvelope(raw)
+	if err != nil || len(e.Transforms) == 0 || e.Transforms[0] != transformDictCompression {
+		return 0
+	}
+	// the generation is under the transforms applied after compression
+	for i := len(e.Transforms) - 1; i > 0; i-- {
+		t := p.reverse[e.Transforms[i]]
+		if t == nil {
+			return 0
+		}
+		if payload, err = t.Reverse(payload); err != nil {
+			return 0
+		}
+	}
+	gen, _ := binary.Uvarint(payload)
+	return gen
+}
+
+// loadDicts reads the store's compression dictionaries.
+func (s *Store) loadDicts(tx *bbolt.Tx) {
+	b := s.aux(tx, dictBucket)
+	if b == nil {
+		return
+	}
+	b.ForEach(func(k, v []byte) error {
+		s.pipeline.dicts.add(binary.BigEndian.Uint64(k), append([]byte(nil), v...))
+		return nil
+	})
+}
+
+// TrainCompressionDict builds a compression dictionary from up to
+// sampleLimit values picked at random from the store, 1000 if it is 0 or
+// less, and stores it in the database file. From then on, values written
+// are compressed with DEFLATE against the dictionary, in place of
+// WithCompression if it was given, which pays off for many small values
+// that have much in common, such as records of the same type, but are each
+// too small to compress well on their own. With few values that differ, it
+// mostly costs time.
+//
+// Each call adds a new generation of the dictionary, which then takes over
+// for new writes. Older generations are kept, at up to 32 KiB each, so
+// that the values compressed with them stay readable; RecompressAll moves
+// them to the newest one. A store without values gets no dictionary.
+//
+//	if err := store.TrainCompressionDict(5000); err != nil {
+//	    return err
+//	}
+//	n, err := store.RecompressAll()
+func (s *Store) TrainCompressionDict(sampleLimit int) error {
+	if sampleLimit <= 0 {
+		sampleLimit = defaultDictSamples
+	}
+	var samples [][]byte
+	err := s.view(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(s.bucketName).Cursor()
+		seen := 0
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if s.hidden(tx, k) {
+				continue
+			}
+			// reservoir sampling keeps each value with equal chance
+			i := seen
+			seen++
+			if i >= sampleLimit {
+				if i = rand.Intn(seen); i >= sampleLimit {
+					continue
+				}
+			}
+			v, err := s.assemble(tx, k, v)
+			if err != nil {
+				return err
+			}
+			raw, err := s.pipeline.untransform(v)
+			if err != nil {
+				return err
+			}
+			raw = append([]byte(nil), raw...)
+			if i < len(samples) {
+				samples[i] = raw
+			} else {
+				samples = append(samples, raw)
+			}
+		}
+		return nil
+	})
+	if err != nil || len(samples) == 0 {
+		return err
+	}
+	dict := buildDict(samples)
+	return s.update(func(w *wtx) error {
+		b, err := w.aux(dictBucket)
+		if err != nil {
+			return err
+		}
+		gen, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		k := make([]byte, 8)
+		binary.BigEndian.PutUint64(k, gen)
+		if err := b.Put(k, dict); err != nil {
+			return err
+		}
+		w.tx.OnCommit(func() { s.pipeline.dicts.add(gen, dict) })
+		return nil
+	})
+}
+
+// buildDict joins samples into a dictionary of at most dictSize bytes.
+// DEFLATE finds matches near the end of the dictionary more cheaply, so the
+// substrings that most samples share go last: each sample is scored by how
+// many of its 4-byte sequences occur in other samples.
+func buildDict(samples [][]byte) []byte {
+	counts := make(map[string]int)
+	for _, sample := range samples {
+		seen := make(map[string]bool)
+		for i := 0; i+4 <= len(sample); i++ {
+			if g := string(sample[i : i+4]); !seen[g] {
+				seen[g] = true
+				counts[g]++
+			}
+		}
+	}
+	scores := make([]float64, len(samples))
+	for j, sample := range samples {
+		shared := 0
+		for i := 0; i+4 <= len(sample); i++ {
+			if counts[string(sample[i:i+4])] > 1 {
+				shared++
+			}
+		}
+		scores[j] = float64(shared) / float64(len(sample)+1)
+	}
+	order := make([]int, len(samples))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return scores[order[i]] > scores[order[j]] })
+	// take the best samples that fit, then write them best last
+	var picked []int
+	size := 0
+	for _, i := range order {
+		if size+len(samples[i]) > dictSize {
+			continue
+		}
+		picked = append(picked, i)
+		size += len(samples[i])
+	}
+	dict := make([]byte, 0, size)
+	for i := len(picked) - 1; i >= 0; i-- {
+		dict = append(dict, samples[picked[i]]...)
+	}
+	return dict
+}
+
+// RecompressAll rewrites the values that are not compressed with the
+// newest dictionary, see TrainCompressionDict, so that they are, in a
+// single transaction, and returns how many it rewrote. Values keep their
+// expiry, tags and timestamps. Lists, see Append, are left as they are.
+// Without a dictionary, RecompressAll does nothing.
+func (s *Store) RecompressAll() (int, error) {
+	gen := s.pipeline.dicts.current()
+	if gen == 0 {
+		return 0, nil
+	}
+	var keys [][]byte
+	err := s.view(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(s.bucketName).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			v, err := s.assemble(tx, k, v)
+			if err != nil {
+				return err
+			}
+			if len(v) > 0 && v[0] != tagList && s.pipeline.dictGeneration(v) != gen {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+		}
+		return nil
+	})
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+	err = s.update(func(w *wtx) error {
+		for _, k := range keys {
+			if err := w.recompress(string(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// recompress runs the value of key through the store's transforms again.
+func (w *wtx) recompress(key string) error {
+	v := w.b.Get([]byte(key))
+	if v == nil {
+		return nil
+	}
+	v, err := w.s.assemble(w.tx, []byte(key), v)
+	if err != nil {
+		return err
+	}
+	e, _, err := DecodeEnvelope(v)
+	if err != nil {
+		return err
+	}
+	plain, err := w.s.pipeline.untransform(v)
+	if err != nil {
+		return err
+	}
+	raw, err := w.s.pipeline.transformNamed(plain, e.TypeName)
+	if err != nil {
+		return err
+	}
+	if w.s.quotaOn() {
+		if err := w.account(key, len(raw)); err != nil {
+			return err
+		}
+	}
+	if err := w.dropChunks(key); err != nil {
+		return err
+	}
+	if err := w.dropBlob(key); err != nil {
+		return err
+	}
+	w.stale = append(w.stale, key)
+	return w.store(key, raw)
+}
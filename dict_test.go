@@ -0,0 +1,163 @@
+package bboltkv
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+type dictRecord struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Country string `json:"country"`
+	Active  bool   `json:"active"`
+	Role    string `json:"role"`
+}
+
+func putRecords(t *testing.T, db *Store, prefix string, n int) {
+	t.Helper()
+	countries := []string{"Germany", "France", "Brazil", "Japan"}
+	for i := 0; i < n; i++ {
+		r := dictRecord{
+			ID:      i,
+			Name:    fmt.Sprint("user-", i),
+			Email:   fmt.Sprintf("user-%d@example.com", i),
+			Country: countries[i%len(countries)],
+			Active:  i%3 != 0,
+			Role:    "subscriber",
+		}
+		if err := db.Put(fmt.Sprintf("%s%05d", prefix, i), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func storedBytes(t *testing.T, db *Store) int64 {
+	t.Helper()
+	r, err := db.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.LogicalBytes
+}
+
+// storedGeneration returns the dictionary generation the value of key is
+// compressed with.
+func storedGeneration(t *testing.T, db *Store, key string) uint64 {
+	t.Helper()
+	raw, err := db.GetRaw(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db.pipeline.dictGeneration(raw)
+}
+
+func TestCompressionDictRatio(t *testing.T) {
+	db := openTestStore(t, WithCompression())
+	putRecords(t, db, "user:", 2000)
+	compressed := storedBytes(t, db)
+
+	if err := db.TrainCompressionDict(500); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.RecompressAll(); err != nil || n != 2000 {
+		t.Fatalf("recompressed %d, %v", n, err)
+	}
+	withDict := storedBytes(t, db)
+	t.Logf("%d bytes compressed per value, %d with a dictionary", compressed, withDict)
+	if withDict > compressed*6/10 {
+		t.Fatalf("%d bytes with a dictionary, expected well below %d", withDict, compressed)
+	}
+	var r dictRecord
+	if err := db.Get("user:01234", &r); err != nil || r.ID != 1234 || r.Email != "user-1234@example.com" {
+		t.Fatalf("got %+v, %v", r, err)
+	}
+	if n, err := db.RecompressAll(); err != nil || n != 0 {
+		t.Fatalf("recompressed %d again, %v", n, err)
+	}
+}
+
+func TestCompressionDictGenerations(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(name, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.TrainCompressionDict(0); err != nil {
+		t.Fatal(err)
+	}
+	if gen := db.pipeline.dicts.current(); gen != 0 {
+		t.Fatalf("empty store trained dictionary %d", gen)
+	}
+	putRecords(t, db, "plain:", 100)
+	if err := db.TrainCompressionDict(0); err != nil {
+		t.Fatal(err)
+	}
+	putRecords(t, db, "a:", 100)
+	if err := db.TrainCompressionDict(50); err != nil {
+		t.Fatal(err)
+	}
+	putRecords(t, db, "b:", 100)
+	db.Close()
+
+	db, err = Open(name, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for key, gen := range map[string]uint64{"plain:00007": 0, "a:00007": 1, "b:00007": 2} {
+		if got := storedGeneration(t, db, key); got != gen {
+			t.Fatalf("%s: generation %d, expected %d", key, got, gen)
+		}
+		var r dictRecord
+		if err := db.Get(key, &r); err != nil || r.ID != 7 {
+			t.Fatalf("%s: got %+v, %v", key, r, err)
+		}
+	}
+	if n, err := db.RecompressAll(); err != nil || n != 200 {
+		t.Fatalf("recompressed %d, %v", n, err)
+	}
+	for _, key := range []string{"plain:00007", "a:00007", "b:00099"} {
+		if got := storedGeneration(t, db, key); got != 2 {
+			t.Fatalf("%s: generation %d after RecompressAll", key, got)
+		}
+	}
+}
+
+func TestCompressionDictRetrain(t *testing.T) {
+	db := openTestStore(t, WithCompression(), WithEncryption(testKey))
+	putRecords(t, db, "user:", 200)
+	if err := db.TrainCompressionDict(100); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RecompressAll(); err != nil {
+		t.Fatal(err)
+	}
+	// the data changes shape, and a new dictionary follows it
+	for i := 0; i < 200; i++ {
+		if err := db.Put(fmt.Sprintf("order:%05d", i), map[string]interface{}{"order": i, "status": "shipped", "carrier": "parcel"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.TrainCompressionDict(100); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.RecompressAll(); err != nil || n != 400 {
+		t.Fatalf("recompressed %d, %v", n, err)
+	}
+	var order map[string]interface{}
+	if err := db.Get("order:00042", &order); err != nil || order["status"] != "shipped" {
+		t.Fatalf("got %v, %v", order, err)
+	}
+	var r dictRecord
+	if err := db.Get("user:00042", &r); err != nil || r.ID != 42 {
+		t.Fatalf("got %+v, %v", r, err)
+	}
+
+	// a value naming a generation the store does not have is corrupt
+	_, err := dictCompression{newDictionaries()}.Reverse(appendUvarint(nil, 9))
+	if err == nil {
+		t.Fatal("decompressed with an unknown dictionary")
+	}
+}
@@ -0,0 +1,120 @@
+package bboltkv
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/gob"
+	"fmt"
+	"reflect"
+)
+
+// Encode encodes a value the same way Put does, so that the result can be
+// stored under any number of keys with PutEncoded without encoding it again.
+// As with Put, the value cannot be nil.
+//
+//	raw, err := store.Encode(profile)
+//	for _, k := range indexKeys {
+//	    err = store.PutEncoded(k, raw)
+//	}
+func (s *Store) Encode(value interface{}) ([]byte, error) {
+	raw, err := s.encodePlain(value)
+	if err != nil {
+		return nil, err
+	}
+	return s.pipeline.transform(raw, value)
+}
+
+// encodePlain encodes a value without applying the store's transforms.
+func (s *Store) encodePlain(value interface{}) ([]byte, error) {
+	if value == nil {
+		return nil, ErrBadValue
+	}
+	if s.opts.marshalers {
+		if m, ok := value.(encoding.BinaryMarshaler); ok {
+			return marshalTagged(tagBinaryMarshaler, m.MarshalBinary)
+		} else if m, ok := value.(encoding.TextMarshaler); ok {
+			return marshalTagged(tagTextMarshaler, m.MarshalText)
+		}
+	}
+	if err := checkEncodable(value); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, &UnencodableError{Value: reflect.TypeOf(value), Err: err}
+	}
+	if s.opts.canonical {
+		return canonicalGob(buf.Bytes())
+	}
+	return buf.Bytes(), nil
+}
+
+func marshalTagged(tag byte, marshal func() ([]byte, error)) ([]byte, error) {
+	data, err := marshal()
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{tag}, data...), nil
+}
+
+// Decode decodes bytes returned by Encode or GetRaw, or passed to a
+// callback such as a ViewReduce, into the pointer-typed value, the same way
+// Get does.
+func (s *Store) Decode(raw []byte, value interface{}) error {
+	return s.decode(raw, value)
+}
+
+// decode decodes raw bytes as written by Put into the pointer-typed value.
+func (s *Store) decode(raw []byte, value interface{}) error {
+	raw, err := s.pipeline.untransform(raw)
+	if err != nil {
+		return err
+	}
+	if len(raw) > 0 && isTag(raw[0]) {
+		return decodeTagged(raw, value)
+	}
+	return gob.NewDecoder(bytes.NewReader(raw)).Decode(value)
+}
+
+func decodeTagged(raw []byte, value interface{}) error {
+	if v := reflect.ValueOf(value); v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("bboltkv: cannot decode into non-pointer %T", value)
+	}
+	switch raw[0] {
+	case tagBinaryMarshaler:
+		if u, ok := value.(encoding.BinaryUnmarshaler); ok {
+			return u.UnmarshalBinary(raw[1:])
+		}
+		return fmt.Errorf("bboltkv: value was stored with MarshalBinary, but %T does not implement encoding.BinaryUnmarshaler", value)
+	case tagTextMarshaler:
+		if u, ok := value.(encoding.TextUnmarshaler); ok {
+			return u.UnmarshalText(raw[1:])
+		}
+		return fmt.Errorf("bboltkv: value was stored with MarshalText, but %T does not implement encoding.TextUnmarshaler", value)
+	case tagList:
+		return errList
+	case tagChunked:
+		return fmt.Errorf("%w: chunks of value are missing", ErrCorrupt)
+	case tagDedup:
+		return fmt.Errorf("%w: deduplicated value is missing", ErrCorrupt)
+	}
+	return fmt.Errorf("bboltkv: unknown value format 0x%02x", raw[0])
+}
+
+// PutEncoded stores bytes previously returned by Encode under the given key.
+// The entry can then be read back with Get like any other. The encoded
+// value cannot be empty - if it is, PutEncoded returns ErrBadValue.
+func (s *Store) PutEncoded(key string, encoded []byte) error {
+	if err := s.validateEncoded(key, encoded); err != nil {
+		return err
+	}
+	if err := s.limitWrite(context.Background(), key); err != nil {
+		return err
+	}
+	plain := key
+	key = s.sealKey(key)
+	return s.update(s.named("PutEncoded", plain, func(w *wtx) error {
+		return w.put(key, encoded)
+	}))
+}
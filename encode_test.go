@@ -0,0 +1,213 @@
+package bboltkv
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestEncodeFanOut(t *testing.T) {
+	db := openTestStore(t)
+	inval := aStruct{Numbers: &[]int{100, 200, 400, 800}}
+	raw, err := db.Encode(inval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := db.PutEncoded(fmt.Sprintf("key%d", i), raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		var outval aStruct
+		if err := db.Get(fmt.Sprintf("key%d", i), &outval); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(*outval.Numbers) != fmt.Sprint(*inval.Numbers) {
+			t.Fatalf("got %v, expected %v", *outval.Numbers, *inval.Numbers)
+		}
+	}
+}
+
+func TestEncodeInterop(t *testing.T) {
+	db := openTestStore(t)
+	// PutEncoded writes what Put writes
+	if err := db.Put("put", "value"); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := db.Encode("value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutEncoded("encoded", raw); err != nil {
+		t.Fatal(err)
+	}
+	putRaw, err := db.DeleteGetRaw("put")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(putRaw) != string(raw) {
+		t.Fatal("Put and Encode produced different bytes")
+	}
+	var val string
+	if err := db.Get("encoded", &val); err != nil {
+		t.Fatal(err)
+	} else if val != "value" {
+		t.Fatalf("got \"%s\", expected \"value\"", val)
+	}
+}
+
+func TestEncodeBadValue(t *testing.T) {
+	db := openTestStore(t)
+	if _, err := db.Encode(nil); err != ErrBadValue {
+		t.Fatalf("got %v, expected ErrBadValue", err)
+	}
+	if err := db.PutEncoded("key", nil); err != ErrBadValue {
+		t.Fatalf("got %v, expected ErrBadValue", err)
+	}
+	if err := db.PutEncoded("key", []byte{}); err != ErrBadValue {
+		t.Fatalf("got %v, expected ErrBadValue", err)
+	}
+}
+
+type testUUID [16]byte
+
+func (u testUUID) MarshalBinary() ([]byte, error) {
+	return u[:], nil
+}
+
+func (u *testUUID) UnmarshalBinary(data []byte) error {
+	if len(data) != 16 {
+		return fmt.Errorf("bad uuid length %d", len(data))
+	}
+	copy(u[:], data)
+	return nil
+}
+
+type testLevel int
+
+func (l testLevel) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("level-%d", int(l))), nil
+}
+
+func (l *testLevel) UnmarshalText(text []byte) error {
+	_, err := fmt.Sscanf(string(text), "level-%d", (*int)(l))
+	return err
+}
+
+func TestMarshalerPreference(t *testing.T) {
+	db := openTestStore(t, WithMarshalerPreference())
+	in := testUUID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	raw, err := db.Encode(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 17 || raw[0] != tagBinaryMarshaler {
+		t.Fatalf("not encoded with MarshalBinary: %x", raw)
+	}
+	if err := db.Put("uuid", in); err != nil {
+		t.Fatal(err)
+	}
+	var out testUUID
+	if err := db.Get("uuid", &out); err != nil {
+		t.Fatal(err)
+	} else if out != in {
+		t.Fatalf("got %x, expected %x", out, in)
+	}
+	// text fallback
+	if err := db.Put("level", testLevel(3)); err != nil {
+		t.Fatal(err)
+	}
+	var level testLevel
+	if err := db.Get("level", &level); err != nil {
+		t.Fatal(err)
+	} else if level != 3 {
+		t.Fatalf("got %d, expected 3", level)
+	}
+	// other values are still gob-encoded
+	if err := db.Put("string", "value"); err != nil {
+		t.Fatal(err)
+	}
+	var val string
+	if err := db.Get("string", &val); err != nil {
+		t.Fatal(err)
+	} else if val != "value" {
+		t.Fatalf("got \"%s\", expected \"value\"", val)
+	}
+}
+
+func TestMarshalerErrors(t *testing.T) {
+	db := openTestStore(t, WithMarshalerPreference())
+	if err := db.Put("uuid", testUUID{1}); err != nil {
+		t.Fatal(err)
+	}
+	var out testUUID
+	if err := db.Get("uuid", out); err == nil || !strings.Contains(err.Error(), "non-pointer") {
+		t.Fatalf("got %v, expected a non-pointer error", err)
+	}
+	var s string
+	if err := db.Get("uuid", &s); err == nil || !strings.Contains(err.Error(), "BinaryUnmarshaler") {
+		t.Fatalf("got %v, expected a missing unmarshaler error", err)
+	}
+}
+
+func TestMarshalerMixedStores(t *testing.T) {
+	db := openTestStore(t)
+	name := db.GetDb().Path()
+	in := testUUID{42}
+	if err := db.Put("gob", in); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err := Open(name, "test", WithMarshalerPreference())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out testUUID
+	if err := db.Get("gob", &out); err != nil {
+		t.Fatal(err)
+	} else if out != in {
+		t.Fatalf("got %x, expected %x", out, in)
+	}
+	if err := db.Put("tagged", in); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = Open(name, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	out = testUUID{}
+	if err := db.Get("tagged", &out); err != nil {
+		t.Fatal(err)
+	} else if out != in {
+		t.Fatalf("got %x, expected %x", out, in)
+	}
+}
+
+func benchmarkEncodeDecode(b *testing.B, opts ...Option) {
+	db := openTestStore(b, opts...)
+	in := testUUID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		raw, err := db.Encode(in)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var out testUUID
+		if err := db.decode(raw, &out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeDecodeGob(b *testing.B) {
+	benchmarkEncodeDecode(b)
+}
+
+func BenchmarkEncodeDecodeMarshaler(b *testing.B) {
+	benchmarkEncodeDecode(b, WithMarshalerPreference())
+}
@@ -0,0 +1,150 @@
+package bboltkv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// An envelope wraps an encoded value together with metadata about it:
+//
+//	tagEnvelope
+//	version         1
+//	flags           EnvelopeFlags
+//	length          uvarint, of the metadata section that follows
+//	metadata        items of a tag byte, a uvarint length and the data
+//	payload         the encoded value, after the transforms it lists
+//
+// Readers skip metadata items they do not know, so later releases can add
+// items without a new version, as long as they can be ignored. A new flag
+// or version is needed for anything that changes how the payload is read.
+const envelopeVersion byte = 1
+
+const (
+	metaTypeName   byte = 1 // the Go type of the value, see EnvelopeTypeName
+	metaTransforms byte = 2 // the tags of the transforms, see EnvelopeTransforms
+)
+
+// EnvelopeFlags tell which metadata an envelope holds.
+type EnvelopeFlags byte
+
+const (
+	// EnvelopeTypeName says that the envelope holds the name of the Go
+	// type of the value, see WithTypeInfo.
+	EnvelopeTypeName EnvelopeFlags = 1 << iota
+
+	// EnvelopeTransforms says that the payload went through the
+	// transforms listed in the envelope, see ValueTransform.
+	EnvelopeTransforms
+
+	envelopeKnownFlags = EnvelopeTypeName | EnvelopeTransforms
+)
+
+var (
+	// ErrBadEnvelope is wrapped by the error returned when a value's
+	// envelope is truncated or malformed.
+	ErrBadEnvelope = errors.New("bboltkv: bad value envelope")
+
+	// ErrEnvelopeVersion is wrapped by the error returned when a value's
+	// envelope has a version or flags this release does not know, such as
+	// when it was written by a later release.
+	ErrEnvelopeVersion = errors.New("bboltkv: unsupported value envelope")
+)
+
+// Envelope describes the metadata stored with a value. Values are stored in
+// an envelope when the store is opened with an option that needs one, such
+// as WithTypeInfo, WithCompression or WithEncryption. Values stored without
+// one read back as an Envelope with Version 0.
+type Envelope struct {
+	Version    byte
+	Flags      EnvelopeFlags
+	TypeName   string // with EnvelopeTypeName
+	Transforms []byte // with EnvelopeTransforms, the tags in the order applied
+}
+
+// WithTypeInfo stores the name of the Go type of every value written with
+// Put, and the other methods encoding values, in the value's envelope,
+// where tools can find it with DecodeEnvelope.
+func WithTypeInfo() Option {
+	return func(o *options) {
+		o.typeInfo = true
+	}
+}
+
+// typeName returns the name recorded for value by WithTypeInfo.
+func typeName(value interface{}) string {
+	return reflect.TypeOf(value).String()
+}
+
+// encodeEnvelope wraps payload in an envelope. The flags are derived from
+// the metadata present.
+func encodeEnvelope(e Envelope, payload []byte) []byte {
+	var meta []byte
+	item := func(tag byte, data []byte) {
+		meta = append(meta, tag)
+		meta = appendUvarint(meta, uint64(len(data)))
+		meta = append(meta, data...)
+	}
+	var flags EnvelopeFlags
+	if e.TypeName != "" {
+		flags |= EnvelopeTypeName
+		item(metaTypeName, []byte(e.TypeName))
+	}
+	if len(e.Transforms) > 0 {
+		flags |= EnvelopeTransforms
+		item(metaTransforms, e.Transforms)
+	}
+	out := make([]byte, 0, 3+binary.MaxVarintLen64+len(meta)+len(payload))
+	out = append(out, tagEnvelope, envelopeVersion, byte(flags))
+	out = appendUvarint(out, uint64(len(meta)))
+	out = append(out, meta...)
+	return append(out, payload...)
+}
+
+// DecodeEnvelope splits an encoded value, as returned by Encode or GetRaw,
+// into its envelope and its payload. For values stored without an
+// envelope, it returns an Envelope with Version 0 and raw itself. The
+// payload is returned as stored: if the envelope lists transforms, they
+// still have to be reversed, which needs a store opened with them.
+func DecodeEnvelope(raw []byte) (Envelope, []byte, error) {
+	if len(raw) == 0 || raw[0] != tagEnvelope {
+		return Envelope{}, raw, nil
+	}
+	if len(raw) < 3 {
+		return Envelope{}, nil, fmt.Errorf("%w: truncated header", ErrBadEnvelope)
+	}
+	e := Envelope{Version: raw[1], Flags: EnvelopeFlags(raw[2])}
+	if e.Version != envelopeVersion {
+		return Envelope{}, nil, fmt.Errorf("%w: version %d", ErrEnvelopeVersion, e.Version)
+	} else if unknown := e.Flags &^ envelopeKnownFlags; unknown != 0 {
+		return Envelope{}, nil, fmt.Errorf("%w: flags 0x%02x", ErrEnvelopeVersion, byte(unknown))
+	}
+	n, l := binary.Uvarint(raw[3:])
+	if l <= 0 || n > uint64(len(raw)-3-l) {
+		return Envelope{}, nil, fmt.Errorf("%w: truncated metadata", ErrBadEnvelope)
+	}
+	meta, payload := raw[3+l:3+l+int(n)], raw[3+l+int(n):]
+	var found EnvelopeFlags
+	for len(meta) > 0 {
+		tag := meta[0]
+		n, l := binary.Uvarint(meta[1:])
+		if l <= 0 || n > uint64(len(meta)-1-l) {
+			return Envelope{}, nil, fmt.Errorf("%w: truncated metadata item", ErrBadEnvelope)
+		}
+		data := meta[1+l : 1+l+int(n)]
+		meta = meta[1+l+int(n):]
+		switch tag {
+		case metaTypeName:
+			e.TypeName = string(data)
+			found |= EnvelopeTypeName
+		case metaTransforms:
+			e.Transforms = append([]byte(nil), data...)
+			found |= EnvelopeTransforms
+		}
+	}
+	if found&e.Flags != e.Flags {
+		return Envelope{}, nil, fmt.Errorf("%w: metadata missing for flags 0x%02x", ErrBadEnvelope, byte(e.Flags&^found))
+	}
+	return e, payload, nil
+}
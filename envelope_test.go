@@ -0,0 +1,111 @@
+package bboltkv
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEnvelopeLegacy(t *testing.T) {
+	db := openTestStore(t)
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := db.GetRaw("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, payload, err := DecodeEnvelope(raw)
+	if err != nil || e.Version != 0 || !bytes.Equal(payload, raw) {
+		t.Fatalf("got %+v, %v", e, err)
+	}
+
+	// a store writing envelopes still reads bare values
+	typed := openTestStore(t, WithTypeInfo())
+	if err := typed.PutEncoded("legacy", raw); err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	if err := typed.Get("legacy", &got); err != nil || got != "value" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
+
+func TestEnvelopeFlags(t *testing.T) {
+	for _, c := range []struct {
+		opts  []Option
+		flags EnvelopeFlags
+	}{
+		{[]Option{WithTypeInfo()}, EnvelopeTypeName},
+		{[]Option{WithCompression()}, EnvelopeTransforms},
+		{[]Option{WithTypeInfo(), WithChecksums()}, EnvelopeTypeName | EnvelopeTransforms},
+	} {
+		db := openTestStore(t, c.opts...)
+		if err := db.Put("key", &schemaUser{"ann"}); err != nil {
+			t.Fatal(err)
+		}
+		raw, err := db.GetRaw("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, _, err := DecodeEnvelope(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Version != 1 || e.Flags != c.flags {
+			t.Fatalf("got %+v, expected flags %d", e, c.flags)
+		}
+		if c.flags&EnvelopeTypeName != 0 && e.TypeName != "*bboltkv.schemaUser" {
+			t.Fatalf("got type name %q", e.TypeName)
+		}
+		if (c.flags&EnvelopeTransforms != 0) != (len(e.Transforms) == 1) {
+			t.Fatalf("got transforms %v", e.Transforms)
+		}
+		var u schemaUser
+		if err := db.Get("key", &u); err != nil || u.Name != "ann" {
+			t.Fatalf("got %v, %v", u, err)
+		}
+	}
+
+	// metadata items this release does not know are skipped
+	raw := encodeEnvelope(Envelope{TypeName: "int"}, []byte("payload"))
+	raw = append(raw[:3], append([]byte{byte(raw[3] + 3), 99, 1, 'x'}, raw[4:]...)...)
+	e, payload, err := DecodeEnvelope(raw)
+	if err != nil || e.TypeName != "int" || string(payload) != "payload" {
+		t.Fatalf("got %+v, %q, %v", e, payload, err)
+	}
+}
+
+func TestEnvelopeTruncated(t *testing.T) {
+	raw := encodeEnvelope(Envelope{TypeName: "string", Transforms: []byte{1}}, []byte("payload"))
+	metaEnd := len(raw) - len("payload")
+	for n := 1; n < metaEnd; n++ {
+		if _, _, err := DecodeEnvelope(raw[:n]); !errors.Is(err, ErrBadEnvelope) {
+			t.Fatalf("%d bytes: got %v, expected ErrBadEnvelope", n, err)
+		}
+	}
+	// a flag without its metadata
+	bad := encodeEnvelope(Envelope{TypeName: "string"}, nil)
+	bad[2] |= byte(EnvelopeTransforms)
+	if _, _, err := DecodeEnvelope(bad); !errors.Is(err, ErrBadEnvelope) {
+		t.Fatalf("got %v, expected ErrBadEnvelope", err)
+	}
+}
+
+func TestEnvelopeFuture(t *testing.T) {
+	db := openTestStore(t)
+	future := []byte{tagEnvelope, 2, 0, 0, 'x'}
+	if _, _, err := DecodeEnvelope(future); !errors.Is(err, ErrEnvelopeVersion) {
+		t.Fatalf("got %v, expected ErrEnvelopeVersion", err)
+	}
+	if err := db.PutEncoded("key", future); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get("key", new(string)); !errors.Is(err, ErrEnvelopeVersion) {
+		t.Fatalf("got %v, expected ErrEnvelopeVersion", err)
+	}
+	flags := []byte{tagEnvelope, 1, 0x80, 0, 'x'}
+	if _, _, err := DecodeEnvelope(flags); !errors.Is(err, ErrEnvelopeVersion) {
+		t.Fatalf("got %v, expected ErrEnvelopeVersion", err)
+	}
+}
@@ -0,0 +1,259 @@
+package bboltkv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+
+	"go.etcd.io/bbolt"
+)
+
+// defaultEstimateSamples is the number of samples EstimateCountPrefix takes
+// when it is given none.
+const defaultEstimateSamples = 100
+
+// The layout of bbolt's pages: a header of the page id, flags, element
+// count and overflow count, followed by the elements. Branch elements hold
+// the offset of their key from the element, the key size and the child's
+// page id; leaf elements hold flags, the offset, the key size and the value
+// size. Fields are in the byte order of the machine that wrote the file.
+const (
+	pageHeaderSize  = 16
+	pageElementSize = 16
+	branchPageFlag  = 0x01
+	leafPageFlag    = 0x02
+	metaMagic       = 0xED0CDAED
+)
+
+// btreePage is a branch or leaf page of bbolt's B+tree, as read from the
+// file.
+type btreePage struct {
+	leaf     bool
+	keys     [][]byte // first keys of the children for branch pages
+	children []uint64
+}
+
+// pageReader reads the B+tree pages of a database file.
+type pageReader struct {
+	f        *os.File
+	pageSize int
+	order    binary.ByteOrder
+	pages    map[uint64]*btreePage
+}
+
+func newPageReader(path string, pageSize int) (*pageReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &pageReader{f: f, pageSize: pageSize, pages: make(map[uint64]*btreePage)}
+	// the meta page's magic number gives away the byte order
+	magic := make([]byte, 4)
+	if _, err := f.ReadAt(magic, pageHeaderSize); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if binary.LittleEndian.Uint32(magic) == metaMagic {
+		r.order = binary.LittleEndian
+	} else if binary.BigEndian.Uint32(magic) == metaMagic {
+		r.order = binary.BigEndian
+	} else {
+		f.Close()
+		return nil, fmt.Errorf("%w: %s: bad meta page", ErrCorrupt, path)
+	}
+	return r, nil
+}
+
+// page reads and parses the page id, including its overflow pages.
+func (r *pageReader) page(id uint64) (*btreePage, error) {
+	if p := r.pages[id]; p != nil {
+		return p, nil
+	}
+	buf := make([]byte, r.pageSize)
+	if _, err := r.f.ReadAt(buf, int64(id)*int64(r.pageSize)); err != nil {
+		return nil, err
+	}
+	flags := r.order.Uint16(buf[8:])
+	count := int(r.order.Uint16(buf[10:]))
+	if overflow := int(r.order.Uint32(buf[12:])); overflow > 0 {
+		buf = make([]byte, (overflow+1)*r.pageSize)
+		if _, err := r.f.ReadAt(buf, int64(id)*int64(r.pageSize)); err != nil {
+			return nil, err
+		}
+	}
+	p := &btreePage{leaf: flags&leafPageFlag != 0}
+	if !p.leaf && flags&branchPageFlag == 0 {
+		return nil, fmt.Errorf("%w: page %d is not part of a B+tree", ErrCorrupt, id)
+	}
+	for i := 0; i < count; i++ {
+		at := pageHeaderSize + i*pageElementSize
+		if at+pageElementSize > len(buf) {
+			return nil, fmt.Errorf("%w: page %d: element %d out of bounds", ErrCorrupt, id, i)
+		}
+		e := buf[at : at+pageElementSize]
+		var pos, ksize int
+		if p.leaf {
+			pos, ksize = int(r.order.Uint32(e[4:])), int(r.order.Uint32(e[8:]))
+		} else {
+			pos, ksize = int(r.order.Uint32(e[0:])), int(r.order.Uint32(e[4:]))
+			p.children = append(p.children, r.order.Uint64(e[8:]))
+		}
+		if at+pos+ksize > len(buf) {
+			return nil, fmt.Errorf("%w: page %d: key %d out of bounds", ErrCorrupt, id, i)
+		}
+		p.keys = append(p.keys, buf[at+pos:at+pos+ksize])
+	}
+	r.pages[id] = p
+	return p, nil
+}
+
+// sample descends from the page root to a leaf, choosing a random child
+// among those that may hold keys from lo up to, but not including, hi, and
+// returns the number of keys in range on the leaf times the number of
+// choices on the way down. Averaged over many descents, this is the
+// number of keys in range.
+func (r *pageReader) sample(root uint64, lo, hi []byte, rnd *rand.Rand) (float64, error) {
+	weight := 1.0
+	id := root
+	for {
+		p, err := r.page(id)
+		if err != nil {
+			return 0, err
+		}
+		if p.leaf {
+			return weight * float64(countInRange(p.keys, lo, hi)), nil
+		}
+		// child i holds the keys from keys[i] up to keys[i+1]
+		first, last := 0, len(p.keys)-1
+		for first < last && bytes.Compare(p.keys[first+1], lo) <= 0 {
+			first++
+		}
+		for last > first && hi != nil && bytes.Compare(p.keys[last], hi) >= 0 {
+			last--
+		}
+		n := last - first + 1
+		weight *= float64(n)
+		id = p.children[first+rnd.Intn(n)]
+	}
+}
+
+func countInRange(keys [][]byte, lo, hi []byte) int {
+	n := 0
+	for _, k := range keys {
+		if bytes.Compare(k, lo) >= 0 && (hi == nil || bytes.Compare(k, hi) < 0) {
+			n++
+		}
+	}
+	return n
+}
+
+// CountPrefix returns the number of entries whose keys start with prefix,
+// leaving out the same keys as Count. It visits every such key; see
+// EstimateCountPrefix for a cheaper approximation.
+func (s *Store) CountPrefix(prefix string) (int, error) {
+	n := 0
+	p, err := s.sealPrefix([]byte(prefix))
+	if err != nil {
+		return 0, err
+	}
+	err = s.view(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(s.bucketName).Cursor()
+		for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Next() {
+			if !s.hidden(tx, k) {
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// EstimateCountPrefix estimates the number of entries whose keys start with
+// prefix, without visiting them. It takes the given number of samples, 100
+// if it is 0 or less, each descending bbolt's B+tree from the root to a
+// random leaf among those that may hold such keys, and extrapolates from
+// the number of children on the way down and the number of keys on the
+// leaf. Its cost thus grows with the number of samples and the depth of the
+// tree, which grows with the logarithm of the number of keys, rather than
+// with the number of keys counted.
+//
+// bound is the relative error the estimate stays within in about 95% of
+// calls, computed from the spread of the samples: 0.1 means the count
+// should be within 10% of the estimate. On keys spread evenly over the
+// pages, as bbolt's fill factor keeps them, 100 samples typically give a
+// bound of a few percent; the bound shrinks with the square root of the
+// number of samples. Trees whose pages hold very different numbers of keys,
+// such as after deleting large runs of keys, which leaves pages partly
+// empty until they are written again, or after storing large values next
+// to small ones, make the samples vary more, and the estimate less
+// reliable; the bound then understates the error when the samples happen
+// to miss the unusual pages. If the keys with the prefix fit on one page,
+// the count is exact, and bound is 0.
+//
+// Unlike CountPrefix, the estimate includes entries that have expired but
+// have not been swept yet, and the key written by WithSelfStats.
+func (s *Store) EstimateCountPrefix(prefix string, samples int) (estimate int, bound float64, err error) {
+	if samples <= 0 {
+		samples = defaultEstimateSamples
+	}
+	lo, err := s.sealPrefix([]byte(prefix))
+	if err != nil {
+		return 0, 0, err
+	}
+	hi := prefixEnd(lo)
+	err = s.view(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucketName)
+		if b.Root() == 0 {
+			// an inline bucket, which is small
+			estimate = countCursor(b.Cursor(), lo)
+			return nil
+		}
+		r, err := newPageReader(tx.DB().Path(), tx.DB().Info().PageSize)
+		if err != nil {
+			return err
+		}
+		defer r.f.Close()
+		root, err := r.page(uint64(b.Root()))
+		if err != nil {
+			return err
+		}
+		if root.leaf {
+			estimate = countInRange(root.keys, lo, hi)
+			return nil
+		}
+		rnd := rand.New(rand.NewSource(rand.Int63()))
+		var sum, sumSq float64
+		for i := 0; i < samples; i++ {
+			x, err := r.sample(uint64(b.Root()), lo, hi, rnd)
+			if err != nil {
+				return err
+			}
+			sum += x
+			sumSq += x * x
+		}
+		mean := sum / float64(samples)
+		estimate = int(math.Round(mean))
+		if mean > 0 && samples > 1 {
+			variance := (sumSq - sum*mean) / float64(samples-1)
+			if variance > 0 {
+				bound = 2 * math.Sqrt(variance/float64(samples)) / mean
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return estimate, bound, nil
+}
+
+func countCursor(c *bbolt.Cursor, prefix []byte) int {
+	n := 0
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		n++
+	}
+	return n
+}
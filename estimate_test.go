@@ -0,0 +1,112 @@
+package bboltkv
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// fillPrefixes writes n entries per prefix, with values of size value(i).
+func fillPrefixes(t testing.TB, db *Store, n map[string]int, value func(i int) int) {
+	t.Helper()
+	for prefix, count := range n {
+		for from := 0; from < count; from += 10000 {
+			entries := make(map[string]interface{})
+			for i := from; i < count && i < from+10000; i++ {
+				entries[fmt.Sprintf("%s%08d", prefix, i)] = strings.Repeat("x", value(i))
+			}
+			if err := db.PutAll(entries); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func checkEstimate(t *testing.T, db *Store, prefix string, tolerance float64) {
+	t.Helper()
+	exact, err := db.CountPrefix(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	est, bound, err := db.EstimateCountPrefix(prefix, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	off := 0.0
+	if exact > 0 {
+		off = math.Abs(float64(est-exact)) / float64(exact)
+	} else if est != 0 {
+		off = math.Inf(1)
+	}
+	if off > tolerance {
+		t.Errorf("%q: estimated %d (bound %.3f), exact %d, off by %.1f%%", prefix, est, bound, exact, 100*off)
+	}
+}
+
+func TestEstimateCountPrefix(t *testing.T) {
+	for _, size := range []int{50, 5000, 50000} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			db := openTestStore(t)
+			fillPrefixes(t, db, map[string]int{
+				"session:": size,
+				"user:":    size / 2,
+				"z:":       size / 10,
+			}, func(int) int { return 20 })
+			for _, prefix := range []string{"session:", "user:", "z:", "", "session:0000", "missing:"} {
+				checkEstimate(t, db, prefix, 0.1)
+			}
+		})
+	}
+}
+
+func TestEstimateCountPrefixSkewed(t *testing.T) {
+	db := openTestStore(t)
+	// some values fill whole pages, so pages hold very different numbers
+	// of keys
+	fillPrefixes(t, db, map[string]int{"a:": 20000, "b:": 20000}, func(i int) int {
+		if i%50 == 0 {
+			return 2000
+		}
+		return 10
+	})
+	// deleting runs of keys leaves pages partly empty
+	if _, err := db.DeleteWhere(func(key string, _ []byte) bool {
+		return strings.HasPrefix(key, "b:") && key[len(key)-3] < '5'
+	}); err != nil {
+		t.Fatal(err)
+	}
+	checkEstimate(t, db, "a:", 0.25)
+	checkEstimate(t, db, "b:", 0.25)
+}
+
+func TestEstimateCountPrefixSmall(t *testing.T) {
+	db := openTestStore(t)
+	if est, bound, err := db.EstimateCountPrefix("", 10); err != nil || est != 0 || bound != 0 {
+		t.Fatalf("empty store: %d, %f, %v", est, bound, err)
+	}
+	fillStore(t, db, 20)
+	// a single leaf, counted exactly
+	if est, bound, err := db.EstimateCountPrefix("k0001", 10); err != nil || est != 10 || bound != 0 {
+		t.Fatalf("got %d, %f, %v, expected 10 exactly", est, bound, err)
+	}
+}
+
+func BenchmarkCountPrefix(b *testing.B) {
+	db := openTestStore(b)
+	fillPrefixes(b, db, map[string]int{"session:": 1000000, "user:": 100000}, func(int) int { return 16 })
+	b.Run("Exact", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := db.CountPrefix("session:"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Estimate", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, _, err := db.EstimateCountPrefix("session:", 100); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package bboltkv
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// exportEntry is a line of the ExportJSON format.
+type exportEntry struct {
+	Key   string     `json:"key"`
+	Value []byte     `json:"value,omitempty"`
+	Time  *time.Time `json:"time,omitempty"` // see PutIfNewer
+
+	Deleted bool `json:"deleted,omitempty"` // see ExportSince
+}
+
+// importBatch is the number of entries ImportJSON and Merge write per
+// transaction, unless WithAdaptiveBatching sizes their batches.
+const importBatch = 1000
+
+// ExportJSON writes all entries of the store to w as JSON, one object per
+// line, holding the key and the encoded value in base64, and the timestamp
+// of entries written with PutIfNewer:
+//
+//	{"key":"user:42","value":"Dv+BAwEBBFVzZXIB/4IAAQIBBE5hbWUBDAABA0FnZQEEAAAAC/+CAQNBbm4BVAA="}
+//
+// The entries are read in a single transaction, so the export is a
+// consistent snapshot, in key order. Only keys, values and timestamps are
+// exported: TTLs, tags, lists and the contents of other internal buckets
+// are not, and
+// keys that have expired, or are left out of Keys, are skipped. Use
+// ImportJSON to read an export back in.
+func (s *Store) ExportJSON(w io.Writer, opts ...OpOption) error {
+	o := buildOpOptions(opts)
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	err := s.view(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucketName)
+		p := s.newProgress(o, b.Stats().KeyN)
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if s.hidden(tx, k) {
+				continue
+			}
+			v, err := s.assemble(tx, k, v)
+			if err != nil {
+				return err
+			}
+			key, err := s.openKey(k)
+			if err != nil {
+				return err
+			}
+			e := exportEntry{Key: key, Value: v}
+			if ts, ok := s.timestamp(tx, k); ok {
+				e.Time = &ts
+			}
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+			if err := p.step(key); err != nil {
+				return err
+			}
+		}
+		return p.done()
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// ImportJSON reads entries in the format written by ExportJSON from r and
+// puts them into the store, replacing entries with the same keys, unless
+// WithSkipExisting is given. Values
+// are validated like those of PutEncoded. Entries are written in batches,
+// each in its own transaction, so an import that fails part way leaves the
+// batches before the failure in place. It returns the number of entries
+// imported. With WithLastWriteWins, entries older than those they would
+// replace are skipped, and not counted.
+func (s *Store) ImportJSON(r io.Reader, opts ...OpOption) (int, error) {
+	o := buildOpOptions(opts)
+	p := s.newProgress(o, -1)
+	dec := json.NewDecoder(r)
+	written := 0
+	for {
+		var batch []exportEntry
+		for len(batch) < p.batch.size {
+			var e exportEntry
+			if err := dec.Decode(&e); err == io.EOF {
+				break
+			} else if err != nil {
+				return written, err
+			}
+			if err := s.validateEncoded(e.Key, e.Value); err != nil {
+				return written, err
+			}
+			batch = append(batch, e)
+		}
+		if len(batch) == 0 {
+			return written, p.done()
+		}
+		n, err := s.writeBatch(batch, p, o)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+// writeBatch puts the entries of a batch, and reports progress for them
+// once they have been committed. It returns the number of entries written,
+// which with WithLastWriteWins leaves out those that lost to newer ones,
+// and with WithSkipExisting those already present.
+func (s *Store) writeBatch(batch []exportEntry, p *progress, o opOptions) (int, error) {
+	s.yieldWrites()
+	written := 0
+	start := s.now()
+	err := s.update(s.named("ImportJSON", "", func(w *wtx) error {
+		written = 0
+		for _, e := range batch {
+			key := s.sealKey(e.Key)
+			if o.lastWriteWins && !w.newer(key, e.Time) {
+				continue
+			}
+			if o.skipExisting && w.get(key) != nil {
+				continue
+			}
+			if err := w.put(key, e.Value); err != nil {
+				return err
+			}
+			if e.Time != nil {
+				if err := w.setTimestamp(key, *e.Time); err != nil {
+					return err
+				}
+			}
+			written++
+		}
+		return nil
+	}))
+	if err != nil {
+		return 0, err
+	}
+	if err := p.batch.done(len(batch), s.now().Sub(start)); err != nil {
+		return written, err
+	}
+	for _, e := range batch {
+		if err := p.step(e.Key); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
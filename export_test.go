@@ -0,0 +1,51 @@
+package bboltkv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unknownnf/bboltkv/testutil"
+)
+
+func TestExportImportJSON(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	src := openTestStore(t, WithClock(clock))
+	fillStore(t, src, 2500)
+	if err := src.PutWithTTL("expired", 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Second)
+	var buf bytes.Buffer
+	if err := src.ExportJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 2500 {
+		t.Fatalf("got %d lines, expected 2500", lines)
+	}
+
+	dst := openTestStore(t)
+	n, err := dst.ImportJSON(&buf)
+	if err != nil {
+		t.Fatal(err)
+	} else if n != 2500 {
+		t.Fatalf("imported %d entries, expected 2500", n)
+	}
+	for _, i := range []int{0, 999, 1000, 2499} {
+		var val int
+		if err := dst.Get(keyN(i), &val); err != nil || val != i {
+			t.Fatalf("%s: got %d, %v", keyN(i), val, err)
+		}
+	}
+	if err := dst.Get("expired", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+
+	if _, err := dst.ImportJSON(strings.NewReader(`{"key":"a","value":"AA=="}` + "\nnot json")); err == nil {
+		t.Fatal("bad input was accepted")
+	}
+	if _, err := dst.ImportJSON(strings.NewReader(`{"key":"a","value":""}`)); err != ErrBadValue {
+		t.Fatalf("got %v, expected ErrBadValue", err)
+	}
+}
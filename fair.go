@@ -0,0 +1,236 @@
+package bboltkv
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WithFairWrites makes Put and Delete queue their writes rather than begin
+// a transaction each. The first write to find the queue empty waits
+// maxBatchDelay for others to join it, and then commits all the writes
+// queued by then in one transaction, so that many small concurrent writes
+// share the cost of a commit. Batch operations that write in transactions
+// of their own per chunk, ImportJSON, Merge, ImportBolt and SweepExpired,
+// let the queued writes go first between chunks, rather than competing
+// with them for bbolt's write lock, which favours the goroutine that just
+// released it.
+//
+// Put and Delete still return once their write has been committed, with
+// its own result: a write that fails, such as a Delete of a missing key,
+// does not fail the others sharing its transaction. Writes are applied in
+// the order they were queued, so two writes to the same key, the second
+// made after the first returned, apply in that order. The deadlines of
+// PutContext and DeleteContext only apply while the write waits in the
+// queue. With WithWriteBuffer, Put buffers its writes as before, and only
+// Delete is queued. See WriteQueueStats for how deep the queue is.
+func WithFairWrites(maxBatchDelay time.Duration) Option {
+	return func(o *options) {
+		o.fairWrites = true
+		o.fairDelay = maxBatchDelay
+	}
+}
+
+// WriteQueueStats describes the queue of writes kept with WithFairWrites.
+type WriteQueueStats struct {
+	Queued  int    // writes waiting in the queue or being committed
+	Batches uint64 // transactions committed for queued writes
+	Writes  uint64 // queued writes committed in them
+}
+
+// WriteQueueStats reports on the queue of writes kept with WithFairWrites.
+// Without it, the queue is always empty.
+func (s *Store) WriteQueueStats() WriteQueueStats {
+	if s.fair == nil {
+		return WriteQueueStats{}
+	}
+	q := s.fair
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return WriteQueueStats{Queued: q.queued, Batches: q.batches, Writes: q.writes}
+}
+
+// queuedWrite is a Put or Delete waiting in the write queue.
+type queuedWrite struct {
+	key    string
+	raw    []byte
+	delete bool
+	meta   map[string]string
+	err    chan error // receives the result once the write is done
+}
+
+func (op *queuedWrite) apply(w *wtx) error {
+	w.meta = op.meta
+	if op.delete {
+		return w.s.deleteOne(w, op.key)
+	}
+	return w.put(op.key, op.raw)
+}
+
+// writeQueue holds the writes queued with WithFairWrites.
+type writeQueue struct {
+	delay time.Duration
+
+	mu      sync.Mutex
+	pending []*queuedWrite
+	// committed is closed once the latest batch taken from pending has
+	// been committed; batch operations wait for it between chunks.
+	committed chan struct{}
+	queued    int
+	batches   uint64
+	writes    uint64
+}
+
+func newWriteQueue(delay time.Duration) *writeQueue {
+	committed := make(chan struct{})
+	close(committed)
+	return &writeQueue{delay: delay, committed: committed}
+}
+
+// withdraw removes op from the queue, and reports whether it was still
+// waiting there.
+func (q *writeQueue) withdraw(op *queuedWrite) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, p := range q.pending {
+		if p == op {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			q.queued--
+			return true
+		}
+	}
+	return false
+}
+
+// queueWrite implements Put and Delete for a store with WithFairWrites.
+func (s *Store) queueWrite(ctx context.Context, op *queuedWrite) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.gate.exit()
+	if atomic.LoadInt32(&s.readOnly) != 0 {
+		return ErrReadOnly
+	}
+	if _, ok := ctx.Deadline(); !ok && s.opts.opTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.opts.opTimeout)
+		defer cancel()
+	}
+	op.err = make(chan error, 1)
+	q := s.fair
+	q.mu.Lock()
+	leader := len(q.pending) == 0
+	if leader {
+		q.committed = make(chan struct{})
+	}
+	q.pending = append(q.pending, op)
+	q.queued++
+	q.mu.Unlock()
+
+	if leader {
+		// once the store is closing, no more writes can join
+		select {
+		case <-s.clock().After(q.delay):
+		case <-s.done:
+		}
+		s.commitQueue()
+		return <-op.err
+	}
+	select {
+	case err := <-op.err:
+		return err
+	case <-ctx.Done():
+	}
+	if !q.withdraw(op) {
+		// already being committed
+		return <-op.err
+	}
+	return contextError(ctx)
+}
+
+// commitQueue commits the writes waiting in the queue, in as few
+// transactions as it can: if a write fails, the writes before it are
+// committed without it, and it is tried again first in the next
+// transaction, where it only fails on its own.
+func (s *Store) commitQueue() {
+	q := s.fair
+	q.mu.Lock()
+	batch := q.pending
+	q.pending = nil
+	committed := q.committed
+	q.mu.Unlock()
+	defer close(committed)
+
+	var err error
+	if atomic.LoadInt32(&s.readOnly) != 0 {
+		err = ErrReadOnly
+	} else if s.wbuf != nil {
+		err = s.flushBuffer()
+	}
+	if err != nil {
+		s.finishQueued(batch, err, false)
+		return
+	}
+	n := len(batch)
+	for len(batch) > 0 {
+		failed := -1
+		err := s.retry(context.Background(), func(w *wtx) error {
+			failed = -1
+			for i, op := range batch[:n] {
+				if err := op.apply(w); err != nil {
+					failed = i
+					return err
+				}
+			}
+			return nil
+		}, s.write)
+		switch {
+		case failed < 0:
+			// committed, or the commit failed
+			s.finishQueued(batch[:n], err, true)
+			batch = batch[n:]
+			n = len(batch)
+		case failed == 0:
+			s.finishQueued(batch[:1], err, false)
+			batch = batch[1:]
+			n = len(batch)
+		default:
+			n = failed
+		}
+	}
+}
+
+// finishQueued hands err to the writes of ops, counting a batch if they
+// shared a commit.
+func (s *Store) finishQueued(ops []*queuedWrite, err error, batch bool) {
+	q := s.fair
+	q.mu.Lock()
+	q.queued -= len(ops)
+	if err == nil {
+		if batch {
+			q.batches++
+		}
+		q.writes += uint64(len(ops))
+	}
+	q.mu.Unlock()
+	for _, op := range ops {
+		op.err <- err
+	}
+}
+
+// yieldWrites waits for the writes queued with WithFairWrites, if any, to
+// be committed. Batch operations call it between chunks, outside of any
+// transaction.
+func (s *Store) yieldWrites() {
+	if s.fair == nil {
+		return
+	}
+	s.fair.mu.Lock()
+	committed := s.fair.committed
+	s.fair.mu.Unlock()
+	select {
+	case <-committed:
+	case <-s.done:
+	}
+}
@@ -0,0 +1,213 @@
+package bboltkv
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// putLatencies runs a bulk import into db, over and over, next to workers
+// each putting keys in a loop, for the given duration, and returns the
+// latencies of the puts, sorted. Every commit takes at least commitCost,
+// standing in for the fsync of a slow disk.
+func putLatencies(t *testing.T, db *Store, dump []byte, workers int, commitCost, d time.Duration) []time.Duration {
+	t.Helper()
+	db.txHook = func() error {
+		time.Sleep(commitCost)
+		return nil
+	}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := db.ImportJSON(bytes.NewReader(dump)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	var mu sync.Mutex
+	var latencies []time.Duration
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; ; n++ {
+				select {
+				case <-stop:
+					return
+				case <-time.After(time.Millisecond):
+				}
+				start := time.Now()
+				if err := db.Put(fmt.Sprintf("req:%d:%d", i, n), n); err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				latencies = append(latencies, time.Since(start))
+				mu.Unlock()
+			}
+		}(i)
+	}
+	time.Sleep(d)
+	close(stop)
+	wg.Wait()
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return latencies
+}
+
+func percentile(latencies []time.Duration, p float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	return latencies[int(p*float64(len(latencies)-1))]
+}
+
+func TestFairWritesLatency(t *testing.T) {
+	if testing.Short() {
+		t.Skip("measures latencies for seconds")
+	}
+	src := openTestStore(t)
+	entries := make(map[string]interface{})
+	for i := 0; i < 5000; i++ {
+		entries[fmt.Sprintf("bulk:%06d", i)] = strings.Repeat("x", 200)
+	}
+	if err := src.PutAll(entries); err != nil {
+		t.Fatal(err)
+	}
+	var dump bytes.Buffer
+	if err := src.ExportJSON(&dump); err != nil {
+		t.Fatal(err)
+	}
+
+	plain := putLatencies(t, openTestStore(t), dump.Bytes(), 16, 10*time.Millisecond, time.Second)
+	fair := putLatencies(t, openTestStore(t, WithFairWrites(time.Millisecond)), dump.Bytes(), 16, 10*time.Millisecond, time.Second)
+	for _, p := range []float64{0.5, 0.9, 0.99} {
+		t.Logf("p%v: %v without fair writes, %v with", 100*p, percentile(plain, p), percentile(fair, p))
+	}
+	if percentile(fair, 0.99) >= percentile(plain, 0.99)/2 {
+		t.Errorf("p99 %v with fair writes, not below half of %v without", percentile(fair, 0.99), percentile(plain, 0.99))
+	}
+}
+
+func TestFairWritesOrder(t *testing.T) {
+	db := openTestStore(t, WithFairWrites(time.Millisecond))
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			key := fmt.Sprint("g", g)
+			for i := 0; i < 50; i++ {
+				if err := db.Put(key, i); err != nil {
+					t.Error(err)
+					return
+				}
+				var v int
+				if err := db.Get(key, &v); err != nil || v != i {
+					t.Errorf("%s: got %d, %v after putting %d", key, v, err, i)
+					return
+				}
+				if i%10 == 9 {
+					if err := db.Delete(key); err != nil {
+						t.Error(err)
+						return
+					}
+					if ok, err := db.Has(key); err != nil || ok {
+						t.Errorf("%s still there after Delete: %v", key, err)
+						return
+					}
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if st := db.WriteQueueStats(); st.Queued != 0 || st.Writes != 8*55 || st.Batches >= st.Writes {
+		t.Fatalf("got %+v", st)
+	}
+}
+
+func TestFairWritesErrors(t *testing.T) {
+	db := openTestStore(t, WithFairWrites(50*time.Millisecond))
+	if err := db.Put("p", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Protect("p"); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 12)
+	for i := 0; i < 10; i++ {
+		go func(i int) { errs <- db.Put(keyN(i), i) }(i)
+	}
+	go func() { errs <- db.Delete("missing") }()
+	go func() { errs <- db.Delete("p") }()
+	var failed []error
+	for i := 0; i < 12; i++ {
+		if err := <-errs; err != nil {
+			failed = append(failed, err)
+		}
+	}
+	// each write has its own result
+	if len(failed) != 2 || (failed[0] != ErrNotFound && failed[0] != ErrProtected) ||
+		(failed[1] != ErrNotFound && failed[1] != ErrProtected) || failed[0] == failed[1] {
+		t.Fatalf("got errors %v", failed)
+	}
+	if n, err := db.Count(); err != nil || n != 11 {
+		t.Fatalf("%d entries, %v", n, err)
+	}
+	if st := db.WriteQueueStats(); st.Queued != 0 || st.Writes != 11 {
+		t.Fatalf("got %+v", st)
+	}
+}
+
+func TestFairWritesClose(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(name, "test", WithFairWrites(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		go func(i int) { errs <- db.Put(keyN(i), i) }(i)
+	}
+	for db.WriteQueueStats().Queued < 50 {
+		time.Sleep(time.Millisecond)
+	}
+	// Close cuts the wait for more writes short, and commits those queued
+	start := time.Now()
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Fatalf("Close took %v", d)
+	}
+	for i := 0; i < 50; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Put("late", 1); err != ErrClosed {
+		t.Fatalf("got %v, expected ErrClosed", err)
+	}
+
+	db, err = Open(name, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if n, err := db.Count(); err != nil || n != 50 {
+		t.Fatalf("%d entries after reopening, %v", n, err)
+	}
+}
@@ -0,0 +1,194 @@
+package bboltkv
+
+import "sync"
+
+// FallbackStore reads from a primary store, and falls back to a secondary
+// store for the keys the primary does not hold, such as while migrating
+// from an old database file to a new one. Use the NewFallbackStore()
+// function to create one.
+//
+// Writes go to the primary only. In particular, Delete removes an entry
+// from the primary, and an entry for the same key in the secondary then
+// shows through again; delete it from the secondary as well, with
+// Secondary, to remove it for good.
+type FallbackStore struct {
+	primary, secondary *Store
+	backfill           bool
+	syncBackfill       bool
+
+	reads     flightGroup // keyed by the key read from the secondary
+	mu        sync.Mutex
+	pending   map[string]bool // keys being backfilled in the background
+	wg        sync.WaitGroup
+	backfills int // backfills actually made, for tests
+}
+
+// FallbackOption configures a FallbackStore, see NewFallbackStore.
+type FallbackOption func(*FallbackStore)
+
+// WithSyncBackfill makes a FallbackStore backfill entries before the read
+// that found them in the secondary returns, rather than in the background.
+func WithSyncBackfill() FallbackOption {
+	return func(f *FallbackStore) {
+		f.syncBackfill = true
+	}
+}
+
+// NewFallbackStore returns a store reading from primary, and from secondary
+// for the keys primary does not hold. If backfill is set, each entry found
+// in the secondary is also copied into the primary, in the background
+// unless WithSyncBackfill is given, so that later reads find it there. A
+// backfill does not replace an entry written to the primary meanwhile, and
+// if it fails, such as with ErrQuotaExceeded, the read it came from still
+// succeeds; the entry is then backfilled again on a later read.
+//
+// The FallbackStore does not own the stores; close them once done with it,
+// after Wait if backfills may be under way.
+//
+//	store := bboltkv.NewFallbackStore(newStore, oldStore, true)
+func NewFallbackStore(primary, secondary *Store, backfill bool, opts ...FallbackOption) *FallbackStore {
+	f := &FallbackStore{
+		primary:   primary,
+		secondary: secondary,
+		backfill:  backfill,
+		reads:     flightGroup{calls: make(map[string]*flight)},
+		pending:   make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// Primary returns the store reads try first, and writes go to.
+func (f *FallbackStore) Primary() *Store {
+	return f.primary
+}
+
+// Secondary returns the store reads fall back to.
+func (f *FallbackStore) Secondary() *Store {
+	return f.secondary
+}
+
+// Get an entry from the primary, or else from the secondary, see Store.Get.
+// It returns ErrNotFound only if neither holds the key.
+func (f *FallbackStore) Get(key string, value interface{}) error {
+	raw, err := f.GetRaw(key)
+	if err != nil || value == nil {
+		return err
+	}
+	return f.primary.decode(raw, value)
+}
+
+// GetRaw returns the encoded bytes of an entry from the primary, or else
+// from the secondary, see Store.GetRaw. Bytes from the secondary are
+// converted to the format of the primary's transforms, so that they can
+// always be decoded with the primary's Decode.
+func (f *FallbackStore) GetRaw(key string) ([]byte, error) {
+	raw, err := f.primary.GetRaw(key)
+	if err != ErrNotFound {
+		return raw, err
+	}
+	// concurrent misses of the same key share a read of the secondary,
+	// and its backfill
+	return f.reads.do(key, func() ([]byte, error) {
+		return f.fallback(key)
+	})
+}
+
+// Has reports whether the primary or the secondary holds an entry for key,
+// see Store.Has. It does not backfill.
+func (f *FallbackStore) Has(key string) (bool, error) {
+	ok, err := f.primary.Has(key)
+	if ok || err != nil {
+		return ok, err
+	}
+	return f.secondary.Has(key)
+}
+
+// Put an entry into the primary, see Store.Put.
+func (f *FallbackStore) Put(key string, value interface{}) error {
+	return f.primary.Put(key, value)
+}
+
+// Delete the entry with the given key from the primary, see Store.Delete
+// and FallbackStore.
+func (f *FallbackStore) Delete(key string) error {
+	return f.primary.Delete(key)
+}
+
+// Wait waits for the backfills under way in the background to finish.
+func (f *FallbackStore) Wait() {
+	f.wg.Wait()
+}
+
+// fallback reads key from the secondary, in the primary's format, and
+// backfills it.
+func (f *FallbackStore) fallback(key string) ([]byte, error) {
+	raw, err := f.secondary.GetRaw(key)
+	if err != nil {
+		return nil, err
+	}
+	if raw, err = reencode(f.secondary, f.primary, raw); err != nil {
+		return nil, err
+	}
+	if !f.backfill {
+		return raw, nil
+	}
+	if f.syncBackfill {
+		f.backfillKey(key, raw)
+		return raw, nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.pending[key] {
+		f.pending[key] = true
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			f.backfillKey(key, raw)
+			f.mu.Lock()
+			delete(f.pending, key)
+			f.mu.Unlock()
+		}()
+	}
+	return raw, nil
+}
+
+// backfillKey stores raw under key in the primary, unless it holds the key
+// by now.
+func (f *FallbackStore) backfillKey(key string, raw []byte) {
+	s := f.primary
+	if s.validateEncoded(key, raw) != nil {
+		return
+	}
+	stored := s.sealKey(key)
+	wrote := false
+	err := s.update(func(w *wtx) error {
+		if w.get(stored) != nil {
+			wrote = false
+			return nil
+		}
+		wrote = true
+		return w.put(stored, raw)
+	})
+	if err == nil && wrote {
+		f.mu.Lock()
+		f.backfills++
+		f.mu.Unlock()
+	}
+}
+
+// reencode converts raw bytes as stored by src to the format of dst's
+// transforms.
+func reencode(src, dst *Store, raw []byte) ([]byte, error) {
+	e, _, err := DecodeEnvelope(raw)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := src.pipeline.untransform(raw)
+	if err != nil {
+		return nil, err
+	}
+	return dst.pipeline.transformNamed(plain, e.TypeName)
+}
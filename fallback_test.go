@@ -0,0 +1,148 @@
+package bboltkv
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestFallbackStore(t *testing.T) {
+	primary, secondary := openTestStore(t), openTestStore(t)
+	f := NewFallbackStore(primary, secondary, false)
+	if err := primary.Put("a", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := secondary.Put("a", "old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := secondary.Put("b", "old"); err != nil {
+		t.Fatal(err)
+	}
+	var v string
+	if err := f.Get("a", &v); err != nil || v != "new" {
+		t.Fatalf("got %q, %v, expected the primary's value", v, err)
+	}
+	if err := f.Get("b", &v); err != nil || v != "old" {
+		t.Fatalf("got %q, %v, expected the secondary's value", v, err)
+	}
+	if err := f.Get("c", &v); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	for key, want := range map[string]bool{"a": true, "b": true, "c": false} {
+		if ok, err := f.Has(key); err != nil || ok != want {
+			t.Fatalf("Has(%q) = %v, %v", key, ok, err)
+		}
+	}
+	// without backfill, the primary is left alone
+	if err := primary.Get("b", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected b not to be backfilled", err)
+	}
+}
+
+func TestFallbackStoreWrites(t *testing.T) {
+	primary, secondary := openTestStore(t), openTestStore(t)
+	f := NewFallbackStore(primary, secondary, true)
+	if err := secondary.Put("a", "old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Put("a", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Put("b", "new"); err != nil {
+		t.Fatal(err)
+	}
+	var v string
+	if err := secondary.Get("a", &v); err != nil || v != "old" {
+		t.Fatalf("got %q, %v in the secondary", v, err)
+	}
+	if err := secondary.Get("b", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected b only in the primary", err)
+	}
+	// deleting from the primary lets the secondary show through
+	if err := f.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Get("a", &v); err != nil || v != "old" {
+		t.Fatalf("got %q, %v after Delete", v, err)
+	}
+	f.Wait()
+}
+
+func TestFallbackStoreBackfill(t *testing.T) {
+	for _, syncBackfill := range []bool{false, true} {
+		primary, secondary := openTestStore(t, WithCompression()), openTestStore(t)
+		var opts []FallbackOption
+		if syncBackfill {
+			opts = append(opts, WithSyncBackfill())
+		}
+		f := NewFallbackStore(primary, secondary, true, opts...)
+		if err := secondary.Put("a", "old"); err != nil {
+			t.Fatal(err)
+		}
+		var v string
+		if err := f.Get("a", &v); err != nil || v != "old" {
+			t.Fatalf("got %q, %v", v, err)
+		}
+		if !syncBackfill {
+			f.Wait()
+		}
+		// the backfilled entry is in the primary's format
+		if err := primary.Get("a", &v); err != nil || v != "old" {
+			t.Fatalf("sync %v: got %q, %v in the primary", syncBackfill, v, err)
+		}
+		raw, err := primary.GetRaw("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fromF, err := f.GetRaw("a")
+		if err != nil || !bytes.Equal(raw, fromF) {
+			t.Fatalf("got %x, %v, expected %x", fromF, err, raw)
+		}
+		// a backfill does not replace a newer write
+		if err := secondary.Put("b", "old"); err != nil {
+			t.Fatal(err)
+		}
+		raw, err = f.GetRaw("b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := primary.Put("b", "new"); err != nil {
+			t.Fatal(err)
+		}
+		f.backfillKey("b", raw)
+		if err := primary.Get("b", &v); err != nil || v != "new" {
+			t.Fatalf("got %q, %v after a late backfill", v, err)
+		}
+		f.Wait()
+	}
+}
+
+func TestFallbackStoreConcurrentBackfill(t *testing.T) {
+	for _, syncBackfill := range []bool{false, true} {
+		primary, secondary := openTestStore(t), openTestStore(t)
+		var opts []FallbackOption
+		if syncBackfill {
+			opts = append(opts, WithSyncBackfill())
+		}
+		f := NewFallbackStore(primary, secondary, true, opts...)
+		if err := secondary.Put("a", "old"); err != nil {
+			t.Fatal(err)
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var v string
+				if err := f.Get("a", &v); err != nil || v != "old" {
+					t.Errorf("got %q, %v", v, err)
+				}
+			}()
+		}
+		wg.Wait()
+		f.Wait()
+		if f.backfills != 1 {
+			t.Fatalf("sync %v: backfilled %d times", syncBackfill, f.backfills)
+		}
+	}
+}
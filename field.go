@@ -0,0 +1,171 @@
+package bboltkv
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ErrFieldNotFound is wrapped by the error GetField returns when the value
+// has nothing at the path, and by the one GetProjection returns when the
+// value has none of the projection's fields.
+var ErrFieldNotFound = errors.New("bboltkv: field not found")
+
+// GetField reads the part of a JSON value at path into out, like
+// json.Unmarshal, without decoding the rest of it. path names the part
+// with the keys of the objects and the indexes of the arrays leading to
+// it, joined with dots, so "items.0.status" is the status of the first of
+// the items; keys holding dots cannot be named. The empty path names the
+// whole value. The value is read with a streaming decoder, which steps
+// over what comes before the part, and stops once it is read. If there is
+// nothing at path, GetField returns an error wrapping ErrFieldNotFound; if
+// no such key is present in the store, ErrNotFound.
+//
+// Values are JSON if they are stored as JSON text: those of types whose
+// MarshalText or MarshalBinary method returns JSON, stored with
+// WithMarshalerPreference, and []byte values holding JSON, such as
+// json.RawMessage, however they were stored. Gob-encoded values are
+// otherwise not JSON; see GetProjection for reading part of those.
+//
+//	var status string
+//	err := store.GetField("ticket:42", "state.status", &status)
+func (s *Store) GetField(key, path string, out interface{}) (err error) {
+	if s.opts.tracer != nil {
+		defer s.startSpan(s.opts.tracer, "GetField", key)(&err)
+	}
+	raw, err := s.GetRaw(key)
+	if err != nil {
+		return err
+	}
+	doc, err := s.jsonText(raw)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	if path != "" {
+		for _, part := range strings.Split(path, ".") {
+			found, err := seekField(dec, part)
+			if err != nil {
+				return fmt.Errorf("bboltkv: reading JSON value: %w", err)
+			} else if !found {
+				return fmt.Errorf("%w: %s", ErrFieldNotFound, path)
+			}
+		}
+	}
+	return dec.Decode(out)
+}
+
+// jsonText returns the JSON text of an encoded value.
+func (s *Store) jsonText(raw []byte) ([]byte, error) {
+	raw, err := s.pipeline.untransform(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) > 0 && (raw[0] == tagTextMarshaler || raw[0] == tagBinaryMarshaler) {
+		return raw[1:], nil
+	}
+	var doc []byte
+	if len(raw) == 0 || isTag(raw[0]) || gob.NewDecoder(bytes.NewReader(raw)).Decode(&doc) != nil {
+		return nil, errors.New("bboltkv: value is not stored as JSON")
+	}
+	return doc, nil
+}
+
+// seekField moves dec, at the start of a value, to the start of the value
+// under key if it is an object, or at the index key if it is an array, and
+// reports whether there is one.
+func seekField(dec *json.Decoder, key string) (bool, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return false, err
+	}
+	switch t {
+	case json.Delim('{'):
+		for dec.More() {
+			t, err := dec.Token()
+			if err != nil {
+				return false, err
+			}
+			if t == key {
+				return true, nil
+			}
+			if err := skipValue(dec); err != nil {
+				return false, err
+			}
+		}
+	case json.Delim('['):
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 {
+			return false, nil
+		}
+		for ; dec.More(); i-- {
+			if i == 0 {
+				return true, nil
+			}
+			if err := skipValue(dec); err != nil {
+				return false, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// skipValue moves dec past the value it is at.
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		t, err := dec.Token()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		} else if err != nil {
+			return err
+		}
+		switch t {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// GetProjection reads part of a gob-encoded value into projection, a
+// pointer to a struct declaring only the fields wanted, under the names
+// and with the types they have in the value. Gob matches fields by name,
+// so the others are stepped over rather than decoded: a projection holding
+// a small field of a large struct spares allocating and filling in the
+// rest. Fields of the projection that the value lacks are left alone. If
+// the value holds none of them, GetProjection returns an error wrapping
+// ErrFieldNotFound; if no such key is present in the store, ErrNotFound.
+//
+// Unlike Get, GetProjection does not check the value against a schema, see
+// WithSchemaCheckedGets, as a projection is not the type registered.
+//
+//	var status struct{ Status string }
+//	err := store.GetProjection("ticket:42", &status)
+func (s *Store) GetProjection(key string, projection interface{}) (err error) {
+	if s.opts.tracer != nil {
+		defer s.startSpan(s.opts.tracer, "GetProjection", key)(&err)
+	}
+	if v := reflect.ValueOf(projection); v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("bboltkv: projection must be a pointer to a struct, not %T", projection)
+	}
+	raw, err := s.GetRaw(key)
+	if err != nil {
+		return err
+	}
+	err = s.decode(raw, projection)
+	if err != nil && strings.Contains(err.Error(), "no fields matched") {
+		return fmt.Errorf("%w: %s has none of the fields of %T", ErrFieldNotFound, key, projection)
+	}
+	return err
+}
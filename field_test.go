@@ -0,0 +1,228 @@
+package bboltkv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testTicketJSON = `{
+	"id": 42,
+	"title": "printer jammed",
+	"state": {"status": "open", "since": "2026-10-01", "labels": ["hw", "urgent"]},
+	"comments": [
+		{"author": "ana", "text": "again?"},
+		{"author": "bo", "text": "replaced the drum", "meta": {"edited": true}}
+	]
+}`
+
+// jsonTicket is stored as its JSON text under WithMarshalerPreference.
+type jsonTicket struct {
+	doc string
+}
+
+func (t jsonTicket) MarshalText() ([]byte, error) { return []byte(t.doc), nil }
+
+func TestGetField(t *testing.T) {
+	db := openTestStore(t, WithMarshalerPreference())
+	if err := db.Put("text", jsonTicket{testTicketJSON}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("raw", json.RawMessage(testTicketJSON)); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path     string
+		expected interface{}
+	}{
+		{"id", 42},
+		{"title", "printer jammed"},
+		{"state.status", "open"},
+		{"state.labels", []string{"hw", "urgent"}},
+		{"state.labels.1", "urgent"},
+		{"comments.1.author", "bo"},
+		{"comments.1.meta.edited", true},
+		{"comments.0", map[string]interface{}{"author": "ana", "text": "again?"}},
+	}
+	for _, key := range []string{"text", "raw"} {
+		for _, test := range tests {
+			out := reflect.New(reflect.TypeOf(test.expected))
+			if err := db.GetField(key, test.path, out.Interface()); err != nil {
+				t.Fatalf("%s %q: %v", key, test.path, err)
+			} else if got := out.Elem().Interface(); !reflect.DeepEqual(got, test.expected) {
+				t.Fatalf("%s %q: got %#v, expected %#v", key, test.path, got, test.expected)
+			}
+		}
+		// the empty path is the whole value
+		var doc struct {
+			State struct{ Status string }
+		}
+		if err := db.GetField(key, "", &doc); err != nil {
+			t.Fatalf("%s: %v", key, err)
+		} else if doc.State.Status != "open" {
+			t.Fatalf("%s: got %+v", key, doc)
+		}
+	}
+}
+
+func TestGetFieldNotFound(t *testing.T) {
+	db := openTestStore(t)
+	if err := db.Put("raw", json.RawMessage(testTicketJSON)); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{
+		"missing",
+		"state.missing",
+		"state.labels.2",
+		"state.labels.-1",
+		"state.labels.first",
+		"title.length",
+		"comments.1.meta.edited.again",
+	} {
+		var out interface{}
+		if err := db.GetField("raw", path, &out); !errors.Is(err, ErrFieldNotFound) {
+			t.Fatalf("%q: got %v, expected ErrFieldNotFound", path, err)
+		} else if !strings.Contains(err.Error(), path) {
+			t.Fatalf("%q: path missing from %q", path, err)
+		}
+	}
+	var out string
+	if err := db.GetField("absent", "title", &out); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if err := db.Put("gob", struct{ Title string }{"printer jammed"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.GetField("gob", "title", &out); err == nil || !strings.Contains(err.Error(), "not stored as JSON") {
+		t.Fatalf("got %v, expected an error for a value not stored as JSON", err)
+	}
+	if err := db.Put("broken", json.RawMessage(`{"state": {"status": `)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.GetField("broken", "title", &out); err == nil || errors.Is(err, ErrFieldNotFound) {
+		t.Fatalf("got %v, expected an error for malformed JSON", err)
+	}
+}
+
+type testFullTicket struct {
+	ID       int
+	Title    string
+	Body     string
+	Labels   []string
+	Comments []testComment
+}
+
+type testComment struct {
+	Author, Text string
+}
+
+func TestGetProjection(t *testing.T) {
+	db := openTestStore(t)
+	in := testFullTicket{
+		ID:       42,
+		Title:    "printer jammed",
+		Body:     strings.Repeat("paper everywhere. ", 100),
+		Labels:   []string{"hw", "urgent"},
+		Comments: []testComment{{"ana", "again?"}, {"bo", "replaced the drum"}},
+	}
+	if err := db.Put("ticket", in); err != nil {
+		t.Fatal(err)
+	}
+	var title struct {
+		Title  string
+		Labels []string
+	}
+	if err := db.GetProjection("ticket", &title); err != nil {
+		t.Fatal(err)
+	} else if title.Title != in.Title || !reflect.DeepEqual(title.Labels, in.Labels) {
+		t.Fatalf("got %+v", title)
+	}
+	// fields the value lacks are left alone
+	partial := struct {
+		ID       int
+		Assignee string
+	}{Assignee: "unset"}
+	if err := db.GetProjection("ticket", &partial); err != nil {
+		t.Fatal(err)
+	} else if partial.ID != 42 || partial.Assignee != "unset" {
+		t.Fatalf("got %+v", partial)
+	}
+	var comments struct{ Comments []struct{ Author string } }
+	if err := db.GetProjection("ticket", &comments); err != nil {
+		t.Fatal(err)
+	} else if len(comments.Comments) != 2 || comments.Comments[1].Author != "bo" {
+		t.Fatalf("got %+v", comments)
+	}
+
+	var none struct{ Assignee string }
+	if err := db.GetProjection("ticket", &none); !errors.Is(err, ErrFieldNotFound) {
+		t.Fatalf("got %v, expected ErrFieldNotFound", err)
+	}
+	if err := db.GetProjection("absent", &title); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	var notStruct string
+	if err := db.GetProjection("ticket", &notStruct); err == nil {
+		t.Fatal("projected into a string")
+	}
+	if err := db.GetProjection("ticket", title); err == nil {
+		t.Fatal("projected into a non-pointer")
+	}
+}
+
+func BenchmarkGetField(b *testing.B) {
+	db := openTestStore(b)
+	in := testFullTicket{ID: 42, Title: "printer jammed", Body: strings.Repeat("paper everywhere. ", 100)}
+	for i := 0; i < 50; i++ {
+		in.Comments = append(in.Comments, testComment{fmt.Sprintf("user%d", i), strings.Repeat("me too. ", 20)})
+	}
+	doc, err := json.Marshal(in)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := db.Put("json", json.RawMessage(doc)); err != nil {
+		b.Fatal(err)
+	}
+	if err := db.Put("gob", in); err != nil {
+		b.Fatal(err)
+	}
+	b.Run("json/field", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var id int
+			if err := db.GetField("json", "ID", &id); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("json/full", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var raw json.RawMessage
+			if err := db.Get("json", &raw); err != nil {
+				b.Fatal(err)
+			}
+			var out testFullTicket
+			if err := json.Unmarshal(raw, &out); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("gob/projection", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var out struct{ ID int }
+			if err := db.GetProjection("gob", &out); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("gob/full", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var out testFullTicket
+			if err := db.Get("gob", &out); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
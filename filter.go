@@ -0,0 +1,181 @@
+package bboltkv
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"go.etcd.io/bbolt"
+)
+
+// defaultBitsPerKey is the filter size used when WithNegativeLookupFilter
+// is given a size below 1, for a false positive rate of about 1%.
+const defaultBitsPerKey = 10
+
+// minFilterKeys is the least number of keys a filter is sized for, so that
+// an empty store does not start out with a tiny one.
+const minFilterKeys = 1024
+
+// WithNegativeLookupFilter keeps a Bloom filter of the store's keys in
+// memory, so that Get and Has can answer for most keys that are absent
+// without reading the file. The filter never misses a key that is present;
+// for absent keys it reports a false "maybe present", and so a read of the
+// file, at a rate set by bitsPerKey: about 8% at 5 bits, 1% at 10 and
+// 0.1% at 15, where each bit costs an eighth of a byte per key. Values below
+// 1 mean 10.
+//
+// The filter is built when the store is opened, by reading all keys, and
+// sized for twice as many keys as the store then holds. Writes add their
+// keys to it; once they have added as many as it was sized for, it is
+// rebuilt in the background, for twice the keys the store then holds.
+// Deletes cannot take keys out of a Bloom filter, so they leave it as it
+// is, and the false positive rate grows as keys are deleted. RebuildFilter
+// builds it afresh; call it after deleting many keys.
+//
+// A store cannot use a filter on a bucket that another store sharing the
+// file also uses, see OpenShared, as it would miss that store's writes.
+func WithNegativeLookupFilter(bitsPerKey int) Option {
+	return func(o *options) {
+		if bitsPerKey < 1 {
+			bitsPerKey = defaultBitsPerKey
+		}
+		o.filterBitsPerKey = bitsPerKey
+	}
+}
+
+// bloomFilter is a Bloom filter over keys. Bit positions are derived from
+// the two halves of a 64-bit FNV-1a hash, by double hashing.
+type bloomFilter struct {
+	mu       sync.RWMutex
+	bits     []uint64
+	hashes   uint64
+	capacity int // keys the filter is sized for
+	added    int // keys added, counting repeats
+}
+
+func newBloomFilter(keys, bitsPerKey int) *bloomFilter {
+	if keys < minFilterKeys {
+		keys = minFilterKeys
+	}
+	words := (keys*bitsPerKey + 63) / 64
+	hashes := uint64(math.Round(float64(bitsPerKey) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	} else if hashes > 30 {
+		hashes = 30
+	}
+	return &bloomFilter{bits: make([]uint64, words), hashes: hashes, capacity: keys}
+}
+
+func filterHash(key []byte) (h1, h2 uint64) {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	return sum & 0xffffffff, sum>>32 | 1
+}
+
+// add sets the bits of key, and reports whether the filter now holds more
+// keys than it was sized for.
+func (f *bloomFilter) add(key []byte) (full bool) {
+	h1, h2 := filterHash(key)
+	n := uint64(len(f.bits) * 64)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := uint64(0); i < f.hashes; i++ {
+		bit := (h1 + i*h2) % n
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.added++
+	return f.added > f.capacity
+}
+
+// mayContain reports whether key may have been added: if it returns false,
+// the key definitely has not.
+func (f *bloomFilter) mayContain(key []byte) bool {
+	h1, h2 := filterHash(key)
+	n := uint64(len(f.bits) * 64)
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for i := uint64(0); i < f.hashes; i++ {
+		bit := (h1 + i*h2) % n
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// filterAbsent reports whether the lookup filter rules out that key is
+// present. Without a filter, it never does.
+func (s *Store) filterAbsent(key string) bool {
+	s.filterMu.RLock()
+	f := s.filter
+	s.filterMu.RUnlock()
+	return f != nil && !f.mayContain([]byte(key))
+}
+
+// filterAdd adds key to the lookup filter, if there is one, and reports
+// whether the filter needs to grow. It is called before the write adding
+// the key commits, so that no reader can see the key in the file but not in
+// the filter; should the write fail, the filter merely has a false positive
+// more.
+func (s *Store) filterAdd(key string) (full bool) {
+	if s.opts.filterBitsPerKey == 0 {
+		return false
+	}
+	s.filterMu.RLock()
+	f := s.filter
+	s.filterMu.RUnlock()
+	return f != nil && f.add([]byte(key))
+}
+
+// growFilter rebuilds the lookup filter in the background, unless it is
+// already being rebuilt. If the store closes first, the rebuild fails, and
+// the next write to the full filter tries again.
+func (s *Store) growFilter() {
+	if !atomic.CompareAndSwapInt32(&s.filterGrowing, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&s.filterGrowing, 0)
+		s.RebuildFilter()
+	}()
+}
+
+// buildFilter builds the lookup filter from the keys in tx, and replaces
+// the store's with it. No write may commit meanwhile, or its key could be
+// missed.
+func (s *Store) buildFilter(tx *bbolt.Tx) {
+	b := tx.Bucket(s.bucketName)
+	f := newBloomFilter(2*b.Stats().KeyN, s.opts.filterBitsPerKey)
+	b.ForEach(func(k, _ []byte) error {
+		f.add(k)
+		return nil
+	})
+	s.filterMu.Lock()
+	s.filter = f
+	s.filterMu.Unlock()
+}
+
+// RebuildFilter builds the filter of WithNegativeLookupFilter afresh from
+// the keys in the store, dropping the keys deleted since it was built and
+// resizing it for the number of keys the store holds now. Writes wait
+// while it reads the keys; Get and Has keep using the old filter until the
+// new one is complete. Without the option, RebuildFilter does nothing.
+func (s *Store) RebuildFilter() error {
+	if s.opts.filterBitsPerKey == 0 {
+		return nil
+	}
+	if atomic.LoadInt32(&s.readOnly) != 0 {
+		// nothing writes, so a read transaction sees every key
+		return s.view(func(tx *bbolt.Tx) error {
+			s.buildFilter(tx)
+			return nil
+		})
+	}
+	return s.update(func(w *wtx) error {
+		s.buildFilter(w.tx)
+		return nil
+	})
+}
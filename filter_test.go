@@ -0,0 +1,199 @@
+package bboltkv
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// filterMisses returns how many of the n absent keys with the given prefix
+// the filter rules out.
+func filterMisses(db *Store, prefix string, n int) int {
+	ruled := 0
+	for i := 0; i < n; i++ {
+		if db.filterAbsent(fmt.Sprintf("%s%05d", prefix, i)) {
+			ruled++
+		}
+	}
+	return ruled
+}
+
+func TestNegativeLookupFilter(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(name, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillStore(t, db, 2000)
+	db.Close()
+
+	db, err = Open(name, "test", WithNegativeLookupFilter(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// keys from the file, and keys written since it was opened
+	if err := db.Put("new", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutAll(map[string]interface{}{"batch:1": 1, "batch:2": 2}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2000; i++ {
+		var v int
+		if err := db.Get(keyN(i), &v); err != nil || v != i {
+			t.Fatalf("Get(%s): got %d, %v", keyN(i), v, err)
+		}
+	}
+	for _, key := range []string{"new", "batch:1", "batch:2"} {
+		if ok, err := db.Has(key); err != nil || !ok {
+			t.Fatalf("Has(%s): %v, %v", key, ok, err)
+		}
+	}
+
+	// absent keys are reported absent, most without reading the file
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("miss%05d", i)
+		if ok, err := db.Has(key); err != nil || ok {
+			t.Fatalf("Has(%s): %v, %v", key, ok, err)
+		}
+		if err := db.Get(key, nil); err != ErrNotFound {
+			t.Fatalf("Get(%s): got %v, expected ErrNotFound", key, err)
+		}
+	}
+	if ruled := filterMisses(db, "miss", 2000); ruled < 1900 {
+		t.Fatalf("filter ruled out only %d of 2000 absent keys", ruled)
+	}
+
+	// deleted keys stay in the filter, but are still not found
+	if err := db.Delete("new"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.Has("new"); err != nil || ok {
+		t.Fatalf("Has after Delete: %v, %v", ok, err)
+	}
+}
+
+func TestNegativeLookupFilterRebuild(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(name, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillStore(t, db, 5000)
+	db.Close()
+	// opened with all keys present, so that the filter does not grow
+	db, err = Open(name, "test", WithNegativeLookupFilter(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.DeleteWhere(func(key string, _ []byte) bool { return key >= keyN(100) }); err != nil {
+		t.Fatal(err)
+	}
+	if ruled := filterMisses(db, "k", 5000); ruled > 100 {
+		t.Fatalf("filter ruled out %d keys before the rebuild", ruled)
+	}
+	if err := db.RebuildFilter(); err != nil {
+		t.Fatal(err)
+	}
+	if ruled := filterMisses(db, "k", 5000); ruled < 4700 {
+		t.Fatalf("filter ruled out only %d of 4900 deleted keys after the rebuild", ruled)
+	}
+	for i := 0; i < 100; i++ {
+		if ok, err := db.Has(keyN(i)); err != nil || !ok {
+			t.Fatalf("Has(%s) after the rebuild: %v, %v", keyN(i), ok, err)
+		}
+	}
+	if err := db.Put("after", 1); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.Has("after"); err != nil || !ok {
+		t.Fatalf("Has(after): %v, %v", ok, err)
+	}
+}
+
+func TestNegativeLookupFilterGrows(t *testing.T) {
+	db := openTestStore(t, WithNegativeLookupFilter(10))
+	for i := 0; i < 10; i++ {
+		entries := make(map[string]interface{})
+		for j := 0; j < 1000; j++ {
+			entries[keyN(i*1000+j)] = j
+		}
+		if err := db.PutAll(entries); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the filter was sized for 1024 keys, and is rebuilt in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for filterMisses(db, "miss", 2000) < 1900 {
+		if time.Now().After(deadline) {
+			t.Fatalf("filter ruled out only %d of 2000 absent keys", filterMisses(db, "miss", 2000))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < 10000; i++ {
+		if ok, err := db.Has(keyN(i)); err != nil || !ok {
+			t.Fatalf("Has(%s): %v, %v", keyN(i), ok, err)
+		}
+	}
+}
+
+func TestNegativeLookupFilterWriteBuffer(t *testing.T) {
+	db := openTestStore(t, WithNegativeLookupFilter(10), WithWriteBuffer(100, 0))
+	if err := db.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.Has("a"); err != nil || !ok {
+		t.Fatalf("Has before the flush: %v, %v", ok, err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.Has("a"); err != nil || !ok {
+		t.Fatalf("Has after the flush: %v, %v", ok, err)
+	}
+}
+
+func TestNegativeLookupFilterShared(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	a, err := OpenShared(path, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if _, err := OpenShared(path, "a", WithNegativeLookupFilter(10)); !errors.Is(err, ErrOptionMismatch) {
+		t.Fatalf("got %v, expected ErrOptionMismatch", err)
+	}
+	b, err := OpenShared(path, "b", WithNegativeLookupFilter(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+}
+
+func BenchmarkGetMiss(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		opts []Option
+	}{
+		{"NoFilter", nil},
+		{"Filter", []Option{WithNegativeLookupFilter(10)}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			db := openTestStore(b, bench.opts...)
+			fillStore(b, db, 10000)
+			if err := db.RebuildFilter(); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := db.Get(fmt.Sprintf("miss%d", i), nil); err != ErrNotFound {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
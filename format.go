@@ -0,0 +1,22 @@
+package bboltkv
+
+// Encoded values normally hold a gob stream. A gob stream starts with the
+// length of its first message, encoded either as a single byte below 0x80,
+// or as a byte count from 0xf8 to 0xff followed by that many bytes. So no
+// gob stream starts with a byte from 0x80 to 0xf7, and values that are
+// stored some other way start with a tag byte from that range instead.
+const (
+	tagBinaryMarshaler byte = 0x80 // encoding.BinaryMarshaler output follows
+	tagTextMarshaler   byte = 0x81 // encoding.TextMarshaler output follows
+	tagList            byte = 0x82 // list header, see Append
+	tagTransformed     byte = 0x83 // transform tag and transformed value follow, see ValueTransform
+	tagEnvelope        byte = 0x84 // envelope with metadata, see Envelope
+	tagChunked         byte = 0x85 // header of a value split into chunks, see WithChunkThreshold
+	tagDedup           byte = 0x86 // SHA-256 of a deduplicated value follows, see WithDeduplication
+)
+
+// isTag reports whether an encoded value starting with b is tagged, rather
+// than being a plain gob stream.
+func isTag(b byte) bool {
+	return b >= 0x80 && b < 0xf8
+}
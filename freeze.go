@@ -0,0 +1,176 @@
+package bboltkv
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrFrozen is returned by writes while the database file is frozen, see
+// Freeze, if the store was opened with WithFreezeErrors.
+var ErrFrozen = errors.New("bboltkv: database is frozen")
+
+// WithFreezeErrors makes writes fail with ErrFrozen while the database file
+// is frozen, see Freeze, rather than wait for it to thaw.
+func WithFreezeErrors() Option {
+	return func(o *options) {
+		o.freezeErrors = true
+	}
+}
+
+// WithMaxFreeze makes Freeze thaw the database file by itself after d, in
+// case the caller never does, so that a snapshot gone wrong does not hold
+// off writers for good.
+func WithMaxFreeze(d time.Duration) Option {
+	return func(o *options) {
+		o.maxFreeze = d
+	}
+}
+
+// freezer holds off write transactions on a database file while it is
+// frozen. Stores sharing the file, see OpenShared, share its freezer.
+type freezer struct {
+	mu     sync.Mutex
+	active int // write transactions begun or about to begin
+	frozen bool
+	thawed chan struct{} // closed when the current freeze ends
+	idle   chan struct{} // closed once active drops to 0 while frozen
+}
+
+func newFreezer() *freezer {
+	return &freezer{}
+}
+
+// hold registers a write transaction about to begin, once the file is not
+// frozen, and fails with ErrFrozen instead of waiting if fail is set.
+func (f *freezer) hold(ctx context.Context, fail bool) error {
+	f.mu.Lock()
+	for f.frozen {
+		if fail {
+			f.mu.Unlock()
+			return ErrFrozen
+		}
+		thawed := f.thawed
+		f.mu.Unlock()
+		select {
+		case <-thawed:
+		case <-ctx.Done():
+			return contextError(ctx)
+		}
+		f.mu.Lock()
+	}
+	f.active++
+	f.mu.Unlock()
+	return nil
+}
+
+// release registers the end of a write transaction registered with hold.
+func (f *freezer) release() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.active--; f.active == 0 && f.idle != nil {
+		close(f.idle)
+		f.idle = nil
+	}
+}
+
+// freeze holds off new write transactions, waits for those under way to
+// end, and returns the channel closed when the freeze ends.
+func (f *freezer) freeze(ctx context.Context) (chan struct{}, error) {
+	f.mu.Lock()
+	for f.frozen {
+		thawed := f.thawed
+		f.mu.Unlock()
+		select {
+		case <-thawed:
+		case <-ctx.Done():
+			return nil, contextError(ctx)
+		}
+		f.mu.Lock()
+	}
+	f.frozen = true
+	thawed := make(chan struct{})
+	f.thawed = thawed
+	var idle chan struct{}
+	if f.active > 0 {
+		idle = make(chan struct{})
+		f.idle = idle
+	}
+	f.mu.Unlock()
+	if idle != nil {
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			f.thaw(thawed)
+			return nil, contextError(ctx)
+		}
+	}
+	return thawed, nil
+}
+
+// thaw ends the freeze that returned thawed, if it has not ended yet.
+func (f *freezer) thaw(thawed chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.frozen && f.thawed == thawed {
+		f.frozen = false
+		f.idle = nil
+		close(thawed)
+	}
+}
+
+// holdWrite registers a write transaction of the store with its freezer.
+func (s *Store) holdWrite(ctx context.Context) error {
+	return s.freezer.hold(ctx, s.opts.freezeErrors)
+}
+
+// Freeze quiesces the database file, for taking a snapshot of the volume
+// it is on while the process keeps running: it waits for the write
+// transactions under way to finish, holds off new ones, and syncs the file
+// to disk. Until thaw is called, the file does not change. Reads work as
+// before, but writes wait for the thaw, bounded by their deadlines such as
+// WithOpTimeout, or fail with ErrFrozen with WithFreezeErrors. The freeze
+// covers all stores sharing the file, see OpenShared.
+//
+// If ctx is done before the writes under way have finished, Freeze gives
+// up with ErrTimeout or ctx's error, and the file is not frozen. A second
+// Freeze waits for the first to thaw. With WithMaxFreeze, the file thaws
+// by itself after the given duration; it also thaws when the store that
+// froze it is closed. Calling thaw more than once, or after the file
+// thawed by itself, does nothing.
+//
+//	thaw, err := store.Freeze(ctx)
+//	if err != nil {
+//	    return err
+//	}
+//	defer thaw()
+//	return snapshotVolume()
+func (s *Store) Freeze(ctx context.Context) (thaw func(), err error) {
+	if err := s.enter(); err != nil {
+		return nil, err
+	}
+	defer s.gate.exit()
+	thawed, err := s.freezer.freeze(ctx)
+	if err != nil {
+		return nil, err
+	}
+	thaw = func() { s.freezer.thaw(thawed) }
+	if err := s.db.Sync(); err != nil {
+		thaw()
+		return nil, err
+	}
+	var expired <-chan time.Time
+	if s.opts.maxFreeze > 0 {
+		expired = s.clock().After(s.opts.maxFreeze)
+	}
+	go func() {
+		select {
+		case <-expired:
+		case <-s.done:
+		case <-thawed:
+		}
+		thaw()
+	}()
+	return thaw, nil
+}
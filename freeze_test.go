@@ -0,0 +1,206 @@
+package bboltkv
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// lastTxID returns the id of the latest committed transaction of db's file.
+func lastTxID(t *testing.T, db *Store) int {
+	t.Helper()
+	var id int
+	if err := db.db.View(func(tx *bbolt.Tx) error {
+		id = tx.ID()
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestFreeze(t *testing.T) {
+	db := openTestStore(t)
+	if err := db.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	thaw, err := db.Freeze(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := lastTxID(t, db)
+	done := make(chan error, 1)
+	go func() { done <- db.Put("b", 2) }()
+	select {
+	case err := <-done:
+		t.Fatalf("Put returned %v while frozen", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	// reads go on
+	var v int
+	if err := db.Get("a", &v); err != nil || v != 1 {
+		t.Fatalf("got %d, %v while frozen", v, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := db.PutContext(ctx, "c", 3); err != ErrTimeout {
+		t.Fatalf("got %v, expected ErrTimeout", err)
+	}
+	if got := lastTxID(t, db); got != id {
+		t.Fatalf("transaction %d committed while frozen", got)
+	}
+
+	thaw()
+	thaw()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get("b", &v); err != nil || v != 2 {
+		t.Fatalf("got %d, %v after thawing", v, err)
+	}
+}
+
+func TestFreezeErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	a, err := OpenShared(path, "a", WithFreezeErrors())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := OpenShared(path, "b", WithFreezeErrors())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err := b.Put("k", 1); err != nil {
+		t.Fatal(err)
+	}
+	thaw, err := a.Freeze(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the freeze covers the whole file
+	for name, err := range map[string]error{
+		"Put a":    a.Put("k", 2),
+		"Put b":    b.Put("k", 2),
+		"Delete b": b.Delete("k"),
+		"PutAll a": a.PutAll(map[string]interface{}{"x": 1}),
+	} {
+		if err != ErrFrozen {
+			t.Errorf("%s: got %v, expected ErrFrozen", name, err)
+		}
+	}
+	thaw()
+	if err := b.Delete("k"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFreezeAutoThaw(t *testing.T) {
+	db := openTestStore(t, WithMaxFreeze(50*time.Millisecond))
+	thaw, err := db.Freeze(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := db.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Fatalf("Put went through after %v", d)
+	}
+	// a second freeze is not thawed by the first's thaw
+	thaw2, err := db.Freeze(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer thaw2()
+	thaw()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := db.PutContext(ctx, "b", 2); err != ErrTimeout {
+		t.Fatalf("got %v, expected ErrTimeout", err)
+	}
+}
+
+func TestFreezeWaitsForWrites(t *testing.T) {
+	db := openTestStore(t)
+	started := make(chan struct{})
+	db.txHook = func() error {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	}
+	done := make(chan error, 1)
+	go func() { done <- db.Put("a", 1) }()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := db.Freeze(ctx); err != ErrTimeout {
+		t.Fatalf("got %v, expected ErrTimeout", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	db.txHook = nil
+	// giving up left the file thawed
+	if err := db.Put("b", 2); err != nil {
+		t.Fatal(err)
+	}
+	thaw, err := db.Freeze(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	thaw()
+}
+
+func TestFreezeUnderLoad(t *testing.T) {
+	db := openTestStore(t, WithFairWrites(time.Millisecond))
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; ; n++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := db.Put(fmt.Sprintf("w%d:%d", i, n%50), n); err != nil {
+					t.Error(err)
+					return
+				}
+				if n%10 == 0 {
+					if err := db.Delete(fmt.Sprintf("w%d:%d", i, n%50)); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}(i)
+	}
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		thaw, err := db.Freeze(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := lastTxID(t, db)
+		time.Sleep(5 * time.Millisecond)
+		if got := lastTxID(t, db); got != id {
+			t.Fatalf("transaction %d committed while frozen at %d", got, id)
+		}
+		thaw()
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,253 @@
+package bboltkv
+
+import (
+	"bytes"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+// FsckKind is the kind of an inconsistency found by Fsck.
+type FsckKind int
+
+const (
+	// FsckOrphanIndex is an index entry without the record it indexes,
+	// or disagreeing with it, such as an expiry time indexed for a key
+	// whose TTL is gone.
+	FsckOrphanIndex FsckKind = iota
+
+	// FsckOrphanMetadata is what the store keeps about a key while the key
+	// is absent, such as its TTL or tags, or about a value that no longer
+	// uses it, such as a chunk beyond the end of a value.
+	FsckOrphanMetadata
+
+	// FsckExpired is an entry whose TTL has run out, which no sweep has
+	// deleted yet, see SweepExpired.
+	FsckExpired
+
+	// FsckMissingMetadata is what the store needs about a key and does
+	// not have, such as the index entry of its TTL, or the chunks of its
+	// value.
+	FsckMissingMetadata
+)
+
+func (k FsckKind) String() string {
+	switch k {
+	case FsckOrphanIndex:
+		return "orphan index entry"
+	case FsckOrphanMetadata:
+		return "orphan metadata"
+	case FsckExpired:
+		return "expired"
+	case FsckMissingMetadata:
+		return "missing metadata"
+	}
+	return fmt.Sprintf("FsckKind(%d)", int(k))
+}
+
+// FsckIssue is an inconsistency found by Fsck.
+type FsckIssue struct {
+	Kind   FsckKind
+	Bucket string // the internal bucket concerned, such as "expiry"
+	Key    string // the key of the entry concerned
+	Detail string // what is wrong
+
+	// Repairable is false for what FsckRepair cannot repair: the chunks
+	// and list elements a value is missing are lost.
+	Repairable bool
+}
+
+// FsckReport is the result of Fsck and FsckRepair.
+type FsckReport struct {
+	Issues   []FsckIssue
+	Repaired int // the issues FsckRepair repaired
+}
+
+// Clean reports whether no issues were found.
+func (r FsckReport) Clean() bool {
+	return len(r.Issues) == 0
+}
+
+// Count returns the number of issues of the given kind.
+func (r FsckReport) Count(kind FsckKind) int {
+	n := 0
+	for _, is := range r.Issues {
+		if is.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// Fsck cross-checks the internal buckets in which the store keeps what it
+// knows about keys, such as their TTLs, tags, timestamps, tombstones,
+// chunks and list elements, against the entries of the store's bucket, and
+// reports what does not match: metadata of keys that are absent, index
+// entries without their records, records without their index entries,
+// values without the chunks or elements they need, and entries that have
+// expired without being swept. Writes through the store keep all of them
+// consistent, but writes through RawUpdate, GetDb or an older version of
+// the package do not. Fsck reads the store in a single transaction and
+// changes nothing; see FsckRepair.
+func (s *Store) Fsck() (FsckReport, error) {
+	var f *fsck
+	err := s.view(func(tx *bbolt.Tx) error {
+		f = s.fsck(tx)
+		return nil
+	})
+	if err != nil {
+		return FsckReport{}, err
+	}
+	return FsckReport{Issues: f.issues}, nil
+}
+
+// FsckRepair runs the checks of Fsck and repairs the issues it finds, in
+// a single transaction: orphans are removed, missing index entries are
+// added, and expired entries are deleted as SweepExpired would. The report
+// lists the issues found, and how many were repaired; those that are not
+// Repairable are left as they are. Deleting such values removes them.
+func (s *Store) FsckRepair() (FsckReport, error) {
+	var report FsckReport
+	err := s.update(func(w *wtx) error {
+		f := s.fsck(w.tx)
+		report = FsckReport{Issues: f.issues}
+		for _, fix := range f.fixes {
+			if fix == nil {
+				continue
+			}
+			if err := fix(w); err != nil {
+				return err
+			}
+			report.Repaired++
+		}
+		return nil
+	})
+	if err != nil {
+		return FsckReport{}, err
+	}
+	return report, nil
+}
+
+// auxCheck cross-checks the internal buckets of a feature against the
+// store's bucket, reporting what it finds to f.
+type auxCheck func(f *fsck)
+
+// auxChecks are the checks Fsck runs. Every feature keeping internal
+// buckets about keys registers one here.
+var auxChecks = []auxCheck{
+	checkExpiry,
+	checkTimestamps,
+	checkTombstones,
+	checkTags,
+	checkChunks,
+	checkLists,
+	checkInsertionOrder,
+	checkScheduledDeletes,
+}
+
+// fsck collects the issues found by the checks in a transaction, with the
+// fixes for them.
+type fsck struct {
+	s      *Store
+	tx     *bbolt.Tx
+	b      *bbolt.Bucket
+	issues []FsckIssue
+	fixes  []func(w *wtx) error // nil for the issues that cannot be repaired
+}
+
+func (s *Store) fsck(tx *bbolt.Tx) *fsck {
+	f := &fsck{s: s, tx: tx, b: tx.Bucket(s.bucketName)}
+	for _, check := range auxChecks {
+		check(f)
+	}
+	return f
+}
+
+// report records an issue with the entry of key, and fix, which repairs it,
+// or nil if it cannot be repaired. Fixes run after all checks, in the same
+// transaction, so they may not hold on to slices read from it.
+func (f *fsck) report(kind FsckKind, bucket string, key []byte, detail string, fix func(w *wtx) error) {
+	name, err := f.s.openKey(key)
+	if err != nil {
+		name = string(key)
+	}
+	f.issues = append(f.issues, FsckIssue{kind, bucket, name, detail, fix != nil})
+	f.fixes = append(f.fixes, fix)
+}
+
+// present reports whether the store's bucket holds key.
+func (f *fsck) present(key []byte) bool {
+	return f.b.Get(key) != nil
+}
+
+// deleteFix returns a fix deleting the given keys from an internal bucket.
+func deleteFix(bucket string, keys ...[]byte) func(w *wtx) error {
+	keys = copyKeys(keys)
+	return func(w *wtx) error {
+		b := w.s.aux(w.tx, bucket)
+		if b == nil {
+			return nil
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// putFix returns a fix adding an empty entry to an internal bucket.
+func putFix(bucket string, key []byte) func(w *wtx) error {
+	key = append([]byte(nil), key...)
+	return func(w *wtx) error {
+		b, err := w.aux(bucket)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, nil)
+	}
+}
+
+func copyKeys(keys [][]byte) [][]byte {
+	copies := make([][]byte, len(keys))
+	for i, k := range keys {
+		copies[i] = append([]byte(nil), k...)
+	}
+	return copies
+}
+
+// checkTimeIndex checks an index keyed by times followed by keys, such as
+// "expiry-index", against the bucket of records mapping the keys to the
+// times. If the index is optional, records are only checked for entries in
+// it once it exists.
+func (f *fsck) checkTimeIndex(records, index string, optional bool) {
+	rb, ib := f.s.aux(f.tx, records), f.s.aux(f.tx, index)
+	if ib != nil {
+		c := ib.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if len(k) < 8 {
+				f.report(FsckOrphanIndex, index, k, "malformed index entry", deleteFix(index, k))
+				continue
+			}
+			var at []byte
+			if rb != nil {
+				at = rb.Get(k[8:])
+			}
+			if at == nil {
+				f.report(FsckOrphanIndex, index, k[8:], "indexed, but not in "+records, deleteFix(index, k))
+			} else if !bytes.Equal(at, k[:8]) {
+				f.report(FsckOrphanIndex, index, k[8:], "indexed at another time than in "+records, deleteFix(index, k))
+			}
+		}
+	}
+	if rb == nil || (ib == nil && optional) {
+		return
+	}
+	c := rb.Cursor()
+	for k, at := c.First(); k != nil; k, at = c.Next() {
+		if ib == nil || ib.Get(expiryIndexKey(at, string(k))) == nil {
+			f.report(FsckMissingMetadata, index, k, "not indexed", putFix(index, expiryIndexKey(at, string(k))))
+		}
+	}
+}
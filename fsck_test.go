@@ -0,0 +1,294 @@
+package bboltkv
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/unknownnf/bboltkv/testutil"
+	"go.etcd.io/bbolt"
+)
+
+// checkIssues checks that report lists the given issues, each as its kind,
+// bucket and key.
+func checkIssues(t *testing.T, report FsckReport, want ...string) {
+	t.Helper()
+	got := []string{}
+	for _, is := range report.Issues {
+		got = append(got, fmt.Sprintf("%s %s %s", is.Kind, is.Bucket, is.Key))
+	}
+	sort.Strings(got)
+	if want == nil {
+		want = []string{}
+	}
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got issues %q, expected %q", got, want)
+	}
+}
+
+// fsckStore returns a store with an entry of each kind Fsck checks.
+func fsckStore(t *testing.T, opts ...Option) *Store {
+	opts = append(opts, WithModTimes(0), WithChunkThreshold(10), WithChunkSize(10))
+	db := openTestStore(t, opts...)
+	if err := db.Put("plain", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithTTL("ttl", 1, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutTagged("tagged", 1, "red", "blue"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("chunked", "a value of a few chunks"); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"a", "b"} {
+		if _, err := db.Append("list", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Put("deleted", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("deleted"); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// auxUpdate changes the internal bucket name of db directly.
+func auxUpdate(t *testing.T, db *Store, name string, fn func(b *bbolt.Bucket) error) {
+	t.Helper()
+	err := db.db.Update(func(tx *bbolt.Tx) error {
+		return fn(tx.Bucket([]byte(db.auxName(name))))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFsckClean(t *testing.T) {
+	db := fsckStore(t)
+	report, err := db.Fsck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Clean() {
+		t.Fatalf("got issues %v", report.Issues)
+	}
+	report, err = db.FsckRepair()
+	if err != nil || !report.Clean() || report.Repaired != 0 {
+		t.Fatalf("got %+v, %v", report, err)
+	}
+	if report, err := openTestStore(t).Fsck(); err != nil || !report.Clean() {
+		t.Fatalf("got %+v, %v for an empty store", report, err)
+	}
+}
+
+func TestFsckOrphanMetadata(t *testing.T) {
+	db := fsckStore(t)
+	err := db.RawUpdate(func(b *bbolt.Bucket) error {
+		for _, k := range []string{"ttl", "tagged", "chunked", "list"} {
+			if err := b.Delete([]byte(k)); err != nil {
+				return err
+			}
+		}
+		return b.Put([]byte("deleted"), []byte("raw"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"orphan metadata expiry ttl",
+		"orphan metadata tags tagged",
+		"orphan metadata chunks chunked",
+		"orphan metadata chunks chunked",
+		"orphan metadata chunks chunked",
+		"orphan metadata lists list",
+		"orphan metadata tombstones deleted",
+	}
+	for _, k := range []string{"ttl", "tagged", "chunked", "list"} {
+		want = append(want, "orphan metadata timestamps "+k)
+	}
+	report, err := db.Fsck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIssues(t, report, want...)
+	if report.Count(FsckOrphanMetadata) != len(want) {
+		t.Fatalf("counted %d orphans", report.Count(FsckOrphanMetadata))
+	}
+
+	report, err = db.FsckRepair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIssues(t, report, want...)
+	if report.Repaired != len(want) {
+		t.Fatalf("repaired %d issues, expected %d", report.Repaired, len(want))
+	}
+	report, err = db.Fsck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIssues(t, report)
+	if keys, err := db.KeysByTag("red"); err != nil || len(keys) != 0 {
+		t.Fatalf("got %v, %v", keys, err)
+	}
+	if keys := expiring(t, db); len(keys) != 0 {
+		t.Fatalf("got %v expiring", keys)
+	}
+	// writing the keys again starts afresh
+	if err := db.Put("list", 1); err != nil {
+		t.Fatal(err)
+	}
+	var got int
+	if err := db.Get("list", &got); err != nil || got != 1 {
+		t.Fatalf("got %d, %v", got, err)
+	}
+}
+
+func TestFsckIndexes(t *testing.T) {
+	db := fsckStore(t)
+	auxUpdate(t, db, expiryIndexBucket, func(b *bbolt.Bucket) error {
+		k, _ := b.Cursor().First()
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+		return b.Put(expiryIndexKey(encodeExpiry(time.Now()), "plain"), nil)
+	})
+	auxUpdate(t, db, tagIndexBucket, func(b *bbolt.Bucket) error {
+		if err := b.Delete(tagIndexKey("red", "tagged")); err != nil {
+			return err
+		}
+		return b.Put(tagIndexKey("green", "tagged"), nil)
+	})
+	auxUpdate(t, db, timestampIndexBucket, func(b *bbolt.Bucket) error {
+		return b.Put(expiryIndexKey(encodeExpiry(time.Now()), "gone"), nil)
+	})
+	auxUpdate(t, db, tombstoneIndexBucket, func(b *bbolt.Bucket) error {
+		k, _ := b.Cursor().First()
+		return b.Delete(k)
+	})
+	want := []string{
+		"missing metadata expiry-index ttl",
+		"orphan index entry expiry-index plain",
+		"missing metadata tag-index tagged",
+		"orphan index entry tag-index tagged",
+		"orphan index entry timestamp-index gone",
+		"missing metadata tombstone-index deleted",
+	}
+	report, err := db.Fsck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIssues(t, report, want...)
+
+	report, err = db.FsckRepair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Repaired != len(want) {
+		t.Fatalf("repaired %d issues, expected %d", report.Repaired, len(want))
+	}
+	report, err = db.Fsck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIssues(t, report)
+	if keys := expiring(t, db); !reflect.DeepEqual(keys, []string{"ttl"}) {
+		t.Fatalf("got %v expiring", keys)
+	}
+	if keys, err := db.KeysByTag("red"); err != nil || !reflect.DeepEqual(keys, []string{"tagged"}) {
+		t.Fatalf("got %v, %v", keys, err)
+	}
+	if keys, err := db.KeysByTag("green"); err != nil || len(keys) != 0 {
+		t.Fatalf("got %v, %v", keys, err)
+	}
+}
+
+func TestFsckExpired(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := fsckStore(t, WithClock(clock))
+	clock.Advance(2 * time.Hour)
+	report, err := db.Fsck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIssues(t, report, "expired expiry ttl")
+
+	if report, err = db.FsckRepair(); err != nil || report.Repaired != 1 {
+		t.Fatalf("got %+v, %v", report, err)
+	}
+	err = db.RawView(func(b *bbolt.Bucket) error {
+		if b.Get([]byte("ttl")) != nil {
+			t.Error("expired key kept")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// deleted as a sweep deletes, leaving a tombstone
+	report, err = db.Fsck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIssues(t, report)
+	err = db.db.View(func(tx *bbolt.Tx) error {
+		if b := db.aux(tx, tombstoneBucket); b == nil || b.Get([]byte("ttl")) == nil {
+			t.Error("no tombstone left")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFsckUnrepairable(t *testing.T) {
+	db := fsckStore(t)
+	auxUpdate(t, db, chunksBucket, func(b *bbolt.Bucket) error {
+		return b.Delete(chunkKey([]byte("chunked"), 1))
+	})
+	auxUpdate(t, db, listBucket, func(b *bbolt.Bucket) error {
+		return b.DeleteBucket(listName("list"))
+	})
+	want := []string{
+		"missing metadata chunks chunked",
+		"missing metadata lists list",
+	}
+	report, err := db.FsckRepair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIssues(t, report, want...)
+	if report.Repaired != 0 {
+		t.Fatalf("repaired %d issues", report.Repaired)
+	}
+	for _, is := range report.Issues {
+		if is.Repairable {
+			t.Fatalf("%+v repairable", is)
+		}
+	}
+	report, err = db.Fsck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIssues(t, report, want...)
+
+	// deleting the values clears them
+	for _, k := range []string{"chunked", "list"} {
+		if err := db.Delete(k); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report, err = db.Fsck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIssues(t, report)
+}
@@ -0,0 +1,156 @@
+package bboltkv
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// structField is a field of a struct tagged for GetStruct and PutStruct.
+type structField struct {
+	index    int
+	name     string
+	key      string
+	raw      bool
+	optional bool
+}
+
+// GetStruct reads the keys named by the fields of the struct dst points to,
+// in a single transaction, and decodes each into its field, for callers
+// that always load the same few keys together. Fields name their key in a
+// tag, optionally followed by modifiers:
+//
+//	type page struct {
+//	    User     User     `bboltkv:"user:42"`
+//	    Settings Settings `bboltkv:"settings:42,optional"`
+//	    Visits   int      `bboltkv:"visits:42"`
+//	    Avatar   []byte   `bboltkv:"avatar:42,raw"`
+//	}
+//	var p page
+//	err := store.GetStruct(&p)
+//
+// A "raw" field, of type []byte, gets the encoded bytes of its key, as
+// from GetRaw, rather than the decoded value. An "optional" field is left
+// as it is if its key is not present; for any other field, GetStruct
+// returns an error naming the field and its key, which matches ErrNotFound
+// with errors.Is. Untagged and unexported fields, and those tagged "-", are
+// left alone. On error, dst may have been partly filled in.
+func (s *Store) GetStruct(dst interface{}) error {
+	v, fields, err := structFields(dst)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, len(fields))
+	for i, f := range fields {
+		keys[i] = f.key
+	}
+	raws, err := s.getRaws(keys)
+	if err != nil {
+		return err
+	}
+	for i, f := range fields {
+		fv := v.Field(f.index)
+		switch {
+		case raws[i] == nil && f.optional:
+			continue
+		case raws[i] == nil:
+			return fmt.Errorf("%w: field %s, key %q", ErrNotFound, f.name, f.key)
+		case f.raw:
+			fv.SetBytes(raws[i])
+		default:
+			if err := s.decode(raws[i], fv.Addr().Interface()); err != nil {
+				return fmt.Errorf("bboltkv: field %s, key %q: %w", f.name, f.key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// PutStruct writes the fields of the struct src points to under the keys
+// they are tagged with, as for GetStruct, in a single transaction: either
+// every field is written, or none is. Values are encoded and validated as
+// with Put, except for raw fields, which must hold encoded bytes, as with
+// PutEncoded. Optional fields that are nil, such as nil pointers and
+// slices, are not written; any other nil field makes PutStruct return an
+// error matching ErrBadValue, without writing anything.
+func (s *Store) PutStruct(src interface{}) error {
+	v, fields, err := structFields(src)
+	if err != nil {
+		return err
+	}
+	entries := make(RawEntries, len(fields))
+	for _, f := range fields {
+		fv := v.Field(f.index)
+		if isNil(fv) {
+			if f.optional {
+				continue
+			}
+			return fmt.Errorf("%w: field %s, key %q is nil", ErrBadValue, f.name, f.key)
+		}
+		var raw []byte
+		if f.raw {
+			raw = fv.Bytes()
+			err = s.validateEncoded(f.key, raw)
+		} else {
+			raw, err = s.encodeForPut(f.key, fv.Interface())
+		}
+		if err != nil {
+			return fmt.Errorf("bboltkv: field %s, key %q: %w", f.name, f.key, err)
+		}
+		entries[f.key] = raw
+	}
+	return s.putAll(entries)
+}
+
+// isNil reports whether v holds a nil pointer, slice, map or other value
+// that can be nil.
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface, reflect.Chan, reflect.Func:
+		return v.IsNil()
+	}
+	return false
+}
+
+// structFields returns the struct ptr points to, and its tagged fields.
+func structFields(ptr interface{}) (reflect.Value, []structField, error) {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, nil, fmt.Errorf("bboltkv: need a pointer to a struct, not %T", ptr)
+	}
+	v = v.Elem()
+	t := v.Type()
+	var fields []structField
+	seen := map[string]string{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("bboltkv")
+		if !ok || tag == "-" || sf.PkgPath != "" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		f := structField{index: i, name: sf.Name, key: parts[0]}
+		if f.key == "" {
+			return reflect.Value{}, nil, fmt.Errorf("bboltkv: field %s names no key", sf.Name)
+		}
+		for _, mod := range parts[1:] {
+			switch mod {
+			case "raw":
+				f.raw = true
+			case "optional":
+				f.optional = true
+			default:
+				return reflect.Value{}, nil, fmt.Errorf("bboltkv: field %s: unknown modifier %q", sf.Name, mod)
+			}
+		}
+		if f.raw && sf.Type != reflect.TypeOf([]byte(nil)) {
+			return reflect.Value{}, nil, fmt.Errorf("bboltkv: raw field %s is not a []byte", sf.Name)
+		}
+		if other, ok := seen[f.key]; ok {
+			return reflect.Value{}, nil, fmt.Errorf("bboltkv: fields %s and %s name the same key %q", other, sf.Name, f.key)
+		}
+		seen[f.key] = sf.Name
+		fields = append(fields, f)
+	}
+	return v, fields, nil
+}
@@ -0,0 +1,158 @@
+package bboltkv
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type structUser struct {
+	Name  string
+	Admin bool
+}
+
+type structSettings struct {
+	Theme string
+}
+
+type structPage struct {
+	User     structUser      `bboltkv:"user:1"`
+	Settings *structSettings `bboltkv:"settings:1,optional"`
+	Visits   int             `bboltkv:"visits:1"`
+	Avatar   []byte          `bboltkv:"avatar:1,raw"`
+
+	Note    string
+	Skipped string `bboltkv:"-"`
+	hidden  string `bboltkv:"hidden"`
+}
+
+func TestGetStruct(t *testing.T) {
+	db := openTestStore(t)
+	avatar, err := db.Encode([]byte{0xff, 0xd8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := structPage{
+		User:     structUser{"alice", true},
+		Settings: &structSettings{"dark"},
+		Visits:   7,
+		Avatar:   avatar,
+		Note:     "not stored",
+		Skipped:  "not stored",
+		hidden:   "not stored",
+	}
+	if err := db.PutStruct(&in); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.Count(); err != nil || n != 4 {
+		t.Fatalf("got %d entries, %v, expected the 4 tagged fields", n, err)
+	}
+	var b []byte
+	if err := db.Get("avatar:1", &b); err != nil || !bytes.Equal(b, []byte{0xff, 0xd8}) {
+		t.Fatalf("got %x, %v", b, err)
+	}
+
+	out := structPage{Note: "kept", hidden: "kept"}
+	if err := db.GetStruct(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.User != in.User || out.Settings == nil || *out.Settings != *in.Settings || out.Visits != 7 {
+		t.Fatalf("got %+v", out)
+	}
+	if !bytes.Equal(out.Avatar, avatar) || out.Note != "kept" || out.hidden != "kept" {
+		t.Fatalf("got %+v", out)
+	}
+
+	// a missing optional key leaves its field alone
+	if err := db.Delete("settings:1"); err != nil {
+		t.Fatal(err)
+	}
+	out = structPage{Settings: &structSettings{"default"}}
+	if err := db.GetStruct(&out); err != nil || out.Settings.Theme != "default" {
+		t.Fatalf("got %+v, %v", out.Settings, err)
+	}
+	// as does a nil one for PutStruct
+	in.Settings = nil
+	if err := db.PutStruct(&in); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get("settings:1", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+}
+
+func TestGetStructMissing(t *testing.T) {
+	db := openTestStore(t)
+	if err := db.Put("user:1", structUser{Name: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	var out structPage
+	err := db.GetStruct(&out)
+	if !errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), "Visits") || !strings.Contains(err.Error(), `"visits:1"`) {
+		t.Fatalf("got %v", err)
+	}
+
+	// values of the wrong type name their field too
+	if err := db.Put("visits:1", "seven"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("avatar:1", []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	err = db.GetStruct(&out)
+	if err == nil || !strings.Contains(err.Error(), "Visits") {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestGetStructBadTarget(t *testing.T) {
+	db := openTestStore(t)
+	var page structPage
+	for _, dst := range []interface{}{page, nil, new(int), &struct {
+		A string `bboltkv:""`
+	}{}, &struct {
+		A string `bboltkv:"a,zipped"`
+	}{}, &struct {
+		A string `bboltkv:"a,raw"`
+	}{}, &struct {
+		A string `bboltkv:"a"`
+		B string `bboltkv:"a"`
+	}{}} {
+		if err := db.GetStruct(dst); err == nil {
+			t.Errorf("%T: no error", dst)
+		}
+		if err := db.PutStruct(dst); err == nil {
+			t.Errorf("%T: no error", dst)
+		}
+	}
+}
+
+func TestPutStructAtomic(t *testing.T) {
+	errNegative := errors.New("negative")
+	db := openTestStore(t, WithPutValidator(func(key string, value interface{}) error {
+		if n, ok := value.(int); ok && n < 0 {
+			return errNegative
+		}
+		return nil
+	}))
+	avatar, _ := db.Encode([]byte{1})
+	in := structPage{User: structUser{Name: "alice"}, Visits: -1, Avatar: avatar}
+	if err := db.PutStruct(&in); !errors.Is(err, errNegative) || !strings.Contains(err.Error(), "Visits") {
+		t.Fatalf("got %v", err)
+	}
+	// a nil required field fails before writing too
+	in = structPage{User: structUser{Name: "alice"}, Visits: 1}
+	if err := db.PutStruct(&in); !errors.Is(err, ErrBadValue) || !strings.Contains(err.Error(), "Avatar") {
+		t.Fatalf("got %v", err)
+	}
+	if n, err := db.Count(); err != nil || n != 0 {
+		t.Fatalf("got %d entries, %v, expected none", n, err)
+	}
+
+	db.setReadOnly()
+	in.Avatar = avatar
+	if err := db.PutStruct(&in); err != ErrReadOnly {
+		t.Fatalf("got %v, expected ErrReadOnly", err)
+	}
+}
@@ -0,0 +1,279 @@
+package bboltkv
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+
+	"go.etcd.io/bbolt"
+)
+
+// A hibernate file holds hibernateMagic, then the id of the last transaction
+// committed to the database file and the size of that file, both as 8 bytes
+// big-endian, then a snapshot of all buckets in the file.
+const hibernateMagic = "bboltkv hibernate 1\n"
+
+// hibernateBatch is the number of entries loaded per transaction when
+// restoring a hibernate file.
+const hibernateBatch = 10000
+
+type hibernateHeader struct {
+	txid uint64
+	size uint64
+}
+
+func (h hibernateHeader) encode() []byte {
+	b := make([]byte, len(hibernateMagic)+16)
+	copy(b, hibernateMagic)
+	binary.BigEndian.PutUint64(b[len(hibernateMagic):], h.txid)
+	binary.BigEndian.PutUint64(b[len(hibernateMagic)+8:], h.size)
+	return b
+}
+
+func readHibernateHeader(r io.Reader) (hibernateHeader, error) {
+	b := make([]byte, len(hibernateMagic)+16)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return hibernateHeader{}, ErrBadSnapshot
+	} else if string(b[:len(hibernateMagic)]) != hibernateMagic {
+		return hibernateHeader{}, ErrBadSnapshot
+	}
+	return hibernateHeader{
+		txid: binary.BigEndian.Uint64(b[len(hibernateMagic):]),
+		size: binary.BigEndian.Uint64(b[len(hibernateMagic)+8:]),
+	}, nil
+}
+
+// dbHeader returns the header describing the database file as it is now.
+func dbHeader(db *bbolt.DB) (hibernateHeader, error) {
+	fi, err := os.Stat(db.Path())
+	if err != nil {
+		return hibernateHeader{}, err
+	}
+	var h hibernateHeader
+	err = db.View(func(tx *bbolt.Tx) error {
+		h = hibernateHeader{txid: uint64(tx.ID()), size: uint64(fi.Size())}
+		return nil
+	})
+	return h, err
+}
+
+// Hibernate closes the store like Close, and writes a hibernate file to
+// path just before the database file is closed: a snapshot of every bucket
+// in the file, in a form OpenFromHibernate can load quickly. Write the
+// hibernate file next to the database rather than on another filesystem.
+//
+// If writing the hibernate file fails, the store is closed all the same and
+// the error is returned; no partial file is left behind.
+func (s *Store) Hibernate(path string) error {
+	return s.closeGrace(context.Background(), func() error {
+		return s.writeHibernate(path)
+	})
+}
+
+func (s *Store) writeHibernate(path string) error {
+	h, err := dbHeader(s.db)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(h.encode())
+	if err == nil {
+		err = s.db.View(func(tx *bbolt.Tx) error {
+			_, err := writeSnapshot(tx, f, func([]byte) bool { return true })
+			return err
+		})
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// OpenFromHibernate opens a store like Open, but first rebuilds the database
+// file at dbPath from the hibernate file at hibernatePath, as written by
+// Hibernate. The rebuilt file is written sequentially with full pages, so it
+// is compact, and reopening warms the operating system's page cache for it.
+// The hibernate file is removed once it has been loaded.
+//
+// If the hibernate file does not exist, is damaged, or the database file has
+// changed since it was written, OpenFromHibernate ignores it and opens
+// dbPath as it is.
+func OpenFromHibernate(dbPath, hibernatePath, bucketName string, opts ...Option) (*Store, error) {
+	if err := restoreHibernate(dbPath, hibernatePath); err != nil {
+		return nil, err
+	}
+	return Open(dbPath, bucketName, opts...)
+}
+
+// restoreHibernate replaces the database file with the contents of the
+// hibernate file, if that is still current. It only fails if the database
+// file cannot be opened.
+func restoreHibernate(dbPath, hibernatePath string) error {
+	f, err := os.Open(hibernatePath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	want, err := readHibernateHeader(r)
+	if err != nil {
+		return nil
+	}
+	db, err := openDB(dbPath, options{})
+	if err != nil {
+		return err
+	}
+	have, err := dbHeader(db)
+	pageSize := db.Info().PageSize
+	if err := db.Close(); err != nil {
+		return err
+	}
+	if err != nil || have != want {
+		return nil
+	}
+
+	tmp := dbPath + ".tmp"
+	os.Remove(tmp)
+	if err := loadHibernate(tmp, r, pageSize); err != nil {
+		os.Remove(tmp)
+		return nil
+	}
+	if err := os.Rename(tmp, dbPath); err != nil {
+		os.Remove(tmp)
+		return nil
+	}
+	f.Close()
+	os.Remove(hibernatePath)
+	return nil
+}
+
+// loadHibernate creates a database file at path, with pages of pageSize
+// bytes, holding the snapshot read from r.
+func loadHibernate(path string, r io.Reader, pageSize int) error {
+	db, err := bbolt.Open(path, 0640, &bbolt.Options{NoSync: true, PageSize: pageSize})
+	if err != nil {
+		return err
+	}
+	l := &bulkLoader{db: db}
+	err = readSnapshot(r, l)
+	if err == nil {
+		err = l.commit()
+	}
+	if l.tx != nil {
+		l.tx.Rollback()
+	}
+	if err == nil {
+		err = db.Sync()
+	}
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// bulkLoader writes a snapshot to a fresh database, committing every
+// hibernateBatch entries. Entries arrive in key order, so pages are filled
+// completely.
+type bulkLoader struct {
+	db    *bbolt.DB
+	tx    *bbolt.Tx
+	path  [][]byte
+	stack []*bbolt.Bucket
+	n     int
+}
+
+func (l *bulkLoader) begin() error {
+	if l.tx != nil {
+		return nil
+	}
+	tx, err := l.db.Begin(true)
+	if err != nil {
+		return err
+	}
+	l.tx = tx
+	l.stack = l.stack[:0]
+	for i, name := range l.path {
+		var b *bbolt.Bucket
+		if i == 0 {
+			b = tx.Bucket(name)
+		} else {
+			b = l.stack[i-1].Bucket(name)
+		}
+		b.FillPercent = 1.0
+		l.stack = append(l.stack, b)
+	}
+	return nil
+}
+
+func (l *bulkLoader) commit() error {
+	if l.tx == nil {
+		return nil
+	}
+	err := l.tx.Commit()
+	l.tx = nil
+	l.n = 0
+	return err
+}
+
+func (l *bulkLoader) bucket(name []byte) error {
+	if err := l.begin(); err != nil {
+		return err
+	}
+	var b *bbolt.Bucket
+	var err error
+	if len(l.stack) == 0 {
+		b, err = l.tx.CreateBucket(name)
+	} else {
+		b, err = l.stack[len(l.stack)-1].CreateBucket(name)
+	}
+	if err != nil {
+		return err
+	}
+	b.FillPercent = 1.0
+	l.path = append(l.path, name)
+	l.stack = append(l.stack, b)
+	return nil
+}
+
+func (l *bulkLoader) sequence(seq uint64) error {
+	if err := l.begin(); err != nil {
+		return err
+	}
+	return l.stack[len(l.stack)-1].SetSequence(seq)
+}
+
+func (l *bulkLoader) entry(key, value []byte) error {
+	if err := l.begin(); err != nil {
+		return err
+	}
+	if err := l.stack[len(l.stack)-1].Put(key, value); err != nil {
+		return err
+	}
+	if l.n++; l.n >= hibernateBatch {
+		return l.commit()
+	}
+	return nil
+}
+
+func (l *bulkLoader) end() error {
+	l.path = l.path[:len(l.path)-1]
+	if l.tx != nil {
+		l.stack = l.stack[:len(l.stack)-1]
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package bboltkv
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// snapshotOf returns what WriteTo writes for db.
+func snapshotOf(t testing.TB, db *Store) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := db.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// makeHibernateFixture creates a store using lists, tags, TTLs and the
+// outbox, so that all kinds of internal buckets exist.
+func makeHibernateFixture(t *testing.T) (dbPath string, db *Store) {
+	dbPath = filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(dbPath, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillStore(t, db, 2000)
+	if _, err := db.Append("list", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutTagged("tagged", 1, "red", "blue"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithTTL("ttl", 1, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithEvent("event", 1, []byte("put")); err != nil {
+		t.Fatal(err)
+	}
+	return dbPath, db
+}
+
+func TestHibernate(t *testing.T) {
+	dbPath, db := makeHibernateFixture(t)
+	hibPath := dbPath + ".hib"
+	want := snapshotOf(t, db)
+	if err := db.Hibernate(hibPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key", 1); err != ErrClosed {
+		t.Fatalf("got %v, expected ErrClosed", err)
+	}
+
+	db, err := OpenFromHibernate(dbPath, hibPath, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if got := snapshotOf(t, db); !bytes.Equal(got, want) {
+		t.Fatal("contents differ after reopening")
+	}
+	if _, err := os.Stat(hibPath); !os.IsNotExist(err) {
+		t.Fatalf("hibernate file was not removed: %v", err)
+	}
+	if _, err := os.Stat(dbPath + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temporary file was left behind: %v", err)
+	}
+	// the store carries on where it left off
+	if keys, err := db.KeysByTag("red"); err != nil || len(keys) != 1 {
+		t.Fatalf("got %q, %v", keys, err)
+	}
+	if n, err := db.Append("list", "b"); err != nil || n != 2 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+}
+
+func TestHibernateStale(t *testing.T) {
+	dbPath, db := makeHibernateFixture(t)
+	hibPath := dbPath + ".hib"
+	if err := db.Hibernate(hibPath); err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(dbPath, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("later", 1); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = OpenFromHibernate(dbPath, hibPath, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Get("later", nil); err != nil {
+		t.Fatalf("write after hibernating was lost: %v", err)
+	}
+	if _, err := os.Stat(hibPath); err != nil {
+		t.Fatalf("stale hibernate file was removed: %v", err)
+	}
+}
+
+func TestHibernateDamaged(t *testing.T) {
+	dbPath, db := makeHibernateFixture(t)
+	hibPath := dbPath + ".hib"
+	want := snapshotOf(t, db)
+	if err := db.Hibernate(hibPath); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(hibPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[len(raw)/2] ^= 1
+	if err := os.WriteFile(hibPath, raw, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = OpenFromHibernate(dbPath, hibPath, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if got := snapshotOf(t, db); !bytes.Equal(got, want) {
+		t.Fatal("contents differ after reopening")
+	}
+	if _, err := os.Stat(dbPath + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temporary file was left behind: %v", err)
+	}
+}
+
+func TestHibernateMissing(t *testing.T) {
+	dbPath, db := makeHibernateFixture(t)
+	db.Close()
+	db, err := OpenFromHibernate(dbPath, dbPath+".hib", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if n, err := db.Count(); err != nil || n != 2004 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+}
+
+// BenchmarkReopen measures opening a large store and reading every value,
+// directly and from a hibernate file.
+func BenchmarkReopen(b *testing.B) {
+	dbPath := filepath.Join(b.TempDir(), "bench.db")
+	db, err := Open(dbPath, "bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	value := strings.Repeat("v", 200)
+	for i := 0; i < 10; i++ {
+		entries := make(map[string]interface{})
+		for j := 0; j < 10000; j++ {
+			entries[keyN(i*10000+j)] = value
+		}
+		if err := db.PutAll(entries); err != nil {
+			b.Fatal(err)
+		}
+	}
+	db.Close()
+
+	readAll := func(db *Store) {
+		err := db.db.View(func(tx *bbolt.Tx) error {
+			return tx.Bucket(db.bucketName).ForEach(func(k, v []byte) error { return nil })
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.Run("Open", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			db, err := Open(dbPath, "bench")
+			if err != nil {
+				b.Fatal(err)
+			}
+			readAll(db)
+			db.Close()
+		}
+	})
+	b.Run("OpenFromHibernate", func(b *testing.B) {
+		hibPath := dbPath + ".hib"
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			db, err := Open(dbPath, "bench")
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := db.Hibernate(hibPath); err != nil {
+				b.Fatal(err)
+			}
+			b.StartTimer()
+			db, err = OpenFromHibernate(dbPath, hibPath, "bench")
+			if err != nil {
+				b.Fatal(err)
+			}
+			readAll(db)
+			db.Close()
+		}
+	})
+}
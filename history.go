@@ -0,0 +1,176 @@
+package bboltkv
+
+import (
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// historyBucket holds a bucket per key with a history, see WithHistory,
+// mapping versions, as 8 bytes big-endian, to the time the version was
+// replaced, in the same form, followed by its encoded value.
+const historyBucket = "history"
+
+// WithHistory makes the store keep the last keep values of the keys
+// starting with prefix: each Put of such a key, or other write replacing
+// its value, archives the value it replaces in the same transaction, with a
+// version number and the time, and drops the oldest versions beyond keep.
+// Version numbers count up from 1 for each key, and are never reused. See
+// History and Rollback. The option can be given several times, for several
+// prefixes; a key takes the keep of the longest prefix it starts with.
+//
+// Deleting a key leaves its history in place, to roll back to, and so does
+// Truncate; with WithDeleteHistory, deletions archive the deleted value as
+// well. History is not kept for lists, nor with WithEncryptedKeys.
+//
+//	store, err := bboltkv.Open(path, "bucket", bboltkv.WithHistory("config:", 10))
+func WithHistory(prefix string, keep int) Option {
+	return func(o *options) {
+		o.history = append(o.history, historyRule{prefix, keep})
+	}
+}
+
+// WithDeleteHistory makes deleting a key with a history, see WithHistory,
+// archive the deleted value like a Put replacing it does.
+func WithDeleteHistory() Option {
+	return func(o *options) {
+		o.deleteHistory = true
+	}
+}
+
+type historyRule struct {
+	prefix string
+	keep   int
+}
+
+// historyKeep returns the number of versions of key to keep, or 0 if it has
+// no history.
+func (s *Store) historyKeep(key string) int {
+	keep, longest := 0, -1
+	for _, r := range s.opts.history {
+		if len(r.prefix) > longest && strings.HasPrefix(key, r.prefix) {
+			keep, longest = r.keep, len(r.prefix)
+		}
+	}
+	return keep
+}
+
+// archive adds the value key holds to its history, if it has one, before
+// the value is replaced or deleted.
+func (w *wtx) archive(key string) error {
+	keep := w.s.historyKeep(key)
+	if keep <= 0 {
+		return nil
+	}
+	old := w.get(key)
+	if old == nil || old[0] == tagList {
+		return nil
+	}
+	hb, err := w.aux(historyBucket)
+	if err != nil {
+		return err
+	}
+	b, err := hb.CreateBucketIfNotExists([]byte(key))
+	if err != nil {
+		return err
+	}
+	version, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	v := make([]byte, 8, 8+len(old))
+	binary.BigEndian.PutUint64(v, uint64(w.s.now().UnixNano()))
+	if err := b.Put(changeKey(version), append(v, old...)); err != nil {
+		return err
+	}
+	var drop [][]byte
+	n := 0
+	c := b.Cursor()
+	for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+		if n++; n > keep {
+			drop = append(drop, k)
+		}
+	}
+	for _, k := range drop {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// History calls fn for each archived version of key, see WithHistory, from
+// the oldest to the newest, with its version number, the time it was
+// replaced, and a function decoding its value as Get does. It runs in a
+// single read transaction; if fn returns an error, History stops and
+// returns that error. For keys without a history, fn is not called. The
+// current value of key is not part of its history.
+//
+//	err := store.History("config:limits", func(version uint64, at time.Time, decode func(interface{}) error) error {
+//	    var l Limits
+//	    if err := decode(&l); err != nil {
+//	        return err
+//	    }
+//	    fmt.Println(version, at, l)
+//	    return nil
+//	})
+func (s *Store) History(key string, fn func(version uint64, at time.Time, decode func(interface{}) error) error) error {
+	if err := s.plainKeys(); err != nil {
+		return err
+	}
+	return s.view(func(tx *bbolt.Tx) error {
+		b := s.historyOf(tx, key)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			if len(k) != 8 || len(v) < 8 {
+				return ErrCorrupt
+			}
+			at := time.Unix(0, int64(binary.BigEndian.Uint64(v)))
+			raw := v[8:]
+			return fn(binary.BigEndian.Uint64(k), at, func(value interface{}) error {
+				return s.decode(raw, value)
+			})
+		})
+	})
+}
+
+// Rollback makes the archived version of key, see History, its current
+// value again, bytes for bytes, as a write replacing the value it holds,
+// which is archived in turn. If key has no such version, it returns
+// ErrNotFound.
+func (s *Store) Rollback(key string, version uint64) error {
+	if err := s.plainKeys(); err != nil {
+		return err
+	}
+	if err := s.checkKey(key); err != nil {
+		return err
+	}
+	return s.update(func(w *wtx) error {
+		b := s.historyOf(w.tx, key)
+		if b == nil {
+			return ErrNotFound
+		}
+		v := b.Get(changeKey(version))
+		if v == nil {
+			return ErrNotFound
+		} else if len(v) < 8 {
+			return ErrCorrupt
+		}
+		raw := append([]byte(nil), v[8:]...)
+		return w.put(key, raw)
+	})
+}
+
+// historyOf returns the bucket holding the history of key, or nil if it
+// has none.
+func (s *Store) historyOf(tx *bbolt.Tx, key string) *bbolt.Bucket {
+	hb := s.aux(tx, historyBucket)
+	if hb == nil {
+		return nil
+	}
+	return hb.Bucket([]byte(key))
+}
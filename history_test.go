@@ -0,0 +1,155 @@
+package bboltkv
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+type archived struct {
+	n     uint64
+	at    time.Time
+	value string
+}
+
+func readHistory(t *testing.T, db *Store, key string) []archived {
+	t.Helper()
+	var got []archived
+	err := db.History(key, func(n uint64, at time.Time, decode func(interface{}) error) error {
+		v := archived{n: n, at: at}
+		if err := decode(&v.value); err != nil {
+			return err
+		}
+		got = append(got, v)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestHistory(t *testing.T) {
+	db := openTestStore(t, WithHistory("config:", 3))
+	start := time.Now()
+	for _, v := range []string{"v1", "v2", "v3", "v4", "v5"} {
+		if err := db.Put("config:a", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := readHistory(t, db, "config:a")
+	// the current value v5 is not part of it, and v1 was trimmed
+	if len(got) != 3 {
+		t.Fatalf("got %+v", got)
+	}
+	for i, want := range []archived{{2, start, "v2"}, {3, start, "v3"}, {4, start, "v4"}} {
+		if got[i].n != want.n || got[i].value != want.value || got[i].at.Before(start.Add(-time.Second)) {
+			t.Fatalf("version %d: got %+v, expected %+v", i, got[i], want)
+		}
+	}
+	// keys outside the prefix have none
+	if err := db.Put("other", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("other", "y"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readHistory(t, db, "other"); len(got) != 0 {
+		t.Fatalf("got %+v for a key without history", got)
+	}
+	if got := readHistory(t, db, "config:missing"); len(got) != 0 {
+		t.Fatalf("got %+v for a key never written", got)
+	}
+}
+
+func TestHistoryLongestPrefix(t *testing.T) {
+	db := openTestStore(t, WithHistory("config:", 1), WithHistory("config:db:", 5))
+	for _, key := range []string{"config:db:url", "config:port"} {
+		for _, v := range []string{"a", "b", "c", "d"} {
+			if err := db.Put(key, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if got := readHistory(t, db, "config:db:url"); len(got) != 3 {
+		t.Fatalf("got %+v", got)
+	}
+	if got := readHistory(t, db, "config:port"); len(got) != 1 || got[0].value != "c" {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	db := openTestStore(t, WithHistory("config:", 10), WithCompression())
+	big := string(bytes.Repeat([]byte("limits "), 100))
+	if err := db.Put("config:a", big); err != nil {
+		t.Fatal(err)
+	}
+	was, err := db.GetRaw("config:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("config:a", "small"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Rollback("config:a", 1); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := db.GetRaw("config:a")
+	if err != nil || !bytes.Equal(raw, was) {
+		t.Fatalf("got %x, %v, expected %x", raw, err, was)
+	}
+	// the rolled back value is itself archived
+	got := readHistory(t, db, "config:a")
+	if len(got) != 2 || got[1].n != 2 || got[1].value != "small" {
+		t.Fatalf("got %+v", got)
+	}
+	if err := db.Rollback("config:a", 9); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if err := db.Rollback("config:none", 1); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+}
+
+func TestHistoryDelete(t *testing.T) {
+	for _, archive := range []bool{false, true} {
+		opts := []Option{WithHistory("config:", 10)}
+		if archive {
+			opts = append(opts, WithDeleteHistory())
+		}
+		db := openTestStore(t, opts...)
+		for _, v := range []string{"v1", "v2"} {
+			if err := db.Put("config:a", v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.Put("config:b", "b1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Delete("config:a"); err != nil {
+			t.Fatal(err)
+		}
+		// Truncate keeps the history, and rolling back restores the key
+		if _, err := db.Truncate(); err != nil {
+			t.Fatal(err)
+		}
+		got := readHistory(t, db, "config:a")
+		if want := map[bool]int{false: 1, true: 2}[archive]; len(got) != want {
+			t.Fatalf("archive %v: got %+v", archive, got)
+		}
+		if err := db.Rollback("config:a", 1); err != nil {
+			t.Fatal(err)
+		}
+		var v string
+		if err := db.Get("config:a", &v); err != nil || v != "v1" {
+			t.Fatalf("got %q, %v after rolling back", v, err)
+		}
+		err := db.Rollback("config:b", 1)
+		if archive && err != nil {
+			t.Fatal(err)
+		} else if !archive && err != ErrNotFound {
+			t.Fatalf("got %v, expected ErrNotFound", err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package bboltkv
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"go.etcd.io/bbolt"
+)
+
+// immutableBucket holds the keys written with PutImmutable, with empty
+// values.
+const immutableBucket = "immutable"
+
+// ErrImmutable is returned when overwriting, deleting or giving a TTL to a
+// key written with PutImmutable.
+var ErrImmutable = errors.New("bboltkv: key is immutable")
+
+// errNoForceDelete is returned by ForceDelete on stores opened without
+// WithAllowForceDelete.
+var errNoForceDelete = errors.New("bboltkv: ForceDelete needs WithAllowForceDelete")
+
+// WithAllowForceDelete enables ForceDelete, which can delete keys written
+// with PutImmutable. Without it, nothing can remove such a key.
+func WithAllowForceDelete() Option {
+	return func(o *options) {
+		o.forceDelete = true
+	}
+}
+
+// PutImmutable puts an entry into the store like Put, and makes the key
+// immutable: every later attempt to overwrite or delete it fails with
+// ErrImmutable, whether through Put, Delete, PutAll, DeletePrefix,
+// DeleteWhere, Truncate, Merge, an import or any other write, and so does
+// giving it a TTL, so it can never expire. A write that touches several
+// keys fails whole, without writing anything. Reading the key is not
+// affected. If the key is already immutable, PutImmutable returns
+// ErrImmutable too. Other stores sharing the bucket, see OpenShared, are
+// held to it as well.
+//
+// Immutability is recorded in the database file, so it survives reopening
+// the store. Only ForceDelete can undo it, see WithAllowForceDelete.
+//
+//	err := store.PutImmutable("audit:2024-06-01T12:00:00Z", entry)
+func (s *Store) PutImmutable(key string, value interface{}) error {
+	raw, err := s.encodeForPut(key, value)
+	if err != nil {
+		return err
+	}
+	key = s.sealKey(key)
+	return s.update(func(w *wtx) error {
+		if err := w.put(key, raw); err != nil {
+			return err
+		}
+		b, err := w.aux(immutableBucket)
+		if err != nil {
+			return err
+		}
+		// from here on, writes look keys up; if the transaction fails,
+		// they just do so needlessly
+		atomic.StoreInt32(&s.state.immutables, 1)
+		return b.Put([]byte(key), []byte{})
+	})
+}
+
+// IsImmutable reports whether key was written with PutImmutable.
+func (s *Store) IsImmutable(key string) (bool, error) {
+	var immutable bool
+	key = s.sealKey(key)
+	err := s.view(func(tx *bbolt.Tx) error {
+		immutable = s.immutable(tx, key)
+		return nil
+	})
+	return immutable, err
+}
+
+// ForceDelete deletes the entry with the given key, even if it was written
+// with PutImmutable, and lifts its immutability. It is meant for
+// administrative repairs, and fails unless the store was opened with
+// WithAllowForceDelete. Protected keys are still refused with ErrProtected,
+// see Protect. If no such key is present in the store, it returns
+// ErrNotFound.
+func (s *Store) ForceDelete(key string) error {
+	if !s.opts.forceDelete {
+		return errNoForceDelete
+	}
+	key = s.sealKey(key)
+	return s.update(func(w *wtx) error {
+		if w.get(key) == nil {
+			return ErrNotFound
+		} else if s.protected(key) {
+			return ErrProtected
+		}
+		w.force = true
+		defer func() { w.force = false }()
+		if err := w.delete(key); err != nil {
+			return err
+		}
+		if b := s.aux(w.tx, immutableBucket); b != nil {
+			return b.Delete([]byte(key))
+		}
+		return nil
+	})
+}
+
+// immutable reports whether key was written with PutImmutable.
+func (s *Store) immutable(tx *bbolt.Tx, key string) bool {
+	if atomic.LoadInt32(&s.state.immutables) == 0 {
+		return false
+	}
+	b := s.aux(tx, immutableBucket)
+	return b != nil && b.Get([]byte(key)) != nil
+}
+
+// loadImmutables notes whether the file holds immutable keys, so that
+// writes need not look them up otherwise.
+func (s *Store) loadImmutables(tx *bbolt.Tx) {
+	if b := s.aux(tx, immutableBucket); b != nil {
+		if k, _ := b.Cursor().First(); k != nil {
+			atomic.StoreInt32(&s.state.immutables, 1)
+		}
+	}
+}
+
+// checkMutable fails with ErrImmutable if key may not be written, unless
+// the transaction is a ForceDelete.
+func (w *wtx) checkMutable(key string) error {
+	if !w.force && w.s.immutable(w.tx, key) {
+		return ErrImmutable
+	}
+	return nil
+}
+
+// checkMutable fails with ErrImmutable if key may not be written. Writes to
+// the write buffer check it up front, as they would otherwise only fail
+// once flushed, together with all others in the flush.
+func (s *Store) checkMutable(key string) error {
+	if atomic.LoadInt32(&s.state.immutables) == 0 {
+		return nil
+	}
+	return s.view(func(tx *bbolt.Tx) error {
+		if s.immutable(tx, key) {
+			return ErrImmutable
+		}
+		return nil
+	})
+}
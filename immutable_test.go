@@ -0,0 +1,226 @@
+package bboltkv
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/unknownnf/bboltkv/testutil"
+)
+
+func TestPutImmutable(t *testing.T) {
+	db := openTestStore(t)
+	fillStore(t, db, 5)
+	if err := db.PutImmutable("audit:1", "first"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.IsImmutable("audit:1"); err != nil || !ok {
+		t.Fatalf("IsImmutable: %v, %v", ok, err)
+	}
+	if ok, err := db.IsImmutable(keyN(0)); err != nil || ok {
+		t.Fatalf("IsImmutable(%s): %v, %v", keyN(0), ok, err)
+	}
+
+	const key = "audit:1"
+	rejected := map[string]func() error{
+		"Put":          func() error { return db.Put(key, "second") },
+		"PutImmutable": func() error { return db.PutImmutable(key, "second") },
+		"PutEncoded": func() error {
+			raw, err := db.Encode("second")
+			if err != nil {
+				return err
+			}
+			return db.PutEncoded(key, raw)
+		},
+		"PutAll":     func() error { return db.PutAll(map[string]interface{}{keyN(9): 9, key: "second"}) },
+		"PutWithTTL": func() error { return db.PutWithTTL(key, "second", time.Hour) },
+		"Expire":     func() error { return db.Expire(key, time.Hour) },
+		"Delete":     func() error { return db.Delete(key) },
+		"DeleteGet":  func() error { return db.DeleteGet(key, nil) },
+		"DeleteGetRaw": func() error {
+			_, err := db.DeleteGetRaw(key)
+			return err
+		},
+		"DeletePrefix": func() error {
+			_, err := db.DeletePrefix("audit:")
+			return err
+		},
+		"DeleteWhere": func() error {
+			_, err := db.DeleteWhere(func(string, []byte) bool { return true })
+			return err
+		},
+		"Truncate": func() error {
+			_, err := db.Truncate()
+			return err
+		},
+		"Merge": func() error {
+			src := openTestStore(t)
+			if err := src.Put(key, "second"); err != nil {
+				return err
+			}
+			_, err := db.Merge(src)
+			return err
+		},
+		"ImportJSON": func() error {
+			src := openTestStore(t)
+			if err := src.Put(key, "second"); err != nil {
+				return err
+			}
+			var buf bytes.Buffer
+			if err := src.ExportJSON(&buf); err != nil {
+				return err
+			}
+			_, err := db.ImportJSON(&buf)
+			return err
+		},
+		"ForceDelete": func() error { return db.ForceDelete(key) },
+	}
+	for name, fn := range rejected {
+		err := fn()
+		if name == "ForceDelete" {
+			if err == nil {
+				t.Fatal("ForceDelete succeeded without WithAllowForceDelete")
+			}
+		} else if err != ErrImmutable {
+			t.Errorf("%s: got %v, expected ErrImmutable", name, err)
+		}
+		var v string
+		if err := db.Get(key, &v); err != nil || v != "first" {
+			t.Fatalf("after %s: got %q, %v", name, v, err)
+		}
+	}
+	// failed writes to several keys wrote none of them
+	if n, err := db.Count(); err != nil || n != 6 {
+		t.Fatalf("got %d entries, %v, expected 6", n, err)
+	}
+	if ok, err := db.Has(keyN(9)); err != nil || ok {
+		t.Fatalf("PutAll wrote %s: %v, %v", keyN(9), ok, err)
+	}
+	// other keys are not affected
+	if err := db.Delete(keyN(0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(keyN(1), "changed"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPutImmutableReplacesTTL(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock))
+	if err := db.PutWithTTL("a", 1, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutImmutable("a", 2); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	var v int
+	if err := db.Get("a", &v); err != nil || v != 2 {
+		t.Fatalf("got %d, %v", v, err)
+	}
+}
+
+func TestPutImmutableWriteBuffer(t *testing.T) {
+	db := openTestStore(t, WithWriteBuffer(100, 0))
+	if err := db.PutImmutable("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("a", 2); err != ErrImmutable {
+		t.Fatalf("got %v, expected ErrImmutable", err)
+	}
+	// the rejected write does not fail the others in the buffer
+	if err := db.Put("b", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	if err := db.Get("a", &v); err != nil || v != 1 {
+		t.Fatalf("got %d, %v", v, err)
+	}
+}
+
+func TestForceDelete(t *testing.T) {
+	db := openTestStore(t, WithAllowForceDelete())
+	if err := db.PutImmutable("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Protect("p"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ForceDelete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.Has("a"); err != nil || ok {
+		t.Fatalf("Has: %v, %v", ok, err)
+	}
+	if err := db.ForceDelete("a"); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	// the key can be written again, and is no longer immutable
+	if err := db.Put("a", 2); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.IsImmutable("a"); err != nil || ok {
+		t.Fatalf("IsImmutable: %v, %v", ok, err)
+	}
+	if err := db.Put("p", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ForceDelete("p"); err != ErrProtected {
+		t.Fatalf("got %v, expected ErrProtected", err)
+	}
+}
+
+func TestImmutableReopen(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(name, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutImmutable("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = Open(name, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("a", 2); err != ErrImmutable {
+		t.Fatalf("Put after reopening: got %v, expected ErrImmutable", err)
+	}
+	if err := db.Delete("a"); err != ErrImmutable {
+		t.Fatalf("Delete after reopening: got %v, expected ErrImmutable", err)
+	}
+	db.Close()
+
+	db, err = Open(name, "test", WithAllowForceDelete())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.ForceDelete("a"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImmutableShared(t *testing.T) {
+	a, b := openSharedBucket(t, nil, nil)
+	if err := b.PutImmutable("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Put("a", 2); err != ErrImmutable {
+		t.Fatalf("Put through the other store: got %v, expected ErrImmutable", err)
+	}
+	if err := a.Delete("a"); err != ErrImmutable {
+		t.Fatalf("Delete through the other store: got %v, expected ErrImmutable", err)
+	}
+	var v int
+	if err := b.Get("a", &v); err != nil || v != 1 {
+		t.Fatalf("got %d, %v, expected 1", v, err)
+	}
+}
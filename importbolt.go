@@ -0,0 +1,87 @@
+package bboltkv
+
+import (
+	"go.etcd.io/bbolt"
+)
+
+// BoltMapping maps an entry of a foreign bbolt database to an entry of the
+// store, see ImportBolt. bucket is the path of the bucket holding the
+// entry, the names of the nested buckets leading to it joined with "/". The
+// slices passed in are only valid until the mapping returns.
+type BoltMapping func(bucket string, key, value []byte) (newKey string, newValue []byte, skip bool)
+
+// ImportBolt copies the entries of a bbolt database written without this
+// package into the store. The file at path is opened read-only, and every
+// key of every bucket in it, including nested buckets, is passed to
+// mapping, which returns the key and the encoded value to store, or skip to
+// leave the entry out. The value is stored as it is, as with PutEncoded, so
+// the mapping must either return a value in the store's format, say from
+// Encode, or one that is already in it. Existing entries with the same keys
+// are replaced, and so are entries imported earlier in the same call.
+//
+// Entries are written in batches, each in its own transaction, so an import
+// that fails part way, for instance because the mapping returns a value
+// PutEncoded would refuse, leaves the batches before the failure in place.
+// It returns the number of entries imported. Progress is reported with
+// WithProgress, with keys as mapped.
+//
+//	n, err := store.ImportBolt("legacy.db", func(bucket string, key, value []byte) (string, []byte, bool) {
+//	    raw, err := store.Encode(append([]byte(nil), value...))
+//	    return bucket + ":" + string(key), raw, err != nil
+//	})
+func (s *Store) ImportBolt(path string, mapping BoltMapping, opts ...OpOption) (int, error) {
+	src, err := openDB(path, options{readOnly: true, mustExist: true})
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	p := s.newProgress(buildOpOptions(opts), -1)
+	written := 0
+	var batch []exportEntry
+	flush := func() error {
+		n, err := s.writeBatch(batch, p, opOptions{})
+		written += n
+		batch = batch[:0]
+		return err
+	}
+	var walk func(name string, b *bbolt.Bucket) error
+	walk = func(name string, b *bbolt.Bucket) error {
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if v == nil {
+				if nested := b.Bucket(k); nested != nil {
+					if err := walk(name+"/"+string(k), nested); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			key, raw, skip := mapping(name, k, v)
+			if skip {
+				continue
+			}
+			if err := s.validateEncoded(key, raw); err != nil {
+				return err
+			}
+			batch = append(batch, exportEntry{Key: key, Value: append([]byte(nil), raw...)})
+			if len(batch) >= p.batch.size {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	err = src.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			return walk(string(name), b)
+		})
+	})
+	if err == nil && len(batch) > 0 {
+		err = flush()
+	}
+	if err != nil {
+		return written, err
+	}
+	return written, p.done()
+}
@@ -0,0 +1,175 @@
+package bboltkv
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// writeForeign creates a bbolt database at path with fn, as code not using
+// this package would.
+func writeForeign(t *testing.T, path string, fn func(tx *bbolt.Tx) error) {
+	t.Helper()
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Update(fn); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportBolt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	writeForeign(t, path, func(tx *bbolt.Tx) error {
+		users, err := tx.CreateBucket([]byte("users"))
+		if err != nil {
+			return err
+		}
+		users.Put([]byte("alice"), []byte("A"))
+		users.Put([]byte("bob"), []byte("B"))
+		admins, err := users.CreateBucket([]byte("admins"))
+		if err != nil {
+			return err
+		}
+		admins.Put([]byte("root"), []byte("R"))
+		logs, err := tx.CreateBucket([]byte("logs"))
+		if err != nil {
+			return err
+		}
+		logs.Put([]byte("1"), []byte("first"))
+		logs.Put([]byte("2"), []byte("second"))
+		return nil
+	})
+
+	db := openTestStore(t)
+	if err := db.Put("users:alice", []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("other", 1); err != nil {
+		t.Fatal(err)
+	}
+	var seen []string
+	n, err := db.ImportBolt(path, func(bucket string, key, value []byte) (string, []byte, bool) {
+		seen = append(seen, bucket+" "+string(key))
+		if bucket == "logs" && string(key) == "2" {
+			return "", nil, true
+		}
+		raw, err := db.Encode(append([]byte(nil), value...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bucket + ":" + string(key), raw, false
+	})
+	if err != nil || n != 4 {
+		t.Fatalf("imported %d, %v, expected 4", n, err)
+	}
+	if fmt.Sprint(seen) != "[logs 1 logs 2 users/admins root users alice users bob]" {
+		t.Fatalf("mapping saw %v", seen)
+	}
+	for key, want := range map[string]string{
+		"users:alice":       "A", // replaced
+		"users:bob":         "B",
+		"users/admins:root": "R",
+		"logs:1":            "first",
+	} {
+		var v []byte
+		if err := db.Get(key, &v); err != nil || string(v) != want {
+			t.Fatalf("Get(%s): got %q, %v, expected %q", key, v, err, want)
+		}
+	}
+	if ok, err := db.Has("logs:2"); err != nil || ok {
+		t.Fatalf("skipped entry was imported: %v, %v", ok, err)
+	}
+	if ok, err := db.Has("other"); err != nil || !ok {
+		t.Fatalf("existing entry is gone: %v, %v", ok, err)
+	}
+}
+
+func TestImportBoltCollision(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	writeForeign(t, path, func(tx *bbolt.Tx) error {
+		for _, name := range []string{"a", "b"} {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			b.Put([]byte("key"), []byte(name))
+		}
+		return nil
+	})
+	db := openTestStore(t)
+	// dropping the bucket maps both entries to the same key
+	n, err := db.ImportBolt(path, func(bucket string, key, value []byte) (string, []byte, bool) {
+		raw, _ := db.Encode(string(value))
+		return string(key), raw, false
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("imported %d, %v, expected 2", n, err)
+	}
+	var v string
+	if err := db.Get("key", &v); err != nil || v != "b" {
+		t.Fatalf("got %q, %v, expected the later entry", v, err)
+	}
+}
+
+func TestImportBoltBatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	const entries = 2*importBatch + 500
+	writeForeign(t, path, func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("data"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < entries; i++ {
+			if err := b.Put([]byte(keyN(i)), []byte{byte(i)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	db := openTestStore(t)
+	mapping := func(bucket string, key, value []byte) (string, []byte, bool) {
+		raw, _ := db.Encode(append([]byte(nil), value...))
+		return string(key), raw, false
+	}
+	var reported int
+	n, err := db.ImportBolt(path, mapping, WithProgress(func(processed, total int, key string) {
+		reported = processed
+	}, importBatch))
+	if err != nil || n != entries || reported != entries {
+		t.Fatalf("imported %d, %v, reported %d, expected %d", n, err, reported, entries)
+	}
+	if count, err := db.Count(); err != nil || count != entries {
+		t.Fatalf("got %d entries, %v", count, err)
+	}
+
+	// a bad value fails its batch, keeping those before it
+	db = openTestStore(t)
+	n, err = db.ImportBolt(path, func(bucket string, key, value []byte) (string, []byte, bool) {
+		if string(key) == keyN(2*importBatch+10) {
+			return string(key), nil, false
+		}
+		return mapping(bucket, key, value)
+	})
+	if !errors.Is(err, ErrBadValue) || n != 2*importBatch {
+		t.Fatalf("imported %d, %v, expected %d and ErrBadValue", n, err, 2*importBatch)
+	}
+	if count, err := db.Count(); err != nil || count != 2*importBatch {
+		t.Fatalf("got %d entries, %v", count, err)
+	}
+}
+
+func TestImportBoltMissing(t *testing.T) {
+	db := openTestStore(t)
+	_, err := db.ImportBolt(filepath.Join(t.TempDir(), "missing.db"), func(string, []byte, []byte) (string, []byte, bool) {
+		return "", nil, true
+	})
+	if !errors.Is(err, ErrNoDatabase) {
+		t.Fatalf("got %v, expected ErrNoDatabase", err)
+	}
+}
@@ -0,0 +1,126 @@
+package bboltkv
+
+import (
+	"encoding/gob"
+	"io"
+	"sort"
+)
+
+// WithSkipExisting makes ImportGobMap, ImportGobAnyMap, ImportJSON and
+// Merge leave entries already in the store alone, rather than replace them
+// with entries of the same keys. Skipped entries are not counted.
+func WithSkipExisting() OpOption {
+	return func(o *opOptions) {
+		o.skipExisting = true
+	}
+}
+
+// ImportGobMap reads gob-encoded values of type map[string][]byte from r,
+// as written by tools using encoding/gob directly, and puts their entries
+// into the store, replacing entries with the same keys unless
+// WithSkipExisting is given. If valueIsEncoded is set, the bytes are values
+// in the store's format, say from Encode or GetRaw, and are stored as they
+// are, as with PutEncoded; otherwise each []byte is itself the value, and
+// is encoded like Put would.
+//
+// r may hold several maps, one after the other, as written by repeated
+// calls to Encode of a single gob.Encoder. Each map is decoded in full
+// before its entries are written, so memory use is bounded by the largest
+// map rather than by the whole input; writers of large datasets should
+// split them into several maps. Entries are written in key order, in
+// batches, each in its own transaction, so an import that fails part way,
+// on input that is not gob, a damaged map, or a value the store refuses,
+// leaves the batches before the failure in place. It returns the number of
+// entries imported up to then, along with the error. Progress is reported
+// with WithProgress.
+//
+//	f, err := os.Open("legacy.gob")
+//	...
+//	n, err := store.ImportGobMap(f, false, bboltkv.WithSkipExisting())
+func (s *Store) ImportGobMap(r io.Reader, valueIsEncoded bool, opts ...OpOption) (int, error) {
+	return s.importGob(r, opts, func(dec *gob.Decoder) ([]exportEntry, error) {
+		var m map[string][]byte
+		if err := dec.Decode(&m); err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		batch := make([]exportEntry, 0, len(keys))
+		for _, k := range keys {
+			raw := m[k]
+			if !valueIsEncoded {
+				var err error
+				if raw, err = s.encodeForPut(k, raw); err != nil {
+					return batch, err
+				}
+			} else if err := s.validateEncoded(k, raw); err != nil {
+				return batch, err
+			}
+			batch = append(batch, exportEntry{Key: k, Value: raw})
+			delete(m, k)
+		}
+		return batch, nil
+	})
+}
+
+// ImportGobAnyMap is ImportGobMap for gob-encoded values of type
+// map[string]interface{}, whose values are encoded like Put would. As with
+// any gob-encoded interface values, their concrete types must have been
+// registered with gob.Register, both by the tool writing them and before
+// calling ImportGobAnyMap.
+func (s *Store) ImportGobAnyMap(r io.Reader, opts ...OpOption) (int, error) {
+	return s.importGob(r, opts, func(dec *gob.Decoder) ([]exportEntry, error) {
+		var m map[string]interface{}
+		if err := dec.Decode(&m); err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		batch := make([]exportEntry, 0, len(keys))
+		for _, k := range keys {
+			raw, err := s.encodeForPut(k, m[k])
+			if err != nil {
+				return batch, err
+			}
+			batch = append(batch, exportEntry{Key: k, Value: raw})
+			delete(m, k)
+		}
+		return batch, nil
+	})
+}
+
+// importGob imports the entries of the maps next decodes from r, until the
+// end of r. On error, next returns the entries it got to before it.
+func (s *Store) importGob(r io.Reader, opts []OpOption, next func(dec *gob.Decoder) ([]exportEntry, error)) (int, error) {
+	o := buildOpOptions(opts)
+	p := s.newProgress(o, -1)
+	dec := gob.NewDecoder(r)
+	written := 0
+	for {
+		entries, err := next(dec)
+		if err == io.EOF {
+			return written, p.done()
+		}
+		for len(entries) > 0 {
+			n := len(entries)
+			if n > p.batch.size {
+				n = p.batch.size
+			}
+			w, werr := s.writeBatch(entries[:n], p, o)
+			written += w
+			if werr != nil {
+				return written, werr
+			}
+			entries = entries[n:]
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package bboltkv
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// gobMaps encodes maps one after the other, as a tool using encoding/gob
+// would.
+func gobMaps(t *testing.T, maps ...interface{}) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	for _, m := range maps {
+		if err := enc.Encode(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &buf
+}
+
+func TestImportGobMap(t *testing.T) {
+	db := openTestStore(t)
+	encoded, err := db.Encode("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := gobMaps(t, map[string][]byte{"user:1": encoded}, map[string][]byte{"user:2": encoded})
+	if n, err := db.ImportGobMap(in, true); err != nil || n != 2 {
+		t.Fatalf("imported %d, %v", n, err)
+	}
+	for _, k := range []string{"user:1", "user:2"} {
+		var v string
+		if err := db.Get(k, &v); err != nil || v != "alice" {
+			t.Fatalf("%s: got %q, %v", k, v, err)
+		}
+	}
+
+	// raw bytes are values themselves
+	in = gobMaps(t, map[string][]byte{"blob:1": []byte("raw"), "blob:2": {}})
+	if n, err := db.ImportGobMap(in, false); err != nil || n != 2 {
+		t.Fatalf("imported %d, %v", n, err)
+	}
+	var b []byte
+	if err := db.Get("blob:1", &b); err != nil || string(b) != "raw" {
+		t.Fatalf("got %q, %v", b, err)
+	}
+
+	// as with PutEncoded, empty encoded values are refused
+	in = gobMaps(t, map[string][]byte{"bad": {}})
+	if n, err := db.ImportGobMap(in, true); err == nil || n != 0 {
+		t.Fatalf("imported %d, %v", n, err)
+	}
+	if ok, _ := db.Has("bad"); ok {
+		t.Fatal("refused value was stored")
+	}
+}
+
+func TestImportGobAnyMap(t *testing.T) {
+	db := openTestStore(t)
+	in := gobMaps(t, map[string]interface{}{"name": "alice", "age": 30})
+	if n, err := db.ImportGobAnyMap(in); err != nil || n != 2 {
+		t.Fatalf("imported %d, %v", n, err)
+	}
+	var name string
+	var age int
+	if err := db.Get("name", &name); err != nil || name != "alice" {
+		t.Fatalf("got %q, %v", name, err)
+	}
+	if err := db.Get("age", &age); err != nil || age != 30 {
+		t.Fatalf("got %d, %v", age, err)
+	}
+}
+
+func TestImportGobCollision(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		db := openTestStore(t)
+		if err := db.Put("a", []byte("old")); err != nil {
+			t.Fatal(err)
+		}
+		var opts []OpOption
+		if skip {
+			opts = append(opts, WithSkipExisting())
+		}
+		in := gobMaps(t, map[string][]byte{"a": []byte("new"), "b": []byte("new")})
+		n, err := db.ImportGobMap(in, false, opts...)
+		if want := map[bool]int{false: 2, true: 1}[skip]; err != nil || n != want {
+			t.Fatalf("skip %v: imported %d, %v, expected %d", skip, n, err, want)
+		}
+		var v []byte
+		if err := db.Get("a", &v); err != nil {
+			t.Fatal(err)
+		}
+		if want := map[bool]string{false: "new", true: "old"}[skip]; string(v) != want {
+			t.Fatalf("skip %v: got %q, expected %q", skip, v, want)
+		}
+	}
+}
+
+func TestImportGobPartial(t *testing.T) {
+	first := map[string][]byte{}
+	for i := 0; i < importBatch+10; i++ {
+		first[keyN(i)] = []byte("v")
+	}
+	whole := gobMaps(t, first, map[string][]byte{"second": []byte("v")}).Bytes()
+
+	// a stream cut short in the second map keeps the first
+	db := openTestStore(t)
+	n, err := db.ImportGobMap(bytes.NewReader(whole[:len(whole)-3]), false)
+	if err == nil || n != len(first) {
+		t.Fatalf("imported %d, %v, expected %d and an error", n, err, len(first))
+	}
+	if c, err := db.Count(); err != nil || c != len(first) {
+		t.Fatalf("got %d entries, %v", c, err)
+	}
+
+	// as does garbage after the first map
+	db = openTestStore(t)
+	in := gobMaps(t, first)
+	in.WriteString("not gob at all")
+	if n, err := db.ImportGobMap(in, false); err == nil || n != len(first) {
+		t.Fatalf("imported %d, %v, expected %d and an error", n, err, len(first))
+	}
+
+	// input that is not gob imports nothing
+	if n, err := openTestStore(t).ImportGobMap(strings.NewReader("{}"), false); err == nil || n != 0 {
+		t.Fatalf("imported %d, %v", n, err)
+	}
+
+	// neither does a map of another type
+	in = gobMaps(t, map[string]int{"a": 1})
+	if n, err := openTestStore(t).ImportGobMap(in, false); err == nil || n != 0 {
+		t.Fatalf("imported %d, %v", n, err)
+	}
+}
+
+// heapReader reads from r, and records how far the heap grew, checked every
+// so many reads.
+type heapReader struct {
+	r          io.Reader
+	reads      int
+	base, peak uint64
+}
+
+func (h *heapReader) Read(p []byte) (int, error) {
+	if h.reads++; h.reads%64 == 0 {
+		var m runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&m)
+		if m.HeapAlloc > h.base && m.HeapAlloc-h.base > h.peak {
+			h.peak = m.HeapAlloc - h.base
+		}
+	}
+	return h.r.Read(p)
+}
+
+func TestImportGobMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("imports 100k entries")
+	}
+	const maps, perMap = 100, 1000
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	value := bytes.Repeat([]byte("v"), 100)
+	for i := 0; i < maps; i++ {
+		m := make(map[string][]byte, perMap)
+		for j := 0; j < perMap; j++ {
+			m[fmt.Sprintf("user:%06d", i*perMap+j)] = value
+		}
+		if err := enc.Encode(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db := openTestStore(t)
+	var m runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	h := &heapReader{r: &buf, base: m.HeapAlloc}
+	size := buf.Len()
+	n, err := db.ImportGobMap(h, false)
+	if err != nil || n != maps*perMap {
+		t.Fatalf("imported %d, %v", n, err)
+	}
+	t.Logf("%d bytes read, heap grew by %d at most", size, h.peak)
+	if h.peak > uint64(size)/5 {
+		t.Fatalf("heap grew by %d bytes for %d bytes of input", h.peak, size)
+	}
+}
@@ -0,0 +1,381 @@
+package bboltkv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// With WithModTimes, the timestamp of every entry, see timestampBucket, is
+// also kept in "timestamp-index", keyed by the time followed by the key, and
+// deleted keys leave a tombstone: "tombstones" maps them to the time they
+// were deleted at, and "tombstone-index" holds the same pairs keyed by time.
+// The sequence of "tombstone-index" is the latest time of the tombstones
+// pruned from it. Times are big-endian Unix nanoseconds, as for expiry.
+// Both indexes are kept up to date whenever they exist, so that they stay
+// consistent if the option is dropped and given again later.
+const (
+	timestampIndexBucket = "timestamp-index"
+	tombstoneBucket      = "tombstones"
+	tombstoneIndexBucket = "tombstone-index"
+)
+
+// ErrTombstonesPruned is returned by ExportSince when tombstones of keys
+// deleted after the given time have been pruned, see WithModTimes, so the
+// deletions since then cannot all be exported.
+var ErrTombstonesPruned = errors.New("bboltkv: tombstones since the given time have been pruned")
+
+// WithModTimes makes the store record the time every entry was last written
+// or deleted, by the store's clock, so that ExportSince can export the
+// changes made since a given time without reading the other entries. The
+// time of a write is kept as the entry's timestamp, see PutIfNewer, so
+// writes without a timestamp of their own carry the time they were made,
+// which PutIfNewer and WithLastWriteWins then compare against. The time of a
+// deletion is kept in a tombstone for tombstoneRetention, after which it is
+// pruned; 0 keeps tombstones forever.
+//
+// Times never go backwards: a write made after the clock was set back is
+// stamped a nanosecond after the last one, so it still sorts after the
+// writes before it. Only changes made while the option is set are
+// recorded, so when giving it to a store that already holds entries, take
+// a full backup from then on, with ExportJSON.
+func WithModTimes(tombstoneRetention time.Duration) Option {
+	return func(o *options) {
+		o.modTimes = true
+		o.tombstoneRetention = tombstoneRetention
+	}
+}
+
+// modTime returns the time to stamp a change with: now, unless that is not
+// after the last time stamped.
+func (w *wtx) modTime() time.Time {
+	w.loadModTime()
+	s := w.s
+	now := s.now().UnixNano()
+	if now <= s.lastModTime {
+		now = s.lastModTime + 1
+	}
+	s.lastModTime = now
+	return time.Unix(0, now)
+}
+
+// loadModTime reads the latest time stamped before the store was opened,
+// unless a change has been stamped since.
+func (w *wtx) loadModTime() {
+	s := w.s
+	if s.lastModTime != 0 {
+		return
+	}
+	for _, name := range []string{timestampIndexBucket, tombstoneIndexBucket} {
+		if b := s.aux(w.tx, name); b != nil {
+			if k, _ := b.Cursor().Last(); len(k) >= 8 {
+				if t := int64(binary.BigEndian.Uint64(k)); t > s.lastModTime {
+					s.lastModTime = t
+				}
+			}
+		}
+	}
+}
+
+// stampWrite records the time of a write to key, see WithModTimes, and
+// removes its tombstone.
+func (w *wtx) stampWrite(key string) error {
+	if err := w.dropTombstone(key); err != nil {
+		return err
+	}
+	if !w.s.opts.modTimes {
+		return nil
+	}
+	return w.setTimestamp(key, w.modTime())
+}
+
+// stampDelete leaves a tombstone for key, of the time it was deleted at,
+// and prunes those older than the retention of WithModTimes.
+func (w *wtx) stampDelete(key string) error {
+	if !w.s.opts.modTimes {
+		return nil
+	}
+	if err := w.setTombstone(key, w.modTime()); err != nil {
+		return err
+	}
+	if r := w.s.opts.tombstoneRetention; r > 0 {
+		return w.pruneTombstones(w.s.now().Add(-r))
+	}
+	return nil
+}
+
+// setTombstone records that key was deleted at t, replacing any tombstone
+// it had.
+func (w *wtx) setTombstone(key string, t time.Time) error {
+	if err := w.dropTombstone(key); err != nil {
+		return err
+	}
+	b, err := w.aux(tombstoneBucket)
+	if err != nil {
+		return err
+	}
+	index, err := w.aux(tombstoneIndexBucket)
+	if err != nil {
+		return err
+	}
+	raw := encodeExpiry(t)
+	if err := index.Put(expiryIndexKey(raw, key), nil); err != nil {
+		return err
+	}
+	return b.Put([]byte(key), raw)
+}
+
+// dropTombstone removes the tombstone of key, if it has one.
+func (w *wtx) dropTombstone(key string) error {
+	b := w.s.aux(w.tx, tombstoneBucket)
+	if b == nil {
+		return nil
+	}
+	at := b.Get([]byte(key))
+	if at == nil {
+		return nil
+	}
+	if err := w.s.aux(w.tx, tombstoneIndexBucket).Delete(expiryIndexKey(at, key)); err != nil {
+		return err
+	}
+	return b.Delete([]byte(key))
+}
+
+// pruneTombstones removes the tombstones of deletions before t.
+func (w *wtx) pruneTombstones(t time.Time) error {
+	index := w.s.aux(w.tx, tombstoneIndexBucket)
+	if index == nil {
+		return nil
+	}
+	b := w.s.aux(w.tx, tombstoneBucket)
+	before := encodeExpiry(t)
+	var pruned [][]byte
+	c := index.Cursor()
+	for k, _ := c.First(); k != nil && bytes.Compare(k[:8], before) < 0; k, _ = c.Next() {
+		pruned = append(pruned, k)
+	}
+	if len(pruned) == 0 {
+		return nil
+	}
+	for _, k := range pruned {
+		if err := index.Delete(k); err != nil {
+			return err
+		}
+		if err := b.Delete(k[8:]); err != nil {
+			return err
+		}
+	}
+	return index.SetSequence(binary.BigEndian.Uint64(pruned[len(pruned)-1]))
+}
+
+// ExportSince writes the entries written since t, and the keys deleted
+// since then, to w as JSON, one object per line, in the order the changes
+// were made, and returns how many it wrote. The store must have been
+// opened with WithModTimes. Entries are written as by ExportJSON, with the
+// time they were written; deletions as the key, the time it was deleted
+// at, and "deleted":true:
+//
+//	{"key":"user:42","value":"Dv+BAwEB...","time":"2026-10-14T09:30:00Z"}
+//	{"key":"user:7","time":"2026-10-14T09:31:12Z","deleted":true}
+//
+// Changes are found through an index of the times, so an export reads only
+// what changed. They are read in a single transaction, so the export is a
+// consistent snapshot. If tombstones of deletions after t have been pruned,
+// ExportSince returns ErrTombstonesPruned without writing anything: a full
+// export is needed instead. Use ApplyIncremental to apply the changes to
+// another store.
+//
+// To chain incremental backups, pass the time the previous export began at,
+// less a margin for the skew of the store's clock. Changes exported twice
+// are harmless, as ApplyIncremental skips those it has applied already.
+//
+//	since := lastBackup.Add(-time.Minute)
+//	lastBackup = time.Now()
+//	n, err := store.ExportSince(since, f)
+func (s *Store) ExportSince(t time.Time, w io.Writer) (int, error) {
+	if !s.opts.modTimes {
+		return 0, errors.New("bboltkv: ExportSince needs WithModTimes")
+	}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	n := 0
+	err := s.view(func(tx *bbolt.Tx) error {
+		after := encodeExpiry(t.Add(time.Nanosecond))
+		var written, deleted *bbolt.Cursor
+		var wk, dk []byte
+		if b := s.aux(tx, timestampIndexBucket); b != nil {
+			written = b.Cursor()
+			wk, _ = written.Seek(after)
+		}
+		if b := s.aux(tx, tombstoneIndexBucket); b != nil {
+			if int64(b.Sequence()) > t.UnixNano() {
+				return fmt.Errorf("%w: through %s", ErrTombstonesPruned, time.Unix(0, int64(b.Sequence())).UTC())
+			}
+			deleted = b.Cursor()
+			dk, _ = deleted.Seek(after)
+		}
+		// the two indexes merged in time order
+		for wk != nil || dk != nil {
+			var k []byte
+			var e exportEntry
+			if dk == nil || (wk != nil && bytes.Compare(wk, dk) < 0) {
+				k = wk
+				wk, _ = written.Next()
+				v := tx.Bucket(s.bucketName).Get(k[8:])
+				if v == nil || s.hidden(tx, k[8:]) {
+					continue
+				}
+				raw, err := s.assemble(tx, k[8:], v)
+				if err != nil {
+					return err
+				}
+				e.Value = raw
+			} else {
+				k = dk
+				dk, _ = deleted.Next()
+				e.Deleted = true
+			}
+			key, err := s.openKey(k[8:])
+			if err != nil {
+				return err
+			}
+			at := decodeExpiry(k[:8])
+			e.Key, e.Time = key, &at
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, bw.Flush()
+}
+
+// ApplyIncremental reads changes in the format written by ExportSince from r
+// and applies them to the store, and returns how many it applied. Each
+// change is applied only if it is newer than what the store holds for its
+// key: the timestamp of the entry, see PutIfNewer, or the tombstone of its
+// deletion, with WithModTimes. Changes to keys the store holds without a
+// timestamp, or knows nothing about, are always applied. So streams can be
+// applied over a full backup restored with ImportJSON, in any order and more
+// than once, and the newest change of each key wins.
+//
+// The times in the stream are kept as the timestamps of the entries, and of
+// the tombstones of deleted keys with WithModTimes, rather than the time of
+// the store's clock when they are applied, so the clocks of the stores need
+// not agree. Values are validated like those of PutEncoded. Changes are
+// applied in batches, each in its own transaction, like ImportJSON does.
+func (s *Store) ApplyIncremental(r io.Reader) (int, error) {
+	dec := json.NewDecoder(r)
+	b := s.newBatcher(importBatch, nil)
+	applied := 0
+	for {
+		var batch []exportEntry
+		for len(batch) < b.size {
+			var e exportEntry
+			if err := dec.Decode(&e); err == io.EOF {
+				break
+			} else if err != nil {
+				return applied, err
+			}
+			if e.Time == nil {
+				return applied, fmt.Errorf("%w: change of %q has no time", ErrBadValue, e.Key)
+			}
+			if !e.Deleted {
+				if err := s.validateEncoded(e.Key, e.Value); err != nil {
+					return applied, err
+				}
+			}
+			batch = append(batch, e)
+		}
+		if len(batch) == 0 {
+			return applied, nil
+		}
+		s.yieldWrites()
+		n := 0
+		start := s.now()
+		err := s.update(func(w *wtx) error {
+			n = 0
+			for _, e := range batch {
+				ok, err := w.applyChange(s.sealKey(e.Key), e)
+				if err != nil {
+					return err
+				} else if ok {
+					n++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return applied, err
+		}
+		b.done(len(batch), s.now().Sub(start))
+		applied += n
+	}
+}
+
+// applyChange applies a change read by ApplyIncremental to key, unless the
+// store holds a newer one.
+func (w *wtx) applyChange(key string, e exportEntry) (bool, error) {
+	s := w.s
+	if s.opts.modTimes {
+		// later changes made here are stamped after those applied
+		if w.loadModTime(); e.Time.UnixNano() > s.lastModTime {
+			s.lastModTime = e.Time.UnixNano()
+		}
+	}
+	if w.get(key) != nil {
+		if stored, ok := s.timestamp(w.tx, []byte(key)); ok && !e.Time.After(stored) {
+			return false, nil
+		}
+	} else if b := s.aux(w.tx, tombstoneBucket); b != nil {
+		if at := b.Get([]byte(key)); at != nil && !e.Time.After(decodeExpiry(at)) {
+			return false, nil
+		}
+	}
+	if !e.Deleted {
+		if err := w.put(key, e.Value); err != nil {
+			return false, err
+		}
+		return true, w.setTimestamp(key, *e.Time)
+	}
+	if w.b.Get([]byte(key)) != nil {
+		if err := w.delete(key); err != nil {
+			return false, err
+		}
+	}
+	if s.opts.modTimes {
+		if err := w.setTombstone(key, *e.Time); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// checkTombstones is the Fsck check of the tombstone buckets.
+func checkTombstones(f *fsck) {
+	f.checkTimeIndex(tombstoneBucket, tombstoneIndexBucket, false)
+	b := f.s.aux(f.tx, tombstoneBucket)
+	if b == nil {
+		return
+	}
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		if f.present(k) {
+			key := string(k)
+			f.report(FsckOrphanMetadata, tombstoneBucket, k, "tombstone of a present key", func(w *wtx) error {
+				return w.dropTombstone(key)
+			})
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package bboltkv
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unknownnf/bboltkv/testutil"
+)
+
+// exportSince runs ExportSince, checking it exported n changes.
+func exportSince(t *testing.T, db *Store, since time.Time, n int) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	if got, err := db.ExportSince(since, &buf); err != nil {
+		t.Fatal(err)
+	} else if got != n {
+		t.Fatalf("exported %d changes, expected %d:\n%s", got, n, buf.String())
+	}
+	return &buf
+}
+
+// applyIncremental runs ApplyIncremental on a copy of buf, checking it
+// applied n changes.
+func applyIncremental(t *testing.T, db *Store, buf *bytes.Buffer, n int) {
+	t.Helper()
+	if got, err := db.ApplyIncremental(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	} else if got != n {
+		t.Fatalf("applied %d changes, expected %d", got, n)
+	}
+}
+
+func TestExportSince(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	src := openTestStore(t, WithClock(clock), WithModTimes(0))
+	want := map[string]int{}
+	for i := 0; i < 10; i++ {
+		if err := src.Put(keyN(i), i); err != nil {
+			t.Fatal(err)
+		}
+		want[keyN(i)] = i
+	}
+	var full bytes.Buffer
+	if err := src.ExportJSON(&full); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Second)
+	backup := clock.Now()
+
+	clock.Advance(time.Minute)
+	for i := 5; i < 15; i++ {
+		if err := src.Put(keyN(i), 100+i); err != nil {
+			t.Fatal(err)
+		}
+		want[keyN(i)] = 100 + i
+	}
+	for _, i := range []int{0, 7, 12} {
+		if err := src.Delete(keyN(i)); err != nil {
+			t.Fatal(err)
+		}
+		delete(want, keyN(i))
+	}
+	if _, err := src.RenamePrefix("k0001", "moved:", false); err != nil {
+		t.Fatal(err)
+	}
+	for i := 10; i < 15; i++ {
+		if i != 12 {
+			want["moved:"+keyN(i)[5:]] = 100 + i
+			delete(want, keyN(i))
+		}
+	}
+	checkValues(t, src, want)
+	// 4 written and kept, 3 deleted, 4 renamed away and 4 renamed to
+	inc := exportSince(t, src, backup, 15)
+	if lines := strings.Count(inc.String(), `"deleted":true`); lines != 7 {
+		t.Fatalf("exported %d deletions, expected 7", lines)
+	}
+
+	// the full backup with the changes since applied over it
+	dst := openTestStore(t)
+	if _, err := dst.ImportJSON(&full); err != nil {
+		t.Fatal(err)
+	}
+	applyIncremental(t, dst, inc, 15)
+	checkValues(t, dst, want)
+
+	// nothing changed since
+	clock.Advance(time.Second)
+	exportSince(t, src, clock.Now(), 0)
+
+	if _, err := dst.ExportSince(backup, &bytes.Buffer{}); err == nil {
+		t.Fatal("exported without WithModTimes")
+	}
+}
+
+func TestApplyIncrementalDeletions(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	src := openTestStore(t, WithClock(clock), WithModTimes(0))
+	start := clock.Now()
+	clock.Advance(time.Second)
+	if err := src.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Put("b", 1); err != nil {
+		t.Fatal(err)
+	}
+	first := exportSince(t, src, start, 2)
+	clock.Advance(time.Second)
+	if err := src.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	second := exportSince(t, src, start, 2)
+	if !strings.Contains(second.String(), `"key":"a"`) || !strings.Contains(second.String(), `"deleted":true`) {
+		t.Fatalf("deletion not exported: %s", second)
+	}
+
+	dst := openTestStore(t, WithModTimes(0))
+	applyIncremental(t, dst, first, 2)
+	applyIncremental(t, dst, second, 1)
+	checkValues(t, dst, map[string]int{"b": 1})
+	// the older stream again does not bring the deleted key back
+	applyIncremental(t, dst, first, 0)
+	checkValues(t, dst, map[string]int{"b": 1})
+
+	// a store that never held the key keeps the tombstone, so applying the
+	// streams out of order ends up the same
+	other := openTestStore(t, WithModTimes(0))
+	applyIncremental(t, other, second, 2)
+	applyIncremental(t, other, first, 0)
+	checkValues(t, other, map[string]int{"b": 1})
+
+	// written again, the key is no longer deleted
+	clock.Advance(time.Second)
+	if err := src.Put("a", 2); err != nil {
+		t.Fatal(err)
+	}
+	third := exportSince(t, src, start, 2)
+	if strings.Contains(third.String(), `"deleted":true`) {
+		t.Fatalf("tombstone kept: %s", third)
+	}
+	applyIncremental(t, dst, third, 1)
+	checkValues(t, dst, map[string]int{"a": 2, "b": 1})
+}
+
+func TestTombstoneRetention(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock), WithModTimes(time.Hour))
+	for i := 0; i < 3; i++ {
+		if err := db.Put(keyN(i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Advance(time.Second)
+	start := clock.Now()
+	clock.Advance(time.Second)
+	if err := db.Delete(keyN(0)); err != nil {
+		t.Fatal(err)
+	}
+	firstDeleted := clock.Now()
+	clock.Advance(30 * time.Minute)
+	if err := db.Delete(keyN(1)); err != nil {
+		t.Fatal(err)
+	}
+	// retained for an hour
+	exportSince(t, db, start, 2)
+
+	clock.Advance(45 * time.Minute)
+	if err := db.Delete(keyN(2)); err != nil {
+		t.Fatal(err)
+	}
+	// the first tombstone is gone, so a stream since before it would miss
+	// the deletion
+	if _, err := db.ExportSince(start, &bytes.Buffer{}); !errors.Is(err, ErrTombstonesPruned) {
+		t.Fatalf("got %v, expected ErrTombstonesPruned", err)
+	}
+	exportSince(t, db, firstDeleted, 2)
+
+	// kept forever without a retention
+	db = openTestStore(t, WithClock(clock), WithModTimes(0))
+	if err := db.Put("key", 1); err != nil {
+		t.Fatal(err)
+	}
+	start = clock.Now()
+	if err := db.Delete("key"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(1000 * time.Hour)
+	if err := db.Put("other", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("other"); err != nil {
+		t.Fatal(err)
+	}
+	exportSince(t, db, start.Add(-time.Nanosecond), 2)
+}
+
+func TestExportSinceClockSkew(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC))
+	src := openTestStore(t, WithClock(clock), WithModTimes(0))
+	start := clock.Now()
+	if err := src.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	// the clock is set back: later writes still come after earlier ones
+	clock.Advance(-time.Hour)
+	if err := src.Put("b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Put("a", 2); err != nil {
+		t.Fatal(err)
+	}
+	ta, err := src.Timestamp("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb, err := src.Timestamp("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tb.After(start) || !ta.After(tb) {
+		t.Fatalf("stamped b at %v and a at %v after %v", tb, ta, start)
+	}
+	inc := exportSince(t, src, start.Add(-time.Nanosecond), 2)
+
+	// a store whose clock is a day ahead keeps the source's times
+	ahead := testutil.NewFakeClock(start.Add(24 * time.Hour))
+	dst := openTestStore(t, WithClock(ahead), WithModTimes(0))
+	applyIncremental(t, dst, inc, 2)
+	checkValues(t, dst, map[string]int{"a": 2, "b": 1})
+	if got, err := dst.Timestamp("a"); err != nil || !got.Equal(ta) {
+		t.Fatalf("got %v, %v, expected %v", got, err, ta)
+	}
+	// overlapping streams are applied again harmlessly
+	applyIncremental(t, dst, inc, 0)
+	checkValues(t, dst, map[string]int{"a": 2, "b": 1})
+
+	// a store whose clock is a day behind stamps its own writes after
+	// those it applied
+	behind := testutil.NewFakeClock(start.Add(-24 * time.Hour))
+	dst = openTestStore(t, WithClock(behind), WithModTimes(0))
+	applyIncremental(t, dst, inc, 2)
+	if err := dst.Put("b", 3); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := dst.Timestamp("b"); err != nil || !got.After(ta) {
+		t.Fatalf("got %v, %v, expected a time after %v", got, err, ta)
+	}
+	// so the stream does not undo them
+	applyIncremental(t, dst, inc, 0)
+	checkValues(t, dst, map[string]int{"a": 2, "b": 3})
+}
+
+func TestApplyIncrementalBad(t *testing.T) {
+	db := openTestStore(t)
+	for _, stream := range []string{
+		`{"key":"a","value":"AA=="}`,
+		`{"key":"a","time":"2026-10-14T12:00:00Z"}`,
+		`{"key":"a",`,
+	} {
+		if _, err := db.ApplyIncremental(strings.NewReader(stream)); err == nil {
+			t.Fatalf("applied %s", stream)
+		}
+	}
+	checkValues(t, db, map[string]int{})
+}
@@ -0,0 +1,346 @@
+package bboltkv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// journalTimesBucket holds a bucket per journal, see WithJournalRetention,
+// mapping seconds since the epoch, as 8 bytes big-endian, to the number of
+// the last entry appended within that second, in the same form.
+const journalTimesBucket = "journal-times"
+
+// changeLogConsumersBucket holds the positions recorded with AckChanges,
+// under the consumers' names, as 8 bytes big-endian.
+const changeLogConsumersBucket = "changelog-consumers"
+
+// journalPruneInterval is the least time between two prunings after
+// writes, see WithJournalRetention.
+const journalPruneInterval = time.Second
+
+// ErrRetentionBlocked is wrapped by the error PruneJournals returns when a
+// journal is over its retention, but the entries retention would prune
+// have not been acknowledged by all consumers. The error names the
+// journals concerned.
+var ErrRetentionBlocked = errors.New("bboltkv: journal retention blocked by unacknowledged entries")
+
+// WithJournalRetention bounds the journals of the store, the append-only
+// buckets of the change log, the outbox and the topics: each is pruned of
+// its oldest entries while it holds more than maxBytes of keys and values,
+// and of those appended more than maxAge ago. Either bound can be 0 for
+// none. Pruning runs in the background after writes that append to a
+// journal, a second after the first of them, and with PruneJournals.
+//
+// Entries that a consumer has not acknowledged yet are never pruned. The
+// consumers of the change log are those recorded with AckChanges, which
+// includes replicas pulling with WithReplicaName; those of a topic are the
+// ones acknowledging with Ack; and the outbox holds only events
+// ConsumeOutbox has not delivered yet. When retention would have to prune
+// such entries, it stops short of them, and PruneJournals returns an error
+// wrapping ErrRetentionBlocked, which background pruning reports to the
+// logger of WithLogger. See JournalLag to act before that happens.
+//
+// The age of entries is recorded to the second, from when the option is
+// given on; entries appended before that are only pruned by size, or along
+// with the first entry whose age is known.
+//
+//	store, err := bboltkv.Open(path, "bucket", bboltkv.WithChangeLog(),
+//	    bboltkv.WithJournalRetention(64<<20, 7*24*time.Hour))
+func WithJournalRetention(maxBytes int64, maxAge time.Duration) Option {
+	return func(o *options) {
+		o.journalMaxBytes = maxBytes
+		o.journalMaxAge = maxAge
+	}
+}
+
+// WithReplicaName makes PullFrom name the replica to the primary, which
+// records how far it has got, so that WithJournalRetention on the primary
+// keeps the changes it has yet to pull. Names must be unique among the
+// replicas of a primary.
+func WithReplicaName(name string) OpOption {
+	return func(o *opOptions) {
+		o.replicaName = name
+	}
+}
+
+// journalPruner runs the pruning of WithJournalRetention after writes.
+type journalPruner struct {
+	due chan struct{}
+}
+
+func (j *journalPruner) signal() {
+	select {
+	case j.due <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Store) pruneLoop(done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-s.pruner.due:
+		}
+		// the writes meanwhile are pruned for together
+		select {
+		case <-done:
+			return
+		case <-s.clock().After(journalPruneInterval):
+		}
+		if _, err := s.PruneJournals(); err != nil {
+			s.opts.logf("bboltkv: pruning journals: %v", err)
+		}
+	}
+}
+
+// journaled records that entry seq was appended to journal, for
+// WithJournalRetention.
+func (w *wtx) journaled(journal string, seq uint64) error {
+	if w.s.pruner == nil {
+		return nil
+	}
+	if !w.journal {
+		w.journal = true
+		w.tx.OnCommit(w.s.pruner.signal)
+	}
+	if w.s.opts.journalMaxAge <= 0 {
+		return nil
+	}
+	tb, err := w.aux(journalTimesBucket)
+	if err != nil {
+		return err
+	}
+	b, err := tb.CreateBucketIfNotExists([]byte(journal))
+	if err != nil {
+		return err
+	}
+	return b.Put(changeKey(uint64(w.s.now().Unix())), changeKey(seq))
+}
+
+// AckChanges records that consumer has processed the changes of the change
+// log, see Changes, up to and including seq, so that WithJournalRetention
+// keeps the ones after it. The position only moves forward, like with Ack.
+func (s *Store) AckChanges(consumer string, seq uint64) error {
+	return s.update(func(w *wtx) error {
+		b, err := w.aux(changeLogConsumersBucket)
+		if err != nil {
+			return err
+		}
+		if v := b.Get([]byte(consumer)); len(v) == 8 && binary.BigEndian.Uint64(v) >= seq {
+			return nil
+		}
+		return b.Put([]byte(consumer), changeKey(seq))
+	})
+}
+
+// PruneJournals prunes the journals of the store as WithJournalRetention
+// says, in a single transaction, and returns how many entries it removed.
+// Without the option, it removes none. If retention is held up by
+// unacknowledged entries, PruneJournals prunes what it can, and returns
+// the number with an error wrapping ErrRetentionBlocked.
+func (s *Store) PruneJournals() (int, error) {
+	maxBytes, maxAge := s.opts.journalMaxBytes, s.opts.journalMaxAge
+	if maxBytes <= 0 && maxAge <= 0 {
+		return 0, nil
+	}
+	n := 0
+	var blocked []string
+	err := s.update(func(w *wtx) error {
+		n, blocked = 0, nil
+		for _, journal := range s.journalNames(w.tx) {
+			pruned, ok, err := w.pruneJournal(journal, maxBytes, maxAge)
+			if err != nil {
+				return err
+			}
+			n += pruned
+			if !ok {
+				blocked = append(blocked, journal)
+			}
+		}
+		return nil
+	})
+	if err == nil && len(blocked) > 0 {
+		err = fmt.Errorf("%w: %s", ErrRetentionBlocked, strings.Join(blocked, ", "))
+	}
+	return n, err
+}
+
+// pruneJournal prunes journal, and reports whether it got it within its
+// retention.
+func (w *wtx) pruneJournal(journal string, maxBytes int64, maxAge time.Duration) (int, bool, error) {
+	b := w.s.aux(w.tx, journal)
+	if b == nil {
+		return 0, true, nil
+	}
+	var times *bbolt.Bucket
+	if tb := w.s.aux(w.tx, journalTimesBucket); tb != nil {
+		times = tb.Bucket([]byte(journal))
+	}
+	// entries up to old were appended before the cutoff
+	var old uint64
+	if maxAge > 0 && times != nil {
+		cutoff := changeKey(uint64(w.s.now().Add(-maxAge).Unix()))
+		c := times.Cursor()
+		for k, v := c.First(); k != nil && bytes.Compare(k, cutoff) < 0; k, v = c.Next() {
+			old = binary.BigEndian.Uint64(v)
+		}
+	}
+	var total int64
+	if maxBytes > 0 {
+		b.ForEach(func(k, v []byte) error {
+			total += int64(len(k) + len(v))
+			return nil
+		})
+	}
+	floor := uint64(math.MaxUint64)
+	for _, c := range w.s.journalConsumers(w.tx, journal) {
+		if c.offset < floor {
+			floor = c.offset
+		}
+	}
+
+	var drop [][]byte
+	ok := true
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		seq := binary.BigEndian.Uint64(k)
+		if over := maxBytes > 0 && total > maxBytes; !over && seq > old {
+			break
+		}
+		if seq > floor {
+			ok = false
+			break
+		}
+		drop = append(drop, append([]byte(nil), k...))
+		total -= int64(len(k) + len(v))
+	}
+	for _, k := range drop {
+		if err := b.Delete(k); err != nil {
+			return 0, false, err
+		}
+	}
+	if times != nil && len(drop) > 0 {
+		// the times of the entries pruned are of no more use
+		last := drop[len(drop)-1]
+		var marks [][]byte
+		c := times.Cursor()
+		for k, v := c.First(); k != nil && bytes.Compare(v, last) <= 0; k, v = c.Next() {
+			marks = append(marks, append([]byte(nil), k...))
+		}
+		for _, k := range marks {
+			if err := times.Delete(k); err != nil {
+				return 0, false, err
+			}
+		}
+	}
+	return len(drop), ok, nil
+}
+
+// journalNames returns the names of the journals in tx, which are those of
+// their internal buckets.
+func (s *Store) journalNames(tx *bbolt.Tx) []string {
+	var names []string
+	for _, name := range []string{changeLogBucket, outboxBucket} {
+		if s.aux(tx, name) != nil {
+			names = append(names, name)
+		}
+	}
+	prefix := s.auxName(topicBucket(""))
+	c := tx.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		names = append(names, string(k[len(s.auxName("")):]))
+	}
+	return names
+}
+
+type journalConsumer struct {
+	name   string
+	offset uint64 // the last entry acknowledged
+}
+
+// journalConsumers returns the consumers of journal, see
+// WithJournalRetention.
+func (s *Store) journalConsumers(tx *bbolt.Tx, journal string) []journalConsumer {
+	var consumers []journalConsumer
+	switch {
+	case journal == changeLogBucket:
+		if b := s.aux(tx, changeLogConsumersBucket); b != nil {
+			b.ForEach(func(k, v []byte) error {
+				if len(v) == 8 {
+					consumers = append(consumers, journalConsumer{string(k), binary.BigEndian.Uint64(v)})
+				}
+				return nil
+			})
+		}
+	case journal == outboxBucket:
+		// delivered events are deleted, so all those left are unacknowledged
+		b := s.aux(tx, outboxBucket)
+		offset := b.Sequence()
+		if k, _ := b.Cursor().First(); k != nil {
+			offset = binary.BigEndian.Uint64(k) - 1
+		}
+		consumers = append(consumers, journalConsumer{"ConsumeOutbox", offset})
+	case strings.HasPrefix(journal, topicBucket("")):
+		b := s.aux(tx, offsetsBucket)
+		if b == nil {
+			break
+		}
+		prefix := []byte(KeyPrefix(journal[len(topicBucket("")):]))
+		c := b.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if parts := ParseKey(string(k)); len(parts) == 2 && len(v) == 8 {
+				consumers = append(consumers, journalConsumer{parts[1], binary.BigEndian.Uint64(v)})
+			}
+		}
+	}
+	return consumers
+}
+
+// ConsumerLag is how far a consumer of a journal is behind, see JournalLag.
+type ConsumerLag struct {
+	Journal  string // "changelog", "outbox", or "topic:" followed by the topic
+	Consumer string // as given to AckChanges, WithReplicaName or Ack, or "ConsumeOutbox"
+	Offset   uint64 // the last entry the consumer acknowledged
+	Last     uint64 // the last entry appended to the journal
+	Entries  int    // entries after Offset still in the journal
+	Bytes    int64  // size of their keys and values
+}
+
+// JournalLag returns the lag of every consumer of the store's journals,
+// see WithJournalRetention, in a single read transaction. A consumer whose
+// unacknowledged entries approach the retention's maxBytes, or whose
+// oldest ones its maxAge, is about to block retention.
+//
+//	lags, err := store.JournalLag()
+//	for _, l := range lags {
+//	    if l.Bytes > maxBytes/2 {
+//	        log.Printf("%s of %s is %d entries behind", l.Consumer, l.Journal, l.Entries)
+//	    }
+//	}
+func (s *Store) JournalLag() ([]ConsumerLag, error) {
+	var lags []ConsumerLag
+	err := s.view(func(tx *bbolt.Tx) error {
+		for _, journal := range s.journalNames(tx) {
+			b := s.aux(tx, journal)
+			for _, c := range s.journalConsumers(tx, journal) {
+				l := ConsumerLag{Journal: journal, Consumer: c.name, Offset: c.offset, Last: b.Sequence()}
+				cur := b.Cursor()
+				for k, v := cur.Seek(changeKey(c.offset + 1)); k != nil; k, v = cur.Next() {
+					l.Entries++
+					l.Bytes += int64(len(k) + len(v))
+				}
+				lags = append(lags, l)
+			}
+		}
+		return nil
+	})
+	return lags, err
+}
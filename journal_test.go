@@ -0,0 +1,255 @@
+package bboltkv
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unknownnf/bboltkv/testutil"
+	"go.etcd.io/bbolt"
+)
+
+// journalSeqs returns the numbers of the entries left in a journal, and
+// the size of their keys and values.
+func journalSeqs(t *testing.T, db *Store, journal string) ([]uint64, int64) {
+	t.Helper()
+	var seqs []uint64
+	var size int64
+	err := db.view(func(tx *bbolt.Tx) error {
+		b := db.aux(tx, journal)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			seqs = append(seqs, binary.BigEndian.Uint64(k))
+			size += int64(len(k) + len(v))
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return seqs, size
+}
+
+func TestJournalRetentionSize(t *testing.T) {
+	// the fake clock holds off pruning after writes
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock), WithChangeLog(), WithJournalRetention(400, 0))
+	for i := 0; i < 50; i++ {
+		if err := db.Put(keyN(i), i); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Publish("orders", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := db.PruneJournals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept := 0
+	for _, journal := range []string{changeLogBucket, topicBucket("orders")} {
+		seqs, size := journalSeqs(t, db, journal)
+		if len(seqs) == 0 || len(seqs) >= 50 || seqs[len(seqs)-1] != 50 || size > 400 {
+			t.Fatalf("%s holds %v, %d bytes", journal, seqs, size)
+		}
+		// the entries are all the same size, and no more than needed go
+		if one := size / int64(len(seqs)); size+one <= 400 {
+			t.Fatalf("%s pruned down to %d bytes", journal, size)
+		}
+		kept += len(seqs)
+	}
+	if n != 100-kept {
+		t.Fatalf("pruned %d, kept %d", n, kept)
+	}
+	// within retention, nothing more goes
+	if n, err := db.PruneJournals(); err != nil || n != 0 {
+		t.Fatalf("pruned %d, %v", n, err)
+	}
+}
+
+func TestJournalRetentionAge(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock), WithChangeLog(), WithJournalRetention(0, time.Hour))
+	for i := 0; i < 5; i++ {
+		if err := db.Put(keyN(i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Advance(30 * time.Minute)
+	for i := 5; i < 8; i++ {
+		if err := db.Put(keyN(i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := db.PruneJournals(); err != nil || n != 0 {
+		t.Fatalf("pruned %d, %v before any entry is an hour old", n, err)
+	}
+	// advancing the clock lets pruning after writes run too
+	clock.Advance(45 * time.Minute)
+	if _, err := db.PruneJournals(); err != nil {
+		t.Fatal(err)
+	}
+	if seqs, _ := journalSeqs(t, db, changeLogBucket); len(seqs) != 3 || seqs[0] != 6 {
+		t.Fatalf("change log holds %v", seqs)
+	}
+	clock.Advance(time.Hour)
+	if _, err := db.PruneJournals(); err != nil {
+		t.Fatal(err)
+	}
+	if seqs, _ := journalSeqs(t, db, changeLogBucket); len(seqs) != 0 {
+		t.Fatalf("change log holds %v", seqs)
+	}
+}
+
+func TestJournalRetentionConsumers(t *testing.T) {
+	db := openTestStore(t, WithClock(testutil.NewFakeClock(time.Now())), WithChangeLog(), WithJournalRetention(1, 0))
+	for i := 0; i < 10; i++ {
+		if _, err := db.Publish("orders", i); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put(keyN(i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Ack("orders", "mailer", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ack("orders", "billing", 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AckChanges("backup", 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithEvent("order:1", 1, []byte("placed")); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := db.PruneJournals()
+	if !errors.Is(err, ErrRetentionBlocked) || n != 3+4 {
+		t.Fatalf("pruned %d, %v", n, err)
+	}
+	for _, journal := range []string{"changelog", "outbox", "topic:orders"} {
+		if !strings.Contains(err.Error(), journal) {
+			t.Errorf("%v does not name %s", err, journal)
+		}
+	}
+	if seqs, _ := journalSeqs(t, db, topicBucket("orders")); len(seqs) != 7 || seqs[0] != 4 {
+		t.Fatalf("topic holds %v", seqs)
+	}
+	if seqs, _ := journalSeqs(t, db, changeLogBucket); len(seqs) != 7 || seqs[0] != 5 {
+		t.Fatalf("change log holds %v", seqs)
+	}
+
+	// once all is acknowledged, retention goes through
+	if err := db.Ack("orders", "mailer", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ack("orders", "billing", 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AckChanges("backup", 11); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ConsumeOutbox(func(uint64, []byte) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.PruneJournals(); err != nil || n != 7+7 {
+		t.Fatalf("pruned %d, %v", n, err)
+	}
+}
+
+func TestJournalLag(t *testing.T) {
+	db := openTestStore(t, WithChangeLog())
+	for i := 0; i < 5; i++ {
+		if _, err := db.Publish("orders", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Ack("orders", "mailer", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithEvent("order:1", 1, []byte("placed")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AckChanges("backup", 1); err != nil {
+		t.Fatal(err)
+	}
+	lags, err := db.JournalLag()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]ConsumerLag{
+		"changelog backup":     {Offset: 1, Last: 1, Entries: 0},
+		"outbox ConsumeOutbox": {Offset: 0, Last: 1, Entries: 1},
+		"topic:orders mailer":  {Offset: 2, Last: 5, Entries: 3},
+	}
+	if len(lags) != len(want) {
+		t.Fatalf("got %+v", lags)
+	}
+	for _, l := range lags {
+		w, ok := want[l.Journal+" "+l.Consumer]
+		if !ok || l.Offset != w.Offset || l.Last != w.Last || l.Entries != w.Entries {
+			t.Errorf("got %+v, expected %+v", l, w)
+		}
+		if (l.Entries == 0) != (l.Bytes == 0) {
+			t.Errorf("%+v: bytes do not match entries", l)
+		}
+	}
+}
+
+func TestJournalRetentionReplica(t *testing.T) {
+	primary := openTestStore(t, WithClock(testutil.NewFakeClock(time.Now())), WithChangeLog(), WithJournalRetention(1, 0))
+	if err := primary.PutAll(map[string]interface{}{"a": 1, "b": 2}); err != nil {
+		t.Fatal(err)
+	}
+	srv, _ := replicationServer(t, primary)
+	replica := openTestStore(t)
+	pull := func() {
+		stop, err := replica.PullFrom(srv.URL+"/replication", time.Hour, WithReplicaName("r1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stop()
+	}
+	pull()
+	if err := primary.Put("c", 3); err != nil {
+		t.Fatal(err)
+	}
+	pull()
+	if err := primary.Put("d", 4); err != nil {
+		t.Fatal(err)
+	}
+	// the primary learns how far the replica got from its next pull, and
+	// keeps the changes after that
+	if _, err := primary.PruneJournals(); !errors.Is(err, ErrRetentionBlocked) {
+		t.Fatalf("got %v", err)
+	}
+	seqs, _ := journalSeqs(t, primary, changeLogBucket)
+	if len(seqs) != 2 || seqs[0] != 3 {
+		t.Fatalf("change log holds %v", seqs)
+	}
+	pull()
+	if got := contents(t, replica); got != "a=1 b=2 c=3 d=4" {
+		t.Fatalf("replica holds %s", got)
+	}
+}
+
+func TestJournalRetentionAfterWrites(t *testing.T) {
+	db := openTestStore(t, WithChangeLog(), WithJournalRetention(1, 0))
+	for i := 0; i < 5; i++ {
+		if err := db.Put(keyN(i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for seqs, _ := journalSeqs(t, db, changeLogBucket); len(seqs) == 5; seqs, _ = journalSeqs(t, db, changeLogBucket) {
+		if time.Now().After(deadline) {
+			t.Fatal("change log not pruned after writes")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,187 @@
+package bboltkv
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnsupportedWithEncryptedKeys is returned by methods that depend on
+// the order or the structure of key names, such as prefix scans, when the
+// store was opened with WithEncryptedKeys.
+var ErrUnsupportedWithEncryptedKeys = errors.New("bboltkv: not supported with encrypted keys")
+
+// WithEncryptedKeys encrypts key names as well as values, with keys derived
+// from the key given to WithEncryption, which it requires; otherwise Open
+// fails. Names are encrypted deterministically, so the same name always
+// maps to the same ciphertext, and reads and writes of single keys work as
+// before. The ciphertext reveals the length of the name, and whether two
+// entries have the same name, but nothing of its content.
+//
+// Since encryption scatters names over the key space, entries are no
+// longer stored in key order: Keys sorts the names once it has decrypted
+// them, but ForEach, ForEachChunked, DeleteWhere and the other iterations
+// visit entries in no particular order. Methods scanning for a prefix
+// return ErrUnsupportedWithEncryptedKeys, except for the given prefixes:
+// keys starting with one of them are stored in a group of their own,
+// under a token derived from the prefix, so that DeletePrefix, CountPrefix,
+// EstimateCountPrefix, GetPrefixParts and Preload can find them. None of
+// the prefixes may start with another; otherwise Open fails. Methods of
+// features keeping key names in indexes of their own, such as tags, lists,
+// leases, protection and views, return ErrUnsupportedWithEncryptedKeys
+// too.
+//
+// Stores opened without the option, or with a different key, see the
+// encrypted names as they are stored. Names written before the option was
+// enabled cannot be decrypted, and make iterations fail.
+//
+//	store, err := bboltkv.Open("data.db", "users",
+//	    bboltkv.WithEncryption(key), bboltkv.WithEncryptedKeys("email:"))
+func WithEncryptedKeys(prefixes ...string) Option {
+	return func(o *options) {
+		o.encryptedKeys = true
+		o.keyPrefixes = append([]string(nil), prefixes...)
+	}
+}
+
+// keyCipher encrypts key names for WithEncryptedKeys, in the manner of
+// SIV: the nonce is a MAC of the name, so encryption is deterministic. A
+// name is stored as the base64 of nonce and ciphertext, preceded by the
+// token of its prefix group and a dot, if it has one; the dot does not
+// occur in base64.
+type keyCipher struct {
+	gcm      cipher.AEAD
+	mac      []byte
+	prefixes []string
+	groups   map[string]string // token to prefix
+}
+
+var keyEncoding = base64.RawURLEncoding
+
+func newKeyCipher(o options) (*keyCipher, error) {
+	if o.encryptionKey == nil {
+		return nil, errors.New("bboltkv: WithEncryptedKeys needs WithEncryption")
+	}
+	derive := func(label string) []byte {
+		h := hmac.New(sha256.New, o.encryptionKey)
+		h.Write([]byte("bboltkv key names " + label))
+		return h.Sum(nil)
+	}
+	block, err := aes.NewCipher(derive("encryption")[:len(o.encryptionKey)])
+	if err != nil {
+		return nil, fmt.Errorf("bboltkv: encryption key: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c := &keyCipher{gcm: gcm, mac: derive("mac"), groups: make(map[string]string)}
+	for _, prefix := range o.keyPrefixes {
+		if prefix == "" {
+			continue
+		}
+		for _, p := range o.keyPrefixes {
+			if p != prefix && strings.HasPrefix(p, prefix) {
+				return nil, fmt.Errorf("bboltkv: encrypted key prefix %q starts with %q", p, prefix)
+			}
+		}
+		c.prefixes = append(c.prefixes, prefix)
+		c.groups[c.token(prefix)] = prefix
+	}
+	return c, nil
+}
+
+func (c *keyCipher) sum(parts ...string) []byte {
+	h := hmac.New(sha256.New, c.mac)
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return h.Sum(nil)
+}
+
+func (c *keyCipher) token(prefix string) string {
+	return keyEncoding.EncodeToString(c.sum("prefix", prefix)[:9])
+}
+
+// seal returns the stored name of key.
+func (c *keyCipher) seal(key string) string {
+	group, prefix := "", ""
+	for _, p := range c.prefixes {
+		if strings.HasPrefix(key, p) {
+			prefix = p
+			group = c.token(p) + "."
+			break
+		}
+	}
+	rest := key[len(prefix):]
+	nonce := c.sum("name", prefix, rest)[:c.gcm.NonceSize()]
+	return group + keyEncoding.EncodeToString(c.gcm.Seal(nonce, nonce, []byte(rest), []byte(prefix)))
+}
+
+// open returns the key of a stored name.
+func (c *keyCipher) open(stored []byte) (string, error) {
+	prefix := ""
+	if i := bytes.IndexByte(stored, '.'); i >= 0 {
+		var ok bool
+		if prefix, ok = c.groups[string(stored[:i])]; !ok {
+			return "", fmt.Errorf("%w: key %q is in an unknown prefix group", ErrCorrupt, stored)
+		}
+		stored = stored[i+1:]
+	}
+	data := make([]byte, keyEncoding.DecodedLen(len(stored)))
+	n, err := keyEncoding.Decode(data, stored)
+	if err != nil || n < c.gcm.NonceSize() {
+		return "", fmt.Errorf("%w: key %q is not encrypted", ErrCorrupt, stored)
+	}
+	nonce := data[:c.gcm.NonceSize()]
+	rest, err := c.gcm.Open(nil, nonce, data[len(nonce):n], []byte(prefix))
+	if err != nil {
+		return "", fmt.Errorf("bboltkv: cannot decrypt key: %w", err)
+	}
+	return prefix + string(rest), nil
+}
+
+// sealKey returns the name key is stored under.
+func (s *Store) sealKey(key string) string {
+	if s.keys == nil {
+		return key
+	}
+	return s.keys.seal(key)
+}
+
+// openKey returns the key of an entry stored under name k.
+func (s *Store) openKey(k []byte) (string, error) {
+	if s.keys == nil {
+		return string(k), nil
+	}
+	return s.keys.open(k)
+}
+
+// sealPrefix returns the prefix of the stored names of the keys starting
+// with prefix, which with WithEncryptedKeys only exists for no prefix at
+// all and for the prefixes given to the option.
+func (s *Store) sealPrefix(prefix []byte) ([]byte, error) {
+	if s.keys == nil || len(prefix) == 0 {
+		return prefix, nil
+	}
+	if token := s.keys.token(string(prefix)); s.keys.groups[token] == string(prefix) {
+		return []byte(token + "."), nil
+	}
+	return nil, ErrUnsupportedWithEncryptedKeys
+}
+
+// plainKeys returns ErrUnsupportedWithEncryptedKeys for stores opened with
+// WithEncryptedKeys, from methods that need key names in the clear.
+func (s *Store) plainKeys() error {
+	if s.keys != nil {
+		return ErrUnsupportedWithEncryptedKeys
+	}
+	return nil
+}
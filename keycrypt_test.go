@@ -0,0 +1,206 @@
+package bboltkv
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var encryptedKeys = []string{"alice@example.com", "email:bob@example.com", "email:carol@example.com", "", "\x00\xff"}
+
+func TestEncryptedKeys(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(name, "test", WithEncryption(testKey), WithEncryptedKeys("email:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range encryptedKeys {
+		if err := db.Put(key, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(db *Store) {
+		t.Helper()
+		for i, key := range encryptedKeys {
+			var v int
+			if err := db.Get(key, &v); err != nil || v != i {
+				t.Fatalf("%q: got %d, %v, expected %d", key, v, err, i)
+			}
+		}
+		if err := db.Get("alice@example", nil); err != ErrNotFound {
+			t.Fatalf("got %v for a prefix of a key, expected ErrNotFound", err)
+		}
+		keys, err := db.Keys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := append([]string(nil), encryptedKeys...)
+		sort.Strings(want)
+		if !reflect.DeepEqual(keys, want) {
+			t.Fatalf("Keys returned %q, expected %q", keys, want)
+		}
+		var seen []string
+		err = db.ForEach(func(key string, decode func(interface{}) error) error {
+			seen = append(seen, key)
+			return decode(new(int))
+		})
+		sort.Strings(seen)
+		if err != nil || !reflect.DeepEqual(seen, want) {
+			t.Fatalf("ForEach visited %q, %v", seen, err)
+		}
+	}
+	check(db)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(name, "test", WithEncryption(testKey), WithEncryptedKeys("email:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(db)
+	if err := db.Delete("alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.Has("alice@example.com"); err != nil || ok {
+		t.Fatalf("deleted key still there: %v", err)
+	}
+	db.Close()
+
+	// other key material finds nothing
+	db, err = Open(name, "test", WithEncryption([]byte("fedcba9876543210")), WithEncryptedKeys("email:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Get("email:bob@example.com", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if _, err := db.Keys(); err == nil {
+		t.Fatal("Keys decrypted names with the wrong key")
+	}
+}
+
+func TestEncryptedKeysPrefixes(t *testing.T) {
+	db := openTestStore(t, WithEncryption(testKey), WithEncryptedKeys("email:", "id:"))
+	for i := 0; i < 20; i++ {
+		if err := db.Put(fmt.Sprintf("email:%d@example.com", i), i); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put(fmt.Sprintf("id:%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put(fmt.Sprintf("other:%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := db.CountPrefix("email:"); err != nil || n != 20 {
+		t.Fatalf("counted %d, %v", n, err)
+	}
+	if n, _, err := db.EstimateCountPrefix("id:", 10); err != nil || n != 20 {
+		t.Fatalf("estimated %d, %v", n, err)
+	}
+	var seen []string
+	err := db.forEachPrefix([]byte("id:"), func(key string, _ func(interface{}) error) error {
+		seen = append(seen, key)
+		return nil
+	})
+	if err != nil || len(seen) != 20 || !strings.HasPrefix(seen[0], "id:") {
+		t.Fatalf("got %q, %v", seen, err)
+	}
+	if n, err := db.DeletePrefix("email:"); err != nil || n != 20 {
+		t.Fatalf("deleted %d, %v", n, err)
+	}
+	if n, err := db.Count(); err != nil || n != 40 {
+		t.Fatalf("%d entries left, %v", n, err)
+	}
+
+	// only with the prefixes of the option
+	for name, err := range map[string]error{
+		"DeletePrefix": func() error { _, err := db.DeletePrefix("other:"); return err }(),
+		"CountPrefix":  func() error { _, err := db.CountPrefix("email"); return err }(),
+		"GetPrefixParts": db.GetPrefixParts(func(string, func(interface{}) error) error {
+			return nil
+		}, "tenant"),
+		"Protect":        db.Protect("id:1"),
+		"PutTagged":      db.PutTagged("id:1", 1, "tag"),
+		"Append":         func() error { _, err := db.Append("list", 1); return err }(),
+		"ListNamespaces": func() error { _, err := db.ListNamespaces("", ':'); return err }(),
+	} {
+		if err != ErrUnsupportedWithEncryptedKeys {
+			t.Errorf("%s: got %v, expected ErrUnsupportedWithEncryptedKeys", name, err)
+		}
+	}
+}
+
+func TestEncryptedKeysOnDisk(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(name, "test", WithEncryption(testKey), WithEncryptedKeys("email:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string]interface{}{
+		"email:dana@example.com": "Dana",
+		"erin@example.com":       "Erin",
+	}
+	if err := db.PutAll(entries); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("frank@example.com", "Frank"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plain := range []string{"dana", "erin", "frank", "example", "email"} {
+		if bytes.Contains(bytes.ToLower(data), []byte(plain)) {
+			t.Errorf("file contains %q", plain)
+		}
+	}
+}
+
+func TestEncryptedKeysExport(t *testing.T) {
+	db := openTestStore(t, WithEncryption(testKey), WithEncryptedKeys())
+	if err := db.Put("alice@example.com", 1); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := db.ExportJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// exports hold the names in the clear
+	plain := openTestStore(t, WithEncryption(testKey))
+	if _, err := plain.ImportJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	if err := plain.Get("alice@example.com", &v); err != nil || v != 1 {
+		t.Fatalf("got %d, %v", v, err)
+	}
+	// and Merge encrypts them again
+	other := openTestStore(t, WithEncryption(testKey), WithEncryptedKeys())
+	if n, err := other.Merge(plain); err != nil || n != 1 {
+		t.Fatalf("merged %d, %v", n, err)
+	}
+	if err := other.Get("alice@example.com", &v); err != nil || v != 1 {
+		t.Fatalf("got %d, %v", v, err)
+	}
+}
+
+func TestEncryptedKeysOptions(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Open(filepath.Join(dir, "a.db"), "test", WithEncryptedKeys()); err == nil {
+		t.Fatal("opened without an encryption key")
+	}
+	if _, err := Open(filepath.Join(dir, "b.db"), "test", WithEncryption(testKey), WithEncryptedKeys("user:", "user:admin:")); err == nil {
+		t.Fatal("opened with nested prefixes")
+	}
+}
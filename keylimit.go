@@ -0,0 +1,60 @@
+package bboltkv
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrKeyTooLarge is wrapped by the error returned when writing under a key
+// longer than the limit set with WithKeyLimit.
+var ErrKeyTooLarge = errors.New("bboltkv: key too large")
+
+// ErrInvalidKey is wrapped by the error returned when writing under a key
+// holding a character rejected with WithKeyCharset.
+var ErrInvalidKey = errors.New("bboltkv: invalid key")
+
+// WithKeyLimit makes the methods writing entries reject keys longer than
+// maxBytes bytes with an error wrapping ErrKeyTooLarge, before any
+// transaction begins. bbolt stores keys of up to 32KB, but every key takes
+// room in the pages of the tree leading to it, so a few huge keys, such as
+// a value written as a key by mistake, leave the file mostly empty pages.
+// Entries already stored under longer keys can still be read and deleted.
+//
+//	store, err := bboltkv.Open(path, "bucket", bboltkv.WithKeyLimit(512))
+func WithKeyLimit(maxBytes int) Option {
+	return func(o *options) {
+		o.keyLimit = maxBytes
+	}
+}
+
+// WithKeyCharset makes the methods writing entries reject keys holding a
+// character allowed returns false for with an error wrapping ErrInvalidKey,
+// before any transaction begins. Keys are read as UTF-8, so allowed is
+// called with each character, whatever the number of bytes it has, and
+// with utf8.RuneError for each byte that is not valid UTF-8. Like
+// WithKeyLimit, the option leaves entries already stored alone.
+//
+//	bboltkv.WithKeyCharset(func(r rune) bool {
+//	    return r != utf8.RuneError && !unicode.IsControl(r)
+//	})
+func WithKeyCharset(allowed func(r rune) bool) Option {
+	return func(o *options) {
+		o.keyCharset = allowed
+	}
+}
+
+// checkKey checks a key about to be written against the limits set with
+// WithKeyLimit and WithKeyCharset.
+func (s *Store) checkKey(key string) error {
+	if n := s.opts.keyLimit; n > 0 && len(key) > n {
+		return fmt.Errorf("%w: key of %d bytes, limit %d", ErrKeyTooLarge, len(key), n)
+	}
+	if allowed := s.opts.keyCharset; allowed != nil {
+		for i, r := range key {
+			if !allowed(r) {
+				return fmt.Errorf("%w: %q at byte %d", ErrInvalidKey, r, i)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package bboltkv
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+func TestKeyLimit(t *testing.T) {
+	db := openTestStore(t, WithKeyLimit(8))
+	for _, key := range []string{"k", "12345678", "héllo!"} {
+		if err := db.Put(key, 1); err != nil {
+			t.Fatalf("%q: %v", key, err)
+		}
+	}
+	for _, key := range []string{"123456789", "héllo!!!"} {
+		err := db.Put(key, 1)
+		if !errors.Is(err, ErrKeyTooLarge) {
+			t.Fatalf("%q: got %v, expected ErrKeyTooLarge", key, err)
+		} else if !strings.Contains(err.Error(), "9 bytes") {
+			t.Fatalf("%q: length missing from %q", key, err)
+		}
+	}
+	checkValues(t, db, map[string]int{"k": 1, "12345678": 1, "héllo!": 1})
+}
+
+func TestKeyCharset(t *testing.T) {
+	printable := func(r rune) bool { return r != utf8.RuneError && !unicode.IsControl(r) }
+	db := openTestStore(t, WithKeyCharset(printable))
+	for _, key := range []string{"user:42", "ключ", "日本語", "emoji:🙂"} {
+		if err := db.Put(key, 1); err != nil {
+			t.Fatalf("%q: %v", key, err)
+		}
+	}
+	for _, test := range []struct {
+		key string
+		at  string
+	}{
+		{"line\nbreak", "byte 4"},
+		{"日本\x00語", "byte 6"},
+		{"bad\xffutf8", "byte 3"},
+		{"\u0085next", "byte 0"},
+	} {
+		err := db.Put(test.key, 1)
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Fatalf("%q: got %v, expected ErrInvalidKey", test.key, err)
+		} else if !strings.Contains(err.Error(), test.at) {
+			t.Fatalf("%q: %s missing from %q", test.key, test.at, err)
+		}
+	}
+
+	// characters are seen whole, not byte by byte
+	var seen []rune
+	db = openTestStore(t, WithKeyCharset(func(r rune) bool {
+		seen = append(seen, r)
+		return r < 0x10000
+	}))
+	if err := db.Put("aé日", 1); err != nil {
+		t.Fatal(err)
+	} else if string(seen) != "aé日" {
+		t.Fatalf("saw %q", string(seen))
+	}
+	if err := db.Put("a🙂", 1); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("got %v, expected ErrInvalidKey", err)
+	}
+}
+
+func TestKeyLimitWritePaths(t *testing.T) {
+	long := strings.Repeat("x", 17)
+	ok := strings.Repeat("x", 16)
+	db := openTestStore(t, WithKeyLimit(16), WithKeyCharset(func(r rune) bool { return r != ' ' }))
+	encoded, err := db.Encode(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writes := map[string]func(key string) error{
+		"Put": func(key string) error { return db.Put(key, 1) },
+		"PutAll": func(key string) error {
+			return db.PutAll(map[string]interface{}{"fine": 1, key: 1})
+		},
+		"PutEncoded": func(key string) error { return db.PutEncoded(key, encoded) },
+		"PutWithTTL": func(key string) error { return db.PutWithTTL(key, 1, time.Hour) },
+		"PutAllWithTTL": func(key string) error {
+			return db.PutAllWithTTL([]TTLEntry{{Key: "fine", Value: 1}, {Key: key, Value: 1}})
+		},
+		"Append": func(key string) error {
+			_, err := db.Append(key, 1)
+			return err
+		},
+		"BucketTx.Put": func(key string) error {
+			return db.Update(func(tx *WriteTx) error {
+				return tx.InBucket("test").Put(key, 1)
+			})
+		},
+		"ImportJSON": func(key string) error {
+			var buf strings.Builder
+			src := openTestStore(t)
+			if err := src.Put(key, 1); err != nil {
+				return err
+			}
+			if err := src.ExportJSON(&buf); err != nil {
+				return err
+			}
+			_, err := db.ImportJSON(strings.NewReader(buf.String()))
+			return err
+		},
+	}
+	for name, write := range writes {
+		if err := write(long); !errors.Is(err, ErrKeyTooLarge) {
+			t.Fatalf("%s: got %v, expected ErrKeyTooLarge", name, err)
+		}
+		if err := write("a key"); !errors.Is(err, ErrInvalidKey) {
+			t.Fatalf("%s: got %v, expected ErrInvalidKey", name, err)
+		}
+		checkValues(t, db, map[string]int{})
+		if err := write(ok); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if _, err := db.DeletePrefix(""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the destinations of renames
+	if err := db.Put("a:123456", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RenamePrefix("a:", "b c:", false); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("got %v, expected ErrInvalidKey", err)
+	}
+	if _, err := db.RenamePrefix("a:", "longer:prefix:", false); !errors.Is(err, ErrKeyTooLarge) {
+		t.Fatalf("got %v, expected ErrKeyTooLarge", err)
+	}
+	checkValues(t, db, map[string]int{"a:123456": 1})
+	if n, err := db.RenamePrefix("a:", "bb:", false); err != nil || n != 1 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	checkValues(t, db, map[string]int{"bb:123456": 1})
+}
+
+func TestKeyLimitLegacyKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	huge := strings.Repeat("k", 20000)
+	control := "tab\there"
+	for _, key := range []string{huge, control, "kept"} {
+		if err := db.Put(key, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	db, err = Open(path, "test", WithKeyLimit(64), WithKeyCharset(func(r rune) bool { return !unicode.IsControl(r) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	checkValues(t, db, map[string]int{huge: 1, control: 1, "kept": 1})
+	if err := db.Put(huge, 2); !errors.Is(err, ErrKeyTooLarge) {
+		t.Fatalf("got %v, expected ErrKeyTooLarge", err)
+	}
+	for _, key := range []string{huge, control} {
+		if err := db.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkValues(t, db, map[string]int{"kept": 1})
+}
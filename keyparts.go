@@ -0,0 +1,108 @@
+package bboltkv
+
+import (
+	"strings"
+)
+
+// Key parts are joined with partSep, and zero bytes inside parts are
+// written as partZero. Both start with a zero byte, so no other byte of a
+// part can be mistaken for them, and partSep sorts below partZero, so that
+// a part sorts before the same part continued with a zero byte.
+const (
+	partSep  = "\x00\x01"
+	partZero = "\x00\xff"
+)
+
+// Key builds a composite key from parts, which may contain any bytes,
+// separators included. ParseKey splits it again. Keys built this way sort
+// like their parts do, compared part by part: by the first part, then the
+// second, and so on, with a key sorting before those that extend it with
+// further parts. Range scans over the first parts of composite keys thus
+// see them in the order of those parts.
+//
+// Parts are joined with the two bytes 0x00 0x01, and zero bytes inside
+// parts are escaped as 0x00 0xff; other bytes are kept as they are. Key()
+// and Key("") both return "".
+//
+//	key := bboltkv.Key("tenant:acme", "user", "42")
+//	parts := bboltkv.ParseKey(key) // ["tenant:acme" "user" "42"]
+func Key(parts ...string) string {
+	var b strings.Builder
+	for i, part := range parts {
+		if i > 0 {
+			b.WriteString(partSep)
+		}
+		for {
+			j := strings.IndexByte(part, 0)
+			if j < 0 {
+				break
+			}
+			b.WriteString(part[:j])
+			b.WriteString(partZero)
+			part = part[j+1:]
+		}
+		b.WriteString(part)
+	}
+	return b.String()
+}
+
+// ParseKey splits a key built with Key into its parts. A key not built with
+// Key comes back as its only part, unless it contains zero bytes; zero
+// bytes not followed by 0x01 or 0xff are kept as they are.
+func ParseKey(key string) []string {
+	var parts []string
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(key, 0)
+		if i < 0 || i == len(key)-1 {
+			b.WriteString(key)
+			break
+		}
+		b.WriteString(key[:i])
+		switch key[i+1] {
+		case partSep[1]:
+			parts = append(parts, b.String())
+			b.Reset()
+			key = key[i+2:]
+		case partZero[1]:
+			b.WriteByte(0)
+			key = key[i+2:]
+		default:
+			b.WriteByte(0)
+			key = key[i+1:]
+		}
+	}
+	return append(parts, b.String())
+}
+
+// KeyPrefix returns the prefix shared by all keys built with Key that start
+// with parts and have at least one more part, for use with DeletePrefix,
+// ExportPrefix and the like. Unlike Key(parts...) as a prefix, it does not
+// also match keys whose part in that place merely starts with the last of
+// parts: KeyPrefix("user", "4") matches Key("user", "4", "x"), but not
+// Key("user", "42"). Without parts, it returns "", which matches all keys.
+//
+//	n, err := store.DeletePrefix(bboltkv.KeyPrefix("tenant:acme", "user"))
+func KeyPrefix(parts ...string) string {
+	if len(parts) == 0 {
+		return ""
+	}
+	return Key(parts...) + partSep
+}
+
+// GetPrefixParts calls fn for every entry whose key was built with Key from
+// parts followed by at least one more part, in key order, like ForEach
+// does for all entries. The iteration runs in a single read transaction; if
+// fn returns an error, GetPrefixParts stops and returns that error.
+//
+//	err := store.GetPrefixParts(func(key string, decode func(interface{}) error) error {
+//	    var u User
+//	    if err := decode(&u); err != nil {
+//	        return err
+//	    }
+//	    log.Printf("user %s: %v", bboltkv.ParseKey(key)[2], u)
+//	    return nil
+//	}, "tenant:acme", "user")
+func (s *Store) GetPrefixParts(fn func(key string, decode func(interface{}) error) error, parts ...string) error {
+	return s.forEachPrefix([]byte(KeyPrefix(parts...)), fn)
+}
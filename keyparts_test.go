@@ -0,0 +1,145 @@
+package bboltkv
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestKeyRoundTrip(t *testing.T) {
+	for _, parts := range [][]string{
+		{""},
+		{"a"},
+		{"a", "b"},
+		{"tenant:acme", "user:42"},
+		{"", ""},
+		{"a", "", "b"},
+		{"x\x00y", "\x00", "\x00\x01", "\x00\xff", "\xff"},
+		{"ends\x00"},
+	} {
+		key := Key(parts...)
+		if got := ParseKey(key); !reflect.DeepEqual(got, parts) {
+			t.Errorf("ParseKey(Key(%q)) = %q", parts, got)
+		}
+	}
+	if Key() != "" || Key("") != "" {
+		t.Fatal("Key() and Key(\"\") should be empty")
+	}
+	if Key("a", "b") == Key("a\x00\x01b") {
+		t.Fatal("a separator inside a part collides with a real one")
+	}
+	// keys not built with Key
+	for _, key := range []string{"plain", "a:b:c", "odd\x00", "odd\x00x"} {
+		if got := ParseKey(key); len(got) != 1 || got[0] != key {
+			t.Errorf("ParseKey(%q) = %q", key, got)
+		}
+	}
+}
+
+// compareParts orders tuples of parts part by part, with a tuple sorting
+// before those that extend it.
+func compareParts(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] < b[i] {
+			return -1
+		} else if a[i] > b[i] {
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
+func TestKeyOrdering(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	// bytes around the ones the encoding uses
+	alphabet := []byte{0x00, 0x01, 0x02, ':', 'a', 'b', 0xfe, 0xff}
+	randomParts := func() []string {
+		parts := make([]string, 1+rnd.Intn(3))
+		for i := range parts {
+			b := make([]byte, rnd.Intn(4))
+			for j := range b {
+				b[j] = alphabet[rnd.Intn(len(alphabet))]
+			}
+			parts[i] = string(b)
+		}
+		return parts
+	}
+	for i := 0; i < 20000; i++ {
+		a, b := randomParts(), randomParts()
+		want := compareParts(a, b)
+		ka, kb := Key(a...), Key(b...)
+		got := 0
+		if ka < kb {
+			got = -1
+		} else if ka > kb {
+			got = 1
+		}
+		if (want < 0) != (got < 0) || (want > 0) != (got > 0) {
+			t.Fatalf("%q vs %q: parts compare %d, keys %q vs %q compare %d", a, b, want, ka, kb, got)
+		}
+		if !reflect.DeepEqual(ParseKey(ka), a) {
+			t.Fatalf("ParseKey(Key(%q)) = %q", a, ParseKey(ka))
+		}
+	}
+}
+
+func TestGetPrefixParts(t *testing.T) {
+	db := openTestStore(t)
+	keys := [][]string{
+		{"user", "4", "name"},
+		{"user", "4", "mail"},
+		{"user", "42", "name"},
+		{"user", "4:x", "name"},
+		{"user", "4\x00", "name"},
+		{"user", "4"},
+		{"group", "4", "name"},
+	}
+	for i, parts := range keys {
+		if err := db.Put(Key(parts...), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scan := func(parts ...string) string {
+		var got []string
+		err := db.GetPrefixParts(func(key string, decode func(interface{}) error) error {
+			var v int
+			if err := decode(&v); err != nil {
+				return err
+			}
+			got = append(got, fmt.Sprintf("%q=%d", ParseKey(key), v))
+			return nil
+		}, parts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprint(got)
+	}
+	if got := scan("user", "4"); got != `[["user" "4" "mail"]=1 ["user" "4" "name"]=0]` {
+		t.Fatalf("got %s", got)
+	}
+	if got := scan("user", "4\x00"); got != `[["user" "4\x00" "name"]=4]` {
+		t.Fatalf("got %s", got)
+	}
+
+	// the parts of "user" keys come out ordered by their second part
+	var seconds []string
+	db.GetPrefixParts(func(key string, _ func(interface{}) error) error {
+		seconds = append(seconds, ParseKey(key)[1])
+		return nil
+	}, "user")
+	if len(seconds) != 6 || !sort.StringsAreSorted(seconds) {
+		t.Fatalf("got %q", seconds)
+	}
+
+	if n, err := db.DeletePrefix(KeyPrefix("user", "4")); err != nil || n != 2 {
+		t.Fatalf("DeletePrefix deleted %d, %v, expected 2", n, err)
+	}
+	if ok, err := db.Has(Key("user", "4")); err != nil || !ok {
+		t.Fatalf("DeletePrefix deleted the key of the prefix itself: %v, %v", ok, err)
+	}
+	if n, err := db.Count(); err != nil || n != 5 {
+		t.Fatalf("got %d entries, %v", n, err)
+	}
+}
@@ -0,0 +1,327 @@
+package bboltkv
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+
+	"go.etcd.io/bbolt"
+)
+
+// Keys returns the keys of all entries in the store, in lexicographic
+// order. Keys that have expired, and the key written by WithSelfStats, are
+// left out.
+func (s *Store) Keys() (_ []string, err error) {
+	if s.opts.tracer != nil {
+		defer s.startSpan(s.opts.tracer, "Keys", "")(&err)
+	}
+	var keys []string
+	var openErr error
+	err = s.forEachKey(func(k []byte) {
+		key, err := s.openKey(k)
+		if err != nil && openErr == nil {
+			openErr = err
+		}
+		keys = append(keys, key)
+	})
+	if err == nil {
+		err = openErr
+	}
+	if err == nil && s.keys != nil {
+		sort.Strings(keys)
+	}
+	return keys, err
+}
+
+// keyStreamChunk is the number of keys KeysChan reads per transaction.
+const keyStreamChunk = 1000
+
+// KeyStream delivers the keys streamed by KeysChan.
+type KeyStream struct {
+	// C delivers the keys, and is closed once they have all been
+	// delivered or the stream stopped early.
+	C <-chan string
+
+	mu  sync.Mutex
+	err error
+}
+
+// Err returns the error that stopped the stream early, or nil if all keys
+// were delivered. It is only final once C is closed.
+func (ks *KeyStream) Err() error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return ks.err
+}
+
+// KeysChan streams the keys of the entries whose keys start with prefix, as
+// Keys reports them, through the channel of the KeyStream it returns,
+// without holding them all in memory. The keys are read from the database
+// in chunks of 1000, each in a read transaction of its own, so that a slow
+// reader does not keep a transaction open. As with ForEachChunked, the
+// stream is therefore not a consistent snapshot. Keys come in key order,
+// except with WithEncryptedKeys, where they come in the order of their
+// stored names.
+//
+// The stream stops early when ctx is done or the store is closed, and the
+// channel is closed in every case. Once it is, Err tells whether the
+// stream stopped early, and why. A reader quitting before the end must
+// cancel ctx, so that the goroutine feeding the channel ends.
+//
+//	ctx, cancel := context.WithCancel(ctx)
+//	defer cancel()
+//	ks := store.KeysChan(ctx, "user:")
+//	for key := range ks.C {
+//	    fmt.Println(key)
+//	}
+//	if err := ks.Err(); err != nil {
+//	    return err
+//	}
+func (s *Store) KeysChan(ctx context.Context, prefix string) *KeyStream {
+	ch := make(chan string, keyStreamChunk)
+	ks := &KeyStream{C: ch}
+	go func() {
+		defer close(ch)
+		err := s.streamKeys(ctx, []byte(prefix), ch)
+		ks.mu.Lock()
+		ks.err = err
+		ks.mu.Unlock()
+	}()
+	return ks
+}
+
+func (s *Store) streamKeys(ctx context.Context, prefix []byte, ch chan<- string) error {
+	prefix, err := s.sealPrefix(prefix)
+	if err != nil {
+		return err
+	}
+	after := prefix
+	first := true
+	for {
+		var keys [][]byte
+		err := s.view(func(tx *bbolt.Tx) error {
+			c := tx.Bucket(s.bucketName).Cursor()
+			k, _ := c.Seek(after)
+			if !first && k != nil && bytes.Equal(k, after) {
+				k, _ = c.Next()
+			}
+			for ; k != nil && bytes.HasPrefix(k, prefix) && len(keys) < keyStreamChunk; k, _ = c.Next() {
+				if !s.hidden(tx, k) {
+					keys = append(keys, append([]byte(nil), k...))
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			key, err := s.openKey(k)
+			if err != nil {
+				return err
+			}
+			select {
+			case ch <- key:
+			case <-ctx.Done():
+				return contextError(ctx)
+			case <-s.done:
+				return ErrClosed
+			}
+		}
+		if len(keys) < keyStreamChunk {
+			return nil
+		}
+		after, first = keys[len(keys)-1], false
+	}
+}
+
+// Count returns the number of entries in the store, leaving out the same
+// keys as Keys.
+func (s *Store) Count() (int, error) {
+	n := 0
+	err := s.forEachKey(func([]byte) { n++ })
+	return n, err
+}
+
+// forEachKey calls fn for every key reported by Keys.
+func (s *Store) forEachKey(fn func(key []byte)) error {
+	return s.view(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(s.bucketName).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if !s.hidden(tx, k) {
+				fn(k)
+			}
+		}
+		return nil
+	})
+}
+
+// hidden reports whether key is left out of Keys and Count.
+func (s *Store) hidden(tx *bbolt.Tx, key []byte) bool {
+	if s.opts.selfStatsKey != "" && string(key) == s.sealKey(s.opts.selfStatsKey) {
+		return true
+	}
+	return s.expired(tx, string(key))
+}
+
+// Has reports whether an entry with the given key is present, leaving out
+// expired entries like Get.
+func (s *Store) Has(key string) (bool, error) {
+	err := s.Get(key, nil)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// WithSortedKeys tells HasMulti that the keys it is given are in
+// lexicographic order, so that it can look them all up with a single
+// cursor, stepping from one key to the next rather than descending the tree
+// from its root for each, which is several times faster for keys close to
+// each other. Keys out of order are still looked up correctly, only more
+// slowly.
+func WithSortedKeys() OpOption {
+	return func(o *opOptions) {
+		o.sortedKeys = true
+	}
+}
+
+// sortedStep is the number of entries HasMulti steps over with
+// WithSortedKeys before seeking the next key instead.
+const sortedStep = 8
+
+// HasMulti reports for each of keys whether an entry with that key is
+// present, like Has, in a single read transaction. The result holds one
+// element for each element of keys, in the same order, duplicates
+// included. For many keys, pass them in order with WithSortedKeys.
+//
+//	present, err := store.HasMulti(candidates, bboltkv.WithSortedKeys())
+//	for i, key := range candidates {
+//	    if !present[i] {
+//	        missing = append(missing, key)
+//	    }
+//	}
+func (s *Store) HasMulti(keys []string, opts ...OpOption) ([]bool, error) {
+	o := buildOpOptions(opts)
+	present := make([]bool, len(keys))
+	err := s.view(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucketName)
+		if !o.sortedKeys {
+			for i, key := range keys {
+				key = s.sealKey(key)
+				present[i] = !s.filterAbsent(key) && b.Get([]byte(key)) != nil && !s.expired(tx, key)
+			}
+			return nil
+		}
+		c := b.Cursor()
+		var k []byte
+		var prev string
+		for i, key := range keys {
+			key = s.sealKey(key)
+			if i == 0 || key < prev {
+				k, _ = c.Seek([]byte(key))
+			} else {
+				for n := 0; k != nil && string(k) < key; n++ {
+					if n == sortedStep {
+						k, _ = c.Seek([]byte(key))
+						break
+					}
+					k, _ = c.Next()
+				}
+			}
+			prev = key
+			present[i] = k != nil && string(k) == key && !s.expired(tx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return present, nil
+}
+
+// ForEach calls fn for every entry reported by Keys, in key order, with a
+// function decoding the entry's value as Get does. The iteration runs in a
+// single read transaction; if fn returns an error, ForEach stops and
+// returns that error.
+//
+//	err := store.ForEach(func(key string, decode func(interface{}) error) error {
+//	    var u User
+//	    return decode(&u)
+//	})
+func (s *Store) ForEach(fn func(key string, decode func(interface{}) error) error) (err error) {
+	if s.opts.tracer != nil {
+		defer s.startSpan(s.opts.tracer, "ForEach", "")(&err)
+	}
+	return s.forEachPrefix(nil, fn)
+}
+
+// forEachPrefix is ForEach for the entries whose keys start with prefix.
+func (s *Store) forEachPrefix(prefix []byte, fn func(key string, decode func(interface{}) error) error) error {
+	prefix, err := s.sealPrefix(prefix)
+	if err != nil {
+		return err
+	}
+	return s.view(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(s.bucketName).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if s.hidden(tx, k) {
+				continue
+			}
+			v, err := s.assemble(tx, k, v)
+			if err != nil {
+				return err
+			}
+			key, err := s.openKey(k)
+			if err != nil {
+				return err
+			}
+			if err := fn(key, func(value interface{}) error { return s.decode(v, value) }); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ForEachChunked calls fn for every entry reported by Keys, in key order,
+// with the encoded value as stored, like ForEach but without holding one
+// read transaction for the whole iteration. Entries are read chunk at a
+// time in a transaction of their own, and fn runs outside of any, so it may
+// write to the store. A long-running read transaction keeps bbolt from
+// reusing the pages freed by writes made meanwhile, so the file grows
+// while it lasts; ForEachChunked only holds each transaction for as long as
+// it takes to read one chunk.
+//
+// The iteration is therefore not a consistent snapshot: each chunk resumes
+// from the last key of the one before, as it is then. Keys added or deleted
+// ahead of that position by the time a chunk is read are seen accordingly,
+// while those changed behind it are not. If fn returns an error,
+// ForEachChunked stops and returns that error. A chunk of 0 or less means
+// 1000 keys.
+func (s *Store) ForEachChunked(chunk int, fn func(key string, raw []byte) error) error {
+	if chunk <= 0 {
+		chunk = defaultCheckpoint
+	}
+	var after []byte
+	for {
+		keys, values, err := s.readBatch(after, chunk)
+		if err != nil {
+			return err
+		}
+		for i, k := range keys {
+			key, err := s.openKey(k)
+			if err != nil {
+				return err
+			}
+			if err := fn(key, values[i]); err != nil {
+				return err
+			}
+		}
+		if len(keys) < chunk {
+			return nil
+		}
+		after = keys[len(keys)-1]
+	}
+}
@@ -0,0 +1,388 @@
+package bboltkv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unknownnf/bboltkv/testutil"
+)
+
+func TestKeys(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock))
+	if keys, err := db.Keys(); err != nil || len(keys) != 0 {
+		t.Fatalf("got %v, %v, expected no keys", keys, err)
+	}
+	for _, key := range []string{"b", "a", "c"} {
+		if err := db.Put(key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.PutWithTTL("d", "d", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := db.Keys(); err != nil || !reflect.DeepEqual(keys, []string{"a", "b", "c", "d"}) {
+		t.Fatalf("got %v, %v", keys, err)
+	}
+	clock.Advance(time.Minute)
+	if keys, err := db.Keys(); err != nil || !reflect.DeepEqual(keys, []string{"a", "b", "c"}) {
+		t.Fatalf("got %v, %v, expected the expired key to be left out", keys, err)
+	}
+	if n, err := db.Count(); err != nil || n != 3 {
+		t.Fatalf("got %d, %v, expected 3", n, err)
+	}
+}
+
+func TestHasForEach(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock))
+	for i, key := range []string{"b", "a", "c"} {
+		if err := db.Put(key, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.PutWithTTL("d", 3, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+	for key, want := range map[string]bool{"a": true, "c": true, "d": false, "e": false} {
+		if ok, err := db.Has(key); err != nil || ok != want {
+			t.Fatalf("%s: got %v, %v", key, ok, err)
+		}
+	}
+	errStop := errors.New("stop")
+	var got []string
+	err := db.ForEach(func(key string, decode func(interface{}) error) error {
+		var v int
+		if err := decode(&v); err != nil {
+			return err
+		}
+		got = append(got, key+"="+string(rune('0'+v)))
+		if key == "b" {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop || !reflect.DeepEqual(got, []string{"a=1", "b=0"}) {
+		t.Fatalf("got %v, %v", got, err)
+	}
+}
+
+func TestForEachChunked(t *testing.T) {
+	db := openTestStore(t)
+	fillStore(t, db, 95)
+	var got []string
+	count := 0
+	err := db.ForEachChunked(10, func(key string, raw []byte) error {
+		var v int
+		if err := db.Decode(raw, &v); err != nil {
+			return err
+		}
+		got = append(got, key)
+		count++
+		// changes between chunks: the next chunk starts at keyN(count+1)
+		if count == 10 {
+			if err := db.Delete(keyN(10)); err != nil {
+				return err
+			}
+			if err := db.Put(keyN(5)+"a", 0); err != nil {
+				return err
+			}
+			if err := db.Put(keyN(10)+"a", 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the key deleted ahead of the scan and the one added behind it are
+	// not seen, while the one added ahead of it is
+	if len(got) != 95 || got[9] != keyN(9) || got[10] != keyN(10)+"a" || got[94] != keyN(94) {
+		t.Fatalf("got %d keys: %v", len(got), got)
+	}
+
+	// early stop, also exactly at a chunk boundary
+	for _, stop := range []int{7, 20} {
+		errStop := errors.New("stop")
+		n := 0
+		err := db.ForEachChunked(10, func(key string, raw []byte) error {
+			if n++; n == stop {
+				return errStop
+			}
+			return nil
+		})
+		if err != errStop || n != stop {
+			t.Fatalf("stopped after %d keys, %v", n, err)
+		}
+	}
+	// an empty store, and a chunk size dividing the number of keys
+	empty := openTestStore(t)
+	if err := empty.ForEachChunked(10, func(string, []byte) error { return errors.New("called") }); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	if err := db.ForEachChunked(19, func(string, []byte) error { n++; return nil }); err != nil || n != 96 {
+		t.Fatalf("got %d keys, %v", n, err)
+	}
+}
+
+func TestForEachChunkedGrowth(t *testing.T) {
+	// Writing from within ForEach is only safe while the file need not be
+	// remapped, so the writes are kept few.
+	value := strings.Repeat("v", 200)
+	growth := func(scan func(db *Store, fn func()) error) int64 {
+		db := openTestStore(t)
+		for i := 0; i < 1000; i++ {
+			if err := db.Put(keyN(i), value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		before, err := db.Usage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		err = scan(db, func() {
+			if n < 30 {
+				if err := db.Put(keyN(n*37%1000), value+"x"); err != nil {
+					t.Fatal(err)
+				}
+				n++
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		after, err := db.Usage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return after.FileSize - before.FileSize
+	}
+	pinned := growth(func(db *Store, fn func()) error {
+		return db.ForEach(func(string, func(interface{}) error) error { fn(); return nil })
+	})
+	chunked := growth(func(db *Store, fn func()) error {
+		return db.ForEachChunked(20, func(string, []byte) error { fn(); return nil })
+	})
+	if pinned <= 0 || chunked*4 > pinned {
+		t.Fatalf("file grew by %d bytes during ForEachChunked, %d during ForEach", chunked, pinned)
+	}
+}
+
+func streamedKeys(t *testing.T, ks *KeyStream) []string {
+	t.Helper()
+	var keys []string
+	for key := range ks.C {
+		keys = append(keys, key)
+	}
+	if err := ks.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestKeysChan(t *testing.T) {
+	db := openTestStore(t)
+	entries := map[string]interface{}{}
+	for i := 0; i < 2*keyStreamChunk+10; i++ {
+		entries[keyN(i)] = i
+	}
+	for i := 0; i < 50; i++ {
+		entries[fmt.Sprintf("user:%03d", i)] = i
+	}
+	if err := db.PutAll(entries); err != nil {
+		t.Fatal(err)
+	}
+	want, err := db.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := streamedKeys(t, db.KeysChan(context.Background(), ""))
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("streamed %d keys, Keys returned %d", len(got), len(want))
+	}
+	got = streamedKeys(t, db.KeysChan(context.Background(), "user:"))
+	if len(got) != 50 || got[0] != "user:000" || got[49] != "user:049" {
+		t.Fatalf("got %d keys for the prefix: %q...", len(got), got[:3])
+	}
+	if got := streamedKeys(t, db.KeysChan(context.Background(), "none:")); len(got) != 0 {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestKeysChanCancel(t *testing.T) {
+	db := openTestStore(t)
+	entries := map[string]interface{}{}
+	for i := 0; i < 5*keyStreamChunk; i++ {
+		entries[keyN(i)] = i
+	}
+	if err := db.PutAll(entries); err != nil {
+		t.Fatal(err)
+	}
+	before := runtime.NumGoroutine()
+	ctx, cancel := context.WithCancel(context.Background())
+	ks := db.KeysChan(ctx, "")
+	<-ks.C
+	cancel()
+	start := time.Now()
+	n := 0
+	for range ks.C {
+		n++
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("stream went on for %v after cancelling", d)
+	}
+	// at most the keys already buffered arrive after cancelling
+	if n >= 5*keyStreamChunk-1 {
+		t.Fatalf("got all %d keys after cancelling", n)
+	}
+	if err := ks.Err(); err != context.Canceled {
+		t.Fatalf("got %v, expected context.Canceled", err)
+	}
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > before; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines, %d before streaming", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// closing the store stops an abandoned stream too
+	ks = db.KeysChan(context.Background(), "")
+	<-ks.C
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range ks.C {
+	}
+	if err := ks.Err(); err != ErrClosed {
+		t.Fatalf("got %v, expected ErrClosed", err)
+	}
+}
+
+func TestHasMulti(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock), WithNegativeLookupFilter(10))
+	entries := map[string]interface{}{}
+	for i := 0; i < 100; i += 3 {
+		entries[keyN(i)] = i
+	}
+	if err := db.PutAll(entries); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithTTL(keyN(1), 1, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+
+	var keys []string
+	for i := 0; i < 110; i++ {
+		keys = append(keys, keyN(i))
+	}
+	// duplicates, out of order, and keys before and after all entries
+	keys = append(keys, keyN(3), keyN(3), keyN(50), "", "a", "zzz", keyN(0))
+	reversed := make([]string, len(keys))
+	for i, key := range keys {
+		reversed[len(keys)-1-i] = key
+	}
+	for _, keys := range [][]string{keys, reversed} {
+		for _, opts := range [][]OpOption{nil, {WithSortedKeys()}} {
+			present, err := db.HasMulti(keys, opts...)
+			if err != nil {
+				t.Fatal(err)
+			} else if len(present) != len(keys) {
+				t.Fatalf("got %d results for %d keys", len(present), len(keys))
+			}
+			for i, key := range keys {
+				if has, err := db.Has(key); err != nil {
+					t.Fatal(err)
+				} else if present[i] != has {
+					t.Fatalf("%q (%d of %d, %d options): got %v, Has reports %v", key, i, len(keys), len(opts), present[i], has)
+				}
+			}
+		}
+	}
+	if present, err := db.HasMulti(nil, WithSortedKeys()); err != nil || len(present) != 0 {
+		t.Fatalf("got %v, %v", present, err)
+	}
+}
+
+func TestHasMultiSorted(t *testing.T) {
+	db := openTestStore(t)
+	entries := map[string]interface{}{}
+	for i := 0; i < 5000; i++ {
+		if i%7 != 0 && i%100 > 30 {
+			entries[keyN(i)] = i
+		}
+	}
+	if err := db.PutAll(entries); err != nil {
+		t.Fatal(err)
+	}
+	// clustered runs and long gaps, so that the cursor both steps and seeks
+	var keys []string
+	for i := 0; i < 5000; i += 1 + i%13 {
+		keys = append(keys, keyN(i), keyN(i))
+	}
+	sorted, err := db.HasMulti(keys, WithSortedKeys())
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsorted, err := db.HasMulti(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sorted, unsorted) {
+		t.Fatal("the sorted path disagrees with the unsorted one")
+	}
+	for i, key := range keys {
+		if _, ok := entries[key]; ok != sorted[i] {
+			t.Fatalf("%s: got %v, expected %v", key, sorted[i], ok)
+		}
+	}
+}
+
+// BenchmarkHasMulti looks up 10000 keys among 50000 entries, either a run
+// of neighbouring keys or keys spread over all entries, half of them absent.
+func BenchmarkHasMulti(b *testing.B) {
+	db := openTestStore(b)
+	entries := map[string]interface{}{}
+	for i := 0; i < 100000; i += 2 {
+		entries[keyN(i)] = i
+		if len(entries) == 10000 {
+			if err := db.PutAll(entries); err != nil {
+				b.Fatal(err)
+			}
+			entries = map[string]interface{}{}
+		}
+	}
+	var clustered, scattered []string
+	for i := 0; i < 10000; i++ {
+		clustered = append(clustered, keyN(40000+i))
+		scattered = append(scattered, keyN(i*10+i%2))
+	}
+	for _, keys := range []struct {
+		name string
+		keys []string
+	}{{"clustered", clustered}, {"scattered", scattered}} {
+		for _, opts := range []struct {
+			name string
+			opts []OpOption
+		}{{"unsorted", nil}, {"sorted", []OpOption{WithSortedKeys()}}} {
+			b.Run(keys.name+"/"+opts.name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := db.HasMulti(keys.keys, opts.opts...); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package bboltkv
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"time"
+)
+
+// ErrConflict is returned when an operation loses out to a competing one,
+// such as renewing a lease that another owner holds.
+var ErrConflict = errors.New("bboltkv: conflict")
+
+const leaseBucket = "leases"
+
+type lease struct {
+	Owner   string
+	Expires time.Time
+}
+
+// AcquireLease claims the lease named key for owner, for the duration ttl.
+// It returns true if the lease was free, had expired, or was already held by
+// owner (in which case it is extended), and false if another owner holds it.
+// Expiry is judged by the store's clock at the time of the call; nothing
+// happens in the background when a lease expires.
+//
+// Leases are kept apart from the store's entries, so a lease key does not
+// clash with an entry of the same name. They survive closing and reopening
+// the store.
+//
+//	if ok, err := store.AcquireLease("task:reindex", "worker-1", time.Minute); err != nil {
+//	    // an error occurred
+//	} else if ok {
+//	    // we own the task for the next minute
+//	}
+func (s *Store) AcquireLease(key string, owner string, ttl time.Duration) (bool, error) {
+	if err := s.plainKeys(); err != nil {
+		return false, err
+	}
+	if ttl <= 0 {
+		return false, ErrBadValue
+	}
+	acquired := false
+	err := s.update(func(w *wtx) error {
+		b, err := w.aux(leaseBucket)
+		if err != nil {
+			return err
+		}
+		now := s.now()
+		if l, err := decodeLease(b.Get([]byte(key))); err != nil {
+			return err
+		} else if l != nil && l.Owner != owner && now.Before(l.Expires) {
+			return nil
+		}
+		acquired = true
+		return putLease(b.Put, key, lease{Owner: owner, Expires: now.Add(ttl)})
+	})
+	return acquired, err
+}
+
+// RenewLease extends the lease named key, held by owner, to expire ttl from
+// now. It returns ErrConflict if another owner holds the lease, and
+// ErrNotFound if nobody does. A lease that has expired can still be renewed
+// by its owner, as long as no one else has acquired it in the meantime.
+func (s *Store) RenewLease(key, owner string, ttl time.Duration) error {
+	if err := s.plainKeys(); err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		return ErrBadValue
+	}
+	return s.update(func(w *wtx) error {
+		b, err := w.aux(leaseBucket)
+		if err != nil {
+			return err
+		}
+		if l, err := decodeLease(b.Get([]byte(key))); err != nil {
+			return err
+		} else if l == nil {
+			return ErrNotFound
+		} else if l.Owner != owner {
+			return ErrConflict
+		}
+		return putLease(b.Put, key, lease{Owner: owner, Expires: s.now().Add(ttl)})
+	})
+}
+
+// ReleaseLease gives up the lease named key, held by owner, so that others
+// can acquire it straight away. It returns ErrConflict if another owner holds
+// the lease, and ErrNotFound if nobody does.
+func (s *Store) ReleaseLease(key, owner string) error {
+	if err := s.plainKeys(); err != nil {
+		return err
+	}
+	return s.update(func(w *wtx) error {
+		b, err := w.aux(leaseBucket)
+		if err != nil {
+			return err
+		}
+		if l, err := decodeLease(b.Get([]byte(key))); err != nil {
+			return err
+		} else if l == nil {
+			return ErrNotFound
+		} else if l.Owner != owner {
+			return ErrConflict
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+func decodeLease(raw []byte) (*lease, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	var l lease
+	if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func putLease(put func(k, v []byte) error, key string, l lease) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(l); err != nil {
+		return err
+	}
+	return put([]byte(key), buf.Bytes())
+}
@@ -0,0 +1,122 @@
+package bboltkv
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/unknownnf/bboltkv/testutil"
+)
+
+func TestLease(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	db := openTestStore(t, WithClock(clock))
+
+	if ok, err := db.AcquireLease("task", "a", time.Minute); err != nil || !ok {
+		t.Fatalf("got %v, %v, expected acquisition", ok, err)
+	}
+	// held by someone else
+	if ok, err := db.AcquireLease("task", "b", time.Minute); err != nil || ok {
+		t.Fatalf("got %v, %v, expected refusal", ok, err)
+	}
+	// re-acquiring extends it
+	if ok, err := db.AcquireLease("task", "a", time.Minute); err != nil || !ok {
+		t.Fatalf("got %v, %v, expected acquisition", ok, err)
+	}
+	// leases do not clash with entries
+	if err := db.Get("task", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if err := db.RenewLease("task", "b", time.Minute); err != ErrConflict {
+		t.Fatalf("got %v, expected ErrConflict", err)
+	}
+	if err := db.RenewLease("other", "a", time.Minute); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	clock.Advance(50 * time.Second)
+	if err := db.RenewLease("task", "a", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// the renewal pushed expiry out
+	clock.Advance(50 * time.Second)
+	if ok, err := db.AcquireLease("task", "b", time.Minute); err != nil || ok {
+		t.Fatalf("got %v, %v, expected refusal", ok, err)
+	}
+	if err := db.ReleaseLease("task", "b"); err != ErrConflict {
+		t.Fatalf("got %v, expected ErrConflict", err)
+	}
+	if err := db.ReleaseLease("task", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ReleaseLease("task", "a"); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if ok, err := db.AcquireLease("task", "b", time.Minute); err != nil || !ok {
+		t.Fatalf("got %v, %v, expected acquisition", ok, err)
+	}
+	if _, err := db.AcquireLease("task", "b", 0); err != ErrBadValue {
+		t.Fatalf("got %v, expected ErrBadValue", err)
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	db := openTestStore(t, WithClock(clock))
+	if ok, err := db.AcquireLease("task", "a", time.Minute); err != nil || !ok {
+		t.Fatalf("got %v, %v, expected acquisition", ok, err)
+	}
+	clock.Advance(time.Minute)
+	if ok, err := db.AcquireLease("task", "b", time.Minute); err != nil || !ok {
+		t.Fatalf("got %v, %v, expected takeover", ok, err)
+	}
+	if err := db.RenewLease("task", "a", time.Minute); err != ErrConflict {
+		t.Fatalf("got %v, expected ErrConflict", err)
+	}
+}
+
+func TestLeaseContention(t *testing.T) {
+	db := openTestStore(t)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var winners []string
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(owner string) {
+			defer wg.Done()
+			ok, err := db.AcquireLease("task", owner, time.Hour)
+			if err != nil {
+				t.Error(err)
+			} else if ok {
+				mu.Lock()
+				winners = append(winners, owner)
+				mu.Unlock()
+			}
+		}(fmt.Sprintf("owner%d", i))
+	}
+	wg.Wait()
+	if len(winners) != 1 {
+		t.Fatalf("lease acquired by %v, expected exactly one owner", winners)
+	}
+}
+
+func TestLeasePersistence(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	db := openTestStore(t, WithClock(clock))
+	name := db.GetDb().Path()
+	if ok, err := db.AcquireLease("task", "a", time.Minute); err != nil || !ok {
+		t.Fatalf("got %v, %v, expected acquisition", ok, err)
+	}
+	db.Close()
+	db, err := Open(name, "test", WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if ok, err := db.AcquireLease("task", "b", time.Minute); err != nil || ok {
+		t.Fatalf("got %v, %v, expected refusal", ok, err)
+	}
+	if err := db.RenewLease("task", "a", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+}
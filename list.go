@@ -0,0 +1,258 @@
+package bboltkv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"go.etcd.io/bbolt"
+)
+
+// ErrNotList is returned by the list methods when the key holds a value
+// that was not built with Append.
+var ErrNotList = errors.New("bboltkv: value is not a list")
+
+var errList = errors.New("bboltkv: value is a list, use GetList or ListRange")
+
+const listBucket = "lists"
+
+// A list is stored as a header under its key in the store's bucket, holding
+// the list's length, and a bucket of elements inside the store's internal
+// "lists" bucket, keyed by their big-endian index. The header keeps the key
+// visible to the rest of the store; writing over or deleting it drops the
+// elements too.
+
+func listHeader(n uint64) []byte {
+	header := make([]byte, 9)
+	header[0] = tagList
+	binary.BigEndian.PutUint64(header[1:], n)
+	return header
+}
+
+// listLen returns the length of the list whose header is raw.
+func listLen(raw []byte) (uint64, error) {
+	if len(raw) != 9 || raw[0] != tagList {
+		return 0, ErrNotList
+	}
+	return binary.BigEndian.Uint64(raw[1:]), nil
+}
+
+// listName returns the name of a list's element bucket. bbolt does not allow
+// empty bucket names, but the empty string is a valid key.
+func listName(key string) []byte {
+	return append([]byte{0}, key...)
+}
+
+func listIndex(i uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, i)
+	return k
+}
+
+// dropList deletes the elements of the list stored under key, if there is
+// one, unless raw is a list header about to replace the current one.
+func (w *wtx) dropList(key string, raw []byte) error {
+	if len(raw) > 0 && raw[0] == tagList {
+		return nil
+	}
+	lists := w.s.aux(w.tx, listBucket)
+	if lists == nil || lists.Bucket(listName(key)) == nil {
+		return nil
+	}
+	return lists.DeleteBucket(listName(key))
+}
+
+// Append adds element to the end of the list stored under key, creating the
+// list if the key is not present, and returns the list's new length. Each
+// element is encoded and validated as with Put, and stored separately, so
+// appending does not get slower as the list grows. If the key holds a value
+// that is not a list, Append returns ErrNotList.
+//
+// A list is read back with GetList, ListRange and ListLen; Get returns an
+// error for it. Putting another value under the key, or deleting it, drops
+// the whole list.
+//
+//	n, err := store.Append("events:42", Event{Kind: "login"})
+func (s *Store) Append(key string, element interface{}) (int, error) {
+	if err := s.plainKeys(); err != nil {
+		return 0, err
+	}
+	raw, err := s.encodeForPut(key, element)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	err = s.update(func(w *wtx) error {
+		if header := w.get(key); header != nil {
+			if n, err = listLen(header); err != nil {
+				return err
+			}
+		}
+		lists, err := w.aux(listBucket)
+		if err != nil {
+			return err
+		}
+		elements, err := lists.CreateBucketIfNotExists(listName(key))
+		if err != nil {
+			return err
+		}
+		if err := elements.Put(listIndex(n), raw); err != nil {
+			return err
+		}
+		n++
+		return w.put(key, listHeader(n))
+	})
+	return int(n), err
+}
+
+// ListLen returns the length of the list stored under key. It returns
+// ErrNotFound if the key is not present, and ErrNotList if it holds a value
+// that is not a list.
+func (s *Store) ListLen(key string) (int, error) {
+	if err := s.plainKeys(); err != nil {
+		return 0, err
+	}
+	var n uint64
+	err := s.view(func(tx *bbolt.Tx) error {
+		header := tx.Bucket(s.bucketName).Get([]byte(key))
+		if header == nil || s.expired(tx, key) {
+			return ErrNotFound
+		}
+		var err error
+		n, err = listLen(header)
+		return err
+	})
+	return int(n), err
+}
+
+// ListRange calls fn for the elements of the list stored under key with
+// indexes from "from" up to, but not including, "to", in order. Both bounds
+// are clamped to the length of the list. fn receives a function that decodes
+// the element into a pointer-typed value; returning an error from fn stops
+// the iteration and returns that error. It returns ErrNotFound if the key is
+// not present, and ErrNotList if it holds a value that is not a list.
+//
+//	err := store.ListRange("events:42", 0, 10, func(decode func(interface{}) error) error {
+//	    var e Event
+//	    if err := decode(&e); err != nil {
+//	        return err
+//	    }
+//	    fmt.Println(e.Kind)
+//	    return nil
+//	})
+func (s *Store) ListRange(key string, from, to int, fn func(decode func(interface{}) error) error) error {
+	if err := s.plainKeys(); err != nil {
+		return err
+	}
+	return s.view(func(tx *bbolt.Tx) error {
+		header := tx.Bucket(s.bucketName).Get([]byte(key))
+		if header == nil || s.expired(tx, key) {
+			return ErrNotFound
+		}
+		n, err := listLen(header)
+		if err != nil {
+			return err
+		}
+		first, end := clampRange(from, to, n)
+		if first == end {
+			return nil
+		}
+		elements := s.aux(tx, listBucket).Bucket(listName(key))
+		c := elements.Cursor()
+		i := first
+		for k, v := c.Seek(listIndex(first)); k != nil && i < end; k, v = c.Next() {
+			raw := v
+			if err := fn(func(value interface{}) error { return s.decode(raw, value) }); err != nil {
+				return err
+			}
+			i++
+		}
+		return nil
+	})
+}
+
+func clampRange(from, to int, n uint64) (uint64, uint64) {
+	clamp := func(i int) uint64 {
+		if i < 0 {
+			return 0
+		} else if uint64(i) > n {
+			return n
+		}
+		return uint64(i)
+	}
+	first, end := clamp(from), clamp(to)
+	if first > end {
+		first = end
+	}
+	return first, end
+}
+
+// GetList decodes all elements of the list stored under key into out, which
+// must be a pointer to a slice of the element type. It returns ErrNotFound
+// if the key is not present, and ErrNotList if it holds a value that is not
+// a list.
+//
+//	var events []Event
+//	err := store.GetList("events:42", &events)
+func (s *Store) GetList(key string, out interface{}) error {
+	if err := s.plainKeys(); err != nil {
+		return err
+	}
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("bboltkv: GetList needs a pointer to a slice, not %T", out)
+	}
+	slice := v.Elem()
+	elemType := slice.Type().Elem()
+	result := reflect.MakeSlice(slice.Type(), 0, 0)
+	err := s.ListRange(key, 0, int(^uint(0)>>1), func(decode func(interface{}) error) error {
+		elem := reflect.New(elemType)
+		if err := decode(elem.Interface()); err != nil {
+			return err
+		}
+		result = reflect.Append(result, elem.Elem())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	slice.Set(result)
+	return nil
+}
+
+// checkLists is the Fsck check of the lists bucket.
+func checkLists(f *fsck) {
+	lists := f.s.aux(f.tx, listBucket)
+	if lists != nil {
+		c := lists.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if v != nil || len(k) == 0 {
+				continue
+			}
+			if _, err := listLen(f.b.Get(k[1:])); err != nil {
+				name := append([]byte(nil), k...)
+				f.report(FsckOrphanMetadata, listBucket, k[1:], "elements of a key without a list", func(w *wtx) error {
+					if lists := w.s.aux(w.tx, listBucket); lists != nil && lists.Bucket(name) != nil {
+						return lists.DeleteBucket(name)
+					}
+					return nil
+				})
+			}
+		}
+	}
+	c := f.b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		n, err := listLen(v)
+		if err != nil || n == 0 {
+			continue
+		}
+		var elements *bbolt.Bucket
+		if lists != nil {
+			elements = lists.Bucket(listName(string(k)))
+		}
+		if elements == nil || elements.Get(listIndex(n-1)) == nil {
+			f.report(FsckMissingMetadata, listBucket, k, fmt.Sprintf("elements of a list of %d are missing", n), nil)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package bboltkv
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestAppendConcurrent(t *testing.T) {
+	db := openTestStore(t)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if _, err := db.Append("list", w*1000+i); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if n, err := db.ListLen("list"); err != nil {
+		t.Fatal(err)
+	} else if n != 400 {
+		t.Fatalf("got length %d, expected 400", n)
+	}
+	var got []int
+	if err := db.GetList("list", &got); err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[int]bool)
+	last := make(map[int]int)
+	for _, v := range got {
+		if seen[v] {
+			t.Fatalf("element %d appended twice", v)
+		}
+		seen[v] = true
+		// each appender's elements keep their order
+		if w := v / 1000; v%1000 < last[w] {
+			t.Fatalf("element %d out of order", v)
+		} else {
+			last[w] = v % 1000
+		}
+	}
+	if len(seen) != 400 {
+		t.Fatalf("got %d distinct elements, expected 400", len(seen))
+	}
+}
+
+func TestListRange(t *testing.T) {
+	db := openTestStore(t)
+	for i := 0; i < 10; i++ {
+		if n, err := db.Append("list", i); err != nil {
+			t.Fatal(err)
+		} else if n != i+1 {
+			t.Fatalf("got length %d, expected %d", n, i+1)
+		}
+	}
+	collect := func(from, to int) []int {
+		var out []int
+		err := db.ListRange("list", from, to, func(decode func(interface{}) error) error {
+			var v int
+			if err := decode(&v); err != nil {
+				return err
+			}
+			out = append(out, v)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	for _, c := range []struct{ from, to, first, n int }{
+		{0, 10, 0, 10},
+		{3, 6, 3, 3},
+		{-5, 2, 0, 2},
+		{8, 100, 8, 2},
+		{6, 3, 0, 0},
+		{10, 20, 0, 0},
+	} {
+		got := collect(c.from, c.to)
+		if len(got) != c.n {
+			t.Fatalf("range %d..%d: got %v", c.from, c.to, got)
+		}
+		for i, v := range got {
+			if v != c.first+i {
+				t.Fatalf("range %d..%d: got %v", c.from, c.to, got)
+			}
+		}
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err := db.ListRange("list", 0, 10, func(decode func(interface{}) error) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Fatalf("got %v after %d calls, expected stop after 1", err, calls)
+	}
+
+	if err := db.ListRange("missing", 0, 10, nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+}
+
+func TestListNotList(t *testing.T) {
+	db := openTestStore(t)
+	if err := db.Put("plain", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Append("plain", "element"); err != ErrNotList {
+		t.Fatalf("append returned %v, expected ErrNotList", err)
+	}
+	if _, err := db.ListLen("plain"); err != ErrNotList {
+		t.Fatalf("ListLen returned %v, expected ErrNotList", err)
+	}
+	var list []string
+	if err := db.GetList("plain", &list); err != ErrNotList {
+		t.Fatalf("GetList returned %v, expected ErrNotList", err)
+	}
+	var val string
+	if err := db.Get("plain", &val); err != nil || val != "value" {
+		t.Fatalf("got %q, %v, expected the value to be untouched", val, err)
+	}
+
+	if _, err := db.Append("list", "element"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get("list", &val); err == nil {
+		t.Fatal("Get on a list succeeded")
+	}
+	if err := db.GetList("list", list); err == nil {
+		t.Fatal("GetList accepted a non-pointer")
+	}
+}
+
+func TestListOverwrite(t *testing.T) {
+	db := openTestStore(t)
+	for i := 0; i < 3; i++ {
+		if _, err := db.Append("list", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Put("list", "plain"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("list"); err != nil {
+		t.Fatal(err)
+	}
+	// the old elements are gone, so the list starts over
+	if n, err := db.Append("list", 10); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("got length %d, expected 1", n)
+	}
+	if err := db.Delete("list"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ListLen("list"); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	var got []int
+	if _, err := db.Append("list", 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.GetList("list", &got); err != nil {
+		t.Fatal(err)
+	} else if len(got) != 1 || got[0] != 20 {
+		t.Fatalf("got %v, expected [20]", got)
+	}
+}
@@ -0,0 +1,161 @@
+package bboltkv
+
+import (
+	"encoding/binary"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// timestampBucket maps keys written with PutIfNewer, or any way with
+// WithModTimes, to the UnixNano time of their write, as 8 bytes big-endian.
+const timestampBucket = "timestamps"
+
+// WithLastWriteWins makes ImportJSON and Merge follow the same rule as
+// PutIfNewer: an entry only replaces one with the same key if its
+// timestamp is not older. Entries without a timestamp count as older than
+// any entry with one, and only replace entries without one, or add new
+// keys.
+func WithLastWriteWins() OpOption {
+	return func(o *opOptions) {
+		o.lastWriteWins = true
+	}
+}
+
+// timestamp returns the time the value under key was written with
+// PutIfNewer, if it was.
+func (s *Store) timestamp(tx *bbolt.Tx, key []byte) (time.Time, bool) {
+	b := s.aux(tx, timestampBucket)
+	if b == nil {
+		return time.Time{}, false
+	}
+	v := b.Get(key)
+	if v == nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(v))), true
+}
+
+func (w *wtx) dropTimestamp(key string) error {
+	b := w.s.aux(w.tx, timestampBucket)
+	if b == nil {
+		return nil
+	}
+	if index := w.s.aux(w.tx, timestampIndexBucket); index != nil {
+		if ts := b.Get([]byte(key)); ts != nil {
+			if err := index.Delete(expiryIndexKey(ts, key)); err != nil {
+				return err
+			}
+		}
+	}
+	return b.Delete([]byte(key))
+}
+
+func (w *wtx) setTimestamp(key string, ts time.Time) error {
+	if err := w.dropTimestamp(key); err != nil {
+		return err
+	}
+	b, err := w.aux(timestampBucket)
+	if err != nil {
+		return err
+	}
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(ts.UnixNano()))
+	if w.s.opts.modTimes || w.s.aux(w.tx, timestampIndexBucket) != nil {
+		index, err := w.aux(timestampIndexBucket)
+		if err != nil {
+			return err
+		}
+		if err := index.Put(expiryIndexKey(v, key), nil); err != nil {
+			return err
+		}
+	}
+	return b.Put([]byte(key), v)
+}
+
+// newer reports whether a write with the given timestamp wins over the
+// entry currently stored under key, under the rules of PutIfNewer and
+// WithLastWriteWins; ts is nil for writes without a timestamp.
+func (w *wtx) newer(key string, ts *time.Time) bool {
+	if w.get(key) == nil {
+		return true
+	}
+	stored, ok := w.s.timestamp(w.tx, []byte(key))
+	if !ok {
+		return true
+	}
+	return ts != nil && !ts.Before(stored)
+}
+
+// PutIfNewer puts an entry into the store like Put, together with the time
+// ts it was written at, but only if the entry it replaces was not written
+// at a later time. It reports whether the value was stored. Writes with the
+// same timestamp as the stored entry are applied, so the last of them to
+// arrive wins; writes to keys that are not present, or that were stored
+// without a timestamp, always are.
+//
+// Timestamps are kept with the entry until it is next written, by Put or
+// any other method, or deleted. So a stale write after a Delete is applied,
+// as there is nothing left to compare it with.
+//
+//	applied, err := store.PutIfNewer("user:42", user, event.Time)
+//	if err == nil && !applied {
+//	    log.Printf("dropped stale update for user:42")
+//	}
+func (s *Store) PutIfNewer(key string, value interface{}, ts time.Time) (applied bool, err error) {
+	if err := s.plainKeys(); err != nil {
+		return false, err
+	}
+	raw, err := s.encodeForPut(key, value)
+	if err != nil {
+		return false, err
+	}
+	err = s.update(func(w *wtx) error {
+		if !w.newer(key, &ts) {
+			return nil
+		}
+		applied = true
+		if err := w.put(key, raw); err != nil {
+			return err
+		}
+		return w.setTimestamp(key, ts)
+	})
+	return applied, err
+}
+
+// Timestamp returns the time the entry with the given key was written at by
+// PutIfNewer, or the zero time if it was written some other way; with
+// WithModTimes, the time of any write. If no such key is present in the
+// store, it returns ErrNotFound.
+func (s *Store) Timestamp(key string) (time.Time, error) {
+	if err := s.plainKeys(); err != nil {
+		return time.Time{}, err
+	}
+	var ts time.Time
+	err := s.view(func(tx *bbolt.Tx) error {
+		if tx.Bucket(s.bucketName).Get([]byte(key)) == nil || s.expired(tx, key) {
+			return ErrNotFound
+		}
+		ts, _ = s.timestamp(tx, []byte(key))
+		return nil
+	})
+	return ts, err
+}
+
+// checkTimestamps is the Fsck check of the timestamp buckets.
+func checkTimestamps(f *fsck) {
+	f.checkTimeIndex(timestampBucket, timestampIndexBucket, true)
+	b := f.s.aux(f.tx, timestampBucket)
+	if b == nil {
+		return
+	}
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		if !f.present(k) {
+			key := string(k)
+			f.report(FsckOrphanMetadata, timestampBucket, k, "timestamp of an absent key", func(w *wtx) error {
+				return w.dropTimestamp(key)
+			})
+		}
+	}
+}
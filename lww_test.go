@@ -0,0 +1,150 @@
+package bboltkv
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPutIfNewer(t *testing.T) {
+	db := openTestStore(t)
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, w := range []struct {
+		value   string
+		ts      time.Time
+		applied bool
+	}{
+		{"b", t0.Add(2 * time.Second), true}, // missing key
+		{"a", t0.Add(time.Second), false},    // delayed retry
+		{"c", t0.Add(3 * time.Second), true},
+		{"d", t0.Add(3 * time.Second), true}, // tie
+		{"x", t0, false},
+	} {
+		applied, err := db.PutIfNewer("key", w.value, w.ts)
+		if err != nil {
+			t.Fatal(err)
+		} else if applied != w.applied {
+			t.Fatalf("%s: applied %v, expected %v", w.value, applied, w.applied)
+		}
+	}
+	var got string
+	if err := db.Get("key", &got); err != nil || got != "d" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if ts, err := db.Timestamp("key"); err != nil || !ts.Equal(t0.Add(3*time.Second)) {
+		t.Fatalf("got %v, %v", ts, err)
+	}
+
+	// a plain write drops the timestamp
+	if err := db.Put("key", "e"); err != nil {
+		t.Fatal(err)
+	}
+	if ts, err := db.Timestamp("key"); err != nil || !ts.IsZero() {
+		t.Fatalf("got %v, %v", ts, err)
+	}
+	if applied, err := db.PutIfNewer("key", "f", t0); err != nil || !applied {
+		t.Fatalf("got %v, %v", applied, err)
+	}
+	if _, err := db.Timestamp("missing"); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+}
+
+func TestPutIfNewerReopen(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(name, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Unix(1700000000, 123456789)
+	if _, err := db.PutIfNewer("key", 1, t0); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = Open(name, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if ts, err := db.Timestamp("key"); err != nil || !ts.Equal(t0) {
+		t.Fatalf("got %v, %v", ts, err)
+	}
+	if applied, err := db.PutIfNewer("key", 2, t0.Add(-1)); err != nil || applied {
+		t.Fatalf("got %v, %v", applied, err)
+	}
+}
+
+func TestMergeLastWriteWins(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	src := openTestStore(t)
+	dst := openTestStore(t)
+	put := func(db *Store, key, value string, ts time.Time) {
+		t.Helper()
+		var err error
+		if ts.IsZero() {
+			err = db.Put(key, value)
+		} else {
+			_, err = db.PutIfNewer(key, value, ts)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(src, "newer", "src", t0.Add(time.Second))
+	put(dst, "newer", "dst", t0)
+	put(src, "older", "src", t0)
+	put(dst, "older", "dst", t0.Add(time.Second))
+	put(src, "tie", "src", t0)
+	put(dst, "tie", "dst", t0)
+	put(src, "untimed", "src", time.Time{})
+	put(dst, "untimed", "dst", t0)
+	put(src, "timed", "src", t0)
+	put(dst, "timed", "dst", time.Time{})
+	put(src, "new", "src", t0)
+	want := map[string]string{
+		"newer": "src", "older": "dst", "tie": "src", "untimed": "dst", "timed": "src", "new": "src",
+	}
+
+	check := func(db *Store, n int, err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		} else if n != 4 {
+			t.Fatalf("wrote %d entries, expected 4", n)
+		}
+		for key, value := range want {
+			var got string
+			if err := db.Get(key, &got); err != nil || got != value {
+				t.Fatalf("%s: got %q, %v, expected %q", key, got, err, value)
+			}
+		}
+		if ts, err := db.Timestamp("newer"); err != nil || !ts.Equal(t0.Add(time.Second)) {
+			t.Fatalf("timestamp was not copied: %v, %v", ts, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := src.ExportJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	export := buf.String()
+	n, err := dst.Merge(src, WithLastWriteWins())
+	check(dst, n, err)
+
+	dst = openTestStore(t)
+	put(dst, "newer", "dst", t0)
+	put(dst, "older", "dst", t0.Add(time.Second))
+	put(dst, "tie", "dst", t0)
+	put(dst, "untimed", "dst", t0)
+	put(dst, "timed", "dst", time.Time{})
+	n, err = dst.ImportJSON(strings.NewReader(export), WithLastWriteWins())
+	check(dst, n, err)
+
+	// without the policy, everything is copied
+	if n, err := dst.Merge(src); err != nil || n != 6 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,77 @@
+package bboltkv
+
+import (
+	"go.etcd.io/bbolt"
+)
+
+// Merge copies all entries of src into the store, replacing entries with the
+// same keys unless WithSkipExisting is given, and returns the number of
+// entries copied. src may be the same file opened with another bucket name,
+// see OpenShared, but not the store itself.
+//
+// Entries are read and written in batches, each in transactions of their
+// own, so Merge does not hold up other writers for long, but it does not
+// copy a consistent snapshot of src if src is written to meanwhile. As with
+// ExportJSON, only keys, values and PutIfNewer timestamps are copied, and
+// keys that src leaves out of Keys are skipped. Values are validated like
+// those of PutEncoded. With WithLastWriteWins, entries of src older than
+// those they would replace are skipped, and not counted.
+func (s *Store) Merge(src *Store, opts ...OpOption) (int, error) {
+	if src == s {
+		return 0, ErrBadValue
+	}
+	o := buildOpOptions(opts)
+	total := -1
+	err := src.view(func(tx *bbolt.Tx) error {
+		total = tx.Bucket(src.bucketName).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	p := s.newProgress(o, total)
+	written := 0
+	var from []byte
+	for {
+		var batch []exportEntry
+		err := src.view(func(tx *bbolt.Tx) error {
+			c := tx.Bucket(src.bucketName).Cursor()
+			k, v := c.Seek(from)
+			if from != nil && k != nil && string(k) == string(from) {
+				k, v = c.Next()
+			}
+			for ; k != nil && len(batch) < p.batch.size; k, v = c.Next() {
+				from = append(from[:0], k...)
+				if src.hidden(tx, k) {
+					continue
+				}
+				key, err := src.openKey(k)
+				if err != nil {
+					return err
+				}
+				e := exportEntry{Key: key, Value: append([]byte(nil), v...)}
+				if ts, ok := src.timestamp(tx, k); ok {
+					e.Time = &ts
+				}
+				batch = append(batch, e)
+			}
+			return nil
+		})
+		if err != nil {
+			return written, err
+		}
+		if len(batch) == 0 {
+			return written, p.done()
+		}
+		for _, e := range batch {
+			if err := s.validateEncoded(e.Key, e.Value); err != nil {
+				return written, err
+			}
+		}
+		n, err := s.writeBatch(batch, p, o)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package bboltkv
+
+import (
+	"context"
+	"encoding/binary"
+	"sort"
+)
+
+type opMetaKey struct{}
+
+// WithOpMeta returns a copy of ctx carrying meta, metadata about the writes
+// made with it, such as the request or the user they are made for. The
+// context methods writing entries, PutContext, PutAllContext and
+// DeleteContext, hand it to the hooks of WithPutHook and WithDeleteHook,
+// and with WithChangeLogMeta, record it in the change log. meta is copied,
+// so changing it afterwards does not change the context.
+//
+//	ctx = bboltkv.WithOpMeta(ctx, map[string]string{"request": reqID, "user": user})
+//	err := store.PutContext(ctx, "order:17", order)
+func WithOpMeta(ctx context.Context, meta map[string]string) context.Context {
+	m := make(map[string]string, len(meta))
+	for k, v := range meta {
+		m[k] = v
+	}
+	return context.WithValue(ctx, opMetaKey{}, m)
+}
+
+// OpMeta returns the metadata WithOpMeta attached to ctx, or nil if there is
+// none. The map must not be changed.
+func OpMeta(ctx context.Context) map[string]string {
+	m, _ := ctx.Value(opMetaKey{}).(map[string]string)
+	return m
+}
+
+// WithPutHook makes the store call fn for every entry written, once the
+// write has committed, with the key, the metadata attached to the context
+// of the write with WithOpMeta, and the encoded value as stored. Writes by
+// methods without a context, or with a context without metadata, pass nil
+// meta. fn is called on the goroutine that made the write, which waits for
+// it, in the order the entries were written; it must not change meta or
+// encoded. With WithWriteBuffer, the writes buffered by Put are hooked
+// when they are flushed, without their metadata.
+//
+//	bboltkv.WithPutHook(func(key string, meta map[string]string, encoded []byte) {
+//	    audit.Printf("%s wrote %q", meta["user"], key)
+//	})
+func WithPutHook(fn func(key string, meta map[string]string, encoded []byte)) Option {
+	return func(o *options) {
+		o.putHook = fn
+	}
+}
+
+// WithDeleteHook makes the store call fn for every entry deleted, once the
+// deletion has committed, with the key and the metadata attached to the
+// context of the deletion, like WithPutHook. Deletions by expiry sweeps
+// pass nil meta.
+func WithDeleteHook(fn func(key string, meta map[string]string)) Option {
+	return func(o *options) {
+		o.deleteHook = fn
+	}
+}
+
+// WithChangeLogMeta makes the change log, see WithChangeLog, record the
+// metadata attached to the context of each change with WithOpMeta, for
+// Changes to report. Changes without metadata are logged as before; older
+// versions of the package cannot read the log entries of those with it.
+func WithChangeLogMeta() Option {
+	return func(o *options) {
+		o.changeLogMeta = true
+	}
+}
+
+// withMeta returns fn, attaching the metadata of ctx to the writes it
+// makes.
+func (s *Store) withMeta(ctx context.Context, fn func(w *wtx) error) func(w *wtx) error {
+	meta := OpMeta(ctx)
+	if meta == nil {
+		return fn
+	}
+	return func(w *wtx) error {
+		w.meta = meta
+		return fn(w)
+	}
+}
+
+// hookedWrite is a write for the hooks of WithPutHook and WithDeleteHook.
+type hookedWrite struct {
+	key  string
+	meta map[string]string
+	raw  []byte // nil for deletions
+}
+
+// hook arranges for the write of raw under key, or its deletion if raw is
+// nil, to be handed to the hooks once w commits.
+func (w *wtx) hook(key string, raw []byte) {
+	s := w.s
+	if raw == nil && s.opts.deleteHook == nil || raw != nil && s.opts.putHook == nil {
+		return
+	}
+	if raw != nil {
+		raw = append([]byte(nil), raw...)
+	}
+	if w.hooked == nil {
+		w.tx.OnCommit(func() { s.runHooks(w.hooked) })
+	}
+	w.hooked = append(w.hooked, hookedWrite{key, w.meta, raw})
+}
+
+func (s *Store) runHooks(writes []hookedWrite) {
+	for _, h := range writes {
+		key, err := s.openKey([]byte(h.key))
+		if err != nil {
+			key = h.key
+		}
+		if h.raw == nil {
+			s.opts.deleteHook(key, h.meta)
+		} else {
+			s.opts.putHook(key, h.meta, h.raw)
+		}
+	}
+}
+
+// encodeMeta encodes meta for the change log: the number of pairs, then
+// each key and value, sorted by key, all prefixed with their lengths as
+// uvarints.
+func encodeMeta(buf []byte, meta map[string]string) []byte {
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	buf = appendUvarint(buf, uint64(len(keys)))
+	for _, k := range keys {
+		buf = appendUvarint(buf, uint64(len(k)))
+		buf = append(buf, k...)
+		buf = appendUvarint(buf, uint64(len(meta[k])))
+		buf = append(buf, meta[k]...)
+	}
+	return buf
+}
+
+// decodeMeta decodes metadata encoded by encodeMeta at the start of v, and
+// returns the rest of v.
+func decodeMeta(v []byte) (map[string]string, []byte, error) {
+	n, l := binary.Uvarint(v)
+	if l <= 0 || n > uint64(len(v)) {
+		return nil, nil, ErrCorrupt
+	}
+	v = v[l:]
+	meta := make(map[string]string, n)
+	for i := uint64(0); i < n; i++ {
+		var pair [2]string
+		for j := range pair {
+			m, l := binary.Uvarint(v)
+			if l <= 0 || uint64(len(v)-l) < m {
+				return nil, nil, ErrCorrupt
+			}
+			pair[j] = string(v[l : l+int(m)])
+			v = v[l+int(m):]
+		}
+		meta[pair[0]] = pair[1]
+	}
+	return meta, v, nil
+}
@@ -0,0 +1,169 @@
+package bboltkv
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/unknownnf/bboltkv/testutil"
+)
+
+// hookLog returns options recording the hooks' calls into the returned
+// slice, one "put key meta" or "delete key meta" line each.
+func hookLog() (*[]string, []Option) {
+	var calls []string
+	return &calls, []Option{
+		WithPutHook(func(key string, meta map[string]string, encoded []byte) {
+			if len(encoded) == 0 {
+				panic("no value")
+			}
+			calls = append(calls, fmt.Sprintf("put %s %v", key, meta))
+		}),
+		WithDeleteHook(func(key string, meta map[string]string) {
+			calls = append(calls, fmt.Sprintf("delete %s %v", key, meta))
+		}),
+	}
+}
+
+func checkCalls(t *testing.T, calls *[]string, want ...string) {
+	t.Helper()
+	if !reflect.DeepEqual(*calls, want) {
+		t.Fatalf("got calls %q, expected %q", *calls, want)
+	}
+	*calls = nil
+}
+
+func TestOpMetaHooks(t *testing.T) {
+	calls, opts := hookLog()
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, append(opts, WithClock(clock))...)
+	meta := map[string]string{"user": "ann"}
+	ctx := WithOpMeta(context.Background(), meta)
+	meta["user"] = "changed"
+	if got := OpMeta(ctx); !reflect.DeepEqual(got, map[string]string{"user": "ann"}) {
+		t.Fatalf("got %v", got)
+	}
+
+	if err := db.PutContext(ctx, "a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutAllContext(ctx, map[string]interface{}{"b": 2, "c": 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteContext(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	checkCalls(t, calls, "put a map[user:ann]", "put b map[user:ann]", "put c map[user:ann]", "delete b map[user:ann]")
+
+	// without a context, or metadata in it
+	if err := db.Put("a", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutContext(context.Background(), "b", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithTTL("d", 1, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	if _, err := db.SweepExpired(); err != nil {
+		t.Fatal(err)
+	}
+	checkCalls(t, calls, "put a map[]", "put b map[]", "delete c map[]", "put d map[]", "delete d map[]")
+
+	// failed writes are not hooked
+	if err := db.DeleteContext(ctx, "missing"); err != ErrNotFound {
+		t.Fatalf("got %v", err)
+	}
+	checkCalls(t, calls)
+	if OpMeta(context.Background()) != nil {
+		t.Fatal("metadata without WithOpMeta")
+	}
+}
+
+func TestOpMetaFairWrites(t *testing.T) {
+	calls, opts := hookLog()
+	db := openTestStore(t, append(opts, WithFairWrites(time.Millisecond))...)
+	ctx := WithOpMeta(context.Background(), map[string]string{"request": "1"})
+	if err := db.PutContext(ctx, "a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("b", 1); err != nil {
+		t.Fatal(err)
+	}
+	checkCalls(t, calls, "put a map[request:1]", "put b map[]")
+}
+
+func TestOpMetaChangeLog(t *testing.T) {
+	db := openTestStore(t, WithChangeLog(), WithChangeLogMeta())
+	ctx := WithOpMeta(context.Background(), map[string]string{"user": "ann", "request": "r1"})
+	if err := db.PutContext(ctx, "a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("b", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteContext(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteContext(WithOpMeta(context.Background(), nil), "b"); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	err := db.Changes(0, func(c Change) error {
+		got = append(got, fmt.Sprintf("%s %v %d %v", c.Key, c.Deleted, len(c.Value), c.Meta))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := db.Encode(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		fmt.Sprintf("a false %d map[request:r1 user:ann]", len(v)),
+		fmt.Sprintf("b false %d map[]", len(v)),
+		"a true 0 map[request:r1 user:ann]",
+		"b true 0 map[]",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, expected %q", got, want)
+	}
+
+	// without the option, the metadata is not logged
+	db = openTestStore(t, WithChangeLog())
+	if err := db.PutContext(ctx, "a", 1); err != nil {
+		t.Fatal(err)
+	}
+	err = db.Changes(0, func(c Change) error {
+		if c.Meta != nil {
+			t.Errorf("logged %v", c.Meta)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpMetaNoAllocs(t *testing.T) {
+	db := openTestStore(t)
+	ctx := context.Background()
+	fn := func(w *wtx) error { return nil }
+	if n := testing.AllocsPerRun(100, func() {
+		db.withMeta(ctx, fn)
+	}); n != 0 {
+		t.Fatalf("%v allocations without metadata", n)
+	}
+	if n := testing.AllocsPerRun(100, func() {
+		OpMeta(ctx)
+	}); n != 0 {
+		t.Fatalf("%v allocations without metadata", n)
+	}
+}
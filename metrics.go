@@ -0,0 +1,254 @@
+package bboltkv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// WithMetricLabels makes WriteMetrics add labels to every sample it writes,
+// such as the name of the store or of the instance, so that the metrics of
+// several stores can be told apart once scraped. Label names must be valid
+// Prometheus label names not starting with "__"; WriteMetrics fails if one
+// is not. Values are escaped as the text format requires.
+//
+//	bboltkv.WithMetricLabels(map[string]string{"store": "sessions"})
+func WithMetricLabels(labels map[string]string) Option {
+	return func(o *options) {
+		o.metricLabels = make(map[string]string, len(labels))
+		for k, v := range labels {
+			o.metricLabels[k] = v
+		}
+	}
+}
+
+// WriteMetrics writes the store's metrics to w in the Prometheus text
+// exposition format, version 0.0.4, so that a scraper can read them
+// without the store depending on a Prometheus client:
+//
+//   - bboltkv_keys, the number of entries as reported by Count, and
+//     bboltkv_file_size_bytes and bboltkv_free_pages, of the database file,
+//     computed on demand;
+//   - bboltkv_operations_total, the reads and writes run since the store was
+//     opened, and bbolt's own counters of read transactions and of the pages
+//     written, with the time spent writing them, always;
+//   - with WithReadCache, bboltkv_cache_entries and the hits and misses of
+//     the cache;
+//   - with WithFairWrites, the length of the write queue and the batches and
+//     writes committed from it;
+//   - with WithOpStats, the reads, writes, deletes and bytes of each key
+//     prefix, labelled prefix, or overflow="true" for OpStatsOther;
+//   - with WithTxStats, a bboltkv_tx_duration_seconds summary of the write
+//     transactions of each operation, labelled op, whose quantile 1 is the
+//     slowest transaction, and the time their commits and page writes took.
+//
+// Metric names are stable; counters end in _total and durations are in
+// seconds. The labels given with WithMetricLabels precede those of each
+// sample. Counting the keys reads the store's bucket, so the cost of
+// WriteMetrics grows with the number of keys, like that of Count. Nothing
+// is written to w if the metrics cannot be gathered.
+//
+//	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+//	    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+//	    if err := store.WriteMetrics(w); err != nil {
+//	        http.Error(w, err.Error(), http.StatusInternalServerError)
+//	    }
+//	})
+func (s *Store) WriteMetrics(w io.Writer) error {
+	m, err := s.newMetricWriter()
+	if err != nil {
+		return err
+	}
+	keys, err := s.Count()
+	if err != nil {
+		return err
+	}
+	var fileSize int64
+	var free int
+	var db bbolt.Stats
+	err = s.view(func(tx *bbolt.Tx) error {
+		fileSize = tx.Size()
+		db = tx.DB().Stats()
+		free = db.FreePageN
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.family("bboltkv_keys", "gauge", "Entries in the store, leaving out expired ones.")
+	m.sample("bboltkv_keys", nil, float64(keys))
+	m.family("bboltkv_file_size_bytes", "gauge", "Size of the database file.")
+	m.sample("bboltkv_file_size_bytes", nil, float64(fileSize))
+	m.family("bboltkv_free_pages", "gauge", "Pages of the database file free for reuse.")
+	m.sample("bboltkv_free_pages", nil, float64(free))
+	m.family("bboltkv_operations_total", "counter", "Reads and writes run since the store was opened.")
+	m.sample("bboltkv_operations_total", nil, float64(s.gate.ops()))
+	m.family("bboltkv_read_transactions_total", "counter", "Read transactions started on the database file.")
+	m.sample("bboltkv_read_transactions_total", nil, float64(db.TxN))
+	m.family("bboltkv_page_writes_total", "counter", "Pages written to the database file.")
+	m.sample("bboltkv_page_writes_total", nil, float64(db.TxStats.Write))
+	m.family("bboltkv_page_write_seconds_total", "counter", "Time spent writing pages, including the fsync.")
+	m.sample("bboltkv_page_write_seconds_total", nil, db.TxStats.WriteTime.Seconds())
+
+	if s.cache != nil {
+		c := s.CacheStats()
+		m.family("bboltkv_cache_entries", "gauge", "Entries in the read cache.")
+		m.sample("bboltkv_cache_entries", nil, float64(c.Entries))
+		m.family("bboltkv_cache_hits_total", "counter", "Gets answered from the read cache.")
+		m.sample("bboltkv_cache_hits_total", nil, float64(c.Hits))
+		m.family("bboltkv_cache_misses_total", "counter", "Gets that had to read the database.")
+		m.sample("bboltkv_cache_misses_total", nil, float64(c.Misses))
+	}
+	if s.fair != nil {
+		q := s.WriteQueueStats()
+		m.family("bboltkv_write_queue_length", "gauge", "Writes waiting in the write queue or being committed.")
+		m.sample("bboltkv_write_queue_length", nil, float64(q.Queued))
+		m.family("bboltkv_write_queue_batches_total", "counter", "Transactions committed for queued writes.")
+		m.sample("bboltkv_write_queue_batches_total", nil, float64(q.Batches))
+		m.family("bboltkv_write_queue_writes_total", "counter", "Queued writes committed.")
+		m.sample("bboltkv_write_queue_writes_total", nil, float64(q.Writes))
+	}
+	if s.opStats != nil {
+		m.opStats(s.OpStats())
+	}
+	if s.txStats != nil {
+		m.txStats(s.TxStatsSnapshot())
+	}
+	_, err = w.Write(m.buf.Bytes())
+	return err
+}
+
+// metricWriter renders metrics in the text exposition format.
+type metricWriter struct {
+	buf    bytes.Buffer
+	labels []string // the rendered labels of WithMetricLabels
+}
+
+func (s *Store) newMetricWriter() (*metricWriter, error) {
+	m := &metricWriter{}
+	for name, value := range s.opts.metricLabels {
+		if !validLabelName(name) {
+			return nil, fmt.Errorf("bboltkv: invalid metric label name %q", name)
+		}
+		m.labels = append(m.labels, name+`="`+escapeLabelValue(value)+`"`)
+	}
+	sort.Strings(m.labels)
+	return m, nil
+}
+
+// validLabelName reports whether name is a Prometheus label name that is
+// not reserved.
+func validLabelName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "__") {
+		return false
+	}
+	for i, c := range name {
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabelValue escapes value for a label, replacing bytes that are not
+// UTF-8, as label values must be.
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(strings.ToValidUTF8(value, "�"))
+}
+
+func (m *metricWriter) family(name, typ, help string) {
+	fmt.Fprintf(&m.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes a sample of name, with the store's labels followed by
+// labels, given as pairs of names and values.
+func (m *metricWriter) sample(name string, labels []string, value float64) {
+	m.buf.WriteString(name)
+	if len(m.labels)+len(labels) > 0 {
+		m.buf.WriteByte('{')
+		m.buf.WriteString(strings.Join(m.labels, ","))
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 || len(m.labels) > 0 {
+				m.buf.WriteByte(',')
+			}
+			m.buf.WriteString(labels[i] + `="` + escapeLabelValue(labels[i+1]) + `"`)
+		}
+		m.buf.WriteByte('}')
+	}
+	m.buf.WriteByte(' ')
+	m.buf.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	m.buf.WriteByte('\n')
+}
+
+func (m *metricWriter) opStats(stats map[string]OpStat) {
+	prefixes := make([]string, 0, len(stats))
+	for p := range stats {
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+	label := func(p string) []string {
+		if p == OpStatsOther {
+			return []string{"overflow", "true"}
+		}
+		return []string{"prefix", p}
+	}
+	counters := []struct {
+		name, help string
+		value      func(OpStat) int64
+	}{
+		{"bboltkv_prefix_reads_total", "Entries read, per key prefix.", func(o OpStat) int64 { return o.Reads }},
+		{"bboltkv_prefix_writes_total", "Entries written, per key prefix.", func(o OpStat) int64 { return o.Writes }},
+		{"bboltkv_prefix_deletes_total", "Entries deleted, per key prefix.", func(o OpStat) int64 { return o.Deletes }},
+		{"bboltkv_prefix_read_bytes_total", "Bytes of keys and values read, per key prefix.", func(o OpStat) int64 { return o.ReadBytes }},
+		{"bboltkv_prefix_write_bytes_total", "Bytes of keys and values written, per key prefix.", func(o OpStat) int64 { return o.WriteBytes }},
+	}
+	for _, c := range counters {
+		m.family(c.name, "counter", c.help)
+		for _, p := range prefixes {
+			m.sample(c.name, label(p), float64(c.value(stats[p])))
+		}
+	}
+}
+
+func (m *metricWriter) txStats(stats map[string]TxOpStats) {
+	ops := make([]string, 0, len(stats))
+	for op := range stats {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	const duration = "bboltkv_tx_duration_seconds"
+	m.family(duration, "summary", "Duration of write transactions, per operation; quantile 1 is the slowest.")
+	for _, op := range ops {
+		st := stats[op]
+		m.sample(duration, []string{"op", op, "quantile", "1"}, st.Slowest.Duration.Seconds())
+		m.sample(duration+"_sum", []string{"op", op}, st.Total.Duration.Seconds())
+		m.sample(duration+"_count", []string{"op", op}, float64(st.Count))
+	}
+	counters := []struct {
+		name, help string
+		value      func(TxStatsDelta) time.Duration
+	}{
+		{"bboltkv_tx_commit_seconds_total", "Time spent committing write transactions, per operation.", func(d TxStatsDelta) time.Duration { return d.Commit }},
+		{"bboltkv_tx_write_seconds_total", "Time spent writing the pages of write transactions, per operation.", func(d TxStatsDelta) time.Duration { return d.Write }},
+	}
+	for _, c := range counters {
+		m.family(c.name, "counter", c.help)
+		for _, op := range ops {
+			m.sample(c.name, []string{"op", op}, c.value(stats[op].Total).Seconds())
+		}
+	}
+	const pages = "bboltkv_tx_page_writes_total"
+	m.family(pages, "counter", "Pages written by write transactions, per operation.")
+	for _, op := range ops {
+		m.sample(pages, []string{"op", op}, float64(stats[op].Total.PageWrites))
+	}
+}
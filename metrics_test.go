@@ -0,0 +1,260 @@
+package bboltkv
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// metricSample is a sample parsed from the text exposition format.
+type metricSample struct {
+	name   string
+	labels map[string]string
+	value  float64
+}
+
+// parseMetrics parses the text exposition format, failing the test on
+// anything malformed or on samples of families not declared before them.
+func parseMetrics(t *testing.T, text []byte) []metricSample {
+	t.Helper()
+	types := map[string]string{}
+	var samples []metricSample
+	sc := bufio.NewScanner(bytes.NewReader(text))
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "# ") {
+			f := strings.SplitN(line, " ", 4)
+			if len(f) != 4 || (f[1] != "HELP" && f[1] != "TYPE") {
+				t.Fatalf("malformed comment %q", line)
+			}
+			if f[1] == "TYPE" {
+				if _, ok := types[f[2]]; ok {
+					t.Fatalf("%s declared twice", f[2])
+				}
+				types[f[2]] = f[3]
+			}
+			continue
+		}
+		s := metricSample{labels: map[string]string{}}
+		i := strings.IndexAny(line, "{ ")
+		if i <= 0 {
+			t.Fatalf("malformed sample %q", line)
+		}
+		s.name, line = line[:i], line[i:]
+		if line[0] == '{' {
+			line = line[1:]
+			for line[0] != '}' {
+				eq := strings.Index(line, `="`)
+				if eq <= 0 {
+					t.Fatalf("malformed labels in %q", sc.Text())
+				}
+				name := line[:eq]
+				line = line[eq+2:]
+				var value strings.Builder
+				for line[0] != '"' {
+					if line[0] == '\\' {
+						switch line[1] {
+						case '\\', '"':
+							value.WriteByte(line[1])
+						case 'n':
+							value.WriteByte('\n')
+						default:
+							t.Fatalf("bad escape in %q", sc.Text())
+						}
+						line = line[2:]
+						continue
+					}
+					value.WriteByte(line[0])
+					line = line[1:]
+				}
+				if _, ok := s.labels[name]; ok {
+					t.Fatalf("label %s repeated in %q", name, sc.Text())
+				}
+				s.labels[name] = value.String()
+				line = strings.TrimPrefix(line[1:], ",")
+			}
+			line = line[1:]
+		}
+		v, err := strconv.ParseFloat(strings.TrimPrefix(line, " "), 64)
+		if err != nil || line[0] != ' ' {
+			t.Fatalf("malformed value in %q", sc.Text())
+		}
+		s.value = v
+		family := s.name
+		if types[family] == "" {
+			family = strings.TrimSuffix(strings.TrimSuffix(family, "_sum"), "_count")
+			if types[family] != "summary" {
+				t.Fatalf("sample of undeclared %s", s.name)
+			}
+		}
+		samples = append(samples, s)
+	}
+	return samples
+}
+
+// metric returns the value of the sample of name whose labels include
+// labels, given as pairs, failing unless there is exactly one.
+func metric(t *testing.T, samples []metricSample, name string, labels ...string) float64 {
+	t.Helper()
+	var found []float64
+next:
+	for _, s := range samples {
+		if s.name != name {
+			continue
+		}
+		for i := 0; i < len(labels); i += 2 {
+			if s.labels[labels[i]] != labels[i+1] {
+				continue next
+			}
+		}
+		found = append(found, s.value)
+	}
+	if len(found) != 1 {
+		t.Fatalf("found %d samples of %s%v", len(found), name, labels)
+	}
+	return found[0]
+}
+
+func writeMetrics(t *testing.T, db *Store) []metricSample {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := db.WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return parseMetrics(t, buf.Bytes())
+}
+
+func TestWriteMetrics(t *testing.T) {
+	db := openTestStore(t, WithOpStats(4), WithTxStats(), WithReadCache(10))
+	for i := 0; i < 10; i++ {
+		if err := db.Put(fmt.Sprintf("user%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := db.Get("user1", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("user0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutAll(map[string]interface{}{"ab": 1, "cd": 2}); err != nil {
+		t.Fatal(err)
+	}
+	samples := writeMetrics(t, db)
+	for _, c := range []struct {
+		name   string
+		labels []string
+		want   float64
+	}{
+		{"bboltkv_keys", nil, 11},
+		{"bboltkv_prefix_writes_total", []string{"prefix", "user"}, 10},
+		{"bboltkv_prefix_reads_total", []string{"prefix", "user"}, 3},
+		{"bboltkv_prefix_deletes_total", []string{"prefix", "user"}, 1},
+		{"bboltkv_prefix_writes_total", []string{"prefix", "ab"}, 1},
+		{"bboltkv_tx_duration_seconds_count", []string{"op", "Put"}, 10},
+		{"bboltkv_tx_duration_seconds_count", []string{"op", "Delete"}, 1},
+		{"bboltkv_tx_duration_seconds_count", []string{"op", "PutAll"}, 1},
+	} {
+		if got := metric(t, samples, c.name, c.labels...); got != c.want {
+			t.Errorf("%s%v is %v, expected %v", c.name, c.labels, got, c.want)
+		}
+	}
+	cache := db.CacheStats()
+	if got := metric(t, samples, "bboltkv_cache_hits_total"); got != float64(cache.Hits) || got < 2 {
+		t.Errorf("got %v cache hits, expected %d", got, cache.Hits)
+	}
+	if got := metric(t, samples, "bboltkv_operations_total"); got < 15 {
+		t.Errorf("counted %v operations", got)
+	}
+	if got := metric(t, samples, "bboltkv_file_size_bytes"); got <= 0 {
+		t.Errorf("file size %v", got)
+	}
+	slowest := metric(t, samples, "bboltkv_tx_duration_seconds", "op", "Put", "quantile", "1")
+	if sum := metric(t, samples, "bboltkv_tx_duration_seconds_sum", "op", "Put"); slowest <= 0 || slowest > sum {
+		t.Errorf("slowest Put took %vs of %vs", slowest, sum)
+	}
+	if got := metric(t, samples, "bboltkv_page_writes_total"); got <= 0 {
+		t.Errorf("%v pages written", got)
+	}
+
+	// counters only grow
+	if err := db.Put("user9", 0); err != nil {
+		t.Fatal(err)
+	}
+	samples = writeMetrics(t, db)
+	if got := metric(t, samples, "bboltkv_prefix_writes_total", "prefix", "user"); got != 11 {
+		t.Errorf("counted %v writes, expected 11", got)
+	}
+}
+
+func TestWriteMetricsDefault(t *testing.T) {
+	db := openTestStore(t)
+	if err := db.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	samples := writeMetrics(t, db)
+	var names []string
+	for _, s := range samples {
+		if len(s.labels) != 0 {
+			t.Errorf("%s has labels %v", s.name, s.labels)
+		}
+		names = append(names, s.name)
+	}
+	sort.Strings(names)
+	want := []string{
+		"bboltkv_file_size_bytes", "bboltkv_free_pages", "bboltkv_keys",
+		"bboltkv_operations_total", "bboltkv_page_write_seconds_total",
+		"bboltkv_page_writes_total", "bboltkv_read_transactions_total",
+	}
+	if strings.Join(names, " ") != strings.Join(want, " ") {
+		t.Fatalf("got %v, expected %v", names, want)
+	}
+	if got := metric(t, samples, "bboltkv_keys"); got != 1 {
+		t.Fatalf("got %v keys", got)
+	}
+}
+
+func TestWriteMetricsLabels(t *testing.T) {
+	labels := map[string]string{"store": `a"b\c` + "\nd", "instance": "x", "bad": "\xff"}
+	db := openTestStore(t, WithMetricLabels(labels), WithOpStats(2))
+	labels["instance"] = "changed"
+	db.opStats.max = 1
+	for _, key := range []string{"a\"1", "b\\2"} {
+		if err := db.Put(key, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var buf bytes.Buffer
+	if err := db.WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `bboltkv_keys{bad="�",instance="x",store="a\"b\\c\nd"} 2`) {
+		t.Fatalf("labels rendered as:\n%s", buf.String())
+	}
+	samples := parseMetrics(t, buf.Bytes())
+	for _, s := range samples {
+		if s.labels["store"] != labels["store"] || s.labels["instance"] != "x" {
+			t.Fatalf("%s has labels %v", s.name, s.labels)
+		}
+	}
+	if got := metric(t, samples, "bboltkv_prefix_writes_total", "prefix", `a"`); got != 1 {
+		t.Fatalf("got %v", got)
+	}
+	if got := metric(t, samples, "bboltkv_prefix_writes_total", "overflow", "true"); got != 1 {
+		t.Fatalf("got %v", got)
+	}
+
+	for _, name := range []string{"", "1a", "a-b", "__reserved"} {
+		db := openTestStore(t, WithMetricLabels(map[string]string{name: "v"}))
+		var buf bytes.Buffer
+		if err := db.WriteMetrics(&buf); err == nil || buf.Len() != 0 {
+			t.Fatalf("label %q: got %v, wrote %q", name, err, buf.String())
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package bboltkv
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// ErrPageSize is wrapped by the error Open returns when WithPageSize asks
+// for a page size other than that of an existing database file, which is
+// fixed when the file is created.
+var ErrPageSize = errors.New("bboltkv: page size does not match the file")
+
+// WithInitialMmapSize makes the store map at least the given number of bytes
+// of the database file into memory when it is opened, however small the
+// file still is. bbolt remaps the file as it grows, and a remap waits for
+// all read transactions to end while holding up writes, so a store expected
+// to grow to several gigabytes spares itself many of those pauses by mapping
+// its final size up front. Mapping more than the file holds costs address
+// space, not memory. Sizes smaller than the file have no effect.
+//
+//	store, err := bboltkv.Open(path, "bucket", bboltkv.WithInitialMmapSize(8<<30))
+func WithInitialMmapSize(bytes int) Option {
+	return func(o *options) {
+		o.initialMmapSize = bytes
+	}
+}
+
+// WithPageSize creates the database file with pages of the given number of
+// bytes, a power of two of at least 1024, rather than of the operating
+// system's page size. Larger pages hold more entries each, which makes the
+// tree shallower for large stores, at the cost of writing more for every
+// change.
+//
+// The page size of a file is fixed when it is created, so the option only
+// takes effect for new files, and compactions and rotations keep the page
+// size of the file they rewrite. Opening an existing file whose page size
+// differs fails with an error wrapping ErrPageSize, rather than opening it
+// with the page size it has.
+func WithPageSize(bytes int) Option {
+	return func(o *options) {
+		o.pageSize = bytes
+	}
+}
+
+// WithFreelistMapType makes bbolt keep the free pages of the database file
+// in a hash map rather than a sorted array. Finding free pages in the array
+// slows down as the file fragments, so large files with many free pages
+// allocate faster with the map, but the array favours pages near the start
+// of the file, which keeps it more compact. The freelist is held in memory
+// only, so the option can change from one Open to the next.
+func WithFreelistMapType() Option {
+	return func(o *options) {
+		o.freelistMap = true
+	}
+}
+
+// FileStats describes the database file as the store opened it, see
+// Store.FileStats.
+type FileStats struct {
+	// PageSize is the size of the file's pages in bytes.
+	PageSize int
+
+	// InitialMmapSize is the size the file was first mapped with, see
+	// WithInitialMmapSize, or 0 if it was mapped at its own size.
+	InitialMmapSize int
+
+	// FreelistType is the freelist bbolt keeps: "array", or "hashmap" with
+	// WithFreelistMapType.
+	FreelistType string
+
+	// FileSize is the size of the file in bytes.
+	FileSize int64
+}
+
+// FileStats returns the page size, mapping and freelist the database file
+// was opened with, so that the effect of WithPageSize, WithInitialMmapSize
+// and WithFreelistMapType can be confirmed.
+func (s *Store) FileStats() (FileStats, error) {
+	stats := FileStats{
+		InitialMmapSize: s.opts.initialMmapSize,
+		FreelistType:    string(bbolt.FreelistArrayType),
+	}
+	err := s.view(func(tx *bbolt.Tx) error {
+		db := tx.DB()
+		stats.PageSize = db.Info().PageSize
+		if db.FreelistType != "" {
+			stats.FreelistType = string(db.FreelistType)
+		}
+		stats.FileSize = tx.Size()
+		return nil
+	})
+	return stats, err
+}
+
+// boltOptions returns the bbolt options the database file at path is opened
+// with.
+func (o options) boltOptions() (*bbolt.Options, error) {
+	if p := o.pageSize; p != 0 && (p < 1024 || p&(p-1) != 0) {
+		return nil, fmt.Errorf("bboltkv: page size %d is not a power of two of at least 1024", p)
+	}
+	bopts := &bbolt.Options{
+		Timeout:         50 * time.Millisecond,
+		ReadOnly:        o.readOnly,
+		InitialMmapSize: o.initialMmapSize,
+		PageSize:        o.pageSize,
+	}
+	if o.freelistMap {
+		bopts.FreelistType = bbolt.FreelistMapType
+	}
+	return bopts, nil
+}
+
+// checkPageSize fails with ErrPageSize if db, opened from the file at path,
+// does not have the page size the options ask for.
+func (o options) checkPageSize(db *bbolt.DB, path string) error {
+	if have := db.Info().PageSize; o.pageSize != 0 && have != o.pageSize {
+		return fmt.Errorf("%w: %s has pages of %d bytes, not %d", ErrPageSize, path, have, o.pageSize)
+	}
+	return nil
+}
+
+// rewriteOptions returns the bbolt options for creating a file to copy the
+// data of src into, with the page size of src.
+func rewriteOptions(src *bbolt.DB) *bbolt.Options {
+	return &bbolt.Options{Timeout: 50 * time.Millisecond, PageSize: src.Info().PageSize}
+}
@@ -0,0 +1,241 @@
+package bboltkv
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// boltPageSize returns the page size of the database file at path, as bbolt
+// reads it.
+func boltPageSize(t *testing.T, path string) int {
+	t.Helper()
+	db, err := bbolt.Open(path, 0640, &bbolt.Options{Timeout: 50 * time.Millisecond, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	return db.Info().PageSize
+}
+
+func TestPageSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path, "test", WithPageSize(16384))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key", 1); err != nil {
+		t.Fatal(err)
+	}
+	if stats, err := db.FileStats(); err != nil {
+		t.Fatal(err)
+	} else if stats.PageSize != 16384 {
+		t.Fatalf("got pages of %d bytes, expected 16384", stats.PageSize)
+	}
+	db.Close()
+	if size := boltPageSize(t, path); size != 16384 {
+		t.Fatalf("file has pages of %d bytes, expected 16384", size)
+	}
+
+	// the same page size, or none, opens the file
+	for _, opts := range [][]Option{{WithPageSize(16384)}, nil} {
+		db, err := Open(path, "test", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkValues(t, db, map[string]int{"key": 1})
+		if stats, err := db.FileStats(); err != nil {
+			t.Fatal(err)
+		} else if stats.PageSize != 16384 {
+			t.Fatalf("got pages of %d bytes, expected 16384", stats.PageSize)
+		}
+		db.Close()
+	}
+}
+
+func TestPageSizeExisting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path, "test", WithPageSize(4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	_, err = Open(path, "test", WithPageSize(8192))
+	if !errors.Is(err, ErrPageSize) {
+		t.Fatalf("got %v, expected ErrPageSize", err)
+	} else if !strings.Contains(err.Error(), "4096") || !strings.Contains(err.Error(), "8192") {
+		t.Fatalf("page sizes missing from %q", err)
+	}
+	// the file was not touched, and is not locked
+	db, err = Open(path, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if size := boltPageSize(t, path); size != 4096 {
+		t.Fatalf("file has pages of %d bytes, expected 4096", size)
+	}
+}
+
+func TestPageSizeInvalid(t *testing.T) {
+	for _, size := range []int{-1, 512, 1000, 5000} {
+		path := filepath.Join(t.TempDir(), "test.db")
+		if _, err := Open(path, "test", WithPageSize(size)); err == nil || !strings.Contains(err.Error(), "power of two") {
+			t.Fatalf("%d: got %v", size, err)
+		}
+	}
+}
+
+func TestPageSizeKeptByCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path, "test", WithPageSize(8192))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pad := make([]byte, 1<<10)
+	batch := map[string]interface{}{}
+	for i := 0; i < 2000; i++ {
+		batch[fmt.Sprintf("pad:%d", i)] = pad
+	}
+	if err := db.PutAll(batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.DeletePrefix("pad:"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	before := fileOf(t, path)
+
+	var log logLines
+	db, err = Open(path, "test", WithPageSize(8192), WithAutoCompact(0.5), WithLogger(&log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if !log.has("compacted") || fileOf(t, path).Size() >= before.Size() {
+		t.Fatalf("not compacted: %q", log)
+	}
+	if stats, err := db.FileStats(); err != nil {
+		t.Fatal(err)
+	} else if stats.PageSize != 8192 {
+		t.Fatalf("got pages of %d bytes, expected 8192", stats.PageSize)
+	}
+	checkValues(t, db, map[string]int{"key": 1})
+}
+
+func TestInitialMmapSize(t *testing.T) {
+	db := openTestStore(t, WithInitialMmapSize(64<<20))
+	stats, err := db.FileStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.InitialMmapSize != 64<<20 {
+		t.Fatalf("got %d, expected %d", stats.InitialMmapSize, 64<<20)
+	}
+	// mapping more does not grow the file
+	if stats.FileSize >= 64<<20 {
+		t.Fatalf("file grew to %d bytes", stats.FileSize)
+	}
+	if err := db.Put("key", 1); err != nil {
+		t.Fatal(err)
+	}
+	checkValues(t, db, map[string]int{"key": 1})
+
+	db = openTestStore(t)
+	if stats, err := db.FileStats(); err != nil {
+		t.Fatal(err)
+	} else if stats.InitialMmapSize != 0 {
+		t.Fatalf("got %d, expected 0", stats.InitialMmapSize)
+	}
+}
+
+func TestFreelistMapType(t *testing.T) {
+	db := openTestStore(t, WithFreelistMapType())
+	if db.GetDb().FreelistType != bbolt.FreelistMapType {
+		t.Fatalf("bbolt has a freelist of type %q", db.GetDb().FreelistType)
+	}
+	if stats, err := db.FileStats(); err != nil {
+		t.Fatal(err)
+	} else if stats.FreelistType != "hashmap" {
+		t.Fatalf("got %q, expected hashmap", stats.FreelistType)
+	}
+	// pages freed and reused
+	want := map[string]int{}
+	for i := 0; i < 100; i++ {
+		if err := db.Put(keyN(i), i); err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 0 {
+			if err := db.Delete(keyN(i)); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			want[keyN(i)] = i
+		}
+	}
+	checkValues(t, db, want)
+
+	db = openTestStore(t)
+	if stats, err := db.FileStats(); err != nil {
+		t.Fatal(err)
+	} else if stats.FreelistType != "array" {
+		t.Fatalf("got %q, expected array", stats.FreelistType)
+	}
+}
+
+// BenchmarkGrowth writes a store up to 64MB while a reader keeps read
+// transactions open, and reports the longest write. Remapping the file
+// waits for the reader, so that is one that remapped it, unless the file
+// was mapped large enough up front.
+func BenchmarkGrowth(b *testing.B) {
+	value := make([]byte, 16<<10)
+	for _, mmap := range []int{0, 256 << 20} {
+		b.Run(fmt.Sprintf("mmap=%dMB", mmap>>20), func(b *testing.B) {
+			var longest time.Duration
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				db := openTestStore(b, WithInitialMmapSize(mmap))
+				done := make(chan struct{})
+				reading := make(chan struct{})
+				go func() {
+					defer close(reading)
+					for {
+						select {
+						case <-done:
+							return
+						default:
+						}
+						db.RawView(func(*bbolt.Bucket) error {
+							time.Sleep(20 * time.Millisecond)
+							return nil
+						})
+					}
+				}()
+				b.StartTimer()
+				for j := 0; j < 4096; j++ {
+					start := time.Now()
+					if err := db.Put(keyN(j), value); err != nil {
+						b.Fatal(err)
+					}
+					if d := time.Since(start); d > longest {
+						longest = d
+					}
+				}
+				b.StopTimer()
+				close(done)
+				<-reading
+				db.Close()
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(longest.Milliseconds()), "max-ms/put")
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package bboltkv
+
+import (
+	"bytes"
+	"sort"
+
+	"go.etcd.io/bbolt"
+)
+
+// ListNamespaces returns the distinct key segments found directly below
+// prefix, where segments are separated by sep, in lexicographic order.
+// For keys following a "tenant/collection/id" scheme,
+//
+//	tenants, err := store.ListNamespaces("", '/')
+//	collections, err := store.ListNamespaces("acme/", '/')
+//
+// A segment is only reported if some key continues past it with another
+// sep: keys with no sep after the prefix ("acme/readme") are entries rather
+// than namespaces and are not listed. A key with sep right after the prefix
+// ("acme//x") yields the empty segment.
+//
+// Rather than visiting every key, ListNamespaces seeks past the whole
+// subtree of each segment it finds, so its cost grows with the number of
+// segments, not the number of keys.
+func (s *Store) ListNamespaces(prefix string, sep byte) ([]string, error) {
+	if err := s.plainKeys(); err != nil {
+		return nil, err
+	}
+	var names []string
+	err := s.view(func(tx *bbolt.Tx) error {
+		names, _ = listNamespaces(tx.Bucket(s.bucketName).Cursor(), []byte(prefix), sep)
+		return nil
+	})
+	// Segments are found in the order of segment+sep, which differs from
+	// the order of the segments themselves when one is a prefix of another.
+	sort.Strings(names)
+	return names, err
+}
+
+// listNamespaces implements ListNamespaces. It also returns the number of
+// cursor movements it made.
+func listNamespaces(c *bbolt.Cursor, prefix []byte, sep byte) (names []string, steps int) {
+	k, _ := c.Seek(prefix)
+	steps++
+	for k != nil && bytes.HasPrefix(k, prefix) {
+		rest := k[len(prefix):]
+		i := bytes.IndexByte(rest, sep)
+		if i < 0 {
+			k, _ = c.Next()
+			steps++
+			continue
+		}
+		names = append(names, string(rest[:i]))
+		end := prefixEnd(k[:len(prefix)+i+1])
+		if end == nil {
+			break
+		}
+		k, _ = c.Seek(end)
+		steps++
+	}
+	return names, steps
+}
+
+// prefixEnd returns the smallest key that sorts after every key starting
+// with prefix, or nil if there is no such key.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package bboltkv
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// bruteNamespaces computes ListNamespaces by looking at every key.
+func bruteNamespaces(keys []string, prefix string, sep byte) []string {
+	seen := map[string]bool{}
+	for _, k := range keys {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		rest := k[len(prefix):]
+		if i := strings.IndexByte(rest, sep); i >= 0 {
+			seen[rest[:i]] = true
+		}
+	}
+	names := []string{}
+	for n := range seen {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestListNamespaces(t *testing.T) {
+	db := openTestStore(t)
+	keys := []string{
+		"acme/users/1", "acme/users/2", "acme/orders/1", "acme/readme",
+		"acme//empty", "globex/users/1", "globex", "initech/x/y/z",
+		"toplevel", "acme/users",
+	}
+	entries := map[string]interface{}{}
+	for _, k := range keys {
+		entries[k] = 1
+	}
+	if err := db.PutAll(entries); err != nil {
+		t.Fatal(err)
+	}
+	for _, prefix := range []string{"", "acme/", "acme/users/", "globex/", "initech/", "nothing/", "a"} {
+		got, err := db.ListNamespaces(prefix, '/')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == nil {
+			got = []string{}
+		}
+		if want := bruteNamespaces(keys, prefix, '/'); !reflect.DeepEqual(got, want) {
+			t.Fatalf("prefix %q: got %q, expected %q", prefix, got, want)
+		}
+	}
+}
+
+func TestListNamespacesHighSeparator(t *testing.T) {
+	db := openTestStore(t)
+	keys := []string{"a\xffb", "a\xffc", "a\xfe\xffd", "b\xff", "\xff\xffx"}
+	for _, k := range keys {
+		if err := db.Put(k, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := db.ListNamespaces("", 0xff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := bruteNamespaces(keys, "", 0xff); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, expected %q", got, want)
+	}
+}
+
+func TestListNamespacesSeeks(t *testing.T) {
+	if testing.Short() {
+		t.Skip("large fixture")
+	}
+	db := openTestStore(t)
+	var keys []string
+	for _, tenant := range []string{"acme", "globex", "initech"} {
+		err := db.update(func(w *wtx) error {
+			for i := 0; i < 100000; i++ {
+				k := fmt.Sprintf("%s/items/%06d", tenant, i)
+				if err := w.put(k, []byte{1}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, tenant+"/items/x")
+	}
+	err := db.view(func(tx *bbolt.Tx) error {
+		names, steps := listNamespaces(tx.Bucket(db.bucketName).Cursor(), nil, '/')
+		sort.Strings(names)
+		if want := bruteNamespaces(keys, "", '/'); !reflect.DeepEqual(names, want) {
+			t.Fatalf("got %q, expected %q", names, want)
+		}
+		if steps > 4 {
+			t.Fatalf("took %d cursor steps for 3 namespaces", steps)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for _, tc := range []struct{ in, out []byte }{
+		{[]byte("a"), []byte("b")},
+		{[]byte("a\xff"), []byte("b")},
+		{[]byte("ab\xff\xff"), []byte("ac")},
+		{[]byte("\xff\xff"), nil},
+		{nil, nil},
+	} {
+		if got := prefixEnd(tc.in); !bytes.Equal(got, tc.out) {
+			t.Fatalf("prefixEnd(%q) = %q, expected %q", tc.in, got, tc.out)
+		}
+	}
+}
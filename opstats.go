@@ -0,0 +1,135 @@
+package bboltkv
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// OpStatsOther is the key under which OpStats reports operations on key
+// prefixes beyond the first maxOpStatPrefixes seen.
+const OpStatsOther = "\x00other"
+
+const maxOpStatPrefixes = 1000
+
+// OpStat counts the operations on keys sharing a prefix, see Store.OpStats.
+type OpStat struct {
+	Reads      int64 // entries read by Get and GetOrPut
+	Writes     int64 // entries written
+	Deletes    int64 // entries deleted
+	ReadBytes  int64 // bytes of keys and values read
+	WriteBytes int64 // bytes of keys and values written
+}
+
+// WithOpStats makes the store count operations and bytes per key prefix,
+// grouping keys by their first prefixLen bytes. Counting uses atomic
+// operations on per-prefix counters, so it is cheap enough to leave enabled.
+// To bound memory use, at most 1000 distinct prefixes are tracked; any
+// further ones are counted together under OpStatsOther. Writes are counted
+// when their transaction commits.
+func WithOpStats(prefixLen int) Option {
+	return func(o *options) {
+		o.opStatsPrefixLen = prefixLen
+	}
+}
+
+// OpStats returns the operation counts per key prefix since the store was
+// opened or ResetOpStats was last called. It returns nil unless the store
+// was opened with WithOpStats.
+func (s *Store) OpStats() map[string]OpStat {
+	if s.opStats == nil {
+		return nil
+	}
+	return s.opStats.snapshot()
+}
+
+// ResetOpStats sets all operation counts back to zero.
+func (s *Store) ResetOpStats() {
+	if s.opStats != nil {
+		s.opStats.reset()
+	}
+}
+
+type opStats struct {
+	prefixLen int
+	max       int
+	mu        sync.RWMutex
+	m         map[string]*opCounters
+}
+
+type opCounters struct {
+	reads, writes, deletes, readBytes, writeBytes int64
+}
+
+func newOpStats(prefixLen int) *opStats {
+	return &opStats{
+		prefixLen: prefixLen,
+		max:       maxOpStatPrefixes,
+		m:         make(map[string]*opCounters),
+	}
+}
+
+// counters returns the counters for the prefix of key.
+func (o *opStats) counters(key string) *opCounters {
+	prefix := key
+	if len(prefix) > o.prefixLen {
+		prefix = prefix[:o.prefixLen]
+	}
+	o.mu.RLock()
+	c := o.m[prefix]
+	o.mu.RUnlock()
+	if c != nil {
+		return c
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if c = o.m[prefix]; c != nil {
+		return c
+	}
+	if len(o.m) >= o.max {
+		prefix = OpStatsOther
+		if c = o.m[prefix]; c != nil {
+			return c
+		}
+	}
+	c = &opCounters{}
+	o.m[prefix] = c
+	return c
+}
+
+func (o *opStats) read(key string, n int) {
+	c := o.counters(key)
+	atomic.AddInt64(&c.reads, 1)
+	atomic.AddInt64(&c.readBytes, int64(len(key)+n))
+}
+
+func (o *opStats) write(key string, n int) {
+	c := o.counters(key)
+	atomic.AddInt64(&c.writes, 1)
+	atomic.AddInt64(&c.writeBytes, int64(len(key)+n))
+}
+
+func (o *opStats) delete(key string) {
+	atomic.AddInt64(&o.counters(key).deletes, 1)
+}
+
+func (o *opStats) snapshot() map[string]OpStat {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	m := make(map[string]OpStat, len(o.m))
+	for prefix, c := range o.m {
+		m[prefix] = OpStat{
+			Reads:      atomic.LoadInt64(&c.reads),
+			Writes:     atomic.LoadInt64(&c.writes),
+			Deletes:    atomic.LoadInt64(&c.deletes),
+			ReadBytes:  atomic.LoadInt64(&c.readBytes),
+			WriteBytes: atomic.LoadInt64(&c.writeBytes),
+		}
+	}
+	return m
+}
+
+func (o *opStats) reset() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.m = make(map[string]*opCounters)
+}
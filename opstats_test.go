@@ -0,0 +1,117 @@
+package bboltkv
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestOpStats(t *testing.T) {
+	db := openTestStore(t, WithOpStats(4))
+	if db.OpStats() == nil || len(db.OpStats()) != 0 {
+		t.Fatalf("unexpected initial stats %v", db.OpStats())
+	}
+	raw, err := db.Encode("value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := db.PutEncoded(fmt.Sprintf("user%d", i), raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := db.Get(fmt.Sprintf("user%d", i), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete("user0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutEncoded("ab", raw); err != nil {
+		t.Fatal(err)
+	}
+	// failed operations are not counted
+	db.Get("user0", nil)
+	db.Delete("user0")
+	db.PutAllEncoded(RawEntries{"user0": raw, "bad": nil})
+
+	stats := db.OpStats()
+	user := stats["user"]
+	want := OpStat{
+		Reads:      3,
+		Writes:     10,
+		Deletes:    1,
+		ReadBytes:  int64(3 * (5 + len(raw))),
+		WriteBytes: int64(10 * (5 + len(raw))),
+	}
+	if user != want {
+		t.Fatalf("got %+v, expected %+v", user, want)
+	}
+	if ab := stats["ab"]; ab.Writes != 1 || ab.WriteBytes != int64(2+len(raw)) {
+		t.Fatalf("unexpected stats for short key: %+v", ab)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("unexpected prefixes in %v", stats)
+	}
+	db.ResetOpStats()
+	if len(db.OpStats()) != 0 {
+		t.Fatalf("stats not reset: %v", db.OpStats())
+	}
+	if db := openTestStore(t); db.OpStats() != nil {
+		t.Fatal("stats reported without WithOpStats")
+	}
+}
+
+func TestOpStatsCardinality(t *testing.T) {
+	db := openTestStore(t, WithOpStats(3), WithReadCache(10))
+	db.opStats.max = 5
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("%03d", i)
+		if err := db.Put(key, i); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Get(key, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := db.OpStats()
+	if len(stats) != 6 {
+		t.Fatalf("tracking %d prefixes, expected 5 and other", len(stats))
+	}
+	if other := stats[OpStatsOther]; other.Writes != 15 || other.Reads != 15 {
+		t.Fatalf("unexpected overflow stats %+v", other)
+	}
+}
+
+func TestOpStatsRace(t *testing.T) {
+	db := openTestStore(t, WithOpStats(1))
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				key := fmt.Sprintf("%d-%d", g, i)
+				if err := db.Put(key, i); err != nil {
+					t.Error(err)
+				}
+				if err := db.Get(key, nil); err != nil {
+					t.Error(err)
+				}
+				if i%10 == 0 {
+					db.OpStats()
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	var writes, reads int64
+	for _, st := range db.OpStats() {
+		writes += st.Writes
+		reads += st.Reads
+	}
+	if writes != 400 || reads != 400 {
+		t.Fatalf("counted %d writes and %d reads, expected 400 each", writes, reads)
+	}
+}
@@ -0,0 +1,189 @@
+package bboltkv
+
+import "time"
+
+// Option configures optional behaviour of a Store. Options are passed to
+// Open() after the path and bucket name:
+//
+//	store, err := bboltkv.Open("my.db", "bucket", bboltkv.WithCheckOnOpen(bboltkv.CheckFast))
+type Option func(*options)
+
+type options struct {
+	checkMode       CheckMode
+	checkCallback   func(err error)
+	checkBackground bool
+	checkReadOnly   bool
+	clock           Clock
+
+	readOnly    bool
+	mustExist   bool
+	forceDelete bool
+
+	putValidators     []func(key string, value interface{}) error
+	putTransforms     []func(key string, value interface{}) (interface{}, error)
+	encodedValidators []func(key string, encoded []byte) error
+
+	keyLimit   int
+	keyCharset func(r rune) bool
+
+	cacheSize int
+	preload   []string
+
+	filterBitsPerKey int
+
+	schemaPuts bool
+	schemaGets bool
+
+	marshalers   bool
+	canonical    bool
+	typeInfo     bool
+	singleflight bool
+
+	opStatsPrefixLen int
+
+	txStats         bool
+	slowOpThreshold time.Duration
+	slowOpFn        func(op, key string, stats TxStatsDelta)
+
+	compression   bool
+	encryptionKey []byte
+	encryptedKeys bool
+	keyPrefixes   []string
+	checksums     bool
+	transforms    []ValueTransform
+
+	chunkThreshold int
+	chunkSize      int
+	dedup          bool
+
+	writeBuffer   bool
+	bufferEntries int
+	bufferDelay   time.Duration
+	flushCallback func(err error)
+
+	fairWrites bool
+	fairDelay  time.Duration
+
+	sweepInterval    time.Duration
+	ttlJitter        float64
+	metricLabels     map[string]string
+	batchTarget      time.Duration
+	batchMin         int
+	batchMax         int
+	keyRate          float64
+	keyBurst         int
+	globalRate       float64
+	globalBurst      int
+	rateLimitMode    RateLimitMode
+	validatedRetries *int
+	decodedSize      int
+
+	autoRecreateBucket bool
+
+	changeLog     bool
+	changeLogMeta bool
+	putHook       func(key string, meta map[string]string, encoded []byte)
+	deleteHook    func(key string, meta map[string]string)
+
+	opTimeout time.Duration
+
+	freezeErrors bool
+	maxFreeze    time.Duration
+	freezer      *freezer     // shared with the stores on the same file, see OpenShared
+	state        *bucketState // shared with the stores on the same bucket, see OpenShared
+
+	retryAttempts int
+	retryBackoff  time.Duration
+	retryClassify func(error) bool
+
+	tracer    Tracer
+	redactKey func(key string) string
+
+	history       []historyRule
+	deleteHistory bool
+
+	insertionOrder []orderRule
+
+	quotaKeys     int64
+	quotaBytes    int64
+	quotaWarnings []quotaWarning
+
+	journalMaxBytes int64
+	journalMaxAge   time.Duration
+
+	logger            Logger
+	autoCompact       float64
+	autoCompactBudget time.Duration
+	compactHook       func(stage string) error // run at each stage of a compaction, for tests
+
+	selfStatsKey      string
+	selfStatsInterval time.Duration
+
+	initialMmapSize int
+	pageSize        int
+	freelistMap     bool
+
+	modTimes           bool
+	tombstoneRetention time.Duration
+}
+
+// WithMarshalerPreference makes Put, Encode and the other writing methods
+// store values implementing encoding.BinaryMarshaler using their
+// MarshalBinary method, and failing that, values implementing
+// encoding.TextMarshaler using MarshalText, rather than gob-encoding them.
+// This is usually faster and more compact for types with a native encoding,
+// such as UUIDs. Get then needs a pointer to a type implementing the
+// matching unmarshaler.
+//
+// Values stored this way are tagged, so any store can read them, whether it
+// was opened with this option or not, and stores opened with it can still
+// read gob-encoded values.
+func WithMarshalerPreference() Option {
+	return func(o *options) {
+		o.marshalers = true
+	}
+}
+
+// WithReadOnly opens the store in read-only mode: all writes fail with
+// ErrReadOnly, and Open behaves like OpenExisting, never creating the
+// database file or the bucket. The file is opened with a shared lock, so
+// several processes can open it read-only at the same time, but none can
+// open it for writing meanwhile. The lock works both ways: while a process
+// has the file open for writing, opening it read-only fails with a timeout
+// as well, so a reader cannot follow a live writer in another process.
+// Within one process, a read-only store can share the file with a writing
+// one through OpenShared. The background writes of WithExpirySweep,
+// WithSelfStats and WithWriteBuffer are not started.
+func WithReadOnly() Option {
+	return func(o *options) {
+		o.readOnly = true
+		o.mustExist = true
+	}
+}
+
+// WithCheckOnOpen makes Open verify the consistency of the database file
+// before returning. See CheckMode for the available levels. When the check
+// fails, Open returns an error wrapping ErrCorrupt.
+func WithCheckOnOpen(mode CheckMode) Option {
+	return func(o *options) {
+		o.checkMode = mode
+	}
+}
+
+// WithBackgroundCheck runs the CheckFull consistency check in a background
+// goroutine instead of blocking Open. The callback is invoked exactly once
+// with the result of the check: nil if the file is consistent, or an error
+// wrapping ErrCorrupt otherwise. If readOnlyOnFailure is set, a failed check
+// puts the store into read-only mode before the callback runs, so that all
+// further writes fail with ErrReadOnly.
+//
+// The check holds bbolt's writer lock while it runs, so writes issued
+// meanwhile wait for it to complete; reads are not affected. The option has
+// no effect unless WithCheckOnOpen(CheckFull) is also given.
+func WithBackgroundCheck(callback func(err error), readOnlyOnFailure bool) Option {
+	return func(o *options) {
+		o.checkBackground = true
+		o.checkCallback = callback
+		o.checkReadOnly = readOnlyOnFailure
+	}
+}
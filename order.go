@@ -0,0 +1,262 @@
+package bboltkv
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.etcd.io/bbolt"
+)
+
+// ErrNoInsertionOrder is returned by ForEachInsertionOrder for prefixes not
+// given to WithInsertionOrder.
+var ErrNoInsertionOrder = errors.New("bboltkv: no insertion order for prefix")
+
+// orderBucket holds a bucket per prefix given to WithInsertionOrder, named
+// by the prefix following a 'p', so that the empty prefix has a name. In
+// it, 's' followed by a big-endian sequence number maps to the key added
+// with it, and 'k' followed by a key maps to the sequence number, so that
+// the entries are visited in sequence order, and a key's entry is found to
+// be removed.
+const orderBucket = "insertion-order"
+
+// WithInsertionOrder makes the store remember the order in which keys
+// starting with prefix are added, so that ForEachInsertionOrder can visit
+// them in that order rather than in key order, such as the entries of an
+// activity feed keyed by opaque IDs. A key takes its place when it is
+// first written; writing it again keeps it, and deleting it removes it, so
+// a key written after being deleted goes to the end. Keys written before
+// the option was given take their place when they are next written.
+//
+// The option can be given several times, for several prefixes; prefixes
+// may nest, a key then has a place in the order of each. The order is kept
+// in the database file, so it carries over to later opens with the same
+// option. It is not kept with WithEncryptedKeys.
+//
+//	store, err := bboltkv.Open(path, "bucket", bboltkv.WithInsertionOrder("feed:"))
+func WithInsertionOrder(prefix string) Option {
+	return func(o *options) {
+		o.insertionOrder = append(o.insertionOrder, orderRule{prefix, false})
+	}
+}
+
+// WithInsertionOrderMoveToEnd is WithInsertionOrder, but writing a key
+// again moves it to the end of the order, as if it had been deleted and
+// added anew, so the order is that of the latest writes.
+func WithInsertionOrderMoveToEnd(prefix string) Option {
+	return func(o *options) {
+		o.insertionOrder = append(o.insertionOrder, orderRule{prefix, true})
+	}
+}
+
+type orderRule struct {
+	prefix    string
+	moveToEnd bool
+}
+
+func orderName(prefix string) []byte {
+	return []byte("p" + prefix)
+}
+
+func orderSeqKey(seq uint64) []byte {
+	k := make([]byte, 9)
+	k[0] = 's'
+	binary.BigEndian.PutUint64(k[1:], seq)
+	return k
+}
+
+func orderKeyKey(key string) []byte {
+	return []byte("k" + key)
+}
+
+// order gives key, just written, its place in the insertion orders covering
+// it.
+func (w *wtx) order(key string) error {
+	for _, r := range w.s.opts.insertionOrder {
+		if !strings.HasPrefix(key, r.prefix) {
+			continue
+		}
+		ob, err := w.aux(orderBucket)
+		if err != nil {
+			return err
+		}
+		b, err := ob.CreateBucketIfNotExists(orderName(r.prefix))
+		if err != nil {
+			return err
+		}
+		if seq := b.Get(orderKeyKey(key)); seq != nil {
+			if !r.moveToEnd {
+				continue
+			}
+			if err := b.Delete(append([]byte{'s'}, seq...)); err != nil {
+				return err
+			}
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := b.Put(orderSeqKey(seq), []byte(key)); err != nil {
+			return err
+		}
+		if err := b.Put(orderKeyKey(key), orderSeqKey(seq)[1:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropOrder removes key, just deleted, from the insertion orders.
+func (w *wtx) dropOrder(key string) error {
+	ob := w.s.aux(w.tx, orderBucket)
+	if ob == nil {
+		return nil
+	}
+	for _, r := range w.s.opts.insertionOrder {
+		b := ob.Bucket(orderName(r.prefix))
+		if b == nil {
+			continue
+		}
+		seq := b.Get(orderKeyKey(key))
+		if seq == nil {
+			continue
+		}
+		if err := b.Delete(append([]byte{'s'}, seq...)); err != nil {
+			return err
+		}
+		if err := b.Delete(orderKeyKey(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForEachInsertionOrder calls fn for every entry whose key starts with
+// prefix, which must have been given to WithInsertionOrder, in the order
+// the keys were added, with a function decoding the entry's value as Get
+// does. Expired entries, and keys written before the option was given and
+// not since, are left out. The iteration runs in a single read transaction;
+// if fn returns an error, ForEachInsertionOrder stops and returns that
+// error. For other prefixes it returns ErrNoInsertionOrder.
+//
+//	err := store.ForEachInsertionOrder("feed:", func(key string, decode func(interface{}) error) error {
+//	    var e Event
+//	    return decode(&e)
+//	})
+func (s *Store) ForEachInsertionOrder(prefix string, fn func(key string, decode func(interface{}) error) error) error {
+	return s.forEachInsertionOrder(prefix, false, fn)
+}
+
+// ForEachInsertionOrderReverse is ForEachInsertionOrder, visiting the most
+// recently added keys first.
+func (s *Store) ForEachInsertionOrderReverse(prefix string, fn func(key string, decode func(interface{}) error) error) error {
+	return s.forEachInsertionOrder(prefix, true, fn)
+}
+
+func (s *Store) forEachInsertionOrder(prefix string, reverse bool, fn func(key string, decode func(interface{}) error) error) error {
+	if err := s.plainKeys(); err != nil {
+		return err
+	}
+	if !s.ordered(prefix) {
+		return fmt.Errorf("%w %q", ErrNoInsertionOrder, prefix)
+	}
+	return s.view(func(tx *bbolt.Tx) error {
+		ob := s.aux(tx, orderBucket)
+		if ob == nil {
+			return nil
+		}
+		b := ob.Bucket(orderName(prefix))
+		if b == nil {
+			return nil
+		}
+		main := tx.Bucket(s.bucketName)
+		c := b.Cursor()
+		// the 's' entries come after the 'k' ones
+		k, key := c.Seek([]byte{'s'})
+		if reverse {
+			k, key = c.Last()
+		}
+		for ; k != nil && k[0] == 's'; k, key = orderStep(c, reverse) {
+			v := main.Get(key)
+			if v == nil || s.hidden(tx, key) {
+				continue
+			}
+			v, err := s.assemble(tx, key, v)
+			if err != nil {
+				return err
+			}
+			if err := fn(string(key), func(value interface{}) error { return s.decode(v, value) }); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ordered reports whether prefix was given to WithInsertionOrder.
+func (s *Store) ordered(prefix string) bool {
+	for _, r := range s.opts.insertionOrder {
+		if r.prefix == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+func orderStep(c *bbolt.Cursor, reverse bool) ([]byte, []byte) {
+	if reverse {
+		return c.Prev()
+	}
+	return c.Next()
+}
+
+// checkInsertionOrder is the Fsck check of the insertion orders.
+func checkInsertionOrder(f *fsck) {
+	ob := f.s.aux(f.tx, orderBucket)
+	if ob == nil {
+		return
+	}
+	ob.ForEach(func(name, v []byte) error {
+		b := ob.Bucket(name)
+		if v != nil || b == nil {
+			return nil
+		}
+		bucket := orderBucket + " " + string(name[1:])
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			switch {
+			case k[0] == 's' && !f.present(v):
+				f.report(FsckOrphanMetadata, bucket, v, "place of an absent key", orderDropFix(name, k, orderKeyKey(string(v))))
+			case k[0] == 's':
+				if seq := b.Get(orderKeyKey(string(v))); seq == nil || string(seq) != string(k[1:]) {
+					f.report(FsckOrphanIndex, bucket, v, "placed at a sequence number it does not have", orderDropFix(name, k))
+				}
+			case k[0] == 'k':
+				if b.Get(append([]byte{'s'}, v...)) == nil {
+					f.report(FsckOrphanIndex, bucket, k[1:], "sequence number without a place", orderDropFix(name, k))
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// orderDropFix returns a fix deleting the given keys from the insertion
+// order bucket name.
+func orderDropFix(name []byte, keys ...[]byte) func(w *wtx) error {
+	name, keys = append([]byte(nil), name...), copyKeys(keys)
+	return func(w *wtx) error {
+		ob := w.s.aux(w.tx, orderBucket)
+		if ob == nil || ob.Bucket(name) == nil {
+			return nil
+		}
+		b := ob.Bucket(name)
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,188 @@
+package bboltkv
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/unknownnf/bboltkv/testutil"
+	"go.etcd.io/bbolt"
+)
+
+// insertionOrder returns the keys ForEachInsertionOrder visits for prefix,
+// checking that the reverse variant visits them the other way round.
+func insertionOrder(t *testing.T, db *Store, prefix string) []string {
+	t.Helper()
+	keys, reversed := []string{}, []string{}
+	err := db.ForEachInsertionOrder(prefix, func(key string, decode func(interface{}) error) error {
+		var v int
+		if err := decode(&v); err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.ForEachInsertionOrderReverse(prefix, func(key string, _ func(interface{}) error) error {
+		reversed = append([]string{key}, reversed...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, reversed) {
+		t.Fatalf("visited %v, and %v reversed", keys, reversed)
+	}
+	return keys
+}
+
+func checkOrder(t *testing.T, db *Store, prefix string, want ...string) {
+	t.Helper()
+	if want == nil {
+		want = []string{}
+	}
+	if got := insertionOrder(t, db, prefix); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got order %v, expected %v", got, want)
+	}
+}
+
+func TestInsertionOrder(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(name, "test", WithInsertionOrder("feed:"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { db.Close() }()
+	for i, k := range []string{"feed:zz", "feed:aa", "other", "feed:mm"} {
+		if err := db.Put(k, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkOrder(t, db, "feed:", "feed:zz", "feed:aa", "feed:mm")
+	// overwriting keeps the place
+	if err := db.Put("feed:zz", 10); err != nil {
+		t.Fatal(err)
+	}
+	checkOrder(t, db, "feed:", "feed:zz", "feed:aa", "feed:mm")
+	// lexicographic iteration sees the same entries in key order
+	var keys []string
+	err = db.ForEach(func(key string, _ func(interface{}) error) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"feed:aa", "feed:mm", "feed:zz", "other"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("got %v, expected %v", keys, want)
+	}
+
+	// across restarts, new keys go to the end
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Open(name, "test", WithInsertionOrder("feed:")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("feed:bb", 4); err != nil {
+		t.Fatal(err)
+	}
+	checkOrder(t, db, "feed:", "feed:zz", "feed:aa", "feed:mm", "feed:bb")
+
+	if err := db.ForEachInsertionOrder("other", nil); !errors.Is(err, ErrNoInsertionOrder) {
+		t.Fatalf("got %v, expected ErrNoInsertionOrder", err)
+	}
+	checkOrder(t, openTestStore(t, WithInsertionOrder("")), "")
+}
+
+func TestInsertionOrderMoveToEnd(t *testing.T) {
+	db := openTestStore(t, WithInsertionOrderMoveToEnd("feed:"), WithInsertionOrder("all:"))
+	for i, k := range []string{"feed:c", "feed:b", "feed:a", "all:c", "all:b", "all:a"} {
+		if err := db.Put(k, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, k := range []string{"feed:c", "all:c"} {
+		if err := db.Put(k, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkOrder(t, db, "feed:", "feed:b", "feed:a", "feed:c")
+	checkOrder(t, db, "all:", "all:c", "all:b", "all:a")
+	if report, err := db.Fsck(); err != nil || !report.Clean() {
+		t.Fatalf("got %+v, %v", report, err)
+	}
+}
+
+func TestInsertionOrderDelete(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock), WithInsertionOrder("feed:"), WithInsertionOrder("feed:x:"))
+	for i, k := range []string{"feed:x:1", "feed:a", "feed:x:2", "feed:b"} {
+		if err := db.Put(k, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkOrder(t, db, "feed:x:", "feed:x:1", "feed:x:2")
+	if err := db.Delete("feed:x:1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.DeletePrefix("feed:b"); err != nil {
+		t.Fatal(err)
+	}
+	checkOrder(t, db, "feed:", "feed:a", "feed:x:2")
+	checkOrder(t, db, "feed:x:", "feed:x:2")
+	// written again after its deletion, a key goes to the end
+	if err := db.Put("feed:x:1", 5); err != nil {
+		t.Fatal(err)
+	}
+	checkOrder(t, db, "feed:", "feed:a", "feed:x:2", "feed:x:1")
+
+	// expired keys are left out until swept, which removes their place
+	if err := db.PutWithTTL("feed:c", 6, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	checkOrder(t, db, "feed:", "feed:a", "feed:x:2", "feed:x:1")
+	if n, err := db.SweepExpired(); err != nil || n != 1 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	if report, err := db.Fsck(); err != nil || !report.Clean() {
+		t.Fatalf("got %+v, %v", report, err)
+	}
+	if _, err := db.Truncate(); err != nil {
+		t.Fatal(err)
+	}
+	checkOrder(t, db, "feed:")
+	checkOrder(t, db, "feed:x:")
+	if report, err := db.Fsck(); err != nil || !report.Clean() {
+		t.Fatalf("got %+v, %v", report, err)
+	}
+}
+
+func TestInsertionOrderFsck(t *testing.T) {
+	db := openTestStore(t, WithInsertionOrder("feed:"))
+	for i, k := range []string{"feed:b", "feed:a"} {
+		if err := db.Put(k, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := db.RawUpdate(func(b *bbolt.Bucket) error {
+		return b.Delete([]byte("feed:b"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkOrder(t, db, "feed:", "feed:a")
+	report, err := db.FsckRepair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIssues(t, report, "orphan metadata insertion-order feed: feed:b")
+	if report, err := db.Fsck(); err != nil || !report.Clean() {
+		t.Fatalf("got %+v, %v", report, err)
+	}
+}
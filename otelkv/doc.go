@@ -0,0 +1,16 @@
+// Package otelkv reports the operations of bboltkv stores as OpenTelemetry
+// spans, see bboltkv.WithTracer. It is a module of its own, so that
+// bboltkv itself does not depend on OpenTelemetry; only programs importing
+// otelkv do:
+//
+//	go get github.com/unknownnf/bboltkv/otelkv
+//
+//	store, err := bboltkv.Open("data.db", "users",
+//	    bboltkv.WithTracer(otelkv.New(context.Background(), otel.Tracer("users"))))
+//
+// The module requires a published version of bboltkv. To work on both at
+// once in a checkout of bboltkv, build them in a workspace, which git
+// ignores:
+//
+//	go work init . ./otelkv
+package otelkv
@@ -0,0 +1,60 @@
+package otelkv
+
+import (
+	"context"
+	"errors"
+
+	"github.com/unknownnf/bboltkv"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer adapts an OpenTelemetry tracer to bboltkv.Tracer. Spans are named
+// "bboltkv." followed by the operation, and carry the key, if any, in the
+// bboltkv.key attribute. The operations within bboltkv.Store.Update are
+// children of its span.
+//
+// A store's Tracer is fixed when it is opened, so its spans are children of
+// the span of the context given to New, not of the request that caused
+// them.
+type Tracer struct {
+	tracer trace.Tracer
+	ctx    context.Context
+}
+
+var _ bboltkv.TxTracer = (*Tracer)(nil)
+
+// New returns a Tracer starting spans with t, as children of the span of
+// ctx, if it has one.
+func New(ctx context.Context, t trace.Tracer) *Tracer {
+	return &Tracer{tracer: t, ctx: ctx}
+}
+
+// StartSpan implements bboltkv.Tracer.
+func (t *Tracer) StartSpan(op, key string) func(err error) {
+	_, span := t.tracer.Start(t.ctx, "bboltkv."+op)
+	if key != "" {
+		span.SetAttributes(attribute.String("bboltkv.key", key))
+	}
+	return func(err error) { end(span, err) }
+}
+
+// StartTx implements bboltkv.TxTracer.
+func (t *Tracer) StartTx(op string) (bboltkv.Tracer, func(err error)) {
+	ctx, span := t.tracer.Start(t.ctx, "bboltkv."+op)
+	return &Tracer{tracer: t.tracer, ctx: ctx}, func(err error) { end(span, err) }
+}
+
+// end ends span with the result of its operation. A missing key is not an
+// error of the store, so it only sets the bboltkv.not_found attribute.
+func end(span trace.Span, err error) {
+	switch {
+	case errors.Is(err, bboltkv.ErrNotFound):
+		span.SetAttributes(attribute.Bool("bboltkv.not_found", true))
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,128 @@
+package otelkv
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/unknownnf/bboltkv"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// openTraced returns a store whose spans rec records.
+func openTraced(t *testing.T) (*bboltkv.Store, *tracetest.SpanRecorder) {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+	name := filepath.Join(t.TempDir(), "test.db")
+	db, err := bboltkv.Open(name, "test", bboltkv.WithTracer(New(context.Background(), tp.Tracer("test"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, rec
+}
+
+// attr returns the value of the attribute key of span, if it has it.
+func attr(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	return attributeOf(span.Attributes(), key)
+}
+
+func TestSpans(t *testing.T) {
+	db, rec := openTraced(t)
+	if err := db.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	if err := db.Get("a", &v); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get("missing", &v); err != bboltkv.ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+
+	spans := rec.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, expected 3", len(spans))
+	}
+	for i, want := range []struct{ name, key string }{
+		{"bboltkv.Put", "a"},
+		{"bboltkv.Get", "a"},
+		{"bboltkv.Get", "missing"},
+	} {
+		sp := spans[i]
+		if sp.Name() != want.name {
+			t.Errorf("span %d is %q, expected %q", i, sp.Name(), want.name)
+		}
+		if key, ok := attr(sp, "bboltkv.key"); !ok || key.AsString() != want.key {
+			t.Errorf("span %d has key %v, expected %q", i, key.AsString(), want.key)
+		}
+		if sp.Status().Code != codes.Unset {
+			t.Errorf("span %d has status %v", i, sp.Status())
+		}
+	}
+	// a missing key is not an error
+	if _, ok := attr(spans[1], "bboltkv.not_found"); ok {
+		t.Error("found key marked not found")
+	}
+	if nf, ok := attr(spans[2], "bboltkv.not_found"); !ok || !nf.AsBool() {
+		t.Error("missing key not marked not found")
+	}
+	if n := len(spans[2].Events()); n != 0 {
+		t.Errorf("missing key recorded %d events", n)
+	}
+}
+
+func TestTxSpans(t *testing.T) {
+	db, rec := openTraced(t)
+	errFn := errors.New("fn failed")
+	err := db.Update(func(tx *bboltkv.WriteTx) error {
+		if err := tx.InBucket("test").Put("b", 2); err != nil {
+			return err
+		}
+		return errFn
+	})
+	if err != errFn {
+		t.Fatalf("got %v, expected the error of fn", err)
+	}
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, expected 2", len(spans))
+	}
+	put, update := spans[0], spans[1]
+	if put.Name() != "bboltkv.Put" || update.Name() != "bboltkv.Update" {
+		t.Fatalf("got spans %q and %q", put.Name(), update.Name())
+	}
+	if put.Parent().SpanID() != update.SpanContext().SpanID() {
+		t.Error("Put is not a child of Update")
+	}
+	if _, ok := attr(update, "bboltkv.key"); ok {
+		t.Error("Update has a key")
+	}
+	if st := update.Status(); st.Code != codes.Error || st.Description != errFn.Error() {
+		t.Errorf("Update has status %v", st)
+	}
+	events := update.Events()
+	if len(events) != 1 || events[0].Name != "exception" {
+		t.Fatalf("Update recorded events %v, expected the error", events)
+	}
+	if msg, ok := attributeOf(events[0].Attributes, "exception.message"); !ok || msg.AsString() != errFn.Error() {
+		t.Errorf("recorded error %q", msg.AsString())
+	}
+}
+
+// attributeOf returns the value of key among kvs, if it is there.
+func attributeOf(kvs []attribute.KeyValue, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range kvs {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
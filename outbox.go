@@ -0,0 +1,121 @@
+package bboltkv
+
+import (
+	"encoding/binary"
+
+	"go.etcd.io/bbolt"
+)
+
+const outboxBucket = "outbox"
+
+// outboxBatch is the number of events ConsumeOutbox reads per transaction.
+const outboxBatch = 100
+
+// PutWithEvent puts an entry into the store like Put, and in the same
+// transaction appends event to the store's outbox, so that either both are
+// stored or neither is. Events are numbered in the order they are added and
+// stay in the outbox until ConsumeOutbox has delivered them. The event
+// cannot be nil.
+//
+//	event, _ := json.Marshal(OrderPlaced{ID: order.ID})
+//	err := store.PutWithEvent("order:"+order.ID, order, event)
+func (s *Store) PutWithEvent(key string, value interface{}, event []byte) error {
+	if err := s.plainKeys(); err != nil {
+		return err
+	}
+	if event == nil {
+		return ErrBadValue
+	}
+	raw, err := s.encodeForPut(key, value)
+	if err != nil {
+		return err
+	}
+	return s.update(func(w *wtx) error {
+		if err := w.put(key, raw); err != nil {
+			return err
+		}
+		b, err := w.aux(outboxBucket)
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := b.Put(outboxKey(seq), event); err != nil {
+			return err
+		}
+		return w.journaled(outboxBucket, seq)
+	})
+}
+
+func outboxKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
+
+// ConsumeOutbox calls fn for the events in the outbox, in the order they
+// were added, with their sequence number. Each event is deleted from the
+// outbox once fn has returned nil for it. If fn returns an error,
+// ConsumeOutbox stops and returns that error, and the event is delivered
+// again by the next call; so is every event whose deletion did not make it
+// to disk because the process died. Events are thus delivered at least
+// once, and fn should tolerate duplicates, such as by ignoring sequence
+// numbers it has seen.
+//
+// fn runs outside of any transaction, so it may take its time without
+// holding up other writers. Calls to ConsumeOutbox are serialized, so that
+// concurrent consumers do not deliver the same events.
+//
+//	err := store.ConsumeOutbox(func(seq uint64, event []byte) error {
+//	    return bus.Publish(event)
+//	})
+func (s *Store) ConsumeOutbox(fn func(seq uint64, event []byte) error) error {
+	s.outboxMu.Lock()
+	defer s.outboxMu.Unlock()
+	for {
+		var seqs []uint64
+		var events [][]byte
+		err := s.view(func(tx *bbolt.Tx) error {
+			b := s.aux(tx, outboxBucket)
+			if b == nil {
+				return nil
+			}
+			c := b.Cursor()
+			for k, v := c.First(); k != nil && len(seqs) < outboxBatch; k, v = c.Next() {
+				seqs = append(seqs, binary.BigEndian.Uint64(k))
+				events = append(events, append([]byte(nil), v...))
+			}
+			return nil
+		})
+		if err != nil || len(seqs) == 0 {
+			return err
+		}
+		acked := 0
+		var fnErr error
+		for i, seq := range seqs {
+			if fnErr = fn(seq, events[i]); fnErr != nil {
+				break
+			}
+			acked++
+		}
+		if acked > 0 {
+			err = s.update(func(w *wtx) error {
+				b := s.aux(w.tx, outboxBucket)
+				for _, seq := range seqs[:acked] {
+					if err := b.Delete(outboxKey(seq)); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if fnErr != nil {
+			return fnErr
+		}
+	}
+}
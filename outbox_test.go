@@ -0,0 +1,150 @@
+package bboltkv
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// outboxLen returns the number of events waiting in the outbox.
+func outboxLen(t *testing.T, db *Store) int {
+	t.Helper()
+	n := 0
+	err := db.GetDb().View(func(tx *bbolt.Tx) error {
+		if b := db.aux(tx, outboxBucket); b != nil {
+			n = b.Stats().KeyN
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestPutWithEventAtomic(t *testing.T) {
+	db := openTestStore(t, WithPutValidator(noSpaces))
+	if err := db.PutWithEvent("key", "value", []byte("created")); err != nil {
+		t.Fatal(err)
+	}
+	var val string
+	if err := db.Get("key", &val); err != nil || val != "value" {
+		t.Fatalf("got %q, %v", val, err)
+	}
+	if n := outboxLen(t, db); n != 1 {
+		t.Fatalf("got %d events, expected 1", n)
+	}
+
+	// whatever stops the value from being stored stops the event too
+	if err := db.PutWithEvent("bad key", "value", []byte("created")); err != errBadKey {
+		t.Fatalf("got %v, expected errBadKey", err)
+	}
+	if err := db.PutWithEvent("other", nil, []byte("created")); err != ErrBadValue {
+		t.Fatalf("got %v, expected ErrBadValue", err)
+	}
+	if err := db.PutWithEvent("other", "value", nil); err != ErrBadValue {
+		t.Fatalf("got %v, expected ErrBadValue", err)
+	}
+	db.setReadOnly()
+	if err := db.PutWithEvent("other", "value", []byte("created")); err != ErrReadOnly {
+		t.Fatalf("got %v, expected ErrReadOnly", err)
+	}
+	if n := outboxLen(t, db); n != 1 {
+		t.Fatalf("got %d events, expected 1", n)
+	}
+	if err := db.Get("other", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+}
+
+func TestConsumeOutbox(t *testing.T) {
+	db := openTestStore(t)
+	var want []string
+	for i := 0; i < 250; i++ {
+		event := fmt.Sprintf("event%d", i)
+		want = append(want, event)
+		if err := db.PutWithEvent(fmt.Sprintf("key%d", i), i, []byte(event)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	var last uint64
+	err := db.ConsumeOutbox(func(seq uint64, event []byte) error {
+		if seq <= last {
+			t.Errorf("seq %d after %d", seq, last)
+		}
+		last = seq
+		got = append(got, string(event))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %d events, expected %d in order", len(got), len(want))
+	}
+	// consumed events are pruned
+	if n := outboxLen(t, db); n != 0 {
+		t.Fatalf("got %d events left, expected 0", n)
+	}
+	err = db.ConsumeOutbox(func(uint64, []byte) error {
+		t.Error("event delivered twice")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConsumeOutboxRedelivery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := db.PutWithEvent(fmt.Sprintf("key%d", i), i, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	failed := errors.New("bus unavailable")
+	var got []byte
+	err = db.ConsumeOutbox(func(seq uint64, event []byte) error {
+		if event[0] == 2 {
+			return failed
+		}
+		got = append(got, event[0])
+		return nil
+	})
+	if err != failed {
+		t.Fatalf("got %v, expected the error of fn", err)
+	}
+	if !reflect.DeepEqual(got, []byte{0, 1}) {
+		t.Fatalf("got %v, expected [0 1]", got)
+	}
+
+	// unacked events survive a restart
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(path, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	got = nil
+	err = db.ConsumeOutbox(func(seq uint64, event []byte) error {
+		got = append(got, event[0])
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []byte{2, 3, 4}) {
+		t.Fatalf("got %v, expected [2 3 4]", got)
+	}
+}
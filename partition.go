@@ -0,0 +1,271 @@
+package bboltkv
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// ErrNoPartition is returned by the methods of a PartitionedStore when the
+// partition function does not assign the key to any partition.
+var ErrNoPartition = errors.New("bboltkv: key has no partition")
+
+// PartitionedStore is a key-value store that spreads its entries over
+// partitions, each kept in its own bucket, so that a whole partition can be
+// dropped at once rather than deleting its entries one by one. Use the
+// OpenPartitioned() function to create one, and Close() it when done.
+//
+// Partitions are nested buckets inside the base bucket, named after the
+// partition. Apart from the partitions, the base bucket is left empty.
+type PartitionedStore struct {
+	s         *Store
+	partition func(key string) string
+}
+
+// DatePartition is the default partition function of OpenPartitioned. It
+// assigns keys starting with a date in the form "2006-01-02" to a partition
+// named after the date, so that each day gets its own partition. Other keys
+// get no partition.
+func DatePartition(key string) string {
+	if len(key) < 10 {
+		return ""
+	}
+	if _, err := time.Parse("2006-01-02", key[:10]); err != nil {
+		return ""
+	}
+	return key[:10]
+}
+
+// OpenPartitioned opens a partitioned key-value store, see Open. The
+// partition function returns the name of the partition a key belongs to, or
+// "" if it belongs to none, in which case writing the key fails with
+// ErrNoPartition. It must always return the same partition for a key. If it
+// is nil, DatePartition is used.
+//
+//	store, err := bboltkv.OpenPartitioned("events.db", "events", nil)
+//	err = store.Put("2024-05-01T12:00:00Z/login", event)
+//	// a month later
+//	err = store.DropPartition("2024-05-01")
+func OpenPartitioned(path, baseBucket string, partitionFn func(key string) string) (*PartitionedStore, error) {
+	if partitionFn == nil {
+		partitionFn = DatePartition
+	}
+	s, err := Open(path, baseBucket)
+	if err != nil {
+		return nil, err
+	}
+	return &PartitionedStore{s: s, partition: partitionFn}, nil
+}
+
+// Close closes the store, see Store.Close.
+func (p *PartitionedStore) Close() error {
+	return p.s.Close()
+}
+
+// GetDb returns the underlying bbolt database.
+func (p *PartitionedStore) GetDb() *bbolt.DB {
+	return p.s.GetDb()
+}
+
+// Put an entry into the partition of its key, creating the partition if
+// needed. As with Store.Put, the value is gob-encoded and cannot be nil.
+func (p *PartitionedStore) Put(key string, value interface{}) error {
+	name := p.partition(key)
+	if name == "" {
+		return ErrNoPartition
+	}
+	raw, err := p.s.encodeForPut(key, value)
+	if err != nil {
+		return err
+	}
+	return p.s.update(func(w *wtx) error {
+		b, err := w.b.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), raw)
+	})
+}
+
+// Get an entry from the partition of its key, see Store.Get.
+func (p *PartitionedStore) Get(key string, value interface{}) error {
+	name := p.partition(key)
+	if name == "" {
+		return ErrNotFound
+	}
+	return p.s.view(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(p.s.bucketName).Bucket([]byte(name))
+		if b == nil {
+			return ErrNotFound
+		}
+		raw := b.Get([]byte(key))
+		if raw == nil {
+			return ErrNotFound
+		}
+		return p.s.decode(raw, value)
+	})
+}
+
+// Delete the entry with the given key. If no such key is present in the
+// store, it returns ErrNotFound.
+func (p *PartitionedStore) Delete(key string) error {
+	name := p.partition(key)
+	if name == "" {
+		return ErrNotFound
+	}
+	return p.s.update(func(w *wtx) error {
+		b := w.b.Bucket([]byte(name))
+		if b == nil || b.Get([]byte(key)) == nil {
+			return ErrNotFound
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+// ListPartitions returns the names of all partitions, in lexicographic
+// order.
+func (p *PartitionedStore) ListPartitions() ([]string, error) {
+	var names []string
+	err := p.s.view(func(tx *bbolt.Tx) error {
+		return tx.Bucket(p.s.bucketName).ForEach(func(k, v []byte) error {
+			if v == nil {
+				names = append(names, string(k))
+			}
+			return nil
+		})
+	})
+	return names, err
+}
+
+// DropPartition deletes the named partition with all its entries. This
+// frees the partition's pages without visiting its entries one by one. It
+// returns ErrNotFound if there is no such partition.
+func (p *PartitionedStore) DropPartition(name string) error {
+	return p.s.update(func(w *wtx) error {
+		err := w.b.DeleteBucket([]byte(name))
+		if err == bbolt.ErrBucketNotFound || err == bbolt.ErrIncompatibleValue {
+			return ErrNotFound
+		}
+		return err
+	})
+}
+
+// Range calls fn for every entry with a key from "from" up to, but not
+// including, "to", in key order across all partitions. An empty "to" means
+// there is no upper bound. fn receives the key and a function that decodes
+// the value into a pointer-typed value; returning an error from fn stops
+// the iteration and returns that error.
+//
+//	err := store.Range("2024-05-01", "2024-05-08", func(key string, decode func(interface{}) error) error {
+//	    var e Event
+//	    return decode(&e)
+//	})
+func (p *PartitionedStore) Range(from, to string, fn func(key string, decode func(interface{}) error) error) error {
+	return p.s.view(func(tx *bbolt.Tx) error {
+		m, err := p.merge(tx, []byte(from))
+		if err != nil {
+			return err
+		}
+		for m.Len() > 0 {
+			k, v := m.next()
+			if to != "" && bytes.Compare(k, []byte(to)) >= 0 {
+				return nil
+			}
+			if err := fn(string(k), func(value interface{}) error { return p.s.decode(v, value) }); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Count returns the number of entries in all partitions.
+func (p *PartitionedStore) Count() (int, error) {
+	stats, err := p.Stats()
+	n := 0
+	for _, st := range stats {
+		n += st.Keys
+	}
+	return n, err
+}
+
+// PartitionStat describes one partition of a PartitionedStore.
+type PartitionStat struct {
+	Name string
+	Keys int
+}
+
+// Stats returns the number of entries in each partition, in the order of
+// the partition names.
+func (p *PartitionedStore) Stats() ([]PartitionStat, error) {
+	var stats []PartitionStat
+	err := p.s.view(func(tx *bbolt.Tx) error {
+		base := tx.Bucket(p.s.bucketName)
+		return base.ForEach(func(k, v []byte) error {
+			if v != nil {
+				return nil
+			}
+			stats = append(stats, PartitionStat{
+				Name: string(k),
+				Keys: base.Bucket(k).Stats().KeyN,
+			})
+			return nil
+		})
+	})
+	return stats, err
+}
+
+// merge returns cursors over all partitions, positioned at the first key
+// not below from, merged into key order.
+func (p *PartitionedStore) merge(tx *bbolt.Tx, from []byte) (*mergeCursor, error) {
+	m := &mergeCursor{}
+	base := tx.Bucket(p.s.bucketName)
+	err := base.ForEach(func(name, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		c := base.Bucket(name).Cursor()
+		if k, v := c.Seek(from); k != nil {
+			m.heads = append(m.heads, mergeHead{c, k, v})
+		}
+		return nil
+	})
+	heap.Init(m)
+	return m, err
+}
+
+type mergeHead struct {
+	c    *bbolt.Cursor
+	k, v []byte
+}
+
+// mergeCursor is a heap of cursors, ordered by their current key.
+type mergeCursor struct {
+	heads []mergeHead
+}
+
+func (m *mergeCursor) Len() int           { return len(m.heads) }
+func (m *mergeCursor) Less(i, j int) bool { return bytes.Compare(m.heads[i].k, m.heads[j].k) < 0 }
+func (m *mergeCursor) Swap(i, j int)      { m.heads[i], m.heads[j] = m.heads[j], m.heads[i] }
+func (m *mergeCursor) Push(x interface{}) { m.heads = append(m.heads, x.(mergeHead)) }
+func (m *mergeCursor) Pop() interface{} {
+	h := m.heads[len(m.heads)-1]
+	m.heads = m.heads[:len(m.heads)-1]
+	return h
+}
+
+// next returns the smallest current key with its value, and advances the
+// cursor it came from.
+func (m *mergeCursor) next() ([]byte, []byte) {
+	h := &m.heads[0]
+	k, v := h.k, h.v
+	if h.k, h.v = h.c.Next(); h.k == nil {
+		heap.Pop(m)
+	} else {
+		heap.Fix(m, 0)
+	}
+	return k, v
+}
@@ -0,0 +1,148 @@
+package bboltkv
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func openTestPartitioned(t *testing.T, partitionFn func(string) string) *PartitionedStore {
+	t.Helper()
+	p, err := OpenPartitioned(filepath.Join(t.TempDir(), "test.db"), "test", partitionFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func TestPartitionRouting(t *testing.T) {
+	p := openTestPartitioned(t, nil)
+	for _, key := range []string{"2024-05-01/a", "2024-05-01/b", "2024-05-02/a"} {
+		if err := p.Put(key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Put("nodate", "x"); err != ErrNoPartition {
+		t.Fatalf("got %v, expected ErrNoPartition", err)
+	}
+	var val string
+	if err := p.Get("2024-05-02/a", &val); err != nil || val != "2024-05-02/a" {
+		t.Fatalf("got %q, %v", val, err)
+	}
+	if err := p.Get("2024-05-03/a", &val); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	names, err := p.ListPartitions()
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(names, []string{"2024-05-01", "2024-05-02"}) {
+		t.Fatalf("got partitions %v", names)
+	}
+	// the entries really are in their partition's bucket
+	err = p.GetDb().View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("test")).Bucket([]byte("2024-05-01"))
+		if b == nil || b.Get([]byte("2024-05-01/b")) == nil || b.Get([]byte("2024-05-02/a")) != nil {
+			t.Error("entries not routed to their partition")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Delete("2024-05-01/a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Delete("2024-05-01/a"); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+}
+
+func TestPartitionRange(t *testing.T) {
+	// a partitioner whose order differs from key order, so the merge matters
+	p := openTestPartitioned(t, func(key string) string { return key[len(key)-1:] })
+	var want []string
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key%02d-%c", i, 'a'+i%3)
+		want = append(want, key)
+		if err := p.Put(key, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	collect := func(from, to string) []string {
+		var keys []string
+		err := p.Range(from, to, func(key string, decode func(interface{}) error) error {
+			var i int
+			if err := decode(&i); err != nil {
+				return err
+			} else if key != want[i] {
+				t.Errorf("key %s has value %d", key, i)
+			}
+			keys = append(keys, key)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keys
+	}
+	if got := collect("", ""); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v", got)
+	}
+	if got := collect("key10", "key20"); !reflect.DeepEqual(got, want[10:20]) {
+		t.Fatalf("got %v", got)
+	}
+	if got := collect("key25", "key10"); len(got) != 0 {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestPartitionDrop(t *testing.T) {
+	p := openTestPartitioned(t, nil)
+	for _, day := range []string{"2024-05-01", "2024-05-02", "2024-05-03"} {
+		for i := 0; i < 100; i++ {
+			if err := p.Put(fmt.Sprintf("%s/%03d", day, i), i); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if n, err := p.Count(); err != nil || n != 300 {
+		t.Fatalf("got count %d, %v, expected 300", n, err)
+	}
+	if err := p.DropPartition("2024-05-02"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.DropPartition("2024-05-02"); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+
+	if n, err := p.Count(); err != nil || n != 200 {
+		t.Fatalf("got count %d, %v, expected 200", n, err)
+	}
+	stats, err := p.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PartitionStat{{"2024-05-01", 100}, {"2024-05-03", 100}}
+	if !reflect.DeepEqual(stats, want) {
+		t.Fatalf("got stats %v, expected %v", stats, want)
+	}
+	var val int
+	if err := p.Get("2024-05-02/050", &val); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if err := p.Get("2024-05-03/050", &val); err != nil || val != 50 {
+		t.Fatalf("got %d, %v", val, err)
+	}
+	n := 0
+	err = p.Range("2024-05-02", "2024-05-03", func(string, func(interface{}) error) error {
+		n++
+		return nil
+	})
+	if err != nil || n != 0 {
+		t.Fatalf("range over dropped day found %d entries, %v", n, err)
+	}
+}
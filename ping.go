@@ -0,0 +1,65 @@
+package bboltkv
+
+import (
+	"context"
+
+	"go.etcd.io/bbolt"
+)
+
+// probeBucket holds the key PingWrite writes and deletes again.
+const probeBucket = "probe"
+
+// Ping checks that the store can be read, for readiness probes: it reads
+// the first entry of the store's bucket in a read transaction. It returns
+// ErrClosed if the store is closed, ErrTimeout if ctx's deadline passes
+// first, or ctx's error if ctx is cancelled first, and otherwise the error
+// of the read, if any.
+//
+// Reads do not wait for writes, so Ping only waits when bbolt is growing
+// its memory map. A read that has begun cannot be aborted; one that Ping
+// gave up on runs to completion in the background.
+//
+//	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+//	defer cancel()
+//	if err := store.Ping(ctx); err != nil {
+//	    http.Error(w, err.Error(), http.StatusServiceUnavailable)
+//	}
+func (s *Store) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return contextError(ctx)
+	}
+	c := make(chan error, 1)
+	go func() {
+		c <- s.view(func(tx *bbolt.Tx) error {
+			tx.Bucket(s.bucketName).Cursor().First()
+			return nil
+		})
+	}()
+	select {
+	case err := <-c:
+		return err
+	case <-ctx.Done():
+		return contextError(ctx)
+	}
+}
+
+// PingWrite checks that the store can be written to, like Ping does for
+// reads: it writes a probe key and deletes it again, in a transaction of
+// its own that it commits. The key lives in an internal bucket, so it never
+// shows up among the store's entries, and is not recorded in the change
+// log. Like a write with WithOpTimeout, PingWrite gives up with ErrTimeout
+// if the transaction cannot begin before ctx's deadline, which it cannot
+// while another write is running, and returns ErrOverrun if the commit
+// finished after it. A read-only store fails with ErrReadOnly.
+func (s *Store) PingWrite(ctx context.Context) error {
+	return s.updateContext(ctx, func(w *wtx) error {
+		b, err := w.aux(probeBucket)
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("ping"), []byte{1}); err != nil {
+			return err
+		}
+		return b.Delete([]byte("ping"))
+	})
+}
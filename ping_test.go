@@ -0,0 +1,92 @@
+package bboltkv
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestPing(t *testing.T) {
+	db := openTestStore(t)
+	ctx := context.Background()
+	if err := db.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PingWrite(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := db.Ping(cancelled); err != context.Canceled {
+		t.Fatalf("got %v, expected context.Canceled", err)
+	}
+	db.Close()
+	if err := db.Ping(ctx); err != ErrClosed {
+		t.Fatalf("got %v, expected ErrClosed", err)
+	}
+	if err := db.PingWrite(ctx); err != ErrClosed {
+		t.Fatalf("got %v, expected ErrClosed", err)
+	}
+}
+
+func TestPingDuringWrite(t *testing.T) {
+	db := openTestStore(t)
+	began := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- db.GetDb().Update(func(*bbolt.Tx) error {
+			close(began)
+			<-release
+			return nil
+		})
+	}()
+	<-began
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	// reads do not wait for the write
+	if err := db.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := db.PingWrite(ctx); err != ErrTimeout {
+		t.Fatalf("got %v, expected ErrTimeout", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("PingWrite took %v", d)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PingWrite(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPingWriteInvisible(t *testing.T) {
+	db := openTestStore(t, WithChangeLog())
+	fillStore(t, db, 3)
+	for i := 0; i < 3; i++ {
+		if err := db.PingWrite(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := db.Count(); err != nil || n != 3 {
+		t.Fatalf("%d entries, %v", n, err)
+	}
+	changes := 0
+	if err := db.Changes(0, func(Change) error { changes++; return nil }); err != nil || changes != 3 {
+		t.Fatalf("%d changes, %v", changes, err)
+	}
+	var buf bytes.Buffer
+	if err := db.ExportJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("ping")) {
+		t.Fatalf("export holds the probe key: %s", buf.Bytes())
+	}
+}
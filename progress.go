@@ -0,0 +1,206 @@
+package bboltkv
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrReentrant is returned by store methods called from within a Progress
+// callback, or the function given to RawView or RawUpdate, and by the
+// operation that invoked it.
+var ErrReentrant = errors.New("bboltkv: store called from a callback")
+
+// Progress is called by long-running operations, such as ExportJSON and
+// Merge, to report how far they have got: processed is the number of
+// entries handled so far, total the number they will handle in all, or -1
+// if that is not known up front, and key the last key handled.
+//
+// A Progress callback may be invoked while the operation holds a
+// transaction open, so it must not call back into the store that invoked
+// it: any method of that store called from the callback fails with
+// ErrReentrant, and so does the operation itself. Callbacks may use other
+// stores, and other goroutines may use the store meanwhile.
+type Progress func(processed, total int, key string)
+
+// OpOption configures a single call of a long-running operation.
+type OpOption func(*opOptions)
+
+type opOptions struct {
+	progress    Progress
+	every       int
+	checkpoint  int
+	sizeBuckets []int64
+	prefix      string
+
+	lastWriteWins bool
+	skipExisting  bool
+	replicaName   string
+	stopWords     []string
+
+	skipMalformed bool
+	skipped       *int
+
+	bearerToken string
+	pullError   func(err error)
+
+	sortedKeys bool
+
+	batchReport func(size int, took time.Duration)
+}
+
+func buildOpOptions(opts []OpOption) opOptions {
+	var o opOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithProgress makes a long-running operation call fn after every "every"
+// entries, and once more at the end unless the last call already covered
+// all entries.
+//
+//	err := store.ExportJSON(w, bboltkv.WithProgress(func(n, total int, key string) {
+//	    log.Printf("exported %d of %d entries", n, total)
+//	}, 10000))
+func WithProgress(fn Progress, every int) OpOption {
+	return func(o *opOptions) {
+		o.progress = fn
+		o.every = every
+	}
+}
+
+// progress tracks the entries handled by one call of an operation.
+type progress struct {
+	s        *Store
+	fn       Progress
+	every    int
+	total    int
+	n        int
+	key      string // last key handled
+	reported int
+	batch    *batcher // sizes the batches of operations writing them
+}
+
+func (s *Store) newProgress(o opOptions, total int) *progress {
+	every := o.every
+	if every <= 0 {
+		every = 1
+	}
+	return &progress{
+		s:     s,
+		fn:    o.progress,
+		every: every,
+		total: total,
+		batch: s.newBatcher(importBatch, o.batchReport),
+	}
+}
+
+// step counts one entry, and reports progress if it is due.
+func (p *progress) step(key string) error {
+	p.n++
+	p.key = key
+	if p.n%p.every == 0 {
+		return p.report()
+	}
+	return nil
+}
+
+// done reports progress at the end of the operation, unless it has been
+// reported already.
+func (p *progress) done() error {
+	if p.n == p.reported {
+		return nil
+	}
+	return p.report()
+}
+
+func (p *progress) report() error {
+	p.reported = p.n
+	if p.fn == nil {
+		return nil
+	}
+	return p.s.callback(func() { p.fn(p.n, p.total, p.key) })
+}
+
+// callbacks tracks the goroutines running Progress callbacks, so that calls
+// they make into the store can be turned away. Goroutines are told apart by
+// the ids in their stack traces; should the runtime ever print them
+// differently, reentrant calls go undetected rather than calls from other
+// goroutines being refused.
+type callbacks struct {
+	n     int32    // goroutines in callbacks
+	inCbs sync.Map // goroutine id -> *int32, set to 1 on a reentrant call
+}
+
+// callback runs fn as a Progress callback, returning ErrReentrant if it
+// called into the store.
+func (s *Store) callback(fn func()) error {
+	id, ok := goroutineID()
+	if !ok {
+		// calls fn makes cannot be told from those of other goroutines
+		fn()
+		return nil
+	}
+	var reentered int32
+	s.callbacks.inCbs.Store(id, &reentered)
+	atomic.AddInt32(&s.callbacks.n, 1)
+	defer func() {
+		atomic.AddInt32(&s.callbacks.n, -1)
+		s.callbacks.inCbs.Delete(id)
+	}()
+	fn()
+	if atomic.LoadInt32(&reentered) != 0 {
+		return ErrReentrant
+	}
+	return nil
+}
+
+// inCallback reports whether the calling goroutine is running a Progress
+// callback of the store, and marks the callback as having reentered it.
+func (s *Store) inCallback() bool {
+	if atomic.LoadInt32(&s.callbacks.n) == 0 {
+		return false
+	}
+	id, ok := goroutineID()
+	if !ok {
+		return false
+	}
+	flag, ok := s.callbacks.inCbs.Load(id)
+	if ok {
+		atomic.StoreInt32(flag.(*int32), 1)
+	}
+	return ok
+}
+
+// goroutineID returns the id of the calling goroutine, which the runtime
+// only reveals in stack traces, or false if the trace does not start as
+// expected.
+func goroutineID() (int64, bool) {
+	var buf [64]byte
+	return parseGoroutineID(buf[:runtime.Stack(buf[:], false)])
+}
+
+// parseGoroutineID reads the id of a goroutine from the start of its stack
+// trace, "goroutine 42 [running]:". Ids start at 1, so 0 is never valid.
+func parseGoroutineID(stack []byte) (int64, bool) {
+	const prefix = "goroutine "
+	if !bytes.HasPrefix(stack, []byte(prefix)) {
+		return 0, false
+	}
+	b := stack[len(prefix):]
+	i := bytes.IndexByte(b, ' ')
+	if i < 0 {
+		return 0, false
+	}
+	id, err := strconv.ParseInt(string(b[:i]), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, false
+	}
+	return id, true
+}
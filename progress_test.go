@@ -0,0 +1,145 @@
+package bboltkv
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+)
+
+type progressCall struct {
+	n, total int
+	key      string
+}
+
+func recordProgress(calls *[]progressCall) Progress {
+	return func(n, total int, key string) {
+		*calls = append(*calls, progressCall{n, total, key})
+	}
+}
+
+func fillStore(t testing.TB, db *Store, n int) {
+	t.Helper()
+	entries := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		entries[keyN(i)] = i
+	}
+	if err := db.PutAll(entries); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func keyN(i int) string {
+	return fmt.Sprintf("k%05d", i)
+}
+
+func TestProgressExport(t *testing.T) {
+	db := openTestStore(t)
+	fillStore(t, db, 10000)
+	var calls []progressCall
+	if err := db.ExportJSON(io.Discard, WithProgress(recordProgress(&calls), 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 10 {
+		t.Fatalf("got %d calls, expected 10", len(calls))
+	}
+	for i, c := range calls {
+		if c.n != (i+1)*1000 || c.total != 10000 || c.key != keyN(c.n-1) {
+			t.Fatalf("call %d: got %+v", i, c)
+		}
+	}
+
+	// a final call covers the remainder
+	calls = nil
+	if err := db.ExportJSON(io.Discard, WithProgress(recordProgress(&calls), 3000)); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 4 || calls[3].n != 10000 {
+		t.Fatalf("got %+v", calls)
+	}
+}
+
+func TestProgressMerge(t *testing.T) {
+	src := openTestStore(t)
+	fillStore(t, src, 2500)
+	dst := openTestStore(t)
+	var calls []progressCall
+	n, err := dst.Merge(src, WithProgress(recordProgress(&calls), 500))
+	if err != nil {
+		t.Fatal(err)
+	} else if n != 2500 {
+		t.Fatalf("merged %d entries, expected 2500", n)
+	}
+	if len(calls) != 5 || calls[4] != (progressCall{2500, 2500, keyN(2499)}) {
+		t.Fatalf("got %+v", calls)
+	}
+	if count, err := dst.Count(); err != nil || count != 2500 {
+		t.Fatalf("got %d, %v, expected 2500", count, err)
+	}
+}
+
+func TestProgressReentrant(t *testing.T) {
+	db := openTestStore(t)
+	fillStore(t, db, 10)
+	var inner error
+	err := db.ExportJSON(io.Discard, WithProgress(func(int, int, string) {
+		inner = db.Get(keyN(0), nil)
+	}, 5))
+	if err != ErrReentrant {
+		t.Fatalf("export returned %v, expected ErrReentrant", err)
+	}
+	if inner != ErrReentrant {
+		t.Fatalf("callback's Get returned %v, expected ErrReentrant", inner)
+	}
+
+	// other goroutines may use the store meanwhile
+	err = db.ExportJSON(io.Discard, WithProgress(func(int, int, string) {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := db.Get(keyN(0), nil); err != nil {
+				t.Error(err)
+			}
+		}()
+		wg.Wait()
+	}, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// and the store works normally afterwards
+	if err := db.Get(keyN(0), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseGoroutineID(t *testing.T) {
+	for stack, want := range map[string]int64{
+		"goroutine 42 [running]:\nmain.main()": 42,
+		"goroutine 1 [running]:":               1,
+		"goroutine 0 [running]:":               0,
+		"goroutine -3 [running]:":              0,
+		"goroutine x [running]:":               0,
+		"goroutine 42":                         0,
+		"thread 42 [running]:":                 0,
+		"":                                     0,
+	} {
+		id, ok := parseGoroutineID([]byte(stack))
+		if id != want || ok != (want != 0) {
+			t.Errorf("%q: got %d, %t, expected %d", stack, id, ok, want)
+		}
+	}
+
+	// the goroutines of the running program are told apart
+	ids := make(chan int64, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			id, _ := goroutineID()
+			ids <- id
+		}()
+	}
+	a, b := <-ids, <-ids
+	if a == 0 || b == 0 || a == b {
+		t.Fatalf("got ids %d and %d", a, b)
+	}
+}
@@ -0,0 +1,178 @@
+package bboltkv
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.etcd.io/bbolt"
+)
+
+// protectBucket holds the protected keys, prefixed with 'k', and the
+// protected prefixes, prefixed with 'p'.
+const protectBucket = "protected"
+
+// ErrProtected is returned when deleting a key protected with Protect or
+// ProtectPrefix, or giving it a TTL.
+var ErrProtected = errors.New("bboltkv: key is protected")
+
+// protection keeps the protected keys and prefixes in memory, so that
+// checking them is cheap. It is loaded when the store is opened, and
+// updated whenever a change to them commits, through any of the stores
+// sharing it, see bucketState.
+type protection struct {
+	n        int32 // protected keys and prefixes, accessed atomically
+	mu       sync.RWMutex
+	keys     map[string]bool
+	prefixes map[string]bool
+}
+
+// protected reports whether key may not be deleted.
+func (s *Store) protected(key string) bool {
+	p := &s.state.protection
+	if atomic.LoadInt32(&p.n) == 0 {
+		return false
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.keys[key] {
+		return true
+	}
+	for prefix := range p.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadProtection reads the protected keys and prefixes from the file.
+func (s *Store) loadProtection(tx *bbolt.Tx) {
+	s.setProtection(s.readProtection(tx))
+}
+
+func (s *Store) readProtection(tx *bbolt.Tx) (keys, prefixes map[string]bool) {
+	keys = make(map[string]bool)
+	prefixes = make(map[string]bool)
+	if b := s.aux(tx, protectBucket); b != nil {
+		b.ForEach(func(k, _ []byte) error {
+			if k[0] == 'k' {
+				keys[string(k[1:])] = true
+			} else {
+				prefixes[string(k[1:])] = true
+			}
+			return nil
+		})
+	}
+	return keys, prefixes
+}
+
+func (s *Store) setProtection(keys, prefixes map[string]bool) {
+	p := &s.state.protection
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys, p.prefixes = keys, prefixes
+	atomic.StoreInt32(&p.n, int32(len(keys)+len(prefixes)))
+}
+
+// changeProtection adds or removes protection entries, each a kind byte
+// followed by the key or prefix, and reloads them once the change commits.
+func (s *Store) changeProtection(add bool, entries ...string) error {
+	return s.update(func(w *wtx) error {
+		b, err := w.aux(protectBucket)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if !add {
+				err = b.Delete([]byte(e))
+			} else if err = b.Put([]byte(e), nil); err == nil {
+				err = w.unexpire(e[0] == 'p', e[1:])
+			}
+			if err != nil {
+				return err
+			}
+		}
+		keys, prefixes := s.readProtection(w.tx)
+		w.tx.OnCommit(func() { s.setProtection(keys, prefixes) })
+		return nil
+	})
+}
+
+// unexpire removes the TTLs of a newly protected key, or of all keys with a
+// newly protected prefix.
+func (w *wtx) unexpire(prefix bool, key string) error {
+	if !prefix {
+		return w.dropExpiry(key)
+	}
+	b := w.s.aux(w.tx, expiryBucket)
+	if b == nil {
+		return nil
+	}
+	var keys []string
+	c := b.Cursor()
+	for k, _ := c.Seek([]byte(key)); k != nil && strings.HasPrefix(string(k), key); k, _ = c.Next() {
+		keys = append(keys, string(k))
+	}
+	for _, k := range keys {
+		if err := w.dropExpiry(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Protect protects the given keys from deletion: Delete and the other
+// methods deleting single keys return ErrProtected for them, DeletePrefix
+// and DeleteWhere fail with ErrProtected without deleting anything if they
+// would delete one, and Truncate leaves them in place. Protected keys
+// cannot expire either: they lose any TTL they have, and giving them one
+// fails with ErrProtected. They can still be overwritten.
+//
+// The keys need not be present in the store. Protection is stored in the
+// database file, so it applies until removed with Unprotect, also after
+// reopening the store, and to the other stores sharing the bucket, see
+// OpenShared. Replication and the other methods that copy or
+// rebuild entire stores are not affected.
+func (s *Store) Protect(keys ...string) error {
+	if err := s.plainKeys(); err != nil {
+		return err
+	}
+	return s.changeProtection(true, protectEntries('k', keys)...)
+}
+
+// ProtectPrefix protects all keys starting with prefix, present now or
+// written later, like Protect.
+func (s *Store) ProtectPrefix(prefix string) error {
+	if err := s.plainKeys(); err != nil {
+		return err
+	}
+	return s.changeProtection(true, "p"+prefix)
+}
+
+// Unprotect removes the protection Protect gave the given keys. Keys
+// protected by a prefix remain protected until UnprotectPrefix is called
+// for that prefix.
+func (s *Store) Unprotect(keys ...string) error {
+	if err := s.plainKeys(); err != nil {
+		return err
+	}
+	return s.changeProtection(false, protectEntries('k', keys)...)
+}
+
+// UnprotectPrefix removes the protection ProtectPrefix gave to prefix.
+func (s *Store) UnprotectPrefix(prefix string) error {
+	if err := s.plainKeys(); err != nil {
+		return err
+	}
+	return s.changeProtection(false, "p"+prefix)
+}
+
+func protectEntries(kind byte, keys []string) []string {
+	entries := make([]string, len(keys))
+	for i, k := range keys {
+		entries[i] = string(kind) + k
+	}
+	return entries
+}
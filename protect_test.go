@@ -0,0 +1,187 @@
+package bboltkv
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProtect(t *testing.T) {
+	db := openTestStore(t)
+	fillStore(t, db, 10)
+	if err := db.PutAll(map[string]interface{}{"config": 1, "sys:a": 2, "sys:b": 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Protect("config", keyN(0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ProtectPrefix("sys:"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"config", keyN(0), "sys:a"} {
+		if err := db.Delete(key); err != ErrProtected {
+			t.Fatalf("Delete(%s): got %v, expected ErrProtected", key, err)
+		}
+		if err := db.DeleteGet(key, nil); err != ErrProtected {
+			t.Fatalf("DeleteGet(%s): got %v, expected ErrProtected", key, err)
+		}
+		if _, err := db.DeleteGetRaw(key); err != ErrProtected {
+			t.Fatalf("DeleteGetRaw(%s): got %v, expected ErrProtected", key, err)
+		}
+		if err := db.Expire(key, time.Hour); err != ErrProtected {
+			t.Fatalf("Expire(%s): got %v, expected ErrProtected", key, err)
+		}
+		if err := db.PutWithTTL(key, 1, time.Hour); err != ErrProtected {
+			t.Fatalf("PutWithTTL(%s): got %v, expected ErrProtected", key, err)
+		}
+		// overwriting is fine
+		if err := db.Put(key, 5); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.DeletePrefix("sys:"); err != ErrProtected {
+		t.Fatalf("DeletePrefix: got %v, expected ErrProtected", err)
+	}
+	if _, err := db.DeleteWhere(func(string, []byte) bool { return true }); err != ErrProtected {
+		t.Fatalf("DeleteWhere: got %v, expected ErrProtected", err)
+	}
+	if count, err := db.Count(); err != nil || count != 13 {
+		t.Fatalf("failed deletions removed keys: %d, %v", count, err)
+	}
+	if err := db.Delete(keyN(1)); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.Truncate(); err != nil || n != 4 {
+		t.Fatalf("Truncate preserved %d, %v, expected 4", n, err)
+	}
+	if keys, err := db.Keys(); err != nil || fmt.Sprint(keys) != "[config k00000 sys:a sys:b]" {
+		t.Fatalf("got %v, %v", keys, err)
+	}
+
+	if err := db.Unprotect("config"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("config"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Unprotect("sys:a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("sys:a"); err != ErrProtected {
+		t.Fatalf("key protected by a prefix: got %v, expected ErrProtected", err)
+	}
+	if err := db.UnprotectPrefix("sys:"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.DeletePrefix("sys:"); err != nil || n != 2 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+}
+
+func TestProtectDropsTTL(t *testing.T) {
+	db := openTestStore(t)
+	for _, key := range []string{"a", "p:1", "p:2", "q"} {
+		if err := db.PutWithTTL(key, 1, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Protect("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ProtectPrefix("p:"); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]bool{"a": false, "p:1": false, "p:2": false, "q": true} {
+		if ttl, err := db.TTL(key); err != nil || (ttl > 0) != want {
+			t.Fatalf("%s: got TTL %v, %v", key, ttl, err)
+		}
+	}
+}
+
+func TestProtectReopen(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(name, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutAll(map[string]interface{}{"config": 1, "sys:a": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Protect("config"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ProtectPrefix("sys:"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = Open(name, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, key := range []string{"config", "sys:a"} {
+		if err := db.Delete(key); err != ErrProtected {
+			t.Fatalf("%s: got %v, expected ErrProtected", key, err)
+		}
+	}
+}
+
+func TestProtectShared(t *testing.T) {
+	a, b := openSharedBucket(t, nil, nil)
+	if err := a.PutAll(map[string]interface{}{"config": 1, "sys:a": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Protect("config"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ProtectPrefix("sys:"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"config", "sys:a"} {
+		if err := a.Delete(key); err != ErrProtected {
+			t.Fatalf("%s: got %v through the other store, expected ErrProtected", key, err)
+		}
+	}
+	if err := b.Unprotect("config"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Delete("config"); err != nil {
+		t.Fatalf("got %v once unprotected", err)
+	}
+}
+
+func BenchmarkDeleteUnprotected(b *testing.B) {
+	for _, bench := range []struct {
+		name    string
+		protect bool
+	}{
+		{"NoProtection", false},
+		{"OtherKeysProtected", true},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			db := openTestStore(b)
+			if bench.protect {
+				if err := db.Protect("config", "other"); err != nil {
+					b.Fatal(err)
+				}
+				if err := db.ProtectPrefix("sys:"); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				if err := db.Put("key", i); err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+				if err := db.Delete("key"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package bboltkv
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"go.etcd.io/bbolt"
+)
+
+// ErrQuotaExceeded is returned by writes that would take the store over a
+// limit set with WithQuota. Nothing has been written.
+var ErrQuotaExceeded = errors.New("bboltkv: quota exceeded")
+
+// quotaBucket holds the usage counted against the store's quota.
+const quotaBucket = "quota"
+
+// WithQuota limits the number of entries in the store to maxKeys, and
+// their total size, keys plus encoded values, to maxBytes; a limit of 0 or
+// less does not apply. A write that would take the store over a limit
+// fails with ErrQuotaExceeded, with nothing written in its transaction.
+// Writes that keep the store's usage level, or lower it, succeed also while
+// it is over a limit, as it may be after the limits were lowered.
+//
+// The usage is kept in the database file, updated in the transactions of
+// the writes, and counted afresh when the store is opened, which visits
+// every entry. Stores sharing the bucket, see OpenShared, must all be
+// opened with WithQuota; otherwise their writes are only counted once the
+// store is opened again.
+func WithQuota(maxKeys int, maxBytes int64) Option {
+	return func(o *options) {
+		o.quotaKeys = int64(maxKeys)
+		o.quotaBytes = maxBytes
+	}
+}
+
+// QuotaDimension names a limit of WithQuota.
+type QuotaDimension int
+
+const (
+	QuotaKeys  QuotaDimension = iota // the number of entries
+	QuotaBytes                       // the total size of the entries
+)
+
+func (d QuotaDimension) String() string {
+	if d == QuotaKeys {
+		return "keys"
+	}
+	return "bytes"
+}
+
+// QuotaStatus describes a crossing of a warning threshold, see
+// WithQuotaWarning.
+type QuotaStatus struct {
+	Dimension QuotaDimension
+	Usage     int64   // usage after the write that crossed the threshold
+	Limit     int64   // the limit of Dimension set with WithQuota
+	Threshold float64 // the threshold crossed
+	Above     bool    // whether usage rose to the threshold, or fell below it
+}
+
+// WithQuotaWarning makes the store call fn when its usage reaches the given
+// fraction of a limit set with WithQuota, such as 0.8, and again when it
+// falls below it, once per crossing, not per write. The option can be
+// given several times, for several thresholds.
+//
+// fn is called once the write that crossed the threshold has committed,
+// outside its transaction, on the goroutine that made it. Crossings are
+// reported in the order of the writes, one at a time: writes crossing a
+// threshold meanwhile wait for fn to return, so it should return quickly,
+// and must not write to the store. Usage that is above a threshold when
+// the store is opened is not reported.
+//
+//	bboltkv.WithQuota(0, 1<<30), bboltkv.WithQuotaWarning(0.9, func(st bboltkv.QuotaStatus) {
+//	    log.Printf("store at %d of %d %s", st.Usage, st.Limit, st.Dimension)
+//	})
+func WithQuotaWarning(threshold float64, fn func(QuotaStatus)) Option {
+	return func(o *options) {
+		o.quotaWarnings = append(o.quotaWarnings, quotaWarning{threshold, fn})
+	}
+}
+
+type quotaWarning struct {
+	threshold float64
+	fn        func(QuotaStatus)
+}
+
+// quotaUsage is the usage counted against a store's quota.
+type quotaUsage struct {
+	keys, bytes int64
+}
+
+// quotaTx tracks the usage in a write transaction.
+type quotaTx struct {
+	start, now quotaUsage
+}
+
+func (s *Store) quotaOn() bool {
+	return s.opts.quotaKeys > 0 || s.opts.quotaBytes > 0
+}
+
+// loadQuota counts the usage of the store, and stores it for the writes
+// to update.
+func (s *Store) loadQuota(tx *bbolt.Tx) error {
+	if !s.quotaOn() {
+		return nil
+	}
+	var u quotaUsage
+	c := tx.Bucket(s.bucketName).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v != nil {
+			u.keys++
+			u.bytes += int64(s.entrySize(tx, k, v))
+		}
+	}
+	if !tx.Writable() {
+		return nil
+	}
+	b, err := tx.CreateBucketIfNotExists(s.auxName(quotaBucket))
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte("usage"), u.encode())
+}
+
+func (u quotaUsage) encode() []byte {
+	v := make([]byte, 16)
+	binary.BigEndian.PutUint64(v, uint64(u.keys))
+	binary.BigEndian.PutUint64(v[8:], uint64(u.bytes))
+	return v
+}
+
+// entrySize returns the size of the entry of key k, holding v in the
+// store's bucket, as counted against the quota: the lengths of the key and
+// of the value, as it would be read.
+func (s *Store) entrySize(tx *bbolt.Tx, k, v []byte) int {
+	if total, _, ok := chunkHeader(v); ok {
+		return len(k) + int(total)
+	}
+	if sum, ok := blobRef(v); ok {
+		if blobs := s.aux(tx, blobsBucket); blobs != nil {
+			return len(k) + len(blobs.Get(sum))
+		}
+	}
+	return len(k) + len(v)
+}
+
+// account counts a write to key against the quota, with the size of the
+// encoded value written, or -1 for a deletion, before the write is made.
+func (w *wtx) account(key string, size int) error {
+	if w.quota == nil {
+		w.quota = &quotaTx{}
+		if b := w.s.aux(w.tx, quotaBucket); b != nil {
+			if v := b.Get([]byte("usage")); len(v) == 16 {
+				w.quota.start = quotaUsage{int64(binary.BigEndian.Uint64(v)), int64(binary.BigEndian.Uint64(v[8:]))}
+			}
+		}
+		w.quota.now = w.quota.start
+	}
+	var d quotaUsage
+	if v := w.b.Get([]byte(key)); v != nil {
+		d.keys--
+		d.bytes -= int64(w.s.entrySize(w.tx, []byte(key), v))
+	}
+	if size >= 0 {
+		d.keys++
+		d.bytes += int64(len(key) + size)
+	}
+	now := quotaUsage{w.quota.now.keys + d.keys, w.quota.now.bytes + d.bytes}
+	if max := w.s.opts.quotaKeys; max > 0 && d.keys > 0 && now.keys > max {
+		return ErrQuotaExceeded
+	}
+	if max := w.s.opts.quotaBytes; max > 0 && d.bytes > 0 && now.bytes > max {
+		return ErrQuotaExceeded
+	}
+	w.quota.now = now
+	return nil
+}
+
+// saveQuota stores the usage after the transaction's writes, and arranges
+// for the warnings they cross to be delivered once it commits.
+func (w *wtx) saveQuota() error {
+	q := w.quota
+	if q == nil || q.now == q.start {
+		return nil
+	}
+	b, err := w.aux(quotaBucket)
+	if err != nil {
+		return err
+	}
+	if err := b.Put([]byte("usage"), q.now.encode()); err != nil {
+		return err
+	}
+	var crossed []QuotaStatus
+	var fns []func(QuotaStatus)
+	for _, wn := range w.s.opts.quotaWarnings {
+		for _, dim := range []struct {
+			d           QuotaDimension
+			limit       int64
+			before, now int64
+		}{
+			{QuotaKeys, w.s.opts.quotaKeys, q.start.keys, q.now.keys},
+			{QuotaBytes, w.s.opts.quotaBytes, q.start.bytes, q.now.bytes},
+		} {
+			if dim.limit <= 0 {
+				continue
+			}
+			mark := wn.threshold * float64(dim.limit)
+			was, is := float64(dim.before) >= mark, float64(dim.now) >= mark
+			if was != is {
+				crossed = append(crossed, QuotaStatus{dim.d, dim.now, dim.limit, wn.threshold, is})
+				fns = append(fns, wn.fn)
+			}
+		}
+	}
+	if len(crossed) == 0 {
+		return nil
+	}
+	// Commit handlers run once bbolt has released the writer lock, so the
+	// handlers of two transactions may run in either order, unless the
+	// first holds off the second until it is done.
+	w.s.warnings.Lock()
+	w.warning = true
+	w.tx.OnCommit(func() {
+		w.warning = false
+		defer w.s.warnings.Unlock()
+		for i, st := range crossed {
+			fns[i](st)
+		}
+	})
+	return nil
+}
+
+// abort lets the next transaction deliver its warnings, after w failed to
+// commit.
+func (w *wtx) abort() {
+	if w.warning {
+		w.warning = false
+		w.s.warnings.Unlock()
+	}
+	for _, jw := range w.joined {
+		jw.abort()
+	}
+}
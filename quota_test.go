@@ -0,0 +1,237 @@
+package bboltkv
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// warningLog records the quota warnings delivered.
+type warningLog struct {
+	mu  sync.Mutex
+	got []QuotaStatus
+}
+
+func (l *warningLog) add(st QuotaStatus) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.got = append(l.got, st)
+}
+
+func (l *warningLog) statuses() []QuotaStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]QuotaStatus(nil), l.got...)
+}
+
+func TestQuota(t *testing.T) {
+	db := openTestStore(t, WithQuota(3, 0))
+	for i := 0; i < 3; i++ {
+		if err := db.Put(keyN(i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Put(keyN(3), 3); err != ErrQuotaExceeded {
+		t.Fatalf("got %v, expected ErrQuotaExceeded", err)
+	}
+	if err := db.Get(keyN(3), nil); err != ErrNotFound {
+		t.Fatalf("got %v after a refused Put", err)
+	}
+	// overwriting keeps the count level
+	if err := db.Put(keyN(0), 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(keyN(0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(keyN(3), 3); err != nil {
+		t.Fatal(err)
+	}
+	// a refused write fails its whole transaction
+	err := db.PutAll(map[string]interface{}{keyN(0): 0, keyN(1): 11})
+	if err != ErrQuotaExceeded {
+		t.Fatalf("got %v, expected ErrQuotaExceeded", err)
+	}
+	var v int
+	if err := db.Get(keyN(1), &v); err != nil || v != 1 {
+		t.Fatalf("got %d, %v after a refused PutAll", v, err)
+	}
+}
+
+func TestQuotaBytes(t *testing.T) {
+	db := openTestStore(t, WithQuota(0, 200))
+	if err := db.Put("a", make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("b", make([]byte, 100)); err != ErrQuotaExceeded {
+		t.Fatalf("got %v, expected ErrQuotaExceeded", err)
+	}
+	// shrinking a value frees its bytes
+	if err := db.Put("a", make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("b", make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQuotaWarning(t *testing.T) {
+	var log warningLog
+	db := openTestStore(t, WithQuota(10, 0), WithQuotaWarning(0.5, log.add))
+	for i := 0; i < 8; i++ {
+		if err := db.Put(keyN(i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := log.statuses()
+	if len(got) != 1 {
+		t.Fatalf("got %+v, expected one crossing", got)
+	}
+	if want := (QuotaStatus{QuotaKeys, 5, 10, 0.5, true}); got[0] != want {
+		t.Fatalf("got %+v, expected %+v", got[0], want)
+	}
+	for i := 7; i >= 2; i-- {
+		if err := db.Delete(keyN(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got = log.statuses()
+	if len(got) != 2 {
+		t.Fatalf("got %+v, expected crossings up and down", got)
+	}
+	if want := (QuotaStatus{QuotaKeys, 4, 10, 0.5, false}); got[1] != want {
+		t.Fatalf("got %+v, expected %+v", got[1], want)
+	}
+}
+
+func TestQuotaWarningThresholds(t *testing.T) {
+	var log warningLog
+	db := openTestStore(t, WithQuota(10, 0),
+		WithQuotaWarning(0.5, log.add), WithQuotaWarning(0.9, log.add))
+	batch := map[string]interface{}{}
+	for i := 0; i < 9; i++ {
+		batch[keyN(i)] = i
+	}
+	// one write crossing both thresholds reports both
+	if err := db.PutAll(batch); err != nil {
+		t.Fatal(err)
+	}
+	got := log.statuses()
+	if len(got) != 2 || got[0].Threshold != 0.5 || got[1].Threshold != 0.9 || !got[0].Above || !got[1].Above {
+		t.Fatalf("got %+v", got)
+	}
+	if err := db.Delete(keyN(0)); err != nil {
+		t.Fatal(err)
+	}
+	got = log.statuses()
+	if len(got) != 3 || got[2].Threshold != 0.9 || got[2].Above {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestQuotaWarningAfterCommit(t *testing.T) {
+	var db *Store
+	read := ErrNotFound
+	db = openTestStore(t, WithQuota(2, 0), WithQuotaWarning(0.5, func(QuotaStatus) {
+		// the write is visible by the time of the warning
+		read = db.Get("a", nil)
+	}))
+	if err := db.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if read != nil {
+		t.Fatalf("got %v in the warning", read)
+	}
+}
+
+func TestQuotaWarningFailedCommit(t *testing.T) {
+	var log warningLog
+	db := openTestStore(t, WithQuota(2, 0), WithQuotaWarning(0.5, log.add))
+	db.txHook = func() error { return errFsync }
+	if err := db.Put("a", 1); err != errFsync {
+		t.Fatalf("got %v", err)
+	}
+	db.txHook = nil
+	if got := log.statuses(); len(got) != 0 {
+		t.Fatalf("got %+v for a write that failed", got)
+	}
+	// the next crossing is delivered
+	if err := db.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := log.statuses(); len(got) != 1 {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestQuotaWarningConcurrent(t *testing.T) {
+	var log warningLog
+	db := openTestStore(t, WithQuota(20, 0), WithQuotaWarning(0.1, log.add))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				// each writer puts and deletes its own keys, taking the
+				// store across the threshold back and forth
+				key := fmt.Sprintf("w%d:%d", i, n%5)
+				var err error
+				if n%10 < 5 {
+					err = db.Put(key, n)
+				} else {
+					err = db.Delete(key)
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	got := log.statuses()
+	if len(got) == 0 {
+		t.Fatal("no crossings reported")
+	}
+	// crossings alternate, in the order of the writes
+	for i, st := range got {
+		if st.Above != (i%2 == 0) {
+			t.Fatalf("crossing %d of %+v out of order", i, got)
+		}
+		if st.Above && st.Usage != 2 || !st.Above && st.Usage != 1 {
+			t.Fatalf("crossing %d of %+v at usage %d", i, got, st.Usage)
+		}
+	}
+	// the writers end with no keys, below the threshold
+	if got[len(got)-1].Above {
+		t.Fatalf("got %+v, ending above", got)
+	}
+}
+
+func TestQuotaReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := db.Put(keyN(i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+	// usage written without a quota is counted when it is opened with one
+	db, err = Open(path, "test", WithQuota(6, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Put(keyN(5), 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(keyN(6), 6); err != ErrQuotaExceeded {
+		t.Fatalf("got %v, expected ErrQuotaExceeded", err)
+	}
+}
@@ -0,0 +1,213 @@
+package bboltkv
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned by writes refused by WithWriteRateLimit or
+// WithGlobalWriteRateLimit in RateLimitReject mode.
+var ErrRateLimited = errors.New("bboltkv: write rate limit exceeded")
+
+// maxRateLimitedKeys is the number of keys whose rate WithWriteRateLimit
+// keeps track of.
+const maxRateLimitedKeys = 10000
+
+// RateLimitMode is what a write over the limits of WithWriteRateLimit and
+// WithGlobalWriteRateLimit does, see WithRateLimitMode.
+type RateLimitMode int
+
+const (
+	// RateLimitBlock makes the write wait until the limits allow it.
+	RateLimitBlock RateLimitMode = iota
+
+	// RateLimitReject makes the write fail with ErrRateLimited.
+	RateLimitReject
+)
+
+// WithWriteRateLimit limits the writes to each key to perKeyPerSecond on
+// average, in bursts of up to burst writes, so that a writer stuck in a
+// loop rewriting one key cannot make the store spend its time syncing the
+// file for it. Each key has a token bucket holding up to burst tokens,
+// refilled at perKeyPerSecond; every Put, PutContext, PutEncoded,
+// PutWithTTL, PutWithTTLJitter and Delete takes a token before it begins,
+// and one beyond the limit waits for a token or fails with
+// ErrRateLimited, see WithRateLimitMode. Writes of several entries at once,
+// such as PutAll and Update, are not limited.
+//
+// The buckets of the 10000 keys written most recently are kept; a key whose
+// bucket was dropped to make room starts afresh with a full one, as it
+// would have after so long. Keys written rarely only pay for a map lookup.
+// A perKeyPerSecond of 0 leaves the writes unlimited.
+//
+//	bboltkv.WithWriteRateLimit(10, 20) // 10 writes per key per second
+func WithWriteRateLimit(perKeyPerSecond float64, burst int) Option {
+	return func(o *options) {
+		o.keyRate, o.keyBurst = perKeyPerSecond, burst
+	}
+}
+
+// WithGlobalWriteRateLimit limits the writes limited by WithWriteRateLimit
+// to perSecond on average in all, in bursts of up to burst writes, with a
+// token bucket shared by all keys. Given both options, a write needs a token
+// of both its key's bucket and the shared one, and takes neither until it
+// can take both, so a key over its own limit does not use up the shared
+// one. A perSecond of 0 leaves the total unlimited.
+func WithGlobalWriteRateLimit(perSecond float64, burst int) Option {
+	return func(o *options) {
+		o.globalRate, o.globalBurst = perSecond, burst
+	}
+}
+
+// WithRateLimitMode sets what a write over the limits of WithWriteRateLimit
+// or WithGlobalWriteRateLimit does: wait for them to allow it, with
+// RateLimitBlock, the default, or fail with ErrRateLimited, with
+// RateLimitReject. A waiting PutContext gives up when its context is done,
+// as it would waiting for the transaction to begin.
+func WithRateLimitMode(mode RateLimitMode) Option {
+	return func(o *options) {
+		o.rateLimitMode = mode
+	}
+}
+
+// tokenBucket holds up to burst tokens, refilled at rate per second.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time // when tokens was last brought up to date
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+// wait brings the bucket up to date and returns how long until it holds a
+// token, or 0 if it does.
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	if d := now.Sub(b.last); d > 0 {
+		b.tokens += d.Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// rateLimiter keeps the token buckets of WithWriteRateLimit and
+// WithGlobalWriteRateLimit.
+type rateLimiter struct {
+	mu     sync.Mutex
+	global *tokenBucket // nil without WithGlobalWriteRateLimit
+	rate   float64      // per key, or 0
+	burst  int
+	max    int
+	lru    *list.List // of *keyBucket, most recently written first
+	keys   map[string]*list.Element
+}
+
+type keyBucket struct {
+	key string
+	tokenBucket
+}
+
+// newRateLimiter returns the rate limiter for o, or nil if o limits no
+// writes.
+func newRateLimiter(o options, now time.Time) *rateLimiter {
+	if o.keyRate <= 0 && o.globalRate <= 0 {
+		return nil
+	}
+	l := &rateLimiter{
+		rate:  o.keyRate,
+		burst: o.keyBurst,
+		max:   maxRateLimitedKeys,
+		lru:   list.New(),
+		keys:  make(map[string]*list.Element),
+	}
+	if o.globalRate > 0 {
+		g := newTokenBucket(o.globalRate, o.globalBurst, now)
+		l.global = &g
+	}
+	return l
+}
+
+// take takes a token for a write to key from its bucket and the shared one,
+// if both hold one, and otherwise returns how long until they might.
+func (l *rateLimiter) take(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var kb *tokenBucket
+	if l.rate > 0 {
+		kb = l.bucket(key, now)
+	}
+	var wait time.Duration
+	if kb != nil {
+		wait = kb.wait(now)
+	}
+	if l.global != nil {
+		if d := l.global.wait(now); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+	if kb != nil {
+		kb.tokens--
+	}
+	if l.global != nil {
+		l.global.tokens--
+	}
+	return 0
+}
+
+// bucket returns the bucket of key, making it the most recently written.
+func (l *rateLimiter) bucket(key string, now time.Time) *tokenBucket {
+	if e, ok := l.keys[key]; ok {
+		l.lru.MoveToFront(e)
+		return &e.Value.(*keyBucket).tokenBucket
+	}
+	if l.lru.Len() >= l.max {
+		oldest := l.lru.Back()
+		l.lru.Remove(oldest)
+		delete(l.keys, oldest.Value.(*keyBucket).key)
+	}
+	b := &keyBucket{key: key, tokenBucket: newTokenBucket(l.rate, l.burst, now)}
+	l.keys[key] = l.lru.PushFront(b)
+	return &b.tokenBucket
+}
+
+// limitWrite waits until the rate limits allow a write to key, or fails
+// with ErrRateLimited in RateLimitReject mode.
+func (s *Store) limitWrite(ctx context.Context, key string) error {
+	l := s.limiter
+	if l == nil {
+		return nil
+	}
+	for {
+		wait := l.take(key, s.now())
+		if wait == 0 {
+			return nil
+		}
+		if s.opts.rateLimitMode == RateLimitReject {
+			return ErrRateLimited
+		}
+		select {
+		case <-s.clock().After(wait):
+		case <-ctx.Done():
+			return contextError(ctx)
+		case <-s.done:
+			return ErrClosed
+		}
+	}
+}
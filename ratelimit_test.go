@@ -0,0 +1,153 @@
+package bboltkv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/unknownnf/bboltkv/testutil"
+)
+
+// puts writes key n times and returns how many of the writes succeeded,
+// failing on errors other than ErrRateLimited.
+func puts(t *testing.T, db *Store, key string, n int) int {
+	t.Helper()
+	ok := 0
+	for i := 0; i < n; i++ {
+		switch err := db.Put(key, i); err {
+		case nil:
+			ok++
+		case ErrRateLimited:
+		default:
+			t.Fatal(err)
+		}
+	}
+	return ok
+}
+
+func TestWriteRateLimitReject(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock), WithWriteRateLimit(10, 5), WithRateLimitMode(RateLimitReject))
+	if n := puts(t, db, "hot", 100); n != 5 {
+		t.Fatalf("%d writes of a burst of 5 went through", n)
+	}
+	// other keys are unaffected
+	if n := puts(t, db, "cold", 5); n != 5 {
+		t.Fatalf("%d writes of another key went through", n)
+	}
+	clock.Advance(100 * time.Millisecond)
+	if n := puts(t, db, "hot", 10); n != 1 {
+		t.Fatalf("%d writes went through after 100ms", n)
+	}
+	// tokens do not pile up beyond the burst
+	clock.Advance(time.Hour)
+	if n := puts(t, db, "hot", 10); n != 5 {
+		t.Fatalf("%d writes went through after an hour", n)
+	}
+	if err := db.Delete("hot"); err != ErrRateLimited {
+		t.Fatalf("got %v, expected ErrRateLimited", err)
+	}
+	if err := db.PutEncoded("hot", nil); err != ErrBadValue {
+		t.Fatalf("got %v, expected an invalid value refused first", err)
+	}
+	// batches are not limited
+	if err := db.PutAll(map[string]interface{}{"hot": 1}); err != nil {
+		t.Fatal(err)
+	}
+	checkValues(t, db, map[string]int{"hot": 1, "cold": 4})
+
+	if openTestStore(t).limiter != nil {
+		t.Fatal("limiter kept without the options")
+	}
+}
+
+func TestWriteRateLimitBlock(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock), WithWriteRateLimit(10, 2))
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < 4; i++ {
+			if err := db.Put("hot", i); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	// two writes of the burst, then the third waits for 100ms
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+	if n := puts(t, db, "cold", 2); n != 2 {
+		t.Fatalf("%d writes of another key went through", n)
+	}
+	checkValues(t, db, map[string]int{"hot": 1, "cold": 1})
+	clock.Advance(100 * time.Millisecond)
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+	checkValues(t, db, map[string]int{"hot": 2, "cold": 1})
+	clock.Advance(100 * time.Millisecond)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	checkValues(t, db, map[string]int{"hot": 3, "cold": 1})
+
+	// a waiting write gives up with its context
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { done <- db.PutContext(ctx, "hot", 9) }()
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("got %v, expected context.Canceled", err)
+	}
+	checkValues(t, db, map[string]int{"hot": 3, "cold": 1})
+}
+
+func TestWriteRateLimitCardinality(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock), WithWriteRateLimit(1, 1), WithRateLimitMode(RateLimitReject))
+	db.limiter.max = 5
+	for i := 0; i < 20; i++ {
+		if err := db.Put(keyN(i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, m := db.limiter.lru.Len(), len(db.limiter.keys); n != 5 || m != 5 {
+		t.Fatalf("keeping %d and %d buckets, expected 5", n, m)
+	}
+	// the most recent keys are still limited, the oldest start afresh
+	if err := db.Put(keyN(19), 0); err != ErrRateLimited {
+		t.Fatalf("got %v, expected ErrRateLimited", err)
+	}
+	if err := db.Put(keyN(0), 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGlobalWriteRateLimit(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock), WithWriteRateLimit(10, 2),
+		WithGlobalWriteRateLimit(10, 3), WithRateLimitMode(RateLimitReject))
+	// the key's limit refuses the third write, without using up a global token
+	if n := puts(t, db, "a", 3); n != 2 {
+		t.Fatalf("%d writes of a went through", n)
+	}
+	if n := puts(t, db, "b", 3); n != 1 {
+		t.Fatalf("%d writes of b went through, expected the global limit to allow one", n)
+	}
+	if n := puts(t, db, "c", 1); n != 0 {
+		t.Fatalf("%d writes of c went through", n)
+	}
+	clock.Advance(100 * time.Millisecond)
+	if n := puts(t, db, "c", 3); n != 1 {
+		t.Fatalf("%d writes of c went through after 100ms", n)
+	}
+
+	// a global limit alone
+	clock = testutil.NewFakeClock(time.Now())
+	db = openTestStore(t, WithClock(clock), WithGlobalWriteRateLimit(1, 4), WithRateLimitMode(RateLimitReject))
+	total := 0
+	for i := 0; i < 10; i++ {
+		total += puts(t, db, keyN(i), 1)
+	}
+	if total != 4 {
+		t.Fatalf("%d writes went through", total)
+	}
+}
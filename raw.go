@@ -0,0 +1,78 @@
+package bboltkv
+
+import "go.etcd.io/bbolt"
+
+// RawView runs fn in a read-only transaction with the store's bucket, for
+// reading entries as they are stored. It returns the error fn returns, or
+// one wrapping ErrNoBucket if the bucket has been removed from the file
+// behind the store's back. The bucket is only valid until fn returns, and
+// so are the slices it hands out.
+//
+// Entries are as stored: values as Encode returns them, unless options
+// change that. WithCompression, WithEncryption and the other transforms
+// store them transformed, WithChunkThreshold stores large ones as headers
+// of chunks kept elsewhere, and WithEncryptedKeys stores keys encrypted.
+//
+// fn must not call the store's methods: those it calls fail with
+// ErrReentrant, and so does RawView, rather than block on a transaction the
+// store already holds. Other goroutines may use the store meanwhile.
+//
+//	err := store.RawView(func(b *bbolt.Bucket) error {
+//	    return b.ForEach(func(k, v []byte) error {
+//	        fmt.Printf("%s: %d bytes\n", k, len(v))
+//	        return nil
+//	    })
+//	})
+func (s *Store) RawView(fn func(b *bbolt.Bucket) error) (err error) {
+	if s.opts.tracer != nil {
+		defer s.startSpan(s.opts.tracer, "RawView", "")(&err)
+	}
+	return s.view(func(tx *bbolt.Tx) error {
+		return s.rawCall(tx.Bucket(s.bucketName), fn)
+	})
+}
+
+// RawUpdate runs fn in a read-write transaction with the store's bucket,
+// like RawView, and commits the transaction unless fn fails, in which case
+// nothing fn wrote is kept and RawUpdate returns its error. Values must be
+// written as the store would store them, see RawView; they are not
+// validated.
+//
+// RawUpdate keeps the read caches and the filter of WithNegativeLookupFilter
+// consistent, by emptying the ones and rebuilding the other, but the entries
+// fn writes or deletes bypass everything else the store does on writes:
+// the change log, and with it WatchState and replication, views, text
+// indexes, the quota, operation statistics, and the TTLs, tags, histories
+// and lists of the keys concerned, which are neither dropped nor updated;
+// Fsck reports what that leaves behind, and FsckRepair removes it.
+// RawUpdate is meant for repairs and migrations, in place of writing
+// through GetDb; writing the store's internal buckets is not possible
+// through it.
+func (s *Store) RawUpdate(fn func(b *bbolt.Bucket) error) (err error) {
+	if s.opts.tracer != nil {
+		defer s.startSpan(s.opts.tracer, "RawUpdate", "")(&err)
+	}
+	return s.update(func(w *wtx) error {
+		if err := s.rawCall(w.b, fn); err != nil {
+			return err
+		}
+		if s.opts.filterBitsPerKey != 0 {
+			s.buildFilter(w.tx)
+		}
+		s.forgetAll(w.tx)
+		return nil
+	})
+}
+
+// rawCall runs fn with b, the store's bucket in a transaction, as a
+// callback, see Store.callback.
+func (s *Store) rawCall(b *bbolt.Bucket, fn func(b *bbolt.Bucket) error) error {
+	if b == nil {
+		return s.errNoBucket()
+	}
+	var err error
+	if cerr := s.callback(func() { err = fn(b) }); cerr != nil {
+		return cerr
+	}
+	return err
+}
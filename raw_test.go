@@ -0,0 +1,137 @@
+package bboltkv
+
+import (
+	"errors"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestRawRoundTrip(t *testing.T) {
+	db := openTestStore(t, WithReadCache(100), WithNegativeLookupFilter(10))
+	if err := db.Put("a", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	var raw []byte
+	err := db.RawView(func(b *bbolt.Bucket) error {
+		raw = append(raw, b.Get([]byte("a"))...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v string
+	if err := db.Decode(raw, &v); err != nil || v != "alice" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+
+	// reads cached, or ruled out by the filter, before a raw write see it
+	// after
+	if err := db.Get("a", &v); err != nil {
+		t.Fatal(err)
+	}
+	if has, err := db.Has("b"); err != nil || has {
+		t.Fatalf("got %v, %v", has, err)
+	}
+	bob, err := db.Encode("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.RawUpdate(func(b *bbolt.Bucket) error {
+		if err := b.Put([]byte("a"), bob); err != nil {
+			return err
+		}
+		return b.Put([]byte("b"), bob)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStrings(t, db, map[string]string{"a": "bob", "b": "bob"})
+}
+
+// checkStrings checks that db holds the given strings.
+func checkStrings(t *testing.T, db *Store, want map[string]string) {
+	t.Helper()
+	for k, w := range want {
+		var v string
+		if err := db.Get(k, &v); err != nil || v != w {
+			t.Fatalf("%s: got %q, %v, expected %q", k, v, err, w)
+		}
+	}
+	if n, err := db.Count(); err != nil || n != len(want) {
+		t.Fatalf("got %d entries, %v, expected %d", n, err, len(want))
+	}
+}
+
+func TestRawUpdateRollback(t *testing.T) {
+	db := openTestStore(t)
+	if err := db.Put("a", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	errFail := errors.New("fail")
+	err := db.RawUpdate(func(b *bbolt.Bucket) error {
+		if err := b.Delete([]byte("a")); err != nil {
+			return err
+		}
+		if err := b.Put([]byte("b"), []byte("x")); err != nil {
+			return err
+		}
+		return errFail
+	})
+	if err != errFail {
+		t.Fatalf("got %v", err)
+	}
+	checkStrings(t, db, map[string]string{"a": "alice"})
+}
+
+func TestRawReentrant(t *testing.T) {
+	db := openTestStore(t)
+	if err := db.Put("a", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	var inner error
+	err := db.RawView(func(b *bbolt.Bucket) error {
+		var v string
+		inner = db.Get("a", &v)
+		return nil
+	})
+	if err != ErrReentrant || inner != ErrReentrant {
+		t.Fatalf("got %v, and %v inside", err, inner)
+	}
+	// a write from inside would wait for the transaction it runs in
+	err = db.RawUpdate(func(b *bbolt.Bucket) error {
+		if err := b.Put([]byte("b"), []byte("x")); err != nil {
+			return err
+		}
+		inner = db.Put("c", "carol")
+		return nil
+	})
+	if err != ErrReentrant || inner != ErrReentrant {
+		t.Fatalf("got %v, and %v inside", err, inner)
+	}
+	checkStrings(t, db, map[string]string{"a": "alice"})
+	// and the store can be used again afterwards
+	if err := db.Put("c", "carol"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRawNoBucket(t *testing.T) {
+	db := openTestStore(t)
+	err := db.GetDb().Update(func(tx *bbolt.Tx) error {
+		return tx.DeleteBucket(db.GetBucketName())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	called := false
+	fn := func(b *bbolt.Bucket) error {
+		called = true
+		return nil
+	}
+	for _, err := range []error{db.RawView(fn), db.RawUpdate(fn)} {
+		if !errors.Is(err, ErrNoBucket) || called {
+			t.Fatalf("got %v, called %v", err, called)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package bboltkv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrPrefixOverlap is returned by RenamePrefix when one prefix starts with
+// the other, so that renamed keys could land among those still to rename.
+var ErrPrefixOverlap = errors.New("bboltkv: prefixes overlap")
+
+// renameBatch is the number of entries RenamePrefix moves per transaction,
+// unless WithAdaptiveBatching sizes its batches.
+const renameBatch = 1000
+
+// RenamePrefix moves every entry whose key starts with oldPrefix to the
+// key with newPrefix in its place, and returns how many it moved. Values
+// are moved as stored, without decoding them, along with their TTLs, tags,
+// timestamps and list elements; views and the quota follow as they do for
+// writes and deletions. Protected and immutable keys cannot be moved, and
+// fail the rename with ErrProtected or ErrImmutable.
+//
+// If a key with the new name is present already, it is replaced if
+// overwrite is set; otherwise the rename fails with an error wrapping
+// ErrConflict. Prefixes where one starts with the other, such as "a" and
+// "ab", fail with ErrPrefixOverlap before anything is moved. New keys are
+// checked against WithKeyLimit and WithKeyCharset as their entries are
+// moved, once newPrefix itself has passed.
+//
+// Entries are moved in batches of 1000, or as WithAdaptiveBatching sizes
+// them, each in a transaction of its own that also deletes the entries under
+// their old keys. A rename that fails part way, or is interrupted by a
+// crash, leaves the batches before in place, and is resumed by calling
+// RenamePrefix again with the same arguments: the entries left under
+// oldPrefix are exactly those still to move. The count returned covers the
+// batches committed.
+//
+//	n, err := store.RenamePrefix("usr:", "user:", false)
+func (s *Store) RenamePrefix(oldPrefix, newPrefix string, overwrite bool) (int, error) {
+	if err := s.plainKeys(); err != nil {
+		return 0, err
+	}
+	if strings.HasPrefix(oldPrefix, newPrefix) || strings.HasPrefix(newPrefix, oldPrefix) {
+		return 0, fmt.Errorf("%w: %q and %q", ErrPrefixOverlap, oldPrefix, newPrefix)
+	}
+	if err := s.checkKey(newPrefix); err != nil {
+		return 0, err
+	}
+	old := []byte(oldPrefix)
+	b := s.newBatcher(renameBatch, nil)
+	moved := 0
+	for {
+		s.yieldWrites()
+		n, size := 0, b.size
+		start := s.now()
+		err := s.update(func(w *wtx) error {
+			n = 0
+			var keys []string
+			c := w.b.Cursor()
+			for k, _ := c.Seek(old); k != nil && bytes.HasPrefix(k, old) && len(keys) < size; k, _ = c.Next() {
+				keys = append(keys, string(k))
+			}
+			for _, key := range keys {
+				if err := w.rename(key, newPrefix+key[len(old):], overwrite); err != nil {
+					return err
+				}
+				n++
+			}
+			return nil
+		})
+		if err != nil {
+			return moved, err
+		}
+		b.done(n, s.now().Sub(start))
+		moved += n
+		if n < size {
+			return moved, nil
+		}
+	}
+}
+
+// rename moves the entry stored under from to the key to, with what the
+// store keeps about it.
+func (w *wtx) rename(from, to string, overwrite bool) error {
+	s := w.s
+	if s.protected(from) {
+		return ErrProtected
+	}
+	if err := s.checkKey(to); err != nil {
+		return err
+	}
+	if !overwrite && w.get(to) != nil {
+		return fmt.Errorf("%w: renaming %q, %q is present", ErrConflict, from, to)
+	}
+	raw, err := s.assemble(w.tx, []byte(from), w.b.Get([]byte(from)))
+	if err != nil {
+		return err
+	}
+	// copied, as writing to the bucket invalidates it
+	raw = append([]byte(nil), raw...)
+	at, expires := s.expiresAt(w.tx, from)
+	ts, stamped := s.timestamp(w.tx, []byte(from))
+	var tags []string
+	if b := s.aux(w.tx, tagsBucket); b != nil {
+		tags = decodeTags(b.Get([]byte(from)))
+	}
+
+	if err := w.put(to, raw); err != nil {
+		return err
+	}
+	// protected keys cannot expire, see Protect
+	if expires && !s.protected(to) {
+		if err := w.setExpiry(to, at); err != nil {
+			return err
+		}
+	}
+	// with WithModTimes, the move is stamped as a write
+	if stamped && !s.opts.modTimes {
+		if err := w.setTimestamp(to, ts); err != nil {
+			return err
+		}
+	}
+	if err := w.setTags(to, tags); err != nil {
+		return err
+	}
+	if len(raw) > 0 && raw[0] == tagList {
+		if err := w.moveList(from, to); err != nil {
+			return err
+		}
+	}
+	return w.delete(from)
+}
+
+// moveList replaces the elements of the list stored under to with those of
+// the one stored under from.
+func (w *wtx) moveList(from, to string) error {
+	lists, err := w.aux(listBucket)
+	if err != nil {
+		return err
+	}
+	// putting a list header keeps the elements of the list it replaces
+	if lists.Bucket(listName(to)) != nil {
+		if err := lists.DeleteBucket(listName(to)); err != nil {
+			return err
+		}
+	}
+	src := lists.Bucket(listName(from))
+	if src == nil {
+		return nil
+	}
+	dst, err := lists.CreateBucket(listName(to))
+	if err != nil {
+		return err
+	}
+	return src.ForEach(func(k, v []byte) error {
+		return dst.Put(k, v)
+	})
+}
@@ -0,0 +1,160 @@
+package bboltkv
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// putRenamable writes the entries usr:00000 to usr:<n-1>, holding their
+// numbers, and entries with TTLs, tags, timestamps and lists.
+func putRenamable(t *testing.T, db *Store, n int) {
+	t.Helper()
+	entries := map[string]interface{}{"usr": -1, "usrx:0": -1, "other": -1}
+	for i := 0; i < n; i++ {
+		entries[fmt.Sprintf("usr:%05d", i)] = i
+	}
+	if err := db.PutAll(entries); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutWithTTL("usr:ttl", 1, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutTagged("usr:tagged", 2, "kind=tagged"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.PutIfNewer("usr:stamped", 3, time.Unix(1e9, 0)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := db.Append("usr:list", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkRenamed checks that putRenamable's entries are under user:.
+func checkRenamed(t *testing.T, db *Store, n int) {
+	t.Helper()
+	want := map[string]int{"usr": -1, "usrx:0": -1, "other": -1, "user:ttl": 1, "user:tagged": 2, "user:stamped": 3}
+	for i := 0; i < n; i++ {
+		want[fmt.Sprintf("user:%05d", i)] = i
+	}
+	var list []int
+	if err := db.GetList("user:list", &list); err != nil || fmt.Sprint(list) != "[0 1 2]" {
+		t.Fatalf("list holds %v, %v", list, err)
+	}
+	for k, w := range want {
+		var v int
+		if err := db.Get(k, &v); err != nil || v != w {
+			t.Fatalf("%s: got %d, %v, expected %d", k, v, err, w)
+		}
+	}
+	// and the list
+	if n, err := db.Count(); err != nil || n != len(want)+1 {
+		t.Fatalf("got %d entries, %v, expected %d", n, err, len(want)+1)
+	}
+	if ttl, err := db.TTL("user:ttl"); err != nil || ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("TTL %v, %v", ttl, err)
+	}
+	if keys, err := db.KeysByTag("kind=tagged"); err != nil || fmt.Sprint(keys) != "[user:tagged]" {
+		t.Fatalf("tagged %v, %v", keys, err)
+	}
+	if ts, err := db.Timestamp("user:stamped"); err != nil || !ts.Equal(time.Unix(1e9, 0)) {
+		t.Fatalf("timestamp %v, %v", ts, err)
+	}
+}
+
+func TestRenamePrefix(t *testing.T) {
+	db := openTestStore(t)
+	putRenamable(t, db, 2500)
+	n, err := db.RenamePrefix("usr:", "user:", false)
+	if err != nil || n != 2500+4 {
+		t.Fatalf("renamed %d, %v", n, err)
+	}
+	checkRenamed(t, db, 2500)
+	// nothing is left to rename
+	if n, err := db.RenamePrefix("usr:", "user:", false); err != nil || n != 0 {
+		t.Fatalf("renamed %d, %v", n, err)
+	}
+}
+
+func TestRenamePrefixConflict(t *testing.T) {
+	db := openTestStore(t)
+	putRenamable(t, db, 10)
+	if err := db.Put("user:00005", "taken"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := db.Append("user:list", "taken"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := db.RenamePrefix("usr:", "user:", false)
+	if !errors.Is(err, ErrConflict) || n != 0 {
+		t.Fatalf("renamed %d, %v", n, err)
+	}
+	var v string
+	if err := db.Get("user:00005", &v); err != nil || v != "taken" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+	if has, err := db.Has("usr:00000"); err != nil || !has {
+		t.Fatalf("usr:00000 moved by a failed rename: %v, %v", has, err)
+	}
+	// with overwrite, the entries present are replaced, lists whole
+	n, err = db.RenamePrefix("usr:", "user:", true)
+	if err != nil || n != 10+4 {
+		t.Fatalf("renamed %d, %v", n, err)
+	}
+	checkRenamed(t, db, 10)
+}
+
+func TestRenamePrefixResume(t *testing.T) {
+	db := openTestStore(t)
+	putRenamable(t, db, 3500)
+	errCrash := errors.New("crash")
+	txs := 0
+	db.txHook = func() error {
+		if txs++; txs == 3 {
+			return errCrash
+		}
+		return nil
+	}
+	n, err := db.RenamePrefix("usr:", "user:", false)
+	if err != errCrash || n != 2*renameBatch {
+		t.Fatalf("renamed %d, %v", n, err)
+	}
+	// the batches committed are in place, under the new names only
+	if count, err := db.Count(); err != nil || count != 3500+7 {
+		t.Fatalf("%d entries, %v", count, err)
+	}
+	db.txHook = nil
+	n, err = db.RenamePrefix("usr:", "user:", false)
+	if err != nil || n != 3500+4-2*renameBatch {
+		t.Fatalf("renamed %d, %v on resuming", n, err)
+	}
+	checkRenamed(t, db, 3500)
+}
+
+func TestRenamePrefixRefused(t *testing.T) {
+	db := openTestStore(t)
+	if err := db.PutAll(map[string]interface{}{"a": 1, "ab": 2, "abc": 3}); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range [][2]string{{"a", "ab"}, {"ab", "a"}, {"a", "a"}, {"", "x"}, {"x", ""}} {
+		if n, err := db.RenamePrefix(p[0], p[1], true); !errors.Is(err, ErrPrefixOverlap) || n != 0 {
+			t.Fatalf("%q to %q: renamed %d, %v", p[0], p[1], n, err)
+		}
+	}
+	checkValues(t, db, map[string]int{"a": 1, "ab": 2, "abc": 3})
+
+	// protected and immutable keys stay where they are
+	if err := db.Protect("ab"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.RenamePrefix("ab", "x", false); err != ErrProtected || n != 0 {
+		t.Fatalf("renamed %d, %v", n, err)
+	}
+	checkValues(t, db, map[string]int{"a": 1, "ab": 2, "abc": 3})
+}
@@ -0,0 +1,307 @@
+package bboltkv
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+const (
+	replicationBucket = "replication"
+
+	// replicationBatch is the number of changes served per request.
+	replicationBatch = 10000
+)
+
+// replicationLine is a line of the replication stream. The first line
+// tells whether a full copy follows, or changes. Each entry is on a line of
+// its own, and the last line has End set, with the sequence number the
+// replica is at afterwards, and whether more changes are waiting.
+type replicationLine struct {
+	Seq     uint64 `json:"seq,omitempty"`
+	Key     string `json:"key,omitempty"`
+	Value   []byte `json:"value,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+	Full    bool   `json:"full,omitempty"`
+	End     bool   `json:"end,omitempty"`
+	More    bool   `json:"more,omitempty"`
+}
+
+// WithBearerToken makes ServeReplication require the given token in the
+// Authorization header of every request, and PullFrom send it.
+func WithBearerToken(token string) OpOption {
+	return func(o *opOptions) {
+		o.bearerToken = token
+	}
+}
+
+// WithPullErrorCallback sets a function that is called with the error of
+// every failed pull that PullFrom runs in the background.
+func WithPullErrorCallback(fn func(err error)) OpOption {
+	return func(o *opOptions) {
+		o.pullError = fn
+	}
+}
+
+// ServeReplication registers a handler at path on mux that serves the
+// store's change log, see WithChangeLog, to replicas calling PullFrom. The
+// store should be opened with WithChangeLog; otherwise replicas can only
+// make full copies. A replica that is new, or that has fallen behind the
+// log because it was trimmed, gets a full copy of the store instead.
+func (s *Store) ServeReplication(mux *http.ServeMux, path string, opts ...OpOption) {
+	o := buildOpOptions(opts)
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if o.bearerToken != "" {
+			auth := []byte(r.Header.Get("Authorization"))
+			if subtle.ConstantTimeCompare(auth, []byte("Bearer "+o.bearerToken)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		var since uint64
+		if v := r.URL.Query().Get("since"); v != "" {
+			var err error
+			if since, err = strconv.ParseUint(v, 10, 64); err != nil {
+				http.Error(w, "bad since parameter", http.StatusBadRequest)
+				return
+			}
+		}
+		if replica := r.URL.Query().Get("replica"); replica != "" {
+			// the replica has applied the changes up to since
+			if err := s.AckChanges(replica, since); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		bw := bufio.NewWriter(w)
+		err := s.view(func(tx *bbolt.Tx) error {
+			return s.serveChanges(tx, since, json.NewEncoder(bw))
+		})
+		// On errors, the missing end line tells the replica that the
+		// stream is incomplete.
+		if err == nil {
+			bw.Flush()
+		}
+	})
+}
+
+// serveChanges writes the replication stream for a replica that has
+// applied the changes up to since.
+func (s *Store) serveChanges(tx *bbolt.Tx, since uint64, enc *json.Encoder) error {
+	last, _ := s.changeLogState(tx)
+	var changes []replicationLine
+	err := ErrLogTrimmed
+	if since > 0 {
+		err = s.changes(tx, since, replicationBatch+1, func(c Change) error {
+			changes = append(changes, replicationLine{Seq: c.Seq, Key: c.Key, Value: c.Value, Deleted: c.Deleted})
+			return nil
+		})
+	}
+	if err == ErrLogTrimmed {
+		if err := enc.Encode(replicationLine{Full: true}); err != nil {
+			return err
+		}
+		c := tx.Bucket(s.bucketName).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if s.hidden(tx, k) {
+				continue
+			}
+			v, err := s.assemble(tx, k, v)
+			if err != nil {
+				return err
+			}
+			if err := enc.Encode(replicationLine{Key: string(k), Value: v}); err != nil {
+				return err
+			}
+		}
+		return enc.Encode(replicationLine{End: true, Seq: last})
+	} else if err != nil {
+		return err
+	}
+	end := replicationLine{End: true, Seq: since}
+	if len(changes) > replicationBatch {
+		changes, end.More = changes[:replicationBatch], true
+	}
+	if err := enc.Encode(replicationLine{}); err != nil {
+		return err
+	}
+	for _, c := range changes {
+		if err := enc.Encode(c); err != nil {
+			return err
+		}
+		end.Seq = c.Seq
+	}
+	return enc.Encode(end)
+}
+
+// PullFrom makes the store a replica of the store serving replication at
+// the given URL, see ServeReplication. It pulls all changes once before
+// returning, failing if that does, and then again every interval in the
+// background, until stop is called or the store is closed. Errors of
+// background pulls are passed to the callback set with
+// WithPullErrorCallback.
+//
+// The changes are applied as they were made on the primary, replacing and
+// deleting entries of the replica, and the sequence number of the last one
+// is stored in the replica's file, so that pulling resumes where it left
+// off after a restart. Each batch of changes is applied in one transaction,
+// so replaying a batch by accident changes nothing. A full copy replaces
+// all entries of the replica. As only keys and values are replicated,
+// entries that expire on the primary disappear from the replica once the
+// primary sweeps them.
+//
+//	stop, err := replica.PullFrom("http://primary:8080/replication", 10*time.Second,
+//	    bboltkv.WithBearerToken(token))
+func (s *Store) PullFrom(url string, interval time.Duration, opts ...OpOption) (stop func(), err error) {
+	o := buildOpOptions(opts)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := s.pull(ctx, url, o); err != nil {
+		cancel()
+		return nil, err
+	}
+	stopped := make(chan struct{})
+	s.goBackground(func(done <-chan struct{}) {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := s.pull(ctx, url, o); err != nil && ctx.Err() == nil && o.pullError != nil {
+				o.pullError(err)
+			}
+		}
+	})
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			<-stopped
+		})
+	}, nil
+}
+
+// pull fetches and applies changes until the replica has caught up.
+func (s *Store) pull(ctx context.Context, primary string, o opOptions) error {
+	for {
+		more, err := s.pullOnce(ctx, primary, o)
+		if err != nil || !more {
+			return err
+		}
+	}
+}
+
+func (s *Store) pullOnce(ctx context.Context, primary string, o opOptions) (more bool, err error) {
+	var since uint64
+	err = s.view(func(tx *bbolt.Tx) error {
+		if b := s.aux(tx, replicationBucket); b != nil {
+			if v := b.Get([]byte(primary)); len(v) == 8 {
+				since = binary.BigEndian.Uint64(v)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	u, err := url.Parse(primary)
+	if err != nil {
+		return false, err
+	}
+	q := u.Query()
+	q.Set("since", strconv.FormatUint(since, 10))
+	if o.replicaName != "" {
+		q.Set("replica", o.replicaName)
+	}
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	if o.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+o.bearerToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("bboltkv: replication: %s", resp.Status)
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(resp.Body))
+	var head replicationLine
+	if err := dec.Decode(&head); err != nil {
+		return false, fmt.Errorf("bboltkv: replication: %w", err)
+	}
+	var lines []replicationLine
+	var end replicationLine
+	for !end.End {
+		var l replicationLine
+		if err := dec.Decode(&l); err != nil {
+			return false, fmt.Errorf("bboltkv: replication: %w", err)
+		}
+		if l.End {
+			end = l
+		} else if !l.Deleted && len(l.Value) == 0 {
+			return false, errors.New("bboltkv: replication: entry without a value")
+		} else {
+			lines = append(lines, l)
+		}
+	}
+
+	err = s.update(func(w *wtx) error {
+		if head.Full {
+			var keys []string
+			c := w.b.Cursor()
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				keys = append(keys, string(k))
+			}
+			for _, k := range keys {
+				if err := w.delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		for _, l := range lines {
+			if !head.Full && l.Seq <= since {
+				continue
+			}
+			var err error
+			if l.Deleted {
+				if w.b.Get([]byte(l.Key)) != nil {
+					err = w.delete(l.Key)
+				}
+			} else {
+				err = w.put(l.Key, l.Value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		b, err := w.aux(replicationBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(primary), changeKey(end.Seq))
+	})
+	return end.More, err
+}
@@ -0,0 +1,199 @@
+package bboltkv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// replicationServer serves the primary's change log, recording the since
+// parameter of each request.
+func replicationServer(t *testing.T, primary *Store, opts ...OpOption) (*httptest.Server, func() []string) {
+	mux := http.NewServeMux()
+	primary.ServeReplication(mux, "/replication", opts...)
+	var mu sync.Mutex
+	var since []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		since = append(since, r.URL.Query().Get("since"))
+		mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		s := since
+		since = nil
+		return s
+	}
+}
+
+// contents returns the keys and values of db as a sorted list.
+func contents(t *testing.T, db *Store) string {
+	t.Helper()
+	keys, err := db.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []string
+	for _, k := range keys {
+		var v int
+		if err := db.Get(k, &v); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, k+"="+string(rune('0'+v)))
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, " ")
+}
+
+func TestReplication(t *testing.T) {
+	primary := openTestStore(t, WithChangeLog())
+	if err := primary.PutAll(map[string]interface{}{"a": 1, "b": 2}); err != nil {
+		t.Fatal(err)
+	}
+	srv, requests := replicationServer(t, primary)
+	url := srv.URL + "/replication"
+	name := filepath.Join(t.TempDir(), "replica.db")
+	replica, err := Open(name, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := replica.Put("stray", 9); err != nil {
+		t.Fatal(err)
+	}
+
+	// initial full copy
+	stop, err := replica.PullFrom(url, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop()
+	if got := contents(t, replica); got != "a=1 b=2" {
+		t.Fatalf("got %s after full sync", got)
+	}
+
+	// incremental, with a deletion
+	if err := primary.Put("c", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	requests()
+	stop, err = replica.PullFrom(url, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop()
+	if got := contents(t, replica); got != "b=2 c=3" {
+		t.Fatalf("got %s after incremental sync", got)
+	}
+	if got := requests(); len(got) != 1 || got[0] != "2" {
+		t.Fatalf("requested since %v, expected 2", got)
+	}
+
+	// a restarted replica resumes where it was
+	replica.Close()
+	if replica, err = Open(name, "test"); err != nil {
+		t.Fatal(err)
+	}
+	defer replica.Close()
+	if err := primary.Put("d", 4); err != nil {
+		t.Fatal(err)
+	}
+	stop, err = replica.PullFrom(url, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop()
+	if got := requests(); len(got) != 1 || got[0] != "4" {
+		t.Fatalf("requested since %v, expected 4", got)
+	}
+	if got := contents(t, replica); got != "b=2 c=3 d=4" {
+		t.Fatalf("got %s after restart", got)
+	}
+
+	// trimming past the replica forces a full copy
+	if err := primary.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.Put("e", 5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := primary.TrimChangeLog(6); err != nil {
+		t.Fatal(err)
+	}
+	stop, err = replica.PullFrom(url, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop()
+	if got := contents(t, replica); got != "c=3 d=4 e=5" {
+		t.Fatalf("got %s after trimming", got)
+	}
+	if err := primary.Put("f", 6); err != nil {
+		t.Fatal(err)
+	}
+	requests()
+	if stop, err = replica.PullFrom(url, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	stop()
+	if got := requests(); len(got) != 1 || got[0] != "7" {
+		t.Fatalf("requested since %v, expected 7", got)
+	}
+	if got := contents(t, replica); got != "c=3 d=4 e=5 f=6" {
+		t.Fatalf("got %s", got)
+	}
+}
+
+func TestReplicationBackground(t *testing.T) {
+	primary := openTestStore(t, WithChangeLog())
+	srv, _ := replicationServer(t, primary)
+	replica := openTestStore(t)
+	stop, err := replica.PullFrom(srv.URL+"/replication", 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	if err := primary.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for replica.Get("a", nil) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("change was not pulled")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReplicationToken(t *testing.T) {
+	primary := openTestStore(t, WithChangeLog())
+	if err := primary.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	srv, _ := replicationServer(t, primary, WithBearerToken("secret"))
+	replica := openTestStore(t)
+	if _, err := replica.PullFrom(srv.URL+"/replication", time.Hour); err == nil {
+		t.Fatal("pull without a token succeeded")
+	}
+	if _, err := replica.PullFrom(srv.URL+"/replication", time.Hour, WithBearerToken("wrong")); err == nil {
+		t.Fatal("pull with the wrong token succeeded")
+	}
+	stop, err := replica.PullFrom(srv.URL+"/replication", time.Hour, WithBearerToken("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop()
+	if err := replica.Get("a", nil); err != nil {
+		t.Fatal(err)
+	}
+}
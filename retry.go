@@ -0,0 +1,111 @@
+package bboltkv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RetryError is returned by writes that failed with errors classified as
+// transient, see WithRetry, on every attempt they were allowed.
+type RetryError struct {
+	Attempts int   // how many times the write was tried
+	Err      error // the error of the last attempt
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("bboltkv: write failed after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// transientErrnos are the system errors IsTransient considers transient.
+var transientErrnos = []syscall.Errno{syscall.EINTR, syscall.EAGAIN, syscall.ENOSPC, syscall.ENOMEM}
+
+// IsTransient is the default classifier of WithRetry. It reports whether
+// err is an interrupted or refused system call, a full disk or a lack of
+// memory, such as a failed fsync or a failure to grow the file or its
+// memory map, which bbolt reports without wrapping the system error.
+func IsTransient(err error) bool {
+	for _, errno := range transientErrnos {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	msg := err.Error()
+	for _, prefix := range []string{"mmap allocate error: ", "file resize error: ", "file sync error: "} {
+		if strings.HasPrefix(msg, prefix) {
+			for _, errno := range transientErrnos {
+				if strings.HasSuffix(msg, errno.Error()) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// WithRetry makes writes that fail with an error classify reports as
+// transient try again, up to attempts times in all, waiting backoff before
+// the second attempt and twice as long before each further one. If they
+// fail on every attempt, they return a RetryError wrapping the last error.
+// Other errors are returned right away. A nil classify means IsTransient.
+//
+// Only failures to begin or commit the transaction are retried, after
+// bbolt has rolled it back, so nothing of the failed attempt is left.
+// Errors of the write itself, such as ErrNotFound or a failed validation,
+// are never retried, and neither are writes that have changed the store's
+// state outside the transaction, such as CreateView. Writes buffered with
+// WithWriteBuffer are retried when they are flushed.
+//
+//	store, err := bboltkv.Open("data.db", "bucket",
+//	    bboltkv.WithRetry(5, 10*time.Millisecond, nil))
+func WithRetry(attempts int, backoff time.Duration, classify func(error) bool) Option {
+	return func(o *options) {
+		if classify == nil {
+			classify = IsTransient
+		}
+		o.retryAttempts = attempts
+		o.retryBackoff = backoff
+		o.retryClassify = classify
+	}
+}
+
+// retry calls run with fn until it succeeds, or fails with an error it
+// must not retry, see WithRetry. The backoff is cut short if ctx is done or
+// the store closes, giving up with the last error.
+func (s *Store) retry(ctx context.Context, fn func(w *wtx) error, run func(fn func(w *wtx) error) error) error {
+	if s.opts.retryAttempts <= 1 {
+		return run(fn)
+	}
+	backoff := s.opts.retryBackoff
+	for attempt := 1; ; attempt++ {
+		var fnErr error
+		effects := false
+		err := run(func(w *wtx) error {
+			fnErr = fn(w)
+			effects = w.sideEffects
+			return fnErr
+		})
+		// after ErrOverrun, the write has been made
+		if err == nil || err == fnErr || err == ErrOverrun || effects || !s.opts.retryClassify(err) {
+			return err
+		}
+		if attempt == s.opts.retryAttempts {
+			return &RetryError{Attempts: attempt, Err: err}
+		}
+		select {
+		case <-s.clock().After(backoff):
+		case <-ctx.Done():
+			return &RetryError{Attempts: attempt, Err: err}
+		case <-s.done:
+			return &RetryError{Attempts: attempt, Err: err}
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,136 @@
+package bboltkv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// failCommits makes the next n writes of db fail before they commit with
+// err, or all of them if n is negative, and returns a function counting
+// the commits attempted.
+func failCommits(db *Store, n int, err error) func() int {
+	calls := 0
+	db.txHook = func() error {
+		calls++
+		if n < 0 || calls <= n {
+			return err
+		}
+		return nil
+	}
+	return func() int { return calls }
+}
+
+var errFsync = &os.PathError{Op: "fdatasync", Path: "test.db", Err: syscall.EINTR}
+
+func TestRetry(t *testing.T) {
+	db := openTestStore(t, WithRetry(4, time.Millisecond, nil))
+	calls := failCommits(db, 3, errFsync)
+	if err := db.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if calls() != 4 {
+		t.Fatalf("%d attempts, expected 4", calls())
+	}
+	var v int
+	if err := db.Get("a", &v); err != nil || v != 1 {
+		t.Fatalf("got %d, %v", v, err)
+	}
+
+	calls = failCommits(db, -1, errFsync)
+	err := db.Put("b", 1)
+	var re *RetryError
+	if !errors.As(err, &re) || re.Attempts != 4 || !errors.Is(err, syscall.EINTR) {
+		t.Fatalf("got %v, expected a RetryError after 4 attempts", err)
+	}
+	if calls() != 4 {
+		t.Fatalf("%d attempts, expected 4", calls())
+	}
+	if ok, err := db.Has("b"); err != nil || ok {
+		t.Fatalf("failed write was made: %v, %v", ok, err)
+	}
+}
+
+func TestRetryNotTransient(t *testing.T) {
+	db := openTestStore(t, WithRetry(4, time.Millisecond, nil))
+	boom := errors.New("boom")
+	calls := failCommits(db, -1, boom)
+	if err := db.Put("a", 1); err != boom {
+		t.Fatalf("got %v, expected boom", err)
+	}
+	if calls() != 1 {
+		t.Fatalf("%d attempts, expected 1", calls())
+	}
+
+	// errors of the write itself are never retried
+	db = openTestStore(t, WithRetry(4, time.Millisecond, func(error) bool { return true }))
+	calls = failCommits(db, 0, nil)
+	if err := db.Delete("missing"); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if calls() != 0 {
+		t.Fatalf("%d commits attempted, expected none", calls())
+	}
+
+	// nor are writes with effects outside the transaction
+	calls = failCommits(db, 1, errFsync)
+	reduce := func(string, []byte, string, []byte, bool) ([]byte, error) { return nil, nil }
+	route := func(string, []byte) string { return "" }
+	if err := db.CreateView("v", "", reduce, route); err != errFsync {
+		t.Fatalf("got %v, expected the commit error", err)
+	}
+	if calls() != 1 {
+		t.Fatalf("%d attempts, expected 1", calls())
+	}
+	if err := db.CreateView("v", "", reduce, route); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	db := openTestStore(t, WithRetry(4, 20*time.Millisecond, nil))
+	failCommits(db, -1, errFsync)
+	start := time.Now()
+	if err := db.Put("a", 1); err == nil {
+		t.Fatal("Put succeeded")
+	}
+	// 20ms, 40ms and 80ms between the four attempts
+	if d := time.Since(start); d < 140*time.Millisecond || d > 2*time.Second {
+		t.Fatalf("took %v, expected about 140ms", d)
+	}
+}
+
+func TestRetryWriteBuffer(t *testing.T) {
+	db := openTestStore(t, WithRetry(3, time.Millisecond, nil), WithWriteBuffer(100, 0))
+	calls := failCommits(db, 2, errFsync)
+	if err := db.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if calls() != 3 {
+		t.Fatalf("%d attempts, expected 3", calls())
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	for err, want := range map[error]bool{
+		errFsync: true,
+		fmt.Errorf("mmap allocate error: %s", syscall.ENOMEM):      true,
+		fmt.Errorf("file resize error: %s", syscall.ENOSPC):        true,
+		fmt.Errorf("file sync error: %s", syscall.EINTR):           true,
+		fmt.Errorf("wrapped: %w", syscall.EAGAIN):                  true,
+		fmt.Errorf("mmap allocate error: %s", syscall.EINVAL):      false,
+		errors.New("mmap too large"):                               false,
+		ErrNotFound:                                                false,
+		&os.PathError{Op: "write", Path: "x", Err: syscall.EACCES}: false,
+	} {
+		if got := IsTransient(err); got != want {
+			t.Errorf("IsTransient(%v) = %t, expected %t", err, got, want)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package bboltkv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.etcd.io/bbolt"
+)
+
+// rotation is the state of a store opened with OpenRotating.
+type rotation struct {
+	paths  [2]string
+	marker string // names the active file, see writeMarker
+	every  int
+
+	commits int64 // since the last rotation, updated atomically
+	due     chan struct{}
+
+	mu        sync.Mutex               // serializes rotations
+	active    int                      // index into paths of the file in use
+	rotations int                      // rotations made, for tests
+	hook      func(stage string) error // run at each stage of a rotation, for tests
+}
+
+// OpenRotating opens a key-value store like Open, kept in one of two
+// database files, pathA and pathB, for workloads that only ever need the
+// latest values of a fairly small set of keys. Such workloads grow a single
+// file under churn, since bbolt never shrinks one, and reuses its freed
+// pages only so well. Every rotateEvery commits, the store compacts its
+// data into the other file and switches to it, in the background.
+//
+// The file in use is named in a marker file next to pathA, with the suffix
+// ".active". A rotation writes the compacted file in full, and renames it
+// into place, before it replaces the marker, so that OpenRotating picks
+// the right file after a crash at any point; the file the marker does not
+// name is only ever a stale or partial copy.
+//
+// The store works like one opened with Open. A rotation waits for a moment
+// when no operation is under way, and holds off new ones while it runs, so
+// a store that is never idle rotates only once its load lets up. GetDb
+// returns the database in use, which changes with each rotation.
+// WithBackgroundCheck runs the check before OpenRotating returns, and
+// read-only stores never rotate.
+//
+//	store, err := bboltkv.OpenRotating("state-a.db", "state-b.db", "state", 1000)
+func OpenRotating(pathA, pathB, bucketName string, rotateEvery int, opts ...Option) (*Store, error) {
+	if rotateEvery < 1 {
+		return nil, errors.New("bboltkv: a rotating store needs rotateEvery of at least 1")
+	}
+	r := &rotation{
+		paths:  [2]string{pathA, pathB},
+		marker: pathA + ".active",
+		every:  rotateEvery,
+		due:    make(chan struct{}, 1),
+	}
+	var err error
+	if r.active, err = readMarker(r.marker); err != nil {
+		return nil, err
+	}
+	// a rotation cut short leaves its compacted file behind
+	os.Remove(r.paths[1-r.active] + ".tmp")
+	o := buildOptions(opts)
+	o.checkBackground = false
+	s, err := open(r.paths[r.active], bucketName, o)
+	if err != nil {
+		return nil, err
+	}
+	s.rot = r
+	s.release = func() error { return s.db.Close() }
+	if !o.readOnly {
+		s.goBackground(s.rotateLoop)
+	}
+	return s, nil
+}
+
+// readMarker returns the index of the file a marker file names; if there
+// is no marker yet, it is the first.
+func readMarker(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	switch strings.TrimSpace(string(b)) {
+	case "a":
+		return 0, nil
+	case "b":
+		return 1, nil
+	}
+	return 0, fmt.Errorf("%w: bad marker file %s", ErrCorrupt, path)
+}
+
+// writeMarker replaces the marker file at path with one naming the file of
+// index active, atomically.
+func writeMarker(path string, active int) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString([]string{"a\n", "b\n"}[active])
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir makes the renames in the directory at path durable.
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// committed counts a commit of a rotating store, and has the rotation loop
+// rotate once enough have been made.
+func (r *rotation) committed() {
+	if atomic.AddInt64(&r.commits, 1) >= int64(r.every) {
+		select {
+		case r.due <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *Store) rotateLoop(done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-s.rot.due:
+			// a failed rotation is tried again after the next commit
+			s.rotate()
+		}
+	}
+}
+
+// Rotate compacts the store's data into the other file of a store opened
+// with OpenRotating and switches to it now, rather than once rotateEvery
+// commits have been made. It waits for a moment when no operation is under
+// way, as rotations do.
+func (s *Store) Rotate() error {
+	if s.rot == nil {
+		return errors.New("bboltkv: store was not opened with OpenRotating")
+	}
+	if s.inCallback() {
+		return ErrReentrant
+	}
+	return s.rotate()
+}
+
+func (s *Store) rotate() error {
+	r := s.rot
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !s.gate.pause(s.done) {
+		return ErrClosed
+	}
+	defer s.gate.resume()
+	// writes outside the gate, such as a background check, end first
+	thawed, err := s.freezer.freeze(context.Background())
+	if err != nil {
+		return err
+	}
+	defer s.freezer.thaw(thawed)
+
+	next := 1 - r.active
+	path := r.paths[next]
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	dst, err := bbolt.Open(tmp, 0640, rewriteOptions(s.db))
+	if err != nil {
+		return err
+	}
+	err = r.stage("compacting")
+	if err == nil {
+		err = bbolt.Compact(dst, s.db, 0)
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = r.stage("compacted")
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	if err := r.stage("renamed"); err != nil {
+		return err
+	}
+	db, err := openDB(path, s.opts)
+	if err != nil {
+		return err
+	}
+	// the marker switches over, so nothing can fail after it
+	err = r.stage("marking")
+	if err == nil {
+		err = writeMarker(r.marker, next)
+	}
+	if err != nil {
+		db.Close()
+		return err
+	}
+	old := s.db
+	s.db = db
+	r.active = next
+	atomic.StoreInt64(&r.commits, 0)
+	r.rotations++
+	old.Close()
+	return nil
+}
+
+// stage runs the test hook for the given stage of a rotation.
+func (r *rotation) stage(name string) error {
+	if r.hook != nil {
+		return r.hook(name)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package bboltkv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openRotatingTest(t *testing.T, dir string, every int, opts ...Option) *Store {
+	t.Helper()
+	db, err := OpenRotating(filepath.Join(dir, "a.db"), filepath.Join(dir, "b.db"), "test", every, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// waitRotations waits for db to have rotated n times.
+func waitRotations(t *testing.T, db *Store, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		db.rot.mu.Lock()
+		got := db.rot.rotations
+		db.rot.mu.Unlock()
+		if got >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("rotated %d times, expected %d", got, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func checkValues(t *testing.T, db *Store, want map[string]int) {
+	t.Helper()
+	for k, w := range want {
+		var v int
+		if err := db.Get(k, &v); err != nil || v != w {
+			t.Fatalf("%s: got %d, %v, expected %d", k, v, err, w)
+		}
+	}
+	if n, err := db.Count(); err != nil || n != len(want) {
+		t.Fatalf("got %d entries, %v, expected %d", n, err, len(want))
+	}
+}
+
+func TestRotating(t *testing.T) {
+	dir := t.TempDir()
+	db := openRotatingTest(t, dir, 10)
+	want := map[string]int{}
+	for i := 0; i < 25; i++ {
+		k := keyN(i % 7)
+		if err := db.Put(k, i); err != nil {
+			t.Fatal(err)
+		}
+		want[k] = i
+		if i == 12 {
+			waitRotations(t, db, 1)
+		}
+	}
+	waitRotations(t, db, 2)
+	checkValues(t, db, want)
+	// the store's bookkeeping carries over
+	want["seq"] = 1
+	seq := func() uint64 {
+		n, err := db.PutSeq("seq", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	first := seq()
+	if err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if next := seq(); next != first+1 {
+		t.Fatalf("sequence went from %d to %d over a rotation", first, next)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db = openRotatingTest(t, dir, 10)
+	defer db.Close()
+	checkValues(t, db, want)
+}
+
+// copyFiles copies the files of dir that exist into a new directory.
+func copyFiles(t *testing.T, dir string) string {
+	t.Helper()
+	to := t.TempDir()
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		b, err := ioutil.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(to, e.Name()), b, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return to
+}
+
+func TestRotatingCrash(t *testing.T) {
+	dir := t.TempDir()
+	db := openRotatingTest(t, dir, 1000)
+	defer db.Close()
+	// a first rotation leaves a.db stale, and b.db in use
+	if err := db.Put("old", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"old": 1}
+	for i := 0; i < 5; i++ {
+		if err := db.Put(keyN(i), i); err != nil {
+			t.Fatal(err)
+		}
+		want[keyN(i)] = i
+	}
+
+	crashed := map[string]string{"before": copyFiles(t, dir)}
+	db.rot.hook = func(stage string) error {
+		crashed[stage] = copyFiles(t, dir)
+		return nil
+	}
+	if err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	db.rot.hook = nil
+	crashed["after"] = copyFiles(t, dir)
+
+	for stage, active := range map[string]int{
+		"before": 1, "compacting": 1, "compacted": 1, "renamed": 1, "marking": 1, "after": 0,
+	} {
+		crash := openRotatingTest(t, crashed[stage], 1000)
+		if crash.rot.active != active {
+			t.Errorf("%s: opened file %d, expected %d", stage, crash.rot.active, active)
+		}
+		checkValues(t, crash, want)
+		// the leftover compacted file is gone, and rotation works on
+		if _, err := os.Stat(filepath.Join(crashed[stage], "a.db.tmp")); !os.IsNotExist(err) {
+			t.Errorf("%s: compacted file left behind", stage)
+		}
+		if err := crash.Rotate(); err != nil {
+			t.Fatalf("%s: %v", stage, err)
+		}
+		checkValues(t, crash, want)
+		crash.Close()
+	}
+}
+
+func TestRotatingBoundedSize(t *testing.T) {
+	dir := t.TempDir()
+	db := openRotatingTest(t, dir, 50)
+	defer db.Close()
+	value := make([]byte, 4<<10)
+	size := func() int64 {
+		db.rot.mu.Lock()
+		path := db.rot.paths[db.rot.active]
+		db.rot.mu.Unlock()
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+	var largest int64
+	for round := 0; round < 20; round++ {
+		// churn on a few keys, with a burst of short-lived ones
+		batch := map[string]interface{}{}
+		for i := 0; i < 200; i++ {
+			batch[fmt.Sprintf("tmp:%d", i)] = value
+		}
+		if err := db.PutAll(batch); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 200; i++ {
+			if err := db.Delete(fmt.Sprintf("tmp:%d", i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 0; i < 50; i++ {
+			if err := db.Put(keyN(i%10), value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if s := size(); s > largest {
+			largest = s
+		}
+	}
+	waitRotations(t, db, 20)
+	if err := db.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	// 10 values of 4 KiB each take a few pages
+	if s := size(); s > 512<<10 {
+		t.Fatalf("file is %d bytes after rotating, at most %d before", s, largest)
+	}
+	if largest > 8<<20 {
+		t.Fatalf("file grew to %d bytes", largest)
+	}
+}
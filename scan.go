@@ -0,0 +1,131 @@
+package bboltkv
+
+import (
+	"go.etcd.io/bbolt"
+)
+
+const (
+	scanBucket = "scans"
+
+	// defaultCheckpoint is the number of entries between checkpoints of a
+	// resumable scan, unless WithCheckpointEvery says otherwise.
+	defaultCheckpoint = 1000
+)
+
+// WithCheckpointEvery makes ResumableScan save its position after every n
+// entries, rather than every 1000. Smaller values repeat less work after an
+// interruption, at the cost of a write transaction per checkpoint.
+func WithCheckpointEvery(n int) OpOption {
+	return func(o *opOptions) {
+		o.checkpoint = n
+	}
+}
+
+// ResumableScan calls fn for every entry in the store, in key order, with
+// the encoded value as stored, which PutEncoded accepts. After every 1000
+// entries, or as set with WithCheckpointEvery, it saves the last key it got
+// to under the given name, in the database file itself. If the scan is
+// interrupted, because fn returned an error or the process died, the next
+// call with the same name resumes just past that checkpoint. Entries fn
+// handled after the last checkpoint are handed to it again, so fn should
+// tolerate that. Once the scan reaches the end, its checkpoint is removed,
+// and the next call starts over.
+//
+// Entries are read in batches, and fn runs outside of any transaction, so
+// the store can be written to while the scan runs. Keys added behind the
+// scan's position in the meantime are not visited, and keys deleted ahead
+// of it are not either. Scans with different names are independent.
+//
+//	err := store.ResumableScan("nightly", func(key string, raw []byte) error {
+//	    return archive(key, raw)
+//	})
+func (s *Store) ResumableScan(name string, fn func(key string, raw []byte) error, opts ...OpOption) error {
+	o := buildOpOptions(opts)
+	every := o.checkpoint
+	if every <= 0 {
+		every = defaultCheckpoint
+	}
+	var after []byte
+	err := s.view(func(tx *bbolt.Tx) error {
+		if b := s.aux(tx, scanBucket); b != nil {
+			if v := b.Get([]byte(name)); v != nil {
+				after = append([]byte(nil), v...)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for {
+		keys, values, err := s.readBatch(after, every)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return s.ResetScan(name)
+		}
+		for i, k := range keys {
+			key, err := s.openKey(k)
+			if err != nil {
+				return err
+			}
+			if err := fn(key, values[i]); err != nil {
+				return err
+			}
+		}
+		if len(keys) < every {
+			return s.ResetScan(name)
+		}
+		after = keys[len(keys)-1]
+		err = s.update(func(w *wtx) error {
+			b, err := w.aux(scanBucket)
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte(name), after)
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ResetScan removes the checkpoint of the resumable scan with the given
+// name, so that the next call of ResumableScan starts from the first key.
+// Resetting a scan that has no checkpoint is not an error.
+func (s *Store) ResetScan(name string) error {
+	return s.update(func(w *wtx) error {
+		if b := s.aux(w.tx, scanBucket); b != nil {
+			return b.Delete([]byte(name))
+		}
+		return nil
+	})
+}
+
+// readBatch returns copies of up to n entries following the key after, or
+// from the first key if after is nil, leaving out the keys Keys leaves out.
+func (s *Store) readBatch(after []byte, n int) (keys, values [][]byte, err error) {
+	err = s.view(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(s.bucketName).Cursor()
+		k, v := c.First()
+		if after != nil {
+			if k, v = c.Seek(after); k != nil && string(k) == string(after) {
+				k, v = c.Next()
+			}
+		}
+		for ; k != nil && len(keys) < n; k, v = c.Next() {
+			if s.hidden(tx, k) {
+				continue
+			}
+			v, err := s.assemble(tx, k, v)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, append([]byte(nil), k...))
+			values = append(values, append([]byte(nil), v...))
+		}
+		return nil
+	})
+	return keys, values, err
+}
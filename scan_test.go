@@ -0,0 +1,119 @@
+package bboltkv
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestResumableScan(t *testing.T) {
+	db := openTestStore(t)
+	fillStore(t, db, 250)
+	seen := map[string]int{}
+	errKilled := errors.New("killed")
+	n := 0
+	scan := func(key string, raw []byte) error {
+		if n++; n == 130 {
+			return errKilled
+		}
+		seen[key]++
+		return nil
+	}
+	if err := db.ResumableScan("job", scan, WithCheckpointEvery(50)); err != errKilled {
+		t.Fatalf("got %v, expected the callback's error", err)
+	}
+	if len(seen) != 129 {
+		t.Fatalf("saw %d keys before the error, expected 129", len(seen))
+	}
+	if err := db.ResumableScan("job", scan, WithCheckpointEvery(50)); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 250 {
+		t.Fatalf("saw %d keys, expected 250", len(seen))
+	}
+	for i := 0; i < 250; i++ {
+		want := 1
+		if i >= 100 && i < 129 {
+			// processed after the last checkpoint before the error
+			want = 2
+		}
+		if seen[keyN(i)] != want {
+			t.Fatalf("%s processed %d times, expected %d", keyN(i), seen[keyN(i)], want)
+		}
+	}
+
+	// a finished scan starts over
+	count := 0
+	if err := db.ResumableScan("job", func(string, []byte) error { count++; return nil }); err != nil {
+		t.Fatal(err)
+	} else if count != 250 {
+		t.Fatalf("got %d keys, expected 250", count)
+	}
+}
+
+func TestResumableScanBehindCursor(t *testing.T) {
+	db := openTestStore(t)
+	fillStore(t, db, 20)
+	var keys []string
+	err := db.ResumableScan("job", func(key string, raw []byte) error {
+		if key == keyN(10) {
+			// behind the scan, and ahead of it in the next batch
+			if err := db.Put("a", 1); err != nil {
+				return err
+			}
+			if err := db.Put("k00015x", 1); err != nil {
+				return err
+			}
+		}
+		keys = append(keys, key)
+		return nil
+	}, WithCheckpointEvery(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 21 || keys[0] != keyN(0) || keys[16] != "k00015x" {
+		t.Fatalf("got %q", keys)
+	}
+}
+
+func TestResetScan(t *testing.T) {
+	db := openTestStore(t)
+	fillStore(t, db, 30)
+	stop := errors.New("stop")
+	first := func(name string) string {
+		var got string
+		err := db.ResumableScan(name, func(key string, raw []byte) error {
+			got = key
+			return stop
+		}, WithCheckpointEvery(10))
+		if err != stop {
+			t.Fatal(err)
+		}
+		return got
+	}
+	// advance "a" by one checkpoint, leave "b" alone
+	n := 0
+	err := db.ResumableScan("a", func(key string, raw []byte) error {
+		if n++; n > 10 {
+			return stop
+		}
+		return nil
+	}, WithCheckpointEvery(10))
+	if err != stop {
+		t.Fatal(err)
+	}
+	if got := first("a"); got != keyN(10) {
+		t.Fatalf("scan a resumed at %s, expected %s", got, keyN(10))
+	}
+	if got := first("b"); got != keyN(0) {
+		t.Fatalf("scan b started at %s, expected %s", got, keyN(0))
+	}
+	if err := db.ResetScan("a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := first("a"); got != keyN(0) {
+		t.Fatalf("reset scan started at %s, expected %s", got, keyN(0))
+	}
+	if err := db.ResetScan("unknown"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,208 @@
+package bboltkv
+
+import (
+	"bytes"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// scheduledBucket maps the keys scheduled for deletion with ScheduleDelete
+// to the time they are destroyed at, and scheduledIndexBucket holds the
+// same keys, each following that time, so that they can be reaped in
+// order, like the expiry buckets. Writing or deleting a key through wtx
+// clears its schedule.
+const (
+	scheduledBucket      = "scheduled-deletes"
+	scheduledIndexBucket = "scheduled-deletes-index"
+)
+
+// ScheduleDelete hides the entry with the given key until after has
+// passed, and then destroys it: meanwhile, it is left out of every read as
+// if deleted, so Get returns ErrNotFound and Keys and Count skip it, but
+// its value and everything attached to it are kept, so that
+// CancelScheduledDelete can bring it back as it was. Once after has passed,
+// the entry can no longer be brought back, and ReapScheduledDeletes, or the
+// sweep of WithExpirySweep, deletes it like Delete. Writing to the key in
+// the meantime, with Put or any other method, replaces the hidden entry and
+// cancels its deletion, as writing to an expired key does; deleting it
+// fails with ErrNotFound. A TTL running out during the grace period still
+// deletes the entry, as it would have.
+//
+// If no such key is present, it returns ErrNotFound, also if it is already
+// scheduled; if it is protected, ErrProtected, and if it is immutable,
+// ErrImmutable. A non-positive after fails with ErrBadValue.
+//
+//	err := store.ScheduleDelete("user:42", 7*24*time.Hour)
+func (s *Store) ScheduleDelete(key string, after time.Duration) error {
+	if after <= 0 {
+		return ErrBadValue
+	}
+	key = s.sealKey(key)
+	return s.update(func(w *wtx) error {
+		if w.get(key) == nil {
+			return ErrNotFound
+		} else if s.protected(key) {
+			return ErrProtected
+		}
+		if err := w.checkMutable(key); err != nil {
+			return err
+		}
+		b, err := w.aux(scheduledBucket)
+		if err != nil {
+			return err
+		}
+		index, err := w.aux(scheduledIndexBucket)
+		if err != nil {
+			return err
+		}
+		at := encodeExpiry(s.now().Add(after))
+		if err := index.Put(expiryIndexKey(at, key), nil); err != nil {
+			return err
+		}
+		w.stale = append(w.stale, key)
+		return b.Put([]byte(key), at)
+	})
+}
+
+// CancelScheduledDelete brings back the entry with the given key that
+// ScheduleDelete hid, as it was before. If the key is not scheduled for
+// deletion, or its grace period has passed, it returns ErrNotFound.
+func (s *Store) CancelScheduledDelete(key string) error {
+	key = s.sealKey(key)
+	return s.update(func(w *wtx) error {
+		at, ok := s.scheduledAt(w.tx, key)
+		if !ok || !s.now().Before(at) {
+			return ErrNotFound
+		}
+		w.touched = append(w.touched, key)
+		return w.dropSchedule(key)
+	})
+}
+
+// ListScheduledDeletes calls fn with every key scheduled for deletion with
+// ScheduleDelete and the time it is destroyed at, in that order, including
+// those whose grace period has passed but have not been reaped yet.
+// Returning an error from fn stops the iteration and returns that error.
+func (s *Store) ListScheduledDeletes(fn func(key string, destroyAt time.Time) error) error {
+	return s.view(func(tx *bbolt.Tx) error {
+		index := s.aux(tx, scheduledIndexBucket)
+		if index == nil {
+			return nil
+		}
+		c := index.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			key, err := s.openKey(k[8:])
+			if err != nil {
+				return err
+			}
+			if err := fn(key, decodeExpiry(k[:8])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ReapScheduledDeletes deletes the entries whose grace period, given with
+// ScheduleDelete, has passed, and returns how many it deleted. Like
+// SweepExpired, it stops at the first entry whose grace period has not
+// passed, so its cost grows with the number of entries it deletes.
+func (s *Store) ReapScheduledDeletes() (int, error) {
+	b := s.newBatcher(sweepBatch, nil)
+	total := 0
+	for {
+		s.yieldWrites()
+		n, size := 0, b.size
+		start := s.now()
+		err := s.update(func(w *wtx) error {
+			index := s.aux(w.tx, scheduledIndexBucket)
+			if index == nil {
+				return nil
+			}
+			now := encodeExpiry(s.now())
+			var keys []string
+			c := index.Cursor()
+			for k, _ := c.First(); k != nil && len(keys) < size; k, _ = c.Next() {
+				if bytes.Compare(k[:8], now) > 0 {
+					break
+				}
+				keys = append(keys, string(k[8:]))
+			}
+			for _, key := range keys {
+				if err := w.delete(key); err != nil {
+					return err
+				}
+			}
+			n = len(keys)
+			return nil
+		})
+		if err == nil {
+			b.done(n, s.now().Sub(start))
+		}
+		total += n
+		if err != nil || n < size {
+			return total, err
+		}
+	}
+}
+
+// scheduledAt returns the time key is destroyed at, if it is scheduled for
+// deletion.
+func (s *Store) scheduledAt(tx *bbolt.Tx, key string) (time.Time, bool) {
+	b := s.aux(tx, scheduledBucket)
+	if b == nil {
+		return time.Time{}, false
+	}
+	at := b.Get([]byte(key))
+	if at == nil {
+		return time.Time{}, false
+	}
+	return decodeExpiry(at), true
+}
+
+// dropSchedule cancels the scheduled deletion of key, if any.
+func (w *wtx) dropSchedule(key string) error {
+	b := w.s.aux(w.tx, scheduledBucket)
+	if b == nil {
+		return nil
+	}
+	at := b.Get([]byte(key))
+	if at == nil {
+		return nil
+	}
+	if err := w.s.aux(w.tx, scheduledIndexBucket).Delete(expiryIndexKey(at, key)); err != nil {
+		return err
+	}
+	return b.Delete([]byte(key))
+}
+
+// checkScheduledDeletes is the Fsck check of the buckets of ScheduleDelete.
+func checkScheduledDeletes(f *fsck) {
+	f.checkTimeIndex(scheduledBucket, scheduledIndexBucket, false)
+	b := f.s.aux(f.tx, scheduledBucket)
+	if b == nil {
+		return
+	}
+	now := f.s.now()
+	c := b.Cursor()
+	for k, at := c.First(); k != nil; k, at = c.Next() {
+		if !f.present(k) {
+			dropIndex, dropSchedule := deleteFix(scheduledIndexBucket, expiryIndexKey(at, string(k))), deleteFix(scheduledBucket, k)
+			f.report(FsckOrphanMetadata, scheduledBucket, k, "scheduled deletion of an absent key", func(w *wtx) error {
+				if err := dropIndex(w); err != nil {
+					return err
+				}
+				return dropSchedule(w)
+			})
+		} else if !now.Before(decodeExpiry(at)) {
+			key := string(k)
+			f.report(FsckExpired, scheduledBucket, k, "to be destroyed at "+decodeExpiry(at).UTC().Format(time.RFC3339Nano), func(w *wtx) error {
+				if w.b.Get([]byte(key)) == nil {
+					return nil
+				}
+				return w.delete(key)
+			})
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package bboltkv
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/unknownnf/bboltkv/testutil"
+	"go.etcd.io/bbolt"
+)
+
+// checkHidden checks that key is left out of every read.
+func checkHidden(t *testing.T, db *Store, key string) {
+	t.Helper()
+	if err := db.Get(key, nil); err != ErrNotFound {
+		t.Fatalf("Get: got %v, expected ErrNotFound", err)
+	}
+	if _, err := db.GetRaw(key); err != ErrNotFound {
+		t.Fatalf("GetRaw: got %v, expected ErrNotFound", err)
+	}
+	if has, err := db.Has(key); err != nil || has {
+		t.Fatalf("Has: got %v, %v", has, err)
+	}
+	if has, err := db.HasMulti([]string{key}); err != nil || has[0] {
+		t.Fatalf("HasMulti: got %v, %v", has, err)
+	}
+	if _, err := db.TTL(key); err != ErrNotFound {
+		t.Fatalf("TTL: got %v, expected ErrNotFound", err)
+	}
+	if _, err := db.TagsOf(key); err != ErrNotFound {
+		t.Fatalf("TagsOf: got %v, expected ErrNotFound", err)
+	}
+	keys, err := db.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range keys {
+		if k == key {
+			t.Fatalf("Keys: got %v", keys)
+		}
+	}
+	err = db.ForEach(func(k string, _ func(interface{}) error) error {
+		if k == key {
+			t.Errorf("ForEach visited %s", key)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tagged, err := db.KeysByTag("red"); err != nil || len(tagged) != 0 {
+		t.Fatalf("KeysByTag: got %v, %v", tagged, err)
+	}
+	var buf bytes.Buffer
+	if err := db.ExportJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte(`"`+key+`"`)) {
+		t.Fatalf("ExportJSON: got %s", buf.String())
+	}
+	if err := db.Delete(key); err != ErrNotFound {
+		t.Fatalf("Delete: got %v, expected ErrNotFound", err)
+	}
+}
+
+// scheduled returns the keys ListScheduledDeletes reports.
+func scheduled(t *testing.T, db *Store) []string {
+	t.Helper()
+	var keys []string
+	err := db.ListScheduledDeletes(func(key string, _ time.Time) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestScheduleDelete(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock), WithReadCache(10))
+	if err := db.PutTagged("user:1", 1, "red"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("user:2", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get("user:1", nil); err != nil { // cached
+		t.Fatal(err)
+	}
+	if err := db.ScheduleDelete("user:1", 7*24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	checkHidden(t, db, "user:1")
+	if n, err := db.Count(); err != nil || n != 1 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	if err := db.ScheduleDelete("user:1", time.Hour); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	var at time.Time
+	err := db.ListScheduledDeletes(func(key string, destroyAt time.Time) error {
+		at = destroyAt
+		return nil
+	})
+	if err != nil || !at.Equal(clock.Now().Add(7*24*time.Hour)) {
+		t.Fatalf("got %v, %v", at, err)
+	}
+
+	// cancelling brings it back, tags included
+	clock.Advance(6 * 24 * time.Hour)
+	if n, err := db.ReapScheduledDeletes(); err != nil || n != 0 {
+		t.Fatalf("reaped %d, %v", n, err)
+	}
+	if err := db.CancelScheduledDelete("user:1"); err != nil {
+		t.Fatal(err)
+	}
+	checkValues(t, db, map[string]int{"user:1": 1, "user:2": 2})
+	if tagged, err := db.KeysByTag("red"); err != nil || !reflect.DeepEqual(tagged, []string{"user:1"}) {
+		t.Fatalf("got %v, %v", tagged, err)
+	}
+	if err := db.CancelScheduledDelete("user:1"); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if keys := scheduled(t, db); len(keys) != 0 {
+		t.Fatalf("still scheduled: %v", keys)
+	}
+
+	// once the grace period has passed, it is destroyed
+	if err := db.ScheduleDelete("user:1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	if err := db.CancelScheduledDelete("user:1"); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	checkHidden(t, db, "user:1")
+	if n, err := db.ReapScheduledDeletes(); err != nil || n != 1 {
+		t.Fatalf("reaped %d, %v", n, err)
+	}
+	if keys := scheduled(t, db); len(keys) != 0 {
+		t.Fatalf("still scheduled: %v", keys)
+	}
+	if err := db.view(func(tx *bbolt.Tx) error {
+		if tx.Bucket(db.bucketName).Get([]byte("user:1")) != nil {
+			t.Error("value kept after reaping")
+		}
+		if b := db.aux(tx, tagsBucket); b != nil && b.Get([]byte("user:1")) != nil {
+			t.Error("tags kept after reaping")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if report, err := db.Fsck(); err != nil || !report.Clean() {
+		t.Fatalf("got %+v, %v", report, err)
+	}
+
+	if err := db.ScheduleDelete("missing", time.Hour); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if err := db.ScheduleDelete("user:2", 0); err != ErrBadValue {
+		t.Fatalf("got %v, expected ErrBadValue", err)
+	}
+	if err := db.Protect("user:2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ScheduleDelete("user:2", time.Hour); err != ErrProtected {
+		t.Fatalf("got %v, expected ErrProtected", err)
+	}
+}
+
+func TestScheduleDeleteThenPut(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock))
+	if err := db.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ScheduleDelete("a", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	// writing replaces the hidden entry and cancels its deletion
+	if err := db.Put("a", 2); err != nil {
+		t.Fatal(err)
+	}
+	checkValues(t, db, map[string]int{"a": 2})
+	if keys := scheduled(t, db); len(keys) != 0 {
+		t.Fatalf("still scheduled: %v", keys)
+	}
+	clock.Advance(time.Hour)
+	if n, err := db.ReapScheduledDeletes(); err != nil || n != 0 {
+		t.Fatalf("reaped %d, %v", n, err)
+	}
+	checkValues(t, db, map[string]int{"a": 2})
+	if err := db.CancelScheduledDelete("a"); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+}
+
+func TestScheduleDeleteSweep(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock), WithExpirySweep(time.Minute))
+	for _, key := range []string{"a", "b", "c"} {
+		if err := db.Put(key, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.ScheduleDelete("a", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ScheduleDelete("b", 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if keys := scheduled(t, db); !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Fatalf("got %v", keys)
+	}
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+	clock.Advance(time.Hour)
+	waitFor(t, func() bool { return !inFile(t, db, "a") })
+	if keys := scheduled(t, db); !reflect.DeepEqual(keys, []string{"b"}) {
+		t.Fatalf("got %v", keys)
+	}
+	if err := db.CancelScheduledDelete("b"); err != nil {
+		t.Fatal(err)
+	}
+	checkValues(t, db, map[string]int{"b": 1, "c": 1})
+}
+
+func TestScheduleDeleteFsck(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock))
+	for _, key := range []string{"a", "b", "c"} {
+		if err := db.Put(key, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.ScheduleDelete(key, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.RawUpdate(func(b *bbolt.Bucket) error { return b.Delete([]byte("a")) }); err != nil {
+		t.Fatal(err)
+	}
+	auxUpdate(t, db, scheduledIndexBucket, func(b *bbolt.Bucket) error {
+		return b.Delete(expiryIndexKey(encodeExpiry(clock.Now().Add(time.Hour)), "c"))
+	})
+	clock.Advance(time.Hour)
+	report, err := db.FsckRepair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIssues(t, report,
+		"orphan metadata scheduled-deletes a",
+		"expired scheduled-deletes b",
+		"expired scheduled-deletes c",
+		"missing metadata scheduled-deletes-index c",
+	)
+	if report, err := db.Fsck(); err != nil || !report.Clean() {
+		t.Fatalf("got %+v, %v", report, err)
+	}
+	if keys := scheduled(t, db); len(keys) != 0 {
+		t.Fatalf("still scheduled: %v", keys)
+	}
+}
@@ -0,0 +1,104 @@
+package bboltkv
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// ErrSchemaMismatch is wrapped by the error returned when a value does not
+// have the type registered for its key with RegisterSchema.
+var ErrSchemaMismatch = errors.New("bboltkv: value does not match schema")
+
+// schemas maps key prefixes to the types registered for them.
+type schemas struct {
+	mu    sync.RWMutex
+	types map[string]reflect.Type
+}
+
+// WithSchemaEnforcement makes Put and the other methods writing values
+// check them against the types registered with RegisterSchema.
+func WithSchemaEnforcement() Option {
+	return func(o *options) {
+		o.schemaPuts = true
+	}
+}
+
+// WithSchemaCheckedGets makes Get check the value it decodes into against
+// the types registered with RegisterSchema, so reading a key into the wrong
+// type fails before the value is read.
+func WithSchemaCheckedGets() Option {
+	return func(o *options) {
+		o.schemaGets = true
+	}
+}
+
+// RegisterSchema registers the type of prototype for the keys starting with
+// prefix. With WithSchemaEnforcement, writing a value of another type under
+// such a key then fails with an error wrapping ErrSchemaMismatch, before
+// any transaction begins. Where registered prefixes overlap, the longest
+// one matching the key applies; keys matching none are unrestricted.
+//
+// Pointers do not matter: registering User{} or &User{} is the same, and
+// both User and *User values match either. Values written with PutEncoded
+// or PutAllEncoded are not checked, as they are already encoded.
+// Registering a nil prototype removes the registration for prefix. The
+// registered types are also those GetShared decodes values into.
+//
+//	store.RegisterSchema("user:", User{})
+//	store.RegisterSchema("user:admin:", Admin{})
+//	err := store.Put("user:42", Admin{}) // fails
+func (s *Store) RegisterSchema(prefix string, prototype interface{}) {
+	s.schemas.mu.Lock()
+	defer s.schemas.mu.Unlock()
+	if c := s.decoded; c != nil {
+		// values were decoded into the types registered before
+		defer c.clear()
+	}
+	if prototype == nil {
+		delete(s.schemas.types, prefix)
+		return
+	}
+	if s.schemas.types == nil {
+		s.schemas.types = make(map[string]reflect.Type)
+	}
+	s.schemas.types[prefix] = baseType(reflect.TypeOf(prototype))
+}
+
+// baseType strips all levels of pointers from t.
+func baseType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// checkSchema checks the type of a value written or read under key against
+// the registered types.
+func (s *Store) checkSchema(key string, value interface{}) error {
+	prefix, want := s.schemaFor(key)
+	if want == nil {
+		return nil
+	}
+	if got := baseType(reflect.TypeOf(value)); got != want {
+		return fmt.Errorf("%w: key %q has prefix %q, registered for %v, but the value is %v",
+			ErrSchemaMismatch, key, prefix, want, got)
+	}
+	return nil
+}
+
+// schemaFor returns the type registered for key and the prefix it was
+// registered for, or a nil type if there is none.
+func (s *Store) schemaFor(key string) (string, reflect.Type) {
+	s.schemas.mu.RLock()
+	defer s.schemas.mu.RUnlock()
+	prefix, want := "", reflect.Type(nil)
+	for p, t := range s.schemas.types {
+		if strings.HasPrefix(key, p) && (want == nil || len(p) > len(prefix)) {
+			prefix, want = p, t
+		}
+	}
+	return prefix, want
+}
@@ -0,0 +1,85 @@
+package bboltkv
+
+import (
+	"errors"
+	"testing"
+)
+
+type schemaUser struct{ Name string }
+
+type schemaAdmin struct {
+	Name  string
+	Level int
+}
+
+func TestSchemaPut(t *testing.T) {
+	db := openTestStore(t, WithSchemaEnforcement())
+	db.RegisterSchema("user:", schemaUser{})
+	db.RegisterSchema("user:admin:", &schemaAdmin{})
+
+	for _, w := range []struct {
+		key   string
+		value interface{}
+		ok    bool
+	}{
+		{"user:1", schemaUser{"ann"}, true},
+		{"user:2", &schemaUser{"bob"}, true},
+		{"user:3", schemaAdmin{"cid", 1}, false},
+		{"user:admin:1", schemaAdmin{"dan", 2}, true},
+		{"user:admin:2", &schemaAdmin{"eve", 3}, true},
+		{"user:admin:3", schemaUser{"fay"}, false},
+		{"other", 42, true},
+	} {
+		err := db.Put(w.key, w.value)
+		if w.ok && err != nil {
+			t.Fatalf("%s: %v", w.key, err)
+		} else if !w.ok && !errors.Is(err, ErrSchemaMismatch) {
+			t.Fatalf("%s: got %v, expected ErrSchemaMismatch", w.key, err)
+		}
+	}
+	if err := db.PutAll(map[string]interface{}{"user:4": schemaUser{}, "user:5": 5}); !errors.Is(err, ErrSchemaMismatch) {
+		t.Fatalf("got %v, expected ErrSchemaMismatch", err)
+	}
+	if err := db.Get("user:4", nil); err != ErrNotFound {
+		t.Fatalf("part of a rejected PutAll was written: %v", err)
+	}
+
+	db.RegisterSchema("user:admin:", nil)
+	if err := db.Put("user:admin:3", schemaUser{"fay"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// without the option, registrations are not enforced
+	free := openTestStore(t)
+	free.RegisterSchema("user:", schemaUser{})
+	if err := free.Put("user:1", 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSchemaGet(t *testing.T) {
+	db := openTestStore(t, WithSchemaCheckedGets())
+	if err := db.Put("user:1", schemaUser{"ann"}); err != nil {
+		t.Fatal(err)
+	}
+	db.RegisterSchema("user:", schemaUser{})
+	var u schemaUser
+	if err := db.Get("user:1", &u); err != nil || u.Name != "ann" {
+		t.Fatalf("got %v, %v", u, err)
+	}
+	var pu *schemaUser
+	if err := db.Get("user:1", &pu); err != nil || pu.Name != "ann" {
+		t.Fatalf("got %v, %v", pu, err)
+	}
+	var a schemaAdmin
+	if err := db.Get("user:1", &a); !errors.Is(err, ErrSchemaMismatch) {
+		t.Fatalf("got %v, expected ErrSchemaMismatch", err)
+	}
+	// checked before the key is looked up
+	if err := db.Get("user:missing", &a); !errors.Is(err, ErrSchemaMismatch) {
+		t.Fatalf("got %v, expected ErrSchemaMismatch", err)
+	}
+	if err := db.Get("user:1", nil); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,95 @@
+package bboltkv
+
+import (
+	"sort"
+
+	"go.etcd.io/bbolt"
+)
+
+// seedBucket maps the names of the seeds SeedOnce has run, each following
+// an 's' so that the empty name has a key, to the time they ran at, as
+// big-endian Unix nanoseconds.
+const seedBucket = "seeds"
+
+// EnsureDefaults writes the entries of defaults whose keys are not present,
+// leaving those that are as they are, in a single transaction, and returns
+// the keys it wrote, sorted. Expired entries count as absent. As writers
+// take turns, stores racing to seed the same file at first start end up
+// with each default written once, by whichever comes first, and never a
+// mix of partial defaults. Values are encoded and validated as with Put,
+// before the transaction begins; if one fails, nothing is written.
+//
+//	created, err := store.EnsureDefaults(map[string]interface{}{
+//	    "config:limits":  Limits{MaxUsers: 100},
+//	    "config:feature": false,
+//	})
+func (s *Store) EnsureDefaults(defaults map[string]interface{}) (applied []string, err error) {
+	keys := make([]string, 0, len(defaults))
+	raw := make(map[string][]byte, len(defaults))
+	for k, v := range defaults {
+		encoded, err := s.encodeForPut(k, v)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+		raw[k] = encoded
+	}
+	sort.Strings(keys)
+	err = s.update(s.named("EnsureDefaults", "", func(w *wtx) error {
+		applied = applied[:0]
+		for _, k := range keys {
+			key := s.sealKey(k)
+			if w.get(key) != nil {
+				continue
+			}
+			if err := w.put(key, raw[k]); err != nil {
+				return err
+			}
+			applied = append(applied, k)
+		}
+		return nil
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// SeedOnce runs fn in a transaction like Update, unless a seed called name
+// has run before, and records that it has in the same transaction. So fn
+// runs once in the lifetime of the store, also across restarts and among
+// stores starting at the same time: if fn returns an error, nothing it
+// wrote is kept and the seed is not recorded, so the next call runs it
+// again. Seeds are recorded in the database file, under names only
+// SeedOnce uses.
+//
+//	err := store.SeedOnce("initial-admin", func(tx *bboltkv.WriteTx) error {
+//	    return tx.InBucket("users").Put("user:admin", admin)
+//	})
+func (s *Store) SeedOnce(name string, fn func(tx *WriteTx) error) (err error) {
+	var t Tracer
+	if s.opts.tracer != nil {
+		var end func(err *error)
+		t, end = s.startTx("SeedOnce")
+		defer end(&err)
+	}
+	return s.update(s.named("SeedOnce", "", func(w *wtx) error {
+		if s.seeded(w.tx, name) {
+			return nil
+		}
+		if err := s.runWriteTx(w, t, fn); err != nil {
+			return err
+		}
+		b, err := w.aux(seedBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("s"+name), encodeExpiry(s.now()))
+	}))
+}
+
+// seeded reports whether the seed called name has run.
+func (s *Store) seeded(tx *bbolt.Tx, name string) bool {
+	b := s.aux(tx, seedBucket)
+	return b != nil && b.Get([]byte("s"+name)) != nil
+}
@@ -0,0 +1,163 @@
+package bboltkv
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestEnsureDefaults(t *testing.T) {
+	db := openTestStore(t)
+	if err := db.Put("b", 10); err != nil {
+		t.Fatal(err)
+	}
+	defaults := map[string]interface{}{"a": 1, "b": 2, "c": 3}
+	applied, err := db.EnsureDefaults(defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "c"}; !reflect.DeepEqual(applied, want) {
+		t.Fatalf("applied %v, expected %v", applied, want)
+	}
+	checkValues(t, db, map[string]int{"a": 1, "b": 10, "c": 3})
+	if applied, err := db.EnsureDefaults(defaults); err != nil || len(applied) != 0 {
+		t.Fatalf("got %v, %v", applied, err)
+	}
+
+	// a value that cannot be encoded writes nothing
+	_, err = db.EnsureDefaults(map[string]interface{}{"d": 4, "e": nil})
+	if err == nil {
+		t.Fatal("wrote a nil default")
+	}
+	checkValues(t, db, map[string]int{"a": 1, "b": 10, "c": 3})
+}
+
+func TestEnsureDefaultsConcurrent(t *testing.T) {
+	db := openTestStore(t)
+	defaults := map[string]interface{}{}
+	for i := 0; i < 50; i++ {
+		defaults[keyN(i)] = i
+	}
+	results := make([][]string, 8)
+	errs := make([]error, len(results))
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = db.EnsureDefaults(defaults)
+		}(i)
+	}
+	wg.Wait()
+	var all []string
+	for i, applied := range results {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if len(applied) != 0 && len(applied) != len(defaults) {
+			t.Fatalf("a caller applied %d defaults of %d", len(applied), len(defaults))
+		}
+		all = append(all, applied...)
+	}
+	if len(all) != len(defaults) {
+		t.Fatalf("applied %d defaults in all, expected %d once each", len(all), len(defaults))
+	}
+	sort.Strings(all)
+	for i, k := range all {
+		if k != keyN(i) {
+			t.Fatalf("applied %v", all)
+		}
+	}
+}
+
+func TestSeedOnce(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(name, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { db.Close() }()
+	runs := 0
+	seed := func(tx *WriteTx) error {
+		runs++
+		return tx.InBucket("test").Put("admin", runs)
+	}
+
+	// failing seeds keep nothing and are not recorded
+	failed := errors.New("failed")
+	err = db.SeedOnce("admin", func(tx *WriteTx) error {
+		if err := tx.InBucket("test").Put("admin", 0); err != nil {
+			return err
+		}
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("got %v, expected the seed's error", err)
+	}
+	checkValues(t, db, map[string]int{})
+
+	for i := 0; i < 2; i++ {
+		if err := db.SeedOnce("admin", seed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if runs != 1 {
+		t.Fatalf("seed ran %d times", runs)
+	}
+	if err := db.Put("admin", 5); err != nil {
+		t.Fatal(err)
+	}
+
+	// across restarts
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Open(name, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SeedOnce("admin", seed); err != nil {
+		t.Fatal(err)
+	}
+	if runs != 1 {
+		t.Fatalf("seed ran %d times", runs)
+	}
+	checkValues(t, db, map[string]int{"admin": 5})
+	// other seeds are independent, the empty name included
+	if err := db.SeedOnce("", seed); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SeedOnce("", seed); err != nil {
+		t.Fatal(err)
+	}
+	if runs != 2 {
+		t.Fatalf("seeds ran %d times", runs)
+	}
+}
+
+func TestSeedOnceConcurrent(t *testing.T) {
+	db := openTestStore(t)
+	var mu sync.Mutex
+	runs := 0
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			errs <- db.SeedOnce("init", func(tx *WriteTx) error {
+				mu.Lock()
+				runs++
+				mu.Unlock()
+				return tx.InBucket("test").Put("init", 1)
+			})
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if runs != 1 {
+		t.Fatalf("seed ran %d times", runs)
+	}
+}
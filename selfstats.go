@@ -0,0 +1,79 @@
+package bboltkv
+
+import (
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// SelfStats is the health report written by WithSelfStats.
+type SelfStats struct {
+	// Time is when the report was written, by the store's clock.
+	Time time.Time
+
+	// Keys is the number of entries in the store, as reported by Count.
+	Keys int
+
+	// FileSize is the size of the database file in bytes, and FreePages
+	// the number of pages in it that are free for reuse.
+	FileSize  int64
+	FreePages int
+
+	// Ops is the number of operations the store has run since it was
+	// opened, counting every read and every write.
+	Ops uint64
+
+	// LastCompaction is when WithAutoCompact compacted the file as the
+	// store was opened, by the store's clock, or the zero time if it did
+	// not.
+	LastCompaction time.Time
+}
+
+// WithSelfStats makes the store write a SelfStats report under key every
+// interval, using Put, so that monitoring agents can read it like any
+// other entry. The key is left out of Keys and Count. A report that fails
+// to be written is skipped; the next one is written an interval later.
+//
+//	store, err := bboltkv.Open(path, "bucket", bboltkv.WithSelfStats("_stats", time.Minute))
+//	...
+//	var stats bboltkv.SelfStats
+//	err = store.Get("_stats", &stats)
+func WithSelfStats(key string, interval time.Duration) Option {
+	return func(o *options) {
+		o.selfStatsKey = key
+		o.selfStatsInterval = interval
+	}
+}
+
+// selfStats gathers the current SelfStats.
+func (s *Store) selfStats() (SelfStats, error) {
+	stats := SelfStats{
+		Time:           s.now(),
+		Ops:            s.gate.ops(),
+		LastCompaction: s.compacted,
+	}
+	var err error
+	if stats.Keys, err = s.Count(); err != nil {
+		return stats, err
+	}
+	err = s.view(func(tx *bbolt.Tx) error {
+		stats.FileSize = tx.Size()
+		stats.FreePages = tx.DB().Stats().FreePageN
+		return nil
+	})
+	return stats, err
+}
+
+// selfStatsLoop writes a SelfStats report every interval.
+func (s *Store) selfStatsLoop(done <-chan struct{}) {
+	for {
+		select {
+		case <-s.clock().After(s.opts.selfStatsInterval):
+		case <-done:
+			return
+		}
+		if stats, err := s.selfStats(); err == nil {
+			s.Put(s.opts.selfStatsKey, stats)
+		}
+	}
+}
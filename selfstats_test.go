@@ -0,0 +1,75 @@
+package bboltkv
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/unknownnf/bboltkv/testutil"
+)
+
+func TestSelfStats(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock), WithSelfStats("_stats", time.Minute))
+	for _, key := range []string{"a", "b"} {
+		if err := db.Put(key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var stats SelfStats
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+	clock.Advance(time.Minute)
+	waitFor(t, func() bool { return db.Get("_stats", &stats) == nil })
+	if stats.Keys != 2 || stats.FileSize == 0 || stats.Ops == 0 || !stats.Time.Equal(clock.Now()) || !stats.LastCompaction.IsZero() {
+		t.Fatalf("got %+v", stats)
+	}
+
+	// the key is left out of Keys and Count
+	if keys, err := db.Keys(); err != nil || !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Fatalf("got %v, %v", keys, err)
+	}
+	if n, err := db.Count(); err != nil || n != 2 {
+		t.Fatalf("got %d, %v, expected 2", n, err)
+	}
+
+	// the report is rewritten every interval
+	if err := db.Put("c", "c"); err != nil {
+		t.Fatal(err)
+	}
+	first := stats
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+	clock.Advance(time.Minute)
+	waitFor(t, func() bool {
+		return db.Get("_stats", &stats) == nil && stats.Time.After(first.Time)
+	})
+	if stats.Keys != 3 || stats.Ops <= first.Ops {
+		t.Fatalf("got %+v after %+v", stats, first)
+	}
+}
+
+func TestSelfStatsErrors(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock), WithSelfStats("_stats", time.Minute))
+	db.setReadOnly()
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+	clock.Advance(time.Minute)
+	// a failed write does not stop the loop
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+	if err := db.Get("_stats", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+}
+
+func TestSelfStatsClose(t *testing.T) {
+	db := openTestStore(t, WithSelfStats("_stats", time.Hour))
+	done := make(chan error)
+	go func() { done <- db.Close() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Close did not stop the stats goroutine")
+	}
+}
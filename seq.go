@@ -0,0 +1,179 @@
+package bboltkv
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// commitsBucket holds no entries; its sequence number is the last sequence
+// number handed out by PutSeq.
+const commitsBucket = "commits"
+
+// ErrUnknownSequence is returned by WaitDurable for sequence numbers that
+// PutSeq has not handed out.
+var ErrUnknownSequence = errors.New("bboltkv: unknown sequence number")
+
+// commitSeqs tracks the sequence numbers of PutSeq.
+type commitSeqs struct {
+	mu      sync.Mutex
+	issued  uint64        // highest sequence number handed out
+	durable uint64        // highest sequence number synced to disk, or lost
+	changed chan struct{} // closed and replaced when durable or lost change
+	lost    []lostSeqs
+}
+
+// lostSeqs is a range of sequence numbers whose writes failed.
+type lostSeqs struct {
+	from, to uint64
+	err      error
+}
+
+func (c *commitSeqs) init(seq uint64) {
+	c.issued, c.durable = seq, seq
+	c.changed = make(chan struct{})
+}
+
+func (c *commitSeqs) issue(seq uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if seq > c.issued {
+		c.issued = seq
+	}
+}
+
+// synced records that the writes up to seq are on disk.
+func (c *commitSeqs) synced(seq uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if seq > c.durable {
+		c.durable = seq
+		close(c.changed)
+		c.changed = make(chan struct{})
+	}
+}
+
+// failed records that the writes after the last durable one, up to seq,
+// were discarded. Flushes commit in order, so no other writes are pending.
+func (c *commitSeqs) failed(seq uint64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lost = append(c.lost, lostSeqs{c.durable + 1, seq, err})
+	c.durable = seq
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// state returns whether seq is durable, the error if its write failed, and
+// a channel that is closed when that might have changed.
+func (c *commitSeqs) state(seq uint64) (bool, error, <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, l := range c.lost {
+		if seq >= l.from && seq <= l.to {
+			return false, l.err, nil
+		}
+	}
+	if seq > c.issued {
+		return false, ErrUnknownSequence, nil
+	}
+	return seq <= c.durable, nil, c.changed
+}
+
+// PutSeq puts an entry into the store like Put, and returns a sequence
+// number for the write. Sequence numbers start at 1 and increase with every
+// call, also across restarts, and a write with a higher sequence number is
+// never committed before one with a lower number. Pass the number to
+// WaitDurable to wait until the write has been synced to disk.
+//
+// With a write buffer, see WithWriteBuffer, the write is buffered like with
+// Put, and its sequence number assigned right away. Without one, the write
+// is committed, and so durable, by the time PutSeq returns.
+func (s *Store) PutSeq(key string, value interface{}) (uint64, error) {
+	raw, err := s.encodeForPut(key, value)
+	if err != nil {
+		return 0, err
+	}
+	key = s.sealKey(key)
+	if s.wbuf != nil {
+		return s.putBufferedSeq(key, raw)
+	}
+	var seq uint64
+	err = s.update(func(w *wtx) error {
+		b, err := w.aux(commitsBucket)
+		if err != nil {
+			return err
+		}
+		if seq, err = b.NextSequence(); err != nil {
+			return err
+		}
+		s.seqs.issue(seq)
+		if err := w.put(key, raw); err != nil {
+			return err
+		}
+		w.tx.OnCommit(func() { s.seqs.synced(seq) })
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+func (s *Store) putBufferedSeq(key string, raw []byte) (uint64, error) {
+	if err := s.enter(); err != nil {
+		return 0, err
+	}
+	defer s.gate.exit()
+	if atomic.LoadInt32(&s.readOnly) != 0 {
+		return 0, ErrReadOnly
+	}
+	if err := s.checkMutable(key); err != nil {
+		return 0, err
+	}
+	seq, full := s.wbuf.addSeq(key, raw, &s.seqs)
+	if full {
+		if err := s.flushBuffer(); err != nil {
+			return 0, err
+		}
+	}
+	return seq, nil
+}
+
+// WaitDurable waits until the write PutSeq returned seq for, and all writes
+// before it, have been synced to disk, flushing the write buffer if that is
+// what it takes. It returns right away for writes that have already been
+// synced, as all writes without a write buffer have been. If the write was
+// lost because its flush failed, WaitDurable returns the error of the
+// flush; if ctx is done first, it returns ctx.Err().
+func (s *Store) WaitDurable(seq uint64, ctx context.Context) error {
+	flushed := false
+	for {
+		durable, err, changed := s.seqs.state(seq)
+		if durable || err != nil {
+			return err
+		}
+		if s.wbuf != nil && !flushed {
+			flushed = true
+			go func() {
+				if s.gate.enter() {
+					if err := s.flushBuffer(); err != nil {
+						s.wbuf.report(err)
+					}
+					s.gate.exit()
+				}
+			}()
+		}
+		select {
+		case <-changed:
+		case <-s.done:
+			if durable, err, _ := s.seqs.state(seq); durable || err != nil {
+				return err
+			}
+			return ErrClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package bboltkv
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPutSeqConcurrent(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithWriteBuffer(7, 0)}} {
+		db := openTestStore(t, opts...)
+		var mu sync.Mutex
+		var seqs []uint64
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				var last uint64
+				for i := 0; i < 50; i++ {
+					seq, err := db.PutSeq(keyN(g*100+i), i)
+					if err != nil {
+						t.Error(err)
+						return
+					} else if seq <= last {
+						t.Errorf("got %d after %d", seq, last)
+					}
+					last = seq
+					mu.Lock()
+					seqs = append(seqs, seq)
+					mu.Unlock()
+				}
+			}(g)
+		}
+		wg.Wait()
+		sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+		for i, seq := range seqs {
+			if seq != uint64(i+1) {
+				t.Fatalf("sequence numbers are not 1 to %d: %v", len(seqs), seqs)
+			}
+		}
+		if err := db.WaitDurable(400, context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPutSeqReopen(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.db")
+	for i, opts := range [][]Option{nil, {WithWriteBuffer(0, 0)}, nil} {
+		db, err := Open(name, "test", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seq, err := db.PutSeq("key", i); err != nil || seq != uint64(i+1) {
+			t.Fatalf("got %d, %v, expected %d", seq, err, i+1)
+		}
+		db.Close()
+	}
+}
+
+func TestWaitDurable(t *testing.T) {
+	// without a write buffer, writes are durable right away
+	db := openTestStore(t)
+	seq, err := db.PutSeq("key", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := db.WaitDurable(seq, ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.WaitDurable(seq+1, context.Background()); err != ErrUnknownSequence {
+		t.Fatalf("got %v, expected ErrUnknownSequence", err)
+	}
+
+	// with one, WaitDurable flushes the buffer
+	db = openTestStore(t, WithWriteBuffer(0, 0))
+	if seq, err = db.PutSeq("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("b", 2); err != nil {
+		t.Fatal(err)
+	}
+	if seq, err = db.PutSeq("c", 3); err != nil || seq != 2 {
+		t.Fatalf("got %d, %v", seq, err)
+	}
+	if inFile(t, db, "a") {
+		t.Fatal("write was not buffered")
+	}
+	if err := db.WaitDurable(seq, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if !inFile(t, db, key) {
+			t.Fatalf("%s is not in the file", key)
+		}
+	}
+}
+
+func TestWaitDurableCancel(t *testing.T) {
+	db := openTestStore(t, WithWriteBuffer(0, 0))
+	seq, err := db.PutSeq("key", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// hold up the flush WaitDurable starts
+	db.wbuf.flushMu.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = db.WaitDurable(seq, ctx)
+	db.wbuf.flushMu.Unlock()
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got %v, expected DeadlineExceeded", err)
+	}
+	if err := db.WaitDurable(seq, context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitDurableLost(t *testing.T) {
+	db := openTestStore(t, WithWriteBuffer(0, 0))
+	seq, err := db.PutSeq("key", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.setReadOnly()
+	if err := db.WaitDurable(seq, context.Background()); err != ErrReadOnly {
+		t.Fatalf("got %v, expected ErrReadOnly", err)
+	}
+}
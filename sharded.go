@@ -0,0 +1,220 @@
+package bboltkv
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+
+	"go.etcd.io/bbolt"
+)
+
+// ShardedStore is a key-value store that spreads its entries over several
+// database files, so that writes to different shards do not wait for each
+// other's file lock. Use the OpenSharded() function to create one, and
+// Close() it when done.
+//
+// Each key lives in exactly one shard, chosen by a hash of the key. The
+// number of shards is fixed for a dataset: opening the same files with a
+// different n routes keys to the wrong shards, and they can no longer be
+// found. There is no support for resharding.
+type ShardedStore struct {
+	shards []*Store
+}
+
+// OpenSharded opens n key-value stores, see Open, and routes keys across
+// them. pathPattern is formatted with fmt.Sprintf and the shard number,
+// from 0 to n-1, to give each shard's file. All shards use the same bucket
+// and options.
+//
+//	store, err := bboltkv.OpenSharded("data-%02d.db", 8, "bucket")
+func OpenSharded(pathPattern string, n int, bucket string, opts ...Option) (*ShardedStore, error) {
+	if n < 1 {
+		return nil, errors.New("bboltkv: a sharded store needs at least one shard")
+	}
+	ss := &ShardedStore{shards: make([]*Store, 0, n)}
+	for i := 0; i < n; i++ {
+		s, err := Open(fmt.Sprintf(pathPattern, i), bucket, opts...)
+		if err != nil {
+			ss.Close()
+			return nil, err
+		}
+		ss.shards = append(ss.shards, s)
+	}
+	return ss, nil
+}
+
+// Close closes all shards, see Store.Close, and returns the first error.
+func (ss *ShardedStore) Close() error {
+	var first error
+	for _, s := range ss.shards {
+		if err := s.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// ShardFor returns the number of the shard holding key. It depends only on
+// the key and the number of shards.
+func (ss *ShardedStore) ShardFor(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(ss.shards)))
+}
+
+// Shard returns the store of shard i.
+func (ss *ShardedStore) Shard(i int) *Store {
+	return ss.shards[i]
+}
+
+func (ss *ShardedStore) shard(key string) *Store {
+	return ss.shards[ss.ShardFor(key)]
+}
+
+// Put an entry into the shard of its key, see Store.Put.
+func (ss *ShardedStore) Put(key string, value interface{}) error {
+	return ss.shard(key).Put(key, value)
+}
+
+// Get an entry from the shard of its key, see Store.Get.
+func (ss *ShardedStore) Get(key string, value interface{}) error {
+	return ss.shard(key).Get(key, value)
+}
+
+// Delete an entry from the shard of its key, see Store.Delete.
+func (ss *ShardedStore) Delete(key string) error {
+	return ss.shard(key).Delete(key)
+}
+
+// Has reports whether the shard of key holds an entry for it, see
+// Store.Has.
+func (ss *ShardedStore) Has(key string) (bool, error) {
+	return ss.shard(key).Has(key)
+}
+
+// PutAll stores the given entries, writing to all shards concurrently. All
+// values are encoded and validated before anything is written, so a bad
+// value fails the whole call. Each shard writes its entries in a single
+// transaction, but the shards commit independently: if one of them fails,
+// the entries of the others may still have been written.
+func (ss *ShardedStore) PutAll(entries map[string]interface{}) error {
+	parts := make([]RawEntries, len(ss.shards))
+	for k, v := range entries {
+		i := ss.ShardFor(k)
+		raw, err := ss.shards[i].encodeForPut(k, v)
+		if err != nil {
+			return err
+		}
+		if parts[i] == nil {
+			parts[i] = make(RawEntries)
+		}
+		parts[i][k] = raw
+	}
+	return ss.fanOut(func(i int, s *Store) error {
+		if parts[i] == nil {
+			return nil
+		}
+		return s.putAll(parts[i])
+	})
+}
+
+// Keys returns the keys of all shards, in lexicographic order, leaving out
+// the same keys as Store.Keys. The shards are read concurrently.
+func (ss *ShardedStore) Keys() ([]string, error) {
+	parts := make([][]string, len(ss.shards))
+	err := ss.fanOut(func(i int, s *Store) error {
+		var err error
+		parts[i], err = s.Keys()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, p := range parts {
+		keys = append(keys, p...)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Count returns the number of entries in all shards, counting them
+// concurrently.
+func (ss *ShardedStore) Count() (int, error) {
+	counts := make([]int, len(ss.shards))
+	err := ss.fanOut(func(i int, s *Store) error {
+		var err error
+		counts[i], err = s.Count()
+		return err
+	})
+	n := 0
+	for _, c := range counts {
+		n += c
+	}
+	return n, err
+}
+
+// ForEach calls fn for every entry in all shards, merged into key order,
+// like Store.ForEach. It holds a read transaction on every shard while it
+// runs.
+func (ss *ShardedStore) ForEach(fn func(key string, decode func(interface{}) error) error) error {
+	txs := make([]*bbolt.Tx, len(ss.shards))
+	var walk func(i int) error
+	walk = func(i int) error {
+		if i < len(ss.shards) {
+			return ss.shards[i].view(func(tx *bbolt.Tx) error {
+				txs[i] = tx
+				return walk(i + 1)
+			})
+		}
+		m := &mergeCursor{}
+		for j, tx := range txs {
+			c := tx.Bucket(ss.shards[j].bucketName).Cursor()
+			if k, v := c.First(); k != nil {
+				m.heads = append(m.heads, mergeHead{c, k, v})
+			}
+		}
+		heap.Init(m)
+		for m.Len() > 0 {
+			k, v := m.next()
+			j := ss.ShardFor(string(k))
+			s := ss.shards[j]
+			if s.hidden(txs[j], k) {
+				continue
+			}
+			v, err := s.assemble(txs[j], k, v)
+			if err != nil {
+				return err
+			}
+			if err := fn(string(k), func(value interface{}) error { return s.decode(v, value) }); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(0)
+}
+
+// fanOut calls fn for every shard concurrently, and returns the first error
+// in shard order.
+func (ss *ShardedStore) fanOut(fn func(i int, s *Store) error) error {
+	errs := make([]error, len(ss.shards))
+	var wg sync.WaitGroup
+	for i, s := range ss.shards {
+		wg.Add(1)
+		go func(i int, s *Store) {
+			defer wg.Done()
+			errs[i] = fn(i, s)
+		}(i, s)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package bboltkv
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func openTestSharded(t testing.TB, n int) (*ShardedStore, string) {
+	t.Helper()
+	pattern := filepath.Join(t.TempDir(), "data-%02d.db")
+	ss, err := OpenSharded(pattern, n, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ss.Close() })
+	return ss, pattern
+}
+
+func TestShardedRouting(t *testing.T) {
+	ss, pattern := openTestSharded(t, 4)
+	if _, err := OpenSharded(pattern, 0, "test"); err == nil {
+		t.Fatal("opened a store without shards")
+	}
+	// the hash is part of the file format
+	for key, want := range map[string]int{"": 1, "a": 0, "user:42": 2, "k00001": 1} {
+		if got := ss.ShardFor(key); got != want {
+			t.Fatalf("%q: shard %d, expected %d", key, got, want)
+		}
+	}
+	used := map[int]bool{}
+	for i := 0; i < 100; i++ {
+		key := keyN(i)
+		if err := ss.Put(key, i); err != nil {
+			t.Fatal(err)
+		}
+		j := ss.ShardFor(key)
+		used[j] = true
+		if ok, err := ss.Shard(j).Has(key); err != nil || !ok {
+			t.Fatalf("%s not in shard %d: %v", key, j, err)
+		}
+	}
+	if len(used) != 4 {
+		t.Fatalf("only shards %v used", used)
+	}
+
+	// reopening routes keys the same way
+	if err := ss.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range ss.shards {
+		if err := s.Put("key", 1); err != ErrClosed {
+			t.Fatalf("got %v, expected ErrClosed", err)
+		}
+	}
+	ss, err := OpenSharded(pattern, 4, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ss.Close()
+	var v int
+	if err := ss.Get(keyN(42), &v); err != nil || v != 42 {
+		t.Fatalf("got %d, %v", v, err)
+	}
+}
+
+func TestShardedParity(t *testing.T) {
+	ss, _ := openTestSharded(t, 3)
+	oracle := openTestStore(t)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		key := keyN(rnd.Intn(100))
+		switch rnd.Intn(4) {
+		case 0, 1:
+			if a, b := ss.Put(key, i), oracle.Put(key, i); a != b {
+				t.Fatalf("put %s: %v, oracle %v", key, a, b)
+			}
+		case 2:
+			if a, b := ss.Delete(key), oracle.Delete(key); a != b {
+				t.Fatalf("delete %s: %v, oracle %v", key, a, b)
+			}
+		case 3:
+			entries := map[string]interface{}{key: i, keyN(rnd.Intn(100)): -i}
+			if a, b := ss.PutAll(entries), oracle.PutAll(entries); a != b {
+				t.Fatalf("put all: %v, oracle %v", a, b)
+			}
+		}
+		var a, b int
+		ea, eb := ss.Get(key, &a), oracle.Get(key, &b)
+		ha, _ := ss.Has(key)
+		hb, _ := oracle.Has(key)
+		if ea != eb || a != b || ha != hb {
+			t.Fatalf("get %s: %d, %v, %v, oracle %d, %v, %v", key, a, ea, ha, b, eb, hb)
+		}
+	}
+	if a, b := ss.PutAll(map[string]interface{}{"x": 1, "y": nil}), oracle.PutAll(map[string]interface{}{"x": 1, "y": nil}); a != ErrBadValue || b != ErrBadValue {
+		t.Fatalf("nil value: %v, oracle %v", a, b)
+	}
+
+	keysA, errA := ss.Keys()
+	keysB, errB := oracle.Keys()
+	if errA != nil || errB != nil || !reflect.DeepEqual(keysA, keysB) {
+		t.Fatalf("keys %v, %v, oracle %v, %v", keysA, errA, keysB, errB)
+	}
+	if a, _ := ss.Count(); a != len(keysB) {
+		t.Fatalf("count %d, expected %d", a, len(keysB))
+	}
+	dump := func(fe func(func(string, func(interface{}) error) error) error) []string {
+		var out []string
+		err := fe(func(key string, decode func(interface{}) error) error {
+			var v int
+			err := decode(&v)
+			out = append(out, fmt.Sprint(key, "=", v))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	if a, b := dump(ss.ForEach), dump(oracle.ForEach); !reflect.DeepEqual(a, b) {
+		t.Fatalf("for each %v, oracle %v", a, b)
+	}
+}
+
+func benchmarkConcurrentPuts(b *testing.B, put func(key string, value interface{}) error) {
+	var n int64
+	b.SetParallelism(4)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := put(keyN(int(atomic.AddInt64(&n, 1))), "value"); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkShardedPut(b *testing.B) {
+	b.Run("single", func(b *testing.B) {
+		benchmarkConcurrentPuts(b, openTestStore(b).Put)
+	})
+	b.Run("sharded", func(b *testing.B) {
+		ss, _ := openTestSharded(b, 8)
+		benchmarkConcurrentPuts(b, ss.Put)
+	})
+}
+
+func TestShardedConcurrent(t *testing.T) {
+	ss, _ := openTestSharded(t, 4)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				if err := ss.Put(keyN(w*100+i), i); err != nil {
+					t.Error(err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if n, err := ss.Count(); err != nil || n != 100 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+}
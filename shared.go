@@ -0,0 +1,182 @@
+package bboltkv
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// ErrOptionMismatch is wrapped by the error OpenShared returns when the
+// options it is given conflict with those of the stores already sharing the
+// file.
+var ErrOptionMismatch = errors.New("bboltkv: options do not match the shared database")
+
+// sharedDB is a database file opened by OpenShared.
+type sharedDB struct {
+	db              *bbolt.DB
+	refs            int
+	checkMode       CheckMode
+	checkBackground bool
+	readOnly        bool
+	pageSize        int
+	initialMmapSize int
+	freelistMap     bool
+	buckets         map[string]int      // stores per bucket
+	stores          map[string][]*Store // see WriteTx.InBucket
+	cached          map[string]bool     // buckets with a store using a read cache, a decoded cache or a lookup filter
+	states          map[string]*bucketState
+	freezer         *freezer
+}
+
+// bucketState is what a store keeps in memory about the internal buckets of
+// its bucket, so that writes need not read them. Stores opened with
+// OpenShared on the same bucket share it, so that what one of them commits
+// applies to the writes of all.
+type bucketState struct {
+	immutables int32 // set once the bucket may hold immutable keys, accessed atomically
+	protection protection
+	policies   ttlPolicies
+}
+
+var shared = struct {
+	sync.Mutex
+	dbs map[string]*sharedDB
+}{dbs: make(map[string]*sharedDB)}
+
+// OpenShared opens a key-value store like Open, except that stores opened
+// with OpenShared on the same file share a single open database, rather
+// than the second one failing on the file lock. Files are identified by
+// their cleaned absolute path; symbolic links are not resolved. The file is
+// closed when the last of the stores sharing it is closed.
+//
+// Each store has its own bucket name and options, but the options that
+// concern the file as a whole, WithCheckOnOpen, WithBackgroundCheck,
+// WithPageSize, WithInitialMmapSize and WithFreelistMapType, must be the
+// same for all of them; the check runs only when the file is first opened,
+// and the file is mapped as the first store asks. So does the compaction of
+// WithAutoCompact, which the first store's options decide, as later stores
+// find the file already open. Once a file is open with WithReadOnly, all stores sharing it must
+// be read-only too. Stores using the same bucket do not see each other's
+// writes in their read caches, so a store cannot use WithReadCache on a
+// bucket that another store sharing the file also uses, or the other way
+// around; the same goes for WithDecodedCache and WithNegativeLookupFilter.
+// Conflicting options make OpenShared return an error wrapping
+// ErrOptionMismatch.
+//
+// The file can still only be opened once: a file opened with Open cannot be
+// shared.
+func OpenShared(path string, bucketName string, opts ...Option) (*Store, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	o := buildOptions(opts)
+	shared.Lock()
+	defer shared.Unlock()
+	sdb := shared.dbs[abs]
+	first := sdb == nil
+	var compacted time.Time
+	if first {
+		if o.autoCompact > 0 && !o.readOnly {
+			if compacted, err = autoCompact(abs, o); err != nil {
+				return nil, err
+			}
+		}
+		db, err := openDB(abs, o)
+		if err != nil {
+			return nil, err
+		}
+		sdb = &sharedDB{
+			db:              db,
+			checkMode:       o.checkMode,
+			checkBackground: o.checkBackground,
+			readOnly:        o.readOnly,
+			pageSize:        o.pageSize,
+			initialMmapSize: o.initialMmapSize,
+			freelistMap:     o.freelistMap,
+			buckets:         make(map[string]int),
+			stores:          make(map[string][]*Store),
+			cached:          make(map[string]bool),
+			states:          make(map[string]*bucketState),
+		}
+	} else if err := sdb.compatible(o, bucketName); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrOptionMismatch, abs, err)
+	} else {
+		o.freezer = sdb.freezer
+		o.state = sdb.states[bucketName]
+	}
+	s, err := newStore(sdb.db, bucketName, o, first)
+	if err != nil {
+		if first {
+			sdb.db.Close()
+		}
+		return nil, err
+	}
+	shared.dbs[abs] = sdb
+	s.compacted = compacted
+	sdb.freezer = s.freezer
+	sdb.refs++
+	sdb.buckets[bucketName]++
+	sdb.stores[bucketName] = append(sdb.stores[bucketName], s)
+	sdb.states[bucketName] = s.state
+	s.shared = sdb
+	if o.cacheSize > 0 || o.decodedSize > 0 || o.filterBitsPerKey > 0 {
+		sdb.cached[bucketName] = true
+	}
+	s.release = func() error {
+		shared.Lock()
+		defer shared.Unlock()
+		sdb.refs--
+		sdb.drop(s)
+		if sdb.buckets[bucketName]--; sdb.buckets[bucketName] == 0 {
+			delete(sdb.buckets, bucketName)
+			delete(sdb.cached, bucketName)
+			delete(sdb.states, bucketName)
+		}
+		if sdb.refs > 0 {
+			return nil
+		}
+		delete(shared.dbs, abs)
+		return sdb.db.Close()
+	}
+	return s, nil
+}
+
+// drop removes s from the stores sharing the database.
+func (sdb *sharedDB) drop(s *Store) {
+	name := string(s.bucketName)
+	stores := sdb.stores[name]
+	for i, st := range stores {
+		if st == s {
+			sdb.stores[name] = append(stores[:i:i], stores[i+1:]...)
+			break
+		}
+	}
+	if len(sdb.stores[name]) == 0 {
+		delete(sdb.stores, name)
+	}
+}
+
+// compatible checks whether a store with the given options and bucket can
+// share the database.
+func (sdb *sharedDB) compatible(o options, bucketName string) error {
+	if o.checkMode != sdb.checkMode || o.checkBackground != sdb.checkBackground {
+		return fmt.Errorf("already open with check mode %d (background %t), not %d (background %t)",
+			sdb.checkMode, sdb.checkBackground, o.checkMode, o.checkBackground)
+	}
+	if sdb.readOnly && !o.readOnly {
+		return errors.New("already open read-only")
+	}
+	if o.pageSize != sdb.pageSize || o.initialMmapSize != sdb.initialMmapSize || o.freelistMap != sdb.freelistMap {
+		return fmt.Errorf("already open with page size %d, initial mmap size %d and map freelist %t, not %d, %d and %t",
+			sdb.pageSize, sdb.initialMmapSize, sdb.freelistMap, o.pageSize, o.initialMmapSize, o.freelistMap)
+	}
+	if sdb.buckets[bucketName] > 0 && (o.cacheSize > 0 || o.decodedSize > 0 || o.filterBitsPerKey > 0 || sdb.cached[bucketName]) {
+		return fmt.Errorf("bucket %q is already in use by another store, which cannot be combined with a read cache, a decoded cache or a lookup filter", bucketName)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package bboltkv
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openSharedBucket returns two stores sharing one file and bucket, each with
+// its own options.
+func openSharedBucket(t *testing.T, aOpts, bOpts []Option) (a, b *Store) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	a, err := OpenShared(path, "b", aOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close() })
+	b, err = OpenShared(path, "b", bOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return a, b
+}
+
+func TestOpenShared(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.db")
+	a, err := OpenShared(path, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a different spelling of the same path
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := filepath.Rel(wd, filepath.Join(dir, ".", "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := OpenShared(rel, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.GetDb() != b.GetDb() {
+		t.Fatal("stores do not share the database")
+	}
+
+	// the buckets are independent
+	if err := a.Put("key", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Get("key", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if err := b.Put("key", "b"); err != nil {
+		t.Fatal(err)
+	}
+	var val string
+	if err := a.Get("key", &val); err != nil || val != "a" {
+		t.Fatalf("got %q, %v, expected a", val, err)
+	}
+
+	// the file stays open until the last store is closed
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Get("key", &val); err != ErrClosed {
+		t.Fatalf("got %v, expected ErrClosed", err)
+	}
+	if err := b.Get("key", &val); err != nil || val != "b" {
+		t.Fatalf("got %q, %v, expected b", val, err)
+	}
+	if _, err := Open(path, "c"); err == nil {
+		t.Fatal("Open succeeded while the file was shared")
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Open(path, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Get("key", &val); err != nil || val != "a" {
+		t.Fatalf("got %q, %v, expected a", val, err)
+	}
+}
+
+func TestOpenSharedMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	a, err := OpenShared(path, "a", WithCheckOnOpen(CheckFast))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if _, err := OpenShared(path, "b"); !errors.Is(err, ErrOptionMismatch) {
+		t.Fatalf("got %v, expected ErrOptionMismatch", err)
+	}
+	b, err := OpenShared(path, "a", WithCheckOnOpen(CheckFast))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if _, err := OpenShared(path, "a", WithCheckOnOpen(CheckFast), WithReadCache(10)); !errors.Is(err, ErrOptionMismatch) {
+		t.Fatalf("got %v, expected ErrOptionMismatch", err)
+	}
+	// the decoded cache would not see the other store's writes either
+	if _, err := OpenShared(path, "a", WithCheckOnOpen(CheckFast), WithDecodedCache(10)); !errors.Is(err, ErrOptionMismatch) {
+		t.Fatalf("got %v, expected ErrOptionMismatch", err)
+	}
+	d, err := OpenShared(path, "d", WithCheckOnOpen(CheckFast), WithDecodedCache(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if _, err := OpenShared(path, "d", WithCheckOnOpen(CheckFast)); !errors.Is(err, ErrOptionMismatch) {
+		t.Fatalf("got %v, expected ErrOptionMismatch", err)
+	}
+	// nor can the file be mapped differently
+	for _, opt := range []Option{WithPageSize(8192), WithInitialMmapSize(1 << 20), WithFreelistMapType()} {
+		if _, err := OpenShared(path, "c", WithCheckOnOpen(CheckFast), opt); !errors.Is(err, ErrOptionMismatch) {
+			t.Fatalf("got %v, expected ErrOptionMismatch", err)
+		}
+	}
+	c, err := OpenShared(path, "c", WithCheckOnOpen(CheckFast), WithReadCache(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if a.GetDb() != c.GetDb() {
+		t.Fatal("stores do not share the database")
+	}
+}
+
+func TestOpenSharedReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	if _, err := OpenShared(path, "a", WithReadOnly()); !errors.Is(err, ErrNoDatabase) {
+		t.Fatalf("got %v, expected ErrNoDatabase", err)
+	}
+	w, err := OpenShared(path, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a read-only store can share a writable file
+	r, err := OpenShared(path, "a", WithReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Put("key", 1); err != ErrReadOnly {
+		t.Fatalf("got %v, expected ErrReadOnly", err)
+	}
+	r.Close()
+	w.Close()
+
+	r, err = OpenShared(path, "a", WithReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := OpenShared(path, "a"); !errors.Is(err, ErrOptionMismatch) {
+		t.Fatalf("got %v, expected ErrOptionMismatch", err)
+	}
+}
@@ -0,0 +1,87 @@
+package bboltkv
+
+import "sync"
+
+// WithSingleflight makes concurrent calls for the same key share work: Get
+// calls share a single read of the database, and GetOrPut calls share a
+// single call to their compute function. Every caller still decodes the
+// shared bytes into its own value, so no two callers end up aliasing the
+// same decoded data.
+//
+// A Get that joins a read already in progress may return the value that was
+// current when that read started, but never one replaced by a write that
+// committed before the Get was called.
+func WithSingleflight() Option {
+	return func(o *options) {
+		o.singleflight = true
+	}
+}
+
+type flights struct {
+	reads    flightGroup // keyed by the key being read
+	computes flightGroup // keyed by the key passed to GetOrPut
+}
+
+func newFlights() *flights {
+	return &flights{
+		reads:    flightGroup{calls: make(map[string]*flight)},
+		computes: flightGroup{calls: make(map[string]*flight)},
+	}
+}
+
+// flightGroup deduplicates concurrent calls for the same key.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+	runs  int // number of calls actually made, for tests
+}
+
+type flight struct {
+	wg  sync.WaitGroup
+	raw []byte
+	err error
+}
+
+// do calls fn and returns its results, unless a call for key is already in
+// progress, in which case it waits for that one and returns its results.
+func (g *flightGroup) do(key string, fn func() ([]byte, error)) ([]byte, error) {
+	g.mu.Lock()
+	if f, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		f.wg.Wait()
+		return f.raw, f.err
+	}
+	f := &flight{}
+	f.wg.Add(1)
+	g.calls[key] = f
+	g.runs++
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		if g.calls[key] == f {
+			delete(g.calls, key)
+		}
+		g.mu.Unlock()
+		f.wg.Done()
+	}()
+	f.raw, f.err = fn()
+	return f.raw, f.err
+}
+
+// forget makes later calls for the given keys start afresh rather than join
+// calls already in progress.
+func (g *flightGroup) forget(keys []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, key := range keys {
+		delete(g.calls, key)
+	}
+}
+
+// forgetAll is forget for all keys.
+func (g *flightGroup) forgetAll() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.calls = make(map[string]*flight)
+}
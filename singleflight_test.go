@@ -0,0 +1,168 @@
+package bboltkv
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOrPut(t *testing.T) {
+	db := openTestStore(t)
+	calls := 0
+	compute := func() (interface{}, error) {
+		calls++
+		return []int{1, 2, 3}, nil
+	}
+	var val []int
+	if err := db.GetOrPut("key", &val, compute); err != nil {
+		t.Fatal(err)
+	} else if len(val) != 3 {
+		t.Fatalf("got %v, expected [1 2 3]", val)
+	}
+	val = nil
+	if err := db.GetOrPut("key", &val, compute); err != nil {
+		t.Fatal(err)
+	} else if len(val) != 3 {
+		t.Fatalf("got %v, expected [1 2 3]", val)
+	}
+	if calls != 1 {
+		t.Fatalf("compute called %d times, expected once", calls)
+	}
+	errCompute := errors.New("compute failed")
+	if err := db.GetOrPut("other", &val, func() (interface{}, error) {
+		return nil, errCompute
+	}); err != errCompute {
+		t.Fatalf("got %v, expected errCompute", err)
+	}
+	if err := db.Get("other", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if err := db.GetOrPut("other", &val, func() (interface{}, error) {
+		return nil, nil
+	}); err != ErrBadValue {
+		t.Fatalf("got %v, expected ErrBadValue", err)
+	}
+}
+
+func TestGetOrPutSingleflight(t *testing.T) {
+	db := openTestStore(t, WithSingleflight())
+	var calls int32
+	release := make(chan struct{})
+	compute := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []int{1, 2, 3}, nil
+	}
+	const n = 50
+	results := make([][]int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := db.GetOrPut("key", &results[i], compute); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("compute called %d times, expected once", calls)
+	}
+	// every caller has its own copy
+	results[0][0] = 100
+	for i := 1; i < n; i++ {
+		if len(results[i]) != 3 || results[i][0] != 1 {
+			t.Fatalf("caller %d got %v", i, results[i])
+		}
+	}
+}
+
+func TestGetSingleflight(t *testing.T) {
+	db := openTestStore(t, WithSingleflight())
+	if err := db.Put("key", []int{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	const n = 50
+	results := make([][]int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := db.Get("key", &results[i]); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	results[0][0] = 100
+	for i := 1; i < n; i++ {
+		if len(results[i]) != 3 || results[i][0] != 1 {
+			t.Fatalf("caller %d got %v", i, results[i])
+		}
+	}
+	if runs := db.flights.reads.runs; runs > n {
+		t.Fatalf("%d reads for %d callers", runs, n)
+	}
+	// missing keys are shared too
+	if err := db.Get("missing", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	// reads after a write see it
+	if err := db.Put("key", []int{4}); err != nil {
+		t.Fatal(err)
+	}
+	var val []int
+	if err := db.Get("key", &val); err != nil {
+		t.Fatal(err)
+	} else if len(val) != 1 || val[0] != 4 {
+		t.Fatalf("got %v, expected [4]", val)
+	}
+}
+
+func TestFlightGroup(t *testing.T) {
+	g := flightGroup{calls: make(map[string]*flight)}
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			raw, err := g.do("key", func() ([]byte, error) {
+				<-release
+				return []byte("value"), nil
+			})
+			if err != nil || string(raw) != "value" {
+				t.Errorf("got %q, %v", raw, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if g.runs != 1 {
+		t.Fatalf("%d runs, expected 1", g.runs)
+	}
+	// forgetting a key starts a new call
+	block := make(chan struct{})
+	started := make(chan struct{})
+	go g.do("key", func() ([]byte, error) {
+		close(started)
+		<-block
+		return nil, nil
+	})
+	<-started
+	g.forget([]string{"key"})
+	if _, err := g.do("key", func() ([]byte, error) { return nil, nil }); err != nil {
+		t.Fatal(err)
+	}
+	close(block)
+	if g.runs != 3 {
+		t.Fatalf("%d runs, expected 3", g.runs)
+	}
+}
@@ -0,0 +1,264 @@
+package bboltkv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"go.etcd.io/bbolt"
+)
+
+// ErrBadSnapshot is returned when reading a snapshot that is damaged or not
+// a snapshot at all.
+var ErrBadSnapshot = errors.New("bboltkv: bad snapshot")
+
+// A snapshot, as written by WriteTo, starts with snapshotMagic, followed by
+// records that each start with a type byte:
+//
+//	'b' name        a bucket starts; its records follow, up to the matching 'e'
+//	's' seq         the sequence number of the current bucket
+//	'k' key value   an entry of the current bucket
+//	'e'             the current bucket ends
+//	'z' crc         the snapshot ends
+//
+// Names, keys and values are written as a uvarint length followed by the
+// bytes, sequence numbers as uvarints. The CRC-32 (IEEE) of everything
+// before it is written as 4 bytes big-endian. Buckets and entries come in
+// key order.
+const snapshotMagic = "bboltkv snapshot 1\n"
+
+const (
+	recBucket = 'b'
+	recSeq    = 's'
+	recEntry  = 'k'
+	recEnd    = 'e'
+	recTail   = 'z'
+)
+
+// WriteTo writes a snapshot of the store to w: its bucket and the internal
+// buckets that hold TTLs, tags, lists and the like, read in a single
+// transaction. It returns the number of bytes written.
+func (s *Store) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	err := s.view(func(tx *bbolt.Tx) error {
+		var err error
+		n, err = writeSnapshot(tx, w, s.ownsBucket)
+		return err
+	})
+	return n, err
+}
+
+// ownsBucket reports whether a top-level bucket belongs to the store.
+func (s *Store) ownsBucket(name []byte) bool {
+	return bytes.Equal(name, s.bucketName) || bytes.HasPrefix(name, s.auxName(""))
+}
+
+// snapshotWriter writes snapshot records, keeping track of their checksum.
+type snapshotWriter struct {
+	w   *bufio.Writer
+	crc hash.Hash32
+	n   int64
+	err error
+}
+
+func (sw *snapshotWriter) write(b []byte) {
+	if sw.err != nil {
+		return
+	}
+	var n int
+	n, sw.err = sw.w.Write(b)
+	sw.crc.Write(b[:n])
+	sw.n += int64(n)
+}
+
+func (sw *snapshotWriter) uvarint(x uint64) {
+	var b [binary.MaxVarintLen64]byte
+	sw.write(b[:binary.PutUvarint(b[:], x)])
+}
+
+func (sw *snapshotWriter) bytes(b []byte) {
+	sw.uvarint(uint64(len(b)))
+	sw.write(b)
+}
+
+func (sw *snapshotWriter) bucket(b *bbolt.Bucket) {
+	if seq := b.Sequence(); seq != 0 {
+		sw.write([]byte{recSeq})
+		sw.uvarint(seq)
+	}
+	c := b.Cursor()
+	for k, v := c.First(); k != nil && sw.err == nil; k, v = c.Next() {
+		if v == nil {
+			sw.write([]byte{recBucket})
+			sw.bytes(k)
+			sw.bucket(b.Bucket(k))
+			sw.write([]byte{recEnd})
+		} else {
+			sw.write([]byte{recEntry})
+			sw.bytes(k)
+			sw.bytes(v)
+		}
+	}
+}
+
+// newSnapshotWriter starts a snapshot.
+func newSnapshotWriter(w io.Writer) *snapshotWriter {
+	sw := &snapshotWriter{w: bufio.NewWriter(w), crc: crc32.NewIEEE()}
+	sw.write([]byte(snapshotMagic))
+	return sw
+}
+
+// finish ends the snapshot, and returns the number of bytes written.
+func (sw *snapshotWriter) finish() (int64, error) {
+	sw.write([]byte{recTail})
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], sw.crc.Sum32())
+	sw.write(crc[:])
+	if sw.err == nil {
+		sw.err = sw.w.Flush()
+	}
+	return sw.n, sw.err
+}
+
+// writeSnapshot writes the top-level buckets of tx accepted by include.
+func writeSnapshot(tx *bbolt.Tx, w io.Writer, include func(name []byte) bool) (int64, error) {
+	sw := newSnapshotWriter(w)
+	err := tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+		if include(name) {
+			sw.write([]byte{recBucket})
+			sw.bytes(name)
+			sw.bucket(b)
+			sw.write([]byte{recEnd})
+		}
+		return sw.err
+	})
+	if err != nil {
+		return sw.n, err
+	}
+	return sw.finish()
+}
+
+// snapshotReader reads the records of a snapshot.
+type snapshotReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+func newSnapshotReader(r io.Reader) (*snapshotReader, error) {
+	sr := &snapshotReader{r: bufio.NewReader(r), crc: crc32.NewIEEE()}
+	magic := make([]byte, len(snapshotMagic))
+	if err := sr.full(magic); err != nil {
+		return nil, err
+	} else if string(magic) != snapshotMagic {
+		return nil, ErrBadSnapshot
+	}
+	return sr, nil
+}
+
+func (sr *snapshotReader) full(b []byte) error {
+	if _, err := io.ReadFull(sr.r, b); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrBadSnapshot
+	} else if err != nil {
+		return err
+	}
+	sr.crc.Write(b)
+	return nil
+}
+
+func (sr *snapshotReader) byte() (byte, error) {
+	var b [1]byte
+	err := sr.full(b[:])
+	return b[0], err
+}
+
+func (sr *snapshotReader) uvarint() (uint64, error) {
+	var x uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b, err := sr.byte()
+		if err != nil {
+			return 0, err
+		}
+		x |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return x, nil
+		}
+	}
+	return 0, ErrBadSnapshot
+}
+
+func (sr *snapshotReader) bytes() ([]byte, error) {
+	n, err := sr.uvarint()
+	if err != nil {
+		return nil, err
+	} else if n > 1<<32 {
+		return nil, ErrBadSnapshot
+	}
+	b := make([]byte, n)
+	return b, sr.full(b)
+}
+
+// snapshotLoader receives the contents of a snapshot.
+type snapshotLoader interface {
+	bucket(name []byte) error
+	sequence(seq uint64) error
+	entry(key, value []byte) error
+	end() error
+}
+
+// readSnapshot reads a snapshot, passing its records to l. It checks the
+// snapshot's checksum only at the end, so l must be prepared to discard
+// what it has received when readSnapshot fails.
+func readSnapshot(r io.Reader, l snapshotLoader) error {
+	sr, err := newSnapshotReader(r)
+	if err != nil {
+		return err
+	}
+	depth := 0
+	for {
+		rec, err := sr.byte()
+		if err != nil {
+			return err
+		}
+		switch {
+		case rec == recBucket:
+			var name []byte
+			if name, err = sr.bytes(); err == nil {
+				err = l.bucket(name)
+			}
+			depth++
+		case rec == recSeq && depth > 0:
+			var seq uint64
+			if seq, err = sr.uvarint(); err == nil {
+				err = l.sequence(seq)
+			}
+		case rec == recEntry && depth > 0:
+			var key, value []byte
+			if key, err = sr.bytes(); err == nil {
+				if value, err = sr.bytes(); err == nil {
+					err = l.entry(key, value)
+				}
+			}
+		case rec == recEnd && depth > 0:
+			err = l.end()
+			depth--
+		case rec == recTail && depth == 0:
+			sum := sr.crc.Sum32()
+			var crc [4]byte
+			if err := sr.full(crc[:]); err != nil {
+				return err
+			} else if binary.BigEndian.Uint32(crc[:]) != sum {
+				return ErrBadSnapshot
+			}
+			return nil
+		default:
+			return ErrBadSnapshot
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package bboltkv
+
+import (
+	"bytes"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// snapshotRecorder collects what readSnapshot passes it as flat strings.
+type snapshotRecorder []string
+
+func (r *snapshotRecorder) bucket(name []byte) error {
+	*r = append(*r, "b "+string(name))
+	return nil
+}
+
+func (r *snapshotRecorder) sequence(seq uint64) error {
+	*r = append(*r, "s")
+	return nil
+}
+
+func (r *snapshotRecorder) entry(key, value []byte) error {
+	*r = append(*r, "k "+string(key))
+	return nil
+}
+
+func (r *snapshotRecorder) end() error {
+	*r = append(*r, "e")
+	return nil
+}
+
+func TestWriteTo(t *testing.T) {
+	db := openTestStore(t)
+	fillStore(t, db, 3)
+	if _, err := db.Append("list", 1); err != nil {
+		t.Fatal(err)
+	}
+	err := db.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("other"))
+		if err == nil {
+			err = b.Put([]byte("x"), []byte("y"))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	n, err := db.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	} else if n != int64(buf.Len()) {
+		t.Fatalf("reported %d bytes, wrote %d", n, buf.Len())
+	}
+	var got snapshotRecorder
+	if err := readSnapshot(bytes.NewReader(buf.Bytes()), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"b test", "k k00000", "k k00001", "k k00002", "k list", "e",
+		"b test\x00lists", "b \x00list", "k \x00\x00\x00\x00\x00\x00\x00\x00", "e", "e",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %q, expected %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %q, expected %q", got, want)
+		}
+	}
+
+	// any damage is noticed
+	raw := buf.Bytes()
+	for _, i := range []int{0, len(snapshotMagic) + 3, len(raw) - 1} {
+		bad := append([]byte(nil), raw...)
+		bad[i] ^= 1
+		if err := readSnapshot(bytes.NewReader(bad), new(snapshotRecorder)); err != ErrBadSnapshot {
+			t.Fatalf("byte %d: got %v, expected ErrBadSnapshot", i, err)
+		}
+	}
+	if err := readSnapshot(bytes.NewReader(raw[:len(raw)-5]), new(snapshotRecorder)); err != ErrBadSnapshot {
+		t.Fatalf("truncated: got %v, expected ErrBadSnapshot", err)
+	}
+}
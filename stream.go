@@ -0,0 +1,121 @@
+package bboltkv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"go.etcd.io/bbolt"
+)
+
+// streamFlushEvery is the number of values StreamJSON writes between
+// flushes.
+const streamFlushEvery = 100
+
+// WithSkipMalformed makes StreamJSON skip the values that cannot be
+// decoded into the value returned by newValue, or not be encoded as JSON,
+// rather than stop at the first of them. It adds the number of values
+// skipped to *skipped.
+func WithSkipMalformed(skipped *int) OpOption {
+	return func(o *opOptions) {
+		o.skipMalformed = true
+		o.skipped = skipped
+	}
+}
+
+// StreamJSON writes the values of the entries whose keys start with prefix
+// to w, as a JSON array, in key order, and returns how many it wrote. Each
+// value is decoded into a new value returned by newValue, as with Get, and
+// encoded with encoding/json. At most limit values are written, or all of
+// them if limit is 0 or less.
+//
+// The values are read in a single transaction, but written out as they are
+// read, and flushed every 100 values: if w has a Flush method, such as an
+// http.ResponseWriter, it is called too. So the memory StreamJSON needs
+// does not grow with the number of values, and a client receives them
+// while the rest are read.
+//
+// If a value cannot be decoded or encoded, StreamJSON stops and returns
+// the error, with the count of values written until then. The array is
+// then left open, which a client parsing it sees as an error, rather than
+// a complete but short result. With WithSkipMalformed, such values are
+// skipped instead, and the array is complete.
+//
+//	http.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+//	    w.Header().Set("Content-Type", "application/json")
+//	    store.StreamJSON(w, "user:", func() interface{} { return new(User) }, 1000)
+//	})
+func (s *Store) StreamJSON(w io.Writer, prefix string, newValue func() interface{}, limit int, opts ...OpOption) (int, error) {
+	o := buildOpOptions(opts)
+	start, err := s.sealPrefix([]byte(prefix))
+	if err != nil {
+		return 0, err
+	}
+	bw := bufio.NewWriter(w)
+	flush := func() error {
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		if f, ok := w.(interface{ Flush() }); ok {
+			f.Flush()
+		}
+		return nil
+	}
+	n := 0
+	err = s.view(func(tx *bbolt.Tx) error {
+		if err := bw.WriteByte('['); err != nil {
+			return err
+		}
+		c := tx.Bucket(s.bucketName).Cursor()
+		for k, v := c.Seek(start); k != nil && bytes.HasPrefix(k, start) && (limit <= 0 || n < limit); k, v = c.Next() {
+			if s.hidden(tx, k) {
+				continue
+			}
+			data, err := s.streamValue(tx, k, v, newValue)
+			if err != nil {
+				if o.skipMalformed {
+					if o.skipped != nil {
+						*o.skipped++
+					}
+					continue
+				}
+				key, _ := s.openKey(k)
+				return fmt.Errorf("bboltkv: %q: %w", key, err)
+			}
+			if n > 0 {
+				if err := bw.WriteByte(','); err != nil {
+					return err
+				}
+			}
+			if _, err := bw.Write(data); err != nil {
+				return err
+			}
+			if n++; n%streamFlushEvery == 0 {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		return bw.WriteByte(']')
+	})
+	if err != nil {
+		flush()
+		return n, err
+	}
+	return n, flush()
+}
+
+// streamValue returns the JSON encoding of the value stored under k.
+func (s *Store) streamValue(tx *bbolt.Tx, k, v []byte, newValue func() interface{}) ([]byte, error) {
+	v, err := s.assemble(tx, k, v)
+	if err != nil {
+		return nil, err
+	}
+	value := newValue()
+	if err := s.decode(v, value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
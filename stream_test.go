@@ -0,0 +1,136 @@
+package bboltkv
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+type streamUser struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func newStreamUser() interface{} { return new(streamUser) }
+
+func TestStreamJSON(t *testing.T) {
+	db := openTestStore(t)
+	for i := 0; i < 250; i++ {
+		if err := db.Put(fmt.Sprintf("user:%03d", i), streamUser{fmt.Sprint("u", i), i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Put("vuser", streamUser{"outside", 1}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		limit, want int
+	}{{0, 250}, {-1, 250}, {10, 10}, {250, 250}, {1000, 250}} {
+		var buf bytes.Buffer
+		n, err := db.StreamJSON(&buf, "user:", newStreamUser, tc.limit)
+		if err != nil || n != tc.want {
+			t.Fatalf("limit %d: wrote %d, %v", tc.limit, n, err)
+		}
+		var users []streamUser
+		if err := json.Unmarshal(buf.Bytes(), &users); err != nil {
+			t.Fatalf("limit %d: %v in %q", tc.limit, err, buf.String())
+		}
+		if len(users) != tc.want || users[0] != (streamUser{"u0", 0}) || users[len(users)-1].Age != tc.want-1 {
+			t.Fatalf("limit %d: got %d users, %v first", tc.limit, len(users), users[0])
+		}
+	}
+	var buf bytes.Buffer
+	if n, err := db.StreamJSON(&buf, "missing:", newStreamUser, 0); err != nil || n != 0 || buf.String() != "[]" {
+		t.Fatalf("got %q, %d, %v", buf.String(), n, err)
+	}
+}
+
+func TestStreamJSONMalformed(t *testing.T) {
+	db := openTestStore(t)
+	for i := 0; i < 5; i++ {
+		if err := db.Put(fmt.Sprint("user:", i), streamUser{fmt.Sprint("u", i), i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Put("user:2", []string{"not", "a", "user"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	n, err := db.StreamJSON(&buf, "user:", newStreamUser, 0)
+	if err == nil || !strings.Contains(err.Error(), `"user:2"`) || n != 2 {
+		t.Fatalf("wrote %d, %v", n, err)
+	}
+	if json.Valid(buf.Bytes()) {
+		t.Fatalf("aborted stream is valid JSON: %q", buf.String())
+	}
+
+	buf.Reset()
+	skipped := 0
+	n, err = db.StreamJSON(&buf, "user:", newStreamUser, 0, WithSkipMalformed(&skipped))
+	if err != nil || n != 4 || skipped != 1 {
+		t.Fatalf("wrote %d, skipped %d, %v", n, skipped, err)
+	}
+	var users []streamUser
+	if err := json.Unmarshal(buf.Bytes(), &users); err != nil || len(users) != 4 || users[2].Age != 3 {
+		t.Fatalf("got %v, %v", users, err)
+	}
+}
+
+// heapWatcher discards what is written to it, and records how far the
+// heap grew, checked every so many writes.
+type heapWatcher struct {
+	writes, flushes int
+	base, peak      uint64
+	written         int
+}
+
+func (h *heapWatcher) Write(p []byte) (int, error) {
+	if h.writes++; h.writes%16 == 0 {
+		var m runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&m)
+		if m.HeapAlloc > h.base && m.HeapAlloc-h.base > h.peak {
+			h.peak = m.HeapAlloc - h.base
+		}
+	}
+	h.written += len(p)
+	return len(p), nil
+}
+
+func (h *heapWatcher) Flush() { h.flushes++ }
+
+func TestStreamJSONMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("streams 100k entries")
+	}
+	db := openTestStore(t)
+	const entries = 100000
+	for i := 0; i < entries; i += 10000 {
+		batch := make(map[string]interface{})
+		for j := i; j < i+10000; j++ {
+			batch[fmt.Sprintf("user:%06d", j)] = streamUser{strings.Repeat("n", 40), j}
+		}
+		if err := db.PutAll(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var m runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	h := &heapWatcher{base: m.HeapAlloc}
+	n, err := db.StreamJSON(h, "user:", newStreamUser, 0)
+	if err != nil || n != entries {
+		t.Fatalf("wrote %d, %v", n, err)
+	}
+	t.Logf("%d bytes written, heap grew by %d at most", h.written, h.peak)
+	if h.flushes < entries/streamFlushEvery {
+		t.Fatalf("flushed %d times", h.flushes)
+	}
+	if h.peak > uint64(h.written)/20 {
+		t.Fatalf("heap grew by %d bytes for %d bytes of output", h.peak, h.written)
+	}
+}
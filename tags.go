@@ -0,0 +1,315 @@
+package bboltkv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.etcd.io/bbolt"
+)
+
+// ErrBadTag is returned when a tag is empty or contains a NUL byte.
+var ErrBadTag = errors.New("bboltkv: bad tag")
+
+// Tags are kept in two internal buckets: "tags" maps each tagged key to its
+// tags, and "tag-index" holds an empty entry for every tag and key, keyed
+// by the tag, a NUL byte and the key, so that the keys carrying a tag are
+// next to each other in key order. Writing or deleting a key through wtx
+// clears its tags.
+const (
+	tagsBucket     = "tags"
+	tagIndexBucket = "tag-index"
+)
+
+func tagIndexKey(tag, key string) []byte {
+	return []byte(tag + "\x00" + key)
+}
+
+// cleanTags checks tags and returns them sorted and without duplicates.
+func cleanTags(tags []string) ([]string, error) {
+	clean := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag == "" || strings.IndexByte(tag, 0) >= 0 {
+			return nil, ErrBadTag
+		}
+		clean = append(clean, tag)
+	}
+	sort.Strings(clean)
+	n := 0
+	for i, tag := range clean {
+		if i == 0 || tag != clean[n-1] {
+			clean[n] = tag
+			n++
+		}
+	}
+	return clean[:n], nil
+}
+
+func encodeTags(tags []string) []byte {
+	var buf []byte
+	for _, tag := range tags {
+		buf = appendUvarint(buf, uint64(len(tag)))
+		buf = append(buf, tag...)
+	}
+	return buf
+}
+
+func decodeTags(raw []byte) []string {
+	var tags []string
+	for len(raw) > 0 {
+		n, w := binary.Uvarint(raw)
+		raw = raw[w:]
+		tags = append(tags, string(raw[:n]))
+		raw = raw[n:]
+	}
+	return tags
+}
+
+func appendUvarint(buf []byte, x uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutUvarint(b[:], x)]...)
+}
+
+// dropTags removes all tags from key.
+func (w *wtx) dropTags(key string) error {
+	b := w.s.aux(w.tx, tagsBucket)
+	if b == nil {
+		return nil
+	}
+	raw := b.Get([]byte(key))
+	if raw == nil {
+		return nil
+	}
+	index := w.s.aux(w.tx, tagIndexBucket)
+	for _, tag := range decodeTags(raw) {
+		if err := index.Delete(tagIndexKey(tag, key)); err != nil {
+			return err
+		}
+	}
+	return b.Delete([]byte(key))
+}
+
+// setTags replaces the tags of key, which must have been cleaned.
+func (w *wtx) setTags(key string, tags []string) error {
+	if err := w.dropTags(key); err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	b, err := w.aux(tagsBucket)
+	if err != nil {
+		return err
+	}
+	index, err := w.aux(tagIndexBucket)
+	if err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if err := index.Put(tagIndexKey(tag, key), nil); err != nil {
+			return err
+		}
+	}
+	return b.Put([]byte(key), encodeTags(tags))
+}
+
+// PutTagged puts an entry into the store like Put, and labels it with the
+// given tags, replacing any tags it had, in the same transaction. Tags are
+// arbitrary non-empty strings without NUL bytes; anything else makes
+// PutTagged return ErrBadTag. Putting the key again without tags, or
+// deleting it, removes its tags.
+//
+//	err := store.PutTagged("invoice:17", invoice, "env=prod", "type=invoice")
+//	keys, err := store.KeysByTag("type=invoice")
+func (s *Store) PutTagged(key string, value interface{}, tags ...string) error {
+	if err := s.plainKeys(); err != nil {
+		return err
+	}
+	tags, err := cleanTags(tags)
+	if err != nil {
+		return err
+	}
+	raw, err := s.encodeForPut(key, value)
+	if err != nil {
+		return err
+	}
+	return s.update(func(w *wtx) error {
+		if err := w.put(key, raw); err != nil {
+			return err
+		}
+		return w.setTags(key, tags)
+	})
+}
+
+// RetagKey replaces the tags of the entry with the given key, leaving its
+// value alone. Without tags, it removes all of them. If no such key is
+// present in the store, it returns ErrNotFound.
+func (s *Store) RetagKey(key string, tags ...string) error {
+	if err := s.plainKeys(); err != nil {
+		return err
+	}
+	tags, err := cleanTags(tags)
+	if err != nil {
+		return err
+	}
+	return s.update(func(w *wtx) error {
+		if w.get(key) == nil {
+			return ErrNotFound
+		}
+		return w.setTags(key, tags)
+	})
+}
+
+// TagsOf returns the tags of the entry with the given key, in lexicographic
+// order. If no such key is present in the store, it returns ErrNotFound.
+func (s *Store) TagsOf(key string) ([]string, error) {
+	if err := s.plainKeys(); err != nil {
+		return nil, err
+	}
+	var tags []string
+	err := s.view(func(tx *bbolt.Tx) error {
+		if tx.Bucket(s.bucketName).Get([]byte(key)) == nil || s.expired(tx, key) {
+			return ErrNotFound
+		}
+		if b := s.aux(tx, tagsBucket); b != nil {
+			tags = decodeTags(b.Get([]byte(key)))
+		}
+		return nil
+	})
+	return tags, err
+}
+
+// KeysByTag returns the keys of the entries tagged with tag, in
+// lexicographic order.
+func (s *Store) KeysByTag(tag string) ([]string, error) {
+	if err := s.plainKeys(); err != nil {
+		return nil, err
+	}
+	return s.KeysByTags(tag)
+}
+
+// KeysByTags returns the keys of the entries tagged with all of the given
+// tags, in lexicographic order. It walks the index of each tag in step,
+// skipping ahead in one whenever another is further along, so it does not
+// need to read every key carrying one of the tags.
+func (s *Store) KeysByTags(tags ...string) ([]string, error) {
+	if err := s.plainKeys(); err != nil {
+		return nil, err
+	}
+	tags, err := cleanTags(tags)
+	if err != nil {
+		return nil, err
+	} else if len(tags) == 0 {
+		return nil, ErrBadTag
+	}
+	var keys []string
+	err = s.view(func(tx *bbolt.Tx) error {
+		index := s.aux(tx, tagIndexBucket)
+		if index == nil {
+			return nil
+		}
+		walkers := make([]*tagWalker, len(tags))
+		for i, tag := range tags {
+			walkers[i] = &tagWalker{c: index.Cursor(), prefix: []byte(tag + "\x00")}
+		}
+		var key []byte
+		for {
+			// Move every walker to the first key not below key, raising
+			// key whenever one overshoots, until they all agree.
+			agreed := 0
+			for i := 0; agreed < len(walkers); i = (i + 1) % len(walkers) {
+				k := walkers[i].seek(key)
+				if k == nil {
+					return nil
+				} else if bytes.Equal(k, key) {
+					agreed++
+				} else {
+					key, agreed = k, 1
+				}
+			}
+			if !s.expired(tx, string(key)) {
+				keys = append(keys, string(key))
+			}
+			key = append(key[:len(key):len(key)], 0)
+		}
+	})
+	return keys, err
+}
+
+// tagWalker moves through the keys carrying one tag.
+type tagWalker struct {
+	c      *bbolt.Cursor
+	prefix []byte
+}
+
+// seek returns the first key carrying the tag that is not below key, or
+// nil if there is none.
+func (w *tagWalker) seek(key []byte) []byte {
+	k, _ := w.c.Seek(append(w.prefix[:len(w.prefix):len(w.prefix)], key...))
+	if k == nil || !bytes.HasPrefix(k, w.prefix) {
+		return nil
+	}
+	return k[len(w.prefix):]
+}
+
+// checkTags is the Fsck check of the tag buckets.
+func checkTags(f *fsck) {
+	b, index := f.s.aux(f.tx, tagsBucket), f.s.aux(f.tx, tagIndexBucket)
+	if index != nil {
+		c := index.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			i := bytes.IndexByte(k, 0)
+			if i < 0 {
+				f.report(FsckOrphanIndex, tagIndexBucket, k, "malformed index entry", deleteFix(tagIndexBucket, k))
+				continue
+			}
+			tag, key := string(k[:i]), k[i+1:]
+			var raw []byte
+			if b != nil {
+				raw = b.Get(key)
+			}
+			if raw == nil || !hasTag(decodeTags(raw), tag) {
+				f.report(FsckOrphanIndex, tagIndexBucket, key, "indexed under "+strconv.Quote(tag)+", but not tagged with it", deleteFix(tagIndexBucket, k))
+			}
+		}
+	}
+	if b == nil {
+		return
+	}
+	c := b.Cursor()
+	for k, raw := c.First(); k != nil; k, raw = c.Next() {
+		tags := decodeTags(raw)
+		if !f.present(k) {
+			keys := make([][]byte, len(tags))
+			for i, tag := range tags {
+				keys[i] = tagIndexKey(tag, string(k))
+			}
+			dropIndex, dropTags := deleteFix(tagIndexBucket, keys...), deleteFix(tagsBucket, k)
+			f.report(FsckOrphanMetadata, tagsBucket, k, "tags of an absent key", func(w *wtx) error {
+				if err := dropIndex(w); err != nil {
+					return err
+				}
+				return dropTags(w)
+			})
+			continue
+		}
+		for _, tag := range tags {
+			ik := tagIndexKey(tag, string(k))
+			if index == nil || index.Get(ik) == nil {
+				f.report(FsckMissingMetadata, tagIndexBucket, k, "tag "+strconv.Quote(tag)+" not indexed", putFix(tagIndexBucket, ik))
+			}
+		}
+	}
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
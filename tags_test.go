@@ -0,0 +1,128 @@
+package bboltkv
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+	db := openTestStore(t)
+	byTag := func(tag string, want ...string) {
+		t.Helper()
+		keys, err := db.KeysByTag(tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(want) == 0 {
+			want = nil
+		}
+		if !reflect.DeepEqual(keys, want) {
+			t.Fatalf("tag %s: got %v, expected %v", tag, keys, want)
+		}
+	}
+	if err := db.PutTagged("a", 1, "type=invoice", "env=prod", "env=prod"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutTagged("b", 2, "type=invoice"); err != nil {
+		t.Fatal(err)
+	}
+	if tags, err := db.TagsOf("a"); err != nil || !reflect.DeepEqual(tags, []string{"env=prod", "type=invoice"}) {
+		t.Fatalf("got %v, %v", tags, err)
+	}
+	byTag("type=invoice", "a", "b")
+	byTag("env=prod", "a")
+
+	// overwriting with tags replaces them
+	if err := db.PutTagged("a", 1, "env=dev"); err != nil {
+		t.Fatal(err)
+	}
+	byTag("type=invoice", "b")
+	byTag("env=prod")
+	byTag("env=dev", "a")
+	// retagging leaves the value alone
+	if err := db.RetagKey("b", "env=dev"); err != nil {
+		t.Fatal(err)
+	}
+	var val int
+	if err := db.Get("b", &val); err != nil || val != 2 {
+		t.Fatalf("got %d, %v, expected 2", val, err)
+	}
+	byTag("type=invoice")
+	byTag("env=dev", "a", "b")
+	// a plain Put removes the tags, and so does Delete
+	if err := db.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if tags, err := db.TagsOf("a"); err != nil || len(tags) != 0 {
+		t.Fatalf("got %v, %v, expected no tags", tags, err)
+	}
+	if err := db.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	byTag("env=dev")
+	if _, err := db.TagsOf("b"); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if err := db.RetagKey("b", "x"); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+}
+
+func TestKeysByTags(t *testing.T) {
+	db := openTestStore(t)
+	var want []string
+	for i := 0; i < 300; i++ {
+		var tags []string
+		if i%2 == 0 {
+			tags = append(tags, "two")
+		}
+		if i%3 == 0 {
+			tags = append(tags, "three")
+		}
+		if i%5 == 0 {
+			tags = append(tags, "five")
+		}
+		key := fmt.Sprintf("key%03d", i)
+		if i%30 == 0 {
+			want = append(want, key)
+		}
+		if err := db.PutTagged(key, i, tags...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// tags that are prefixes of others must not match them
+	if err := db.PutTagged("other", 0, "tw", "two2", "three", "five"); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := db.KeysByTags("five", "two", "three")
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("got %v, expected %v", keys, want)
+	}
+	if keys, err := db.KeysByTags("two", "missing"); err != nil || len(keys) != 0 {
+		t.Fatalf("got %v, %v, expected no keys", keys, err)
+	}
+	if keys, err := db.KeysByTags("three", "five"); err != nil || len(keys) != 21 {
+		t.Fatalf("got %d keys, %v, expected 21", len(keys), err)
+	}
+}
+
+func TestTagValidation(t *testing.T) {
+	db := openTestStore(t)
+	for _, tags := range [][]string{{""}, {"ok", ""}, {"a\x00b"}} {
+		if err := db.PutTagged("key", 1, tags...); err != ErrBadTag {
+			t.Fatalf("%q: got %v, expected ErrBadTag", tags, err)
+		}
+	}
+	if err := db.Get("key", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if _, err := db.KeysByTags(); err != ErrBadTag {
+		t.Fatalf("got %v, expected ErrBadTag", err)
+	}
+	if _, err := db.KeysByTag(""); err != ErrBadTag {
+		t.Fatalf("got %v, expected ErrBadTag", err)
+	}
+}
@@ -0,0 +1,80 @@
+// Package testutil contains helpers for testing code that uses bboltkv.
+package testutil
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is a clock that only moves when told to. It satisfies the
+// bboltkv.Clock interface, so that time-dependent features of a store can
+// be tested without waiting in real time:
+//
+//	clock := testutil.NewFakeClock(time.Now())
+//	store, err := bboltkv.Open(path, "bucket", bboltkv.WithClock(clock))
+//	...
+//	clock.Advance(time.Hour)
+//
+// A FakeClock is safe for concurrent use.
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// NewFakeClock returns a FakeClock set to the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the clock's current time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the clock's time once it has been
+// advanced by at least d. A non-positive d fires immediately.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+	} else {
+		c.waiters = append(c.waiters, waiter{at: c.now.Add(d), ch: ch})
+	}
+	return ch
+}
+
+// Advance moves the clock forward by d, firing any After channels whose
+// time has come.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			pending = append(pending, w)
+		} else {
+			w.ch <- c.now
+		}
+	}
+	c.waiters = pending
+}
+
+// Waiters returns the number of After channels that have not fired yet.
+// Tests can use it to wait until a background goroutine is blocked on the
+// clock before advancing it.
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
@@ -0,0 +1,41 @@
+package testutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFakeClock(start)
+	if !c.Now().Equal(start) {
+		t.Fatalf("got %v, expected %v", c.Now(), start)
+	}
+	ch := c.After(time.Minute)
+	if c.Waiters() != 1 {
+		t.Fatalf("got %d waiters, expected 1", c.Waiters())
+	}
+	c.Advance(30 * time.Second)
+	select {
+	case <-ch:
+		t.Fatal("fired early")
+	default:
+	}
+	c.Advance(30 * time.Second)
+	select {
+	case now := <-ch:
+		if !now.Equal(start.Add(time.Minute)) {
+			t.Fatalf("got %v, expected %v", now, start.Add(time.Minute))
+		}
+	default:
+		t.Fatal("did not fire")
+	}
+	if c.Waiters() != 0 {
+		t.Fatalf("got %d waiters, expected 0", c.Waiters())
+	}
+	select {
+	case <-c.After(0):
+	default:
+		t.Fatal("zero duration did not fire immediately")
+	}
+}
@@ -0,0 +1,57 @@
+package fixture_test
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/unknownnf/bboltkv"
+	"github.com/unknownnf/bboltkv/testutil/fixture"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the tests")
+
+// recordLogin stands in for the code under test of a service.
+func recordLogin(s *bboltkv.Store, user string) error {
+	var n int64
+	if err := s.Get(user+":logins", &n); err != nil && err != bboltkv.ErrNotFound {
+		return err
+	}
+	return s.Put(user+":logins", n+1)
+}
+
+func TestGolden(t *testing.T) {
+	f, err := os.Open("testdata/users.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	store := fixture.BuildFixture(t, f)
+
+	if err := recordLogin(store, "user:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("user:2", "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got bytes.Buffer
+	if err := fixture.SnapshotFixture(store, &got); err != nil {
+		t.Fatal(err)
+	}
+	const golden = "testdata/users-after.jsonl"
+	if *update {
+		if err := ioutil.WriteFile(golden, got.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Fatalf("store holds\n%s\nexpected\n%s", got.Bytes(), want)
+	}
+}
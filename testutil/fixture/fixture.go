@@ -0,0 +1,195 @@
+// Package fixture builds bboltkv stores from declarative fixture files, and
+// writes stores back out as such files, so that tests can check the stores
+// they start from, and the ones they end up with, into version control.
+//
+// A fixture file holds one entry per line, as a JSON object with the key,
+// the codec used for the value, and the value:
+//
+//	{"key":"user:1","codec":"string","value":"alice"}
+//	{"key":"user:1:logins","codec":"int","value":42}
+//	{"key":"user:1:roles","codec":"map","value":{"admin":"yes"}}
+//
+// The codecs are:
+//
+//	string   a JSON string, stored as a Go string
+//	int      a JSON number, stored as an int64
+//	float    a JSON number, stored as a float64
+//	bool     true or false, stored as a bool
+//	bytes    a base64 JSON string, stored as a []byte
+//	strings  a JSON array of strings, stored as a []string
+//	map      a JSON object of strings, stored as a map[string]string
+//	raw      a base64 JSON string of a value as Encode returns it, stored
+//	         as it is, for values of any other type
+package fixture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/unknownnf/bboltkv"
+)
+
+// entry is a line of a fixture file.
+type entry struct {
+	Key   string          `json:"key"`
+	Codec string          `json:"codec"`
+	Value json.RawMessage `json:"value"`
+}
+
+// BuildFixture creates a store in a temporary directory of t from the
+// fixture file read from spec, and returns it. The store is opened with
+// bboltkv.WithCanonicalEncoding, followed by opts, so that equal fixtures
+// make stores with equal values, byte for byte, and closed when the test
+// ends. BuildFixture fails the test if spec is not a valid fixture file, or
+// names a key more than once.
+//
+//	f, _ := os.Open("testdata/users.jsonl")
+//	store := fixture.BuildFixture(t, f)
+func BuildFixture(t testing.TB, spec io.Reader, opts ...bboltkv.Option) *bboltkv.Store {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fixture.db")
+	s, err := bboltkv.Open(path, "fixture", append([]bboltkv.Option{bboltkv.WithCanonicalEncoding()}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	entries := bboltkv.RawEntries{}
+	sc := bufio.NewScanner(spec)
+	sc.Buffer(nil, 64<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var e entry
+		dec := json.NewDecoder(bytes.NewReader(sc.Bytes()))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&e); err != nil {
+			t.Fatalf("fixture line %d: %v", line, err)
+		}
+		if _, ok := entries[e.Key]; ok {
+			t.Fatalf("fixture line %d: key %q given again", line, e.Key)
+		}
+		raw, err := encode(s, e)
+		if err != nil {
+			t.Fatalf("fixture line %d: key %q: %v", line, e.Key, err)
+		}
+		entries[e.Key] = raw
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutAllEncoded(entries); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// encode returns the encoded value of a fixture entry.
+func encode(s *bboltkv.Store, e entry) ([]byte, error) {
+	if e.Codec == "raw" {
+		var raw []byte
+		err := json.Unmarshal(e.Value, &raw)
+		return raw, err
+	}
+	for _, c := range codecs {
+		if c.name != e.Codec {
+			continue
+		}
+		v := c.new()
+		if err := json.Unmarshal(e.Value, v); err != nil {
+			return nil, err
+		}
+		return s.Encode(reflect.ValueOf(v).Elem().Interface())
+	}
+	return nil, fmt.Errorf("unknown codec %q", e.Codec)
+}
+
+// SnapshotFixture writes the entries of s to w as a fixture file, see
+// BuildFixture, in key order. Each value is written with the first codec,
+// in the order the package documentation lists them, that decodes it, so
+// that building a fixture and writing it out again gives the same bytes,
+// as long as the file was written by SnapshotFixture in the first place.
+// Values of other types are written as raw, and are stable if they were
+// encoded canonically, see bboltkv.WithCanonicalEncoding.
+func SnapshotFixture(s *bboltkv.Store, w io.Writer) error {
+	var lines [][]byte
+	var keys []string
+	err := s.ForEachChunked(0, func(key string, raw []byte) error {
+		e, err := snapshot(s, key, raw)
+		if err != nil {
+			return fmt.Errorf("fixture: key %q: %w", key, err)
+		}
+		line, err := marshal(e)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		lines = append(lines, line)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// keys come in the order they are stored in, which differs with
+	// bboltkv.WithEncryptedKeys
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return keys[order[i]] < keys[order[j]] })
+	bw := bufio.NewWriter(w)
+	for _, i := range order {
+		bw.Write(lines[i])
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// codecs are the codecs of fixture files but raw, in the order
+// SnapshotFixture tries them, with a function returning a pointer to
+// decode values into.
+var codecs = []struct {
+	name string
+	new  func() interface{}
+}{
+	{"string", func() interface{} { return new(string) }},
+	{"int", func() interface{} { return new(int64) }},
+	{"float", func() interface{} { return new(float64) }},
+	{"bool", func() interface{} { return new(bool) }},
+	{"bytes", func() interface{} { return new([]byte) }},
+	{"strings", func() interface{} { return new([]string) }},
+	{"map", func() interface{} { return new(map[string]string) }},
+}
+
+// snapshot returns the fixture entry for a value.
+func snapshot(s *bboltkv.Store, key string, raw []byte) (entry, error) {
+	for _, c := range codecs {
+		v := c.new()
+		if s.Decode(raw, v) != nil {
+			continue
+		}
+		value, err := marshal(v)
+		return entry{Key: key, Codec: c.name, Value: value}, err
+	}
+	value, err := marshal(raw)
+	return entry{Key: key, Codec: "raw", Value: value}, err
+}
+
+// marshal is json.Marshal, leaving characters special to HTML as they are,
+// so that fixture files read as they were written.
+func marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,164 @@
+package fixture
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/unknownnf/bboltkv"
+)
+
+type profile struct {
+	Name  string
+	Attrs map[string]int
+}
+
+func snapshotOf(t *testing.T, s *bboltkv.Store) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := SnapshotFixture(s, &buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	spec, err := ioutil.ReadFile(filepath.Join("testdata", "users.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a value of another type goes in raw, encoded canonically
+	enc := BuildFixture(t, strings.NewReader(""))
+	raw, err := enc.Encode(profile{"alice", map[string]int{"a": 1, "b": 2, "c": 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(spec), "\n")
+	lines = append(lines[:len(lines)-1],
+		fmt.Sprintf(`{"key":"user:1:profile","codec":"raw","value":"%s"}`+"\n", base64.StdEncoding.EncodeToString(raw)),
+		`{"key":"user:<html>","codec":"string","value":"a & b"}`+"\n")
+	// which sorts them by key
+	sort.Strings(lines)
+	spec = []byte(strings.Join(lines, ""))
+
+	s := BuildFixture(t, bytes.NewReader(spec))
+	if got := snapshotOf(t, s); !bytes.Equal(got, spec) {
+		t.Fatalf("got\n%s\nexpected\n%s", got, spec)
+	}
+	var p profile
+	if err := s.Get("user:1:profile", &p); err != nil || p.Attrs["b"] != 2 {
+		t.Fatalf("got %+v, %v", p, err)
+	}
+	var n int64
+	if err := s.Get("user:1:logins", &n); err != nil || n != 42 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+
+	// a fixture written out in another order, or with other spacing, is
+	// written out in the one form
+	messy := "\n" + `{"codec":"int",  "key":"b","value":1}` + "\n" + `{"key":"a","codec":"string","value":"x"}`
+	want := `{"key":"a","codec":"string","value":"x"}` + "\n" + `{"key":"b","codec":"int","value":1}` + "\n"
+	if got := snapshotOf(t, BuildFixture(t, strings.NewReader(messy))); string(got) != want {
+		t.Fatalf("got\n%s", got)
+	}
+}
+
+func TestMapsDeterministic(t *testing.T) {
+	settings := map[string]string{}
+	attrs := map[string]int{}
+	for i := 0; i < 50; i++ {
+		settings[fmt.Sprintf("k%02d", i)] = fmt.Sprint(i)
+		attrs[fmt.Sprintf("a%02d", i)] = i
+	}
+	// stores written without canonical encoding, whose maps gob encodes in
+	// random order
+	var stores []*bboltkv.Store
+	for i := 0; i < 3; i++ {
+		s, err := bboltkv.Open(filepath.Join(t.TempDir(), "plain.db"), "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		if err := s.Put("settings", settings); err != nil {
+			t.Fatal(err)
+		}
+		stores = append(stores, s)
+	}
+	first := snapshotOf(t, stores[0])
+	for _, s := range stores[1:] {
+		if got := snapshotOf(t, s); !bytes.Equal(got, first) {
+			t.Fatalf("got\n%s\nand\n%s", first, got)
+		}
+	}
+	// fixtures built from it hold the same bytes every time, and so do the
+	// values their stores encode, such as structs holding maps, which are
+	// written out raw
+	var raws, snapshots [][]byte
+	for i := 0; i < 3; i++ {
+		s := BuildFixture(t, bytes.NewReader(first))
+		raw, err := s.GetRaw("settings")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put("profile", profile{"alice", attrs}); err != nil {
+			t.Fatal(err)
+		}
+		raws = append(raws, raw)
+		snapshots = append(snapshots, snapshotOf(t, s))
+	}
+	for i := 1; i < 3; i++ {
+		if !bytes.Equal(raws[i], raws[0]) {
+			t.Fatalf("got %x and %x", raws[0], raws[i])
+		}
+		if !bytes.Equal(snapshots[i], snapshots[0]) {
+			t.Fatalf("got\n%s\nand\n%s", snapshots[0], snapshots[i])
+		}
+	}
+	if !bytes.Contains(snapshots[0], []byte(`"key":"profile","codec":"raw"`)) {
+		t.Fatalf("got\n%s", snapshots[0])
+	}
+}
+
+// fatalRecorder records the first failure of BuildFixture, and stops it
+// like testing.T does.
+type fatalRecorder struct {
+	testing.TB
+	failure string
+}
+
+func (r *fatalRecorder) Fatal(args ...interface{}) {
+	r.failure = fmt.Sprint(args...)
+	runtime.Goexit()
+}
+
+func (r *fatalRecorder) Fatalf(format string, args ...interface{}) {
+	r.failure = fmt.Sprintf(format, args...)
+	runtime.Goexit()
+}
+
+func TestBuildFixtureErrors(t *testing.T) {
+	for spec, want := range map[string]string{
+		`{"key":"a","codec":"string","value":1}`:                                                       "line 1",
+		`{"key":"a","codec":"yaml","value":1}`:                                                         "unknown codec",
+		`{"key":"a","codec":"string","value":"x","ttl":5}`:                                             "unknown field",
+		"{\"key\":\"a\",\"codec\":\"int\",\"value\":1}\n{\"key\":\"a\",\"codec\":\"int\",\"value\":2}": "line 2",
+		`not json`: "line 1",
+	} {
+		r := &fatalRecorder{TB: t}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			BuildFixture(r, strings.NewReader(spec))
+		}()
+		<-done
+		if !strings.Contains(r.failure, want) {
+			t.Errorf("%s: got failure %q, expected %q", spec, r.failure, want)
+		}
+	}
+}
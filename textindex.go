@@ -0,0 +1,323 @@
+package bboltkv
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+
+	"go.etcd.io/bbolt"
+)
+
+// ErrNoTextIndex is returned by SearchText, RebuildTextIndex and
+// DropTextIndex for text indexes that have not been created with
+// CreateTextIndex.
+var ErrNoTextIndex = errors.New("bboltkv: no such text index")
+
+// ErrTextIndexExists is returned by CreateTextIndex when a text index of the
+// same name has already been created.
+var ErrTextIndexExists = errors.New("bboltkv: text index already exists")
+
+// TextExtract returns the text of an entry that a text index covers, see
+// CreateTextIndex, or "" if the entry is not to be indexed. raw is the
+// entry's encoded value, and only valid until TextExtract returns.
+type TextExtract func(raw []byte) (text string, err error)
+
+type textIndex struct {
+	name    string
+	extract TextExtract
+	stop    map[string]bool
+}
+
+// textIndexes keeps the text indexes created on the store. n is the number
+// of indexes, accessed atomically, so that writes need not lock when there
+// are none.
+type textIndexes struct {
+	n      int32
+	mu     sync.RWMutex
+	byName map[string]*textIndex
+}
+
+// textIndexBucket returns the name of the internal bucket holding a text
+// index: an empty entry for every token and key whose text holds it, keyed
+// by the token, a NUL byte and the key, like the tag index.
+func textIndexBucket(name string) string {
+	return "text:" + name
+}
+
+// WithStopWords makes CreateTextIndex leave the given words out of the
+// index, and SearchText out of queries, ignoring case.
+func WithStopWords(words ...string) OpOption {
+	return func(o *opOptions) {
+		o.stopWords = append(o.stopWords, words...)
+	}
+}
+
+// tokens returns the words of text, lowercased and without duplicates or
+// stop words, in the order they first appear. Words are runs of letters,
+// in any script.
+func (x *textIndex) tokens(text string) []string {
+	var tokens []string
+	seen := map[string]bool{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !unicode.IsLetter(r) }) {
+		if !seen[word] && !x.stop[word] {
+			seen[word] = true
+			tokens = append(tokens, word)
+		}
+	}
+	return tokens
+}
+
+// CreateTextIndex creates a full-text index of the store's entries: extract
+// returns the text of each entry, which is split into words, lowercased,
+// and recorded as the entry's tokens. Every write and deletion updates the
+// index in the same transaction, so SearchText always finds what is
+// stored. If extract fails, the write it was called for fails with the same
+// error, and nothing is written. extract runs inside the write transaction,
+// so it must not call the store's methods, other than Encode and Decode.
+//
+// Words are runs of letters, so anything else, digits included, separates
+// them. Words given with WithStopWords are not indexed.
+//
+// The index is stored in the database file, but extract is not, so text
+// indexes must be created again each time the store is opened, like views.
+// CreateTextIndex builds the index from all entries, like
+// RebuildTextIndex, so that writes made while it did not exist are
+// accounted for.
+//
+//	err := store.CreateTextIndex("titles", func(raw []byte) (string, error) {
+//	    var t Ticket
+//	    if err := store.Decode(raw, &t); err != nil {
+//	        return "", nil // not a ticket
+//	    }
+//	    return t.Title, nil
+//	}, bboltkv.WithStopWords("the", "a", "of"))
+func (s *Store) CreateTextIndex(name string, extract TextExtract, opts ...OpOption) error {
+	if err := s.plainKeys(); err != nil {
+		return err
+	}
+	o := buildOpOptions(opts)
+	x := &textIndex{name: name, extract: extract, stop: map[string]bool{}}
+	for _, word := range o.stopWords {
+		x.stop[strings.ToLower(word)] = true
+	}
+	added := false
+	err := s.update(func(w *wtx) error {
+		if err := w.rebuildTextIndex(x); err != nil {
+			return err
+		}
+		// Writers run one at a time, so no other write can miss the index
+		// between here and the commit.
+		xs := &s.textIndexes
+		xs.mu.Lock()
+		defer xs.mu.Unlock()
+		if xs.byName[name] != nil {
+			return ErrTextIndexExists
+		}
+		if xs.byName == nil {
+			xs.byName = make(map[string]*textIndex)
+		}
+		xs.byName[name] = x
+		atomic.StoreInt32(&xs.n, int32(len(xs.byName)))
+		added = true
+		w.sideEffects = true
+		return nil
+	})
+	if err != nil && added {
+		s.forgetTextIndex(name)
+	}
+	return err
+}
+
+// SearchText calls fn for the key of every entry whose text, in the index
+// name, holds all words of query, in key order, reading them in a single
+// transaction. The query is split into words like the texts are, so case
+// and punctuation do not matter, and stop words are left out. A query
+// without any other words matches nothing. Expired entries are skipped.
+// Returning an error from fn stops the search and returns that error.
+//
+// The index of each word is walked in step with the others, skipping
+// ahead in one whenever another is further along, so a search does not
+// read every key holding one of the words.
+//
+//	err := store.SearchText("titles", "printer jammed", func(key string) error {
+//	    results = append(results, key)
+//	    return nil
+//	})
+func (s *Store) SearchText(name, query string, fn func(key string) error) error {
+	x := s.getTextIndex(name)
+	if x == nil {
+		return ErrNoTextIndex
+	}
+	tokens := x.tokens(query)
+	if len(tokens) == 0 {
+		return nil
+	}
+	return s.view(func(tx *bbolt.Tx) error {
+		index := s.aux(tx, textIndexBucket(name))
+		if index == nil {
+			return nil
+		}
+		walkers := make([]*tagWalker, len(tokens))
+		for i, token := range tokens {
+			walkers[i] = &tagWalker{c: index.Cursor(), prefix: []byte(token + "\x00")}
+		}
+		var key []byte
+		for {
+			// as in KeysByTags
+			agreed := 0
+			for i := 0; agreed < len(walkers); i = (i + 1) % len(walkers) {
+				k := walkers[i].seek(key)
+				if k == nil {
+					return nil
+				} else if bytes.Equal(k, key) {
+					agreed++
+				} else {
+					key, agreed = k, 1
+				}
+			}
+			if !s.expired(tx, string(key)) {
+				if err := fn(string(key)); err != nil {
+					return err
+				}
+			}
+			key = append(key[:len(key):len(key)], 0)
+		}
+	})
+}
+
+// RebuildTextIndex builds the text index name from scratch, from all
+// entries of the store.
+func (s *Store) RebuildTextIndex(name string) error {
+	x := s.getTextIndex(name)
+	if x == nil {
+		return ErrNoTextIndex
+	}
+	return s.update(func(w *wtx) error {
+		return w.rebuildTextIndex(x)
+	})
+}
+
+// DropTextIndex removes the text index name, and deletes it from the
+// database file.
+func (s *Store) DropTextIndex(name string) error {
+	x := s.getTextIndex(name)
+	if x == nil {
+		return ErrNoTextIndex
+	}
+	removed := false
+	err := s.update(func(w *wtx) error {
+		bucket := s.auxName(textIndexBucket(name))
+		if w.tx.Bucket(bucket) != nil {
+			if err := w.tx.DeleteBucket(bucket); err != nil {
+				return err
+			}
+		}
+		// as in CreateTextIndex, no other write runs until the commit
+		s.forgetTextIndex(name)
+		removed = true
+		w.sideEffects = true
+		return nil
+	})
+	if err != nil && removed {
+		xs := &s.textIndexes
+		xs.mu.Lock()
+		xs.byName[name] = x
+		atomic.StoreInt32(&xs.n, int32(len(xs.byName)))
+		xs.mu.Unlock()
+	}
+	return err
+}
+
+func (s *Store) getTextIndex(name string) *textIndex {
+	s.textIndexes.mu.RLock()
+	defer s.textIndexes.mu.RUnlock()
+	return s.textIndexes.byName[name]
+}
+
+func (s *Store) forgetTextIndex(name string) {
+	xs := &s.textIndexes
+	xs.mu.Lock()
+	defer xs.mu.Unlock()
+	delete(xs.byName, name)
+	atomic.StoreInt32(&xs.n, int32(len(xs.byName)))
+}
+
+// rebuildTextIndex empties the index and adds every entry to it.
+func (w *wtx) rebuildTextIndex(x *textIndex) error {
+	name := w.s.auxName(textIndexBucket(x.name))
+	if w.tx.Bucket(name) != nil {
+		if err := w.tx.DeleteBucket(name); err != nil {
+			return err
+		}
+	}
+	index, err := w.aux(textIndexBucket(x.name))
+	if err != nil {
+		return err
+	}
+	c := w.b.Cursor()
+	for k, raw := c.First(); k != nil; k, raw = c.Next() {
+		raw, err := w.s.assemble(w.tx, k, raw)
+		if err != nil {
+			return err
+		}
+		if err := indexText(index, x, string(k), raw, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateTextIndexes updates the text indexes for a write to key, before it
+// is made. raw is the value being written, nil for deletes.
+func (w *wtx) updateTextIndexes(key string, raw []byte) error {
+	if atomic.LoadInt32(&w.s.textIndexes.n) == 0 {
+		return nil
+	}
+	w.s.textIndexes.mu.RLock()
+	defer w.s.textIndexes.mu.RUnlock()
+	old, err := w.s.assemble(w.tx, []byte(key), w.b.Get([]byte(key)))
+	if err != nil {
+		return err
+	}
+	for _, x := range w.s.textIndexes.byName {
+		index, err := w.aux(textIndexBucket(x.name))
+		if err != nil {
+			return err
+		}
+		if old != nil {
+			if err := indexText(index, x, key, old, false); err != nil {
+				return err
+			}
+		}
+		if raw != nil {
+			if err := indexText(index, x, key, raw, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// indexText adds the tokens of the value raw of key to the index, or
+// removes them.
+func indexText(index *bbolt.Bucket, x *textIndex, key string, raw []byte, add bool) error {
+	text, err := x.extract(raw)
+	if err != nil {
+		return err
+	}
+	for _, token := range x.tokens(text) {
+		k := tagIndexKey(token, key)
+		if add {
+			err = index.Put(k, nil)
+		} else {
+			err = index.Delete(k)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package bboltkv
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+type ticket struct {
+	Title string
+}
+
+// ticketTitles returns a text extract for the titles of tickets.
+func ticketTitles(db *Store) TextExtract {
+	return func(raw []byte) (string, error) {
+		var t ticket
+		if db.Decode(raw, &t) != nil {
+			return "", nil
+		}
+		if t.Title == "fail" {
+			return "", errors.New("bad title")
+		}
+		return t.Title, nil
+	}
+}
+
+func searchText(t *testing.T, db *Store, query string) string {
+	t.Helper()
+	var keys []string
+	err := db.SearchText("titles", query, func(key string) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(keys, " ")
+}
+
+// postings returns the entries of the text index name, and the size of
+// their keys.
+func postings(t *testing.T, db *Store, name string) (int, int) {
+	t.Helper()
+	n, size := 0, 0
+	err := db.view(func(tx *bbolt.Tx) error {
+		b := db.aux(tx, textIndexBucket(name))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			n++
+			size += len(k) + len(v)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n, size
+}
+
+func TestTextIndex(t *testing.T) {
+	db := openTestStore(t)
+	err := db.PutAll(map[string]interface{}{
+		"t:1":   ticket{"Printer jammed on floor 3"},
+		"t:2":   ticket{"The printer is out of toner"},
+		"t:3":   ticket{"VPN drops, printer fine"},
+		"other": 42,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateTextIndex("titles", ticketTitles(db), WithStopWords("The", "of", "is")); err != nil {
+		t.Fatal(err)
+	}
+	// entries written before the index was created are found
+	for query, want := range map[string]string{
+		"printer":         "t:1 t:2 t:3",
+		"PRINTER, jammed": "t:1",
+		"toner printer":   "t:2",
+		"printer vpn":     "t:3",
+		"jammed toner":    "",
+		"the":             "",
+		"":                "",
+		"floor3":          "t:1",
+	} {
+		if got := searchText(t, db, query); got != want {
+			t.Errorf("%q: got %q, expected %q", query, got, want)
+		}
+	}
+
+	if err := db.Put("t:4", ticket{"Printer on fire"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("t:1", ticket{"Printer fixed"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("t:2"); err != nil {
+		t.Fatal(err)
+	}
+	for query, want := range map[string]string{
+		"printer":       "t:1 t:3 t:4",
+		"jammed":        "",
+		"fixed printer": "t:1",
+		"toner":         "",
+		"fire":          "t:4",
+	} {
+		if got := searchText(t, db, query); got != want {
+			t.Errorf("%q: got %q, expected %q", query, got, want)
+		}
+	}
+
+	// a failing extract fails the write
+	if err := db.Put("t:5", ticket{"fail"}); err == nil || err.Error() != "bad title" {
+		t.Fatalf("got %v", err)
+	}
+	if has, _ := db.Has("t:5"); has {
+		t.Fatal("failed write was made")
+	}
+
+	// deleting the entries leaves no postings behind
+	for _, key := range []string{"t:1", "t:3", "t:4"} {
+		if err := db.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, _ := postings(t, db, "titles"); n != 0 {
+		t.Fatalf("%d postings left", n)
+	}
+}
+
+func TestTextIndexUnicode(t *testing.T) {
+	db := openTestStore(t)
+	if err := db.CreateTextIndex("titles", ticketTitles(db)); err != nil {
+		t.Fatal(err)
+	}
+	err := db.PutAll(map[string]interface{}{
+		"t:1": ticket{"Café crème — Ünïcode"},
+		"t:2": ticket{"Straße/ΣΊΣΥΦΟΣ 東京タワー"},
+		"t:3": ticket{"café au lait"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for query, want := range map[string]string{
+		"CAFÉ":       "t:1 t:3",
+		"ünïcode":    "t:1",
+		"cafe":       "",
+		"straße":     "t:2",
+		"ΣΊΣΥΦΟΣ":    "t:2",
+		"東京タワー":      "t:2",
+		"crème café": "t:1",
+	} {
+		if got := searchText(t, db, query); got != want {
+			t.Errorf("%q: got %q, expected %q", query, got, want)
+		}
+	}
+}
+
+func TestTextIndexManage(t *testing.T) {
+	db := openTestStore(t)
+	if err := db.SearchText("titles", "x", func(string) error { return nil }); err != ErrNoTextIndex {
+		t.Fatalf("got %v", err)
+	}
+	if err := db.CreateTextIndex("titles", ticketTitles(db)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateTextIndex("titles", ticketTitles(db)); err != ErrTextIndexExists {
+		t.Fatalf("got %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := db.Put(keyN(i), ticket{"printer"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errStop := errors.New("stop")
+	n := 0
+	err := db.SearchText("titles", "printer", func(string) error {
+		if n++; n == 2 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop || n != 2 {
+		t.Fatalf("got %v after %d keys", err, n)
+	}
+
+	// a rebuild repairs a damaged index
+	err = db.update(func(w *wtx) error {
+		b, err := w.aux(textIndexBucket("titles"))
+		if err != nil {
+			return err
+		}
+		return b.Delete(tagIndexKey("printer", keyN(1)))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RebuildTextIndex("titles"); err != nil {
+		t.Fatal(err)
+	}
+	if got := searchText(t, db, "printer"); got != "k00000 k00001 k00002" {
+		t.Fatalf("got %q", got)
+	}
+
+	if err := db.DropTextIndex("titles"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := postings(t, db, "titles"); n != 0 {
+		t.Fatalf("%d postings left", n)
+	}
+	if err := db.Put(keyN(3), ticket{"printer"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := postings(t, db, "titles"); n != 0 {
+		t.Fatalf("%d postings after dropping the index", n)
+	}
+	for _, err := range []error{db.DropTextIndex("titles"), db.RebuildTextIndex("titles")} {
+		if err != ErrNoTextIndex {
+			t.Fatalf("got %v", err)
+		}
+	}
+	if err := db.CreateTextIndex("titles", ticketTitles(db)); err != nil {
+		t.Fatal(err)
+	}
+	if got := searchText(t, db, "printer"); got != "k00000 k00001 k00002 k00003" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestTextIndexSize(t *testing.T) {
+	db := openTestStore(t)
+	if err := db.CreateTextIndex("titles", ticketTitles(db), WithStopWords("the")); err != nil {
+		t.Fatal(err)
+	}
+	// a corpus of titles of 3 to 8 words, drawn from 50, with repeats
+	words := []string{"the"}
+	for i := 0; i < 49; i++ {
+		words = append(words, fmt.Sprintf("w%c%c", 'a'+i/26, 'a'+i%26))
+	}
+	r := rand.New(rand.NewSource(1))
+	corpus := func() map[string]interface{} {
+		entries := map[string]interface{}{}
+		for i := 0; i < 500; i++ {
+			var title []string
+			for j := 3 + r.Intn(6); j > 0; j-- {
+				title = append(title, words[r.Intn(len(words))])
+			}
+			entries[keyN(i)] = ticket{strings.Join(title, " ")}
+		}
+		return entries
+	}
+	// the postings are one per distinct word of each title, holding the
+	// word and the key
+	expected := func(entries map[string]interface{}) (int, int) {
+		n, size := 0, 0
+		x := &textIndex{stop: map[string]bool{"the": true}}
+		for key, e := range entries {
+			for _, token := range x.tokens(e.(ticket).Title) {
+				n++
+				size += len(token) + 1 + len(key)
+			}
+		}
+		return n, size
+	}
+	for round := 0; round < 3; round++ {
+		entries := corpus()
+		if err := db.PutAll(entries); err != nil {
+			t.Fatal(err)
+		}
+		wantN, wantSize := expected(entries)
+		n, size := postings(t, db, "titles")
+		if n != wantN || size != wantSize {
+			t.Fatalf("round %d: %d postings of %d bytes, expected %d of %d", round, n, size, wantN, wantSize)
+		}
+		if size > 500*8*(4+1+6) {
+			t.Fatalf("%d bytes of postings", size)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package bboltkv
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	// ErrTimeout is returned by writes that could not begin their
+	// transaction in time, see WithOpTimeout, and by Ping when its deadline
+	// passes. Nothing has been written.
+	ErrTimeout = errors.New("bboltkv: write timed out")
+
+	// ErrOverrun is returned by writes that began their transaction in
+	// time, but finished after the deadline, see WithOpTimeout. The write
+	// has been made.
+	ErrOverrun = errors.New("bboltkv: write finished after its deadline")
+)
+
+// WithOpTimeout bounds how long Put, Delete, PutAll and the other writing
+// methods wait for their transaction to begin, which they may not while
+// another write holds bbolt's writer lock. A write that has not begun within
+// d gives up with ErrTimeout, writing nothing. Writes cannot be aborted once
+// they have begun, so one that begins in time always completes, but if it
+// completes after d, it returns ErrOverrun rather than nil, so that callers
+// can notice slow commits.
+//
+// PutContext, DeleteContext and PutAllContext take the deadline from their
+// context instead, if it has one. Writes queued in a write buffer, see
+// WithWriteBuffer, are not bounded, as they do not wait for a transaction.
+func WithOpTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.opTimeout = d
+	}
+}
+
+// writeContext runs fn in a read-write transaction, like write, unless ctx
+// is done before the transaction begins.
+func (s *Store) writeContext(ctx context.Context, fn func(w *wtx) error) error {
+	type began struct {
+		tx  *bbolt.Tx
+		err error
+	}
+	if err := s.holdWrite(ctx); err != nil {
+		return err
+	}
+	c := make(chan began, 1)
+	go func() {
+		tx, err := s.db.Begin(true)
+		c <- began{tx, err}
+	}()
+	var b began
+	select {
+	case b = <-c:
+	case <-ctx.Done():
+		// Begin cannot be interrupted, so release the transaction
+		// whenever it arrives.
+		go func() {
+			if b := <-c; b.tx != nil {
+				b.tx.Rollback()
+			}
+			s.freezer.release()
+		}()
+		return contextError(ctx)
+	}
+	defer s.freezer.release()
+	if b.err != nil {
+		return b.err
+	}
+	defer b.tx.Rollback()
+	w, err := s.apply(b.tx, fn)
+	if err == nil {
+		err = b.tx.Commit()
+	}
+	if err != nil {
+		w.abort()
+		return err
+	}
+	if s.rot != nil {
+		s.rot.committed()
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrOverrun
+	}
+	return nil
+}
+
+// contextError returns the error for giving up because ctx is done:
+// ErrTimeout if its deadline passed, and its error otherwise.
+func contextError(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,111 @@
+package bboltkv
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// holdWriter keeps bbolt's writer lock for d, and returns once it has it.
+func holdWriter(t *testing.T, db *Store, d time.Duration) <-chan error {
+	t.Helper()
+	locked := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- db.GetDb().Update(func(tx *bbolt.Tx) error {
+			close(locked)
+			time.Sleep(d)
+			return nil
+		})
+	}()
+	<-locked
+	return done
+}
+
+func TestOpTimeout(t *testing.T) {
+	db := openTestStore(t, WithOpTimeout(50*time.Millisecond))
+	// without contention, writes are unaffected
+	if err := db.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutAll(map[string]interface{}{"b": 2, "c": 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("c"); err != nil {
+		t.Fatal(err)
+	}
+
+	done := holdWriter(t, db, 300*time.Millisecond)
+	start := time.Now()
+	if err := db.Put("x", 1); err != ErrTimeout {
+		t.Fatalf("got %v, expected ErrTimeout", err)
+	}
+	if err := db.Delete("a"); err != ErrTimeout {
+		t.Fatalf("got %v, expected ErrTimeout", err)
+	}
+	if err := db.PutAll(map[string]interface{}{"y": 1}); err != ErrTimeout {
+		t.Fatalf("got %v, expected ErrTimeout", err)
+	}
+	if d := time.Since(start); d > 250*time.Millisecond {
+		t.Fatalf("timing out took %v", d)
+	}
+	// reads go on
+	if err := db.Get("a", nil); err != nil {
+		t.Fatal(err)
+	}
+	// a longer deadline of the call's own replaces the store's
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PutContext(ctx, "z", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := db.Keys(); err != nil || len(keys) != 3 || keys[2] != "z" {
+		t.Fatalf("got keys %v, %v", keys, err)
+	}
+}
+
+func TestOpTimeoutContext(t *testing.T) {
+	db := openTestStore(t)
+	done := holdWriter(t, db, 200*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := db.PutContext(ctx, "a", 1); err != ErrTimeout {
+		t.Fatalf("got %v, expected ErrTimeout", err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := db.DeleteContext(ctx, "a"); err != context.Canceled {
+		t.Fatalf("got %v, expected context.Canceled", err)
+	}
+	<-done
+	if err := db.PutAllContext(context.Background(), map[string]interface{}{"b": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := db.Has("a"); err != nil || ok {
+		t.Fatalf("timed out write was made: %v, %v", ok, err)
+	}
+}
+
+func TestOpTimeoutOverrun(t *testing.T) {
+	db := openTestStore(t, WithOpTimeout(20*time.Millisecond))
+	// a view whose reduce is slow makes the transaction itself overrun
+	slow := func(viewKey string, existing []byte, key string, raw []byte, deleted bool) ([]byte, error) {
+		time.Sleep(50 * time.Millisecond)
+		return raw, nil
+	}
+	route := func(key string, raw []byte) string { return key }
+	if err := db.CreateView("slow", "slow:", slow, route); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("slow:1", 1); err != ErrOverrun {
+		t.Fatalf("got %v, expected ErrOverrun", err)
+	}
+	if ok, err := db.Has("slow:1"); err != nil || !ok {
+		t.Fatalf("overrunning write was not made: %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,248 @@
+package bboltkv
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"go.etcd.io/bbolt"
+)
+
+// offsetsBucket holds the offsets recorded with Ack, under the topic and
+// the consumer joined with Key, as 8 bytes big-endian.
+const offsetsBucket = "topic-offsets"
+
+// topicBatch is the number of messages a subscription reads per
+// transaction.
+const topicBatch = 100
+
+// topicBucket returns the name of the internal bucket holding the messages
+// of a topic, under their sequence numbers as 8 bytes big-endian.
+func topicBucket(topic string) string {
+	return "topic:" + topic
+}
+
+// Message is a message published to a topic, see Subscribe.
+type Message struct {
+	Topic   string
+	Seq     uint64 // sequence number Publish returned for it
+	Payload []byte // the encoded payload
+
+	s *Store
+}
+
+// Decode decodes the message's payload into value, like Get does with
+// values.
+func (m Message) Decode(value interface{}) error {
+	return m.s.decode(m.Payload, value)
+}
+
+// topics tracks the topics published to, so that subscriptions can wait
+// for new messages.
+type topics struct {
+	mu      sync.Mutex
+	changed map[string]chan struct{} // closed and replaced on every publish
+}
+
+// waitFor returns a channel that is closed when a message is next
+// published to topic.
+func (t *topics) waitFor(topic string) <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ch := t.changed[topic]
+	if ch == nil {
+		if t.changed == nil {
+			t.changed = make(map[string]chan struct{})
+		}
+		ch = make(chan struct{})
+		t.changed[topic] = ch
+	}
+	return ch
+}
+
+func (t *topics) published(topic string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ch := t.changed[topic]; ch != nil {
+		close(ch)
+		delete(t.changed, topic)
+	}
+}
+
+// Publish appends payload to the messages of topic, encoded like a value of
+// Put, and returns its sequence number. Sequence numbers start at 1 and
+// grow by one with every message published to the topic, also across
+// TrimTopic. Messages are stored in the database file until TrimTopic
+// removes them, or WithJournalRetention prunes them.
+//
+//	seq, err := store.Publish("orders", OrderPlaced{ID: order.ID})
+func (s *Store) Publish(topic string, payload interface{}) (uint64, error) {
+	raw, err := s.Encode(payload)
+	if err != nil {
+		return 0, err
+	}
+	var seq uint64
+	err = s.update(func(w *wtx) error {
+		b, err := w.aux(topicBucket(topic))
+		if err != nil {
+			return err
+		}
+		if seq, err = b.NextSequence(); err != nil {
+			return err
+		}
+		w.tx.OnCommit(func() { s.topics.published(topic) })
+		if err := b.Put(outboxKey(seq), raw); err != nil {
+			return err
+		}
+		return w.journaled(topicBucket(topic), seq)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// Subscribe streams the messages of topic, starting with the one numbered
+// fromSeq, or the oldest one after it if that has been trimmed: first
+// those already published, then new ones as they are published, in order.
+// The subscription goes on until cancel is called or the store is closed,
+// and then closes the channel; it also does so if reading the messages
+// fails.
+//
+// Messages received are not marked as processed in any way. Consumers keep
+// track of how far they have got with Ack, and subscribe again from after
+// their offset, see Offset, so that messages are delivered at least once:
+// those received but not yet acknowledged when a consumer stops are
+// delivered again. A subscription that falls behind TrimTopic skips the
+// messages trimmed before it got to them.
+//
+//	offset, err := store.Offset("orders", "mailer")
+//	msgs, cancel := store.Subscribe("orders", offset+1)
+//	defer cancel()
+//	for m := range msgs {
+//	    var o OrderPlaced
+//	    if err := m.Decode(&o); err == nil && send(o) == nil {
+//	        store.Ack("orders", "mailer", m.Seq)
+//	    }
+//	}
+func (s *Store) Subscribe(topic string, fromSeq uint64) (<-chan Message, func()) {
+	out := make(chan Message)
+	stop := make(chan struct{})
+	var once sync.Once
+	cancel := func() { once.Do(func() { close(stop) }) }
+	go func() {
+		defer close(out)
+		next := fromSeq
+		for {
+			// taken before reading, so that no publish in between is missed
+			changed := s.topics.waitFor(topic)
+			msgs, err := s.readTopic(topic, next)
+			if err != nil {
+				return
+			}
+			for _, m := range msgs {
+				select {
+				case out <- m:
+				case <-stop:
+					return
+				case <-s.done:
+					return
+				}
+				next = m.Seq + 1
+			}
+			if len(msgs) == topicBatch {
+				continue
+			}
+			select {
+			case <-changed:
+			case <-stop:
+				return
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return out, cancel
+}
+
+// readTopic reads up to topicBatch messages of topic, from the one
+// numbered from.
+func (s *Store) readTopic(topic string, from uint64) ([]Message, error) {
+	var msgs []Message
+	err := s.view(func(tx *bbolt.Tx) error {
+		b := s.aux(tx, topicBucket(topic))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Seek(outboxKey(from)); k != nil && len(msgs) < topicBatch; k, v = c.Next() {
+			msgs = append(msgs, Message{
+				Topic:   topic,
+				Seq:     binary.BigEndian.Uint64(k),
+				Payload: append([]byte(nil), v...),
+				s:       s,
+			})
+		}
+		return nil
+	})
+	return msgs, err
+}
+
+// Ack records that consumer has processed the messages of topic up to and
+// including seq. The offset only moves forward: acknowledging a message
+// before the recorded offset leaves it as it is.
+func (s *Store) Ack(topic, consumer string, seq uint64) error {
+	return s.update(func(w *wtx) error {
+		b, err := w.aux(offsetsBucket)
+		if err != nil {
+			return err
+		}
+		k := []byte(Key(topic, consumer))
+		if v := b.Get(k); len(v) == 8 && binary.BigEndian.Uint64(v) >= seq {
+			return nil
+		}
+		return b.Put(k, outboxKey(seq))
+	})
+}
+
+// Offset returns the sequence number of the last message of topic that
+// consumer has acknowledged with Ack, or 0 if it has acknowledged none.
+func (s *Store) Offset(topic, consumer string) (uint64, error) {
+	var seq uint64
+	err := s.view(func(tx *bbolt.Tx) error {
+		if b := s.aux(tx, offsetsBucket); b != nil {
+			if v := b.Get([]byte(Key(topic, consumer))); len(v) == 8 {
+				seq = binary.BigEndian.Uint64(v)
+			}
+		}
+		return nil
+	})
+	return seq, err
+}
+
+// TrimTopic deletes all but the newest keepLast messages of topic, and
+// returns how many it deleted. Subscriptions reading the topic meanwhile
+// carry on with the oldest message left.
+func (s *Store) TrimTopic(topic string, keepLast int) (int, error) {
+	n := 0
+	err := s.update(func(w *wtx) error {
+		n = 0
+		b := s.aux(w.tx, topicBucket(topic))
+		if b == nil {
+			return nil
+		}
+		total := b.Stats().KeyN
+		var keys [][]byte
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && len(keys) < total-keepLast; k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		n = len(keys)
+		return nil
+	})
+	return n, err
+}
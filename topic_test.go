@@ -0,0 +1,183 @@
+package bboltkv
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// receive reads n messages from msgs, failing the test if they do not
+// arrive in time, and returns their sequence numbers and payloads.
+func receive(t *testing.T, msgs <-chan Message, n int) []string {
+	t.Helper()
+	var got []string
+	for len(got) < n {
+		select {
+		case m, ok := <-msgs:
+			if !ok {
+				t.Fatalf("channel closed after %v", got)
+			}
+			var v string
+			if err := m.Decode(&v); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, fmt.Sprintf("%d=%s", m.Seq, v))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out after %v", got)
+		}
+	}
+	return got
+}
+
+func publishN(t *testing.T, db *Store, topic string, from, to int) {
+	t.Helper()
+	for i := from; i <= to; i++ {
+		seq, err := db.Publish(topic, fmt.Sprintf("m%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seq != uint64(i) {
+			t.Fatalf("Publish returned %d, expected %d", seq, i)
+		}
+	}
+}
+
+func TestSubscribeReplayAndLive(t *testing.T) {
+	db := openTestStore(t)
+	publishN(t, db, "t", 1, 5)
+	if _, err := db.Publish("other", "x"); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, cancel := db.Subscribe("t", 3)
+	defer cancel()
+	if got := fmt.Sprint(receive(t, msgs, 3)); got != "[3=m3 4=m4 5=m5]" {
+		t.Fatalf("replay: got %s", got)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 6; i <= 250; i++ {
+			if _, err := db.Publish("t", fmt.Sprintf("m%d", i)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	got := receive(t, msgs, 245)
+	<-done
+	for i, m := range got {
+		if want := fmt.Sprintf("%d=m%d", i+6, i+6); m != want {
+			t.Fatalf("live message %d: got %s, expected %s", i, m, want)
+		}
+	}
+	select {
+	case m := <-msgs:
+		t.Fatalf("unexpected message %d", m.Seq)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestTopicConsumers(t *testing.T) {
+	db := openTestStore(t)
+	publishN(t, db, "t", 1, 10)
+	if err := db.Ack("t", "a", 7); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ack("t", "b", 2); err != nil {
+		t.Fatal(err)
+	}
+	// offsets only move forward
+	if err := db.Ack("t", "a", 4); err != nil {
+		t.Fatal(err)
+	}
+	for consumer, want := range map[string]string{
+		"a": "[8=m8 9=m9 10=m10]",
+		"b": "[3=m3 4=m4 5=m5 6=m6 7=m7 8=m8 9=m9 10=m10]",
+		"c": "[1=m1 2=m2 3=m3 4=m4 5=m5 6=m6 7=m7 8=m8 9=m9 10=m10]",
+	} {
+		offset, err := db.Offset("t", consumer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs, cancel := db.Subscribe("t", offset+1)
+		got := fmt.Sprint(receive(t, msgs, 10-int(offset)))
+		cancel()
+		if got != want {
+			t.Fatalf("consumer %s from %d: got %s, expected %s", consumer, offset, got, want)
+		}
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	db := openTestStore(t)
+	publishN(t, db, "t", 1, 3)
+	msgs, cancel := db.Subscribe("t", 1)
+	receive(t, msgs, 1)
+	cancel()
+	cancel()
+	// the channel closes, after at most the message being handed over
+	closed := make(chan struct{})
+	go func() {
+		for range msgs {
+		}
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel not closed after cancel")
+	}
+
+	// closing the store ends subscriptions too
+	msgs, _ = db.Subscribe("t", 4)
+	db.Close()
+	select {
+	case _, ok := <-msgs:
+		if ok {
+			t.Fatal("message after Close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel not closed after Close")
+	}
+}
+
+func TestTrimTopic(t *testing.T) {
+	db := openTestStore(t)
+	publishN(t, db, "t", 1, 10)
+	msgs, cancel := db.Subscribe("t", 1)
+	defer cancel()
+	if got := fmt.Sprint(receive(t, msgs, 2)); got != "[1=m1 2=m2]" {
+		t.Fatalf("got %s", got)
+	}
+	if n, err := db.TrimTopic("t", 3); err != nil || n != 7 {
+		t.Fatalf("trimmed %d, %v, expected 7", n, err)
+	}
+	// the subscriber read ahead at most one batch, and then carries on
+	// with what is left, and with new messages
+	publishN(t, db, "t", 11, 12)
+	var last uint64
+	for last != 12 {
+		select {
+		case m := <-msgs:
+			if m.Seq <= last {
+				t.Fatalf("got %d after %d", m.Seq, last)
+			}
+			last = m.Seq
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out after %d", last)
+		}
+	}
+	// new subscribers only see what is left
+	msgs2, cancel2 := db.Subscribe("t", 1)
+	defer cancel2()
+	if got := fmt.Sprint(receive(t, msgs2, 5)); got != "[8=m8 9=m9 10=m10 11=m11 12=m12]" {
+		t.Fatalf("got %s", got)
+	}
+	if n, err := db.TrimTopic("t", 10); err != nil || n != 0 {
+		t.Fatalf("trimmed %d, %v, expected 0", n, err)
+	}
+	if n, err := db.TrimTopic("missing", 0); err != nil || n != 0 {
+		t.Fatalf("trimmed %d, %v, expected 0", n, err)
+	}
+}
@@ -0,0 +1,71 @@
+package bboltkv
+
+// Tracer receives a span for each traced operation of a store, see
+// WithTracer. StartSpan is called as the operation begins, with its name,
+// such as "Put", and the key it concerns, or "" if it concerns none, and
+// returns the function to call once the operation ends, with its result:
+// nil, ErrNotFound, or another error. The span lasts from one call to the
+// other. Both are called on the goroutine running the operation.
+//
+// The traced operations are Put, Get and Delete, and their Context
+// variants, ForEach, Keys, and Update, together with the reads and writes
+// made within it, see TxTracer. The bboltkv/otelkv package adapts an
+// OpenTelemetry tracer.
+type Tracer interface {
+	StartSpan(op, key string) func(err error)
+}
+
+// TxTracer is implemented by Tracers that nest the spans of the reads and
+// writes made within Update inside the span of their transaction. Update
+// then calls StartTx in place of StartSpan, and starts the spans of the
+// operations of its WriteTx, named after the BucketTx methods, with the
+// Tracer StartTx returns. Without it, they are started with StartSpan like
+// any other, while the span of Update is open.
+type TxTracer interface {
+	Tracer
+	StartTx(op string) (Tracer, func(err error))
+}
+
+// WithTracer makes the store report its operations to t, see Tracer.
+// Without a tracer, tracing costs nothing.
+func WithTracer(t Tracer) Option {
+	return func(o *options) {
+		o.tracer = t
+	}
+}
+
+// WithKeyRedaction makes the store pass keys through fn before handing
+// them to its Tracer, such as to leave out the parts that identify users.
+//
+//	bboltkv.WithKeyRedaction(func(key string) string {
+//	    if i := strings.IndexByte(key, ':'); i >= 0 {
+//	        return key[:i+1] + "*"
+//	    }
+//	    return key
+//	})
+func WithKeyRedaction(fn func(key string) string) Option {
+	return func(o *options) {
+		o.redactKey = fn
+	}
+}
+
+// startSpan starts a span of op on key with t, and returns the function
+// ending it with the error *err points to.
+func (s *Store) startSpan(t Tracer, op, key string) func(err *error) {
+	if key != "" && s.opts.redactKey != nil {
+		key = s.opts.redactKey(key)
+	}
+	end := t.StartSpan(op, key)
+	return func(err *error) { end(*err) }
+}
+
+// startTx starts the span of a transaction, and returns the Tracer for the
+// spans within it, and the function ending it.
+func (s *Store) startTx(op string) (Tracer, func(err *error)) {
+	t := s.opts.tracer
+	if tt, ok := t.(TxTracer); ok {
+		child, end := tt.StartTx(op)
+		return child, func(err *error) { end(*err) }
+	}
+	return t, s.startSpan(t, op, "")
+}
@@ -0,0 +1,184 @@
+package bboltkv
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type recordedSpan struct {
+	op, key string
+	parent  string // op of the transaction span it is within, if any
+	err     error
+	ended   bool
+}
+
+// recordingTracer records the spans it is handed.
+type recordingTracer struct {
+	mu     sync.Mutex
+	spans  []*recordedSpan
+	parent string
+}
+
+func (r *recordingTracer) StartSpan(op, key string) func(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sp := &recordedSpan{op: op, key: key, parent: r.parent}
+	r.spans = append(r.spans, sp)
+	return func(err error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		sp.err, sp.ended = err, true
+	}
+}
+
+// txRecordingTracer is a recordingTracer that nests spans.
+type txRecordingTracer struct {
+	*recordingTracer
+}
+
+func (r txRecordingTracer) StartTx(op string) (Tracer, func(err error)) {
+	end := r.StartSpan(op, "")
+	return &recordingTracer{parent: op}, end
+}
+
+func (r *recordingTracer) ops() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ops []string
+	for _, sp := range r.spans {
+		if !sp.ended {
+			ops = append(ops, sp.op+" (open)")
+			continue
+		}
+		ops = append(ops, sp.op+" "+sp.key)
+	}
+	return ops
+}
+
+func TestTracer(t *testing.T) {
+	rec := &recordingTracer{}
+	db := openTestStore(t, WithTracer(rec))
+	if err := db.Put("user:1", "ann"); err != nil {
+		t.Fatal(err)
+	}
+	var v string
+	if err := db.Get("user:1", &v); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get("user:2", &v); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	var n int
+	if err := db.Get("user:1", &n); err == nil {
+		t.Fatal("decoded a string into an int")
+	}
+	if err := db.Delete("user:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ForEach(func(string, func(interface{}) error) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Keys(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Put user:1", "Get user:1", "Get user:2", "Get user:1", "Delete user:1", "ForEach ", "Keys "}
+	if got := rec.ops(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("got spans %q, expected %q", got, want)
+	}
+	// not finding a key is told apart from failing
+	if err := rec.spans[1].err; err != nil {
+		t.Fatalf("Get ended with %v", err)
+	}
+	if err := rec.spans[2].err; err != ErrNotFound {
+		t.Fatalf("Get of a missing key ended with %v", err)
+	}
+	if err := rec.spans[3].err; err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("failed Get ended with %v", err)
+	}
+}
+
+func TestTracerRedaction(t *testing.T) {
+	rec := &recordingTracer{}
+	db := openTestStore(t, WithTracer(rec), WithKeyRedaction(func(key string) string {
+		if i := strings.IndexByte(key, ':'); i >= 0 {
+			return key[:i+1] + "*"
+		}
+		return key
+	}))
+	if err := db.Put("user:ann@example.com", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.ops(); len(got) != 1 || got[0] != "Put user:*" {
+		t.Fatalf("got spans %q", got)
+	}
+}
+
+func TestTracerUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	rec := &recordingTracer{}
+	pending, err := OpenShared(path, "pending", WithTracer(txRecordingTracer{rec}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pending.Close()
+	done, err := OpenShared(path, "done")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done.Close()
+	if err := pending.Put("job:1", "resize"); err != nil {
+		t.Fatal(err)
+	}
+
+	var child *recordingTracer
+	err = pending.Update(func(tx *WriteTx) error {
+		child = tx.tracer.(*recordingTracer)
+		// the span of the transaction is open meanwhile
+		if got := rec.ops(); got[len(got)-1] != "Update (open)" {
+			t.Errorf("got spans %q", got)
+		}
+		var job string
+		if err := tx.InBucket("pending").Get("job:1", &job); err != nil {
+			return err
+		}
+		if err := tx.InBucket("pending").Delete("job:1"); err != nil {
+			return err
+		}
+		if err := tx.InBucket("done").Get("job:1", nil); err != ErrNotFound {
+			return err
+		}
+		return tx.InBucket("done").Put("job:1", job)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.ops(); len(got) != 2 || got[1] != "Update " {
+		t.Fatalf("got spans %q", got)
+	}
+	want := []string{"Get job:1", "Delete job:1", "Get job:1", "Put job:1"}
+	if got := child.ops(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("got child spans %q, expected %q", got, want)
+	}
+	for _, sp := range child.spans {
+		if sp.parent != "Update" {
+			t.Fatalf("%s not within Update", sp.op)
+		}
+	}
+
+	// without TxTracer, the spans follow each other
+	flat := &recordingTracer{}
+	pending.opts.tracer = flat
+	err = pending.Update(func(tx *WriteTx) error {
+		return tx.InBucket("pending").Put("job:2", "crop")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"Update ", "Put job:2"}
+	if got := flat.ops(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("got spans %q, expected %q", got, want)
+	}
+}
@@ -0,0 +1,301 @@
+package bboltkv
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// ValueTransform is a reversible transformation of encoded values, such as
+// compression or encryption. Transforms are enabled with options when the
+// store is opened, and are applied to every value the store encodes. Each
+// stored value records the tags of the transforms it went through in its
+// envelope, see Envelope, so
+// values written before a transform was enabled, or while it was disabled,
+// keep decoding as long as the transforms they did use are still known.
+//
+// Tags below 16 are reserved for the transforms of this package.
+type ValueTransform interface {
+	// Tag identifies the transform in stored values. It must not change
+	// once values have been written with it.
+	Tag() byte
+
+	// Apply transforms an encoded value. It must not modify data.
+	Apply(data []byte) ([]byte, error)
+
+	// Reverse undoes Apply. It must not modify data.
+	Reverse(data []byte) ([]byte, error)
+}
+
+// ErrUnknownTransform is wrapped by the error returned when a value was
+// stored using a transform that the store was not opened with.
+var ErrUnknownTransform = errors.New("bboltkv: value uses an unknown transform")
+
+const (
+	transformCompression     byte = 1
+	transformEncryption      byte = 2
+	transformChecksum        byte = 3
+	transformDictCompression byte = 4
+	transformReserved        byte = 16
+)
+
+// WithCompression compresses values with DEFLATE before storing them.
+// Stores opened without this option still read compressed values.
+func WithCompression() Option {
+	return func(o *options) {
+		o.compression = true
+	}
+}
+
+// WithEncryption encrypts values with AES-GCM before storing them. The key
+// must be 16, 24 or 32 bytes long, to select AES-128, AES-192 or AES-256;
+// otherwise Open fails. Only values are encrypted, not keys, unless
+// WithEncryptedKeys is given too. Values stored without encryption remain
+// readable, while encrypted values cannot be read by stores opened without
+// the key.
+func WithEncryption(key []byte) Option {
+	return func(o *options) {
+		o.encryptionKey = append([]byte(nil), key...)
+	}
+}
+
+// WithChecksums stores a CRC-32C checksum with every value, and verifies it
+// when the value is read. A mismatch is reported as an error wrapping
+// ErrCorrupt. Stores opened without this option still verify the checksums
+// of values that have them.
+func WithChecksums() Option {
+	return func(o *options) {
+		o.checksums = true
+	}
+}
+
+// WithTransform adds a transform of your own, applied after compression and
+// before encryption. The option can be given several times; transforms then
+// run in the order given. Open fails if a tag is reserved or used twice.
+func WithTransform(t ValueTransform) Option {
+	return func(o *options) {
+		o.transforms = append(o.transforms, t)
+	}
+}
+
+// pipeline applies a store's transforms in a fixed order: compression,
+// WithTransform transforms, encryption, and checksums last, so that they
+// cover the bytes as stored. Once a dictionary has been trained, see
+// TrainCompressionDict, compression uses it, whether WithCompression was
+// given or not. The tags of the transforms applied are listed in the
+// value's envelope, see Envelope.
+//
+// Values written before envelopes existed nest their transforms instead:
+// such a value holds tagTransformed, the transform's tag, and the output of
+// Apply on the value before it, so reversing peels transforms off from the
+// outside in.
+type pipeline struct {
+	apply    []ValueTransform
+	reverse  map[byte]ValueTransform
+	typeInfo bool
+	dicts    *dictionaries
+}
+
+func newPipeline(o options) (*pipeline, error) {
+	dicts := newDictionaries()
+	p := &pipeline{typeInfo: o.typeInfo, dicts: dicts, reverse: map[byte]ValueTransform{
+		transformCompression:     compression{},
+		transformChecksum:        checksum{},
+		transformDictCompression: dictCompression{dicts},
+	}}
+	if o.compression {
+		p.apply = append(p.apply, compression{})
+	}
+	for _, t := range o.transforms {
+		tag := t.Tag()
+		if tag < transformReserved {
+			return nil, fmt.Errorf("bboltkv: transform tag %d is reserved", tag)
+		} else if p.reverse[tag] != nil {
+			return nil, fmt.Errorf("bboltkv: transform tag %d is used twice", tag)
+		}
+		p.reverse[tag] = t
+		p.apply = append(p.apply, t)
+	}
+	if o.encryptionKey != nil {
+		block, err := aes.NewCipher(o.encryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("bboltkv: encryption key: %w", err)
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		p.reverse[transformEncryption] = encryption{gcm}
+		p.apply = append(p.apply, encryption{gcm})
+	}
+	if o.checksums {
+		p.apply = append(p.apply, checksum{})
+	}
+	return p, nil
+}
+
+// transform applies all transforms to the encoding of value, and wraps the
+// result in an envelope if there is any metadata to record.
+func (p *pipeline) transform(raw []byte, value interface{}) ([]byte, error) {
+	name := ""
+	if p.typeInfo {
+		name = typeName(value)
+	}
+	return p.transformNamed(raw, name)
+}
+
+// transformNamed is transform for a value of the type with the given name,
+// which may be empty.
+func (p *pipeline) transformNamed(raw []byte, name string) ([]byte, error) {
+	dict := p.dicts.current() != 0
+	if len(p.apply) == 0 && name == "" && !dict {
+		return raw, nil
+	}
+	e := Envelope{TypeName: name}
+	apply := p.apply
+	if dict {
+		var err error
+		if raw, err = (dictCompression{p.dicts}).Apply(raw); err != nil {
+			return nil, err
+		}
+		e.Transforms = append(e.Transforms, transformDictCompression)
+		if len(apply) > 0 && apply[0].Tag() == transformCompression {
+			apply = apply[1:]
+		}
+	}
+	for _, t := range apply {
+		var err error
+		if raw, err = t.Apply(raw); err != nil {
+			return nil, err
+		}
+		e.Transforms = append(e.Transforms, t.Tag())
+	}
+	return encodeEnvelope(e, raw), nil
+}
+
+// untransform unwraps a stored value's envelope, and reverses the
+// transforms it went through.
+func (p *pipeline) untransform(raw []byte) ([]byte, error) {
+	if len(raw) > 0 && raw[0] == tagEnvelope {
+		e, payload, err := DecodeEnvelope(raw)
+		if err != nil {
+			return nil, err
+		}
+		for i := len(e.Transforms) - 1; i >= 0; i-- {
+			t := p.reverse[e.Transforms[i]]
+			if t == nil {
+				return nil, fmt.Errorf("%w (tag %d)", ErrUnknownTransform, e.Transforms[i])
+			}
+			if payload, err = t.Reverse(payload); err != nil {
+				return nil, err
+			}
+		}
+		return payload, nil
+	}
+	for len(raw) > 0 && raw[0] == tagTransformed {
+		if len(raw) < 2 {
+			return nil, fmt.Errorf("%w: truncated transform header", ErrCorrupt)
+		}
+		t := p.reverse[raw[1]]
+		if t == nil {
+			return nil, fmt.Errorf("%w (tag %d)", ErrUnknownTransform, raw[1])
+		}
+		var err error
+		if raw, err = t.Reverse(raw[2:]); err != nil {
+			return nil, err
+		}
+	}
+	return raw, nil
+}
+
+// compression compresses with DEFLATE at the default level.
+type compression struct{}
+
+var flateWriters = sync.Pool{New: func() interface{} {
+	w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+	return w
+}}
+
+func (compression) Tag() byte { return transformCompression }
+
+func (compression) Apply(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	} else if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (compression) Reverse(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	out, err := ioutil.ReadAll(r)
+	if _, ok := err.(flate.CorruptInputError); ok || err == io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return out, err
+}
+
+// encryption encrypts with AES-GCM, storing the random nonce in front of
+// the sealed value.
+type encryption struct {
+	gcm cipher.AEAD
+}
+
+func (encryption) Tag() byte { return transformEncryption }
+
+func (e encryption) Apply(data []byte) ([]byte, error) {
+	nonce := make([]byte, e.gcm.NonceSize(), e.gcm.NonceSize()+len(data)+e.gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return e.gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+func (e encryption) Reverse(data []byte) ([]byte, error) {
+	n := e.gcm.NonceSize()
+	if len(data) < n {
+		return nil, fmt.Errorf("%w: truncated encrypted value", ErrCorrupt)
+	}
+	out, err := e.gcm.Open(nil, data[:n], data[n:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("bboltkv: cannot decrypt value: %w", err)
+	}
+	return out, nil
+}
+
+// checksum stores the CRC-32C of the value in front of it.
+type checksum struct{}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func (checksum) Tag() byte { return transformChecksum }
+
+func (checksum) Apply(data []byte) ([]byte, error) {
+	out := make([]byte, 4, 4+len(data))
+	binary.BigEndian.PutUint32(out, crc32.Checksum(data, castagnoli))
+	return append(out, data...), nil
+}
+
+func (checksum) Reverse(data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("%w: truncated checksum", ErrCorrupt)
+	} else if binary.BigEndian.Uint32(data) != crc32.Checksum(data[4:], castagnoli) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+	return data[4:], nil
+}
@@ -0,0 +1,219 @@
+package bboltkv
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+var testKey = []byte("0123456789abcdef")
+
+// xorTransform is a transform of the kind a user might register.
+type xorTransform byte
+
+func (x xorTransform) Tag() byte { return 0x42 }
+
+func (x xorTransform) Apply(data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[i] = b ^ byte(x)
+	}
+	return out, nil
+}
+
+func (x xorTransform) Reverse(data []byte) ([]byte, error) { return x.Apply(data) }
+
+func TestTransformCombinations(t *testing.T) {
+	value := strings.Repeat("compressible ", 100)
+	for mask := 0; mask < 8; mask++ {
+		var opts []Option
+		var tags []byte
+		if mask&1 != 0 {
+			opts = append(opts, WithCompression())
+			tags = append(tags, transformCompression)
+		}
+		if mask&2 != 0 {
+			opts = append(opts, WithEncryption(testKey))
+			tags = append(tags, transformEncryption)
+		}
+		if mask&4 != 0 {
+			opts = append(opts, WithChecksums())
+			tags = append(tags, transformChecksum)
+		}
+		db := openTestStore(t, opts...)
+		if err := db.Put("key", value); err != nil {
+			t.Fatal(err)
+		}
+		var got string
+		if err := db.Get("key", &got); err != nil || got != value {
+			t.Fatalf("mask %d: got %d bytes, %v", mask, len(got), err)
+		}
+		if v, err := db.GetAny("key"); err != nil || v != value {
+			t.Fatalf("mask %d: GetAny returned %v", mask, err)
+		}
+
+		// the envelope lists the transforms in the fixed order
+		e, _, err := DecodeEnvelope(rawValue(t, db, "key"))
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(e.Transforms, tags) {
+			t.Fatalf("mask %d: got transforms %v, expected %v", mask, e.Transforms, tags)
+		}
+		if mask&1 != 0 && len(rawValue(t, db, "key")) > len(value)/4 {
+			t.Fatalf("mask %d: value was not compressed", mask)
+		}
+		if mask&2 != 0 && bytes.Contains(rawValue(t, db, "key"), []byte("compressible")) {
+			t.Fatalf("mask %d: value was not encrypted", mask)
+		}
+		// values are compared before they are transformed
+		if err := db.CompareAndPut("key", value, "new"); err != nil {
+			t.Fatalf("mask %d: %v", mask, err)
+		}
+	}
+}
+
+func rawValue(t *testing.T, db *Store, key string) []byte {
+	t.Helper()
+	var raw []byte
+	err := db.db.View(func(tx *bbolt.Tx) error {
+		raw = append(raw, tx.Bucket(db.bucketName).Get([]byte(key))...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestTransformMixedValues(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.db")
+	reopen := func(opts ...Option) *Store {
+		db, err := Open(name, "test", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return db
+	}
+	db := reopen()
+	if err := db.Put("legacy", 1); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// adding transforms to an existing store
+	db = reopen(WithCompression(), WithTransform(xorTransform(0x5a)), WithChecksums())
+	if err := db.Put("transformed", 2); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]int{"legacy": 1, "transformed": 2} {
+		var got int
+		if err := db.Get(key, &got); err != nil || got != want {
+			t.Fatalf("%s: got %d, %v", key, got, err)
+		}
+	}
+	db.Close()
+
+	// built-in transforms are always known, but not the custom one
+	db = reopen()
+	defer db.Close()
+	var got int
+	if err := db.Get("legacy", &got); err != nil || got != 1 {
+		t.Fatalf("got %d, %v", got, err)
+	}
+	if err := db.Get("transformed", &got); !errors.Is(err, ErrUnknownTransform) {
+		t.Fatalf("got %v, expected ErrUnknownTransform", err)
+	}
+	if _, err := db.GetAny("transformed"); !errors.Is(err, ErrUnknownTransform) {
+		t.Fatalf("got %v, expected ErrUnknownTransform", err)
+	}
+}
+
+func TestTransformEncryptionKey(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(name, "test", WithEncryption(testKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = Open(name, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get("key", new(string)); !errors.Is(err, ErrUnknownTransform) {
+		t.Fatalf("got %v, expected ErrUnknownTransform", err)
+	}
+	db.Close()
+
+	db, err = Open(name, "test", WithEncryption([]byte("fedcba9876543210")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get("key", new(string)); err == nil {
+		t.Fatal("value decrypted with the wrong key")
+	}
+	db.Close()
+
+	if _, err := Open(name, "test", WithEncryption([]byte("short"))); err == nil {
+		t.Fatal("short key was accepted")
+	}
+}
+
+func TestTransformChecksumMismatch(t *testing.T) {
+	db := openTestStore(t, WithChecksums())
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	raw := rawValue(t, db, "key")
+	raw[len(raw)-1] ^= 1
+	if err := db.PutEncoded("key", raw); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get("key", new(string)); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("got %v, expected ErrCorrupt", err)
+	}
+}
+
+func TestTransformBadTags(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.db")
+	if _, err := Open(name, "test", WithTransform(reservedTransform{})); err == nil {
+		t.Fatal("reserved tag was accepted")
+	}
+	if _, err := Open(name, "test", WithTransform(xorTransform(1)), WithTransform(xorTransform(2))); err == nil {
+		t.Fatal("duplicate tag was accepted")
+	}
+}
+
+type reservedTransform struct{ xorTransform }
+
+func (reservedTransform) Tag() byte { return transformChecksum }
+
+func TestTransformNested(t *testing.T) {
+	// values written before envelopes nest their transforms
+	db := openTestStore(t, WithEncryption(testKey))
+	raw, err := db.encodePlain("value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tr := range []ValueTransform{compression{}, db.pipeline.reverse[transformEncryption], checksum{}} {
+		out, err := tr.Apply(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw = append([]byte{tagTransformed, tr.Tag()}, out...)
+	}
+	if err := db.PutEncoded("key", raw); err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	if err := db.Get("key", &got); err != nil || got != "value" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,429 @@
+package bboltkv
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Expiry times are kept in two internal buckets: "expiry" maps each key with
+// a TTL to its expiry time, and "expiry-index" holds the same pairs keyed by
+// expiry time followed by the key, so that keys can be visited in the order
+// they expire. Times are stored as big-endian Unix nanoseconds. Writing or
+// deleting a key through wtx clears its expiry, so both buckets only ever
+// describe live keys.
+const (
+	expiryBucket      = "expiry"
+	expiryIndexBucket = "expiry-index"
+)
+
+// sweepBatch is the number of expired keys deleted per transaction by
+// SweepExpired, unless WithAdaptiveBatching sizes its batches.
+const sweepBatch = 1000
+
+// WithTTLJitter makes the TTLs given with PutWithTTL, PutAllWithTTL and
+// the TTL policies of SetTTLPolicy longer or shorter by a random amount of
+// up to fraction of them, such as 0.1 for ±10%, so that entries written
+// with the same TTL at about the same time do not all expire at once. TTL
+// reports the TTL with the jitter applied. Jitter never makes a TTL zero
+// or negative. PutWithTTLJitter overrides the fraction for a single write;
+// Expire is not jittered. A fraction of 0, the default, leaves TTLs
+// exact, fractions above 1 count as 1 and negative ones as 0.
+func WithTTLJitter(fraction float64) Option {
+	if fraction > 1 {
+		fraction = 1
+	} else if fraction < 0 {
+		fraction = 0
+	}
+	return func(o *options) {
+		o.ttlJitter = fraction
+	}
+}
+
+// jittered returns ttl, longer or shorter by a random amount of up to
+// fraction of it, but at least 1ns.
+func jittered(ttl time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return ttl
+	}
+	ttl += time.Duration(float64(ttl) * fraction * (2*rand.Float64() - 1))
+	if ttl < 1 {
+		ttl = 1
+	}
+	return ttl
+}
+
+// WithExpirySweep makes the store delete expired keys in the background,
+// every interval, as SweepExpired does, along with the entries whose grace
+// period given with ScheduleDelete has passed, as ReapScheduledDeletes
+// does. Without it, expired keys are only removed by calling SweepExpired,
+// though reads treat them as absent either way.
+func WithExpirySweep(interval time.Duration) Option {
+	return func(o *options) {
+		o.sweepInterval = interval
+	}
+}
+
+func encodeExpiry(t time.Time) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(t.UnixNano()))
+	return b
+}
+
+func decodeExpiry(b []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b)))
+}
+
+func expiryIndexKey(at []byte, key string) []byte {
+	return append(append([]byte(nil), at...), key...)
+}
+
+// expiresAt returns the expiry time of key, if it has one.
+func (s *Store) expiresAt(tx *bbolt.Tx, key string) (time.Time, bool) {
+	b := s.aux(tx, expiryBucket)
+	if b == nil {
+		return time.Time{}, false
+	}
+	at := b.Get([]byte(key))
+	if at == nil {
+		return time.Time{}, false
+	}
+	return decodeExpiry(at), true
+}
+
+// expired reports whether key has a TTL that has run out, or is hidden
+// by ScheduleDelete.
+func (s *Store) expired(tx *bbolt.Tx, key string) bool {
+	if at, ok := s.expiresAt(tx, key); ok && !s.now().Before(at) {
+		return true
+	}
+	_, scheduled := s.scheduledAt(tx, key)
+	return scheduled
+}
+
+// dropExpiry clears the expiry time of key, if it has one.
+func (w *wtx) dropExpiry(key string) error {
+	b := w.s.aux(w.tx, expiryBucket)
+	if b == nil {
+		return nil
+	}
+	at := b.Get([]byte(key))
+	if at == nil {
+		return nil
+	}
+	if err := w.s.aux(w.tx, expiryIndexBucket).Delete(expiryIndexKey(at, key)); err != nil {
+		return err
+	}
+	w.stale = append(w.stale, key)
+	return b.Delete([]byte(key))
+}
+
+// setExpiry makes key expire at the given time, replacing any expiry time it
+// had.
+func (w *wtx) setExpiry(key string, at time.Time) error {
+	if w.s.protected(key) {
+		return ErrProtected
+	}
+	if err := w.checkMutable(key); err != nil {
+		return err
+	}
+	if err := w.dropExpiry(key); err != nil {
+		return err
+	}
+	b, err := w.aux(expiryBucket)
+	if err != nil {
+		return err
+	}
+	index, err := w.aux(expiryIndexBucket)
+	if err != nil {
+		return err
+	}
+	raw := encodeExpiry(at)
+	if err := index.Put(expiryIndexKey(raw, key), nil); err != nil {
+		return err
+	}
+	w.stale = append(w.stale, key)
+	return b.Put([]byte(key), raw)
+}
+
+// PutWithTTL puts an entry into the store like Put, and makes it expire
+// after ttl has passed, according to the store's clock. Once expired, the
+// entry reads as absent, and is deleted by the next sweep, see
+// SweepExpired. Putting the key again without a TTL makes it permanent,
+// unless a policy set with SetTTLPolicy gives it one.
+func (s *Store) PutWithTTL(key string, value interface{}, ttl time.Duration) error {
+	return s.PutWithTTLJitter(key, value, ttl, s.opts.ttlJitter)
+}
+
+// PutWithTTLJitter is PutWithTTL, with a jitter of its own in place of the
+// store's, see WithTTLJitter: the TTL is made longer or shorter by a random
+// amount of up to jitter of it, or left exact if jitter is 0. A jitter
+// outside of [0, 1] fails with ErrBadValue.
+//
+//	err := store.PutWithTTLJitter("cache:"+url, resp, 10*time.Minute, 0.2)
+func (s *Store) PutWithTTLJitter(key string, value interface{}, ttl time.Duration, jitter float64) error {
+	if ttl <= 0 || jitter < 0 || jitter > 1 {
+		return ErrBadValue
+	}
+	raw, err := s.encodeForPut(key, value)
+	if err != nil {
+		return err
+	}
+	if err := s.limitWrite(context.Background(), key); err != nil {
+		return err
+	}
+	plain := key
+	key = s.sealKey(key)
+	return s.update(s.named("PutWithTTL", plain, func(w *wtx) error {
+		if err := w.put(key, raw); err != nil {
+			return err
+		}
+		return w.setExpiry(key, s.now().Add(jittered(ttl, jitter)))
+	}))
+}
+
+// TTLEntry is an entry for PutAllWithTTL: a value to store under Key, which
+// expires after TTL has passed, or never if TTL is 0.
+type TTLEntry struct {
+	Key   string
+	Value interface{}
+	TTL   time.Duration
+}
+
+// PutAllWithTTL stores all the given entries in a single transaction, like
+// PutAll, and makes each expire after its TTL, like PutWithTTL. Entries with
+// a TTL of 0 are stored without one of their own, as with Put, so one batch
+// can mix both, and get that of their TTL policy, if any. If a key is given
+// more than once, the last entry for it wins, TTL included. A negative TTL
+// fails the batch with ErrBadValue, and nothing is written.
+//
+//	err := store.PutAllWithTTL([]bboltkv.TTLEntry{
+//	    {Key: "session:1", Value: s1, TTL: time.Hour},
+//	    {Key: "user:1", Value: u1},
+//	})
+func (s *Store) PutAllWithTTL(entries []TTLEntry) error {
+	type ttlRaw struct {
+		key string
+		raw []byte
+		ttl time.Duration
+	}
+	stored := make([]ttlRaw, len(entries))
+	for i, e := range entries {
+		if e.TTL < 0 {
+			return ErrBadValue
+		}
+		raw, err := s.encodeForPut(e.Key, e.Value)
+		if err != nil {
+			return err
+		}
+		stored[i] = ttlRaw{s.sealKey(e.Key), raw, e.TTL}
+	}
+	// in key order, as in PutAll, keeping the last entry of a key last
+	sort.SliceStable(stored, func(i, j int) bool { return stored[i].key < stored[j].key })
+	return s.update(func(w *wtx) error {
+		now := s.now()
+		for _, e := range stored {
+			// put drops any expiry the key had, so only the last entry's
+			// stays in the index
+			if err := w.put(e.key, e.raw); err != nil {
+				return err
+			}
+			if e.ttl > 0 {
+				if err := w.setExpiry(e.key, now.Add(jittered(e.ttl, s.opts.ttlJitter))); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Expire makes the entry with the given key expire after ttl has passed,
+// replacing any TTL it had. If no such key is present in the store, it
+// returns ErrNotFound.
+func (s *Store) Expire(key string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrBadValue
+	}
+	key = s.sealKey(key)
+	return s.update(func(w *wtx) error {
+		if w.get(key) == nil {
+			return ErrNotFound
+		}
+		return w.setExpiry(key, s.now().Add(ttl))
+	})
+}
+
+// Persist removes the TTL of the entry with the given key, if it has one,
+// so that it no longer expires. If no such key is present in the store, it
+// returns ErrNotFound.
+func (s *Store) Persist(key string) error {
+	key = s.sealKey(key)
+	return s.update(func(w *wtx) error {
+		if w.get(key) == nil {
+			return ErrNotFound
+		}
+		return w.dropExpiry(key)
+	})
+}
+
+// TTL returns the time left until the entry with the given key expires, or
+// 0 if it does not expire. If no such key is present in the store, it
+// returns ErrNotFound.
+func (s *Store) TTL(key string) (time.Duration, error) {
+	var ttl time.Duration
+	key = s.sealKey(key)
+	err := s.view(func(tx *bbolt.Tx) error {
+		if tx.Bucket(s.bucketName).Get([]byte(key)) == nil {
+			return ErrNotFound
+		}
+		if _, ok := s.scheduledAt(tx, key); ok {
+			return ErrNotFound
+		}
+		at, ok := s.expiresAt(tx, key)
+		if !ok {
+			return nil
+		}
+		if ttl = at.Sub(s.now()); ttl <= 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+	return ttl, err
+}
+
+// ExpiringBefore calls fn for every key that expires before t, in the order
+// they expire, with the time they expire at. This includes keys that have
+// already expired but have not been swept yet. Returning an error from fn
+// stops the iteration and returns that error.
+//
+//	soon := time.Now().Add(time.Hour)
+//	err := store.ExpiringBefore(soon, func(key string, expiresAt time.Time) error {
+//	    log.Printf("%s expires at %v", key, expiresAt)
+//	    return nil
+//	})
+func (s *Store) ExpiringBefore(t time.Time, fn func(key string, expiresAt time.Time) error) error {
+	return s.view(func(tx *bbolt.Tx) error {
+		index := s.aux(tx, expiryIndexBucket)
+		if index == nil {
+			return nil
+		}
+		end := encodeExpiry(t)
+		c := index.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k[:8], end) < 0; k, _ = c.Next() {
+			key, err := s.openKey(k[8:])
+			if err != nil {
+				return err
+			}
+			if err := fn(key, decodeExpiry(k[:8])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CountExpiring returns the number of keys that expire within window from
+// now, including those that have already expired but have not been swept
+// yet.
+func (s *Store) CountExpiring(window time.Duration) (int, error) {
+	n := 0
+	err := s.ExpiringBefore(s.now().Add(window), func(string, time.Time) error {
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// SweepExpired deletes all expired keys and returns how many it deleted.
+// It visits the keys in expiry order and stops at the first one that has
+// not expired, so its cost grows with the number of expired keys, not the
+// size of the store.
+func (s *Store) SweepExpired() (int, error) {
+	b := s.newBatcher(sweepBatch, nil)
+	total := 0
+	for {
+		s.yieldWrites()
+		n, size := 0, b.size
+		start := s.now()
+		err := s.update(func(w *wtx) error {
+			index := s.aux(w.tx, expiryIndexBucket)
+			if index == nil {
+				return nil
+			}
+			now := encodeExpiry(s.now())
+			var keys []string
+			c := index.Cursor()
+			for k, _ := c.First(); k != nil && len(keys) < size; k, _ = c.Next() {
+				atomic.AddInt64(&s.sweepSteps, 1)
+				if bytes.Compare(k[:8], now) > 0 {
+					break
+				}
+				keys = append(keys, string(k[8:]))
+			}
+			for _, key := range keys {
+				if err := w.delete(key); err != nil {
+					return err
+				}
+			}
+			n = len(keys)
+			return nil
+		})
+		if err == nil {
+			b.done(n, s.now().Sub(start))
+		}
+		total += n
+		if err != nil || n < size {
+			return total, err
+		}
+	}
+}
+
+// sweepLoop runs SweepExpired every sweep interval.
+func (s *Store) sweepLoop(done <-chan struct{}) {
+	for {
+		select {
+		case <-s.clock().After(s.opts.sweepInterval):
+		case <-done:
+			return
+		}
+		s.SweepExpired()
+		s.ReapScheduledDeletes()
+	}
+}
+
+// checkExpiry is the Fsck check of the expiry buckets.
+func checkExpiry(f *fsck) {
+	f.checkTimeIndex(expiryBucket, expiryIndexBucket, false)
+	b := f.s.aux(f.tx, expiryBucket)
+	if b == nil {
+		return
+	}
+	now := f.s.now()
+	c := b.Cursor()
+	for k, at := c.First(); k != nil; k, at = c.Next() {
+		if !f.present(k) {
+			dropIndex, dropExpiry := deleteFix(expiryIndexBucket, expiryIndexKey(at, string(k))), deleteFix(expiryBucket, k)
+			f.report(FsckOrphanMetadata, expiryBucket, k, "expiry of an absent key", func(w *wtx) error {
+				if err := dropIndex(w); err != nil {
+					return err
+				}
+				return dropExpiry(w)
+			})
+		} else if !now.Before(decodeExpiry(at)) {
+			key := string(k)
+			f.report(FsckExpired, expiryBucket, k, "expired at "+decodeExpiry(at).UTC().Format(time.RFC3339Nano), func(w *wtx) error {
+				if w.b.Get([]byte(key)) == nil {
+					return nil
+				}
+				return w.delete(key)
+			})
+		}
+	}
+}
@@ -0,0 +1,480 @@
+package bboltkv
+
+import (
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/unknownnf/bboltkv/testutil"
+)
+
+// expiring returns the keys in the expiry index, in expiry order.
+func expiring(t *testing.T, db *Store) []string {
+	t.Helper()
+	var keys []string
+	err := db.ExpiringBefore(time.Unix(1<<32, 0), func(key string, _ time.Time) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestTTL(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock), WithReadCache(10))
+	if err := db.PutWithTTL("key", "value", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, err := db.TTL("key"); err != nil || ttl != time.Minute {
+		t.Fatalf("got %v, %v, expected 1m", ttl, err)
+	}
+	var val string
+	if err := db.Get("key", &val); err != nil || val != "value" {
+		t.Fatalf("got %q, %v", val, err)
+	}
+	clock.Advance(time.Minute)
+	if err := db.Get("key", &val); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if _, err := db.TTL("key"); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if err := db.Delete("key"); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if n, err := db.SweepExpired(); err != nil || n != 1 {
+		t.Fatalf("swept %d, %v, expected 1", n, err)
+	}
+
+	if err := db.Expire("missing", time.Minute); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if err := db.PutWithTTL("key", "value", 0); err != ErrBadValue {
+		t.Fatalf("got %v, expected ErrBadValue", err)
+	}
+}
+
+func TestExpiryIndexConsistency(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock))
+	check := func(want ...string) {
+		t.Helper()
+		if got := expiring(t, db); !reflect.DeepEqual(got, want) {
+			t.Fatalf("got index %v, expected %v", got, want)
+		}
+	}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if err := db.PutWithTTL(key, key, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Second)
+	}
+	check("a", "b", "c", "d")
+
+	// changing the TTL moves the key
+	if err := db.Expire("a", 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	check("b", "c", "d", "a")
+	// so does putting it again with a TTL
+	if err := db.PutWithTTL("b", "b", 3*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	check("c", "d", "a", "b")
+	// overwriting without a TTL, deleting and persisting remove it
+	if err := db.Put("c", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("d"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Persist("a"); err != nil {
+		t.Fatal(err)
+	}
+	check("b")
+	if ttl, err := db.TTL("a"); err != nil || ttl != 0 {
+		t.Fatalf("got %v, %v, expected no TTL", ttl, err)
+	}
+	if err := db.Persist("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.DeleteGetRaw("b"); err != nil {
+		t.Fatal(err)
+	}
+	check()
+	if n, err := db.SweepExpired(); err != nil || n != 0 {
+		t.Fatalf("swept %d, %v, expected 0", n, err)
+	}
+}
+
+func TestExpiringBefore(t *testing.T) {
+	start := time.Now()
+	clock := testutil.NewFakeClock(start)
+	db := openTestStore(t, WithClock(clock))
+	for i, minutes := range []int{30, 10, 50, 20, 40} {
+		if err := db.PutWithTTL(fmt.Sprintf("key%d", i), i, time.Duration(minutes)*time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var keys []string
+	var last time.Time
+	err := db.ExpiringBefore(start.Add(45*time.Minute), func(key string, at time.Time) error {
+		if at.Before(last) {
+			t.Errorf("%s out of order", key)
+		}
+		last = at
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"key1", "key3", "key0", "key4"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("got %v, expected %v", keys, want)
+	}
+	if !last.Equal(start.Add(40 * time.Minute)) {
+		t.Fatalf("got %v, expected %v", last, start.Add(40*time.Minute))
+	}
+	if n, err := db.CountExpiring(25 * time.Minute); err != nil || n != 2 {
+		t.Fatalf("got %d, %v, expected 2", n, err)
+	}
+	clock.Advance(time.Hour)
+	if n, err := db.CountExpiring(0); err != nil || n != 5 {
+		t.Fatalf("got %d, %v, expected 5", n, err)
+	}
+}
+
+func TestSweepUsesIndex(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock))
+	entries := make(map[string]interface{})
+	for i := 0; i < 5000; i++ {
+		entries[fmt.Sprintf("key%04d", i)] = i
+	}
+	if err := db.PutAll(entries); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2500; i += 100 {
+		if err := db.Expire(fmt.Sprintf("key%04d", i), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 2500; i < 5000; i += 100 {
+		if err := db.Expire(fmt.Sprintf("key%04d", i), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Advance(time.Minute)
+	if n, err := db.SweepExpired(); err != nil || n != 25 {
+		t.Fatalf("swept %d, %v, expected 25", n, err)
+	}
+	// the 25 expired keys plus the first one that has not expired
+	if steps := atomic.LoadInt64(&db.sweepSteps); steps != 26 {
+		t.Fatalf("sweep visited %d index entries, expected 26", steps)
+	}
+	if err := db.Get("key0000", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if err := db.Get("key0001", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpirySweep(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock), WithExpirySweep(time.Minute))
+	if err := db.PutWithTTL("key", "value", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+	clock.Advance(time.Minute)
+	waitFor(t, func() bool { return !inFile(t, db, "key") })
+	if n, err := db.CountExpiring(time.Hour); err != nil || n != 0 {
+		t.Fatalf("got %d, %v, expected 0", n, err)
+	}
+}
+
+func TestPutAllWithTTL(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock))
+	var entries []TTLEntry
+	for i := 0; i < 30; i++ {
+		// ten each expiring after one and two minutes, and ten permanent
+		entries = append(entries, TTLEntry{Key: keyN(i), Value: i, TTL: time.Duration(i%3) * time.Minute})
+	}
+	if err := db.PutAllWithTTL(entries); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{}
+	for i := 0; i < 30; i++ {
+		want[keyN(i)] = i
+	}
+	checkValues(t, db, want)
+	for i := 0; i < 30; i++ {
+		ttl, err := db.TTL(keyN(i))
+		if i%3 == 0 && (err != nil || ttl != 0) {
+			t.Fatalf("%s: got %v, %v, expected no TTL", keyN(i), ttl, err)
+		} else if i%3 != 0 && (err != nil || ttl != time.Duration(i%3)*time.Minute) {
+			t.Fatalf("%s: got %v, %v", keyN(i), ttl, err)
+		}
+	}
+	if n := len(expiring(t, db)); n != 20 {
+		t.Fatalf("%d keys in the expiry index, expected 20", n)
+	}
+
+	clock.Advance(time.Minute)
+	for i := 1; i < 30; i += 3 {
+		delete(want, keyN(i))
+	}
+	checkValues(t, db, want)
+	clock.Advance(time.Minute)
+	for i := 2; i < 30; i += 3 {
+		delete(want, keyN(i))
+	}
+	checkValues(t, db, want)
+	if n, err := db.SweepExpired(); err != nil || n != 20 {
+		t.Fatalf("swept %d, %v, expected 20", n, err)
+	}
+	if keys := expiring(t, db); len(keys) != 0 {
+		t.Fatalf("left %v in the expiry index", keys)
+	}
+}
+
+func TestPutAllWithTTLOverwrites(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock))
+	if err := db.PutWithTTL("old", 0, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	err := db.PutAllWithTTL([]TTLEntry{
+		{Key: "a", Value: 1, TTL: time.Minute},
+		{Key: "b", Value: 1, TTL: time.Minute},
+		{Key: "old", Value: 1, TTL: 2 * time.Minute},
+		{Key: "a", Value: 2, TTL: 3 * time.Minute},
+		{Key: "b", Value: 2},
+		{Key: "c", Value: 1},
+		{Key: "c", Value: 2, TTL: time.Minute},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkValues(t, db, map[string]int{"a": 2, "b": 2, "c": 2, "old": 1})
+	// one index entry per key with a TTL, for the TTL it was given last
+	if got, want := expiring(t, db), []string{"c", "old", "a"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got index %v, expected %v", got, want)
+	}
+	if ttl, err := db.TTL("b"); err != nil || ttl != 0 {
+		t.Fatalf("got %v, %v, expected no TTL", ttl, err)
+	}
+
+	// nothing is written if an entry is bad
+	err = db.PutAllWithTTL([]TTLEntry{{Key: "d", Value: 1}, {Key: "e", Value: 1, TTL: -time.Second}})
+	if err != ErrBadValue {
+		t.Fatalf("got %v, expected ErrBadValue", err)
+	}
+	err = db.PutAllWithTTL([]TTLEntry{{Key: "d", Value: 1, TTL: time.Minute}, {Key: "e", Value: nil}})
+	if err != ErrBadValue {
+		t.Fatalf("got %v, expected ErrBadValue", err)
+	}
+	if err := db.Get("d", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if err := db.Protect("keep"); err != nil {
+		t.Fatal(err)
+	}
+	err = db.PutAllWithTTL([]TTLEntry{{Key: "d", Value: 1, TTL: time.Minute}, {Key: "keep", Value: 1, TTL: time.Minute}})
+	if err != ErrProtected {
+		t.Fatalf("got %v, expected ErrProtected", err)
+	}
+	if err := db.Get("d", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if got, want := expiring(t, db), []string{"c", "old", "a"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got index %v, expected %v", got, want)
+	}
+}
+
+func BenchmarkPutAllWithTTL(b *testing.B) {
+	const n = 1000
+	b.Run("looped", func(b *testing.B) {
+		db := openTestStore(b)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < n; j++ {
+				if err := db.PutWithTTL(keyN(j), j, time.Hour); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("batched", func(b *testing.B) {
+		db := openTestStore(b)
+		entries := make([]TTLEntry, n)
+		for j := range entries {
+			entries[j] = TTLEntry{Key: keyN(j), Value: j, TTL: time.Hour}
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := db.PutAllWithTTL(entries); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// ttls returns the TTL left of each key, which must be present.
+func ttls(t *testing.T, db *Store, keys ...string) []time.Duration {
+	t.Helper()
+	out := make([]time.Duration, len(keys))
+	for i, k := range keys {
+		ttl, err := db.TTL(k)
+		if err != nil {
+			t.Fatalf("%s: %v", k, err)
+		}
+		out[i] = ttl
+	}
+	return out
+}
+
+func TestTTLJitter(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock), WithTTLJitter(0.2))
+	const n = 1000
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = keyN(i)
+		if err := db.PutWithTTL(keys[i], i, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// uniform over [48m, 72m]: about half on each side, and spread out
+	var below, above, sum time.Duration
+	var low, high int
+	for _, ttl := range ttls(t, db, keys...) {
+		if ttl < 48*time.Minute || ttl > 72*time.Minute {
+			t.Fatalf("TTL %v outside of 1h±20%%", ttl)
+		}
+		sum += ttl
+		switch {
+		case ttl < time.Hour:
+			below++
+		case ttl > time.Hour:
+			above++
+		}
+		if ttl < 54*time.Minute {
+			low++
+		} else if ttl > 66*time.Minute {
+			high++
+		}
+	}
+	if below < n/2-100 || above < n/2-100 {
+		t.Fatalf("%d TTLs below 1h and %d above", below, above)
+	}
+	if mean := sum / n; mean < 58*time.Minute || mean > 62*time.Minute {
+		t.Fatalf("mean TTL %v, expected about 1h", mean)
+	}
+	// each outer quarter of the range holds about a quarter of the TTLs
+	if low < n/4-75 || high < n/4-75 {
+		t.Fatalf("%d TTLs in the lowest quarter and %d in the highest", low, high)
+	}
+
+	// PutAllWithTTL and BucketTx.PutWithTTL are jittered too
+	var entries []TTLEntry
+	for i := 0; i < 100; i++ {
+		entries = append(entries, TTLEntry{Key: fmt.Sprintf("all%d", i), Value: i, TTL: time.Hour})
+	}
+	if err := db.PutAllWithTTL(entries); err != nil {
+		t.Fatal(err)
+	}
+	err := db.Update(func(tx *WriteTx) error {
+		return tx.InBucket("test").PutWithTTL("tx", 1, time.Hour)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exact := 0
+	for _, e := range entries {
+		if ttl := ttls(t, db, e.Key)[0]; ttl == time.Hour {
+			exact++
+		} else if ttl < 48*time.Minute || ttl > 72*time.Minute {
+			t.Fatalf("TTL %v outside of 1h±20%%", ttl)
+		}
+	}
+	if exact > 1 {
+		t.Fatalf("PutAllWithTTL kept %d TTLs exact", exact)
+	}
+	if ttl := ttls(t, db, "tx")[0]; ttl < 48*time.Minute || ttl > 72*time.Minute {
+		t.Fatalf("TTL %v outside of 1h±20%%", ttl)
+	}
+
+	// Expire is exact
+	if err := db.Expire(keyN(0), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := ttls(t, db, keyN(0))[0]; ttl != time.Minute {
+		t.Fatalf("got %v, expected 1m", ttl)
+	}
+}
+
+func TestTTLJitterNeverZero(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock), WithTTLJitter(5))
+	for i := 0; i < 200; i++ {
+		if err := db.PutWithTTL(keyN(i), i, 2); err != nil {
+			t.Fatal(err)
+		}
+		if ttl := ttls(t, db, keyN(i))[0]; ttl < 1 || ttl > 4 {
+			t.Fatalf("TTL %v for 2ns with full jitter", ttl)
+		}
+	}
+	if err := db.PutWithTTLJitter("k", 1, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := ttls(t, db, "k")[0]; ttl <= 0 {
+		t.Fatalf("got %v", ttl)
+	}
+}
+
+func TestPutWithTTLJitter(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock), WithTTLJitter(0.5))
+	// the call's jitter wins over the store's
+	for i := 0; i < 100; i++ {
+		if err := db.PutWithTTLJitter("exact", i, time.Hour, 0); err != nil {
+			t.Fatal(err)
+		}
+		if ttl := ttls(t, db, "exact")[0]; ttl != time.Hour {
+			t.Fatalf("got %v, expected 1h", ttl)
+		}
+		if err := db.PutWithTTLJitter("narrow", i, time.Hour, 0.01); err != nil {
+			t.Fatal(err)
+		}
+		if ttl := ttls(t, db, "narrow")[0]; ttl < time.Hour-36*time.Second || ttl > time.Hour+36*time.Second {
+			t.Fatalf("TTL %v outside of 1h±1%%", ttl)
+		}
+	}
+	for _, jitter := range []float64{-0.1, 1.5} {
+		if err := db.PutWithTTLJitter("bad", 1, time.Hour, jitter); err != ErrBadValue {
+			t.Fatalf("jitter %v: got %v, expected ErrBadValue", jitter, err)
+		}
+	}
+	if err := db.PutWithTTLJitter("bad", 1, 0, 0.1); err != ErrBadValue {
+		t.Fatalf("got %v, expected ErrBadValue", err)
+	}
+
+	// without WithTTLJitter, TTLs are exact
+	plain := openTestStore(t, WithClock(clock))
+	for i := 0; i < 100; i++ {
+		if err := plain.PutWithTTL(keyN(i), i, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if ttl := ttls(t, plain, keyN(i))[0]; ttl != time.Hour {
+			t.Fatalf("got %v, expected 1h", ttl)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package bboltkv
+
+import (
+	"encoding/binary"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// ttlPolicyBucket maps the prefixes given TTL policies with SetTTLPolicy,
+// each following a 'p' so that the empty prefix has a key, to their TTLs,
+// as big-endian nanoseconds.
+const ttlPolicyBucket = "ttl-policies"
+
+// ttlPolicies keeps the TTL policies in memory, so that writes can look
+// them up cheaply. It is loaded when the store is opened, and updated
+// whenever a change to them commits, like protection, also through the
+// other stores sharing it, see bucketState.
+type ttlPolicies struct {
+	n  int32 // policies, accessed atomically
+	mu sync.RWMutex
+	m  map[string]time.Duration
+}
+
+// policyTTL returns the TTL of the policy with the longest prefix of key,
+// if there is one.
+func (s *Store) policyTTL(key string) (time.Duration, bool) {
+	p := &s.state.policies
+	if atomic.LoadInt32(&p.n) == 0 {
+		return 0, false
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	prefix, ttl, found := "", time.Duration(0), false
+	for pre, t := range p.m {
+		if strings.HasPrefix(key, pre) && (!found || len(pre) > len(prefix)) {
+			prefix, ttl, found = pre, t, true
+		}
+	}
+	return ttl, found
+}
+
+// loadTTLPolicies reads the TTL policies from the file.
+func (s *Store) loadTTLPolicies(tx *bbolt.Tx) {
+	s.setTTLPolicies(s.readTTLPolicies(tx))
+}
+
+func (s *Store) readTTLPolicies(tx *bbolt.Tx) map[string]time.Duration {
+	m := make(map[string]time.Duration)
+	if b := s.aux(tx, ttlPolicyBucket); b != nil {
+		b.ForEach(func(k, v []byte) error {
+			if len(k) > 0 && len(v) == 8 {
+				m[string(k[1:])] = time.Duration(binary.BigEndian.Uint64(v))
+			}
+			return nil
+		})
+	}
+	return m
+}
+
+func (s *Store) setTTLPolicies(m map[string]time.Duration) {
+	p := &s.state.policies
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.m = m
+	atomic.StoreInt32(&p.n, int32(len(m)))
+}
+
+// applyTTLPolicy gives key, just written, the TTL of the policy covering
+// it, if any. Protected keys cannot expire, so policies pass over them.
+func (w *wtx) applyTTLPolicy(key string) error {
+	ttl, ok := w.s.policyTTL(key)
+	if !ok || ttl == 0 || w.s.protected(key) {
+		return nil
+	}
+	return w.setExpiry(key, w.s.now().Add(jittered(ttl, w.s.opts.ttlJitter)))
+}
+
+// SetTTLPolicy makes entries written under keys starting with prefix expire
+// after ttl has passed, as if written with PutWithTTL, jitter included,
+// replacing any policy prefix had. The policy applies to the writes made
+// from then on, by Put and every other method writing entries, not to the
+// entries already stored. Writes with a TTL of their own, with PutWithTTL,
+// keep theirs.
+//
+// Where policies nest, the one with the longest prefix matching the key
+// applies, and a policy with a ttl of 0 gives the keys it covers no TTL, so
+// exceptions can be carved out of a broader policy. Protected keys, see
+// Protect, are written without a TTL whatever the policy. A negative ttl
+// fails with ErrBadValue. Policies are stored in the database file, so they
+// apply until removed with RemoveTTLPolicy, also after reopening the store,
+// and to the writes of the other stores sharing the bucket, see OpenShared.
+//
+//	store.SetTTLPolicy("cache:", 15*time.Minute)
+//	store.SetTTLPolicy("cache:config:", 0) // kept until deleted
+func (s *Store) SetTTLPolicy(prefix string, ttl time.Duration) error {
+	if ttl < 0 {
+		return ErrBadValue
+	}
+	return s.changeTTLPolicy(prefix, ttl, true)
+}
+
+// RemoveTTLPolicy removes the policy SetTTLPolicy gave to prefix, if any.
+// Entries written under it keep the TTLs they were given.
+func (s *Store) RemoveTTLPolicy(prefix string) error {
+	return s.changeTTLPolicy(prefix, 0, false)
+}
+
+// ListTTLPolicies returns the TTL given to each prefix with SetTTLPolicy.
+func (s *Store) ListTTLPolicies() (map[string]time.Duration, error) {
+	var m map[string]time.Duration
+	err := s.view(func(tx *bbolt.Tx) error {
+		m = s.readTTLPolicies(tx)
+		return nil
+	})
+	return m, err
+}
+
+// changeTTLPolicy sets or removes the policy of prefix, and reloads the
+// policies once the change commits.
+func (s *Store) changeTTLPolicy(prefix string, ttl time.Duration, set bool) error {
+	if err := s.plainKeys(); err != nil {
+		return err
+	}
+	return s.update(func(w *wtx) error {
+		b, err := w.aux(ttlPolicyBucket)
+		if err != nil {
+			return err
+		}
+		if set {
+			v := make([]byte, 8)
+			binary.BigEndian.PutUint64(v, uint64(ttl))
+			err = b.Put([]byte("p"+prefix), v)
+		} else {
+			err = b.Delete([]byte("p" + prefix))
+		}
+		if err != nil {
+			return err
+		}
+		m := s.readTTLPolicies(w.tx)
+		w.tx.OnCommit(func() { s.setTTLPolicies(m) })
+		return nil
+	})
+}
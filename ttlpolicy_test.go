@@ -0,0 +1,198 @@
+package bboltkv
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/unknownnf/bboltkv/testutil"
+)
+
+// checkTTLs checks the TTLs of keys, 0 for none.
+func checkTTLs(t *testing.T, db *Store, ttls map[string]time.Duration) {
+	t.Helper()
+	for key, expected := range ttls {
+		if ttl, err := db.TTL(key); err != nil {
+			t.Fatalf("%s: %v", key, err)
+		} else if ttl != expected {
+			t.Fatalf("%s: got a TTL of %v, expected %v", key, ttl, expected)
+		}
+	}
+}
+
+func TestTTLPolicy(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock))
+	if err := db.Put("cache:old", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetTTLPolicy("cache:", 15*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"cache:a", "other"} {
+		if err := db.Put(key, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.PutAll(map[string]interface{}{"cache:b": 1, "cache:c": 1}); err != nil {
+		t.Fatal(err)
+	}
+	// entries stored before are left alone
+	checkTTLs(t, db, map[string]time.Duration{"cache:a": 15 * time.Minute, "cache:b": 15 * time.Minute, "other": 0, "cache:old": 0})
+
+	clock.Advance(16 * time.Minute)
+	checkValues(t, db, map[string]int{"cache:old": 1, "other": 1})
+
+	// an explicit TTL wins, both ways
+	if err := db.PutWithTTL("cache:a", 1, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutAllWithTTL([]TTLEntry{{Key: "cache:b", Value: 1, TTL: time.Minute}, {Key: "cache:c", Value: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	checkTTLs(t, db, map[string]time.Duration{"cache:a": time.Hour, "cache:b": time.Minute, "cache:c": 15 * time.Minute})
+	// overwritten, the entry gets the policy's again
+	if err := db.Put("cache:a", 2); err != nil {
+		t.Fatal(err)
+	}
+	checkTTLs(t, db, map[string]time.Duration{"cache:a": 15 * time.Minute})
+
+	// protected keys do not expire
+	if err := db.Protect("cache:kept"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("cache:kept", 1); err != nil {
+		t.Fatal(err)
+	}
+	checkTTLs(t, db, map[string]time.Duration{"cache:kept": 0})
+
+	if err := db.SetTTLPolicy("bad:", -time.Second); err != ErrBadValue {
+		t.Fatalf("got %v, expected ErrBadValue", err)
+	}
+}
+
+func TestTTLPolicyNested(t *testing.T) {
+	db := openTestStore(t, WithClock(testutil.NewFakeClock(time.Now())))
+	for prefix, ttl := range map[string]time.Duration{
+		"":                time.Hour,
+		"cache:":          15 * time.Minute,
+		"cache:session:":  time.Minute,
+		"cache:config:":   0,
+		"cache:config:x:": 2 * time.Minute,
+	} {
+		if err := db.SetTTLPolicy(prefix, ttl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := map[string]time.Duration{
+		"user:1":           time.Hour,
+		"cache:page":       15 * time.Minute,
+		"cache:session:42": time.Minute,
+		"cache:config:db":  0,
+		"cache:config:x:y": 2 * time.Minute,
+		"cache:configs":    15 * time.Minute,
+	}
+	for key := range expected {
+		if err := db.Put(key, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkTTLs(t, db, expected)
+
+	// the broader policy applies once the narrower one is removed
+	if err := db.RemoveTTLPolicy("cache:session:"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RemoveTTLPolicy("absent:"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("cache:session:42", 2); err != nil {
+		t.Fatal(err)
+	}
+	checkTTLs(t, db, map[string]time.Duration{"cache:session:42": 15 * time.Minute})
+}
+
+func TestTTLPolicyPersisted(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path, "test", WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies := map[string]time.Duration{"cache:": 15 * time.Minute, "cache:config:": 0}
+	for prefix, ttl := range policies {
+		if err := db.SetTTLPolicy(prefix, ttl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	db, err = Open(path, "test", WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if got, err := db.ListTTLPolicies(); err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(got, policies) {
+		t.Fatalf("got %v, expected %v", got, policies)
+	}
+	for _, key := range []string{"cache:a", "cache:config:a"} {
+		if err := db.Put(key, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkTTLs(t, db, map[string]time.Duration{"cache:a": 15 * time.Minute, "cache:config:a": 0})
+
+	if got, err := openTestStore(t).ListTTLPolicies(); err != nil || len(got) != 0 {
+		t.Fatalf("got %v, %v", got, err)
+	}
+}
+
+func TestTTLPolicyShared(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	a, b := openSharedBucket(t, []Option{WithClock(clock)}, []Option{WithClock(clock)})
+	if err := b.SetTTLPolicy("cache:", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Put("cache:x", 1); err != nil {
+		t.Fatal(err)
+	}
+	checkTTLs(t, a, map[string]time.Duration{"cache:x": time.Minute})
+	if err := b.RemoveTTLPolicy("cache:"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Put("cache:y", 1); err != nil {
+		t.Fatal(err)
+	}
+	checkTTLs(t, a, map[string]time.Duration{"cache:y": 0})
+}
+
+func TestTTLPolicyJitter(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithClock(clock), WithTTLJitter(0.1))
+	if err := db.SetTTLPolicy("cache:", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	exact := 0
+	for i := 0; i < 100; i++ {
+		key := "cache:" + keyN(i)
+		if err := db.Put(key, i); err != nil {
+			t.Fatal(err)
+		}
+		ttl, err := db.TTL(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl < 54*time.Minute || ttl > 66*time.Minute {
+			t.Fatalf("TTL %v outside of 1h±10%%", ttl)
+		}
+		if ttl == time.Hour {
+			exact++
+		}
+	}
+	if exact > 1 {
+		t.Fatalf("%d policy TTLs exact", exact)
+	}
+}
@@ -0,0 +1,355 @@
+package bboltkv
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// wtx is a read-write transaction on the store's bucket. All changes to the
+// bucket are made through its methods, so that the rest of the store (such
+// as the read cache) learns about them.
+type wtx struct {
+	s       *Store
+	tx      *bbolt.Tx
+	b       *bbolt.Bucket
+	touched []string
+	sizes   []int             // len of the value written for each touched key, or -1
+	stale   []string          // keys not written, but whose cached values must go
+	force   bool              // immutable keys may be deleted, see ForceDelete
+	grow    bool              // the lookup filter is full, see WithNegativeLookupFilter
+	quota   *quotaTx          // usage counted against the quota, see WithQuota
+	warning bool              // the store's warnings are held for the commit, see saveQuota
+	joined  []*wtx            // the other stores' parts of an Update
+	journal bool              // a journal was appended to, see journaled
+	logged  bool              // the change log was appended to, see logChange
+	op      string            // the operation making the write, see WithTxStats
+	opKey   string            // the key it concerns, if a single one
+	meta    map[string]string // attached to the context of the write, see WithOpMeta
+	hooked  []hookedWrite     // the writes for the hooks, see WithPutHook
+
+	// sideEffects is set by writes that change the store's state outside
+	// the transaction, so that they are not retried, see WithRetry.
+	sideEffects bool
+}
+
+// get returns the value stored under key, or nil, also if the key has
+// expired. The slice is only valid for the lifetime of the transaction.
+// If the value's chunks cannot be read, get returns its header, which
+// fails to decode.
+func (w *wtx) get(key string) []byte {
+	if w.s.expired(w.tx, key) {
+		return nil
+	}
+	v := w.b.Get([]byte(key))
+	if raw, err := w.s.assemble(w.tx, []byte(key), v); err == nil {
+		return raw
+	}
+	return v
+}
+
+func (w *wtx) put(key string, raw []byte) error {
+	if err := w.checkMutable(key); err != nil {
+		return err
+	}
+	if w.s.quotaOn() {
+		if err := w.account(key, len(raw)); err != nil {
+			return err
+		}
+	}
+	if len(w.s.opts.history) > 0 {
+		if err := w.archive(key); err != nil {
+			return err
+		}
+	}
+	if err := w.updateViews(key, raw); err != nil {
+		return err
+	}
+	if err := w.updateTextIndexes(key, raw); err != nil {
+		return err
+	}
+	if err := w.dropList(key, raw); err != nil {
+		return err
+	}
+	if err := w.dropExpiry(key); err != nil {
+		return err
+	}
+	if err := w.dropSchedule(key); err != nil {
+		return err
+	}
+	if err := w.dropTags(key); err != nil {
+		return err
+	}
+	if err := w.dropTimestamp(key); err != nil {
+		return err
+	}
+	if err := w.dropChunks(key); err != nil {
+		return err
+	}
+	if err := w.dropBlob(key); err != nil {
+		return err
+	}
+	if w.s.filterAdd(key) {
+		w.grow = true
+	}
+	if err := w.store(key, raw); err != nil {
+		return err
+	}
+	if err := w.applyTTLPolicy(key); err != nil {
+		return err
+	}
+	if len(w.s.opts.insertionOrder) > 0 {
+		if err := w.order(key); err != nil {
+			return err
+		}
+	}
+	if err := w.stampWrite(key); err != nil {
+		return err
+	}
+	if w.s.opts.changeLog {
+		if err := w.logChange(key, raw); err != nil {
+			return err
+		}
+	}
+	if w.s.opts.putHook != nil {
+		w.hook(key, raw)
+	}
+	w.touched = append(w.touched, key)
+	if w.s.opStats != nil {
+		w.sizes = append(w.sizes, len(raw))
+	}
+	return nil
+}
+
+func (w *wtx) delete(key string) error {
+	if err := w.checkMutable(key); err != nil {
+		return err
+	}
+	if w.s.quotaOn() {
+		if err := w.account(key, -1); err != nil {
+			return err
+		}
+	}
+	if w.s.opts.deleteHistory {
+		if err := w.archive(key); err != nil {
+			return err
+		}
+	}
+	if err := w.updateViews(key, nil); err != nil {
+		return err
+	}
+	if err := w.updateTextIndexes(key, nil); err != nil {
+		return err
+	}
+	if err := w.dropList(key, nil); err != nil {
+		return err
+	}
+	if err := w.dropExpiry(key); err != nil {
+		return err
+	}
+	if err := w.dropSchedule(key); err != nil {
+		return err
+	}
+	if err := w.dropTags(key); err != nil {
+		return err
+	}
+	if err := w.dropTimestamp(key); err != nil {
+		return err
+	}
+	if err := w.dropChunks(key); err != nil {
+		return err
+	}
+	if err := w.dropBlob(key); err != nil {
+		return err
+	}
+	if err := w.b.Delete([]byte(key)); err != nil {
+		return err
+	}
+	if len(w.s.opts.insertionOrder) > 0 {
+		if err := w.dropOrder(key); err != nil {
+			return err
+		}
+	}
+	if err := w.stampDelete(key); err != nil {
+		return err
+	}
+	if w.s.opts.changeLog {
+		if err := w.logChange(key, nil); err != nil {
+			return err
+		}
+	}
+	if w.s.opts.deleteHook != nil {
+		w.hook(key, nil)
+	}
+	w.touched = append(w.touched, key)
+	if w.s.opStats != nil {
+		w.sizes = append(w.sizes, -1)
+	}
+	return nil
+}
+
+// aux returns the store's internal bucket with the given name, creating it if
+// needed.
+func (w *wtx) aux(name string) (*bbolt.Bucket, error) {
+	return w.tx.CreateBucketIfNotExists(w.s.auxName(name))
+}
+
+// auxName returns the name of the store's internal bucket for the given
+// feature. Internal buckets sit next to the store's bucket, named after it.
+func (s *Store) auxName(name string) []byte {
+	return []byte(string(s.bucketName) + "\x00" + name)
+}
+
+// aux returns the store's internal bucket with the given name, or nil if it
+// has not been created yet.
+func (s *Store) aux(tx *bbolt.Tx, name string) *bbolt.Bucket {
+	return tx.Bucket(s.auxName(name))
+}
+
+// view runs fn in a read-only transaction, unless the store is closed. Any
+// writes waiting in the write buffer are flushed first, so that fn sees
+// them.
+func (s *Store) view(fn func(tx *bbolt.Tx) error) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.gate.exit()
+	if s.wbuf != nil {
+		if err := s.flushBuffer(); err != nil {
+			return err
+		}
+	}
+	return s.db.View(func(tx *bbolt.Tx) error {
+		if tx.Bucket(s.bucketName) == nil {
+			return s.errNoBucket()
+		}
+		return fn(tx)
+	})
+}
+
+// update runs fn in a read-write transaction, unless the store is closed or
+// in read-only mode. Any writes waiting in the write buffer are flushed
+// first, so that they are not applied on top of fn's changes.
+func (s *Store) update(fn func(w *wtx) error) error {
+	return s.updateContext(context.Background(), fn)
+}
+
+// updateContext is update, giving up if the transaction cannot begin before
+// ctx is done or the store's WithOpTimeout has passed, see writeContext.
+func (s *Store) updateContext(ctx context.Context, fn func(w *wtx) error) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.gate.exit()
+	if atomic.LoadInt32(&s.readOnly) != 0 {
+		return ErrReadOnly
+	}
+	if s.wbuf != nil {
+		if err := s.flushBuffer(); err != nil {
+			return err
+		}
+	}
+	if _, ok := ctx.Deadline(); !ok && s.opts.opTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.opts.opTimeout)
+		defer cancel()
+	}
+	return s.retry(ctx, fn, func(fn func(w *wtx) error) error {
+		if ctx.Done() == nil {
+			return s.write(fn)
+		}
+		return s.writeContext(ctx, fn)
+	})
+}
+
+// write runs fn in a read-write transaction. The caller is responsible for
+// the checks done by update.
+func (s *Store) write(fn func(w *wtx) error) error {
+	if err := s.holdWrite(context.Background()); err != nil {
+		return err
+	}
+	defer s.freezer.release()
+	var w *wtx
+	err := s.db.Update(func(tx *bbolt.Tx) (err error) {
+		w, err = s.apply(tx, fn)
+		return err
+	})
+	if err != nil && w != nil {
+		w.abort()
+	}
+	if err == nil && s.rot != nil {
+		s.rot.committed()
+	}
+	return err
+}
+
+// apply runs fn on the store's bucket in tx. Unless the transaction then
+// commits, the caller must abort the wtx returned.
+func (s *Store) apply(tx *bbolt.Tx, fn func(w *wtx) error) (*wtx, error) {
+	w := &wtx{s: s, tx: tx}
+	b, err := s.writeBucket(tx)
+	if err != nil {
+		return w, err
+	}
+	w.b = b
+	var start time.Time
+	if s.txStats != nil {
+		start = s.now()
+	}
+	if err := fn(w); err != nil {
+		return w, err
+	}
+	if err := w.saveQuota(); err != nil {
+		return w, err
+	}
+	if s.txStats != nil {
+		w.recordTxStats(start)
+	}
+	if s.txHook != nil {
+		if err := s.txHook(); err != nil {
+			return w, err
+		}
+	}
+	w.commit()
+	return w, nil
+}
+
+// commit arranges for the store's bookkeeping to be updated once the
+// transaction has been committed.
+func (w *wtx) commit() {
+	if len(w.touched) == 0 && len(w.stale) == 0 {
+		return
+	}
+	if w.grow {
+		w.tx.OnCommit(w.s.growFilter)
+	}
+	keys := w.touched
+	changed := append(keys[:len(keys):len(keys)], w.stale...)
+	if c := w.s.cache; c != nil {
+		w.tx.OnCommit(func() { c.invalidate(changed) })
+	}
+	if c := w.s.decoded; c != nil {
+		w.tx.OnCommit(func() { c.invalidate(changed) })
+	}
+	if f := w.s.flights; f != nil {
+		w.tx.OnCommit(func() { f.reads.forget(changed) })
+	}
+	if o := w.s.opStats; o != nil {
+		sizes := w.sizes
+		w.tx.OnCommit(func() {
+			for i, key := range keys {
+				if sizes[i] < 0 {
+					o.delete(key)
+				} else {
+					o.write(key, sizes[i])
+				}
+			}
+		})
+	}
+}
+
+func (s *Store) setReadOnly() {
+	atomic.StoreInt32(&s.readOnly, 1)
+}
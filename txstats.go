@@ -0,0 +1,183 @@
+package bboltkv
+
+import (
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// TxStatsOther is the operation under which TxStatsSnapshot reports the
+// writes of methods it does not name.
+const TxStatsOther = "other"
+
+// TxStatsDelta describes the work of the transaction of a write, from
+// bbolt's statistics of the transaction, see WithTxStats.
+type TxStatsDelta struct {
+	PageAllocs int           // pages allocated
+	PageBytes  int           // bytes of the pages allocated
+	Rebalance  time.Duration // time spent merging underfilled nodes
+	Spill      time.Duration // time spent splitting and writing nodes to pages
+	PageWrites int           // pages written to the file
+	Write      time.Duration // time spent writing pages, including the fsync
+	Commit     time.Duration // time from the start of the commit to its end
+	Duration   time.Duration // time from the start of the transaction to the end of its commit
+}
+
+func (d *TxStatsDelta) add(o TxStatsDelta) {
+	d.PageAllocs += o.PageAllocs
+	d.PageBytes += o.PageBytes
+	d.Rebalance += o.Rebalance
+	d.Spill += o.Spill
+	d.PageWrites += o.PageWrites
+	d.Write += o.Write
+	d.Commit += o.Commit
+	d.Duration += o.Duration
+}
+
+// TxOpStats sums up the transactions of the writes of one operation, see
+// TxStatsSnapshot.
+type TxOpStats struct {
+	Count   int64        // transactions committed
+	Last    TxStatsDelta // the most recent one
+	Total   TxStatsDelta // the sum of all of them
+	Slowest TxStatsDelta // the one of the longest Duration
+}
+
+// Mean returns the average of the transactions summed up, or zero if there
+// are none.
+func (o TxOpStats) Mean() TxStatsDelta {
+	if o.Count == 0 {
+		return TxStatsDelta{}
+	}
+	n := o.Count
+	t := o.Total
+	return TxStatsDelta{
+		PageAllocs: int(int64(t.PageAllocs) / n),
+		PageBytes:  int(int64(t.PageBytes) / n),
+		Rebalance:  t.Rebalance / time.Duration(n),
+		Spill:      t.Spill / time.Duration(n),
+		PageWrites: int(int64(t.PageWrites) / n),
+		Write:      t.Write / time.Duration(n),
+		Commit:     t.Commit / time.Duration(n),
+		Duration:   t.Duration / time.Duration(n),
+	}
+}
+
+// WithTxStats makes the store keep bbolt's statistics of the transaction of
+// every write it commits, with how long its commit took, and sum them up
+// per operation, such as "Put", for TxStatsSnapshot. Transactions that fail
+// to commit are not counted. Without the option, or WithSlowOpCallback,
+// writes only pay for checking that neither was given.
+func WithTxStats() Option {
+	return func(o *options) {
+		o.txStats = true
+	}
+}
+
+// WithSlowOpCallback makes the store call fn with the statistics of the
+// transaction of every write whose Duration reaches threshold, naming the
+// operation and the key it wrote, or "" if it concerns several, so that the
+// reason for an occasional slow write can be told: page allocations, the
+// fsync, or a long transaction. If the commit took most of the time, the
+// Write time tells whether the fsync did. fn is called once the
+// transaction has committed, on the goroutine that made the write, which
+// waits for it; it can use the store. The option implies WithTxStats.
+//
+//	bboltkv.WithSlowOpCallback(100*time.Millisecond, func(op, key string, st bboltkv.TxStatsDelta) {
+//	    log.Printf("slow %s of %q: commit %v, of which writing %v pages %v", op, key, st.Commit, st.PageWrites, st.Write)
+//	})
+func WithSlowOpCallback(threshold time.Duration, fn func(op, key string, stats TxStatsDelta)) Option {
+	return func(o *options) {
+		o.txStats = true
+		o.slowOpThreshold = threshold
+		o.slowOpFn = fn
+	}
+}
+
+// TxStatsSnapshot returns the transaction statistics of the writes made
+// since the store was opened, per operation, see WithTxStats: "Put",
+// "PutAll", "PutEncoded", "PutWithTTL", "Delete", "Update",
+// "EnsureDefaults", "SeedOnce" and, for each batch it writes, "ImportJSON",
+// and TxStatsOther for the writes of the other methods. It returns nil
+// unless the store was opened with WithTxStats.
+func (s *Store) TxStatsSnapshot() map[string]TxOpStats {
+	if s.txStats == nil {
+		return nil
+	}
+	t := s.txStats
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := make(map[string]TxOpStats, len(t.ops))
+	for op, st := range t.ops {
+		m[op] = *st
+	}
+	return m
+}
+
+type txStats struct {
+	mu  sync.Mutex
+	ops map[string]*TxOpStats
+}
+
+func (t *txStats) record(op string, d TxStatsDelta) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := t.ops[op]
+	if st == nil {
+		st = &TxOpStats{}
+		t.ops[op] = st
+	}
+	st.Count++
+	st.Last = d
+	st.Total.add(d)
+	if d.Duration >= st.Slowest.Duration {
+		st.Slowest = d
+	}
+}
+
+// named returns fn, naming the write it makes op on key, or "", for
+// WithTxStats.
+func (s *Store) named(op, key string, fn func(w *wtx) error) func(w *wtx) error {
+	if s.txStats == nil {
+		return fn
+	}
+	return func(w *wtx) error {
+		w.op, w.opKey = op, key
+		return fn(w)
+	}
+}
+
+// recordTxStats arranges for the statistics of w's transaction, which
+// began at start and is about to commit, to be recorded once it has.
+func (w *wtx) recordTxStats(start time.Time) {
+	s := w.s
+	committing := s.now()
+	op, key, tx := w.op, w.opKey, w.tx
+	if op == "" {
+		op = TxStatsOther
+	}
+	tx.OnCommit(func() {
+		end := s.now()
+		d := txStatsDelta(tx.Stats())
+		d.Commit = end.Sub(committing)
+		d.Duration = end.Sub(start)
+		s.txStats.record(op, d)
+		if fn := s.opts.slowOpFn; fn != nil && d.Duration >= s.opts.slowOpThreshold {
+			fn(op, key, d)
+		}
+	})
+}
+
+// txStatsDelta returns the parts of bbolt's statistics of a transaction
+// that TxStatsDelta holds.
+func txStatsDelta(st bbolt.TxStats) TxStatsDelta {
+	return TxStatsDelta{
+		PageAllocs: st.PageCount,
+		PageBytes:  st.PageAlloc,
+		Rebalance:  st.RebalanceTime,
+		Spill:      st.SpillTime,
+		PageWrites: st.Write,
+		Write:      st.WriteTime,
+	}
+}
@@ -0,0 +1,150 @@
+package bboltkv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/unknownnf/bboltkv/testutil"
+)
+
+func TestTxStats(t *testing.T) {
+	db := openTestStore(t, WithTxStats())
+	for i := 0; i < 3; i++ {
+		if err := db.Put(keyN(i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.PutAll(map[string]interface{}{"a": 1, "b": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(keyN(0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *WriteTx) error {
+		return tx.InBucket("test").Put("c", 3)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Expire("a", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	// failed writes are not counted
+	if err := db.Delete("absent"); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+
+	stats := db.TxStatsSnapshot()
+	for op, count := range map[string]int64{"Put": 3, "PutAll": 1, "Delete": 1, "Update": 1, TxStatsOther: 1} {
+		st := stats[op]
+		if st.Count != count {
+			t.Fatalf("%s: got %d transactions, expected %d", op, st.Count, count)
+		}
+		if st.Last.PageWrites == 0 || st.Last.PageAllocs == 0 || st.Last.PageBytes == 0 {
+			t.Fatalf("%s: no pages in %+v", op, st.Last)
+		}
+		if st.Last.Duration < st.Last.Commit || st.Last.Commit < st.Last.Write || st.Last.Write == 0 {
+			t.Fatalf("%s: times do not add up in %+v", op, st.Last)
+		}
+		if st.Total.Duration < st.Slowest.Duration || st.Total.PageWrites < st.Last.PageWrites {
+			t.Fatalf("%s: total %+v is less than the last or slowest", op, st.Total)
+		}
+	}
+	if len(stats) != 5 {
+		t.Fatalf("got %v", stats)
+	}
+
+	if stats := openTestStore(t).TxStatsSnapshot(); stats != nil {
+		t.Fatalf("got %v without WithTxStats", stats)
+	}
+}
+
+func TestSlowOpCallback(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	type slowOp struct {
+		op, key string
+		stats   TxStatsDelta
+	}
+	var slow []slowOp
+	db := openTestStore(t, WithClock(clock), WithSlowOpCallback(time.Second, func(op, key string, stats TxStatsDelta) {
+		slow = append(slow, slowOp{op, key, stats})
+	}))
+	if err := db.Put("fast", 1); err != nil {
+		t.Fatal(err)
+	}
+	// the commit is held up
+	db.txHook = func() error {
+		clock.Advance(2 * time.Second)
+		return nil
+	}
+	if err := db.Put("slow", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("slow"); err != nil {
+		t.Fatal(err)
+	}
+	db.txHook = nil
+	if err := db.Delete("fast"); err != nil {
+		t.Fatal(err)
+	}
+	if len(slow) != 2 {
+		t.Fatalf("got %+v, expected the two slow writes", slow)
+	}
+	for i, op := range []string{"Put", "Delete"} {
+		if s := slow[i]; s.op != op || s.key != "slow" || s.stats.Commit < 2*time.Second || s.stats.Duration < s.stats.Commit {
+			t.Fatalf("got %+v, expected a slow %s", s, op)
+		}
+	}
+	// the callback implies the statistics, where the fast write took no
+	// time by the fake clock
+	if st := db.TxStatsSnapshot()["Put"]; st.Count != 2 || st.Slowest.Commit < 2*time.Second || st.Total.Commit != st.Slowest.Commit {
+		t.Fatalf("got %+v", st)
+	}
+}
+
+func TestTxStatsAggregation(t *testing.T) {
+	st := &txStats{ops: make(map[string]*TxOpStats)}
+	for _, d := range []TxStatsDelta{
+		{PageAllocs: 2, PageBytes: 8192, PageWrites: 3, Write: 3 * time.Millisecond, Commit: 4 * time.Millisecond, Duration: 5 * time.Millisecond},
+		{PageAllocs: 4, PageBytes: 16384, PageWrites: 5, Spill: time.Millisecond, Write: 9 * time.Millisecond, Commit: 12 * time.Millisecond, Duration: 20 * time.Millisecond},
+		{PageAllocs: 0, PageBytes: 0, PageWrites: 1, Rebalance: 3 * time.Millisecond, Write: time.Millisecond, Commit: 2 * time.Millisecond, Duration: 2 * time.Millisecond},
+	} {
+		st.record("Put", d)
+	}
+	got := *st.ops["Put"]
+	expected := TxOpStats{
+		Count:   3,
+		Last:    TxStatsDelta{PageWrites: 1, Rebalance: 3 * time.Millisecond, Write: time.Millisecond, Commit: 2 * time.Millisecond, Duration: 2 * time.Millisecond},
+		Total:   TxStatsDelta{PageAllocs: 6, PageBytes: 24576, PageWrites: 9, Rebalance: 3 * time.Millisecond, Spill: time.Millisecond, Write: 13 * time.Millisecond, Commit: 18 * time.Millisecond, Duration: 27 * time.Millisecond},
+		Slowest: TxStatsDelta{PageAllocs: 4, PageBytes: 16384, PageWrites: 5, Spill: time.Millisecond, Write: 9 * time.Millisecond, Commit: 12 * time.Millisecond, Duration: 20 * time.Millisecond},
+	}
+	if got != expected {
+		t.Fatalf("got %+v, expected %+v", got, expected)
+	}
+	mean := TxStatsDelta{PageAllocs: 2, PageBytes: 8192, PageWrites: 3, Rebalance: time.Millisecond, Spill: time.Millisecond / 3, Write: 13 * time.Millisecond / 3, Commit: 6 * time.Millisecond, Duration: 9 * time.Millisecond}
+	if m := got.Mean(); m != mean {
+		t.Fatalf("got mean %+v, expected %+v", m, mean)
+	}
+	if m := (TxOpStats{}).Mean(); m != (TxStatsDelta{}) {
+		t.Fatalf("got mean %+v of nothing", m)
+	}
+}
+
+func BenchmarkTxStats(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		opts []Option
+	}{
+		{"off", nil},
+		{"on", []Option{WithTxStats()}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			db := openTestStore(b, bench.opts...)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := db.Put("key", i); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
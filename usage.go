@@ -0,0 +1,182 @@
+package bboltkv
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+
+	"go.etcd.io/bbolt"
+)
+
+// UsageReport describes how the database file is used, see Store.Usage.
+type UsageReport struct {
+	FileSize      int64 // size of the database file, in bytes
+	PageSize      int   // size of a page, in bytes
+	Pages         int   // pages in use or free in the file
+	FreePages     int   // pages on the freelist, available for reuse
+	PendingPages  int   // pages freed, but still in use by open transactions
+	FreelistBytes int   // bytes used by the freelist itself
+
+	Keys         int   // entries in the store's bucket
+	LogicalBytes int64 // total length of all keys and values in the bucket
+
+	// Overhead is FileSize divided by LogicalBytes: how many bytes of file
+	// each byte of data costs. It is zero for an empty bucket.
+	Overhead float64
+
+	// Bucket holds bbolt's statistics for the store's bucket.
+	Bucket bbolt.BucketStats
+
+	// DedupValues is the number of values stored once for several keys,
+	// see WithDeduplication, DedupBytes their total length, and
+	// DedupSavedBytes the length of the copies not stored.
+	DedupValues     int
+	DedupBytes      int64
+	DedupSavedBytes int64
+
+	// ValueSizes is the histogram of value sizes, see ValueSizeHistogram,
+	// if Usage was called with WithSizeHistogram, and nil otherwise.
+	ValueSizes map[string]int
+}
+
+// Usage reports how much space the store's data takes up, compared to the
+// size of the file holding it. Computing LogicalBytes visits every entry in
+// the bucket.
+//
+// The file can be much larger than the data for a number of reasons: pages
+// freed by earlier writes sit on the freelist until reused, pages are only
+// partly filled after random inserts, and a bbolt file never shrinks, apart
+// from by compaction.
+//
+// With WithSizeHistogram, the report also holds a histogram of value
+// sizes, computed in the same transaction.
+func (s *Store) Usage(opts ...OpOption) (UsageReport, error) {
+	o := buildOpOptions(opts)
+	var r UsageReport
+	err := s.view(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(s.bucketName)
+		stats := s.db.Stats()
+		r.FileSize = tx.Size()
+		r.PageSize = s.db.Info().PageSize
+		r.Pages = int(r.FileSize) / r.PageSize
+		r.FreePages = stats.FreePageN
+		r.PendingPages = stats.PendingPageN
+		r.FreelistBytes = stats.FreelistInuse
+		r.Bucket = b.Stats()
+		r.Keys = r.Bucket.KeyN
+		if o.sizeBuckets != nil {
+			r.ValueSizes = s.sizeHistogram(tx, o.sizeBuckets, o.prefix)
+		}
+		s.dedupUsage(tx, &r)
+		return b.ForEach(func(k, v []byte) error {
+			r.LogicalBytes += int64(len(k) + len(v))
+			return nil
+		})
+	})
+	if r.LogicalBytes > 0 {
+		r.Overhead = float64(r.FileSize) / float64(r.LogicalBytes)
+	}
+	return r, err
+}
+
+// PageHistogram counts the pages used by the store's bucket, by kind:
+//
+//	"branch"           branch pages of the b-tree
+//	"branch-overflow"  extra pages of branch pages too large for one page
+//	"leaf"             leaf pages, holding the entries
+//	"leaf-overflow"    extra pages of leaf pages too large for one page,
+//	                   typically holding a single large value
+//
+// A small bucket may be stored inline in its parent page, in which case
+// all counts are zero.
+func (s *Store) PageHistogram() (map[string]int, error) {
+	var h map[string]int
+	err := s.view(func(tx *bbolt.Tx) error {
+		stats := tx.Bucket(s.bucketName).Stats()
+		h = map[string]int{
+			"branch":          stats.BranchPageN,
+			"branch-overflow": stats.BranchOverflowN,
+			"leaf":            stats.LeafPageN,
+			"leaf-overflow":   stats.LeafOverflowN,
+		}
+		return nil
+	})
+	return h, err
+}
+
+// ExponentialBuckets returns n histogram bucket bounds for
+// ValueSizeHistogram, starting at first and growing by factor each time:
+//
+//	ExponentialBuckets(64, 4, 5) // 64, 256, 1024, 4096, 16384
+func ExponentialBuckets(first, factor int64, n int) []int64 {
+	bounds := make([]int64, n)
+	for i := range bounds {
+		bounds[i] = first
+		first *= factor
+	}
+	return bounds
+}
+
+// WithSizeHistogram makes Usage include a histogram of value sizes with the
+// given bucket bounds, as returned by ValueSizeHistogram.
+func WithSizeHistogram(bounds []int64) OpOption {
+	return func(o *opOptions) {
+		o.sizeBuckets = bounds
+		if o.sizeBuckets == nil {
+			o.sizeBuckets = []int64{}
+		}
+	}
+}
+
+// WithPrefix restricts ValueSizeHistogram, and the histogram of Usage, to
+// the keys starting with prefix.
+func WithPrefix(prefix string) OpOption {
+	return func(o *opOptions) {
+		o.prefix = prefix
+	}
+}
+
+// ValueSizeHistogram counts the values in the store by their encoded size,
+// as stored. Each bound is the inclusive upper limit of a bucket, named
+// "<=" followed by the bound in bytes, and values larger than all bounds
+// are counted under ">" followed by the largest bound. All buckets are
+// present in the result, even if empty; with no bounds, there is a single
+// bucket, ">0". Values are not decoded, and the counts come from a single
+// read transaction.
+//
+//	h, err := store.ValueSizeHistogram(bboltkv.ExponentialBuckets(64, 4, 6))
+//	fmt.Println(h["<=64"], h[">65536"])
+func (s *Store) ValueSizeHistogram(bounds []int64, opts ...OpOption) (map[string]int, error) {
+	o := buildOpOptions(opts)
+	var h map[string]int
+	err := s.view(func(tx *bbolt.Tx) error {
+		h = s.sizeHistogram(tx, bounds, o.prefix)
+		return nil
+	})
+	return h, err
+}
+
+func (s *Store) sizeHistogram(tx *bbolt.Tx, bounds []int64, prefix string) map[string]int {
+	bounds = append([]int64(nil), bounds...)
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	counts := make([]int, len(bounds)+1)
+	c := tx.Bucket(s.bucketName).Cursor()
+	p := []byte(prefix)
+	for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+		if v == nil || s.hidden(tx, k) {
+			continue
+		}
+		size := int64(len(v))
+		counts[sort.Search(len(bounds), func(i int) bool { return bounds[i] >= size })]++
+	}
+	h := make(map[string]int, len(counts))
+	for i, bound := range bounds {
+		h["<="+strconv.FormatInt(bound, 10)] += counts[i]
+	}
+	last := int64(0)
+	if len(bounds) > 0 {
+		last = bounds[len(bounds)-1]
+	}
+	h[">"+strconv.FormatInt(last, 10)] = counts[len(bounds)]
+	return h
+}
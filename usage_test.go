@@ -0,0 +1,156 @@
+package bboltkv
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestUsageEmpty(t *testing.T) {
+	db := openTestStore(t)
+	r, err := db.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Keys != 0 || r.LogicalBytes != 0 || r.Overhead != 0 {
+		t.Fatalf("unexpected report for an empty store: %+v", r)
+	}
+	if r.FileSize <= 0 || r.PageSize <= 0 || r.Pages != int(r.FileSize)/r.PageSize {
+		t.Fatalf("unexpected file figures: %+v", r)
+	}
+}
+
+func TestUsageSmallValues(t *testing.T) {
+	db := openTestStore(t)
+	raw := []byte("0123456789")
+	entries := RawEntries{}
+	for i := 0; i < 10000; i++ {
+		entries[fmt.Sprintf("key%05d", i)] = raw
+	}
+	if err := db.PutAllEncoded(entries); err != nil {
+		t.Fatal(err)
+	}
+	r, err := db.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Keys != 10000 {
+		t.Fatalf("got %d keys, expected 10000", r.Keys)
+	}
+	if want := int64(10000 * (8 + 10)); r.LogicalBytes != want {
+		t.Fatalf("got %d logical bytes, expected %d", r.LogicalBytes, want)
+	}
+	if r.Overhead < 1 {
+		t.Fatalf("overhead %v is below 1", r.Overhead)
+	}
+	h, err := db.PageHistogram()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h["leaf"] < 10000*18/r.PageSize || h["branch"] == 0 {
+		t.Fatalf("unexpected histogram %v", h)
+	}
+	if h["leaf-overflow"] != 0 {
+		t.Fatalf("tiny values used overflow pages: %v", h)
+	}
+}
+
+func TestUsageLargeValue(t *testing.T) {
+	db := openTestStore(t)
+	before, err := db.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := make([]byte, 10*before.PageSize)
+	if err := db.PutEncoded("large", large); err != nil {
+		t.Fatal(err)
+	}
+	r, err := db.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.LogicalBytes != int64(len("large")+len(large)) {
+		t.Fatalf("got %d logical bytes", r.LogicalBytes)
+	}
+	if r.FileSize <= before.FileSize {
+		t.Fatalf("file did not grow: %d -> %d", before.FileSize, r.FileSize)
+	}
+	h, err := db.PageHistogram()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h["leaf-overflow"] < 9 {
+		t.Fatalf("a value of 10 pages used %d overflow pages", h["leaf-overflow"])
+	}
+	// deleting it frees pages
+	if err := db.Delete("large"); err != nil {
+		t.Fatal(err)
+	}
+	after, err := db.Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.FreePages+after.PendingPages <= r.FreePages+r.PendingPages {
+		t.Fatalf("free pages did not grow: %d -> %d", r.FreePages, after.FreePages)
+	}
+	if after.FileSize != r.FileSize {
+		t.Fatalf("file size changed on delete: %d -> %d", r.FileSize, after.FileSize)
+	}
+}
+
+func TestValueSizeHistogram(t *testing.T) {
+	db := openTestStore(t)
+	entries := RawEntries{}
+	for i, size := range []int{1, 64, 65, 256, 1000, 5000} {
+		entries[fmt.Sprintf("a%d", i)] = make([]byte, size)
+	}
+	entries["b0"] = make([]byte, 10)
+	entries["b1"] = make([]byte, 100)
+	if err := db.PutAllEncoded(entries); err != nil {
+		t.Fatal(err)
+	}
+	bounds := ExponentialBuckets(64, 4, 3)
+	h, err := db.ValueSizeHistogram(bounds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"<=64": 3, "<=256": 3, "<=1024": 1, ">1024": 1}
+	if fmt.Sprint(h) != fmt.Sprint(want) {
+		t.Fatalf("got %v, expected %v", h, want)
+	}
+
+	h, err = db.ValueSizeHistogram(bounds, WithPrefix("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]int{"<=64": 1, "<=256": 1, "<=1024": 0, ">1024": 0}
+	if fmt.Sprint(h) != fmt.Sprint(want) {
+		t.Fatalf("got %v, expected %v", h, want)
+	}
+
+	r, err := db.Usage(WithSizeHistogram(bounds), WithPrefix("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = map[string]int{"<=64": 2, "<=256": 2, "<=1024": 1, ">1024": 1}
+	if fmt.Sprint(r.ValueSizes) != fmt.Sprint(want) {
+		t.Fatalf("got %v, expected %v", r.ValueSizes, want)
+	}
+	if r, err := db.Usage(); err != nil || r.ValueSizes != nil {
+		t.Fatalf("got %v, %v without asking", r.ValueSizes, err)
+	}
+}
+
+func TestValueSizeHistogramEmpty(t *testing.T) {
+	db := openTestStore(t)
+	h, err := db.ValueSizeHistogram([]int64{100, 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"<=10": 0, "<=100": 0, ">100": 0}
+	if fmt.Sprint(h) != fmt.Sprint(want) {
+		t.Fatalf("got %v, expected %v", h, want)
+	}
+	if h, err := db.ValueSizeHistogram(nil); err != nil || len(h) != 1 || h[">0"] != 0 {
+		t.Fatalf("got %v, %v", h, err)
+	}
+}
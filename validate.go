@@ -0,0 +1,283 @@
+package bboltkv
+
+import (
+	"encoding"
+	"encoding/gob"
+	"errors"
+	"reflect"
+	"sync"
+)
+
+// WithPutValidator registers a function that vets every value written with
+// Put or PutAll before it is encoded. A non-nil error aborts the write
+// before any transaction begins and is returned to the caller unchanged.
+// The option can be given several times; validators then run in the order
+// given, and the first error wins.
+//
+//	validKey := regexp.MustCompile(`^[a-z]+/[0-9]+$`)
+//	store, err := bboltkv.Open(path, "bucket", bboltkv.WithPutValidator(
+//	    func(key string, value interface{}) error {
+//	        if !validKey.MatchString(key) {
+//	            return fmt.Errorf("bad key %q", key)
+//	        }
+//	        return nil
+//	    }))
+func WithPutValidator(fn func(key string, value interface{}) error) Option {
+	return func(o *options) {
+		o.putValidators = append(o.putValidators, fn)
+	}
+}
+
+// WithPutTransform registers a function that normalizes every value written
+// with Put, PutAll, GetOrPut, GetSet, Update and the other methods encoding
+// values, such as to trim strings or lower-case email addresses. The value
+// it returns is stored in place of the one given, and a non-nil error
+// aborts the write like a validator's. Transforms run before anything else
+// looks at the value: the option can be given several times, and each
+// transform is handed the value the previous one returned, and the last
+// one's is then checked against the schema, see WithSchemaEnforcement,
+// vetted by the WithPutValidator validators, encoded, and vetted by the
+// WithEncodedValidator ones. The value CompareAndPut compares against goes
+// through the transforms too, so that it matches what was stored.
+//
+//	bboltkv.WithPutTransform(func(key string, value interface{}) (interface{}, error) {
+//	    if u, ok := value.(User); ok {
+//	        u.Email = strings.ToLower(strings.TrimSpace(u.Email))
+//	        return u, nil
+//	    }
+//	    return value, nil
+//	})
+func WithPutTransform(fn func(key string, value interface{}) (interface{}, error)) Option {
+	return func(o *options) {
+		o.putTransforms = append(o.putTransforms, fn)
+	}
+}
+
+// WithEncodedValidator registers a function that vets the encoded bytes of
+// every value written with Put, PutAll, PutEncoded or PutAllEncoded. It runs
+// after all WithPutValidator validators, and otherwise behaves the same way.
+// Values written with Put and PutAll have been through the store's
+// transforms by then, such as compression, so the validator sees the bytes
+// as they will be stored.
+//
+//	bboltkv.WithEncodedValidator(func(key string, encoded []byte) error {
+//	    if len(encoded) > 64<<10 {
+//	        return errors.New("value too large")
+//	    }
+//	    return nil
+//	})
+func WithEncodedValidator(fn func(key string, encoded []byte) error) Option {
+	return func(o *options) {
+		o.encodedValidators = append(o.encodedValidators, fn)
+	}
+}
+
+// encodeForPut validates and encodes a value about to be written under key.
+func (s *Store) encodeForPut(key string, value interface{}) ([]byte, error) {
+	if err := s.checkKey(key); err != nil {
+		return nil, err
+	}
+	value, err := s.normalize(key, value)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, ErrBadValue
+	}
+	if s.opts.schemaPuts {
+		if err := s.checkSchema(key, value); err != nil {
+			return nil, err
+		}
+	}
+	for _, fn := range s.opts.putValidators {
+		if err := fn(key, value); err != nil {
+			return nil, err
+		}
+	}
+	raw, err := s.Encode(value)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.runEncodedValidators(key, raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// normalize runs the WithPutTransform transforms on a value about to be
+// written under key.
+func (s *Store) normalize(key string, value interface{}) (interface{}, error) {
+	for _, fn := range s.opts.putTransforms {
+		if value == nil {
+			return nil, ErrBadValue
+		}
+		v, err := fn(key, value)
+		if err != nil {
+			return nil, err
+		}
+		value = v
+	}
+	return value, nil
+}
+
+// validateEncoded validates encoded bytes about to be written under key.
+func (s *Store) validateEncoded(key string, raw []byte) error {
+	if err := s.checkKey(key); err != nil {
+		return err
+	}
+	return s.runEncodedValidators(key, raw)
+}
+
+// runEncodedValidators runs the WithEncodedValidator validators on encoded
+// bytes about to be written under key.
+func (s *Store) runEncodedValidators(key string, raw []byte) error {
+	if len(raw) == 0 {
+		return ErrBadValue
+	}
+	for _, fn := range s.opts.encodedValidators {
+		if err := fn(key, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrUnencodable is matched by the *UnencodableError returned when a value
+// cannot be stored, or could not be read back as it was written.
+var ErrUnencodable = errors.New("bboltkv: value cannot be encoded")
+
+// UnencodableError describes a value that Put, Encode or Validate rejected.
+// It matches ErrUnencodable with errors.Is.
+type UnencodableError struct {
+	Value reflect.Type // type of the value passed in
+	Type  reflect.Type // offending type within it, nil if gob failed for another reason
+	Path  string       // path to the offending part, such as "Owner.Tags[]", empty for the value itself
+	Err   error        // the encoder's error, if it failed
+	what  string
+}
+
+func (e *UnencodableError) Error() string {
+	msg := "bboltkv: cannot encode " + e.Value.String()
+	if e.Path != "" {
+		msg += " at " + e.Path
+	}
+	if e.Err != nil {
+		return msg + ": " + e.Err.Error()
+	}
+	return msg + ": " + e.what
+}
+
+// Is makes the error match ErrUnencodable.
+func (e *UnencodableError) Is(target error) bool {
+	return target == ErrUnencodable
+}
+
+// Unwrap returns the encoder's error, if any.
+func (e *UnencodableError) Unwrap() error {
+	return e.Err
+}
+
+// Validate checks that value can be stored with Put, without writing it,
+// so that values can be vetted where they are built rather than where they
+// are stored. It returns ErrBadValue for nil, and an *UnencodableError for
+// values that gob cannot encode, or would encode with data missing:
+//
+//   - channels and functions, including as struct fields, which gob
+//     otherwise drops;
+//   - structs without exported fields, including empty ones, which gob
+//     otherwise stores as nothing;
+//   - nil pointers, except inside structs, slices and maps.
+//
+// Put, Encode and the other writing methods run the same checks and return
+// the same errors. Values held in interfaces are only checked by encoding
+// them, so their errors carry no path.
+func (s *Store) Validate(value interface{}) error {
+	_, err := s.encodePlain(value)
+	return err
+}
+
+var encodableTypes sync.Map // reflect.Type to *UnencodableError, nil if fine
+
+// checkEncodable checks that gob encodes value without losing data. Only
+// types are checked, and the result is cached, except for a nil pointer at
+// the top, which gob refuses.
+func checkEncodable(value interface{}) error {
+	t := reflect.TypeOf(value)
+	if v := reflect.ValueOf(value); v.Kind() == reflect.Ptr && v.IsNil() {
+		return &UnencodableError{Value: t, Type: t, what: "nil pointer"}
+	}
+	if cached, ok := encodableTypes.Load(t); ok {
+		if e := cached.(*UnencodableError); e != nil {
+			return e
+		}
+		return nil
+	}
+	e := checkType(t, "", map[reflect.Type]bool{})
+	if e != nil {
+		e.Value = t
+	}
+	encodableTypes.Store(t, e)
+	if e != nil {
+		return e
+	}
+	return nil
+}
+
+var (
+	gobEncoderType    = reflect.TypeOf((*gob.GobEncoder)(nil)).Elem()
+	binaryMarshalType = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	textMarshalType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// marshalsItself reports whether gob encodes values of type t with one of
+// their own methods.
+func marshalsItself(t reflect.Type) bool {
+	for _, m := range []reflect.Type{gobEncoderType, binaryMarshalType, textMarshalType} {
+		if t.Implements(m) || reflect.PtrTo(t).Implements(m) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkType(t reflect.Type, path string, seen map[reflect.Type]bool) *UnencodableError {
+	if seen[t] || marshalsItself(t) {
+		return nil
+	}
+	seen[t] = true
+	switch t.Kind() {
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return &UnencodableError{Type: t, Path: path, what: t.String() + " cannot be encoded"}
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		elem := path
+		if t.Kind() != reflect.Ptr {
+			elem += "[]"
+		}
+		return checkType(t.Elem(), elem, seen)
+	case reflect.Map:
+		if e := checkType(t.Key(), path+"[key]", seen); e != nil {
+			return e
+		}
+		return checkType(t.Elem(), path+"[]", seen)
+	case reflect.Struct:
+		exported := 0
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			exported++
+			field := f.Name
+			if path != "" {
+				field = path + "." + f.Name
+			}
+			if e := checkType(f.Type, field, seen); e != nil {
+				return e
+			}
+		}
+		if exported == 0 {
+			return &UnencodableError{Type: t, Path: path, what: t.String() + " has no exported fields"}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package bboltkv
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errBadKey = errors.New("bad key")
+
+func noSpaces(key string, value interface{}) error {
+	if strings.Contains(key, " ") {
+		return errBadKey
+	}
+	return nil
+}
+
+func TestPutValidator(t *testing.T) {
+	db := openTestStore(t, WithPutValidator(noSpaces))
+	if err := db.Put("good", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("not good", "value"); err != errBadKey {
+		t.Fatalf("got %v, expected errBadKey", err)
+	}
+	if err := db.Get("not good", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	// nil values are still rejected first
+	if err := db.Put("not good", nil); err != ErrBadValue {
+		t.Fatalf("got %v, expected ErrBadValue", err)
+	}
+}
+
+func TestEncodedValidator(t *testing.T) {
+	errTooLarge := errors.New("too large")
+	db := openTestStore(t, WithEncodedValidator(func(key string, encoded []byte) error {
+		if len(encoded) > 100 {
+			return errTooLarge
+		}
+		return nil
+	}))
+	if err := db.Put("small", "value"); err != nil {
+		t.Fatal(err)
+	}
+	large := strings.Repeat("x", 200)
+	if err := db.Put("large", large); err != errTooLarge {
+		t.Fatalf("got %v, expected errTooLarge", err)
+	}
+	raw, err := db.Encode(large)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutEncoded("large", raw); err != errTooLarge {
+		t.Fatalf("got %v, expected errTooLarge", err)
+	}
+	if err := db.PutAllEncoded(RawEntries{"large": raw}); err != errTooLarge {
+		t.Fatalf("got %v, expected errTooLarge", err)
+	}
+}
+
+func TestValidatorOrder(t *testing.T) {
+	var calls []string
+	first := errors.New("first")
+	db := openTestStore(t,
+		WithEncodedValidator(func(string, []byte) error {
+			calls = append(calls, "encoded")
+			return nil
+		}),
+		WithPutValidator(func(string, interface{}) error {
+			calls = append(calls, "put1")
+			return nil
+		}),
+		WithPutValidator(func(key string, _ interface{}) error {
+			calls = append(calls, "put2")
+			if key == "reject" {
+				return first
+			}
+			return nil
+		}),
+	)
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(calls, ","); got != "put1,put2,encoded" {
+		t.Fatalf("validators ran as %s", got)
+	}
+	calls = nil
+	if err := db.Put("reject", "value"); err != first {
+		t.Fatalf("got %v, expected first", err)
+	}
+	if got := strings.Join(calls, ","); got != "put1,put2" {
+		t.Fatalf("validators ran as %s", got)
+	}
+}
+
+func TestPutAllValidation(t *testing.T) {
+	db := openTestStore(t, WithPutValidator(noSpaces))
+	err := db.PutAll(map[string]interface{}{
+		"a":     1,
+		"b":     2,
+		"c c":   3,
+		"d":     4,
+		"e":     5,
+		"f f f": 6,
+	})
+	if err != errBadKey {
+		t.Fatalf("got %v, expected errBadKey", err)
+	}
+	for _, k := range []string{"a", "b", "d", "e"} {
+		if err := db.Get(k, nil); err != ErrNotFound {
+			t.Fatalf("%s: got %v, expected ErrNotFound", k, err)
+		}
+	}
+}
+
+// trimmed is a transform trimming string values.
+func trimmed(key string, value interface{}) (interface{}, error) {
+	if s, ok := value.(string); ok {
+		return strings.TrimSpace(s), nil
+	}
+	return value, nil
+}
+
+func TestPutTransform(t *testing.T) {
+	db := openTestStore(t, WithPutTransform(trimmed))
+	if err := db.Put("a", "  ann "); err != nil {
+		t.Fatal(err)
+	}
+	var v string
+	if err := db.Get("a", &v); err != nil || v != "ann" {
+		t.Fatalf("got %q, %v", v, err)
+	}
+	// callers get back the stored value, not the one they gave
+	if err := db.GetOrPut("b", &v, func() (interface{}, error) { return " bob ", nil }); err != nil || v != "bob" {
+		t.Fatalf("GetOrPut got %q, %v", v, err)
+	}
+	if _, err := db.GetSet("a", " cy ", &v); err != nil || v != "ann" {
+		t.Fatalf("GetSet got %q, %v", v, err)
+	}
+	if _, err := db.GetSet("a", "dee", &v); err != nil || v != "cy" {
+		t.Fatalf("GetSet got %q, %v", v, err)
+	}
+	if err := db.CompareAndPut("a", " dee", "eve"); err != nil {
+		t.Fatal(err)
+	}
+	err := db.Update(func(tx *WriteTx) error {
+		return tx.InBucket("test").Put("c", " fay ")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get("c", &v); err != nil || v != "fay" {
+		t.Fatalf("got %q, %v after Update", v, err)
+	}
+}
+
+func TestPutTransformError(t *testing.T) {
+	errEmpty := errors.New("empty")
+	db := openTestStore(t, WithPutTransform(trimmed), WithPutTransform(func(key string, value interface{}) (interface{}, error) {
+		if value == "" {
+			return nil, errEmpty
+		}
+		return value, nil
+	}))
+	if err := db.Put("a", "   "); err != errEmpty {
+		t.Fatalf("got %v, expected errEmpty", err)
+	}
+	if err := db.Get("a", nil); err != ErrNotFound {
+		t.Fatalf("got %v after an aborted Put", err)
+	}
+	// a transform may not make a value nil
+	db = openTestStore(t, WithPutTransform(func(string, interface{}) (interface{}, error) { return nil, nil }))
+	if err := db.Put("a", 1); err != ErrBadValue {
+		t.Fatalf("got %v, expected ErrBadValue", err)
+	}
+}
+
+func TestPutTransformOrder(t *testing.T) {
+	var calls []string
+	db := openTestStore(t,
+		WithEncodedValidator(func(string, []byte) error {
+			calls = append(calls, "encoded")
+			return nil
+		}),
+		WithPutValidator(func(_ string, value interface{}) error {
+			calls = append(calls, "put:"+value.(string))
+			return nil
+		}),
+		WithPutTransform(func(_ string, value interface{}) (interface{}, error) {
+			calls = append(calls, "transform1")
+			return value.(string) + "1", nil
+		}),
+		WithPutTransform(func(_ string, value interface{}) (interface{}, error) {
+			calls = append(calls, "transform2")
+			return value.(string) + "2", nil
+		}),
+	)
+	if err := db.Put("key", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(calls, ","); got != "transform1,transform2,put:v12,encoded" {
+		t.Fatalf("ran as %s", got)
+	}
+}
+
+func TestPutAllTransform(t *testing.T) {
+	var keys []string
+	db := openTestStore(t, WithPutTransform(func(key string, value interface{}) (interface{}, error) {
+		keys = append(keys, key)
+		return strings.ToUpper(value.(string)), nil
+	}))
+	err := db.PutAll(map[string]interface{}{"a": "x", "b": "y", "c": "z"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("transform ran for %q", keys)
+	}
+	for k, want := range map[string]string{"a": "X", "b": "Y", "c": "Z"} {
+		var v string
+		if err := db.Get(k, &v); err != nil || v != want {
+			t.Fatalf("%s: got %q, %v", k, v, err)
+		}
+	}
+}
+
+type withChan struct {
+	Name string
+	Done chan bool
+}
+
+type withFunc struct {
+	Name  string
+	Inner struct{ Hooks []func() }
+}
+
+type unexportedOnly struct {
+	name string
+	age  int
+}
+
+type withPointers struct {
+	Name  string
+	Owner *withPointers
+	Count *int
+}
+
+func TestValidate(t *testing.T) {
+	db := openTestStore(t)
+	for _, c := range []struct {
+		value interface{}
+		path  string
+		msg   string
+	}{
+		{make(chan int), "", "bboltkv: cannot encode chan int: chan int cannot be encoded"},
+		{func() {}, "", "bboltkv: cannot encode func(): func() cannot be encoded"},
+		{withChan{}, "Done", "bboltkv: cannot encode bboltkv.withChan at Done: chan bool cannot be encoded"},
+		{&withFunc{}, "Inner.Hooks[]", "bboltkv: cannot encode *bboltkv.withFunc at Inner.Hooks[]: func() cannot be encoded"},
+		{unexportedOnly{name: "x"}, "", "bboltkv: cannot encode bboltkv.unexportedOnly: bboltkv.unexportedOnly has no exported fields"},
+		{struct{}{}, "", "bboltkv: cannot encode struct {}: struct {} has no exported fields"},
+		{map[string][]unexportedOnly{}, "[][]", "bboltkv: cannot encode map[string][]bboltkv.unexportedOnly at [][]: bboltkv.unexportedOnly has no exported fields"},
+		{(*withPointers)(nil), "", "bboltkv: cannot encode *bboltkv.withPointers: nil pointer"},
+	} {
+		err := db.Validate(c.value)
+		var ue *UnencodableError
+		if !errors.Is(err, ErrUnencodable) || !errors.As(err, &ue) {
+			t.Fatalf("%T: got %v, expected ErrUnencodable", c.value, err)
+		}
+		if ue.Path != c.path || ue.Value != reflect.TypeOf(c.value) || err.Error() != c.msg {
+			t.Fatalf("%T: got %q at %q", c.value, err, ue.Path)
+		}
+		// Put rejects the same values
+		if err := db.Put("key", c.value); err == nil || err.Error() != c.msg {
+			t.Fatalf("%T: put returned %v", c.value, err)
+		}
+	}
+	if err := db.Validate(nil); err != ErrBadValue {
+		t.Fatalf("got %v, expected ErrBadValue", err)
+	}
+	if count, err := db.Count(); err != nil || count != 0 {
+		t.Fatalf("%d keys stored, %v", count, err)
+	}
+
+	// nil pointers inside structs are fine, and recursive types terminate
+	value := withPointers{Name: "a", Owner: &withPointers{Name: "b"}}
+	if err := db.Validate(value); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("key", value); err != nil {
+		t.Fatal(err)
+	}
+	var got withPointers
+	if err := db.Get("key", &got); err != nil || got.Owner.Name != "b" || got.Count != nil {
+		t.Fatalf("got %+v, %v", got, err)
+	}
+
+	// types with their own encoding are not inspected
+	if err := db.Validate(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// values in interfaces are left to gob
+	err := db.Validate([]interface{}{make(chan int)})
+	var ue *UnencodableError
+	if !errors.As(err, &ue) || ue.Err == nil || ue.Path != "" {
+		t.Fatalf("got %v, expected an encoder error", err)
+	}
+}
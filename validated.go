@@ -0,0 +1,113 @@
+package bboltkv
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// defaultValidatedRetries is the number of times UpdateValidated runs its
+// function again after a conflict, unless WithValidatedRetries says
+// otherwise.
+const defaultValidatedRetries = 3
+
+// errStaleReads fails an attempt of UpdateValidated whose reads were
+// overtaken by buffered writes, so that it is rolled back and retried.
+var errStaleReads = errors.New("bboltkv: reads of the transaction are stale")
+
+// WithValidatedRetries sets the number of times UpdateValidated runs its
+// function again after finding that what it read has changed, before it
+// gives up with ErrConflict. The default is 3; 0 makes it give up at the
+// first conflict.
+func WithValidatedRetries(n int) Option {
+	return func(o *options) {
+		o.validatedRetries = &n
+	}
+}
+
+// UpdateValidated is Update, for functions that decide what to write from
+// what they read, making sure that what fn read with Get from the store's
+// bucket is still current when the transaction commits.
+//
+// Update reads the state of the file, and writes made meanwhile wait for
+// the transaction to commit, except for those of Put with WithWriteBuffer:
+// Put returns as soon as its write is buffered, so a value fn read may have
+// been replaced by one that is only flushed after the transaction, which
+// then rests on a stale read. UpdateValidated records a hash of every value
+// fn reads, or that the key was absent, and before committing checks them
+// against the writes buffered since. If one differs, it rolls the
+// transaction back, flushes the buffer and runs fn again, up to the number
+// of times set with WithValidatedRetries, and then fails with an error
+// wrapping ErrConflict. So fn may run more than once, and must not have
+// effects outside the transaction. Without a write buffer, nothing can
+// overtake fn's reads, and UpdateValidated is Update.
+//
+//	err := store.UpdateValidated(func(tx *bboltkv.WriteTx) error {
+//	    var stock int
+//	    if err := tx.InBucket("inventory").Get("stock:42", &stock); err != nil {
+//	        return err
+//	    }
+//	    return tx.InBucket("inventory").Put("reserved:42", stock > 0)
+//	})
+func (s *Store) UpdateValidated(fn func(tx *WriteTx) error) error {
+	if s.wbuf == nil {
+		return s.Update(fn)
+	}
+	retries := defaultValidatedRetries
+	if n := s.opts.validatedRetries; n != nil {
+		retries = *n
+	}
+	for attempt := 0; ; attempt++ {
+		err := s.Update(func(tx *WriteTx) error {
+			tx.reads = make(map[string]readHash)
+			if err := fn(tx); err != nil {
+				return err
+			}
+			return tx.validate()
+		})
+		if !errors.Is(err, errStaleReads) {
+			return err
+		}
+		if attempt == retries {
+			return fmt.Errorf("%w: values read changed on each of %d attempts", ErrConflict, attempt+1)
+		}
+	}
+}
+
+// WriteTxValidated is UpdateValidated: fn is given the WriteTx of Update,
+// the store's read-write transaction.
+func (s *Store) WriteTxValidated(fn func(tx *WriteTx) error) error {
+	return s.UpdateValidated(fn)
+}
+
+// readHash is what UpdateValidated records of a value read.
+type readHash struct {
+	present bool
+	sum     [sha256.Size]byte
+}
+
+func hashRead(raw []byte) readHash {
+	if raw == nil {
+		return readHash{}
+	}
+	return readHash{present: true, sum: sha256.Sum256(raw)}
+}
+
+// read records the value read under key, unless an earlier read did.
+func (tx *WriteTx) read(key string, raw []byte) {
+	if _, ok := tx.reads[key]; !ok {
+		tx.reads[key] = hashRead(raw)
+	}
+}
+
+// validate fails with errStaleReads if a write buffered since the
+// transaction began replaced a value it read.
+func (tx *WriteTx) validate() error {
+	b := tx.root.s.wbuf
+	for key, h := range tx.reads {
+		if raw, ok := b.get(key); ok && hashRead(raw) != h {
+			return errStaleReads
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package bboltkv
+
+import (
+	"errors"
+	"testing"
+)
+
+// incr returns a function for UpdateValidated that stores a+1 under b,
+// counting its calls in attempts and calling during after reading a.
+func incr(attempts *int, during func()) func(tx *WriteTx) error {
+	return func(tx *WriteTx) error {
+		*attempts++
+		b := tx.InBucket("test")
+		var a int
+		if err := b.Get("a", &a); err != nil && err != ErrNotFound {
+			return err
+		}
+		if during != nil {
+			during()
+		}
+		return b.Put("b", a+1)
+	}
+}
+
+func TestUpdateValidatedRetries(t *testing.T) {
+	db := openTestStore(t, WithWriteBuffer(1000, 0))
+	if err := db.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	attempts := 0
+	err := db.UpdateValidated(incr(&attempts, func() {
+		if attempts == 1 {
+			// buffered without waiting for the transaction
+			if err := db.Put("a", 2); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Fatalf("fn ran %d times, expected 2", attempts)
+	}
+	checkValues(t, db, map[string]int{"a": 2, "b": 3})
+
+	// buffering the value read, or writes of other keys, is no conflict
+	attempts = 0
+	err = db.UpdateValidated(incr(&attempts, func() {
+		if err := db.Put("a", 2); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put("c", 1); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 1 {
+		t.Fatalf("fn ran %d times, expected 1", attempts)
+	}
+	checkValues(t, db, map[string]int{"a": 2, "b": 3, "c": 1})
+
+	// nor are the transaction's own writes
+	attempts = 0
+	err = db.UpdateValidated(func(tx *WriteTx) error {
+		attempts++
+		b := tx.InBucket("test")
+		var a int
+		if err := b.Get("a", &a); err != nil {
+			return err
+		}
+		if err := b.Put("a", a+1); err != nil {
+			return err
+		}
+		return b.Get("a", &a)
+	})
+	if err != nil || attempts != 1 {
+		t.Fatalf("fn ran %d times, %v", attempts, err)
+	}
+	checkValues(t, db, map[string]int{"a": 3, "b": 3, "c": 1})
+}
+
+func TestWriteTxValidated(t *testing.T) {
+	db := openTestStore(t, WithWriteBuffer(1000, 0))
+	if err := db.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	attempts := 0
+	err := db.WriteTxValidated(incr(&attempts, func() {
+		if attempts == 1 {
+			if err := db.Put("a", 2); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}))
+	if err != nil || attempts != 2 {
+		t.Fatalf("fn ran %d times, %v", attempts, err)
+	}
+	checkValues(t, db, map[string]int{"a": 2, "b": 3})
+}
+
+func TestUpdateValidatedAbsent(t *testing.T) {
+	db := openTestStore(t, WithWriteBuffer(1000, 0))
+	attempts := 0
+	err := db.UpdateValidated(incr(&attempts, func() {
+		if attempts == 1 {
+			if err := db.Put("a", 5); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Fatalf("fn ran %d times, expected 2", attempts)
+	}
+	checkValues(t, db, map[string]int{"a": 5, "b": 6})
+}
+
+func TestUpdateValidatedExhausted(t *testing.T) {
+	db := openTestStore(t, WithWriteBuffer(1000, 0), WithValidatedRetries(2))
+	if err := db.Put("b", 0); err != nil {
+		t.Fatal(err)
+	}
+	attempts := 0
+	err := db.UpdateValidated(incr(&attempts, func() {
+		if err := db.Put("a", attempts*10); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("got %v, expected ErrConflict", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("fn ran %d times, expected 3", attempts)
+	}
+	// nothing of the attempts was committed
+	checkValues(t, db, map[string]int{"a": 30, "b": 0})
+
+	db = openTestStore(t, WithWriteBuffer(1000, 0), WithValidatedRetries(0))
+	attempts = 0
+	err = db.UpdateValidated(incr(&attempts, func() {
+		if err := db.Put("a", attempts); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	if !errors.Is(err, ErrConflict) || attempts != 1 {
+		t.Fatalf("fn ran %d times, %v", attempts, err)
+	}
+}
+
+func TestUpdateValidatedSync(t *testing.T) {
+	db := openTestStore(t)
+	if err := db.Put("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	attempts := 0
+	if err := db.UpdateValidated(incr(&attempts, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 1 {
+		t.Fatalf("fn ran %d times, expected 1", attempts)
+	}
+	checkValues(t, db, map[string]int{"a": 1, "b": 2})
+
+	// errors of fn are returned as they are, without a retry
+	errFn := errors.New("fn failed")
+	attempts = 0
+	err := db.UpdateValidated(func(tx *WriteTx) error {
+		attempts++
+		if err := tx.InBucket("test").Put("b", 9); err != nil {
+			return err
+		}
+		return errFn
+	})
+	if err != errFn || attempts != 1 {
+		t.Fatalf("fn ran %d times, %v", attempts, err)
+	}
+	checkValues(t, db, map[string]int{"a": 1, "b": 2})
+}
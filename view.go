@@ -0,0 +1,228 @@
+package bboltkv
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.etcd.io/bbolt"
+)
+
+// ErrNoView is returned by GetView and RebuildView for views that have not
+// been created with CreateView.
+var ErrNoView = errors.New("bboltkv: no such view")
+
+// ErrViewExists is returned by CreateView when a view of the same name has
+// already been created.
+var ErrViewExists = errors.New("bboltkv: view already exists")
+
+// ViewReduce folds a change to a source entry into the value of a view
+// entry, see CreateView. existingRaw is the view entry's current value, nil
+// if it has none. eventRaw is the source entry's encoded value: the new one
+// when it is written, and the old one when it is deleted. The returned value
+// replaces the view entry's; returning nil deletes it. The slices passed in
+// are only valid until reduce returns.
+type ViewReduce func(viewKey string, existingRaw []byte, eventKey string, eventRaw []byte, deleted bool) ([]byte, error)
+
+// ViewRoute returns the key of the view entry that a source entry counts
+// towards, or "" if it counts towards none.
+type ViewRoute func(eventKey string, eventRaw []byte) (viewKey string)
+
+type view struct {
+	name   string
+	prefix string
+	reduce ViewReduce
+	route  ViewRoute
+}
+
+// views keeps the views created on the store. n is the number of views,
+// accessed atomically, so that writes need not lock when there are none.
+type views struct {
+	n      int32
+	mu     sync.RWMutex
+	byName map[string]*view
+}
+
+// viewBucket returns the name of the internal bucket holding a view.
+func viewBucket(name string) string {
+	return "view:" + name
+}
+
+// CreateView creates a materialized view of the entries whose keys start
+// with sourcePrefix. Every write to such an entry is folded into the view
+// by reduce, in the same transaction, so the view is always up to date with
+// the entries it derives from; deleting an entry is folded in with deleted
+// set, and overwriting one first deletes the old value, then writes the new
+// one. route picks the view entry each source entry counts towards. If
+// reduce fails, the write it was called for fails with the same error, and
+// nothing is written. reduce and route run inside the write transaction, so
+// they must not call the store's methods, other than Encode and Decode.
+//
+// The view's entries are stored in the database file, but reduce and route
+// are not, so views must be created again each time the store is opened.
+// CreateView rebuilds the view from the source entries, like RebuildView,
+// so that writes made while it did not exist are accounted for.
+//
+//	err := store.CreateView("totals", "order:", addTotals,
+//	    func(key string, raw []byte) string {
+//	        return customerOf(raw)
+//	    })
+//	raw, err := store.GetView("totals", "customer:42")
+func (s *Store) CreateView(name string, sourcePrefix string, reduce ViewReduce, route ViewRoute) error {
+	if err := s.plainKeys(); err != nil {
+		return err
+	}
+	v := &view{name: name, prefix: sourcePrefix, reduce: reduce, route: route}
+	added := false
+	err := s.update(func(w *wtx) error {
+		if err := w.rebuildView(v); err != nil {
+			return err
+		}
+		// Writers run one at a time, so no other write can miss the view
+		// between here and the commit.
+		vs := &s.views
+		vs.mu.Lock()
+		defer vs.mu.Unlock()
+		if vs.byName[name] != nil {
+			return ErrViewExists
+		}
+		if vs.byName == nil {
+			vs.byName = make(map[string]*view)
+		}
+		vs.byName[name] = v
+		atomic.StoreInt32(&vs.n, int32(len(vs.byName)))
+		added = true
+		w.sideEffects = true
+		return nil
+	})
+	if err != nil && added {
+		s.views.mu.Lock()
+		delete(s.views.byName, name)
+		atomic.StoreInt32(&s.views.n, int32(len(s.views.byName)))
+		s.views.mu.Unlock()
+	}
+	return err
+}
+
+// GetView returns a copy of the value of the entry viewKey of the view
+// name. If the view has no such entry, it returns ErrNotFound.
+func (s *Store) GetView(name, viewKey string) ([]byte, error) {
+	if s.getView(name) == nil {
+		return nil, ErrNoView
+	}
+	var raw []byte
+	err := s.view(func(tx *bbolt.Tx) error {
+		var v []byte
+		if b := s.aux(tx, viewBucket(name)); b != nil {
+			v = b.Get([]byte(viewKey))
+		}
+		if v == nil {
+			return ErrNotFound
+		}
+		raw = append([]byte{}, v...)
+		return nil
+	})
+	return raw, err
+}
+
+// RebuildView recomputes the view name from scratch, by folding every
+// source entry into an empty view.
+func (s *Store) RebuildView(name string) error {
+	v := s.getView(name)
+	if v == nil {
+		return ErrNoView
+	}
+	return s.update(func(w *wtx) error {
+		return w.rebuildView(v)
+	})
+}
+
+func (s *Store) getView(name string) *view {
+	s.views.mu.RLock()
+	defer s.views.mu.RUnlock()
+	return s.views.byName[name]
+}
+
+// rebuildView empties the view and folds every source entry into it.
+func (w *wtx) rebuildView(v *view) error {
+	name := w.s.auxName(viewBucket(v.name))
+	if w.tx.Bucket(name) != nil {
+		if err := w.tx.DeleteBucket(name); err != nil {
+			return err
+		}
+	}
+	vb, err := w.aux(viewBucket(v.name))
+	if err != nil {
+		return err
+	}
+	p := []byte(v.prefix)
+	c := w.b.Cursor()
+	for k, raw := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, raw = c.Next() {
+		raw, err := w.s.assemble(w.tx, k, raw)
+		if err != nil {
+			return err
+		}
+		if err := foldView(vb, v, string(k), raw, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateViews folds a write to key into the views on it, before it is
+// made. raw is the value being written, nil for deletes.
+func (w *wtx) updateViews(key string, raw []byte) error {
+	if atomic.LoadInt32(&w.s.views.n) == 0 {
+		return nil
+	}
+	w.s.views.mu.RLock()
+	defer w.s.views.mu.RUnlock()
+	var old []byte
+	loaded := false
+	for _, v := range w.s.views.byName {
+		if !strings.HasPrefix(key, v.prefix) {
+			continue
+		}
+		if !loaded {
+			var err error
+			if old, err = w.s.assemble(w.tx, []byte(key), w.b.Get([]byte(key))); err != nil {
+				return err
+			}
+			loaded = true
+		}
+		vb, err := w.aux(viewBucket(v.name))
+		if err != nil {
+			return err
+		}
+		if old != nil {
+			if err := foldView(vb, v, key, old, true); err != nil {
+				return err
+			}
+		}
+		if raw != nil {
+			if err := foldView(vb, v, key, raw, false); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// foldView passes one change to a source entry through the view's reduce.
+func foldView(vb *bbolt.Bucket, v *view, key string, raw []byte, deleted bool) error {
+	viewKey := v.route(key, raw)
+	if viewKey == "" {
+		return nil
+	}
+	out, err := v.reduce(viewKey, vb.Get([]byte(viewKey)), key, raw, deleted)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return vb.Delete([]byte(viewKey))
+	}
+	// out may point into the database's memory
+	return vb.Put([]byte(viewKey), append([]byte(nil), out...))
+}
@@ -0,0 +1,171 @@
+package bboltkv
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type order struct {
+	Customer string
+	Total    int
+}
+
+// viewAgg keeps per customer order totals, or order counts.
+func viewAgg(db *Store, count bool) ViewReduce {
+	return func(viewKey string, existing []byte, eventKey string, eventRaw []byte, deleted bool) ([]byte, error) {
+		var sum int
+		if existing != nil {
+			if err := db.Decode(existing, &sum); err != nil {
+				return nil, err
+			}
+		}
+		var o order
+		if err := db.Decode(eventRaw, &o); err != nil {
+			return nil, err
+		}
+		if o.Total < 0 {
+			return nil, errors.New("negative total")
+		}
+		n := o.Total
+		if count {
+			n = 1
+		}
+		if deleted {
+			n = -n
+		}
+		if sum += n; sum == 0 {
+			return nil, nil
+		}
+		return db.Encode(sum)
+	}
+}
+
+func viewRoute(db *Store) ViewRoute {
+	return func(eventKey string, eventRaw []byte) string {
+		var o order
+		if db.Decode(eventRaw, &o) != nil {
+			return ""
+		}
+		return o.Customer
+	}
+}
+
+func viewContents(t *testing.T, db *Store, name string) map[string]int {
+	t.Helper()
+	out := map[string]int{}
+	for _, c := range []string{"alice", "bob", "carol"} {
+		raw, err := db.GetView(name, c)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		if err := db.Decode(raw, &n); err != nil {
+			t.Fatal(err)
+		}
+		out[c] = n
+	}
+	return out
+}
+
+func TestView(t *testing.T) {
+	db := openTestStore(t)
+	if err := db.Put("order:1", order{"alice", 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateView("totals", "order:", viewAgg(db, false), viewRoute(db)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateView("totals", "order:", viewAgg(db, false), viewRoute(db)); err != ErrViewExists {
+		t.Fatalf("got %v, expected ErrViewExists", err)
+	}
+	if err := db.CreateView("counts", "order:", viewAgg(db, true), viewRoute(db)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetView("other", "alice"); err != ErrNoView {
+		t.Fatalf("got %v, expected ErrNoView", err)
+	}
+	err := db.PutAll(map[string]interface{}{
+		"order:2": order{"bob", 5},
+		"order:3": order{"alice", 7},
+		"order:4": order{"carol", 1},
+		"other:1": order{"alice", 100},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// overwrites move the order, deletes retract it
+	if err := db.Put("order:2", order{"alice", 6}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("order:4"); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"alice": 23}
+	if got := viewContents(t, db, "totals"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got totals %v, expected %v", got, want)
+	}
+	if got := viewContents(t, db, "counts"); !reflect.DeepEqual(got, map[string]int{"alice": 3}) {
+		t.Fatalf("got counts %v", got)
+	}
+	if _, err := db.DeletePrefix("order:3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("order:5", order{"bob", 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the incremental result matches a rebuild
+	incremental := viewContents(t, db, "totals")
+	if err := db.RebuildView("totals"); err != nil {
+		t.Fatal(err)
+	}
+	if got := viewContents(t, db, "totals"); !reflect.DeepEqual(got, incremental) || !reflect.DeepEqual(got, map[string]int{"alice": 16, "bob": 2}) {
+		t.Fatalf("rebuilt %v, incremental %v", got, incremental)
+	}
+	if err := db.RebuildView("other"); err != ErrNoView {
+		t.Fatalf("got %v, expected ErrNoView", err)
+	}
+}
+
+func TestViewReduceFails(t *testing.T) {
+	db := openTestStore(t)
+	if err := db.CreateView("totals", "order:", viewAgg(db, false), viewRoute(db)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("order:1", order{"alice", 10}); err != nil {
+		t.Fatal(err)
+	}
+	err := db.PutAll(map[string]interface{}{"order:2": order{"bob", 1}, "order:3": order{"bob", -1}})
+	if err == nil || !strings.Contains(err.Error(), "negative total") {
+		t.Fatalf("got %v, expected reduce's error", err)
+	}
+	if err := db.Put("order:1", order{"alice", -1}); err == nil {
+		t.Fatal("write succeeded despite reduce failing")
+	}
+	// neither the source entries nor the view changed
+	if keys, err := db.Keys(); err != nil || !reflect.DeepEqual(keys, []string{"order:1"}) {
+		t.Fatalf("got keys %v, %v", keys, err)
+	}
+	var o order
+	if err := db.Get("order:1", &o); err != nil || o.Total != 10 {
+		t.Fatalf("got %+v, %v", o, err)
+	}
+	if got := viewContents(t, db, "totals"); !reflect.DeepEqual(got, map[string]int{"alice": 10}) {
+		t.Fatalf("got totals %v", got)
+	}
+
+	// a failing rebuild does not create the view
+	if err := db.Put("refund:1", order{"carol", -5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateView("broken", "refund:", viewAgg(db, false), viewRoute(db)); err == nil {
+		t.Fatal("created a view whose rebuild failed")
+	}
+	if _, err := db.GetView("broken", "alice"); err != ErrNoView {
+		t.Fatalf("got %v, expected ErrNoView", err)
+	}
+}
@@ -0,0 +1,254 @@
+package bboltkv
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+
+	"go.etcd.io/bbolt"
+)
+
+// ErrNoChangeLog is returned by methods that need the change log when the
+// store was opened without WithChangeLog.
+var ErrNoChangeLog = errors.New("bboltkv: change log not enabled")
+
+// stateChunk is the number of entries WatchState replays per transaction.
+const stateChunk = 1000
+
+// StateEvent is an event delivered by WatchState.
+type StateEvent struct {
+	Key     string
+	Value   interface{} // as returned by newValue, decoded; nil for deletions and on errors
+	Deleted bool
+	Initial bool  // the entry is replayed, rather than changed after the watch began
+	Err     error // decoding the value failed, or, with no Key, the watch ended
+}
+
+// replayed is a chunk of entries WatchState replayed: those after the
+// chunk before, up to last, or all the rest if last is nil, as they were
+// once seq changes had been logged.
+type replayed struct {
+	last []byte
+	seq  uint64
+}
+
+// WatchState streams the current state of the entries whose keys start
+// with prefix, followed by their changes: first every entry there is, in
+// key order, with Initial set, then every Put and Delete as it is
+// committed, in the order of the change log. Values are decoded into what
+// newValue returns, which must be a pointer, as given to Get. A value that
+// fails to decode is delivered with Err set and no Value, and the watch
+// goes on.
+//
+// The live changes follow on from the replay without gap or duplicate:
+// each chunk of the replay is read in a transaction of its own, along with
+// the position of the change log in it, and a change is only delivered if
+// the chunk holding its key was read before it was logged. The entries are
+// therefore not replayed as they stood at a single point in time, but
+// applying the events in order always gives the store's state as of the
+// last change delivered. Replaying reads 1000 entries per transaction,
+// like ForEachChunked, so that the replay of a large prefix is neither held
+// in memory nor in a long-running transaction.
+//
+// The store must be opened with WithChangeLog, or WatchState returns
+// ErrNoChangeLog. The watch goes on until cancel is called or the store is
+// closed, and then closes the channel. If the changes it has yet to deliver
+// are trimmed from the log, as TrimChangeLog or WithJournalRetention may
+// do, or reading them fails, it delivers an event with just Err set, such
+// as ErrLogTrimmed, before closing the channel.
+//
+//	events, cancel, err := store.WatchState("user:", func() interface{} { return new(User) })
+//	if err != nil {
+//	    return err
+//	}
+//	defer cancel()
+//	for ev := range events {
+//	    if ev.Deleted {
+//	        ui.Remove(ev.Key)
+//	    } else if ev.Err == nil {
+//	        ui.Show(ev.Key, ev.Value.(*User))
+//	    }
+//	}
+func (s *Store) WatchState(prefix string, newValue func() interface{}) (<-chan StateEvent, func(), error) {
+	if !s.opts.changeLog {
+		return nil, nil, ErrNoChangeLog
+	}
+	sealed, err := s.sealPrefix([]byte(prefix))
+	if err != nil {
+		return nil, nil, err
+	}
+	// the first chunk is read before returning, so that the watch covers
+	// all writes made after it
+	entries, seq, err := s.replayChunk(sealed, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	w := &stateWatch{
+		s:        s,
+		prefix:   sealed,
+		newValue: newValue,
+		out:      make(chan StateEvent),
+		stop:     make(chan struct{}),
+	}
+	var once sync.Once
+	cancel := func() { once.Do(func() { close(w.stop) }) }
+	go w.run(entries, seq)
+	return w.out, cancel, nil
+}
+
+// stateWatch is a watch of WatchState.
+type stateWatch struct {
+	s        *Store
+	prefix   []byte // the stored names of the keys watched start with it
+	newValue func() interface{}
+	out      chan StateEvent
+	stop     chan struct{}
+	chunks   []replayed
+}
+
+// run delivers the events of the watch, starting with the first chunk of
+// the replay, read once seq changes had been logged.
+func (w *stateWatch) run(entries []Change, seq uint64) {
+	defer close(w.out)
+	since := seq
+	for {
+		for _, e := range entries {
+			if !w.send(w.event(e, true)) {
+				return
+			}
+		}
+		if len(entries) < stateChunk {
+			w.chunks = append(w.chunks, replayed{nil, seq})
+			break
+		}
+		last := []byte(entries[len(entries)-1].Key)
+		w.chunks = append(w.chunks, replayed{last, seq})
+		var err error
+		if entries, seq, err = w.s.replayChunk(w.prefix, last); err != nil {
+			w.fail(err)
+			return
+		}
+	}
+	w.follow(since)
+}
+
+// send delivers ev, and reports whether the watch goes on.
+func (w *stateWatch) send(ev StateEvent) bool {
+	select {
+	case w.out <- ev:
+		return true
+	case <-w.stop:
+	case <-w.s.done:
+	}
+	return false
+}
+
+// fail delivers the error that ends the watch, unless the store is closing.
+func (w *stateWatch) fail(err error) {
+	if err != ErrClosed {
+		w.send(StateEvent{Err: err})
+	}
+}
+
+// event returns the event for an entry, or a change, whose key is its
+// stored name.
+func (w *stateWatch) event(c Change, initial bool) StateEvent {
+	ev := StateEvent{Deleted: c.Deleted, Initial: initial}
+	var err error
+	if ev.Key, err = w.s.openKey([]byte(c.Key)); err != nil {
+		ev.Err = err
+		return ev
+	}
+	if c.Deleted {
+		return ev
+	}
+	v := w.newValue()
+	if ev.Err = w.s.decode(c.Value, v); ev.Err == nil {
+		ev.Value = v
+	}
+	return ev
+}
+
+// replayedAt returns the number of changes that had been logged when the
+// entry stored under k was replayed, or would have been had it been there.
+func (w *stateWatch) replayedAt(k []byte) uint64 {
+	i := sort.Search(len(w.chunks), func(i int) bool {
+		return w.chunks[i].last == nil || bytes.Compare(k, w.chunks[i].last) <= 0
+	})
+	return w.chunks[i].seq
+}
+
+// follow delivers the changes logged after since to the keys watched,
+// leaving out those the replay already reflects.
+func (w *stateWatch) follow(since uint64) {
+	s := w.s
+	through := w.chunks[len(w.chunks)-1].seq
+	for {
+		// taken before reading, so that no change logged in between is missed
+		changed := s.logWaits.waitFor(changeLogBucket)
+		var changes []Change
+		err := s.view(func(tx *bbolt.Tx) error {
+			return s.changes(tx, since, topicBatch, func(c Change) error {
+				changes = append(changes, c)
+				return nil
+			})
+		})
+		if err != nil {
+			w.fail(err)
+			return
+		}
+		for _, c := range changes {
+			since = c.Seq
+			k := []byte(c.Key)
+			if !bytes.HasPrefix(k, w.prefix) || (c.Seq <= through && c.Seq <= w.replayedAt(k)) {
+				continue
+			}
+			if !w.send(w.event(c, false)) {
+				return
+			}
+		}
+		if len(changes) == topicBatch {
+			continue
+		}
+		select {
+		case <-changed:
+		case <-w.stop:
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// replayChunk reads up to stateChunk entries whose stored names start with
+// prefix, after the one stored under after, or from the first if after is
+// nil, and returns them under their stored names, with the sequence number
+// of the last change logged.
+func (s *Store) replayChunk(prefix, after []byte) ([]Change, uint64, error) {
+	var entries []Change
+	var seq uint64
+	err := s.view(func(tx *bbolt.Tx) error {
+		seq, _ = s.changeLogState(tx)
+		c := tx.Bucket(s.bucketName).Cursor()
+		k, v := c.Seek(prefix)
+		if after != nil {
+			k, v = c.Seek(after)
+			if k != nil && bytes.Equal(k, after) {
+				k, v = c.Next()
+			}
+		}
+		for ; k != nil && bytes.HasPrefix(k, prefix) && len(entries) < stateChunk; k, v = c.Next() {
+			if s.hidden(tx, k) {
+				continue
+			}
+			raw, err := s.assemble(tx, k, v)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, Change{Key: string(k), Value: append([]byte(nil), raw...)})
+		}
+		return nil
+	})
+	return entries, seq, err
+}
@@ -0,0 +1,322 @@
+package bboltkv
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func newInt() interface{} { return new(int) }
+
+// nextState reads the next event from events, failing the test if none
+// arrives in time or the channel is closed.
+func nextState(t *testing.T, events <-chan StateEvent) StateEvent {
+	t.Helper()
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatal("channel closed")
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+	return StateEvent{}
+}
+
+// describe returns ev as key=value, key deleted, or key: error, with a *
+// for initial events.
+func describe(ev StateEvent) string {
+	mark := ""
+	if ev.Initial {
+		mark = "*"
+	}
+	switch {
+	case ev.Err != nil:
+		return fmt.Sprintf("%s%s: %v", mark, ev.Key, ev.Err)
+	case ev.Deleted:
+		return fmt.Sprintf("%s%s deleted", mark, ev.Key)
+	}
+	return fmt.Sprintf("%s%s=%d", mark, ev.Key, *ev.Value.(*int))
+}
+
+func TestWatchState(t *testing.T) {
+	db := openTestStore(t, WithChangeLog())
+	if err := db.PutAll(map[string]interface{}{"user:2": 2, "user:1": 1, "other": 0, "user:3": 3}); err != nil {
+		t.Fatal(err)
+	}
+	events, cancel, err := db.WatchState("user:", newInt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	if err := db.Put("user:4", 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("other", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete("user:1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("user:2", 20); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for i := 0; i < 6; i++ {
+		got = append(got, describe(nextState(t, events)))
+	}
+	if want := "[*user:1=1 *user:2=2 *user:3=3 user:4=4 user:1 deleted user:2=20]"; fmt.Sprint(got) != want {
+		t.Fatalf("got %v, expected %s", got, want)
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected event %s", describe(ev))
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if _, _, err := openTestStore(t).WatchState("", newInt); err != ErrNoChangeLog {
+		t.Fatalf("got %v without a change log", err)
+	}
+}
+
+func TestWatchStateNoGap(t *testing.T) {
+	db := openTestStore(t, WithChangeLog())
+	// the keys hold versions counting up from 1, and the replay spans
+	// several chunks
+	const keys = 2 * stateChunk
+	initial := map[string]interface{}{}
+	for i := 0; i < keys; i += 2 {
+		initial[keyN(i)] = 1
+	}
+	if err := db.PutAll(initial); err != nil {
+		t.Fatal(err)
+	}
+	versions := make([]int, keys)
+	for i := 0; i < keys; i += 2 {
+		versions[i] = 1
+	}
+	write := func(n int, r *rand.Rand) error {
+		for ; n > 0; n-- {
+			i := r.Intn(keys)
+			versions[i]++
+			if err := db.Put(keyN(i), versions[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	r := rand.New(rand.NewSource(1))
+	if err := write(200, r); err != nil {
+		t.Fatal(err)
+	}
+	// the writes go on while the watch begins and replays
+	done := make(chan error, 1)
+	go func() { done <- write(3000, r) }()
+	events, cancel, err := db.WatchState("k", newInt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	seen := map[string]int{}
+	replaying := true
+	check := func(ev StateEvent) {
+		t.Helper()
+		if ev.Err != nil || ev.Deleted {
+			t.Fatalf("got %s", describe(ev))
+		}
+		if ev.Initial && !replaying {
+			t.Fatalf("initial %s after live events", describe(ev))
+		}
+		replaying = ev.Initial
+		v := *ev.Value.(*int)
+		prev, ok := seen[ev.Key]
+		if ev.Initial && ok {
+			t.Fatalf("%s replayed twice", ev.Key)
+		}
+		if !ev.Initial && v != prev+1 {
+			t.Fatalf("%s went from %d to %d", ev.Key, prev, v)
+		}
+		seen[ev.Key] = v
+	}
+	var writeErr error
+	for done != nil {
+		select {
+		case ev := <-events:
+			check(ev)
+		case writeErr = <-done:
+			done = nil
+		}
+	}
+	if writeErr != nil {
+		t.Fatal(writeErr)
+	}
+	final := func() bool {
+		for i, v := range versions {
+			if seen[keyN(i)] != v {
+				return false
+			}
+		}
+		return true
+	}
+	for !final() {
+		check(nextState(t, events))
+	}
+}
+
+func TestWatchStateDecodeErrors(t *testing.T) {
+	db := openTestStore(t, WithChangeLog())
+	if err := db.PutAll(map[string]interface{}{"a": 1, "b": "one", "c": 3}); err != nil {
+		t.Fatal(err)
+	}
+	events, cancel, err := db.WatchState("", newInt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	if err := db.Put("d", "four"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("e", 5); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"*a=1", "*b", "*c=3", "d", "e=5"} {
+		ev := nextState(t, events)
+		if got := describe(ev); !bytes.HasPrefix([]byte(got), []byte(want)) {
+			t.Fatalf("event %d: got %s, expected %s", i, got, want)
+		}
+		if (ev.Err != nil) != (i == 1 || i == 3) || (ev.Err != nil && ev.Value != nil) {
+			t.Fatalf("event %d: got %s", i, describe(ev))
+		}
+	}
+}
+
+func TestWatchStateTrimmed(t *testing.T) {
+	db := openTestStore(t, WithChangeLog())
+	if err := db.PutAll(map[string]interface{}{"a": 1, "b": 2}); err != nil {
+		t.Fatal(err)
+	}
+	events, cancel, err := db.WatchState("", newInt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	// the watch waits to hand over its first event, so the change it has
+	// yet to deliver is trimmed away before it gets to it
+	if err := db.Put("c", 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.TrimChangeLog(math.MaxUint64); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for ev := range events {
+		got = append(got, describe(ev))
+	}
+	if want := fmt.Sprintf("[*a=1 *b=2 : %v]", ErrLogTrimmed); fmt.Sprint(got) != want {
+		t.Fatalf("got %v, expected %s", got, want)
+	}
+}
+
+func TestWatchStateCancel(t *testing.T) {
+	db := openTestStore(t, WithChangeLog())
+	if err := db.PutAll(map[string]interface{}{"a": 1, "b": 2}); err != nil {
+		t.Fatal(err)
+	}
+	for _, live := range []bool{false, true} {
+		events, cancel, err := db.WatchState("", newInt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nextState(t, events)
+		if live {
+			nextState(t, events)
+		}
+		cancel()
+		cancel()
+		// the channel closes, after at most the event being handed over
+		closed := make(chan struct{})
+		go func() {
+			for range events {
+			}
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("channel not closed after cancel")
+		}
+	}
+
+	// closing the store ends watches too
+	events, _, err := db.WatchState("", newInt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextState(t, events)
+	nextState(t, events)
+	db.Close()
+	select {
+	case ev, ok := <-events:
+		if ok {
+			t.Fatalf("got %s after Close", describe(ev))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("channel not closed after Close")
+	}
+	if _, _, err := db.WatchState("", newInt); err != ErrClosed {
+		t.Fatalf("got %v after Close", err)
+	}
+}
+
+func TestWatchStateMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("replays 50k entries")
+	}
+	const n = 50000
+	db := openTestStore(t, WithChangeLog())
+	value := bytes.Repeat([]byte("v"), 1000)
+	entries := map[string]interface{}{}
+	for i := 0; i < n; i++ {
+		entries[fmt.Sprintf("user:%06d", i)] = value
+		if len(entries) == 5000 {
+			if err := db.PutAll(entries); err != nil {
+				t.Fatal(err)
+			}
+			entries = map[string]interface{}{}
+		}
+	}
+	var m runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	base := m.HeapAlloc
+	events, cancel, err := db.WatchState("user:", func() interface{} { return new([]byte) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	var peak uint64
+	for i := 0; i < n; i++ {
+		if ev := nextState(t, events); ev.Err != nil || !ev.Initial {
+			t.Fatalf("event %d: %+v", i, ev)
+		}
+		// what the watch holds on to, rather than the garbage it leaves
+		if i%5000 == 2500 {
+			runtime.GC()
+			runtime.ReadMemStats(&m)
+			if m.HeapAlloc > base && m.HeapAlloc-base > peak {
+				peak = m.HeapAlloc - base
+			}
+		}
+	}
+	size := uint64(n * len(value))
+	t.Logf("%d bytes replayed, heap grew by %d at most", size, peak)
+	if peak > size/5 {
+		t.Fatalf("heap grew by %d bytes for %d bytes of entries", peak, size)
+	}
+}
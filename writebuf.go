@@ -0,0 +1,245 @@
+package bboltkv
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WithWriteBuffer makes Put buffer its writes in memory rather than
+// writing each one in a transaction of its own. Buffered writes are
+// flushed to the file together, in a single transaction, once maxEntries
+// keys are waiting, once the oldest of them has waited for maxDelay, when
+// Flush is called, and when the store is closed. A non-positive maxEntries
+// or maxDelay disables the respective trigger.
+//
+// Put still encodes and validates its value right away, and reads see
+// buffered writes as if they had been flushed: Get looks in the buffer
+// first, and all other methods flush the buffer before they read or write.
+// Only Put itself is buffered.
+//
+// Writes still in the buffer are lost if the process dies. When a flush
+// fails its writes are discarded. If it was started by Put, Flush or Close,
+// its error is returned from there; errors of flushes started after
+// maxDelay are passed to the callback set with WithFlushErrorCallback, and
+// returned by the next call to Flush or Close.
+func WithWriteBuffer(maxEntries int, maxDelay time.Duration) Option {
+	return func(o *options) {
+		o.writeBuffer = true
+		o.bufferEntries = maxEntries
+		o.bufferDelay = maxDelay
+	}
+}
+
+// WithFlushErrorCallback sets a function that is called with the error of
+// every failed flush of the write buffer that was started because of
+// maxDelay, see WithWriteBuffer. It runs on the goroutine doing the flush,
+// and must not close the store.
+func WithFlushErrorCallback(callback func(err error)) Option {
+	return func(o *options) {
+		o.flushCallback = callback
+	}
+}
+
+// writeBuffer holds the writes that Put has not made to the file yet.
+type writeBuffer struct {
+	max  int
+	wake chan struct{} // signalled when the buffer stops being empty
+
+	// flushMu is held for the whole of a flush, so that flushes commit in
+	// the order their writes were made.
+	flushMu sync.Mutex
+
+	mu       sync.Mutex
+	pending  map[string][]byte
+	flushing map[string][]byte // writes of the flush in progress
+	err      error             // first unreported error of a delayed flush
+
+	// seq is the last sequence number handed out by PutSeq, and
+	// pendingSeq the highest of them among the pending writes.
+	seq, pendingSeq uint64
+}
+
+func newWriteBuffer(max int) *writeBuffer {
+	return &writeBuffer{
+		max:     max,
+		wake:    make(chan struct{}, 1),
+		pending: make(map[string][]byte),
+	}
+}
+
+// add buffers a write and reports whether the buffer is now full.
+func (b *writeBuffer) add(key string, raw []byte) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.addLocked(key, raw)
+}
+
+func (b *writeBuffer) addLocked(key string, raw []byte) bool {
+	b.pending[key] = raw
+	if len(b.pending) == 1 {
+		select {
+		case b.wake <- struct{}{}:
+		default:
+		}
+	}
+	return b.max > 0 && len(b.pending) >= b.max
+}
+
+// addSeq buffers a write of PutSeq, and returns its sequence number and
+// whether the buffer is now full.
+func (b *writeBuffer) addSeq(key string, raw []byte, seqs *commitSeqs) (uint64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	b.pendingSeq = b.seq
+	seqs.issue(b.seq)
+	return b.seq, b.addLocked(key, raw)
+}
+
+// get returns the buffered value for key, if there is one.
+func (b *writeBuffer) get(key string) ([]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if raw, ok := b.pending[key]; ok {
+		return raw, true
+	}
+	raw, ok := b.flushing[key]
+	return raw, ok
+}
+
+func (b *writeBuffer) report(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+func (b *writeBuffer) takeErr() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err := b.err
+	b.err = nil
+	return err
+}
+
+// putBuffered implements Put for a store with a write buffer.
+func (s *Store) putBuffered(key string, raw []byte) error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.gate.exit()
+	if atomic.LoadInt32(&s.readOnly) != 0 {
+		return ErrReadOnly
+	}
+	if err := s.checkMutable(key); err != nil {
+		return err
+	}
+	if s.wbuf.add(key, raw) {
+		return s.flushBuffer()
+	}
+	return nil
+}
+
+// Flush writes all buffered writes to the file, see WithWriteBuffer. If
+// the flush succeeds but an earlier, delayed flush failed, it returns the
+// error of the earlier flush. Without a write buffer, Flush does nothing.
+func (s *Store) Flush() error {
+	if err := s.enter(); err != nil {
+		return err
+	}
+	defer s.gate.exit()
+	if s.wbuf == nil {
+		return nil
+	}
+	if err := s.flushBuffer(); err != nil {
+		return err
+	}
+	return s.wbuf.takeErr()
+}
+
+// flushBuffer writes the buffered writes to the file in one transaction.
+// The caller is responsible for the closed check.
+func (s *Store) flushBuffer() error {
+	b := s.wbuf
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	b.mu.Lock()
+	entries := b.pending
+	if len(entries) == 0 {
+		b.mu.Unlock()
+		return nil
+	}
+	b.pending = make(map[string][]byte)
+	b.flushing = entries
+	seq := b.pendingSeq
+	b.pendingSeq = 0
+	b.mu.Unlock()
+
+	var err error
+	if atomic.LoadInt32(&s.readOnly) != 0 {
+		err = ErrReadOnly
+	} else {
+		keys := make([]string, 0, len(entries))
+		for key := range entries {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		err = s.retry(context.Background(), func(w *wtx) error {
+			for _, key := range keys {
+				if err := w.put(key, entries[key]); err != nil {
+					return err
+				}
+			}
+			if seq == 0 {
+				return nil
+			}
+			c, err := w.aux(commitsBucket)
+			if err != nil {
+				return err
+			}
+			return c.SetSequence(seq)
+		}, s.write)
+	}
+	if seq != 0 {
+		if err == nil {
+			s.seqs.synced(seq)
+		} else {
+			s.seqs.failed(seq, err)
+		}
+	}
+	b.mu.Lock()
+	b.flushing = nil
+	b.mu.Unlock()
+	return err
+}
+
+// flushLoop flushes the write buffer maxDelay after it stops being empty.
+func (s *Store) flushLoop(done <-chan struct{}) {
+	for {
+		select {
+		case <-s.wbuf.wake:
+		case <-done:
+			return
+		}
+		select {
+		case <-s.clock().After(s.opts.bufferDelay):
+		case <-done:
+			return
+		}
+		if !s.gate.enter() {
+			return
+		}
+		err := s.flushBuffer()
+		s.gate.exit()
+		if err != nil {
+			s.wbuf.report(err)
+			if s.opts.flushCallback != nil {
+				s.opts.flushCallback(err)
+			}
+		}
+	}
+}
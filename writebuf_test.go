@@ -0,0 +1,188 @@
+package bboltkv
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/unknownnf/bboltkv/testutil"
+	"go.etcd.io/bbolt"
+)
+
+// inFile reports whether key has been written to the file.
+func inFile(t *testing.T, db *Store, key string) bool {
+	t.Helper()
+	var found bool
+	err := db.GetDb().View(func(tx *bbolt.Tx) error {
+		found = tx.Bucket(db.GetBucketName()).Get([]byte(key)) != nil
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+func TestWriteBufferReadYourWrites(t *testing.T) {
+	for _, opts := range [][]Option{
+		{WithWriteBuffer(0, 0)},
+		{WithWriteBuffer(0, 0), WithReadCache(10)},
+	} {
+		db := openTestStore(t, opts...)
+		if err := db.Put("key", "value"); err != nil {
+			t.Fatal(err)
+		}
+		if inFile(t, db, "key") {
+			t.Fatal("put was not buffered")
+		}
+		var val string
+		if err := db.Get("key", &val); err != nil || val != "value" {
+			t.Fatalf("got %q, %v, expected the buffered value", val, err)
+		}
+		// other methods flush first
+		if names, err := db.ListNamespaces("", 'e'); err != nil || len(names) != 1 {
+			t.Fatalf("got %v, %v", names, err)
+		}
+		if !inFile(t, db, "key") {
+			t.Fatal("read did not flush the buffer")
+		}
+		if err := db.Put("key", "other"); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.DeleteGet("key", &val); err != nil || val != "other" {
+			t.Fatalf("got %q, %v, expected the buffered value", val, err)
+		}
+		if err := db.Get("key", &val); err != ErrNotFound {
+			t.Fatalf("got %v, expected ErrNotFound", err)
+		}
+	}
+}
+
+func TestWriteBufferOrder(t *testing.T) {
+	db := openTestStore(t, WithWriteBuffer(7, 0))
+	for i := 0; i < 100; i++ {
+		if err := db.Put("counter", i); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put(fmt.Sprintf("key%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+		var val int
+		if err := db.Get("counter", &val); err != nil || val != i {
+			t.Fatalf("got %d, %v, expected %d", val, err, i)
+		}
+	}
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var val int
+	if err := db.Get("counter", &val); err != nil || val != 99 {
+		t.Fatalf("got %d, %v, expected 99", val, err)
+	}
+}
+
+func TestWriteBufferFlushOnSize(t *testing.T) {
+	db := openTestStore(t, WithWriteBuffer(3, 0))
+	for i := 0; i < 3; i++ {
+		if inFile(t, db, "key0") {
+			t.Fatalf("flushed after %d puts", i)
+		}
+		if err := db.Put(fmt.Sprintf("key%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if !inFile(t, db, fmt.Sprintf("key%d", i)) {
+			t.Fatalf("key%d not flushed", i)
+		}
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after a while.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWriteBufferFlushOnDelay(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	db := openTestStore(t, WithWriteBuffer(0, time.Second), WithClock(clock))
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+	clock.Advance(time.Second / 2)
+	if inFile(t, db, "key") {
+		t.Fatal("flushed early")
+	}
+	clock.Advance(time.Second / 2)
+	waitFor(t, func() bool { return inFile(t, db, "key") })
+}
+
+func TestWriteBufferErrors(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	errs := make(chan error, 1)
+	db := openTestStore(t, WithWriteBuffer(0, time.Second), WithClock(clock),
+		WithFlushErrorCallback(func(err error) { errs <- err }))
+	if err := db.Put("key", "value"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return clock.Waiters() == 1 })
+	db.setReadOnly()
+	clock.Advance(time.Second)
+	select {
+	case err := <-errs:
+		if err != ErrReadOnly {
+			t.Fatalf("got %v, expected ErrReadOnly", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("callback did not fire")
+	}
+	if err := db.Flush(); err != ErrReadOnly {
+		t.Fatalf("got %v, expected ErrReadOnly", err)
+	}
+	// reported once only
+	if err := db.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// failed writes are discarded
+	if err := db.Get("key", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+}
+
+func TestWriteBufferClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path, "test", WithWriteBuffer(1000, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := db.Put(fmt.Sprintf("key%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("late", 1); err != ErrClosed {
+		t.Fatalf("got %v, expected ErrClosed", err)
+	}
+	db, err = Open(path, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i := 0; i < 100; i++ {
+		var val int
+		if err := db.Get(fmt.Sprintf("key%d", i), &val); err != nil || val != i {
+			t.Fatalf("key%d: got %d, %v", i, val, err)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package bboltkv
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrDifferentDatabase is returned when a transaction is to span stores
+// that are not in the same database file, see Update and MoveKey.
+var ErrDifferentDatabase = errors.New("bboltkv: stores are not in the same database file")
+
+// WriteTx is a read-write transaction obtained from Update. Besides the
+// bucket of the store it was obtained from, it can write to the buckets of
+// other stores sharing the database file, see OpenShared, all of which
+// commit together, or not at all.
+type WriteTx struct {
+	root    *wtx
+	joined  map[*Store]*wtx
+	entered []*Store
+	tracer  Tracer              // for the spans of the operations within, see TxTracer
+	reads   map[string]readHash // the values read, see UpdateValidated
+}
+
+// Update runs fn in a single read-write transaction, which commits once fn
+// returns nil. If fn returns an error, or the commit fails, nothing fn wrote
+// is kept, in any of the buckets it wrote to. The transaction must not be
+// used once fn returns, nor be used by other goroutines than the one
+// running fn, and fn must not call methods of the stores it writes to.
+//
+//	err := store.Update(func(tx *bboltkv.WriteTx) error {
+//	    if err := tx.InBucket("pending").Delete("job:1"); err != nil {
+//	        return err
+//	    }
+//	    return tx.InBucket("done").Put("job:1", job)
+//	})
+func (s *Store) Update(fn func(tx *WriteTx) error) (err error) {
+	var t Tracer
+	if s.opts.tracer != nil {
+		var end func(err *error)
+		t, end = s.startTx("Update")
+		defer end(&err)
+	}
+	return s.update(s.named("Update", "", func(w *wtx) error {
+		return s.runWriteTx(w, t, fn)
+	}))
+}
+
+// runWriteTx runs fn in w, as Update does, tracing its operations with t.
+func (s *Store) runWriteTx(w *wtx, t Tracer, fn func(tx *WriteTx) error) error {
+	tx := &WriteTx{root: w, joined: map[*Store]*wtx{s: w}, tracer: t}
+	defer tx.exit()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	for st, jw := range tx.joined {
+		if st != s {
+			w.joined = append(w.joined, jw)
+			if err := jw.saveQuota(); err != nil {
+				return err
+			}
+			jw.commit()
+		}
+	}
+	return nil
+}
+
+// InBucket returns the part of the transaction that writes to the bucket
+// of the given name, through a store open on it: the store tx was obtained
+// from, or else one sharing its database file, with OpenShared. Such a
+// store's options apply to the entries it reads and writes, as if its own
+// methods were called, but it cannot use WithWriteBuffer. If there is no
+// such store, or it cannot take part, the methods of the BucketTx return
+// the error.
+func (tx *WriteTx) InBucket(name string) *BucketTx {
+	s := tx.root.s
+	if string(s.bucketName) == name {
+		return &BucketTx{tx: tx, w: tx.root}
+	}
+	if s.shared == nil {
+		return &BucketTx{tx: tx, err: fmt.Errorf("%w: no store on bucket %q shares the file", ErrNoBucket, name)}
+	}
+	shared.Lock()
+	var st *Store
+	if stores := s.shared.stores[name]; len(stores) > 0 {
+		st = stores[0]
+	}
+	shared.Unlock()
+	if st == nil {
+		return &BucketTx{tx: tx, err: fmt.Errorf("%w: no store on bucket %q shares the file", ErrNoBucket, name)}
+	}
+	return tx.in(st)
+}
+
+// in returns the part of the transaction that writes to the bucket of st.
+func (tx *WriteTx) in(st *Store) *BucketTx {
+	if w := tx.joined[st]; w != nil {
+		return &BucketTx{tx: tx, w: w}
+	}
+	if st.db != tx.root.s.db {
+		return &BucketTx{tx: tx, err: ErrDifferentDatabase}
+	}
+	if st.wbuf != nil {
+		return &BucketTx{tx: tx, err: fmt.Errorf("bboltkv: bucket %q has a write buffer, and cannot join a transaction", st.bucketName)}
+	}
+	if err := st.enter(); err != nil {
+		return &BucketTx{tx: tx, err: err}
+	}
+	tx.entered = append(tx.entered, st)
+	if atomic.LoadInt32(&st.readOnly) != 0 {
+		return &BucketTx{tx: tx, err: ErrReadOnly}
+	}
+	b, err := st.writeBucket(tx.root.tx)
+	if err != nil {
+		return &BucketTx{tx: tx, err: err}
+	}
+	w := &wtx{s: st, tx: tx.root.tx, b: b}
+	tx.joined[st] = w
+	return &BucketTx{tx: tx, w: w}
+}
+
+// exit lets the stores that joined the transaction close again.
+func (tx *WriteTx) exit() {
+	for _, st := range tx.entered {
+		st.gate.exit()
+	}
+}
+
+// BucketTx reads and writes the entries of one bucket within a WriteTx,
+// see InBucket. Its methods work like the store methods of the same names.
+type BucketTx struct {
+	tx  *WriteTx
+	w   *wtx
+	err error
+}
+
+// startSpan starts the span of op on key within the transaction.
+func (b *BucketTx) startSpan(op, key string) func(err *error) {
+	return b.tx.root.s.startSpan(b.tx.tracer, op, key)
+}
+
+// Get decodes the value stored under key into value, which must be a
+// pointer or nil, or returns ErrNotFound.
+func (b *BucketTx) Get(key string, value interface{}) (err error) {
+	if b.tx.tracer != nil {
+		defer b.startSpan("Get", key)(&err)
+	}
+	if b.err != nil {
+		return b.err
+	}
+	s := b.w.s
+	if s.opts.schemaGets && value != nil {
+		if err := s.checkSchema(key, value); err != nil {
+			return err
+		}
+	}
+	sealed := s.sealKey(key)
+	raw := b.w.get(sealed)
+	if b.tx.reads != nil && b.w == b.tx.root {
+		b.tx.read(sealed, raw)
+	}
+	if raw == nil {
+		return ErrNotFound
+	} else if value == nil {
+		return nil
+	}
+	return s.decode(raw, value)
+}
+
+// Put stores value under key, replacing any value stored there.
+func (b *BucketTx) Put(key string, value interface{}) (err error) {
+	if b.tx.tracer != nil {
+		defer b.startSpan("Put", key)(&err)
+	}
+	if b.err != nil {
+		return b.err
+	}
+	raw, err := b.w.s.encodeForPut(key, value)
+	if err != nil {
+		return err
+	}
+	return b.w.put(b.w.s.sealKey(key), raw)
+}
+
+// PutWithTTL stores value under key like Put, and makes it expire after
+// ttl has passed, with the store's jitter, see WithTTLJitter.
+func (b *BucketTx) PutWithTTL(key string, value interface{}, ttl time.Duration) (err error) {
+	if b.tx.tracer != nil {
+		defer b.startSpan("PutWithTTL", key)(&err)
+	}
+	if b.err != nil {
+		return b.err
+	}
+	if ttl <= 0 {
+		return ErrBadValue
+	}
+	s := b.w.s
+	raw, err := s.encodeForPut(key, value)
+	if err != nil {
+		return err
+	}
+	key = s.sealKey(key)
+	if err := b.w.put(key, raw); err != nil {
+		return err
+	}
+	return b.w.setExpiry(key, s.now().Add(jittered(ttl, s.opts.ttlJitter)))
+}
+
+// Delete deletes the entry with the given key, or returns ErrNotFound.
+// Protected keys are refused with ErrProtected.
+func (b *BucketTx) Delete(key string) (err error) {
+	if b.tx.tracer != nil {
+		defer b.startSpan("Delete", key)(&err)
+	}
+	if b.err != nil {
+		return b.err
+	}
+	return b.w.s.deleteOne(b.w, b.w.s.sealKey(key))
+}
+
+// MoveKey moves the entry with the given key from the bucket of src to the
+// bucket of dst, in a single transaction: either the entry is in dst
+// afterwards and gone from src, or nothing changed. The two stores must
+// share the database file, see OpenShared. Otherwise MoveKey returns
+// ErrDifferentDatabase.
+//
+// The value is written to dst as with Put, passing dst's validators and
+// transforms, so the stores may differ in their options; it replaces any
+// value dst held under key. Its expiry, tags and timestamps stay behind, and
+// are deleted with the entry. Lists, see Append, cannot be moved. If src
+// has no such key, MoveKey returns ErrNotFound; protected keys are refused
+// like with Delete.
+//
+//	err := bboltkv.MoveKey(pending, done, "job:1")
+func MoveKey(src, dst *Store, key string) error {
+	if src.db != dst.db {
+		return ErrDifferentDatabase
+	}
+	return src.Update(func(tx *WriteTx) error {
+		from, to := tx.in(src), tx.in(dst)
+		if to.err != nil {
+			return to.err
+		}
+		v := from.w.get(src.sealKey(key))
+		if v == nil {
+			return ErrNotFound
+		} else if v[0] == tagList {
+			return errList
+		}
+		e, _, err := DecodeEnvelope(v)
+		if err != nil {
+			return err
+		}
+		plain, err := src.pipeline.untransform(v)
+		if err != nil {
+			return err
+		}
+		plain = append([]byte(nil), plain...)
+		if err := from.Delete(key); err != nil {
+			return err
+		}
+		raw, err := dst.pipeline.transformNamed(plain, e.TypeName)
+		if err != nil {
+			return err
+		}
+		if err := dst.validateEncoded(key, raw); err != nil {
+			return err
+		}
+		return to.w.put(dst.sealKey(key), raw)
+	})
+}
@@ -0,0 +1,237 @@
+package bboltkv
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/unknownnf/bboltkv/testutil"
+)
+
+// openSharedPair opens two stores on buckets "pending" and "done" of the
+// same file.
+func openSharedPair(t *testing.T, pendingOpts, doneOpts []Option) (pending, done *Store) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	pending, err := OpenShared(path, "pending", pendingOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pending.Close() })
+	done, err = OpenShared(path, "done", doneOpts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { done.Close() })
+	return pending, done
+}
+
+func TestUpdateInBucket(t *testing.T) {
+	pending, done := openSharedPair(t, nil, nil)
+	if err := pending.Put("job:1", "resize"); err != nil {
+		t.Fatal(err)
+	}
+	err := pending.Update(func(tx *WriteTx) error {
+		var job string
+		if err := tx.InBucket("pending").Get("job:1", &job); err != nil {
+			return err
+		}
+		if err := tx.InBucket("pending").Delete("job:1"); err != nil {
+			return err
+		}
+		return tx.InBucket("done").Put("job:1", job+" done")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var job string
+	if err := done.Get("job:1", &job); err != nil || job != "resize done" {
+		t.Fatalf("got %q, %v", job, err)
+	}
+	if err := pending.Get("job:1", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+
+	// a failure between the two writes keeps neither
+	if err := pending.Put("job:2", "crop"); err != nil {
+		t.Fatal(err)
+	}
+	crash := errors.New("crash")
+	err = pending.Update(func(tx *WriteTx) error {
+		if err := tx.InBucket("pending").Delete("job:2"); err != nil {
+			return err
+		}
+		if err := tx.InBucket("done").Put("job:2", "crop done"); err != nil {
+			return err
+		}
+		return crash
+	})
+	if err != crash {
+		t.Fatalf("got %v, expected the error of fn", err)
+	}
+	if err := pending.Get("job:2", nil); err != nil {
+		t.Fatalf("deletion from pending was kept: %v", err)
+	}
+	if err := done.Get("job:2", nil); err != ErrNotFound {
+		t.Fatalf("put into done was kept: %v", err)
+	}
+
+	if err := pending.Update(func(tx *WriteTx) error {
+		return tx.InBucket("archive").Put("job:1", "x")
+	}); !errors.Is(err, ErrNoBucket) {
+		t.Fatalf("got %v, expected ErrNoBucket", err)
+	}
+}
+
+func TestBucketTxPutWithTTL(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Now())
+	pending, done := openSharedPair(t, []Option{WithClock(clock)}, []Option{WithClock(clock)})
+	err := pending.Update(func(tx *WriteTx) error {
+		for i := 0; i < 3; i++ {
+			if err := tx.InBucket("pending").PutWithTTL(keyN(i), i, time.Minute); err != nil {
+				return err
+			}
+		}
+		// overwritten in the same transaction, with a new TTL and without
+		if err := tx.InBucket("pending").PutWithTTL(keyN(0), 10, 2*time.Minute); err != nil {
+			return err
+		}
+		if err := tx.InBucket("pending").Put(keyN(1), 11); err != nil {
+			return err
+		}
+		return tx.InBucket("done").PutWithTTL("job:1", "done", time.Minute)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := expiring(t, pending), []string{keyN(2), keyN(0)}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got index %v, expected %v", got, want)
+	}
+	if ttl, err := done.TTL("job:1"); err != nil || ttl != time.Minute {
+		t.Fatalf("got %v, %v, expected 1m", ttl, err)
+	}
+	clock.Advance(time.Minute)
+	checkValues(t, pending, map[string]int{keyN(0): 10, keyN(1): 11})
+	if err := done.Get("job:1", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+
+	if err := pending.Update(func(tx *WriteTx) error {
+		return tx.InBucket("pending").PutWithTTL("key", 1, 0)
+	}); err != ErrBadValue {
+		t.Fatalf("got %v, expected ErrBadValue", err)
+	}
+}
+
+func TestMoveKey(t *testing.T) {
+	pending, done := openSharedPair(t, []Option{WithCompression()}, []Option{WithEncryption(testKey)})
+	if err := pending.Put("job:1", "resize"); err != nil {
+		t.Fatal(err)
+	}
+	if err := MoveKey(pending, done, "job:1"); err != nil {
+		t.Fatal(err)
+	}
+	var job string
+	if err := done.Get("job:1", &job); err != nil || job != "resize" {
+		t.Fatalf("got %q, %v", job, err)
+	}
+	if err := pending.Get("job:1", nil); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+	if err := MoveKey(pending, done, "job:1"); err != ErrNotFound {
+		t.Fatalf("got %v, expected ErrNotFound", err)
+	}
+
+	// a failed commit rolls back both buckets
+	if err := pending.Put("job:2", "crop"); err != nil {
+		t.Fatal(err)
+	}
+	failCommits(pending, -1, errFsync)
+	if err := MoveKey(pending, done, "job:2"); err == nil {
+		t.Fatal("move committed")
+	}
+	pending.txHook = nil
+	if err := pending.Get("job:2", nil); err != nil {
+		t.Fatalf("job:2 gone from pending: %v", err)
+	}
+	if err := done.Get("job:2", nil); err != ErrNotFound {
+		t.Fatalf("job:2 in done: %v", err)
+	}
+
+	// protected keys stay
+	if err := pending.Protect("job:2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := MoveKey(pending, done, "job:2"); err != ErrProtected {
+		t.Fatalf("got %v, expected ErrProtected", err)
+	}
+	if err := done.Get("job:2", nil); err != ErrNotFound {
+		t.Fatalf("job:2 in done: %v", err)
+	}
+}
+
+func TestMoveKeyDifferentDatabase(t *testing.T) {
+	a := openTestStore(t)
+	b := openTestStore(t)
+	if err := a.Put("k", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := MoveKey(a, b, "k"); err != ErrDifferentDatabase {
+		t.Fatalf("got %v, expected ErrDifferentDatabase", err)
+	}
+	err := a.Update(func(tx *WriteTx) error {
+		if err := tx.InBucket("test").Delete("k"); err != nil {
+			return err
+		}
+		return tx.in(b).Put("k", 1)
+	})
+	if err != ErrDifferentDatabase {
+		t.Fatalf("got %v, expected ErrDifferentDatabase", err)
+	}
+	if err := a.Get("k", nil); err != nil {
+		t.Fatalf("k was deleted: %v", err)
+	}
+}
+
+func TestMoveKeyViews(t *testing.T) {
+	pending, done := openSharedPair(t, nil, nil)
+	if err := pending.CreateView("totals", "order:", viewAgg(pending, false), viewRoute(pending)); err != nil {
+		t.Fatal(err)
+	}
+	if err := done.CreateView("totals", "", viewAgg(done, false), viewRoute(done)); err != nil {
+		t.Fatal(err)
+	}
+	for i, o := range []order{{"alice", 10}, {"alice", 5}, {"bob", 7}} {
+		if err := pending.Put(fmt.Sprint("order:", i), o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := MoveKey(pending, done, fmt.Sprint("order:", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := viewContents(t, pending, "totals"); len(got) != 1 || got["bob"] != 7 {
+		t.Fatalf("pending totals %v", got)
+	}
+	if got := viewContents(t, done, "totals"); len(got) != 1 || got["alice"] != 15 {
+		t.Fatalf("done totals %v", got)
+	}
+
+	// a view of dst refusing the value rolls back the move
+	if err := pending.Put("draft:1", order{"carol", -1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := MoveKey(pending, done, "draft:1"); err == nil {
+		t.Fatal("done's view accepted a negative total")
+	}
+	if err := pending.Get("draft:1", nil); err != nil {
+		t.Fatalf("draft:1 gone from pending: %v", err)
+	}
+	if got := viewContents(t, done, "totals"); len(got) != 1 || got["alice"] != 15 {
+		t.Fatalf("done totals %v", got)
+	}
+}